@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"git3/internal/admin"
+	"git3/internal/git"
+)
+
+// commands are the CLI verbs that drive a running server's admin API.
+var commands = map[string]func(c *adminClient, args []string) error{
+	"branch":  branchCommand,
+	"move":    moveCommand,
+	"promote": promoteCommand,
+	"prune":   pruneCommand,
+	"status":  statusCommand,
+	"sync":    syncCommand,
+}
+
+// runCommand runs a CLI verb and returns the process exit code.
+func runCommand(verb string, args []string) int {
+	cmd, ok := commands[verb]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "git3: unknown command %q\n", verb)
+		return 2
+	}
+
+	fs := flag.NewFlagSet(verb, flag.ExitOnError)
+	c := &adminClient{}
+	fs.StringVar(&c.server, "server", envOr("GIT3_URL", "http://localhost:80"), "URL of the running git3 server")
+	fs.StringVar(&c.token, "admin-token", envOr("ADMIN_TOKEN", ""), "admin API token")
+	fs.Parse(args)
+
+	if err := cmd(c, fs.Args()); err != nil {
+		fmt.Fprintf(os.Stderr, "git3 %s: %v\n", verb, err)
+		return 1
+	}
+	return 0
+}
+
+// branchCommand prints the synced branch, or switches to args[0].
+func branchCommand(c *adminClient, args []string) error {
+	var resp admin.BranchRequest
+	var err error
+	switch len(args) {
+	case 0:
+		err = c.do("GET", "branch", nil, &resp)
+	case 1:
+		err = c.do("POST", "branch", admin.BranchRequest{Branch: args[0]}, &resp)
+	default:
+		return fmt.Errorf("usage: git3 branch [-server URL] [name]")
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println(resp.Branch)
+	return nil
+}
+
+// statusCommand prints the server's sync state and its recent sync events.
+func statusCommand(c *adminClient, args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("usage: git3 status [-server URL]")
+	}
+	var st admin.StatusResponse
+	if err := c.do("GET", "status", nil, &st); err != nil {
+		return err
+	}
+
+	if st.ReplicaOf != "" {
+		fmt.Printf("REPLICA:      of %s, read-only until promoted\n", st.ReplicaOf)
+	}
+	fmt.Printf("last commit:  %s %s (%d files)\n", formatTime(st.LastCommitTime), st.LastCommitHash, st.LastCommitFiles)
+	fmt.Printf("last push:    %s\n", formatTime(st.LastPushTime))
+	if st.PullRequest != 0 {
+		fmt.Printf("pull request: #%d %s\n", st.PullRequest, st.PullRequestURL)
+	}
+	if st.Ahead > 0 {
+		fmt.Printf("unpushed:     %d commits (push pending: %v)\n", st.Ahead, st.PendingPush)
+	}
+	if st.LastPushError != "" {
+		fmt.Printf("push error:   %s\n", st.LastPushError)
+	}
+	fmt.Printf("last pull:    %s\n", formatTime(st.LastPullTime))
+	if st.Offline {
+		fmt.Printf("OFFLINE:      remote unreachable since %s\n", formatTime(st.OfflineSince))
+	}
+	if r := st.Remote; r != nil {
+		switch {
+		case !r.Reachable:
+			fmt.Printf("REMOTE:       unreachable at %s: %s\n", formatTime(r.CheckedAt), r.Error)
+		case !r.AuthOK:
+			fmt.Printf("REMOTE:       refused at %s (%s): %s\n", formatTime(r.CheckedAt), r.ErrorClass, r.Error)
+		default:
+			fmt.Printf("remote:       reachable at %s\n", formatTime(r.CheckedAt))
+		}
+	}
+	for _, f := range st.SecretFindings {
+		fmt.Printf("SECRET:       %s line %d (%s), not committed\n", f.Path, f.Line, f.Rule)
+	}
+	if st.CorruptError != "" {
+		fmt.Printf("CORRUPT:      %s\n", st.CorruptError)
+	}
+	if st.Recoveries > 0 || st.LastRecoveryError != "" {
+		fmt.Printf("recoveries:   %d, last %s\n", st.Recoveries, formatTime(st.LastRecoveryTime))
+	}
+	if st.LastRecoveryError != "" {
+		fmt.Printf("recovery error: %s\n", st.LastRecoveryError)
+	}
+	fmt.Printf("pending:      %v (syncing: %v)\n", st.PendingTrigger, st.Syncing)
+	fmt.Printf("vault:        %d objects, %d bytes\n", st.Objects, st.Bytes)
+	fmt.Printf("repository:   %d bytes\n", st.RepoBytes)
+	if st.OverSizeLimit {
+		fmt.Printf("OVER SIZE LIMIT: the repository has grown past the configured limit\n")
+	}
+
+	if len(st.Events) > 0 {
+		fmt.Println()
+	}
+	for _, e := range st.Events {
+		line := fmt.Sprintf("%s  %-6s %-16s %8s", e.Time.Local().Format(time.DateTime), e.Op, e.Result, e.Duration.Round(time.Millisecond))
+		if e.Hash != "" {
+			line += "  " + e.Hash[:min(len(e.Hash), 7)]
+		}
+		if e.Files > 0 {
+			line += fmt.Sprintf("  %d files", e.Files)
+		}
+		if e.Error != "" {
+			line += "  " + e.Error
+		}
+		fmt.Println(line)
+	}
+	return nil
+}
+
+// syncCommand commits and pushes pending changes now.
+func syncCommand(c *adminClient, args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("usage: git3 sync [-server URL]")
+	}
+	var resp admin.SyncResponse
+	if err := c.do("POST", "sync", nil, &resp); err != nil {
+		return err
+	}
+	if resp.Commit == "" {
+		fmt.Println("nothing to commit")
+	} else {
+		fmt.Printf("committed %s (%d files)\n", resp.Commit[:min(len(resp.Commit), 7)], resp.Files)
+	}
+	if resp.Pushed {
+		fmt.Println("pushed")
+	}
+	return nil
+}
+
+// moveCommand renames an object, or every object under a prefix when both
+// arguments end in "/", on the server.
+func moveCommand(c *adminClient, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: git3 move [-server URL] <from> <to>, prefixes ending in /")
+	}
+	from, to := args[0], args[1]
+	var resp admin.MoveResponse
+	err := c.do("POST", "move", admin.MoveRequest{From: from, To: to, Prefix: strings.HasSuffix(from, "/") && strings.HasSuffix(to, "/")}, &resp)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("moved %d objects from %s to %s\n", len(resp.Moved), from, to)
+	return nil
+}
+
+// promoteCommand turns a replica into a primary that accepts writes.
+func promoteCommand(c *adminClient, args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("usage: git3 promote [-server URL]")
+	}
+	var resp admin.BranchRequest
+	if err := c.do("POST", "promote", nil, &resp); err != nil {
+		return err
+	}
+	fmt.Printf("promoted; writes are committed and pushed to %s\n", resp.Branch)
+	return nil
+}
+
+// pruneCommand squashes the history before a cutoff, given as a date, an
+// RFC 3339 time or an age like 90d or 2160h, and force-pushes the result.
+func pruneCommand(c *adminClient, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: git3 prune [-server URL] <date | age, e.g. 2026-01-01 or 90d>")
+	}
+	before, err := parseCutoff(args[0], time.Now())
+	if err != nil {
+		return err
+	}
+	var resp git.PruneResult
+	if err := c.do("POST", "prune", admin.PruneRequest{Before: before}, &resp); err != nil {
+		return err
+	}
+	if resp.Squashed == 0 {
+		fmt.Printf("nothing before %s to prune\n", formatTime(before))
+		return nil
+	}
+	fmt.Printf("squashed %d commits into %s, kept %d; pushed %s\n", resp.Squashed, resp.Baseline[:7], resp.Kept, resp.Head[:7])
+	fmt.Printf("the old history is in branch %s on the server; other clones must re-clone\n", resp.Backup)
+	return nil
+}
+
+// parseCutoff parses a prune cutoff: a date or RFC 3339 time, or an age
+// in days ("90d") or as a Go duration, counted back from now.
+func parseCutoff(s string, now time.Time) (time.Time, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n >= 0 {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation(time.DateOnly, s, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("cutoff %q: want a date (2006-01-02), an RFC 3339 time or an age (90d, 2160h)", s)
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.Local().Format(time.DateTime)
+}
+
+type adminClient struct {
+	server string
+	token  string
+}
+
+// do sends a JSON request to an admin endpoint and decodes the reply into out.
+func (c *adminClient) do(method, endpoint string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(c.server, "/")+admin.Prefix+endpoint, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var e struct{ Error string }
+		json.NewDecoder(resp.Body).Decode(&e)
+		return fmt.Errorf("%s: %s", resp.Status, e.Error)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
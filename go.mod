@@ -2,7 +2,10 @@ module git3
 
 go 1.26.0
 
-require github.com/go-git/go-git/v5 v5.16.5
+require (
+	github.com/go-git/go-git/v5 v5.16.5
+	github.com/sergi/go-diff v1.3.2-0.20230802210424-5b0b94c5c0d3
+)
 
 require (
 	dario.cat/mergo v1.0.0 // indirect
@@ -17,7 +20,6 @@ require (
 	github.com/jbenet/go-context v0.0.0-20150711004518-d14ea06fba99 // indirect
 	github.com/kevinburke/ssh_config v1.2.0 // indirect
 	github.com/pjbgf/sha1cd v0.3.2 // indirect
-	github.com/sergi/go-diff v1.3.2-0.20230802210424-5b0b94c5c0d3 // indirect
 	github.com/skeema/knownhosts v1.3.1 // indirect
 	github.com/xanzy/ssh-agent v0.3.3 // indirect
 	golang.org/x/crypto v0.45.0 // indirect
@@ -0,0 +1,212 @@
+// Package admin serves the operator API under /-/, used by the git3 CLI
+// verbs to control a running server.
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"git3/internal/git"
+)
+
+// Prefix is the URL path prefix the admin API is mounted under.
+const Prefix = "/-/"
+
+// Syncer is the part of the git syncer the admin API drives.
+type Syncer interface {
+	Branch() string
+	SwitchBranch(branch string) error
+	PullBranch() string
+	RequestPull()
+	TryStatus(timeout time.Duration) (git.Status, bool)
+	Events() []git.Event
+	Log(opts git.LogOptions) ([]git.LogEntry, error)
+	Diff(key, from, to string) (*git.FileDiff, error)
+	Sync() git.SyncResult
+	Prune(before time.Time) (*git.PruneResult, error)
+	Promote() error
+	RepoSize() (size, limit int64)
+}
+
+// Config configures the admin API.
+type Config struct {
+	Token       string // bearer token for operator endpoints; empty disables them
+	StatusToken string // bearer token for GET /-/status; empty leaves it open
+	HookSecret  string // enables POST /-/hooks/push for git host webhooks
+	S3Auth      bool   // S3 requests need credentials, so object keys aren't served without a token either
+	Syncer      Syncer
+	Vault       Vault        // optional; adds object counts to the status and enables moves
+	Metrics     http.Handler // optional; served at /-/metrics
+	Events      http.Handler // optional; the event stream served at /-/events
+}
+
+// Handler serves the admin API. Operator requests need the admin token as
+// a bearer token; webhooks from the git host are verified by signature.
+type Handler struct {
+	token       string
+	statusToken string
+	hookSecret  string
+	s3Auth      bool
+	syncer      Syncer
+	vault       Vault
+	metrics     http.Handler
+	events      http.Handler
+
+	mu         sync.Mutex
+	lastStatus git.Status // served while a sync holds the syncer
+}
+
+// NewHandler creates an admin API handler.
+func NewHandler(cfg Config) *Handler {
+	return &Handler{
+		token:       cfg.Token,
+		statusToken: cfg.StatusToken,
+		hookSecret:  cfg.HookSecret,
+		s3Auth:      cfg.S3Auth,
+		syncer:      cfg.Syncer,
+		vault:       cfg.Vault,
+		metrics:     cfg.Metrics,
+		events:      cfg.Events,
+	}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	endpoint := strings.TrimPrefix(r.URL.Path, Prefix)
+	switch endpoint {
+	case "hooks/push":
+		h.pushHook(w, r)
+		return
+	case "status":
+		h.status(w, r)
+		return
+	case "stats":
+		h.bucketStats(w, r)
+		return
+	case "metrics":
+		h.serveMetrics(w, r)
+		return
+	case "log":
+		h.commitLog(w, r)
+		return
+	case "events":
+		h.serveEvents(w, r)
+		return
+	}
+
+	if h.token == "" {
+		jsonError(w, http.StatusForbidden, "admin API disabled; set ADMIN_TOKEN")
+		return
+	}
+	if !validToken(r, h.token) {
+		jsonError(w, http.StatusUnauthorized, "invalid admin token")
+		return
+	}
+
+	if key, ok := strings.CutPrefix(endpoint, "diff/"); ok {
+		h.diff(w, r, key)
+		return
+	}
+	switch endpoint {
+	case "branch":
+		h.branch(w, r)
+	case "sync":
+		h.sync(w, r)
+	case "prune":
+		h.prune(w, r)
+	case "promote":
+		h.promote(w, r)
+	case "move":
+		h.move(w, r)
+	default:
+		jsonError(w, http.StatusNotFound, "unknown admin endpoint")
+	}
+}
+
+// BranchRequest is the body of POST /-/branch.
+type BranchRequest struct {
+	Branch string `json:"branch"`
+}
+
+// branch reports the synced branch (GET) or switches to another (POST).
+func (h *Handler) branch(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+	case "POST":
+		var req BranchRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Branch == "" {
+			jsonError(w, http.StatusBadRequest, `body must be {"branch": "<name>"}`)
+			return
+		}
+		if err := h.syncer.SwitchBranch(req.Branch); err != nil {
+			jsonError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, BranchRequest{Branch: h.syncer.Branch()})
+}
+
+// SyncResponse is the reply to POST /-/sync.
+type SyncResponse struct {
+	Commit string `json:"commit,omitempty"` // empty when there was nothing to commit
+	Files  int    `json:"files"`
+	Pushed bool   `json:"pushed"`
+	Error  string `json:"error,omitempty"`
+}
+
+// sync commits and pushes pending changes right away, including those
+// written with X-Git3-Defer-Sync, and replies once it is done.
+func (h *Handler) sync(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	result := h.syncer.Sync()
+	resp := SyncResponse{Commit: result.Commit, Files: result.Files, Pushed: result.Pushed}
+	status := http.StatusOK
+	if result.Err != nil {
+		resp.Error = result.Err.Error()
+		status = http.StatusInternalServerError
+	}
+	writeJSON(w, status, resp)
+}
+
+// promote turns a replica into a primary: writes are accepted, committed
+// and pushed from then on. It answers 409 if the server isn't a replica.
+func (h *Handler) promote(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err := h.syncer.Promote(); errors.Is(err, git.ErrNotReplica) {
+		jsonError(w, http.StatusConflict, "not a replica")
+		return
+	} else if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, BranchRequest{Branch: h.syncer.Branch()})
+}
+
+// validToken reports whether r carries token as its bearer token.
+func validToken(r *http.Request, token string) bool {
+	got, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func jsonError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
@@ -0,0 +1,177 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"git3/internal/git"
+)
+
+type fakeSyncer struct {
+	branch  string
+	pulls   int
+	status  git.Status
+	events  []git.Event
+	logOpts git.LogOptions
+	busy    bool // a sync holds the syncer
+	syncs   int
+	pruned  time.Time
+	replica bool
+}
+
+func (f *fakeSyncer) Branch() string     { return f.branch }
+func (f *fakeSyncer) PullBranch() string { return f.branch }
+func (f *fakeSyncer) RequestPull()       { f.pulls++ }
+
+func (f *fakeSyncer) TryStatus(timeout time.Duration) (git.Status, bool) {
+	if f.busy {
+		return git.Status{}, false
+	}
+	return f.status, true
+}
+
+func (f *fakeSyncer) Events() []git.Event { return f.events }
+
+func (f *fakeSyncer) RepoSize() (size, limit int64) { return 4096, 2048 }
+
+func (f *fakeSyncer) Diff(key, from, to string) (*git.FileDiff, error) {
+	switch {
+	case from == "nope":
+		return nil, git.ErrUnknownRevision
+	case key == "missing.md":
+		return nil, os.ErrNotExist
+	}
+	return &git.FileDiff{Key: key, From: from, To: to, Status: git.DiffModified, Hunks: []git.Hunk{
+		{FromLine: 1, FromCount: 1, ToLine: 1, ToCount: 1, Lines: []string{"-a\n", "+b\n"}},
+	}}, nil
+}
+
+func (f *fakeSyncer) Log(opts git.LogOptions) ([]git.LogEntry, error) {
+	f.logOpts = opts
+	if opts.Since == "zzz" {
+		return nil, git.ErrInvalidHash
+	}
+	return []git.LogEntry{{Hash: "abc", Message: "sync"}}, nil
+}
+
+func (f *fakeSyncer) Sync() git.SyncResult {
+	f.syncs++
+	return git.SyncResult{Commit: "abc", Files: 2, Pushed: true}
+}
+
+func (f *fakeSyncer) Prune(before time.Time) (*git.PruneResult, error) {
+	if f.syncs == 0 {
+		return nil, fmt.Errorf("%w: sync first", git.ErrUnpushed)
+	}
+	f.pruned = before
+	return &git.PruneResult{Head: "def", Baseline: "abc", Squashed: 10, Kept: 2, Backup: "git3-backup/main-prune-x"}, nil
+}
+
+func (f *fakeSyncer) Promote() error {
+	if !f.replica {
+		return git.ErrNotReplica
+	}
+	f.replica = false
+	return nil
+}
+
+func (f *fakeSyncer) SwitchBranch(branch string) error {
+	if branch == "broken" {
+		return errors.New("checkout failed")
+	}
+	f.branch = branch
+	return nil
+}
+
+func do(h http.Handler, method, path, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestAdminRequiresToken(t *testing.T) {
+	syncer := &fakeSyncer{branch: "main"}
+
+	if w := do(NewHandler(Config{Syncer: syncer}), "GET", "/-/branch", "", ""); w.Code != http.StatusForbidden {
+		t.Fatalf("disabled admin API got %d, want 403", w.Code)
+	}
+	h := NewHandler(Config{Token: "secret", Syncer: syncer})
+	for _, token := range []string{"", "wrong"} {
+		if w := do(h, "POST", "/-/branch", token, `{"branch":"x"}`); w.Code != http.StatusUnauthorized {
+			t.Fatalf("token %q got %d, want 401", token, w.Code)
+		}
+	}
+	if syncer.branch != "main" {
+		t.Fatal("unauthorized request switched the branch")
+	}
+}
+
+func TestAdminSwitchBranch(t *testing.T) {
+	syncer := &fakeSyncer{branch: "main"}
+	h := NewHandler(Config{Token: "secret", Syncer: syncer})
+
+	w := do(h, "POST", "/-/branch", "secret", `{"branch":"devices/ipad"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("switch got %d: %s", w.Code, w.Body.String())
+	}
+	var resp BranchRequest
+	json.NewDecoder(w.Body).Decode(&resp)
+	if resp.Branch != "devices/ipad" || syncer.branch != "devices/ipad" {
+		t.Fatalf("branch = %q (syncer %q), want devices/ipad", resp.Branch, syncer.branch)
+	}
+
+	if w := do(h, "POST", "/-/branch", "secret", `{"branch":"broken"}`); w.Code != http.StatusInternalServerError {
+		t.Fatalf("failed switch got %d, want 500", w.Code)
+	}
+	if w := do(h, "POST", "/-/branch", "secret", `{}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("missing branch got %d, want 400", w.Code)
+	}
+	if w := do(h, "GET", "/-/branch", "secret", ""); !strings.Contains(w.Body.String(), "devices/ipad") {
+		t.Fatalf("GET branch = %s", w.Body.String())
+	}
+}
+
+func TestAdminSync(t *testing.T) {
+	syncer := &fakeSyncer{branch: "main"}
+	h := NewHandler(Config{Token: "secret", Syncer: syncer})
+
+	if w := do(h, "GET", "/-/sync", "secret", ""); w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("GET /-/sync got %d, want 405", w.Code)
+	}
+	w := do(h, "POST", "/-/sync", "secret", "")
+	var resp SyncResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if w.Code != http.StatusOK || resp != (SyncResponse{Commit: "abc", Files: 2, Pushed: true}) || syncer.syncs != 1 {
+		t.Fatalf("POST /-/sync got %d %+v after %d syncs", w.Code, resp, syncer.syncs)
+	}
+}
+
+func TestAdminPromote(t *testing.T) {
+	syncer := &fakeSyncer{branch: "main", replica: true}
+	h := NewHandler(Config{Token: "secret", Syncer: syncer})
+
+	if w := do(h, "GET", "/-/promote", "secret", ""); w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("GET /-/promote got %d, want 405", w.Code)
+	}
+	if w := do(h, "POST", "/-/promote", "", ""); w.Code != http.StatusUnauthorized || !syncer.replica {
+		t.Fatalf("promote without a token got %d", w.Code)
+	}
+	w := do(h, "POST", "/-/promote", "secret", "")
+	if w.Code != http.StatusOK || syncer.replica || !strings.Contains(w.Body.String(), `"main"`) {
+		t.Fatalf("promote got %d %s", w.Code, w.Body.String())
+	}
+	if w := do(h, "POST", "/-/promote", "secret", ""); w.Code != http.StatusConflict {
+		t.Fatalf("promoting a primary got %d, want 409", w.Code)
+	}
+}
@@ -0,0 +1,46 @@
+package admin
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+
+	"git3/internal/git"
+)
+
+// diff shows how a key changed between two versions:
+// GET /-/diff/<key>?from=<rev>&to=<rev>, where to defaults to the working
+// tree. The reply is a unified diff, or JSON hunks with ?format=json or an
+// Accept: application/json header.
+func (h *Handler) diff(w http.ResponseWriter, r *http.Request, key string) {
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	from := r.URL.Query().Get("from")
+	if from == "" {
+		jsonError(w, http.StatusBadRequest, "from is required")
+		return
+	}
+
+	d, err := h.syncer.Diff(key, from, r.URL.Query().Get("to"))
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		jsonError(w, http.StatusNotFound, err.Error())
+		return
+	case errors.Is(err, git.ErrUnknownRevision):
+		jsonError(w, http.StatusNotFound, err.Error())
+		return
+	case err != nil:
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json") {
+		writeJSON(w, http.StatusOK, d)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(d.Unified()))
+}
@@ -0,0 +1,40 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"git3/internal/git"
+)
+
+func TestDiff(t *testing.T) {
+	h := NewHandler(Config{Token: "secret", StatusToken: "peek", Syncer: &fakeSyncer{}})
+
+	// Diffs show content, so the status token isn't enough
+	if w := do(h, "GET", "/-/diff/notes/a.md?from=abc", "peek", ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("diff with the status token got %d, want 401", w.Code)
+	}
+
+	w := do(h, "GET", "/-/diff/notes/a.md?from=abc", "secret", "")
+	if w.Code != http.StatusOK || w.Body.String() != "--- a/notes/a.md\n+++ b/notes/a.md\n@@ -1 +1 @@\n-a\n+b\n" {
+		t.Fatalf("diff got %d:\n%s", w.Code, w.Body.String())
+	}
+
+	w = do(h, "GET", "/-/diff/notes/a.md?from=abc&to=def&format=json", "secret", "")
+	var d git.FileDiff
+	json.NewDecoder(w.Body).Decode(&d)
+	if d.Key != "notes/a.md" || d.To != "def" || len(d.Hunks) != 1 {
+		t.Fatalf("JSON diff = %+v", d)
+	}
+
+	for path, want := range map[string]int{
+		"/-/diff/notes/a.md":           http.StatusBadRequest,
+		"/-/diff/notes/a.md?from=nope": http.StatusNotFound,
+		"/-/diff/missing.md?from=abc":  http.StatusNotFound,
+	} {
+		if w := do(h, "GET", path, "secret", ""); w.Code != want {
+			t.Errorf("%s got %d, want %d", path, w.Code, want)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package admin
+
+import (
+	"crypto/hmac"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"git3/internal/webhook"
+)
+
+// maxHookPayload matches GitHub's cap on webhook payloads.
+const maxHookPayload = 25 << 20
+
+// pushHook handles push webhooks from GitHub or Gitea. A push to the pull
+// branch schedules an immediate pull; the syncer coalesces bursts.
+func (h *Handler) pushHook(w http.ResponseWriter, r *http.Request) {
+	if h.hookSecret == "" {
+		jsonError(w, http.StatusNotFound, "push hook disabled; set HOOK_SECRET")
+		return
+	}
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxHookPayload))
+	if err != nil {
+		jsonError(w, http.StatusRequestEntityTooLarge, "payload too large")
+		return
+	}
+	if !validSignature(h.hookSecret, body, r.Header) {
+		jsonError(w, http.StatusUnauthorized, "invalid signature")
+		return
+	}
+
+	event := r.Header.Get("X-GitHub-Event")
+	if event == "" {
+		event = r.Header.Get("X-Gitea-Event")
+	}
+	if event == "ping" {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "pong"})
+		return
+	}
+	if event != "push" {
+		writeJSON(w, http.StatusAccepted, map[string]string{"status": "ignored", "reason": "not a push event"})
+		return
+	}
+
+	var push struct {
+		Ref string `json:"ref"`
+	}
+	if err := json.Unmarshal(body, &push); err != nil {
+		jsonError(w, http.StatusBadRequest, "invalid push payload")
+		return
+	}
+	if push.Ref != "refs/heads/"+h.syncer.PullBranch() {
+		writeJSON(w, http.StatusAccepted, map[string]string{"status": "ignored", "reason": "push to another branch"})
+		return
+	}
+
+	h.syncer.RequestPull()
+	writeJSON(w, http.StatusAccepted, map[string]string{"status": "pull scheduled"})
+}
+
+// validSignature checks GitHub's X-Hub-Signature-256 ("sha256=<hex>") or
+// Gitea's X-Gitea-Signature (bare hex) against the body.
+func validSignature(secret string, body []byte, header http.Header) bool {
+	want := webhook.Sign(secret, body)
+	if got := header.Get("X-Hub-Signature-256"); got != "" {
+		return hmac.Equal([]byte(got), []byte(want))
+	}
+	if got := header.Get("X-Gitea-Signature"); got != "" {
+		return hmac.Equal([]byte("sha256="+got), []byte(want))
+	}
+	return false
+}
@@ -0,0 +1,63 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"git3/internal/webhook"
+)
+
+func sendHook(h http.Handler, event, body, signature string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/-/hooks/push", strings.NewReader(body))
+	req.Header.Set("X-GitHub-Event", event)
+	if signature != "" {
+		req.Header.Set("X-Hub-Signature-256", signature)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestPushHookSchedulesPull(t *testing.T) {
+	syncer := &fakeSyncer{branch: "main"}
+	// No admin token: the hook is authenticated by its signature alone
+	h := NewHandler(Config{HookSecret: "hook", Syncer: syncer})
+
+	body := `{"ref": "refs/heads/main"}`
+	if w := sendHook(h, "push", body, webhook.Sign("hook", []byte(body))); w.Code != http.StatusAccepted {
+		t.Fatalf("signed push got %d: %s", w.Code, w.Body.String())
+	}
+	if syncer.pulls != 1 {
+		t.Fatalf("RequestPull called %d times, want 1", syncer.pulls)
+	}
+
+	other := `{"ref": "refs/heads/feature"}`
+	sendHook(h, "push", other, webhook.Sign("hook", []byte(other)))
+	if syncer.pulls != 1 {
+		t.Fatal("push to another branch scheduled a pull")
+	}
+}
+
+func TestPushHookRejectsBadSignature(t *testing.T) {
+	syncer := &fakeSyncer{branch: "main"}
+	h := NewHandler(Config{HookSecret: "hook", Syncer: syncer})
+
+	body := `{"ref": "refs/heads/main"}`
+	for _, sig := range []string{"", webhook.Sign("wrong", []byte(body))} {
+		if w := sendHook(h, "push", body, sig); w.Code != http.StatusUnauthorized {
+			t.Fatalf("signature %q got %d, want 401", sig, w.Code)
+		}
+	}
+	if syncer.pulls != 0 {
+		t.Fatal("unsigned push scheduled a pull")
+	}
+}
+
+func TestPushHookDisabledWithoutSecret(t *testing.T) {
+	h := NewHandler(Config{Token: "secret", Syncer: &fakeSyncer{branch: "main"}})
+	if w := sendHook(h, "push", "{}", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("disabled hook got %d, want 404", w.Code)
+	}
+}
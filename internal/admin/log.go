@@ -0,0 +1,46 @@
+package admin
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"git3/internal/git"
+)
+
+// commitLog lists recent commits: GET /-/log?limit=20&offset=0 pages through the
+// history, and ?since=<hash> returns only commits newer than hash.
+func (h *Handler) commitLog(w http.ResponseWriter, r *http.Request) {
+	if !h.statusAllowed(w, r) {
+		return
+	}
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	opts := git.LogOptions{Since: q.Get("since")}
+	for name, dst := range map[string]*int{"limit": &opts.Limit, "offset": &opts.Offset} {
+		v := q.Get(name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			jsonError(w, http.StatusBadRequest, name+" must be a non-negative integer")
+			return
+		}
+		*dst = n
+	}
+
+	entries, err := h.syncer.Log(opts)
+	if errors.Is(err, git.ErrInvalidHash) {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	} else if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
@@ -0,0 +1,43 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"git3/internal/git"
+)
+
+func TestLog(t *testing.T) {
+	syncer := &fakeSyncer{}
+	h := NewHandler(Config{Syncer: syncer})
+
+	w := do(h, "GET", "/-/log?limit=5&offset=10&since=0123456789abcdef0123456789abcdef01234567", "", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("log got %d: %s", w.Code, w.Body.String())
+	}
+	var entries []git.LogEntry
+	json.NewDecoder(w.Body).Decode(&entries)
+	if len(entries) != 1 || entries[0].Hash != "abc" {
+		t.Fatalf("entries = %+v", entries)
+	}
+	if o := syncer.logOpts; o.Limit != 5 || o.Offset != 10 || o.Since != "0123456789abcdef0123456789abcdef01234567" {
+		t.Fatalf("options = %+v", o)
+	}
+
+	for _, query := range []string{"limit=-1", "offset=x", "since=zzz"} {
+		if w := do(h, "GET", "/-/log?"+query, "", ""); w.Code != http.StatusBadRequest {
+			t.Errorf("%s got %d, want 400", query, w.Code)
+		}
+	}
+}
+
+func TestLogHonorsStatusToken(t *testing.T) {
+	h := NewHandler(Config{StatusToken: "peek", Syncer: &fakeSyncer{}})
+	if w := do(h, "GET", "/-/log", "", ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("log without token got %d, want 401", w.Code)
+	}
+	if w := do(h, "GET", "/-/log", "peek", ""); w.Code != http.StatusOK {
+		t.Fatalf("log with token got %d", w.Code)
+	}
+}
@@ -0,0 +1,59 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"git3/internal/s3"
+)
+
+// MoveRequest is the body of POST /-/move.
+type MoveRequest struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Prefix bool   `json:"prefix"` // from and to are prefixes, ending in "/"
+}
+
+// MoveResponse is the reply to POST /-/move.
+type MoveResponse struct {
+	Moved []s3.MovedObject `json:"moved"`
+	Error string           `json:"error,omitempty"`
+}
+
+// move renames an object, or every object under a prefix, on disk and
+// syncs the lot at once; see s3.Handler.Move. It answers 404 if there is
+// nothing to move, 409 if a destination exists, and 500 with what was
+// moved if the move stopped part way.
+func (h *Handler) move(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if h.vault == nil {
+		jsonError(w, http.StatusNotFound, "no vault to move objects in")
+		return
+	}
+	var req MoveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, http.StatusBadRequest, `body must be {"from": "<key>", "to": "<key>", "prefix": false}`)
+		return
+	}
+	moved, err := h.vault.Move(req.From, req.To, req.Prefix)
+	switch {
+	case errors.Is(err, s3.ErrMoveInvalid):
+		jsonError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, s3.ErrMoveDenied):
+		jsonError(w, http.StatusForbidden, err.Error())
+	case errors.Is(err, s3.ErrMoveNotFound):
+		jsonError(w, http.StatusNotFound, err.Error())
+	case len(moved) == 0 && errors.Is(err, s3.ErrMoveExists):
+		jsonError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, s3.ErrMoveUnsupported):
+		jsonError(w, http.StatusNotImplemented, err.Error())
+	case err != nil:
+		writeJSON(w, http.StatusInternalServerError, MoveResponse{Moved: moved, Error: err.Error()})
+	default:
+		writeJSON(w, http.StatusOK, MoveResponse{Moved: moved})
+	}
+}
@@ -0,0 +1,44 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"git3/internal/s3"
+)
+
+func TestAdminMove(t *testing.T) {
+	vault := s3.NewHandlerWithOptions(t.TempDir())
+	for _, key := range []string{"notes/a.md", "notes/b.md", "taken.md"} {
+		do(vault, "PUT", "/vault/"+key, "", key)
+	}
+	h := NewHandler(Config{Token: "secret", Syncer: &fakeSyncer{}, Vault: vault})
+
+	if w := do(h, "POST", "/-/move", "", `{"from":"notes/","to":"old/","prefix":true}`); w.Code != http.StatusUnauthorized {
+		t.Fatalf("move without a token got %d, want 401", w.Code)
+	}
+	if w := do(h, "GET", "/-/move", "secret", ""); w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("GET /-/move got %d, want 405", w.Code)
+	}
+	for body, want := range map[string]int{
+		`nonsense`: http.StatusBadRequest,
+		`{"from":"notes/","to":"notes/sub/","prefix":true}`: http.StatusBadRequest,
+		`{"from":"missing.md","to":"new.md"}`:               http.StatusNotFound,
+		`{"from":"notes/a.md","to":"taken.md"}`:             http.StatusConflict,
+	} {
+		if w := do(h, "POST", "/-/move", "secret", body); w.Code != want {
+			t.Errorf("move %s got %d, want %d: %s", body, w.Code, want, w.Body)
+		}
+	}
+
+	w := do(h, "POST", "/-/move", "secret", `{"from":"notes/","to":"old/","prefix":true}`)
+	var resp MoveResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if w.Code != http.StatusOK || len(resp.Moved) != 2 || resp.Moved[1] != (s3.MovedObject{From: "notes/b.md", To: "old/b.md"}) {
+		t.Fatalf("move got %d %+v", w.Code, resp)
+	}
+	if w := do(vault, "GET", "/vault/old/a.md", "", ""); w.Body.String() != "notes/a.md" {
+		t.Fatalf("moved object = %d %q", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,43 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"git3/internal/git"
+)
+
+// PruneRequest is the body of POST /-/prune.
+type PruneRequest struct {
+	Before time.Time `json:"before"` // history up to this time is squashed
+}
+
+// prune rewrites the history to drop versions older than the cutoff and
+// force-pushes it; see git.Syncer.Prune. It answers 409 while there are
+// changes not yet pushed or pulled.
+func (h *Handler) prune(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var req PruneRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Before.IsZero() {
+		jsonError(w, http.StatusBadRequest, `body must be {"before": "<RFC 3339 time>"}`)
+		return
+	}
+	if req.Before.After(time.Now()) {
+		jsonError(w, http.StatusBadRequest, "before is in the future")
+		return
+	}
+	result, err := h.syncer.Prune(req.Before)
+	if errors.Is(err, git.ErrUnpushed) {
+		jsonError(w, http.StatusConflict, err.Error())
+		return
+	} else if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
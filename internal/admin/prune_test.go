@@ -0,0 +1,42 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"git3/internal/git"
+)
+
+func TestAdminPrune(t *testing.T) {
+	syncer := &fakeSyncer{branch: "main"}
+	h := NewHandler(Config{Token: "secret", Syncer: syncer})
+	body := `{"before":"2026-01-02T03:04:05Z"}`
+
+	if w := do(h, "POST", "/-/prune", "", body); w.Code != http.StatusUnauthorized {
+		t.Fatalf("prune without a token got %d, want 401", w.Code)
+	}
+	if w := do(h, "GET", "/-/prune", "secret", ""); w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("GET /-/prune got %d, want 405", w.Code)
+	}
+	for _, bad := range []string{"", `{}`, `{"before":"yesterday"}`, `{"before":"2999-01-01T00:00:00Z"}`} {
+		if w := do(h, "POST", "/-/prune", "secret", bad); w.Code != http.StatusBadRequest {
+			t.Errorf("body %q got %d, want 400", bad, w.Code)
+		}
+	}
+	if w := do(h, "POST", "/-/prune", "secret", body); w.Code != http.StatusConflict {
+		t.Fatalf("prune with unpushed changes got %d, want 409", w.Code)
+	}
+
+	syncer.syncs = 1
+	w := do(h, "POST", "/-/prune", "secret", body)
+	var resp git.PruneResult
+	json.NewDecoder(w.Body).Decode(&resp)
+	if w.Code != http.StatusOK || resp.Head != "def" || resp.Squashed != 10 || resp.Backup == "" {
+		t.Fatalf("prune got %d %+v", w.Code, resp)
+	}
+	if want := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC); !syncer.pruned.Equal(want) {
+		t.Fatalf("pruned before %s, want %s", syncer.pruned, want)
+	}
+}
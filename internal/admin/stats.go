@@ -0,0 +1,55 @@
+package admin
+
+import (
+	"net/http"
+	"strconv"
+
+	"git3/internal/s3"
+)
+
+// StatsResponse is the body of GET /-/stats.
+type StatsResponse struct {
+	s3.BucketStats
+	// RepoBytes and RepoSizeLimit are what the repository size guard
+	// reads; see git.Config.SizeLimit
+	RepoBytes     int64 `json:"repo_bytes"`
+	RepoSizeLimit int64 `json:"repo_size_limit,omitempty"`
+	OverSizeLimit bool  `json:"over_size_limit,omitempty"`
+}
+
+// bucketStats summarizes the vault from the counts the S3 handler keeps,
+// guarded like the status. With ?refresh=true the tree is walked again
+// first, for changes made to it directly, which takes a token: the status
+// token if one is set, else the admin token. Without a token, the keys of
+// the largest objects are left out when S3 requests need credentials.
+func (h *Handler) bucketStats(w http.ResponseWriter, r *http.Request) {
+	if !h.statusAllowed(w, r) {
+		return
+	}
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if h.vault == nil {
+		jsonError(w, http.StatusNotFound, "no vault to count")
+		return
+	}
+	tokened := h.statusToken != "" || (h.token != "" && validToken(r, h.token))
+	refresh, _ := strconv.ParseBool(r.URL.Query().Get("refresh"))
+	if refresh && !tokened {
+		jsonError(w, http.StatusUnauthorized, "refresh needs the admin token")
+		return
+	}
+	stats, err := h.vault.BucketStats(refresh)
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if h.s3Auth && !tokened {
+		stats.Largest = []s3.ObjectSize{}
+	}
+	resp := StatsResponse{BucketStats: stats}
+	resp.RepoBytes, resp.RepoSizeLimit = h.syncer.RepoSize()
+	resp.OverSizeLimit = resp.RepoSizeLimit > 0 && resp.RepoBytes >= resp.RepoSizeLimit
+	writeJSON(w, http.StatusOK, resp)
+}
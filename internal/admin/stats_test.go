@@ -0,0 +1,52 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"git3/internal/s3"
+)
+
+func TestAdminStats(t *testing.T) {
+	vault := s3.NewHandlerWithOptions(t.TempDir())
+	do(vault, "PUT", "/vault/notes/a.md", "", "hello")
+	h := NewHandler(Config{StatusToken: "peek", Syncer: &fakeSyncer{}, Vault: vault})
+
+	if w := do(h, "GET", "/-/stats", "", ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("stats without the status token got %d, want 401", w.Code)
+	}
+	w := do(h, "GET", "/-/stats?refresh=true", "peek", "")
+	var resp StatsResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if w.Code != http.StatusOK || resp.Objects != 1 || resp.Bytes != 5 || len(resp.Largest) != 1 || resp.Prefixes[0].Prefix != "notes/" {
+		t.Fatalf("stats got %d %+v", w.Code, resp)
+	}
+	// The same numbers the repository size guard reads
+	if resp.RepoBytes != 4096 || resp.RepoSizeLimit != 2048 || !resp.OverSizeLimit {
+		t.Fatalf("repository size = %d of %d, over %v", resp.RepoBytes, resp.RepoSizeLimit, resp.OverSizeLimit)
+	}
+}
+
+func TestAdminStatsWithoutStatusToken(t *testing.T) {
+	vault := s3.NewHandlerWithOptions(t.TempDir())
+	do(vault, "PUT", "/vault/notes/a.md", "", "hello")
+	h := NewHandler(Config{Token: "secret", S3Auth: true, Syncer: &fakeSyncer{}, Vault: vault})
+
+	if w := do(h, "GET", "/-/stats?refresh=true", "", ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("refresh without a token got %d, want 401", w.Code)
+	}
+	// Open without a status token, but the keys stay behind S3 credentials
+	w := do(h, "GET", "/-/stats", "", "")
+	var resp StatsResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if w.Code != http.StatusOK || resp.Objects != 1 || len(resp.Largest) != 0 {
+		t.Fatalf("stats without a token got %d %+v", w.Code, resp)
+	}
+	w = do(h, "GET", "/-/stats?refresh=true", "secret", "")
+	resp = StatsResponse{}
+	json.NewDecoder(w.Body).Decode(&resp)
+	if w.Code != http.StatusOK || len(resp.Largest) != 1 || resp.Largest[0].Key != "notes/a.md" {
+		t.Fatalf("stats with the admin token got %d %+v", w.Code, resp)
+	}
+}
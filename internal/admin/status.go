@@ -0,0 +1,110 @@
+package admin
+
+import (
+	"net/http"
+	"time"
+
+	"git3/internal/git"
+	"git3/internal/s3"
+)
+
+// statusTimeout bounds how long GET /-/status waits for a running sync
+// before answering with the last status it saw.
+const statusTimeout = 200 * time.Millisecond
+
+// Vault is the part of the S3 handler the admin API uses.
+type Vault interface {
+	Stats() (objects int, bytes int64, err error)
+	BucketStats(refresh bool) (s3.BucketStats, error)
+	LastReconcile() *s3.MetaReport
+	Move(from, to string, prefix bool) ([]s3.MovedObject, error)
+}
+
+// StatusResponse is the body of GET /-/status.
+type StatusResponse struct {
+	git.Status
+	Syncing bool  `json:"syncing"` // a commit, push or pull is running right now
+	Objects int   `json:"objects"`
+	Bytes   int64 `json:"bytes"`
+	// Reconcile is the last pass over the metadata store, once one ran
+	Reconcile *s3.MetaReport `json:"metadata_reconcile,omitempty"`
+	Events    []git.Event    `json:"events"` // recent sync steps, oldest first
+}
+
+// status reports the syncer's state and the size of the vault.
+func (h *Handler) status(w http.ResponseWriter, r *http.Request) {
+	if !h.statusAllowed(w, r) {
+		return
+	}
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var resp StatusResponse
+	st, ok := h.syncer.TryStatus(statusTimeout)
+	h.mu.Lock()
+	if ok {
+		h.lastStatus = st
+	}
+	resp.Status = h.lastStatus
+	h.mu.Unlock()
+	resp.Syncing = !ok
+	resp.Events = h.syncer.Events()
+
+	if h.vault != nil {
+		objects, bytes, err := h.vault.Stats()
+		if err != nil {
+			jsonError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		resp.Objects, resp.Bytes = objects, bytes
+		resp.Reconcile = h.vault.LastReconcile()
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// serveMetrics serves the Prometheus metrics, guarded like the status.
+func (h *Handler) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	if h.metrics == nil {
+		jsonError(w, http.StatusNotFound, "metrics disabled")
+		return
+	}
+	if h.statusAllowed(w, r) {
+		h.metrics.ServeHTTP(w, r)
+	}
+}
+
+// serveEvents serves the change stream, guarded like the status. Its
+// events name the keys changed, so when S3 requests need credentials it
+// needs the status or admin token even if no status token is set.
+func (h *Handler) serveEvents(w http.ResponseWriter, r *http.Request) {
+	if h.events == nil {
+		jsonError(w, http.StatusNotFound, "event stream disabled")
+		return
+	}
+	if !h.statusAllowed(w, r) {
+		return
+	}
+	if h.s3Auth && h.statusToken == "" {
+		switch {
+		case h.token == "":
+			jsonError(w, http.StatusForbidden, "event stream needs STATUS_TOKEN or ADMIN_TOKEN with S3 credentials set")
+			return
+		case !validToken(r, h.token):
+			jsonError(w, http.StatusUnauthorized, "invalid admin token")
+			return
+		}
+	}
+	h.events.ServeHTTP(w, r)
+}
+
+// statusAllowed checks the read-only endpoints' token: when a status token
+// is set, they need that or the admin token.
+func (h *Handler) statusAllowed(w http.ResponseWriter, r *http.Request) bool {
+	if h.statusToken != "" && !validToken(r, h.statusToken) && !(h.token != "" && validToken(r, h.token)) {
+		jsonError(w, http.StatusUnauthorized, "invalid status token")
+		return false
+	}
+	return true
+}
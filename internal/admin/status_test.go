@@ -0,0 +1,81 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"git3/internal/git"
+	"git3/internal/s3"
+)
+
+type fakeVault struct{}
+
+func (fakeVault) Stats() (int, int64, error)    { return 3, 1024, nil }
+func (fakeVault) LastReconcile() *s3.MetaReport { return &s3.MetaReport{Kept: 5, Missing: 1} }
+func (fakeVault) BucketStats(bool) (s3.BucketStats, error) {
+	return s3.BucketStats{Objects: 3, Bytes: 1024}, nil
+}
+func (fakeVault) Move(string, string, bool) ([]s3.MovedObject, error) {
+	return nil, s3.ErrMoveNotFound
+}
+
+func TestStatus(t *testing.T) {
+	syncer := &fakeSyncer{branch: "main"}
+	syncer.status.Commits = 2
+	syncer.status.LastPushError = "push failed: timeout"
+	syncer.events = []git.Event{{Op: "push", Result: "failed", Error: "push failed: timeout"}}
+	h := NewHandler(Config{Syncer: syncer, Vault: fakeVault{}})
+
+	// No admin token needed
+	w := do(h, "GET", "/-/status", "", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status got %d: %s", w.Code, w.Body.String())
+	}
+	var resp StatusResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if resp.Commits != 2 || resp.LastPushError != "push failed: timeout" || resp.Objects != 3 || resp.Bytes != 1024 || resp.Syncing || len(resp.Events) != 1 ||
+		resp.Reconcile == nil || resp.Reconcile.Missing != 1 {
+		t.Fatalf("unexpected status %+v", resp)
+	}
+
+	// A sync in progress gets the last status seen instead of a wait
+	syncer.busy = true
+	syncer.status.Commits = 5
+	w = do(h, "GET", "/-/status", "", "")
+	resp = StatusResponse{}
+	json.NewDecoder(w.Body).Decode(&resp)
+	if !resp.Syncing || resp.Commits != 2 {
+		t.Fatalf("busy status = %+v, want the previous one marked syncing", resp)
+	}
+}
+
+func TestStatusToken(t *testing.T) {
+	h := NewHandler(Config{Token: "admin", StatusToken: "peek", Syncer: &fakeSyncer{}})
+
+	for token, want := range map[string]int{"": 401, "wrong": 401, "admin": 200, "peek": 200} {
+		if w := do(h, "GET", "/-/status", token, ""); w.Code != want {
+			t.Errorf("token %q got %d, want %d", token, w.Code, want)
+		}
+	}
+}
+
+func TestEventsNeedTokenWithS3Auth(t *testing.T) {
+	events := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	h := NewHandler(Config{Token: "admin", S3Auth: true, Syncer: &fakeSyncer{}, Events: events})
+
+	for token, want := range map[string]int{"": 401, "wrong": 401, "admin": 200} {
+		if w := do(h, "GET", "/-/events", token, ""); w.Code != want {
+			t.Errorf("token %q got %d, want %d", token, w.Code, want)
+		}
+	}
+	h = NewHandler(Config{S3Auth: true, Syncer: &fakeSyncer{}, Events: events})
+	if w := do(h, "GET", "/-/events", "", ""); w.Code != http.StatusForbidden {
+		t.Fatalf("events without any token configured got %d, want 403", w.Code)
+	}
+	// Without S3 credentials the stream stays open, like the status
+	h = NewHandler(Config{Token: "admin", Syncer: &fakeSyncer{}, Events: events})
+	if w := do(h, "GET", "/-/events", "", ""); w.Code != http.StatusOK {
+		t.Fatalf("open events got %d, want 200", w.Code)
+	}
+}
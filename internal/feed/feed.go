@@ -0,0 +1,168 @@
+// Package feed streams vault changes to browsers as server-sent events.
+package feed
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event types.
+const (
+	Put    = "put"
+	Delete = "delete"
+	Pull   = "pull"
+)
+
+// Event is one change to the vault: objects written or deleted through the
+// S3 API, or files brought in by a pull.
+type Event struct {
+	Type string    `json:"type"`
+	Keys []string  `json:"keys"`
+	Time time.Time `json:"ts"`
+}
+
+// Broker fans events out to the connected event streams. Each stream has
+// its own buffer; a client that falls behind loses its oldest events
+// rather than holding up the others.
+type Broker struct {
+	Buffer    int           // events buffered per stream
+	Heartbeat time.Duration // interval of keep-alive comments, for proxies that drop idle connections
+
+	mu     sync.Mutex
+	subs   map[chan Event]struct{}
+	closed chan struct{}
+	once   sync.Once
+}
+
+// New creates a Broker with a 64 event buffer per stream and a heartbeat
+// every 15 seconds.
+func New() *Broker {
+	return &Broker{
+		Buffer:    64,
+		Heartbeat: 15 * time.Second,
+		subs:      make(map[chan Event]struct{}),
+		closed:    make(chan struct{}),
+	}
+}
+
+// Publish sends e to every stream, dropping a stream's oldest event when
+// its buffer is full. It never blocks.
+func (b *Broker) Publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs {
+		select {
+		case ch <- e:
+			continue
+		default:
+		}
+		// Only publishers send, under b.mu, so dropping one makes room
+		select {
+		case <-ch:
+		default:
+		}
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// ObjectsChanged publishes objects written ("put") or deleted ("delete")
+// through the S3 API.
+func (b *Broker) ObjectsChanged(op string, keys []string) {
+	b.Publish(Event{Type: op, Keys: keys})
+}
+
+// Close ends every stream, so a server shutdown doesn't wait for them.
+func (b *Broker) Close() {
+	b.once.Do(func() { close(b.closed) })
+}
+
+func (b *Broker) subscribe() chan Event {
+	ch := make(chan Event, max(b.Buffer, 1))
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+	return ch
+}
+
+func (b *Broker) unsubscribe(ch chan Event) {
+	b.mu.Lock()
+	delete(b.subs, ch)
+	b.mu.Unlock()
+}
+
+// ServeHTTP streams events as "data: <json>" lines until the client goes
+// away or the Broker is closed.
+func (b *Broker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	rc := http.NewResponseController(w)
+	ch := b.subscribe()
+	defer b.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // nginx would otherwise hold events back
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": connected\n\n")
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(b.Heartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case e := <-ch:
+			data, err := json.Marshal(e)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "data: %s\n\n", data)
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		case <-r.Context().Done():
+			return
+		case <-b.closed:
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// Pulls publishes the paths a pull changed as pull events. It implements
+// git.ChangeNotifier. Paths outside Subdir are left out and the rest are
+// made relative to it, so they read as S3 keys.
+type Pulls struct {
+	Broker *Broker
+	Subdir string
+}
+
+func (p Pulls) Changed(oldHash, newHash string, paths []string) {
+	prefix := strings.Trim(p.Subdir, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	keys := make([]string, 0, len(paths))
+	for _, path := range paths {
+		if key, ok := strings.CutPrefix(path, prefix); ok {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) > 0 {
+		p.Broker.Publish(Event{Type: Pull, Keys: keys})
+	}
+}
@@ -0,0 +1,116 @@
+package feed
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPublishDropsOldest(t *testing.T) {
+	b := New()
+	b.Buffer = 2
+	ch := b.subscribe()
+	for _, key := range []string{"a", "b", "c"} {
+		b.Publish(Event{Type: Put, Keys: []string{key}})
+	}
+	for _, want := range []string{"b", "c"} {
+		if e := <-ch; e.Keys[0] != want {
+			t.Fatalf("got event for %s, want %s", e.Keys[0], want)
+		}
+	}
+}
+
+func TestPullsKeysRelativeToSubdir(t *testing.T) {
+	b := New()
+	ch := b.subscribe()
+	Pulls{Broker: b, Subdir: "vault"}.Changed("old", "new", []string{"vault/a.md", "other/b.md"})
+	e := <-ch
+	if e.Type != Pull || len(e.Keys) != 1 || e.Keys[0] != "a.md" {
+		t.Fatalf("event = %+v, want a pull of a.md", e)
+	}
+}
+
+// readLine returns the next non-empty line of an event stream.
+func readLine(t *testing.T, r *bufio.Reader) string {
+	t.Helper()
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			return line
+		}
+	}
+}
+
+func TestServeHTTPStreamsEvents(t *testing.T) {
+	b := New()
+	b.Heartbeat = 20 * time.Millisecond
+	srv := httptest.NewServer(b)
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	r := bufio.NewReader(resp.Body)
+	if line := readLine(t, r); line != ": connected" {
+		t.Fatalf("first line = %q", line)
+	}
+
+	b.ObjectsChanged(Put, []string{"a.md"})
+	line := readLine(t, r)
+	for line == ": heartbeat" {
+		line = readLine(t, r)
+	}
+	var e Event
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e); err != nil || e.Type != Put || e.Keys[0] != "a.md" || e.Time.IsZero() {
+		t.Fatalf("event line %q: %+v, %v", line, e, err)
+	}
+	if line := readLine(t, r); line != ": heartbeat" {
+		t.Fatalf("idle stream sent %q, want a heartbeat", line)
+	}
+
+	// Disconnecting unsubscribes
+	cancel()
+	deadline := time.Now().Add(time.Second)
+	for {
+		b.mu.Lock()
+		n := len(b.subs)
+		b.mu.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("stream still subscribed after the client left")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestCloseEndsStreams(t *testing.T) {
+	b := New()
+	done := make(chan struct{})
+	go func() {
+		b.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/-/events", nil))
+		close(done)
+	}()
+	b.Close()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("stream still open after Close")
+	}
+}
@@ -0,0 +1,227 @@
+// Package forge opens and follows pull requests through the REST API of
+// the GitHub or Gitea instance hosting the remote.
+package forge
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// The forges a Client speaks to. Gitea's API follows GitHub's closely
+// enough that only merging and authentication differ.
+const (
+	GitHub = "github"
+	Gitea  = "gitea"
+)
+
+// Pull request states, as State returns them.
+const (
+	StateOpen   = "open"
+	StateMerged = "merged"
+	StateClosed = "closed"
+)
+
+// ErrNotMergeable is returned by Merge when the forge refuses to merge
+// yet, typically because checks are still running or failed.
+var ErrNotMergeable = errors.New("pull request not mergeable")
+
+// Client calls one repository's pull request API.
+type Client struct {
+	Kind       string // GitHub or Gitea
+	API        string // e.g. https://api.github.com or https://gitea.example.com/api/v1
+	Owner      string
+	Repo       string
+	Token      string
+	HTTPClient *http.Client
+}
+
+// New returns a Client for the repository at remote, a git URL over HTTPS
+// or SSH. api is the API's base URL; when empty it is derived from the
+// remote's host: api.github.com for github.com, and /api/v1 on the host
+// for Gitea.
+func New(kind, api, remote, token string) (*Client, error) {
+	if kind != GitHub && kind != Gitea {
+		return nil, fmt.Errorf("unknown forge %q, want %s or %s", kind, GitHub, Gitea)
+	}
+	host, owner, repo, err := parseRemote(remote)
+	if err != nil {
+		return nil, err
+	}
+	if api == "" {
+		switch {
+		case kind == GitHub && host == "github.com":
+			api = "https://api.github.com"
+		case kind == GitHub:
+			api = "https://" + host + "/api/v3" // GitHub Enterprise Server
+		default:
+			api = "https://" + host + "/api/v1"
+		}
+	}
+	return &Client{Kind: kind, API: strings.TrimSuffix(api, "/"), Owner: owner, Repo: repo, Token: token}, nil
+}
+
+// parseRemote splits a remote URL, such as https://github.com/you/vault.git
+// or git@github.com:you/vault.git, into host, owner and repository.
+func parseRemote(remote string) (host, owner, repo string, err error) {
+	var p string
+	if u, perr := url.Parse(remote); perr == nil && u.Host != "" {
+		host, p = u.Hostname(), u.Path
+	} else if at, rest, ok := strings.Cut(remote, ":"); ok && !strings.Contains(at, "/") {
+		_, host, _ = strings.Cut(at, "@")
+		if host == "" {
+			host = at
+		}
+		p = rest
+	}
+	p = strings.TrimSuffix(strings.Trim(p, "/"), ".git")
+	i := strings.LastIndex(p, "/")
+	if host == "" || i <= 0 || i == len(p)-1 {
+		return "", "", "", fmt.Errorf("cannot tell the owner and repository from remote %q", remote)
+	}
+	return host, p[:i], p[i+1:], nil
+}
+
+type pullRequest struct {
+	Number  int    `json:"number"`
+	HTMLURL string `json:"html_url"`
+	State   string `json:"state"`
+	Merged  bool   `json:"merged"`
+	Head    struct {
+		Ref string `json:"ref"`
+	} `json:"head"`
+	Base struct {
+		Ref string `json:"ref"`
+	} `json:"base"`
+}
+
+// Find returns the number and web URL of the open pull request from head
+// into base, or 0 if there is none.
+func (c *Client) Find(ctx context.Context, head, base string) (int, string, error) {
+	q := url.Values{"state": {"open"}, "base": {base}, "head": {c.Owner + ":" + head}}
+	var prs []pullRequest
+	if err := c.call(ctx, "GET", c.pulls("?"+q.Encode()), nil, &prs); err != nil {
+		return 0, "", fmt.Errorf("find pull request: %w", err)
+	}
+	// Gitea ignores the head and base filters
+	for _, pr := range prs {
+		if pr.Head.Ref == head && pr.Base.Ref == base {
+			return pr.Number, pr.HTMLURL, nil
+		}
+	}
+	return 0, "", nil
+}
+
+// Create opens a pull request from head into base, returning its number
+// and web URL.
+func (c *Client) Create(ctx context.Context, head, base, title string) (int, string, error) {
+	body := map[string]string{"title": title, "head": head, "base": base,
+		"body": "Opened by git3 for changes synced from the vault. It is updated with every sync until merged."}
+	var pr pullRequest
+	if err := c.call(ctx, "POST", c.pulls(""), body, &pr); err != nil {
+		return 0, "", fmt.Errorf("open pull request: %w", err)
+	}
+	return pr.Number, pr.HTMLURL, nil
+}
+
+// State returns whether pull request number is StateOpen, StateMerged or
+// StateClosed.
+func (c *Client) State(ctx context.Context, number int) (string, error) {
+	var pr pullRequest
+	if err := c.call(ctx, "GET", c.pulls(fmt.Sprintf("/%d", number)), nil, &pr); err != nil {
+		return "", fmt.Errorf("pull request #%d: %w", number, err)
+	}
+	switch {
+	case pr.Merged:
+		return StateMerged, nil
+	case pr.State == "open":
+		return StateOpen, nil
+	default:
+		return StateClosed, nil
+	}
+}
+
+// Merge merges pull request number with a merge commit. Gitea is asked to
+// wait for checks to pass if they haven't; GitHub refuses until they
+// have, and Merge returns ErrNotMergeable so it can be tried again later.
+func (c *Client) Merge(ctx context.Context, number int) error {
+	method, body := "PUT", map[string]any{"merge_method": "merge"}
+	if c.Kind == Gitea {
+		method, body = "POST", map[string]any{"Do": "merge", "merge_when_checks_succeed": true}
+	}
+	err := c.call(ctx, method, c.pulls(fmt.Sprintf("/%d/merge", number)), body, nil)
+	var se *statusError
+	if errors.As(err, &se) && (se.code == http.StatusMethodNotAllowed || se.code == http.StatusConflict) {
+		return fmt.Errorf("%w: #%d: %s", ErrNotMergeable, number, se.msg)
+	} else if err != nil {
+		return fmt.Errorf("merge pull request #%d: %w", number, err)
+	}
+	return nil
+}
+
+func (c *Client) pulls(suffix string) string {
+	return fmt.Sprintf("%s/repos/%s/%s/pulls%s", c.API, url.PathEscape(c.Owner), url.PathEscape(c.Repo), suffix)
+}
+
+// statusError is an API response other than 2xx.
+type statusError struct {
+	code int
+	msg  string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.code, http.StatusText(e.code), e.msg)
+}
+
+// call sends body, if any, as JSON and decodes the response into out, if
+// not nil.
+func (c *Client) call(ctx context.Context, method, target string, body, out any) error {
+	var r io.Reader
+	if body != nil {
+		data, _ := json.Marshal(body)
+		r = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, r)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		scheme := "Bearer"
+		if c.Kind == Gitea {
+			scheme = "token"
+		}
+		req.Header.Set("Authorization", scheme+" "+c.Token)
+	}
+
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &statusError{code: resp.StatusCode, msg: string(bytes.TrimSpace(msg))}
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return &http.Client{Timeout: 30 * time.Second}
+}
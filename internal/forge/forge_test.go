@@ -0,0 +1,133 @@
+package forge
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		kind, remote, api, owner, repo string
+	}{
+		{GitHub, "https://github.com/you/vault.git", "https://api.github.com", "you", "vault"},
+		{GitHub, "git@github.com:you/vault.git", "https://api.github.com", "you", "vault"},
+		{GitHub, "https://git.corp.example/team/vault", "https://git.corp.example/api/v3", "team", "vault"},
+		{Gitea, "https://gitea.example.com/org/sub/vault.git", "https://gitea.example.com/api/v1", "org/sub", "vault"},
+		{Gitea, "ssh://git@gitea.example.com:2222/org/vault.git", "https://gitea.example.com/api/v1", "org", "vault"},
+	}
+	for _, tt := range tests {
+		c, err := New(tt.kind, "", tt.remote, "tok")
+		if err != nil {
+			t.Fatalf("New(%s, %s): %v", tt.kind, tt.remote, err)
+		}
+		if c.API != tt.api || c.Owner != tt.owner || c.Repo != tt.repo {
+			t.Errorf("New(%s, %s) = %s %s/%s, want %s %s/%s", tt.kind, tt.remote, c.API, c.Owner, c.Repo, tt.api, tt.owner, tt.repo)
+		}
+	}
+	if c, _ := New(GitHub, "https://ghe.example/api/v3/", "https://ghe.example/you/vault", ""); c.API != "https://ghe.example/api/v3" {
+		t.Errorf("explicit API = %s", c.API)
+	}
+	if _, err := New("gitlab", "", "https://gitlab.com/you/vault", ""); err == nil {
+		t.Error("unknown forge accepted")
+	}
+	if _, err := New(GitHub, "", "https://github.com/vault", ""); err == nil {
+		t.Error("remote without an owner accepted")
+	}
+}
+
+// fakeForge serves the pull request API for one repository.
+type fakeForge struct {
+	kind   string
+	auth   []string
+	pulls  []map[string]any
+	merged []string // method of each merge request
+	refuse bool     // answer merges with 405
+}
+
+func (f *fakeForge) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.auth = append(f.auth, r.Header.Get("Authorization"))
+	switch {
+	case r.Method == "GET" && r.URL.Path == "/repos/you/vault/pulls":
+		json.NewEncoder(w).Encode(f.pulls)
+	case r.Method == "POST" && r.URL.Path == "/repos/you/vault/pulls":
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		n := len(f.pulls) + 1
+		pr := map[string]any{"number": n, "html_url": fmt.Sprintf("https://forge/you/vault/pull/%d", n), "state": "open",
+			"head": map[string]string{"ref": body["head"]}, "base": map[string]string{"ref": body["base"]}}
+		f.pulls = append(f.pulls, pr)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(pr)
+	case r.Method == "GET" && r.URL.Path == "/repos/you/vault/pulls/1":
+		json.NewEncoder(w).Encode(f.pulls[0])
+	case r.URL.Path == "/repos/you/vault/pulls/1/merge":
+		if f.refuse {
+			http.Error(w, `{"message":"Required status check is expected"}`, http.StatusMethodNotAllowed)
+			return
+		}
+		f.merged = append(f.merged, r.Method)
+		f.pulls[0]["state"], f.pulls[0]["merged"] = "closed", true
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestPullRequestLifecycle(t *testing.T) {
+	for _, kind := range []string{GitHub, Gitea} {
+		t.Run(kind, func(t *testing.T) {
+			f := &fakeForge{kind: kind}
+			srv := httptest.NewServer(f)
+			defer srv.Close()
+			c, err := New(kind, srv.URL, "https://forge/you/vault.git", "secret")
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx := context.Background()
+
+			if n, _, err := c.Find(ctx, "git3/sync", "main"); err != nil || n != 0 {
+				t.Fatalf("Find with none open = %d, %v", n, err)
+			}
+			n, url, err := c.Create(ctx, "git3/sync", "main", "sync")
+			if err != nil || n != 1 || url != "https://forge/you/vault/pull/1" {
+				t.Fatalf("Create = %d %q, %v", n, url, err)
+			}
+			if n, _, err := c.Find(ctx, "git3/sync", "main"); err != nil || n != 1 {
+				t.Fatalf("Find = %d, %v; want the pull request opened", n, err)
+			}
+			if n, _, _ := c.Find(ctx, "other", "main"); n != 0 {
+				t.Fatalf("Find of another head = %d", n)
+			}
+			if state, err := c.State(ctx, 1); err != nil || state != StateOpen {
+				t.Fatalf("State = %q, %v", state, err)
+			}
+
+			f.refuse = true
+			if err := c.Merge(ctx, 1); !errors.Is(err, ErrNotMergeable) {
+				t.Fatalf("Merge refused = %v, want ErrNotMergeable", err)
+			}
+			f.refuse = false
+			if err := c.Merge(ctx, 1); err != nil {
+				t.Fatal(err)
+			}
+			want := map[string]string{GitHub: "PUT", Gitea: "POST"}[kind]
+			if len(f.merged) != 1 || f.merged[0] != want {
+				t.Fatalf("merged with %v, want %s", f.merged, want)
+			}
+			if state, _ := c.State(ctx, 1); state != StateMerged {
+				t.Fatalf("State after merge = %q", state)
+			}
+
+			scheme := map[string]string{GitHub: "Bearer", Gitea: "token"}[kind]
+			for _, got := range f.auth {
+				if got != scheme+" secret" {
+					t.Fatalf("Authorization = %q, want %s secret", got, scheme)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package git
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Attribute modes, for Config.Attributes: whether UpdateAttributes keeps a
+// git3 section in the served directory's .gitattributes.
+const (
+	// AttributesOff leaves .gitattributes alone. This is the default.
+	AttributesOff = "off"
+	// AttributesAuto writes the section, unless .gitattributes already
+	// has rules of the user's own and no git3 section.
+	AttributesAuto = "auto"
+	// AttributesManage writes the section whatever else the file holds.
+	AttributesManage = "manage"
+)
+
+// The git3 section of .gitattributes lies between these lines, so it can
+// be updated without touching the rest of the file.
+const (
+	attributesBegin = "# BEGIN git3: managed, changes between these lines are replaced"
+	attributesEnd   = "# END git3"
+)
+
+// attributesSection is the git3 section marking binary patterns as
+// neither text nor diffable, and text patterns as text with LF endings.
+func attributesSection(binary, text []string) string {
+	var b strings.Builder
+	b.WriteString(attributesBegin + "\n")
+	for _, p := range binary {
+		b.WriteString(p + " -text -diff\n")
+	}
+	for _, p := range text {
+		b.WriteString(p + " text eol=lf\n")
+	}
+	b.WriteString(attributesEnd + "\n")
+	return b.String()
+}
+
+// updateAttributes puts section into the .gitattributes content data,
+// replacing the git3 section it has or adding one at the end. ok is false
+// if data has rules of the user's own and no git3 section, and manage
+// isn't set. The LFS rules git3 adds don't count as the user's.
+func updateAttributes(data []byte, section string, manage bool) (updated []byte, ok bool) {
+	if updated, ok := replaceSection(data, attributesBegin, attributesEnd, section); ok {
+		return updated, true
+	}
+	if !manage {
+		for _, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			if line != "" && !strings.HasPrefix(line, "#") && !strings.Contains(line, "filter=lfs") {
+				return data, false
+			}
+		}
+	}
+	return appendSection(data, section), true
+}
+
+// replaceSection replaces the section of data from the begin line to the
+// end line with section. ok is false if data has no such section.
+func replaceSection(data []byte, begin, end, section string) (updated []byte, ok bool) {
+	i := bytes.Index(data, []byte(begin))
+	if i < 0 {
+		return data, false
+	}
+	j := bytes.Index(data[i:], []byte(end))
+	if j < 0 {
+		return data, false
+	}
+	j += i + len(end)
+	if j < len(data) && data[j] == '\n' {
+		j++
+	}
+	return append(append(bytes.Clone(data[:i]), section...), data[j:]...), true
+}
+
+// appendSection adds section at the end of data, on a line of its own.
+func appendSection(data []byte, section string) []byte {
+	updated := bytes.Clone(data)
+	if len(updated) > 0 && updated[len(updated)-1] != '\n' {
+		updated = append(updated, '\n')
+	}
+	return append(updated, section...)
+}
+
+// UpdateAttributes brings the git3 section of the served directory's
+// .gitattributes in line with the configuration and, if that changed the
+// file, triggers a sync to commit it, so it is committed once rather than
+// rewritten with every start. Run it after pulling, so the commit builds
+// on the remote's latest. The Syncer commits object content byte for
+// byte whatever the attributes say; they only tell git clients how to
+// check files in and diff them. A replica leaves the file alone.
+func (gs *Syncer) UpdateAttributes() error {
+	if gs.attributes == "" || gs.attributes == AttributesOff || gs.isReplica() {
+		return nil
+	}
+	file := filepath.Join(gs.dir, filepath.FromSlash(gs.subdir), ".gitattributes")
+	data, err := os.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	section := attributesSection(gs.binaryPatterns, gs.textPatterns)
+	updated, ok := updateAttributes(data, section, gs.attributes == AttributesManage)
+	if !ok {
+		log.Printf("[git] .gitattributes has rules of its own, leaving it alone; use the manage mode to add git3's")
+		return nil
+	}
+	if bytes.Equal(updated, data) {
+		return nil
+	}
+	if err := os.WriteFile(file, updated, 0644); err != nil {
+		return err
+	}
+	log.Println("[git] updated .gitattributes")
+	gs.Trigger(".gitattributes")
+	return nil
+}
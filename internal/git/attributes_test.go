@@ -0,0 +1,89 @@
+package git
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUpdateAttributes(t *testing.T) {
+	section := attributesSection([]string{"*.png"}, []string{"*.md"})
+	lfs := "*.mp4 filter=lfs diff=lfs merge=lfs -text\n"
+
+	tests := []struct {
+		name   string
+		data   string
+		manage bool
+		want   string
+		ok     bool
+	}{
+		{"new file", "", false, section, true},
+		{"lfs rules only", lfs, false, lfs + section, true},
+		{"user rules", "*.txt text\n", false, "*.txt text\n", false},
+		{"user rules, managed", "*.txt text", true, "*.txt text\n" + section, true},
+		{"section replaced in place",
+			"# mine\n" + attributesSection([]string{"*.gif"}, nil) + "*.txt text\n", false,
+			"# mine\n" + section + "*.txt text\n", true},
+	}
+	for _, tt := range tests {
+		got, ok := updateAttributes([]byte(tt.data), section, tt.manage)
+		if string(got) != tt.want || ok != tt.ok {
+			t.Errorf("%s: got %q, %v; want %q, %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+	if want := "# BEGIN git3: managed, changes between these lines are replaced\n*.png -text -diff\n*.md text eol=lf\n# END git3\n"; section != want {
+		t.Errorf("section = %q, want %q", section, want)
+	}
+}
+
+func TestAttributesCommittedOnce(t *testing.T) {
+	cfg := Config{Dir: t.TempDir(), Branch: "main", User: "Test", Email: "test@test.com", Debounce: 10 * time.Millisecond,
+		Attributes: AttributesAuto, BinaryPatterns: []string{"*.png"}, TextPatterns: []string{"*.md"}}
+	syncer := New(cfg, mustInitRepo(t, cfg))
+	defer syncer.Close()
+
+	// CRLF endings stay as written, whatever the attributes say
+	crlf := "line one\r\nline two\r\n"
+	os.WriteFile(filepath.Join(cfg.Dir, "windows.md"), []byte(crlf), 0644)
+	if err := syncer.UpdateAttributes(); err != nil {
+		t.Fatal(err)
+	}
+	if result := syncer.Sync(); result.Err != nil {
+		t.Fatal(result.Err)
+	}
+	head, _ := syncer.repo.Head()
+	commit, _ := syncer.repo.CommitObject(head.Hash())
+	file, err := commit.File(".gitattributes")
+	if err != nil {
+		t.Fatalf(".gitattributes not committed: %v", err)
+	}
+	if content, _ := file.Contents(); !strings.Contains(content, "*.png -text -diff") {
+		t.Fatalf(".gitattributes = %q", content)
+	}
+	file, _ = commit.File("windows.md")
+	r, _ := file.Reader()
+	data, _ := io.ReadAll(r)
+	r.Close()
+	if string(data) != crlf {
+		t.Fatalf("committed %q, want %q byte for byte", data, crlf)
+	}
+
+	// Unchanged, it isn't written or committed again
+	if err := syncer.UpdateAttributes(); err != nil {
+		t.Fatal(err)
+	}
+	if syncer.Status().PendingTrigger {
+		t.Fatal("unchanged .gitattributes triggered a sync")
+	}
+
+	// A file of the user's own is left alone in auto mode
+	user := "*.txt text\n"
+	os.WriteFile(filepath.Join(cfg.Dir, ".gitattributes"), []byte(user), 0644)
+	syncer.UpdateAttributes()
+	if data, _ := os.ReadFile(filepath.Join(cfg.Dir, ".gitattributes")); string(data) != user {
+		t.Fatalf("user-managed .gitattributes rewritten: %q", data)
+	}
+}
@@ -0,0 +1,252 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+// Conflict policies, for Config.ConflictPolicy: what a pull does when the
+// branch and the remote have both moved on.
+const (
+	// ConflictsFail leaves diverged histories for someone to merge with
+	// git; until then every pull and push fails. This is the default.
+	ConflictsFail = "fail"
+	// ConflictsManual merges the remote's changes in. A text file changed
+	// on both sides is merged line by line; if the changes overlap, or
+	// the file isn't text, it keeps the local version, and the remote's
+	// is written next to it under ConflictsDir for the user to resolve.
+	ConflictsManual = "manual"
+)
+
+// ConflictsDir is where ConflictsManual leaves the remote's version of a
+// conflicting file, at "<ConflictsDir>/<key>.<short commit hash>" within
+// the served subdirectory.
+const ConflictsDir = ".conflicts"
+
+// ConflictNotifier is a ChangeNotifier that is also told about conflicts
+// a merging pull left to resolve: the paths kept at the local version and,
+// in the same order, where their remote versions went.
+type ConflictNotifier interface {
+	Conflicted(hash string, paths, copies []string)
+}
+
+// errUnrelatedHistories is returned when the branch and the remote share
+// no commit to merge from.
+var errUnrelatedHistories = errors.New("refusing to merge unrelated histories")
+
+// mergeLocked merges origin/<pull branch> into a branch that has diverged
+// from it, as ConflictsManual describes, and checks the result out. It
+// returns the conflicting paths and where their remote versions went.
+// Caller must hold gs.mu.
+func (gs *Syncer) mergeLocked() (paths, copies []string, err error) {
+	target, err := gs.repo.Reference(plumbing.NewRemoteReferenceName("origin", gs.pullBranch), true)
+	if err != nil {
+		return nil, nil, err
+	}
+	head, err := gs.repo.Head()
+	if err != nil {
+		return nil, nil, err
+	}
+	local, err := gs.repo.CommitObject(head.Hash())
+	if err != nil {
+		return nil, nil, err
+	}
+	remote, err := gs.repo.CommitObject(target.Hash())
+	if err != nil {
+		return nil, nil, err
+	}
+	bases, err := local.MergeBase(remote)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(bases) == 0 {
+		return nil, nil, errUnrelatedHistories
+	}
+
+	baseFiles, err := commitFiles(bases[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	localFiles, err := commitFiles(local)
+	if err != nil {
+		return nil, nil, err
+	}
+	remoteFiles, err := commitFiles(remote)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Start from the remote's tree and replay what changed locally
+	merged := remoteFiles
+	var automerged []string
+	for _, p := range changedFiles(baseFiles, localFiles) {
+		l, inLocal := localFiles[p]
+		r, inRemote := remoteFiles[p]
+		b, inBase := baseFiles[p]
+		switch {
+		case inRemote == inBase && r == b:
+			// Only changed here
+		case inRemote == inLocal && r == l:
+			continue // the same change on both sides
+		case inRemote && inLocal && inBase:
+			if entry, ok := gs.mergeText(b, l, r); ok {
+				merged[p] = entry
+				automerged = append(automerged, p)
+				continue
+			}
+			fallthrough
+		case inRemote:
+			theirs := gs.conflictCopy(p, remote.Hash)
+			merged[theirs] = r
+			paths, copies = append(paths, p), append(copies, theirs)
+		}
+		if inLocal {
+			merged[p] = l
+		} else {
+			delete(merged, p)
+		}
+	}
+
+	tree, err := writeTree(gs.repo.Storer, merged)
+	if err != nil {
+		return nil, nil, err
+	}
+	msg := fmt.Sprintf("merge origin/%s: %s", gs.pullBranch, time.Now().Format("2006-01-02 15:04"))
+	if len(automerged) > 0 {
+		msg += "\n\nMerged line by line:"
+		for _, p := range automerged {
+			msg += "\n\t" + p
+		}
+	}
+	if len(paths) > 0 {
+		msg += "\n\nConflicts, kept here with the remote version alongside:\n"
+		for i, p := range paths {
+			msg += fmt.Sprintf("\t%s -> %s\n", p, copies[i])
+		}
+	}
+	author, committer := gs.signatures()
+	commit := &object.Commit{
+		Author:       author,
+		Committer:    committer,
+		Message:      msg,
+		TreeHash:     tree,
+		ParentHashes: []plumbing.Hash{local.Hash, remote.Hash},
+	}
+	obj := gs.repo.Storer.NewEncodedObject()
+	if err := commit.Encode(obj); err != nil {
+		return nil, nil, err
+	}
+	hash, err := gs.repo.Storer.SetEncodedObject(obj)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	wt, err := gs.repo.Worktree()
+	if err != nil {
+		return nil, nil, err
+	}
+	// Fails, leaving the branch alone, while there are uncommitted changes
+	if err := wt.Reset(&gogit.ResetOptions{Mode: gogit.MergeReset, Commit: hash}); err != nil {
+		return nil, nil, err
+	}
+	for _, p := range automerged {
+		log.Printf("[git] auto-merged %s, changed here and on origin/%s", p, gs.pullBranch)
+	}
+	for i, p := range paths {
+		log.Printf("[git] CONFLICT: %s changed here and on origin/%s; kept this version, the remote one is at %s", p, gs.pullBranch, copies[i])
+	}
+	return paths, copies, nil
+}
+
+// conflictCopy is where the remote's version of the conflicting file p,
+// from commit, goes.
+func (gs *Syncer) conflictCopy(p string, commit plumbing.Hash) string {
+	key := strings.TrimPrefix(p, gs.subdir+"/")
+	if gs.subdir == "" {
+		key = p
+	}
+	return path.Join(gs.subdir, ConflictsDir, key+"."+commit.String()[:7])
+}
+
+// commitFiles maps the path of every file in c's tree to its entry.
+func commitFiles(c *object.Commit) (map[string]object.TreeEntry, error) {
+	tree, err := c.Tree()
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]object.TreeEntry)
+	err = tree.Files().ForEach(func(f *object.File) error {
+		files[f.Name] = object.TreeEntry{Name: path.Base(f.Name), Mode: f.Mode, Hash: f.Hash}
+		return nil
+	})
+	return files, err
+}
+
+// changedFiles lists, sorted, the paths whose entries differ between from
+// and to, including those only one of them has.
+func changedFiles(from, to map[string]object.TreeEntry) []string {
+	var paths []string
+	for p, e := range from {
+		if other, ok := to[p]; !ok || other != e {
+			paths = append(paths, p)
+		}
+	}
+	for p := range to {
+		if _, ok := from[p]; !ok {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// writeTree stores the trees holding files, keyed by path, and returns
+// the hash of the root one.
+func writeTree(s storer.EncodedObjectStorer, files map[string]object.TreeEntry) (plumbing.Hash, error) {
+	var entries []object.TreeEntry
+	dirs := make(map[string]map[string]object.TreeEntry)
+	for p, e := range files {
+		dir, rest, nested := strings.Cut(p, "/")
+		if !nested {
+			e.Name = p
+			entries = append(entries, e)
+			continue
+		}
+		if dirs[dir] == nil {
+			dirs[dir] = make(map[string]object.TreeEntry)
+		}
+		dirs[dir][rest] = e
+	}
+	for dir, sub := range dirs {
+		hash, err := writeTree(s, sub)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		entries = append(entries, object.TreeEntry{Name: dir, Mode: filemode.Dir, Hash: hash})
+	}
+	// Git orders a directory as if its name ended in "/"
+	sortName := func(e object.TreeEntry) string {
+		if e.Mode == filemode.Dir {
+			return e.Name + "/"
+		}
+		return e.Name
+	}
+	sort.Slice(entries, func(i, j int) bool { return sortName(entries[i]) < sortName(entries[j]) })
+
+	obj := s.NewEncodedObject()
+	if err := (&object.Tree{Entries: entries}).Encode(obj); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return s.SetEncodedObject(obj)
+}
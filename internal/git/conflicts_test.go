@@ -0,0 +1,90 @@
+package git
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+)
+
+type conflictRecorder struct {
+	recordingNotifier
+	mu            sync.Mutex
+	paths, copies []string
+}
+
+func (n *conflictRecorder) Conflicted(hash string, paths, copies []string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.paths, n.copies = append(n.paths, paths...), append(n.copies, copies...)
+}
+
+// divergedSyncer returns a syncer whose commit of a.md and c.md diverges
+// from the remote's of a.md and b.md, and the remote's commit.
+func divergedSyncer(t *testing.T, policy string, notifier ChangeNotifier) (*Syncer, Config, string) {
+	t.Helper()
+	remote := newRemote(t, map[string]string{"a.md": "base", "same.md": "base"})
+	cfg := Config{Dir: t.TempDir(), Repo: remote, Branch: "main", User: "Test", Email: "test@test.com",
+		ConflictPolicy: policy, Notifier: notifier}
+	syncer := New(cfg, mustInitRepo(t, cfg))
+
+	theirs := pushFiles(t, remote, filepath.Join(t.TempDir(), "other"), map[string]string{
+		"a.md": "theirs", "b.md": "theirs", "same.md": "both",
+	})
+	for name, content := range map[string]string{"a.md": "ours", "c.md": "ours", "same.md": "both"} {
+		os.WriteFile(filepath.Join(cfg.Dir, name), []byte(content), 0644)
+	}
+	return syncer, cfg, theirs.String()[:7]
+}
+
+func TestConflictsManual(t *testing.T) {
+	notifier := &conflictRecorder{}
+	syncer, cfg, short := divergedSyncer(t, ConflictsManual, notifier)
+	if result := syncer.Sync(); result.Err != nil || !result.Pushed {
+		t.Fatalf("sync = %+v", result)
+	}
+
+	theirsCopy := ".conflicts/a.md." + short
+	for name, want := range map[string]string{"a.md": "ours", "b.md": "theirs", "c.md": "ours", "same.md": "both", theirsCopy: "theirs"} {
+		if got, _ := os.ReadFile(filepath.Join(cfg.Dir, name)); string(got) != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	tree := remoteTree(t, cfg.Repo, "main")
+	if f, err := tree.File(theirsCopy); err != nil {
+		t.Fatalf("conflict copy not pushed: %v", err)
+	} else if content, _ := f.Contents(); content != "theirs" {
+		t.Fatalf("pushed conflict copy = %q", content)
+	}
+	if !slices.Equal(notifier.paths, []string{"a.md"}) || !slices.Equal(notifier.copies, []string{theirsCopy}) {
+		t.Fatalf("notified conflicts %v -> %v", notifier.paths, notifier.copies)
+	}
+
+	// Resolving is an ordinary write and delete
+	os.WriteFile(filepath.Join(cfg.Dir, "a.md"), []byte("resolved"), 0644)
+	os.Remove(filepath.Join(cfg.Dir, filepath.FromSlash(theirsCopy)))
+	syncer.Trigger("a.md", theirsCopy)
+	if result := syncer.Sync(); result.Err != nil || result.Files != 2 {
+		t.Fatalf("resolving sync = %+v", result)
+	}
+	tree = remoteTree(t, cfg.Repo, "main")
+	if f, _ := tree.File("a.md"); f == nil {
+		t.Fatal("a.md missing after resolving")
+	} else if content, _ := f.Contents(); content != "resolved" {
+		t.Fatalf("a.md = %q after resolving", content)
+	}
+	if _, err := tree.File(theirsCopy); err == nil {
+		t.Fatal("conflict copy still committed after resolving")
+	}
+}
+
+func TestConflictsFail(t *testing.T) {
+	syncer, cfg, _ := divergedSyncer(t, "", nil)
+	if result := syncer.Sync(); result.Err == nil || result.Pushed {
+		t.Fatalf("sync of diverged branches = %+v", result)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.Dir, "b.md")); !os.IsNotExist(err) {
+		t.Fatal("the remote's changes were merged")
+	}
+}
@@ -0,0 +1,297 @@
+package git
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/utils/diff"
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// maxDiffSize is the largest version Diff compares line by line; bigger
+// files are reported like binary ones.
+const maxDiffSize = 1 << 20
+
+// diffContext is the number of unchanged lines around each hunk.
+const diffContext = 3
+
+// Worktree names the working tree in place of a revision.
+const Worktree = "worktree"
+
+// ErrUnknownRevision is returned for a revision that doesn't resolve.
+var ErrUnknownRevision = errors.New("unknown revision")
+
+// FileDiff is the difference between two versions of one file.
+type FileDiff struct {
+	Key    string `json:"key"`
+	From   string `json:"from"` // commit hash
+	To     string `json:"to"`   // commit hash, or Worktree
+	Status string `json:"status"`
+	Binary bool   `json:"binary,omitempty"` // or too large to compare by line
+	Hunks  []Hunk `json:"hunks,omitempty"`
+}
+
+// File statuses reported in FileDiff.Status.
+const (
+	DiffAdded     = "added"
+	DiffDeleted   = "deleted"
+	DiffModified  = "modified"
+	DiffUnchanged = "unchanged"
+)
+
+// Hunk is one run of changes with its surrounding context. Lines start
+// with ' ', '-' or '+' as in a unified diff.
+type Hunk struct {
+	FromLine  int      `json:"from_line"`
+	FromCount int      `json:"from_count"`
+	ToLine    int      `json:"to_line"`
+	ToCount   int      `json:"to_count"`
+	Lines     []string `json:"lines"`
+}
+
+// Diff compares key, a path relative to the served directory, between the
+// from and to revisions. to may be Worktree, or empty for the same. A key
+// missing from one side shows up as added or deleted.
+func (gs *Syncer) Diff(key, from, to string) (*FileDiff, error) {
+	if to == "" {
+		to = Worktree
+	}
+	if !validDiffKey(key) {
+		return nil, fmt.Errorf("invalid key %q", key)
+	}
+	if gs.repo == nil {
+		return nil, fmt.Errorf("%w %q", ErrUnknownRevision, from)
+	}
+	name := path.Join(gs.subdir, key)
+
+	// Reading the working tree must not overlap a branch switch
+	if to == Worktree {
+		gs.tree.RLock()
+		defer gs.tree.RUnlock()
+	}
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+
+	d := &FileDiff{Key: key}
+	fromHash, old, oldOK, err := gs.readRevision(from, name)
+	if err != nil {
+		return nil, err
+	}
+	d.From = fromHash.String()
+
+	var cur []byte
+	var curOK bool
+	if to == Worktree {
+		d.To = Worktree
+		cur, curOK, err = readWorktreeFile(filepath.Join(gs.dir, filepath.FromSlash(name)))
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		toHash, data, ok, err := gs.readRevision(to, name)
+		if err != nil {
+			return nil, err
+		}
+		d.To, cur, curOK = toHash.String(), data, ok
+	}
+
+	switch {
+	case !oldOK && !curOK:
+		return nil, fmt.Errorf("%s: %w", key, os.ErrNotExist)
+	case !oldOK:
+		d.Status = DiffAdded
+	case !curOK:
+		d.Status = DiffDeleted
+	case bytes.Equal(old, cur):
+		d.Status = DiffUnchanged
+		return d, nil
+	default:
+		d.Status = DiffModified
+	}
+	if isBinary(old) || isBinary(cur) {
+		d.Binary = true
+		return d, nil
+	}
+	d.Hunks = hunks(string(old), string(cur))
+	return d, nil
+}
+
+// validDiffKey rejects keys outside the served directory and keys into
+// git's or git3's own metadata, as the S3 handler does.
+func validDiffKey(key string) bool {
+	if key == "" || strings.HasPrefix(key, "/") {
+		return false
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == ".." || part == ".git" || part == recoveryDir {
+			return false
+		}
+	}
+	return true
+}
+
+// readWorktreeFile reads the file at fullPath; ok is false if it doesn't
+// exist. A file over maxDiffSize isn't read, as in readRevision.
+func readWorktreeFile(fullPath string) (data []byte, ok bool, err error) {
+	info, err := os.Stat(fullPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	if info.Size() > maxDiffSize {
+		return []byte{0}, true, nil
+	}
+	data, err = os.ReadFile(fullPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	}
+	return data, err == nil, err
+}
+
+// readRevision resolves rev and reads name from it; ok is false if the
+// file doesn't exist there. Caller must hold gs.mu.
+func (gs *Syncer) readRevision(rev, name string) (hash plumbing.Hash, data []byte, ok bool, err error) {
+	h, err := gs.repo.ResolveRevision(plumbing.Revision(rev))
+	if err != nil {
+		return hash, nil, false, fmt.Errorf("%w %q", ErrUnknownRevision, rev)
+	}
+	commit, err := gs.repo.CommitObject(*h)
+	if err != nil {
+		return hash, nil, false, fmt.Errorf("%w %q", ErrUnknownRevision, rev)
+	}
+	f, err := commit.File(name)
+	if errors.Is(err, object.ErrFileNotFound) {
+		return *h, nil, false, nil
+	} else if err != nil {
+		return hash, nil, false, err
+	}
+	if f.Size > maxDiffSize {
+		// Too large to diff; a NUL marks it binary without reading it
+		return *h, []byte{0}, true, nil
+	}
+	contents, err := f.Contents()
+	if err != nil {
+		return hash, nil, false, err
+	}
+	return *h, []byte(contents), true, nil
+}
+
+// isBinary uses git's heuristic: a NUL byte in the first 8000 bytes. Files
+// over maxDiffSize count as binary too.
+func isBinary(data []byte) bool {
+	return len(data) > maxDiffSize || bytes.IndexByte(data[:min(len(data), 8000)], 0) >= 0
+}
+
+// line is one line of a line diff, with its ' ', '-' or '+' prefix.
+type line struct {
+	op   byte
+	text string
+}
+
+// hunks diffs two texts by line and groups the changes into hunks with
+// diffContext lines of context, as a unified diff does.
+func hunks(old, cur string) []Hunk {
+	var lines []line
+	for _, d := range diff.Do(old, cur) {
+		op := byte(' ')
+		switch d.Type {
+		case diffmatchpatch.DiffDelete:
+			op = '-'
+		case diffmatchpatch.DiffInsert:
+			op = '+'
+		}
+		for _, text := range strings.SplitAfter(d.Text, "\n") {
+			if text != "" {
+				lines = append(lines, line{op, text})
+			}
+		}
+	}
+
+	// fromLine[i] and toLine[i] number lines[i] on each side, or the
+	// line before it on a side it isn't part of
+	fromLine := make([]int, len(lines)+1)
+	toLine := make([]int, len(lines)+1)
+	for i, l := range lines {
+		fromLine[i+1], toLine[i+1] = fromLine[i], toLine[i]
+		if l.op != '+' {
+			fromLine[i+1]++
+		}
+		if l.op != '-' {
+			toLine[i+1]++
+		}
+	}
+
+	var result []Hunk
+	for i := 0; i < len(lines); i++ {
+		if lines[i].op == ' ' {
+			continue
+		}
+		// Extend the hunk while the next change is close enough for the
+		// context around both to touch
+		start, end := max(0, i-diffContext), i+1
+		for j := i + 1; j < len(lines) && j <= end+2*diffContext; j++ {
+			if lines[j].op != ' ' {
+				end = j + 1
+			}
+		}
+		end = min(len(lines), end+diffContext)
+
+		h := Hunk{FromLine: fromLine[start] + 1, ToLine: toLine[start] + 1}
+		for _, l := range lines[start:end] {
+			h.Lines = append(h.Lines, string(l.op)+l.text)
+		}
+		h.FromCount = fromLine[end] - fromLine[start]
+		h.ToCount = toLine[end] - toLine[start]
+		result = append(result, h)
+		i = end - 1
+	}
+	return result
+}
+
+// Unified renders the diff in the unified format of diff -u and git diff.
+func (d *FileDiff) Unified() string {
+	var b strings.Builder
+	fromName, toName := "a/"+d.Key, "b/"+d.Key
+	if d.Status == DiffAdded {
+		fromName = "/dev/null"
+	}
+	if d.Status == DiffDeleted {
+		toName = "/dev/null"
+	}
+	switch {
+	case d.Status == DiffUnchanged:
+		return ""
+	case d.Binary:
+		fmt.Fprintf(&b, "Binary files %s and %s differ\n", fromName, toName)
+		return b.String()
+	}
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", fromName, toName)
+	for _, h := range d.Hunks {
+		fmt.Fprintf(&b, "@@ -%s +%s @@\n", hunkRange(h.FromLine, h.FromCount), hunkRange(h.ToLine, h.ToCount))
+		for _, l := range h.Lines {
+			b.WriteString(l)
+			if !strings.HasSuffix(l, "\n") {
+				b.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+	}
+	return b.String()
+}
+
+func hunkRange(start, count int) string {
+	if count == 0 {
+		start-- // an empty range names the line before it
+	}
+	if count == 1 {
+		return fmt.Sprint(start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
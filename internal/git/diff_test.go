@@ -0,0 +1,99 @@
+package git
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	cfg := Config{Dir: t.TempDir(), Branch: "main", User: "Test", Email: "test@test.com"}
+	syncer := New(cfg, mustInitRepo(t, cfg))
+	write := func(name, content string) {
+		os.WriteFile(filepath.Join(cfg.Dir, name), []byte(content), 0644)
+	}
+
+	write("a.md", "one\ntwo\nthree\n")
+	first, _ := syncer.Commit()
+	write("a.md", "one\n2\nthree\n")
+	write("b.md", "new\n")
+	second, _ := syncer.Commit()
+
+	d, err := syncer.Diff("a.md", first.String(), second.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "--- a/a.md\n+++ b/a.md\n@@ -1,3 +1,3 @@\n one\n-two\n+2\n three\n"
+	if d.Status != DiffModified || d.Unified() != want {
+		t.Fatalf("diff = %s %q, want modified %q", d.Status, d.Unified(), want)
+	}
+
+	// A key missing on one side is an add, not an error
+	d, err = syncer.Diff("b.md", first.String(), second.String())
+	if err != nil || d.Status != DiffAdded || !strings.Contains(d.Unified(), "--- /dev/null\n+++ b/b.md\n@@ -0,0 +1 @@\n+new\n") {
+		t.Fatalf("added diff = %+v, %v:\n%s", d, err, d.Unified())
+	}
+
+	// to defaults to the working tree
+	os.Remove(filepath.Join(cfg.Dir, "b.md"))
+	d, err = syncer.Diff("b.md", second.String(), "")
+	if err != nil || d.Status != DiffDeleted || d.To != Worktree {
+		t.Fatalf("worktree diff = %+v, %v; want deleted", d, err)
+	}
+
+	if _, err := syncer.Diff("a.md", "nope", ""); !errors.Is(err, ErrUnknownRevision) {
+		t.Fatalf("unknown revision err = %v", err)
+	}
+	if _, err := syncer.Diff("missing.md", first.String(), ""); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("missing key err = %v", err)
+	}
+	for _, key := range []string{"../secret", ".git/config", "notes/.git/config", ".git3/meta/a.md.json"} {
+		if _, err := syncer.Diff(key, first.String(), ""); err == nil {
+			t.Fatalf("Diff accepted %s, outside the vault", key)
+		}
+	}
+}
+
+func TestDiffBinary(t *testing.T) {
+	cfg := Config{Dir: t.TempDir(), Branch: "main", User: "Test", Email: "test@test.com"}
+	syncer := New(cfg, mustInitRepo(t, cfg))
+
+	path := filepath.Join(cfg.Dir, "cat.png")
+	os.WriteFile(path, []byte("\x89PNG\x00\x01"), 0644)
+	first, _ := syncer.Commit()
+	os.WriteFile(path, []byte("\x89PNG\x00\x02"), 0644)
+
+	d, err := syncer.Diff("cat.png", first.String(), Worktree)
+	if err != nil || !d.Binary || d.Unified() != "Binary files a/cat.png and b/cat.png differ\n" {
+		t.Fatalf("binary diff = %+v, %v", d, err)
+	}
+}
+
+func TestDiffLargeWorktreeFile(t *testing.T) {
+	cfg := Config{Dir: t.TempDir(), Branch: "main", User: "Test", Email: "test@test.com"}
+	syncer := New(cfg, mustInitRepo(t, cfg))
+
+	path := filepath.Join(cfg.Dir, "big.md")
+	os.WriteFile(path, []byte("small"), 0644)
+	first, _ := syncer.Commit()
+	os.WriteFile(path, bytes.Repeat([]byte("a\n"), maxDiffSize), 0644)
+
+	if d, err := syncer.Diff("big.md", first.String(), Worktree); err != nil || !d.Binary || d.Status != DiffModified {
+		t.Fatalf("diff against a file over maxDiffSize = %+v, %v", d, err)
+	}
+}
+
+func TestHunksMergeNearbyChanges(t *testing.T) {
+	old := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n14\n15\n16\n17\n18\n"
+	near := strings.Replace(strings.Replace(old, "2\n", "X\n", 1), "9\n", "Y\n", 1)
+	if h := hunks(old, near); len(h) != 1 || h[0].FromLine != 1 || h[0].FromCount != 12 {
+		t.Fatalf("changes six lines apart = %+v, want one hunk", h)
+	}
+	far := strings.Replace(strings.Replace(old, "2\n", "X\n", 1), "17\n", "Y\n", 1)
+	if h := hunks(old, far); len(h) != 2 || h[1].FromLine != 14 || h[1].FromCount != 5 {
+		t.Fatalf("changes far apart = %+v, want two hunks", h)
+	}
+}
@@ -0,0 +1,180 @@
+package git
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// drift counts the files in the served part of the working tree that
+// differ from HEAD.
+type drift struct {
+	noHead    bool // nothing has been committed yet
+	untracked int
+	modified  int // including files added to the index but not committed
+	deleted   int
+}
+
+// checkDrift compares the served part of the working tree with HEAD, so
+// InitRepo can report what an unclean shutdown left behind: changes not
+// committed yet, which the next sync commits, or files missing after a
+// pull that didn't finish.
+func checkDrift(repo *gogit.Repository, subdir string) (drift, error) {
+	var d drift
+	if _, err := repo.Head(); errors.Is(err, plumbing.ErrReferenceNotFound) {
+		d.noHead = true
+	} else if err != nil {
+		return d, err
+	}
+	err := walkDrift(repo, subdir, func(name string, kind driftKind) {
+		switch kind {
+		case driftUntracked:
+			d.untracked++
+		case driftDeleted:
+			d.deleted++
+		case driftModified:
+			d.modified++
+		}
+	})
+	return d, err
+}
+
+// driftKind is how a file in the working tree differs from HEAD.
+type driftKind int
+
+const (
+	driftUntracked driftKind = iota
+	driftModified
+	driftDeleted
+)
+
+// walkDrift calls fn for each file in the served part of the working tree
+// that differs from HEAD.
+func walkDrift(repo *gogit.Repository, subdir string, fn func(name string, kind driftKind)) error {
+	wt, err := repo.Worktree()
+	if err != nil {
+		return err
+	}
+	status, err := wt.Status()
+	if err != nil {
+		return fmt.Errorf("status failed: %w", err)
+	}
+	prefix := ""
+	if subdir != "" {
+		prefix = strings.Trim(subdir, "/") + "/"
+	}
+	for name, st := range status {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		switch {
+		case st.Worktree == gogit.Untracked:
+			fn(name, driftUntracked)
+		case st.Worktree == gogit.Deleted || st.Staging == gogit.Deleted:
+			fn(name, driftDeleted)
+		case st.Worktree != gogit.Unmodified || st.Staging != gogit.Unmodified:
+			fn(name, driftModified)
+		}
+	}
+	return nil
+}
+
+// commitDrift commits the untracked and modified files in the served part
+// of the working tree, for Config.CommitOnStartup, so the pull that
+// follows can't strand them. Files missing from the working tree are
+// left alone, since an interrupted pull leaves them so and committing
+// their deletion would push it, and so are files a secret scan would
+// hold, for the first sync to scan again. It returns plumbing.ZeroHash
+// if there was nothing to commit.
+func commitDrift(repo *gogit.Repository, cfg Config) (plumbing.Hash, error) {
+	var names []string
+	deleted := 0
+	err := walkDrift(repo, cfg.Subdir, func(name string, kind driftKind) {
+		if kind == driftDeleted {
+			deleted++
+		} else {
+			names = append(names, name)
+		}
+	})
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	if deleted > 0 {
+		log.Printf("[git] not committing the deletion of %d files missing from the working tree on startup", deleted)
+	}
+	if cfg.SecretScan != "" && cfg.SecretScan != SecretsOff {
+		names = withoutSecrets(cfg, names)
+	}
+	if len(names) == 0 {
+		return plumbing.ZeroHash, nil
+	}
+	sort.Strings(names)
+
+	wt, err := repo.Worktree()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	for _, name := range names {
+		if _, err := wt.Add(name); err != nil {
+			return plumbing.ZeroHash, fmt.Errorf("add failed: %w", err)
+		}
+	}
+	now := time.Now()
+	author := object.Signature{Name: cfg.User, Email: cfg.Email, When: now}
+	committer := object.Signature{Name: cmp.Or(cfg.Committer, cfg.User), Email: cmp.Or(cfg.CommitterEmail, cfg.Email), When: now}
+	hash, err := wt.Commit(syncMessagePrefix+now.Format("2006-01-02 15:04"), &gogit.CommitOptions{Author: &author, Committer: &committer})
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("commit failed: %w", err)
+	}
+	return hash, nil
+}
+
+// withoutSecrets returns those of names, paths in cfg.Dir, that the
+// secret scan finds nothing in.
+func withoutSecrets(cfg Config, names []string) []string {
+	rules := secretRulesFor(cfg.SecretPatterns)
+	allow := gitignore.NewMatcher(parseAllowPatterns(cfg.SecretAllow))
+	subdir := filepath.ToSlash(cfg.Subdir)
+	var clean []string
+	for _, name := range names {
+		rel := strings.TrimPrefix(name, subdir+"/")
+		data, err := os.ReadFile(filepath.Join(cfg.Dir, filepath.FromSlash(name)))
+		if err == nil && !allow.Match(strings.Split(rel, "/"), false) && !isBinary(data) && utf8.Valid(data) &&
+			len(scanSecrets(rel, string(data), rules)) > 0 {
+			log.Printf("[git] SECRET: %s looks like it holds a secret; not committing it on startup", rel)
+			continue
+		}
+		clean = append(clean, name)
+	}
+	return clean
+}
+
+// logDrift reports the working tree's drift from HEAD at startup.
+func logDrift(repo *gogit.Repository, subdir string) {
+	d, err := checkDrift(repo, subdir)
+	switch {
+	case err != nil:
+		log.Printf("[git] check working tree failed: %v", err)
+	case d.noHead && d.untracked+d.modified > 0:
+		log.Printf("[git] no commits yet, %d files in the working tree to commit", d.untracked+d.modified)
+	case d.untracked+d.modified+d.deleted == 0:
+		log.Println("[git] working tree matches HEAD")
+	default:
+		log.Printf("[git] working tree differs from HEAD: %d untracked, %d modified and %d deleted files not committed yet", d.untracked, d.modified, d.deleted)
+		if d.deleted > 0 {
+			log.Printf("[git] WARNING: %d files in HEAD are missing from the working tree, as an interrupted pull can leave it; restore them with git checkout before the next sync commits their deletion", d.deleted)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package git
+
+import (
+	"sync"
+	"time"
+)
+
+// eventHistory is how many sync events a Syncer remembers.
+const eventHistory = 100
+
+// maxEventPaths caps the paths kept per event; Files still counts them all.
+const maxEventPaths = 20
+
+// Event records one step of a sync: a commit, a pull or a push.
+type Event struct {
+	Time     time.Time     `json:"time"`
+	Op       string        `json:"op"`     // "commit", "pull" or "push"
+	Result   string        `json:"result"` // e.g. "committed", "up to date", "failed"
+	Hash     string        `json:"hash,omitempty"`
+	Files    int           `json:"files,omitempty"`
+	Paths    []string      `json:"paths,omitempty"` // the first maxEventPaths of Files
+	Error    string        `json:"error,omitempty"`
+	Output   string        `json:"output,omitempty"` // a hook's, its last maxHookOutput bytes
+	Duration time.Duration `json:"duration_ns"`
+}
+
+// withPaths sets Files and the capped Paths.
+func (e Event) withPaths(paths []string) Event {
+	e.Files = len(paths)
+	if len(paths) > maxEventPaths {
+		paths = paths[:maxEventPaths]
+	}
+	e.Paths = paths
+	return e
+}
+
+// eventLog is a fixed-size ring of the most recent events. It has its own
+// lock so readers never wait for a sync in progress.
+type eventLog struct {
+	mu     sync.Mutex
+	size   int
+	events []Event
+	next   int // once full, the slot of the oldest event
+}
+
+// add records e as having started at start and finished now.
+func (l *eventLog) add(e Event, start time.Time) {
+	e.Time = start
+	e.Duration = time.Since(start)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.events) < l.size {
+		l.events = append(l.events, e)
+		return
+	}
+	l.events[l.next] = e
+	l.next = (l.next + 1) % l.size
+}
+
+// list returns the recorded events, oldest first.
+func (l *eventLog) list() []Event {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	out := make([]Event, 0, len(l.events))
+	out = append(out, l.events[l.next:]...)
+	return append(out, l.events[:l.next]...)
+}
+
+// Events returns the most recent sync events, oldest first. Unlike Status
+// it never waits for a sync in progress.
+func (gs *Syncer) Events() []Event {
+	return gs.events.list()
+}
@@ -0,0 +1,55 @@
+package git
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEventLogKeepsMostRecent(t *testing.T) {
+	l := eventLog{size: 3}
+	for _, op := range []string{"a", "b", "c", "d", "e"} {
+		l.add(Event{Op: op}, time.Now())
+	}
+
+	events := l.list()
+	if len(events) != 3 || events[0].Op != "c" || events[1].Op != "d" || events[2].Op != "e" {
+		t.Fatalf("events = %+v, want c, d, e", events)
+	}
+}
+
+func TestSyncRecordsEvents(t *testing.T) {
+	remote := newRemote(t, map[string]string{"a.md": "a"})
+	cfg := Config{Dir: t.TempDir(), Repo: remote, Branch: "main", User: "Test", Email: "test@test.com"}
+	repo := mustInitRepo(t, cfg)
+	syncer := New(cfg, repo)
+
+	os.WriteFile(filepath.Join(cfg.Dir, "b.md"), []byte("b"), 0644)
+	syncer.doSync()
+	head, _ := repo.Head()
+
+	events := syncer.Events()
+	var ops []string
+	for _, e := range events {
+		ops = append(ops, e.Op+":"+e.Result)
+	}
+	if len(events) != 3 || ops[0] != "commit:committed" || ops[1] != "pull:up to date" || ops[2] != "push:pushed" {
+		t.Fatalf("events = %v, want commit, pull, push", ops)
+	}
+	if c := events[0]; c.Hash != head.Hash().String() || c.Files != 1 || c.Paths[0] != "b.md" {
+		t.Fatalf("commit event = %+v", c)
+	}
+	if p := events[2]; p.Hash != head.Hash().String() || p.Time.IsZero() {
+		t.Fatalf("push event = %+v", p)
+	}
+
+	// A failed push is recorded with its error
+	os.RemoveAll(remote)
+	os.WriteFile(filepath.Join(cfg.Dir, "c.md"), []byte("c"), 0644)
+	syncer.doSync()
+	events = syncer.Events()
+	if last := events[len(events)-1]; last.Op != "push" || last.Result != "failed" || last.Error == "" {
+		t.Fatalf("last event = %+v, want a failed push", last)
+	}
+}
@@ -0,0 +1,95 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// The sync exclusions in .git/info/exclude lie between these lines, so
+// they can be updated without touching the rest of the file.
+const (
+	excludeBegin = "# BEGIN git3 sync exclusions: managed, changes between these lines are replaced"
+	excludeEnd   = "# END git3 sync exclusions"
+)
+
+// CheckExcludes reports the first of prefixes that can't be excluded from
+// sync: prefixes are plain key prefixes, without glob characters.
+func CheckExcludes(prefixes []string) error {
+	for _, p := range prefixes {
+		if strings.TrimPrefix(p, "/") == "" || strings.ContainsAny(p, "*?[\\") || strings.Contains("/"+p+"/", "/../") {
+			return fmt.Errorf("invalid sync exclusion %q: must be a key prefix, such as cache/", p)
+		}
+	}
+	return nil
+}
+
+// excludePattern is the gitignore pattern matching the keys under prefix,
+// anchored at the served directory.
+func excludePattern(subdir, prefix string) string {
+	p := strings.TrimPrefix(prefix, "/")
+	if subdir != "" {
+		p = subdir + "/" + p
+	}
+	return "/" + p + "*"
+}
+
+// writeExcludes lists prefixes in .git/info/exclude, replacing those
+// listed before, so untracked files under them are never staged and
+// pulls treat them as ignored rather than as files in the way.
+func writeExcludes(dir, subdir string, prefixes []string) error {
+	file := filepath.Join(dir, ".git", "info", "exclude")
+	data, err := os.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	section := ""
+	if len(prefixes) > 0 {
+		var b strings.Builder
+		b.WriteString(excludeBegin + "\n")
+		for _, p := range prefixes {
+			b.WriteString(excludePattern(filepath.ToSlash(subdir), p) + "\n")
+		}
+		b.WriteString(excludeEnd + "\n")
+		section = b.String()
+	}
+	updated, ok := replaceSection(data, excludeBegin, excludeEnd, section)
+	if !ok {
+		if section == "" {
+			return nil
+		}
+		updated = appendSection(data, section)
+	}
+	if bytes.Equal(updated, data) {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(file, updated, 0644)
+}
+
+// excluded reports whether p, a path relative to the served directory, is
+// under one of the prefixes excluded from sync.
+func (gs *Syncer) excluded(p string) bool {
+	for _, prefix := range gs.exclude {
+		if strings.HasPrefix(p, strings.TrimPrefix(prefix, "/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// withoutExcluded returns paths without those excluded from sync, and
+// whether any change is left to sync. No paths at all stand for the whole
+// tree.
+func (gs *Syncer) withoutExcluded(paths []string) ([]string, bool) {
+	if len(paths) == 0 || len(gs.exclude) == 0 {
+		return paths, true
+	}
+	kept := slices.DeleteFunc(slices.Clone(paths), gs.excluded)
+	return kept, len(kept) > 0
+}
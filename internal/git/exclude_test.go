@@ -0,0 +1,76 @@
+package git
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExclude(t *testing.T) {
+	remote := newRemote(t, map[string]string{"vault/a.md": "a"})
+	cfg := Config{Dir: t.TempDir(), Repo: remote, Branch: "main", User: "Test", Email: "test@test.com", Debounce: time.Hour,
+		Subdir: "vault", Exclude: []string{"cache/"}}
+	syncer := New(cfg, mustInitRepo(t, cfg))
+	dir := filepath.Join(cfg.Dir, "vault")
+
+	data, _ := os.ReadFile(filepath.Join(cfg.Dir, ".git", "info", "exclude"))
+	if !strings.Contains(string(data), "\n/vault/cache/*\n") || !strings.Contains(string(data), stateDirPattern) {
+		t.Fatalf("info/exclude = %q", data)
+	}
+
+	// A write under the prefix doesn't schedule a sync
+	os.MkdirAll(filepath.Join(dir, "cache"), 0755)
+	os.WriteFile(filepath.Join(dir, "cache", "index.bin"), []byte("regenerable"), 0644)
+	syncer.Trigger("cache/index.bin")
+	if syncer.Status().PendingTrigger {
+		t.Fatal("excluded write scheduled a sync")
+	}
+
+	// Neither a triggered nor a full sync commits it
+	os.WriteFile(filepath.Join(dir, "b.md"), []byte("b"), 0644)
+	syncer.Trigger("b.md", "cache/index.bin")
+	if result := syncer.Sync(); result.Files != 1 || !result.Pushed {
+		t.Fatalf("sync = %+v", result)
+	}
+	syncer.Trigger()
+	syncer.Sync()
+	tree := remoteTree(t, remote, "main")
+	if _, err := tree.File("vault/b.md"); err != nil {
+		t.Fatal("b.md not pushed")
+	}
+	if _, err := tree.File("vault/cache/index.bin"); err == nil {
+		t.Fatal("excluded file committed")
+	}
+
+	// Pulls leave it in place
+	pushFiles(t, remote, t.TempDir(), map[string]string{"vault/c.md": "c"})
+	syncer.Pull()
+	if _, err := os.Stat(filepath.Join(dir, "c.md")); err != nil {
+		t.Fatalf("pull: %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "cache", "index.bin")); string(data) != "regenerable" {
+		t.Fatalf("excluded file = %q after a pull", data)
+	}
+
+	// Changing the prefixes replaces the section
+	if err := writeExcludes(cfg.Dir, cfg.Subdir, []string{"tmp"}); err != nil {
+		t.Fatal(err)
+	}
+	data, _ = os.ReadFile(filepath.Join(cfg.Dir, ".git", "info", "exclude"))
+	if strings.Contains(string(data), "cache") || strings.Count(string(data), excludeBegin) != 1 || !strings.Contains(string(data), "/vault/tmp*") {
+		t.Fatalf("info/exclude after a change = %q", data)
+	}
+}
+
+func TestCheckExcludes(t *testing.T) {
+	if err := CheckExcludes([]string{"cache/", ".trash", "/tmp/"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, bad := range []string{"", "/", "*.tmp", "../x", "a/../../b"} {
+		if err := CheckExcludes([]string{bad}); err == nil {
+			t.Errorf("CheckExcludes accepted %q", bad)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// ErrForcePushed is returned by a pull when the remote's pull branch no
+// longer contains what was last fetched from it: its history was
+// rewritten and force-pushed. Without Config.ResetOnForcePush every pull
+// fails with it until someone intervenes.
+var ErrForcePushed = errors.New("remote history was rewritten")
+
+// pullSpec is fetchSpec without the force flag, so a fetch of a rewritten
+// branch fails with gogit.ErrForceNeeded instead of quietly following it.
+func pullSpec(branch string) config.RefSpec {
+	return fetchSpec(branch)[1:]
+}
+
+// resetToRemoteLocked moves the branch onto origin/<pull branch>, as when
+// that was force-pushed; why says what happened, for the log. The old
+// head is kept as a backup branch, and the working tree is laid over the
+// new history: files only the remote has are added, files both have keep
+// their local content. The result is committed if it differs from the
+// remote. Caller must hold gs.mu.
+func (gs *Syncer) resetToRemoteLocked(why string) error {
+	start := time.Now()
+	target, err := gs.fetchBranch(gs.pullBranch)
+	if err != nil {
+		return err
+	}
+	if target == nil {
+		return fmt.Errorf("origin/%s disappeared", gs.pullBranch)
+	}
+	// Commit first, so the backup has everything written so far
+	gs.pendingAll = true
+	if _, err := gs.commitPendingLocked(); err != nil {
+		return err
+	}
+
+	backup := "(none)"
+	if head, err := gs.repo.Head(); err == nil {
+		name := plumbing.NewBranchReferenceName(fmt.Sprintf("git3-backup/%s-%s", gs.branch, start.UTC().Format("20060102T150405Z")))
+		if err := gs.repo.Storer.SetReference(plumbing.NewHashReference(name, head.Hash())); err != nil {
+			return fmt.Errorf("backup %s: %w", gs.branch, err)
+		}
+		backup = name.Short()
+	}
+
+	// A mixed reset moves the branch and index but keeps the files. A
+	// replica has nothing of its own to keep, so its files follow too.
+	wt, err := gs.repo.Worktree()
+	if err != nil {
+		return err
+	}
+	opts := &gogit.ResetOptions{Commit: target.Hash(), Mode: gogit.MixedReset}
+	if gs.replica {
+		opts.Mode = gogit.HardReset
+	}
+	if gs.subdir != "" {
+		err = wt.ResetSparsely(opts, []string{gs.subdir})
+	} else {
+		err = wt.Reset(opts)
+	}
+	if err != nil {
+		return fmt.Errorf("reset onto origin/%s: %w", gs.pullBranch, err)
+	}
+	if err := gs.restoreMissingLocked(target.Hash()); err != nil {
+		return fmt.Errorf("check out origin/%s: %w", gs.pullBranch, err)
+	}
+	log.Printf("[git] WARNING: %s; reset %s onto %s, the old history is kept in branch %s",
+		why, gs.branch, target.Hash(), backup)
+	gs.events.add(Event{Op: "pull", Result: "reset", Hash: target.Hash().String()}, start)
+
+	// The restored files were never triggered for
+	gs.pendingAll = true
+	_, err = gs.commitPendingLocked()
+	return err
+}
+
+// restoreMissingLocked writes out the regular files of commit, within the
+// served subdirectory, that are missing from the working tree. Caller must
+// hold gs.mu.
+func (gs *Syncer) restoreMissingLocked(commit plumbing.Hash) error {
+	c, err := gs.repo.CommitObject(commit)
+	if err != nil {
+		return err
+	}
+	tree, err := c.Tree()
+	if err != nil {
+		return err
+	}
+	return tree.Files().ForEach(func(f *object.File) error {
+		if gs.subdir != "" && !strings.HasPrefix(f.Name, gs.subdir+"/") {
+			return nil
+		}
+		if f.Mode != filemode.Regular && f.Mode != filemode.Executable {
+			return nil
+		}
+		path := filepath.Join(gs.dir, filepath.FromSlash(f.Name))
+		if _, err := os.Lstat(path); !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		mode, err := f.Mode.ToOSFileMode()
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		r, err := f.Reader()
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode.Perm())
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, r); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	})
+}
@@ -0,0 +1,110 @@
+package git
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// forcePush replaces main on remote with a single unrelated commit
+// containing files.
+func forcePush(t *testing.T, remote string, files map[string]string) {
+	t.Helper()
+	dir := t.TempDir()
+	repo, err := gogit.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.NewBranchReferenceName("main")))
+	repo.CreateRemote(&config.RemoteConfig{Name: "origin", URLs: []string{remote}})
+	for name, content := range files {
+		os.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+	}
+	wt, _ := repo.Worktree()
+	wt.AddGlob(".")
+	_, err = wt.Commit("rewritten", &gogit.CommitOptions{
+		Author: &object.Signature{Name: "Rewriter", Email: "rewriter@test", When: time.Now()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repo.Push(&gogit.PushOptions{RefSpecs: []config.RefSpec{"+refs/heads/main:refs/heads/main"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestForcePushFailsPullByDefault(t *testing.T) {
+	remote := newRemote(t, map[string]string{"a.md": "a"})
+	cfg := Config{Dir: t.TempDir(), Repo: remote, Branch: "main", User: "Test", Email: "test@test.com"}
+	syncer := New(cfg, mustInitRepo(t, cfg))
+	forcePush(t, remote, map[string]string{"b.md": "b"})
+
+	syncer.mu.Lock()
+	err := syncer.fetchLocked()
+	syncer.mu.Unlock()
+	if !errors.Is(err, ErrForcePushed) {
+		t.Fatalf("fetch after a force push = %v, want ErrForcePushed", err)
+	}
+	if ErrorClass(err) != "rejected" {
+		t.Fatalf("ErrorClass = %q, want rejected", ErrorClass(err))
+	}
+}
+
+func TestResetOnForcePush(t *testing.T) {
+	remote := newRemote(t, map[string]string{"a.md": "a", "shared.md": "old"})
+	cfg := Config{Dir: t.TempDir(), Repo: remote, Branch: "main", User: "Test", Email: "test@test.com", ResetOnForcePush: true}
+	repo := mustInitRepo(t, cfg)
+	syncer := New(cfg, repo)
+	os.WriteFile(filepath.Join(cfg.Dir, "local.md"), []byte("local"), 0644)
+	syncer.doSync()
+	oldHead, _ := repo.Head()
+
+	forcePush(t, remote, map[string]string{"b.md": "b", "shared.md": "remote"})
+	os.WriteFile(filepath.Join(cfg.Dir, "shared.md"), []byte("mine"), 0644)
+	syncer.doSync()
+
+	if st := syncer.Status(); st.LastPushError != "" || st.Pushes != 2 {
+		t.Fatalf("status = %+v, want the sync to push after the reset", st)
+	}
+	tree := remoteTree(t, remote, "main")
+	for name, want := range map[string]string{"a.md": "a", "b.md": "b", "local.md": "local", "shared.md": "mine"} {
+		f, err := tree.File(name)
+		if err != nil {
+			t.Fatalf("%s missing after the reset: %v", name, err)
+		}
+		if got, _ := f.Contents(); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	if got, _ := os.ReadFile(filepath.Join(cfg.Dir, "b.md")); string(got) != "b" {
+		t.Fatalf("b.md from the rewritten remote not checked out, got %q", got)
+	}
+
+	// The old history, with the change written before the reset, is kept
+	// in a backup branch
+	iter, _ := repo.Branches()
+	var backups []*plumbing.Reference
+	iter.ForEach(func(ref *plumbing.Reference) error {
+		if strings.HasPrefix(ref.Name().Short(), "git3-backup/main-") {
+			backups = append(backups, ref)
+		}
+		return nil
+	})
+	if len(backups) != 1 {
+		t.Fatalf("backup branches = %v, want one", backups)
+	}
+	backup, _ := repo.CommitObject(backups[0].Hash())
+	old, _ := repo.CommitObject(oldHead.Hash())
+	if ok, _ := old.IsAncestor(backup); !ok {
+		t.Fatalf("backup %s does not contain the old head %s", backup.Hash, old.Hash)
+	}
+}
@@ -0,0 +1,170 @@
+package git
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// Hook points, which are also the Op of the events hooks record.
+const (
+	HookPreCommit  = "pre-commit"
+	HookPostCommit = "post-commit"
+	HookPostPush   = "post-push"
+	HookPostPull   = "post-pull"
+)
+
+// Pre-commit failure policies, for Hooks.PreCommitFailure.
+const (
+	// HookAbort makes a failed pre-commit hook fail the sync: nothing is
+	// committed or pushed until a later sync's hook succeeds. This is the
+	// default.
+	HookAbort = "abort"
+	// HookContinue logs the failure and commits anyway.
+	HookContinue = "continue"
+)
+
+// DefaultHookTimeout is how long a hook may run when Hooks.Timeout is
+// zero.
+const DefaultHookTimeout = time.Minute
+
+// maxHookOutput caps the output kept in a hook's event; the log gets all
+// of it.
+const maxHookOutput = 4096
+
+// Hooks are shell commands, run with sh -c in the served directory, at
+// points of a sync. Each gets GIT3_HOOK, GIT3_BRANCH and GIT3_VAULT in its
+// environment; post-commit, post-push and post-pull also get GIT3_COMMIT,
+// and post-commit and post-pull get GIT3_CHANGED_FILES, a file listing
+// the paths the commit or pull changed, one per line. Their output goes
+// to the log and, capped, to the event each run records.
+type Hooks struct {
+	// PreCommit runs before every commit of the vault's changes, so it
+	// may change files for the commit to include.
+	PreCommit string
+	// PostCommit runs after a commit, and PostPush after a push that sent
+	// commits. PostPull runs after a pull that brought changes in.
+	PostCommit string
+	PostPush   string
+	PostPull   string
+	// Timeout bounds each run; zero means DefaultHookTimeout. A hook that
+	// runs over is killed and counts as failed.
+	Timeout time.Duration
+	// PreCommitFailure is HookAbort (the default) or HookContinue. Other
+	// hooks' failures are only logged.
+	PreCommitFailure string
+}
+
+// command returns the command configured for point.
+func (h Hooks) command(point string) string {
+	switch point {
+	case HookPreCommit:
+		return h.PreCommit
+	case HookPostCommit:
+		return h.PostCommit
+	case HookPostPush:
+		return h.PostPush
+	case HookPostPull:
+		return h.PostPull
+	}
+	return ""
+}
+
+// preCommitLocked runs the pre-commit hook, returning its error if the
+// policy is to abort. Caller must hold gs.mu.
+func (gs *Syncer) preCommitLocked() error {
+	if gs.hooks.PreCommit == "" {
+		return nil
+	}
+	err := gs.runHookLocked(HookPreCommit, plumbing.ZeroHash, nil)
+	// The hook may have written anywhere in the vault
+	gs.pendingAll = true
+	if err != nil && gs.hooks.PreCommitFailure != HookContinue {
+		return err
+	}
+	return nil
+}
+
+// runHookLocked runs the hook for point, if there is one, and records it
+// as an event. hash is the commit it is about, if any, and paths, when not
+// nil, are listed in the file GIT3_CHANGED_FILES names. Caller must hold
+// gs.mu.
+func (gs *Syncer) runHookLocked(point string, hash plumbing.Hash, paths []string) error {
+	command := gs.hooks.command(point)
+	if command == "" {
+		return nil
+	}
+	start := time.Now()
+	root := filepath.Join(gs.dir, filepath.FromSlash(gs.subdir))
+	env := append(os.Environ(), "GIT3_HOOK="+point, "GIT3_BRANCH="+gs.branch, "GIT3_VAULT="+root)
+	if !hash.IsZero() {
+		env = append(env, "GIT3_COMMIT="+hash.String())
+	}
+	if paths != nil {
+		list, err := writeChangedFiles(point, paths)
+		if err != nil {
+			log.Printf("[git] %s hook: %v", point, err)
+		} else {
+			defer os.Remove(list)
+			env = append(env, "GIT3_CHANGED_FILES="+list)
+		}
+	}
+
+	timeout := cmp.Or(gs.hooks.Timeout, DefaultHookTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Dir = root
+	cmd.Env = env
+	// Don't wait on children the hook left holding its output
+	cmd.WaitDelay = time.Second
+	out, err := cmd.CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("timed out after %s", timeout)
+	}
+	for line := range strings.Lines(string(out)) {
+		log.Printf("[git] %s hook: %s", point, strings.TrimRight(line, "\r\n"))
+	}
+
+	output := string(out)
+	if len(output) > maxHookOutput {
+		output = output[len(output)-maxHookOutput:]
+	}
+	event := Event{Op: point, Result: "ran", Output: output}
+	if !hash.IsZero() {
+		event.Hash = hash.String()
+	}
+	if err != nil {
+		log.Printf("[git] %s hook failed: %v", point, err)
+		event.Result, event.Error = "failed", err.Error()
+		gs.events.add(event, start)
+		return fmt.Errorf("%s hook failed: %w", point, err)
+	}
+	gs.events.add(event, start)
+	return nil
+}
+
+// writeChangedFiles writes paths, one per line, to a temporary file and
+// returns its name.
+func writeChangedFiles(point string, paths []string) (string, error) {
+	f, err := os.CreateTemp("", "git3-"+point+"-*.txt")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	for _, p := range paths {
+		if _, err := fmt.Fprintln(f, p); err != nil {
+			os.Remove(f.Name())
+			return "", err
+		}
+	}
+	return f.Name(), nil
+}
@@ -0,0 +1,99 @@
+package git
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHooks(t *testing.T) {
+	remote := newRemote(t, map[string]string{"a.md": "a"})
+	out := t.TempDir()
+	cfg := Config{Dir: t.TempDir(), Repo: remote, Branch: "main", User: "Test", Email: "test@test.com", Debounce: time.Hour,
+		Hooks: Hooks{
+			PreCommit:  "ls *.md > index.txt",
+			PostCommit: `echo "$GIT3_COMMIT" > ` + out + `/commit; cat "$GIT3_CHANGED_FILES" > ` + out + `/committed`,
+			PostPush:   `echo "$GIT3_HOOK $GIT3_COMMIT" > ` + out + `/push`,
+			PostPull:   `cat "$GIT3_CHANGED_FILES" > ` + out + `/pulled; echo pulled`,
+		}}
+	syncer := New(cfg, mustInitRepo(t, cfg))
+
+	os.WriteFile(filepath.Join(cfg.Dir, "b.md"), []byte("b"), 0644)
+	syncer.Trigger("b.md")
+	result := syncer.Sync()
+	if result.Err != nil || !result.Pushed {
+		t.Fatalf("sync = %+v", result)
+	}
+	if files := strings.Join(headFiles(t, syncer), ","); files != "a.md,b.md,index.txt" {
+		t.Fatalf("committed %s, want index.txt the pre-commit hook wrote", files)
+	}
+	read := func(name string) string {
+		data, _ := os.ReadFile(filepath.Join(out, name))
+		return strings.TrimSpace(string(data))
+	}
+	if got := read("commit"); got != result.Commit {
+		t.Errorf("post-commit GIT3_COMMIT = %q, want %s", got, result.Commit)
+	}
+	if got := read("committed"); got != "b.md\nindex.txt" {
+		t.Errorf("post-commit changed files = %q", got)
+	}
+	if got := read("push"); got != "post-push "+result.Commit {
+		t.Errorf("post-push got %q", got)
+	}
+
+	pushFiles(t, remote, t.TempDir(), map[string]string{"c.md": "c"})
+	syncer.doPull()
+	if got := read("pulled"); got != "c.md" {
+		t.Errorf("post-pull changed files = %q", got)
+	}
+	var pulled Event
+	for _, e := range syncer.Events() {
+		if e.Op == HookPostPull {
+			pulled = e
+		}
+	}
+	if pulled.Result != "ran" || pulled.Output != "pulled\n" {
+		t.Fatalf("post-pull event = %+v, want its output", pulled)
+	}
+}
+
+func TestPreCommitHookFailure(t *testing.T) {
+	for _, policy := range []string{HookAbort, HookContinue} {
+		t.Run(policy, func(t *testing.T) {
+			cfg := Config{Dir: t.TempDir(), Branch: "main", User: "Test", Email: "test@test.com",
+				Hooks: Hooks{PreCommit: "echo index broken >&2; exit 3", PreCommitFailure: policy}}
+			syncer := New(cfg, mustInitRepo(t, cfg))
+
+			os.WriteFile(filepath.Join(cfg.Dir, "a.md"), []byte("a"), 0644)
+			result := syncer.Sync()
+			if policy == HookAbort && (result.Err == nil || result.Commit != "") {
+				t.Fatalf("sync = %+v, want it aborted", result)
+			}
+			if policy == HookContinue && (result.Err != nil || result.Commit == "") {
+				t.Fatalf("sync = %+v, want it committed anyway", result)
+			}
+			events := syncer.Events()
+			if len(events) == 0 || events[0].Op != HookPreCommit || events[0].Result != "failed" ||
+				events[0].Output != "index broken\n" || !strings.Contains(events[0].Error, "exit status 3") {
+				t.Fatalf("events = %+v, want the failed hook with its output", events)
+			}
+		})
+	}
+}
+
+func TestHookTimeout(t *testing.T) {
+	cfg := Config{Dir: t.TempDir(), Branch: "main", User: "Test", Email: "test@test.com",
+		Hooks: Hooks{PreCommit: "sleep 10", Timeout: 100 * time.Millisecond}}
+	syncer := New(cfg, mustInitRepo(t, cfg))
+
+	os.WriteFile(filepath.Join(cfg.Dir, "a.md"), []byte("a"), 0644)
+	start := time.Now()
+	if result := syncer.Sync(); result.Err == nil || !strings.Contains(result.Err.Error(), "timed out") {
+		t.Fatalf("sync = %+v, want the hook timed out", result)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("sync took %s, the hook wasn't killed", elapsed)
+	}
+}
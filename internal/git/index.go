@@ -0,0 +1,84 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+)
+
+// staleLockAge is how old an index.lock must be before the syncer takes
+// it for the leftover of a crashed git process rather than one at work.
+var staleLockAge = 10 * time.Minute
+
+// errIndexLocked is returned by a sync that found another git process
+// working on the index.
+var errIndexLocked = errors.New("index is locked by another git process")
+
+// checkIndexLockLocked looks for the index.lock git leaves while it works
+// on the index. go-git ignores it, but git run by hand in the vault, or
+// one that crashed, doesn't: a recent lock makes the sync wait for the
+// next trigger, and a stale one is removed. Caller must hold gs.mu.
+func (gs *Syncer) checkIndexLockLocked() error {
+	lock := filepath.Join(gs.dir, ".git", "index.lock")
+	info, err := os.Stat(lock)
+	if err != nil {
+		return nil
+	}
+	if age := time.Since(info.ModTime()); age < staleLockAge {
+		return fmt.Errorf("%w (%s, %s old)", errIndexLocked, lock, age.Round(time.Second))
+	}
+	if err := os.Remove(lock); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove stale %s: %w", lock, err)
+	}
+	log.Printf("[git] removed stale index.lock, last touched %s", info.ModTime().Format(time.RFC3339))
+	return nil
+}
+
+// repairIndexLocked rebuilds an index that can't be read, as a crash while
+// go-git rewrote it leaves, from HEAD. The working files are left alone,
+// so the next commit records how they differ from HEAD. It reports
+// whether it repaired anything. Caller must hold gs.mu.
+func (gs *Syncer) repairIndexLocked() bool {
+	_, readErr := gs.repo.Storer.Index()
+	if readErr == nil {
+		return false
+	}
+	log.Printf("[git] INDEX CORRUPT: %v; rebuilding it from HEAD", readErr)
+	start := time.Now()
+	if err := gs.rebuildIndexLocked(); err != nil {
+		log.Printf("[git] INDEX REPAIR FAILED: %v", err)
+		gs.events.add(Event{Op: "repair", Result: "failed", Error: err.Error()}, start)
+		return false
+	}
+	log.Println("[git] INDEX REPAIRED")
+	gs.events.add(Event{Op: "repair", Result: "repaired", Error: readErr.Error()}, start)
+	gs.pendingAll = true
+	return true
+}
+
+// rebuildIndexLocked replaces the index with HEAD's tree. Caller must hold
+// gs.mu.
+func (gs *Syncer) rebuildIndexLocked() error {
+	if err := gs.repo.Storer.SetIndex(&index.Index{Version: 2}); err != nil {
+		return err
+	}
+	if _, err := gs.repo.Head(); errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return nil // nothing committed yet, so an empty index is right
+	}
+	wt, err := gs.repo.Worktree()
+	if err != nil {
+		return err
+	}
+	opts := &gogit.ResetOptions{Mode: gogit.MixedReset}
+	if gs.subdir != "" {
+		return wt.ResetSparsely(opts, []string{gs.subdir})
+	}
+	return wt.Reset(opts)
+}
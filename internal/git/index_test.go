@@ -0,0 +1,58 @@
+package git
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestStaleIndexLock(t *testing.T) {
+	cfg := Config{Dir: t.TempDir(), Branch: "main", User: "Test", Email: "test@test.com", Debounce: time.Hour}
+	syncer := New(cfg, mustInitRepo(t, cfg))
+	lock := filepath.Join(cfg.Dir, ".git", "index.lock")
+	os.WriteFile(filepath.Join(cfg.Dir, "a.md"), []byte("a"), 0644)
+
+	// A fresh lock belongs to a git at work
+	os.WriteFile(lock, nil, 0644)
+	if result := syncer.Sync(); !errors.Is(result.Err, errIndexLocked) || result.Commit != "" {
+		t.Fatalf("sync under a live lock = %+v", result)
+	}
+	if _, err := os.Stat(lock); err != nil {
+		t.Fatal("a live lock was removed")
+	}
+
+	// An old one was left by a crash
+	old := time.Now().Add(-2 * staleLockAge)
+	os.Chtimes(lock, old, old)
+	if result := syncer.Sync(); result.Err != nil || result.Files != 1 {
+		t.Fatalf("sync with a stale lock = %+v", result)
+	}
+	if _, err := os.Stat(lock); !os.IsNotExist(err) {
+		t.Fatal("stale lock left behind")
+	}
+}
+
+func TestCorruptIndexRepaired(t *testing.T) {
+	cfg := Config{Dir: t.TempDir(), Branch: "main", User: "Test", Email: "test@test.com", Debounce: time.Hour}
+	syncer := New(cfg, mustInitRepo(t, cfg))
+	os.WriteFile(filepath.Join(cfg.Dir, "a.md"), []byte("a"), 0644)
+	syncer.Sync()
+
+	// A crash while go-git rewrote the index
+	os.WriteFile(filepath.Join(cfg.Dir, ".git", "index"), []byte("DIRC\x00\x00"), 0644)
+	os.WriteFile(filepath.Join(cfg.Dir, "b.md"), []byte("b"), 0644)
+	syncer.Trigger("b.md")
+	result := syncer.Sync()
+	if result.Err != nil || result.Files != 1 {
+		t.Fatalf("sync with a corrupt index = %+v", result)
+	}
+	if got := headFiles(t, syncer); !slices.Equal(got, []string{"a.md", "b.md"}) {
+		t.Fatalf("repaired sync committed %v", got)
+	}
+	if events := syncer.Events(); !slices.ContainsFunc(events, func(e Event) bool { return e.Op == "repair" && e.Result == "repaired" }) {
+		t.Fatalf("no repair event in %+v", events)
+	}
+}
@@ -0,0 +1,54 @@
+package git
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// Instrumentation receives measurements of sync activity, so a metrics
+// backend can be plugged in without the Syncer knowing about it. Methods
+// are called with the sync lock held and must not block.
+type Instrumentation interface {
+	ObserveCommit(d time.Duration)
+	ObservePull(d time.Duration, err error)
+	ObservePush(d time.Duration, err error) // err is nil for a successful push
+	SetPending(pending bool)                // a triggered sync is waiting to run
+}
+
+type nopInstrumentation struct{}
+
+func (nopInstrumentation) ObserveCommit(time.Duration)      {}
+func (nopInstrumentation) ObservePull(time.Duration, error) {}
+func (nopInstrumentation) ObservePush(time.Duration, error) {}
+func (nopInstrumentation) SetPending(bool)                  {}
+
+// ErrLFSUpload wraps failures to upload LFS content before a push.
+var ErrLFSUpload = errors.New("lfs upload failed")
+
+// ErrorClass sorts a pull or push error into a coarse class for alerting:
+// "auth", "rejected", "not_found", "network", "lfs", "corrupt" or "other".
+func ErrorClass(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, transport.ErrAuthenticationRequired), errors.Is(err, transport.ErrAuthorizationFailed):
+		return "auth"
+	case errors.Is(err, gogit.ErrForceNeeded), errors.Is(err, gogit.ErrNonFastForwardUpdate),
+		err != nil && strings.Contains(err.Error(), "non-fast-forward update"):
+		return "rejected"
+	case errors.Is(err, transport.ErrRepositoryNotFound):
+		return "not_found"
+	case errors.As(err, &netErr):
+		return "network"
+	case errors.Is(err, ErrLFSUpload):
+		return "lfs"
+	case IsCorrupt(err):
+		return "corrupt"
+	default:
+		return "other"
+	}
+}
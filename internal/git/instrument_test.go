@@ -0,0 +1,89 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+type recordingInstrumentation struct {
+	mu                     sync.Mutex
+	commits, pulls, pushes int
+	pushErrs               []error
+	pending                []bool
+}
+
+func (r *recordingInstrumentation) ObserveCommit(time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.commits++
+}
+
+func (r *recordingInstrumentation) ObservePull(time.Duration, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pulls++
+}
+
+func (r *recordingInstrumentation) ObservePush(d time.Duration, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pushes++
+	if err != nil {
+		r.pushErrs = append(r.pushErrs, err)
+	}
+}
+
+func (r *recordingInstrumentation) SetPending(pending bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pending = append(r.pending, pending)
+}
+
+func TestInstrumentationObservesSync(t *testing.T) {
+	remote := newRemote(t, map[string]string{"a.md": "a"})
+	instr := &recordingInstrumentation{}
+	cfg := Config{Dir: t.TempDir(), Repo: remote, Branch: "main", User: "Test", Email: "test@test.com",
+		Instrumentation: instr, Debounce: time.Hour}
+	repo := mustInitRepo(t, cfg)
+	syncer := New(cfg, repo)
+
+	os.WriteFile(filepath.Join(cfg.Dir, "b.md"), []byte("b"), 0644)
+	syncer.Trigger()
+	syncer.doSync()
+	if instr.commits != 1 || instr.pulls != 1 || instr.pushes != 1 || len(instr.pushErrs) != 0 {
+		t.Fatalf("observed %+v, want one commit, pull and push", instr)
+	}
+	if len(instr.pending) != 2 || !instr.pending[0] || instr.pending[1] {
+		t.Fatalf("pending = %v, want set by Trigger and cleared by the sync", instr.pending)
+	}
+
+	os.RemoveAll(remote)
+	os.WriteFile(filepath.Join(cfg.Dir, "c.md"), []byte("c"), 0644)
+	syncer.doSync()
+	if len(instr.pushErrs) != 1 {
+		t.Fatalf("push errors = %v, want the failed push", instr.pushErrs)
+	}
+}
+
+func TestErrorClass(t *testing.T) {
+	for err, want := range map[error]string{
+		fmt.Errorf("push failed: %w", transport.ErrAuthenticationRequired): "auth",
+		fmt.Errorf("push failed: %w", transport.ErrRepositoryNotFound):     "not_found",
+		errors.New("non-fast-forward update: refs/heads/main"):             "rejected",
+		fmt.Errorf("%w: %w", ErrLFSUpload, errors.New("413")):              "lfs",
+		fmt.Errorf("add failed: %w", plumbing.ErrObjectNotFound):           "corrupt",
+		&os.PathError{Op: "open", Path: "x", Err: os.ErrNotExist}:          "other",
+	} {
+		if got := ErrorClass(err); got != want {
+			t.Errorf("ErrorClass(%v) = %q, want %q", err, got, want)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+// MaxLogLimit caps how many commits one Log call returns.
+const MaxLogLimit = 100
+
+// logDiffBudget bounds the time Log spends diffing trees for change
+// counts. Commits past the budget are listed without them.
+var logDiffBudget = time.Second
+
+// ErrInvalidHash is returned for a malformed commit hash argument.
+var ErrInvalidHash = errors.New("invalid commit hash")
+
+// LogEntry is one commit in the synced branch's history.
+type LogEntry struct {
+	Hash    string        `json:"hash"`
+	Author  string        `json:"author"`
+	Email   string        `json:"email"`
+	Time    time.Time     `json:"time"`
+	Message string        `json:"message"`
+	Changes *ChangeCounts `json:"changes,omitempty"` // nil if not computed
+}
+
+// ChangeCounts counts the files a commit changed relative to its first
+// parent.
+type ChangeCounts struct {
+	Added    int `json:"added"`
+	Modified int `json:"modified"`
+	Deleted  int `json:"deleted"`
+}
+
+// LogOptions selects a page of history.
+type LogOptions struct {
+	Limit  int    // at most MaxLogLimit; 0 means 20
+	Offset int    // commits to skip, newest first
+	Since  string // if set, stop at this commit (exclusive)
+}
+
+// Log lists the checked-out branch's commits, newest first. A repository
+// without commits has an empty history. Only the head is read under the
+// sync lock; the history is walked after it is released.
+func (gs *Syncer) Log(opts LogOptions) ([]LogEntry, error) {
+	if opts.Limit <= 0 {
+		opts.Limit = 20
+	}
+	opts.Limit = min(opts.Limit, MaxLogLimit)
+	var since plumbing.Hash
+	if opts.Since != "" {
+		if !plumbing.IsHash(opts.Since) {
+			return nil, fmt.Errorf("%w %q", ErrInvalidHash, opts.Since)
+		}
+		since = plumbing.NewHash(opts.Since)
+	}
+
+	entries := []LogEntry{}
+	repo, head, err := gs.headSnapshot()
+	if err != nil {
+		return nil, err
+	} else if repo == nil {
+		return entries, nil
+	}
+	commits, err := repo.Log(&gogit.LogOptions{From: head})
+	if err != nil {
+		return nil, err
+	}
+	defer commits.Close()
+
+	deadline := time.Now().Add(logDiffBudget)
+	skipped := 0
+	err = commits.ForEach(func(c *object.Commit) error {
+		if c.Hash == since || len(entries) == opts.Limit {
+			return storer.ErrStop
+		}
+		if skipped < opts.Offset {
+			skipped++
+			return nil
+		}
+		entry := LogEntry{
+			Hash:    c.Hash.String(),
+			Author:  c.Author.Name,
+			Email:   c.Author.Email,
+			Time:    c.Author.When,
+			Message: c.Message,
+		}
+		if time.Now().Before(deadline) {
+			if counts, err := changeCounts(c); err == nil {
+				entry.Changes = counts
+			}
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	return entries, err
+}
+
+// Versions counts the checked-out branch's commits that changed key, a
+// path relative to the served directory, including the one that added it
+// and any that deleted it. Changes not committed yet aren't counted. The
+// history is walked without holding the sync lock, and the counts are kept
+// until the head moves.
+func (gs *Syncer) Versions(key string) (int, error) {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains("/"+key+"/", "/../") {
+		return 0, fmt.Errorf("invalid key %q", key)
+	}
+	name := path.Join(gs.subdir, key)
+
+	repo, head, err := gs.headSnapshot()
+	if repo == nil || err != nil {
+		return 0, err
+	}
+	if n, ok := gs.versions.get(head, name); ok {
+		return n, nil
+	}
+	commits, err := repo.Log(&gogit.LogOptions{From: head, FileName: &name})
+	if err != nil {
+		return 0, err
+	}
+	defer commits.Close()
+
+	n := 0
+	err = commits.ForEach(func(*object.Commit) error {
+		n++
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	gs.versions.put(head, name, n)
+	return n, nil
+}
+
+// headSnapshot returns the repository and its head commit, read under the
+// sync lock, so its history can be walked after the lock is released:
+// commits never change once written. A repository
+// without commits is returned as nil.
+func (gs *Syncer) headSnapshot() (*gogit.Repository, plumbing.Hash, error) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	if gs.repo == nil {
+		return nil, plumbing.ZeroHash, nil
+	}
+	head, err := gs.repo.Head()
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return nil, plumbing.ZeroHash, nil
+	} else if err != nil {
+		return nil, plumbing.ZeroHash, err
+	}
+	return gs.repo, head.Hash(), nil
+}
+
+// maxCachedVersions bounds how many paths' counts versionCache keeps.
+const maxCachedVersions = 10000
+
+// versionCache keeps Versions' counts for one head commit, by path. A
+// count for another head replaces them all.
+type versionCache struct {
+	mu     sync.Mutex
+	head   plumbing.Hash
+	counts map[string]int
+}
+
+func (c *versionCache) get(head plumbing.Hash, name string) (int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if head != c.head {
+		return 0, false
+	}
+	n, ok := c.counts[name]
+	return n, ok
+}
+
+func (c *versionCache) put(head plumbing.Hash, name string, n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if head != c.head || len(c.counts) >= maxCachedVersions {
+		c.head, c.counts = head, make(map[string]int)
+	}
+	c.counts[name] = n
+}
+
+// changeCounts diffs a commit against its first parent, or against an
+// empty tree for a root commit.
+func changeCounts(c *object.Commit) (*ChangeCounts, error) {
+	tree, err := c.Tree()
+	if err != nil {
+		return nil, err
+	}
+	parentTree := &object.Tree{}
+	if c.NumParents() > 0 {
+		parent, err := c.Parent(0)
+		if err != nil {
+			return nil, err
+		}
+		if parentTree, err = parent.Tree(); err != nil {
+			return nil, err
+		}
+	}
+	changes, err := object.DiffTree(parentTree, tree)
+	if err != nil {
+		return nil, err
+	}
+
+	var counts ChangeCounts
+	for _, ch := range changes {
+		switch {
+		case ch.From.Name == "":
+			counts.Added++
+		case ch.To.Name == "":
+			counts.Deleted++
+		default:
+			counts.Modified++
+		}
+	}
+	return &counts, nil
+}
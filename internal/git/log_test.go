@@ -0,0 +1,104 @@
+package git
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLogEmptyRepo(t *testing.T) {
+	cfg := Config{Dir: t.TempDir(), Branch: "main"}
+	syncer := New(cfg, mustInitRepo(t, cfg))
+
+	entries, err := syncer.Log(LogOptions{})
+	if err != nil || entries == nil || len(entries) != 0 {
+		t.Fatalf("Log on a repo without commits = %v, %v; want an empty list", entries, err)
+	}
+}
+
+func TestLogPaging(t *testing.T) {
+	cfg := Config{Dir: t.TempDir(), Branch: "main", User: "Test", Email: "test@test.com"}
+	syncer := New(cfg, mustInitRepo(t, cfg))
+
+	var hashes []string // oldest first
+	for _, step := range []func(){
+		func() { os.WriteFile(filepath.Join(cfg.Dir, "a.md"), []byte("a"), 0644) },
+		func() {
+			os.WriteFile(filepath.Join(cfg.Dir, "a.md"), []byte("a2"), 0644)
+			os.WriteFile(filepath.Join(cfg.Dir, "b.md"), []byte("b"), 0644)
+		},
+		func() { os.Remove(filepath.Join(cfg.Dir, "b.md")) },
+	} {
+		step()
+		hash, err := syncer.Commit()
+		if err != nil {
+			t.Fatal(err)
+		}
+		hashes = append(hashes, hash.String())
+	}
+
+	entries, err := syncer.Log(LogOptions{})
+	if err != nil || len(entries) != 3 {
+		t.Fatalf("Log = %d entries, %v; want 3", len(entries), err)
+	}
+	if entries[0].Hash != hashes[2] || entries[0].Author != "Test" || entries[0].Message == "" {
+		t.Fatalf("newest entry = %+v", entries[0])
+	}
+	want := []ChangeCounts{{Deleted: 1}, {Added: 1, Modified: 1}, {Added: 1}}
+	for i, e := range entries {
+		if e.Changes == nil || *e.Changes != want[i] {
+			t.Errorf("%s changes = %+v, want %+v", e.Hash, e.Changes, want[i])
+		}
+	}
+
+	page, _ := syncer.Log(LogOptions{Limit: 1, Offset: 1})
+	if len(page) != 1 || page[0].Hash != hashes[1] {
+		t.Fatalf("Limit 1 Offset 1 = %+v, want the middle commit", page)
+	}
+	newer, _ := syncer.Log(LogOptions{Since: hashes[0]})
+	if len(newer) != 2 || newer[1].Hash != hashes[1] {
+		t.Fatalf("Since the first commit = %+v, want the two after it", newer)
+	}
+	if _, err := syncer.Log(LogOptions{Since: "nope"}); err == nil {
+		t.Fatal("Log accepted a malformed hash")
+	}
+}
+
+func TestVersions(t *testing.T) {
+	cfg := Config{Dir: t.TempDir(), Branch: "main", User: "Test", Email: "test@test.com", Subdir: "vault"}
+	syncer := New(cfg, mustInitRepo(t, cfg))
+	dir := filepath.Join(cfg.Dir, "vault")
+
+	if n, err := syncer.Versions("a.md"); err != nil || n != 0 {
+		t.Fatalf("Versions before any commit = %d, %v", n, err)
+	}
+	for _, step := range []func(){
+		func() { os.WriteFile(filepath.Join(dir, "a.md"), []byte("a"), 0644) },
+		func() { os.WriteFile(filepath.Join(dir, "b.md"), []byte("b"), 0644) },
+		func() { os.WriteFile(filepath.Join(dir, "a.md"), []byte("a2"), 0644) },
+		func() { os.Remove(filepath.Join(dir, "a.md")) },
+	} {
+		step()
+		if _, err := syncer.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for key, want := range map[string]int{"a.md": 3, "b.md": 1, "c.md": 0} {
+		if n, err := syncer.Versions(key); err != nil || n != want {
+			t.Errorf("Versions(%q) = %d, %v; want %d", key, n, err, want)
+		}
+	}
+	if _, err := syncer.Versions("../a.md"); err == nil {
+		t.Error("Versions accepted a key outside the served directory")
+	}
+
+	// Counts are kept for the head they were taken at
+	os.WriteFile(filepath.Join(dir, "b.md"), []byte("b2"), 0644)
+	if _, err := syncer.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := syncer.Versions("b.md"); err != nil || n != 2 {
+		t.Errorf("Versions(b.md) after another commit = %d, %v; want 2", n, err)
+	}
+}
@@ -0,0 +1,179 @@
+package git
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/utils/diff"
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// lfsPointerPrefix starts every Git LFS pointer file. Pointers are text,
+// but merging two of them line by line makes no sense.
+var lfsPointerPrefix = []byte("version https://git-lfs.github.com/spec/")
+
+// mergeText merges the changes to a file from base to ours and to theirs
+// line by line, storing the result. ok is false, and nothing is stored,
+// if any version isn't a plain text file or the changes overlap. Caller
+// must hold gs.mu.
+func (gs *Syncer) mergeText(base, ours, theirs object.TreeEntry) (merged object.TreeEntry, ok bool) {
+	if !base.Mode.IsRegular() || ours.Mode != base.Mode || theirs.Mode != base.Mode {
+		return merged, false
+	}
+	var texts [3]string
+	for i, hash := range []plumbing.Hash{base.Hash, ours.Hash, theirs.Hash} {
+		data, ok := gs.readText(hash)
+		if !ok {
+			return merged, false
+		}
+		texts[i] = data
+	}
+	result, ok := merge3(texts[0], texts[1], texts[2])
+	if !ok {
+		return merged, false
+	}
+
+	obj := gs.repo.Storer.NewEncodedObject()
+	obj.SetType(plumbing.BlobObject)
+	w, err := obj.Writer()
+	if err != nil {
+		return merged, false
+	}
+	if _, err := io.WriteString(w, result); err != nil {
+		return merged, false
+	}
+	if err := w.Close(); err != nil {
+		return merged, false
+	}
+	hash, err := gs.repo.Storer.SetEncodedObject(obj)
+	if err != nil {
+		return merged, false
+	}
+	return object.TreeEntry{Mode: base.Mode, Hash: hash}, true
+}
+
+// readText reads the blob hash if it is text: small enough to diff, free
+// of NULs, valid UTF-8 and not an LFS pointer. Caller must hold gs.mu.
+func (gs *Syncer) readText(hash plumbing.Hash) (string, bool) {
+	blob, err := gs.repo.BlobObject(hash)
+	if err != nil || blob.Size > maxDiffSize {
+		return "", false
+	}
+	r, err := blob.Reader()
+	if err != nil {
+		return "", false
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil || isBinary(data) || !utf8.Valid(data) || bytes.HasPrefix(data, lfsPointerPrefix) {
+		return "", false
+	}
+	return string(data), true
+}
+
+// edit replaces lines [start, end) of a base text with lines.
+type edit struct {
+	start, end int
+	lines      []string
+}
+
+// merge3 applies the changes from base to ours and from base to theirs
+// together, as diff3 and git merge do. ok is false if they overlap: if
+// both sides change the same lines, or lines next to each other, other
+// than in the same way.
+func merge3(base, ours, theirs string) (merged string, ok bool) {
+	baseLines := splitLines(base)
+	a, b := lineEdits(base, ours), lineEdits(base, theirs)
+
+	var out strings.Builder
+	pos := 0
+	for len(a) > 0 || len(b) > 0 {
+		// Gather the next edit and every edit of either side that
+		// overlaps or touches the lines it and the others cover
+		var lo, hi int
+		if len(b) == 0 || len(a) > 0 && a[0].start <= b[0].start {
+			lo, hi = a[0].start, a[0].end
+		} else {
+			lo, hi = b[0].start, b[0].end
+		}
+		var ga, gb []edit
+		for grew := true; grew; {
+			grew = false
+			for len(a) > 0 && a[0].start <= hi {
+				hi = max(hi, a[0].end)
+				ga, a, grew = append(ga, a[0]), a[1:], true
+			}
+			for len(b) > 0 && b[0].start <= hi {
+				hi = max(hi, b[0].end)
+				gb, b, grew = append(gb, b[0]), b[1:], true
+			}
+		}
+
+		out.WriteString(strings.Join(baseLines[pos:lo], ""))
+		oursText := applyEdits(baseLines, lo, hi, ga)
+		theirsText := applyEdits(baseLines, lo, hi, gb)
+		switch {
+		case len(gb) == 0 || oursText == theirsText:
+			out.WriteString(oursText)
+		case len(ga) == 0:
+			out.WriteString(theirsText)
+		default:
+			return "", false
+		}
+		pos = hi
+	}
+	out.WriteString(strings.Join(baseLines[pos:], ""))
+	return out.String(), true
+}
+
+// lineEdits lists, in order, the edits that turn base into other.
+func lineEdits(base, other string) []edit {
+	var edits []edit
+	line, open := 0, false
+	for _, d := range diff.Do(base, other) {
+		lines := splitLines(d.Text)
+		if d.Type == diffmatchpatch.DiffEqual {
+			line += len(lines)
+			open = false
+			continue
+		}
+		if !open {
+			edits = append(edits, edit{start: line, end: line})
+			open = true
+		}
+		e := &edits[len(edits)-1]
+		if d.Type == diffmatchpatch.DiffDelete {
+			line += len(lines)
+			e.end = line
+		} else {
+			e.lines = append(e.lines, lines...)
+		}
+	}
+	return edits
+}
+
+// applyEdits returns base's lines [lo, hi) with edits, which lie within
+// them, applied.
+func applyEdits(base []string, lo, hi int, edits []edit) string {
+	var b strings.Builder
+	for _, e := range edits {
+		b.WriteString(strings.Join(base[lo:e.start], ""))
+		b.WriteString(strings.Join(e.lines, ""))
+		lo = e.end
+	}
+	b.WriteString(strings.Join(base[lo:hi], ""))
+	return b.String()
+}
+
+// splitLines splits text after each newline, keeping them.
+func splitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
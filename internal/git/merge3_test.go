@@ -0,0 +1,106 @@
+package git
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMerge3(t *testing.T) {
+	base := "# Note\n\n## Work\n- one\n\n## Home\n- two\n\n## Ideas\n- three\n"
+	tests := []struct {
+		name, ours, theirs, want string
+		ok                       bool
+	}{
+		{"separate sections",
+			"# Note\n\n## Work\n- one\n- four\n\n## Home\n- two\n\n## Ideas\n- three\n",
+			"# Note\n\n## Work\n- one\n\n## Home\n- two\n\n## Ideas\n- three\n- five\n",
+			"# Note\n\n## Work\n- one\n- four\n\n## Home\n- two\n\n## Ideas\n- three\n- five\n", true},
+		{"one side only",
+			base,
+			"# Note\n\n## Work\n- ONE\n\n## Home\n- two\n\n## Ideas\n- three\n",
+			"# Note\n\n## Work\n- ONE\n\n## Home\n- two\n\n## Ideas\n- three\n", true},
+		{"same change",
+			"# Note\n\n## Work\n- ONE\n\n## Home\n- two\n\n## Ideas\n- three\n",
+			"# Note\n\n## Work\n- ONE\n\n## Home\n- two\n\n## Ideas\n- three\n",
+			"# Note\n\n## Work\n- ONE\n\n## Home\n- two\n\n## Ideas\n- three\n", true},
+		{"overlapping",
+			"# Note\n\n## Work\n- mine\n\n## Home\n- two\n\n## Ideas\n- three\n",
+			"# Note\n\n## Work\n- yours\n\n## Home\n- two\n\n## Ideas\n- three\n",
+			"", false},
+		{"adjacent lines",
+			"# Note\n\n## WORK\n- one\n\n## Home\n- two\n\n## Ideas\n- three\n",
+			"# Note\n\n## Work\n- ONE\n\n## Home\n- two\n\n## Ideas\n- three\n",
+			"", false},
+		{"both appended",
+			base + "- mine\n",
+			base + "- yours\n",
+			"", false},
+		{"deleted and edited elsewhere",
+			"# Note\n\n## Work\n- one\n\n## Ideas\n- three\n",
+			"# Note\n\n## Work\n- one\n\n## Home\n- two\n\n## Ideas\n- THREE\n",
+			"# Note\n\n## Work\n- one\n\n## Ideas\n- THREE\n", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := merge3(base, tt.ours, tt.theirs)
+			if ok != tt.ok || got != tt.want {
+				t.Fatalf("merge3 = %q, %v; want %q, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestConflictsAutoMerge(t *testing.T) {
+	note := "## Monday\nrain\n\n## Tuesday\nsun\n\n## Wednesday\nwind\n"
+	binary := "\x00\x01 base"
+	remote := newRemote(t, map[string]string{"note.md": note, "clash.md": "base\n", "image.bin": binary})
+	cfg := Config{Dir: t.TempDir(), Repo: remote, Branch: "main", User: "Test", Email: "test@test.com",
+		ConflictPolicy: ConflictsManual}
+	syncer := New(cfg, mustInitRepo(t, cfg))
+
+	theirs := pushFiles(t, remote, filepath.Join(t.TempDir(), "other"), map[string]string{
+		"note.md":   strings.Replace(note, "wind\n", "wind\nhail\n", 1),
+		"clash.md":  "theirs\n",
+		"image.bin": "\x00\x01 theirs",
+	})
+	for name, content := range map[string]string{
+		"note.md":   strings.Replace(note, "rain\n", "rain\nfog\n", 1),
+		"clash.md":  "ours\n",
+		"image.bin": "\x00\x01 ours",
+	} {
+		os.WriteFile(filepath.Join(cfg.Dir, name), []byte(content), 0644)
+	}
+	if result := syncer.Sync(); result.Err != nil || !result.Pushed {
+		t.Fatalf("sync = %+v", result)
+	}
+
+	short := theirs.String()[:7]
+	want := map[string]string{
+		"note.md":                       "## Monday\nrain\nfog\n\n## Tuesday\nsun\n\n## Wednesday\nwind\nhail\n",
+		"clash.md":                      "ours\n",
+		".conflicts/clash.md." + short:  "theirs\n",
+		"image.bin":                     "\x00\x01 ours",
+		".conflicts/image.bin." + short: "\x00\x01 theirs",
+	}
+	for name, content := range want {
+		if got, _ := os.ReadFile(filepath.Join(cfg.Dir, name)); string(got) != content {
+			t.Errorf("%s = %q, want %q", name, got, content)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(cfg.Dir, ".conflicts", "note.md."+short)); !os.IsNotExist(err) {
+		t.Error("a merged file was left as a conflict")
+	}
+
+	head, _ := syncer.repo.Head()
+	commit, _ := syncer.repo.CommitObject(head.Hash())
+	if !strings.Contains(commit.Message, "Merged line by line:\n\tnote.md\n\nConflicts") {
+		t.Fatalf("merge commit message:\n%s", commit.Message)
+	}
+	if f, err := remoteTree(t, remote, "main").File("note.md"); err != nil {
+		t.Fatal(err)
+	} else if content, _ := f.Contents(); content != want["note.md"] {
+		t.Fatalf("pushed note.md = %q", content)
+	}
+}
@@ -0,0 +1,130 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Backoff while the remote is unreachable: the first retry comes after
+// the pull interval (or offlineRetry without a puller), each later one
+// after twice as long as the one before, up to maxOfflineBackoff.
+var (
+	offlineRetry      = time.Minute
+	maxOfflineBackoff = 30 * time.Minute
+)
+
+// ErrOffline is returned by a push skipped because the remote was
+// unreachable and the backoff hasn't run out yet. The commits stay
+// ahead of the remote and are pushed once it is back.
+var ErrOffline = errors.New("remote unreachable")
+
+// offlineState tracks a remote that can't be reached, so pulls and pushes
+// back off instead of failing at full rate.
+type offlineState struct {
+	since     time.Time // the first failure; zero while online
+	failures  int       // failed attempts since then
+	nextTry   time.Time // no attempt is made before this
+	lastError string    // the last error logged in full
+	unpushed  bool      // a push was skipped or failed while offline
+}
+
+// isNetworkError reports whether err means the remote couldn't be
+// reached at all, rather than that it refused the request.
+func isNetworkError(err error) bool {
+	return err != nil && ErrorClass(err) == "network"
+}
+
+// backingOffLocked reports whether the remote was found unreachable and
+// the backoff hasn't run out. Caller must hold gs.mu.
+func (gs *Syncer) backingOffLocked() bool {
+	return !gs.offline.since.IsZero() && time.Now().Before(gs.offline.nextTry)
+}
+
+// skipPushLocked records a push skipped while backing off and returns
+// its error. Caller must hold gs.mu.
+func (gs *Syncer) skipPushLocked() error {
+	gs.offline.unpushed = true
+	err := fmt.Errorf("%w since %s, next attempt at %s", ErrOffline,
+		gs.offline.since.Format(time.RFC3339), gs.offline.nextTry.Format(time.RFC3339))
+	gs.events.add(Event{Op: "push", Result: "skipped", Error: err.Error()}, time.Now())
+	return err
+}
+
+// networkFailedLocked records a pull or push that failed with err and
+// logs it, reporting false if err isn't a network error, which is left
+// to the caller. A network error takes the Syncer offline, or further
+// backs it off if it already was; an error like the last one is only
+// logged as a reminder that the remote is still unreachable. Caller must
+// hold gs.mu.
+func (gs *Syncer) networkFailedLocked(op string, err error) bool {
+	if !isNetworkError(err) {
+		return false
+	}
+	o := &gs.offline
+	now := time.Now()
+	o.failures++
+	o.unpushed = o.unpushed || op == "push"
+	backoff := gs.offlineBackoffLocked()
+	o.nextTry = now.Add(backoff)
+	switch {
+	case o.since.IsZero():
+		o.since = now
+		gs.status.Offline, gs.status.OfflineSince = true, now
+		log.Printf("[git] %s failed, remote unreachable: %v; retrying in %s", op, err, backoff)
+	case err.Error() != o.lastError:
+		log.Printf("[git] %s failed, remote still unreachable: %v; retrying in %s", op, err, backoff)
+	default:
+		log.Printf("[git] still offline since %s; retrying in %s", o.since.Format(time.RFC3339), backoff)
+	}
+	o.lastError = err.Error()
+	return true
+}
+
+// offlineBackoffLocked is how long to wait after the latest failed
+// attempt. Caller must hold gs.mu.
+func (gs *Syncer) offlineBackoffLocked() time.Duration {
+	backoff := offlineRetry
+	if gs.pullInterval > 0 {
+		backoff = gs.pullInterval
+	}
+	limit := max(maxOfflineBackoff, backoff)
+	for i := 1; i < gs.offline.failures && backoff < limit; i++ {
+		backoff *= 2
+	}
+	return min(backoff, limit)
+}
+
+// reachedLocked records that the remote answered, bringing the Syncer
+// back online if it wasn't. A push skipped or failed in the meantime is
+// retried with a sync. Caller must hold gs.mu.
+func (gs *Syncer) reachedLocked() {
+	o := &gs.offline
+	if o.since.IsZero() {
+		return
+	}
+	log.Printf("[git] remote reachable again after %s offline", time.Since(o.since).Round(time.Second))
+	if o.unpushed && !gs.status.PendingTrigger {
+		if gs.timer != nil {
+			gs.timer.Stop()
+		}
+		gs.timer = time.AfterFunc(gs.debounce, gs.doSync)
+		gs.status.PendingTrigger = true
+		gs.instr.SetPending(true)
+	}
+	gs.offline = offlineState{}
+	gs.status.Offline, gs.status.OfflineSince = false, time.Time{}
+}
+
+// nextPullDelay is how long the puller waits before its next pull: the
+// interval (see pullEveryLocked), or until the backoff runs out while
+// offline.
+func (gs *Syncer) nextPullDelay(interval time.Duration) time.Duration {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	if gs.offline.since.IsZero() {
+		return gs.pullEveryLocked(interval)
+	}
+	return max(time.Until(gs.offline.nextTry), time.Millisecond)
+}
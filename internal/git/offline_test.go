@@ -0,0 +1,87 @@
+package git
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	gogit "github.com/go-git/go-git/v5"
+)
+
+// setOrigin points the repository's origin at url.
+func setOrigin(t *testing.T, repo *gogit.Repository, url string) {
+	t.Helper()
+	cfg, err := repo.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Remotes["origin"].URLs = []string{url}
+	if err := repo.SetConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOfflineBackoff(t *testing.T) {
+	defer func(r, m time.Duration) { offlineRetry, maxOfflineBackoff = r, m }(offlineRetry, maxOfflineBackoff)
+	offlineRetry, maxOfflineBackoff = 50*time.Millisecond, 200*time.Millisecond
+
+	remote := newRemote(t, map[string]string{"a.md": "a"})
+	cfg := Config{Dir: t.TempDir(), Repo: remote, Branch: "main", User: "Test", Email: "test@test.com",
+		Debounce: 10 * time.Millisecond}
+	repo := mustInitRepo(t, cfg)
+	syncer := New(cfg, repo)
+
+	// Nothing listens on port 1
+	setOrigin(t, repo, "http://127.0.0.1:1/vault.git")
+	os.WriteFile(filepath.Join(cfg.Dir, "b.md"), []byte("b"), 0644)
+	if result := syncer.Sync(); !errors.Is(result.Err, ErrOffline) || result.Commit == "" {
+		t.Fatalf("sync while offline = %+v", result)
+	}
+	st := syncer.Status()
+	if !st.Offline || st.OfflineSince.IsZero() || st.LastPushError != "" {
+		t.Fatalf("status = %+v, want offline without a push error", st)
+	}
+
+	// Within the backoff, nothing is tried
+	events := len(syncer.Events())
+	if result := syncer.Sync(); !errors.Is(result.Err, ErrOffline) {
+		t.Fatalf("second sync = %+v", result)
+	}
+	if got := syncer.Events()[events:]; len(got) != 1 || got[0].Op != "push" || got[0].Result != "skipped" {
+		t.Fatalf("events of a sync while backing off: %+v", got)
+	}
+
+	syncer.mu.Lock()
+	for failures, want := range map[int]time.Duration{1: 50 * time.Millisecond, 2: 100 * time.Millisecond, 3: 200 * time.Millisecond, 10: 200 * time.Millisecond} {
+		syncer.offline.failures = failures
+		if got := syncer.offlineBackoffLocked(); got != want {
+			t.Errorf("backoff after %d failures = %s, want %s", failures, got, want)
+		}
+	}
+	syncer.offline.failures = 1
+	syncer.mu.Unlock()
+
+	// Once the backoff runs out, a pull that gets through brings the
+	// syncer back online and the skipped push goes out
+	setOrigin(t, repo, remote)
+	time.Sleep(syncer.nextPullDelay(time.Hour))
+	syncer.doPull()
+	if st := syncer.Status(); st.Offline || !st.OfflineSince.IsZero() {
+		t.Fatalf("status after reaching the remote = %+v", st)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := remoteTree(t, remote, "main").File("b.md"); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("commit made while offline was never pushed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if d := syncer.nextPullDelay(time.Hour); d != time.Hour {
+		t.Fatalf("pull delay back online = %s, want the interval", d)
+	}
+}
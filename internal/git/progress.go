@@ -0,0 +1,45 @@
+package git
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"time"
+)
+
+// progressInterval is the least time between two logged progress lines
+// of a clone; the line that ends each phase is always logged.
+var progressInterval = 5 * time.Second
+
+// progressLog logs the progress a remote reports on the sideband during a
+// clone ("Counting objects:  42% (420/1000)"), throttled so a large
+// clone shows it is alive without flooding the log.
+type progressLog struct {
+	buf  []byte
+	last time.Time
+}
+
+func (p *progressLog) Write(b []byte) (int, error) {
+	p.buf = append(p.buf, b...)
+	// Git rewrites a progress line with \r until its phase is done
+	for {
+		i := bytes.IndexAny(p.buf, "\r\n")
+		if i < 0 {
+			break
+		}
+		if line := strings.TrimSpace(string(p.buf[:i])); line != "" {
+			p.line(line)
+		}
+		p.buf = p.buf[i+1:]
+	}
+	return len(b), nil
+}
+
+func (p *progressLog) line(line string) {
+	done := strings.HasSuffix(line, "done.")
+	if !done && time.Since(p.last) < progressInterval {
+		return
+	}
+	p.last = time.Now()
+	log.Printf("[git] remote: %s", line)
+}
@@ -0,0 +1,62 @@
+package git
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProgressLogThrottles(t *testing.T) {
+	defer func(d time.Duration) { progressInterval = d }(progressInterval)
+	progressInterval = time.Hour
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	p := &progressLog{}
+	// Sideband writes don't follow line boundaries
+	for _, chunk := range []string{"Counting objects:  10% (1/10)\rCounting obj", "ects:  50% (5/10)\r", "Counting objects: 100% (10/10), done.\nCompressing objects:  50% (1/2)\r"} {
+		p.Write([]byte(chunk))
+	}
+
+	got := buf.String()
+	for _, want := range []string{"[git] remote: Counting objects:  10% (1/10)", "[git] remote: Counting objects: 100% (10/10), done."} {
+		if !strings.Contains(got, want) {
+			t.Errorf("log lacks %q:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "50%") {
+		t.Errorf("throttled lines logged:\n%s", got)
+	}
+}
+
+func TestCloneLogsProgress(t *testing.T) {
+	files := make(map[string]string)
+	for i := 0; i < 200; i++ {
+		files[fmt.Sprintf("note%03d.md", i)] = strings.Repeat(fmt.Sprintf("line %d\n", i), 50)
+	}
+	remote := newRemote(t, files)
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	mustInitRepo(t, Config{Dir: t.TempDir(), Repo: remote, Branch: "main", CloneTimeout: time.Minute})
+	if !strings.Contains(buf.String(), "[git] remote: ") {
+		t.Fatalf("no clone progress logged:\n%s", buf.String())
+	}
+}
+
+func TestCloneTimeout(t *testing.T) {
+	remote := newRemote(t, map[string]string{"a.md": "a"})
+	dir := t.TempDir()
+	_, err := InitRepo(Config{Dir: dir, Repo: remote, Branch: "main", CloneTimeout: time.Nanosecond})
+	if !errors.Is(err, ErrClone) || !strings.Contains(err.Error(), "timed out after 1ns") {
+		t.Fatalf("InitRepo = %v, want a clone timeout", err)
+	}
+}
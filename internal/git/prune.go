@@ -0,0 +1,204 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// ErrUnpushed is returned by Prune while there are changes not yet
+// committed and pushed, or the remote has commits not yet pulled: the
+// history rewritten must be the one everyone has.
+var ErrUnpushed = errors.New("local and remote history differ")
+
+// PruneResult describes a pruned history.
+type PruneResult struct {
+	Head     string `json:"head"`               // the branch's new head, as pushed
+	Baseline string `json:"baseline,omitempty"` // the commit standing for the history before the cutoff
+	Squashed int    `json:"squashed"`           // commits folded into the baseline; 0 if there was nothing to prune
+	Kept     int    `json:"kept"`               // commits after the cutoff, rewritten on top of the baseline
+	Backup   string `json:"backup,omitempty"`   // branch keeping the old history
+}
+
+// Prune rewrites the branch's history to drop the versions from before
+// the cutoff: the commits up to it are squashed into one baseline commit
+// holding the tree as of the cutoff, and those after it are rewritten on
+// top with their trees, messages and signatures unchanged. History is
+// followed along first parents, so a merge after the cutoff becomes an
+// ordinary commit. The old head is kept in a backup branch, and the new
+// history is force-pushed.
+//
+// It fails with ErrUnpushed unless everything is committed and the branch
+// and the remote's agree. Other clones of the remote, including other git3
+// servers, see the force push; they must re-clone, or reset onto it with
+// Config.ResetOnForcePush. S3 requests wait until it is done.
+func (gs *Syncer) Prune(before time.Time) (*PruneResult, error) {
+	if gs.repo == nil || gs.remote == "" {
+		return nil, errors.New("no remote to prune")
+	}
+
+	// The tree lock comes first, as in SwitchBranch
+	gs.tree.Lock()
+	defer gs.tree.Unlock()
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	if gs.replica {
+		return nil, errors.New("a replica cannot prune; promote it or prune the primary")
+	}
+	if gs.pullBranch != gs.branch {
+		// Pulls would merge the old history back in
+		return nil, fmt.Errorf("cannot prune while pulling %s into %s", gs.pullBranch, gs.branch)
+	}
+
+	start := time.Now()
+	result, err := gs.pruneLocked(before, start)
+	if err != nil {
+		gs.events.add(Event{Op: "prune", Result: "failed", Error: err.Error()}, start)
+		return nil, err
+	}
+	if result.Squashed == 0 {
+		gs.events.add(Event{Op: "prune", Result: "nothing to prune", Hash: result.Head}, start)
+		return result, nil
+	}
+	log.Printf("[git] WARNING: pruned %s before %s: %d commits squashed into %s, %d kept, force-pushed %s; the old history is kept in branch %s, and other clones must re-clone",
+		gs.branch, before.Format(time.RFC3339), result.Squashed, result.Baseline, result.Kept, result.Head, result.Backup)
+	gs.events.add(Event{Op: "prune", Result: "pruned", Hash: result.Head}, start)
+	gs.status.LastPushTime = time.Now()
+	gs.measureLocked()
+	return result, nil
+}
+
+// pruneLocked does the work of Prune. Caller must hold gs.tree and gs.mu.
+func (gs *Syncer) pruneLocked(before, start time.Time) (*PruneResult, error) {
+	if gs.pendingAll || len(gs.pendingPaths) > 0 {
+		return nil, fmt.Errorf("%w: changes not committed yet; sync first", ErrUnpushed)
+	}
+	if err := gs.fetchLocked(); err != nil {
+		return nil, err
+	}
+	branch := plumbing.NewBranchReferenceName(gs.branch)
+	head, err := gs.repo.Reference(branch, true)
+	if err != nil {
+		return nil, err
+	}
+	tracking, err := gs.repo.Reference(plumbing.NewRemoteReferenceName("origin", gs.branch), true)
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return nil, fmt.Errorf("%w: origin has no %s; sync first", ErrUnpushed, gs.branch)
+	} else if err != nil {
+		return nil, err
+	}
+	if tracking.Hash() != head.Hash() {
+		return nil, fmt.Errorf("%w: %s is at %s and origin/%s at %s; sync first",
+			ErrUnpushed, gs.branch, head.Hash(), gs.branch, tracking.Hash())
+	}
+
+	// Newest first, up to and including the last commit from before the
+	// cutoff
+	var kept []*object.Commit
+	var baseline *object.Commit
+	for c, err := gs.repo.CommitObject(head.Hash()); ; c, err = c.Parent(0) {
+		if err != nil {
+			return nil, err
+		}
+		if !c.Committer.When.After(before) {
+			baseline = c
+			break
+		}
+		kept = append(kept, c)
+		if c.NumParents() == 0 {
+			break
+		}
+	}
+	result := &PruneResult{Head: head.Hash().String(), Kept: len(kept)}
+	if baseline == nil || baseline.NumParents() == 0 {
+		return result, nil // nothing from before the cutoff to squash
+	}
+	squashed := 0
+	err = object.NewCommitPreorderIter(baseline, nil, nil).ForEach(func(*object.Commit) error {
+		squashed++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	author, committer := gs.signatures()
+	author.When, committer.When = baseline.Committer.When, baseline.Committer.When
+	newHead, err := gs.storeCommit(&object.Commit{
+		Author:    author,
+		Committer: committer,
+		Message: fmt.Sprintf("git3: history before %s pruned\n\nSquashes %d commits, up to %s.\n",
+			before.UTC().Format(time.RFC3339), squashed, baseline.Hash),
+		TreeHash: baseline.TreeHash,
+	})
+	if err != nil {
+		return nil, err
+	}
+	result.Baseline = newHead.String()
+	for i := len(kept) - 1; i >= 0; i-- {
+		c := kept[i]
+		newHead, err = gs.storeCommit(&object.Commit{
+			Author:       c.Author,
+			Committer:    c.Committer,
+			Message:      c.Message,
+			TreeHash:     c.TreeHash,
+			ParentHashes: []plumbing.Hash{newHead},
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	backup := plumbing.NewBranchReferenceName(fmt.Sprintf("git3-backup/%s-prune-%s", gs.branch, start.UTC().Format("20060102T150405Z")))
+	if err := gs.repo.Storer.SetReference(plumbing.NewHashReference(backup, head.Hash())); err != nil {
+		return nil, fmt.Errorf("backup %s: %w", gs.branch, err)
+	}
+	if err := gs.repo.Storer.SetReference(plumbing.NewHashReference(branch, newHead)); err != nil {
+		return nil, err
+	}
+	if err := gs.pushPrunedLocked(branch, tracking.Hash()); err != nil {
+		// Left rewritten, the branch could never be pushed again
+		gs.repo.Storer.SetReference(head)
+		return nil, err
+	}
+	gs.repo.Storer.SetReference(plumbing.NewHashReference(tracking.Name(), newHead))
+
+	result.Head, result.Squashed, result.Backup = newHead.String(), squashed, backup.Short()
+	return result, nil
+}
+
+// pushPrunedLocked force-pushes the rewritten branch, leased on lease, the
+// remote branch as the prune fetched it: a commit pushed from elsewhere
+// since then fails the push with ErrUnpushed instead of being
+// overwritten. Caller must hold gs.mu.
+func (gs *Syncer) pushPrunedLocked(branch plumbing.ReferenceName, lease plumbing.Hash) error {
+	err := gs.repo.Push(&gogit.PushOptions{
+		RemoteName:     "origin",
+		RefSpecs:       []config.RefSpec{config.RefSpec("+" + branch + ":" + branch)},
+		Auth:           authFor(gs.token),
+		ForceWithLease: &gogit.ForceWithLease{RefName: branch, Hash: lease},
+	})
+	switch {
+	case err == nil:
+		return nil
+	case ErrorClass(err) == "rejected":
+		return fmt.Errorf("%w: origin/%s moved since it was fetched, history left as it was; sync first", ErrUnpushed, gs.branch)
+	default:
+		return fmt.Errorf("force push failed, history left as it was: %w", err)
+	}
+}
+
+// storeCommit writes c to the repository and returns its hash.
+func (gs *Syncer) storeCommit(c *object.Commit) (plumbing.Hash, error) {
+	obj := gs.repo.Storer.NewEncodedObject()
+	if err := c.Encode(obj); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return gs.repo.Storer.SetEncodedObject(obj)
+}
@@ -0,0 +1,237 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// oldHistory creates a bare remote with a commit on main for each of
+// days, that many days ago, oldest first. Commit i writes n.md with the
+// content "i" and is named "day -<days[i]>", in two digits.
+func oldHistory(t *testing.T, days ...int) string {
+	t.Helper()
+	remote := newRemote(t, nil)
+	dir := t.TempDir()
+	repo, _ := gogit.PlainInit(dir, false)
+	repo.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.NewBranchReferenceName("main")))
+	repo.CreateRemote(&config.RemoteConfig{Name: "origin", URLs: []string{remote}})
+	wt, _ := repo.Worktree()
+	for i, d := range days {
+		os.WriteFile(filepath.Join(dir, "n.md"), []byte{'0' + byte(i)}, 0644)
+		wt.Add("n.md")
+		when := time.Now().AddDate(0, 0, -d)
+		_, err := wt.Commit(fmt.Sprintf("day -%02d", d), &gogit.CommitOptions{
+			Author: &object.Signature{Name: "Old", Email: "old@test", When: when},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := repo.Push(&gogit.PushOptions{RefSpecs: []config.RefSpec{"refs/heads/main:refs/heads/main"}}); err != nil {
+		t.Fatal(err)
+	}
+	return remote
+}
+
+// firstParents returns the first lines of the messages of the
+// first-parent history of the remote's main, newest first.
+func firstParents(t *testing.T, remote string) []string {
+	t.Helper()
+	r, _ := gogit.PlainOpen(remote)
+	c, err := r.CommitObject(remoteHash(t, remote, "main"))
+	var msgs []string
+	for ; err == nil; c, err = c.Parent(0) {
+		msgs = append(msgs, strings.SplitN(c.Message, "\n", 2)[0])
+	}
+	if !errors.Is(err, object.ErrParentNotFound) {
+		t.Fatal(err)
+	}
+	return msgs
+}
+
+func TestPrune(t *testing.T) {
+	remote := oldHistory(t, 90, 60, 40, 10, 2)
+	cfg := Config{Dir: t.TempDir(), Repo: remote, Branch: "main", User: "Test", Email: "test@test.com", Debounce: time.Hour}
+	repo := mustInitRepo(t, cfg)
+	syncer := New(cfg, repo)
+	os.WriteFile(filepath.Join(cfg.Dir, "local.md"), []byte("local"), 0644)
+	syncer.Sync()
+	oldHead := remoteHash(t, remote, "main")
+	treeBefore := remoteTree(t, remote, "main").Hash
+
+	result, err := syncer.Prune(time.Now().AddDate(0, 0, -30))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Squashed != 3 || result.Kept != 3 {
+		t.Fatalf("result = %+v, want 3 squashed and 3 kept", result)
+	}
+
+	// The remote has the baseline and the three newer commits, ending with
+	// the same tree
+	msgs := firstParents(t, remote)
+	if len(msgs) != 4 || !strings.HasPrefix(msgs[3], "git3: history before") || msgs[2] != "day -10" || msgs[1] != "day -02" || !strings.HasPrefix(msgs[0], "sync:") {
+		t.Fatalf("history = %q", msgs)
+	}
+	if got := remoteHash(t, remote, "main"); got.String() != result.Head {
+		t.Fatalf("remote main = %s, want %s", got, result.Head)
+	}
+	if remoteTree(t, remote, "main").Hash != treeBefore {
+		t.Fatal("pruning changed the tree")
+	}
+	r, _ := gogit.PlainOpen(remote)
+	baseline, _ := r.CommitObject(plumbing.NewHash(result.Baseline))
+	f, _ := baseline.File("n.md")
+	if content, _ := f.Contents(); content != "2" || baseline.NumParents() != 0 {
+		t.Fatalf("baseline holds n.md = %q with %d parents, want the -40 day version and none", content, baseline.NumParents())
+	}
+
+	// The old history is kept in a local backup branch
+	backup, err := repo.Reference(plumbing.NewBranchReferenceName(result.Backup), true)
+	if err != nil || backup.Hash() != oldHead || !strings.HasPrefix(result.Backup, "git3-backup/main-prune-") {
+		t.Fatalf("backup %q = %v, %v; want %s", result.Backup, backup, err, oldHead)
+	}
+	if !containsEvent(syncer.Events(), "prune", "pruned") {
+		t.Fatalf("events = %+v", syncer.Events())
+	}
+
+	// Syncing carries on from the new history
+	os.WriteFile(filepath.Join(cfg.Dir, "after.md"), []byte("after"), 0644)
+	syncer.Trigger("after.md")
+	if res := syncer.Sync(); res.Err != nil || !res.Pushed {
+		t.Fatalf("sync after prune = %+v", res)
+	}
+	if msgs := firstParents(t, remote); len(msgs) != 5 {
+		t.Fatalf("history after a sync = %q", msgs)
+	}
+}
+
+func TestPruneNothingOlder(t *testing.T) {
+	remote := oldHistory(t, 10, 5)
+	cfg := Config{Dir: t.TempDir(), Repo: remote, Branch: "main", User: "Test", Email: "test@test.com", Debounce: time.Hour}
+	syncer := New(cfg, mustInitRepo(t, cfg))
+	head := remoteHash(t, remote, "main")
+
+	// Only the root commit is older: there is nothing to squash it with
+	for _, days := range []int{30, 7} {
+		result, err := syncer.Prune(time.Now().AddDate(0, 0, -days))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Squashed != 0 || result.Head != head.String() || remoteHash(t, remote, "main") != head {
+			t.Fatalf("cutoff -%d days: result = %+v, remote at %s", days, result, remoteHash(t, remote, "main"))
+		}
+	}
+}
+
+func TestPruneRefusesUnpushedChanges(t *testing.T) {
+	remote := oldHistory(t, 90, 60, 10)
+	cfg := Config{Dir: t.TempDir(), Repo: remote, Branch: "main", User: "Test", Email: "test@test.com", Debounce: time.Hour}
+	syncer := New(cfg, mustInitRepo(t, cfg))
+	cutoff := time.Now().AddDate(0, 0, -30)
+	head := remoteHash(t, remote, "main")
+
+	// A write not committed yet
+	os.WriteFile(filepath.Join(cfg.Dir, "new.md"), []byte("new"), 0644)
+	syncer.Trigger("new.md")
+	if _, err := syncer.Prune(cutoff); !errors.Is(err, ErrUnpushed) {
+		t.Fatalf("prune with a pending write = %v, want ErrUnpushed", err)
+	}
+
+	// A commit not pushed yet
+	if _, err := syncer.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := syncer.Prune(cutoff); !errors.Is(err, ErrUnpushed) {
+		t.Fatalf("prune with an unpushed commit = %v, want ErrUnpushed", err)
+	}
+	if remoteHash(t, remote, "main") != head {
+		t.Fatal("remote changed by a refused prune")
+	}
+	if !containsEvent(syncer.Events(), "prune", "failed") {
+		t.Fatalf("events = %+v", syncer.Events())
+	}
+
+	// Commits another clone pushed, not pulled yet
+	syncer.Push()
+	pushFiles(t, remote, t.TempDir(), map[string]string{"other.md": "other"})
+	if _, err := syncer.Prune(cutoff); !errors.Is(err, ErrUnpushed) {
+		t.Fatalf("prune behind the remote = %v, want ErrUnpushed", err)
+	}
+}
+
+func TestPrunePushLeased(t *testing.T) {
+	remote := oldHistory(t, 90, 60, 10)
+	cfg := Config{Dir: t.TempDir(), Repo: remote, Branch: "main", User: "Test", Email: "test@test.com", Debounce: time.Hour}
+	syncer := New(cfg, mustInitRepo(t, cfg))
+	fetched := remoteHash(t, remote, "main")
+
+	// Another device pushes between the prune's fetch and its push
+	pushed := pushFiles(t, remote, t.TempDir(), map[string]string{"other.md": "other"})
+	syncer.mu.Lock()
+	err := syncer.pushPrunedLocked(plumbing.NewBranchReferenceName("main"), fetched)
+	syncer.mu.Unlock()
+	if !errors.Is(err, ErrUnpushed) {
+		t.Fatalf("push over a moved remote = %v, want ErrUnpushed", err)
+	}
+	if remoteHash(t, remote, "main") != pushed {
+		t.Fatal("the other device's commit was overwritten")
+	}
+}
+
+func TestPruneSeenByReplicas(t *testing.T) {
+	remote := oldHistory(t, 90, 60, 10)
+	cfg := Config{Dir: t.TempDir(), Repo: remote, Branch: "main", User: "Test", Email: "test@test.com", Debounce: time.Hour}
+	syncer := New(cfg, mustInitRepo(t, cfg))
+	replicaCfg := cfg
+	replicaCfg.Dir = t.TempDir()
+	replica := New(replicaCfg, mustInitRepo(t, replicaCfg))
+
+	if _, err := syncer.Prune(time.Now().AddDate(0, 0, -30)); err != nil {
+		t.Fatal(err)
+	}
+	replica.mu.Lock()
+	err := replica.fetchLocked()
+	replica.mu.Unlock()
+	if !errors.Is(err, ErrForcePushed) {
+		t.Fatalf("replica fetch after a prune = %v, want ErrForcePushed", err)
+	}
+
+	// A fresh clone gets the pruned history
+	clone := replicaCfg
+	clone.Dir = t.TempDir()
+	repo := mustInitRepo(t, clone)
+	head, _ := repo.Head()
+	if head.Hash() != remoteHash(t, remote, "main") {
+		t.Fatalf("re-clone at %s, want %s", head.Hash(), remoteHash(t, remote, "main"))
+	}
+}
+
+func TestPruneRefusesSeparatePullBranch(t *testing.T) {
+	remote := oldHistory(t, 90, 60, 10)
+	cfg := Config{Dir: t.TempDir(), Repo: remote, Branch: "main", PullBranch: "main", PushBranch: "device", User: "Test", Email: "test@test.com", Debounce: time.Hour}
+	syncer := New(cfg, mustInitRepo(t, cfg))
+	if _, err := syncer.Prune(time.Now().AddDate(0, 0, -30)); err == nil || !strings.Contains(err.Error(), "pulling main") {
+		t.Fatalf("prune with a separate pull branch = %v", err)
+	}
+}
+
+// containsEvent reports whether events has one for op with result.
+func containsEvent(events []Event, op, result string) bool {
+	for _, e := range events {
+		if e.Op == op && e.Result == result {
+			return true
+		}
+	}
+	return false
+}
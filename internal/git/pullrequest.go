@@ -0,0 +1,178 @@
+package git
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// PullRequests is the forge API pull-request mode goes through; see
+// Config.PullRequests.
+type PullRequests interface {
+	// Find returns the number and web URL of the open pull request from
+	// head into base, or 0 if there is none.
+	Find(ctx context.Context, head, base string) (int, string, error)
+	// Create opens a pull request from head into base.
+	Create(ctx context.Context, head, base, title string) (int, string, error)
+	// State returns PullRequestOpen, PullRequestMerged or
+	// PullRequestClosed.
+	State(ctx context.Context, number int) (string, error)
+	// Merge merges the pull request, or asks the forge to once its checks
+	// pass. An error means it is left open, to be tried again.
+	Merge(ctx context.Context, number int) error
+}
+
+// Pull request states, as PullRequests.State returns them.
+const (
+	PullRequestOpen   = "open"
+	PullRequestMerged = "merged"
+	PullRequestClosed = "closed"
+)
+
+// pullRequestTimeout bounds each call to the forge API.
+const pullRequestTimeout = 30 * time.Second
+
+// findPullRequestLocked looks up the open pull request once, so a restart
+// goes on with the one it opened before instead of opening another.
+// Caller must hold gs.mu.
+func (gs *Syncer) findPullRequestLocked() error {
+	if gs.prFound {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), pullRequestTimeout)
+	defer cancel()
+	n, url, err := gs.prs.Find(ctx, gs.branch, gs.pullBranch)
+	if err != nil {
+		return err
+	}
+	gs.prFound = true
+	gs.status.PullRequest, gs.status.PullRequestURL = n, url
+	return nil
+}
+
+// openPullRequestLocked makes sure, after a push, that a pull request
+// from the push branch into the pull branch is open for the commits the
+// pull branch lacks. Only one is ever open: later pushes update it.
+// Caller must hold gs.mu.
+func (gs *Syncer) openPullRequestLocked() error {
+	if gs.prs == nil {
+		return nil
+	}
+	if err := gs.findPullRequestLocked(); err != nil {
+		return err
+	}
+	if gs.status.PullRequest != 0 {
+		gs.autoMergeLocked()
+		return nil
+	}
+	if merged, err := gs.inPullBranchLocked(); err != nil || merged {
+		return err
+	}
+
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), pullRequestTimeout)
+	defer cancel()
+	n, url, err := gs.prs.Create(ctx, gs.branch, gs.pullBranch, fmt.Sprintf("git3: sync %s into %s", gs.branch, gs.pullBranch))
+	if err != nil {
+		gs.events.add(Event{Op: "pr", Result: "failed", Error: err.Error()}, start)
+		return err
+	}
+	log.Printf("[git] opened pull request #%d from %s into %s: %s", n, gs.branch, gs.pullBranch, url)
+	gs.status.PullRequest, gs.status.PullRequestURL = n, url
+	gs.events.add(Event{Op: "pr", Result: "opened"}, start)
+	gs.autoMergeLocked()
+	return nil
+}
+
+// followPullRequestLocked checks on the open pull request before a pull.
+// Once it is merged or closed, by git3 or anyone else, the push branch is
+// reset onto the pull branch, keeping the old commits in a backup branch,
+// and whatever the vault holds that the pull branch lacks is committed on
+// top and goes into the next pull request. Caller must hold gs.mu.
+func (gs *Syncer) followPullRequestLocked() {
+	if gs.prs == nil {
+		return
+	}
+	if err := gs.findPullRequestLocked(); err != nil {
+		log.Printf("[git] %v", err)
+		return
+	}
+	n := gs.status.PullRequest
+	if n == 0 {
+		return
+	}
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), pullRequestTimeout)
+	defer cancel()
+	state, err := gs.prs.State(ctx, n)
+	if err != nil {
+		log.Printf("[git] %v", err)
+		return
+	}
+	if state == PullRequestOpen {
+		gs.autoMergeLocked()
+		return
+	}
+
+	gs.status.PullRequest, gs.status.PullRequestURL = 0, ""
+	gs.events.add(Event{Op: "pr", Result: state}, start)
+	if err := gs.resetToRemoteLocked(fmt.Sprintf("pull request #%d was %s", n, state)); err != nil {
+		log.Printf("[git] reset after pull request #%d: %v", n, err)
+		return
+	}
+	// What the reset committed on top needs a push and a pull request
+	if merged, err := gs.inPullBranchLocked(); err == nil && !merged && !gs.status.PendingTrigger {
+		if gs.timer != nil {
+			gs.timer.Stop()
+		}
+		gs.timer = time.AfterFunc(gs.debounce, gs.doSync)
+		gs.status.PendingTrigger = true
+		gs.instr.SetPending(true)
+	}
+}
+
+// autoMergeLocked merges the open pull request, with auto-merge on. A
+// forge that refuses, say for checks still running, is asked again at the
+// next push or pull. Caller must hold gs.mu.
+func (gs *Syncer) autoMergeLocked() {
+	if !gs.prAutoMerge {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), pullRequestTimeout)
+	defer cancel()
+	if err := gs.prs.Merge(ctx, gs.status.PullRequest); err != nil {
+		gs.debugf("pull request #%d not merged: %v", gs.status.PullRequest, err)
+		return
+	}
+	log.Printf("[git] merged pull request #%d, or asked for it to merge once checks pass", gs.status.PullRequest)
+}
+
+// inPullBranchLocked reports whether origin/<pull branch> already has the
+// branch's head, so there is nothing for a pull request to bring in.
+// Caller must hold gs.mu.
+func (gs *Syncer) inPullBranchLocked() (bool, error) {
+	head, err := gs.headCommitLocked()
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+	tracking, err := gs.repo.Reference(plumbing.NewRemoteReferenceName("origin", gs.pullBranch), true)
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if tracking.Hash() == head.Hash {
+		return true, nil
+	}
+	target, err := gs.repo.CommitObject(tracking.Hash())
+	if err != nil {
+		return false, err
+	}
+	return head.IsAncestor(target)
+}
@@ -0,0 +1,179 @@
+package git
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// fakeForge keeps pull requests in memory.
+type fakeForge struct {
+	states  map[int]string
+	created []string // head->base of each pull request opened
+	merges  int
+}
+
+func (f *fakeForge) Find(_ context.Context, head, base string) (int, string, error) {
+	for n, state := range f.states {
+		if state == PullRequestOpen && f.created[n-1] == head+"->"+base {
+			return n, fmt.Sprintf("https://forge/pulls/%d", n), nil
+		}
+	}
+	return 0, "", nil
+}
+
+func (f *fakeForge) Create(_ context.Context, head, base, title string) (int, string, error) {
+	if f.states == nil {
+		f.states = map[int]string{}
+	}
+	f.created = append(f.created, head+"->"+base)
+	n := len(f.created)
+	f.states[n] = PullRequestOpen
+	return n, fmt.Sprintf("https://forge/pulls/%d", n), nil
+}
+
+func (f *fakeForge) State(_ context.Context, n int) (string, error) {
+	return f.states[n], nil
+}
+
+func (f *fakeForge) Merge(_ context.Context, n int) error {
+	f.merges++
+	return fmt.Errorf("checks pending")
+}
+
+// setRemoteBranch points branch in a bare remote at hash, as a merge on
+// the forge would.
+func setRemoteBranch(t *testing.T, remote, branch string, hash plumbing.Hash) {
+	t.Helper()
+	r, err := gogit.PlainOpen(remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Storer.SetReference(plumbing.NewHashReference(plumbing.NewBranchReferenceName(branch), hash)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newPRSyncer(t *testing.T, forge *fakeForge) (*Syncer, string, Config) {
+	t.Helper()
+	remote := newRemote(t, map[string]string{"shared.md": "shared"})
+	cfg := Config{Dir: t.TempDir(), Repo: remote, Branch: "main", PushBranch: "git3/sync", User: "Test", Email: "test@test.com",
+		PullRequests: forge, PullRequestAutoMerge: true, Debounce: time.Hour} // triggered syncs wait for the test
+	return New(cfg, mustInitRepo(t, cfg)), remote, cfg
+}
+
+func TestPullRequestOpenedOnce(t *testing.T) {
+	forge := &fakeForge{}
+	syncer, remote, cfg := newPRSyncer(t, forge)
+	mainBefore := remoteHash(t, remote, "main")
+
+	for _, name := range []string{"a.md", "b.md"} {
+		os.WriteFile(filepath.Join(cfg.Dir, name), []byte(name), 0644)
+		if result := syncer.Sync(); result.Err != nil || !result.Pushed {
+			t.Fatalf("sync = %+v", result)
+		}
+	}
+	if len(forge.created) != 1 || forge.created[0] != "git3/sync->main" {
+		t.Fatalf("pull requests opened = %v, want one from git3/sync into main", forge.created)
+	}
+	if st := syncer.Status(); st.PullRequest != 1 || st.PullRequestURL != "https://forge/pulls/1" {
+		t.Fatalf("status pull request = %d %q", st.PullRequest, st.PullRequestURL)
+	}
+	if got := remoteHash(t, remote, "main"); got != mainBefore {
+		t.Fatal("pull-request mode pushed to the target branch")
+	}
+	if forge.merges == 0 {
+		t.Fatal("auto-merge never tried")
+	}
+
+	// A restart finds the open pull request instead of opening another
+	restarted := New(cfg, syncer.repo)
+	os.WriteFile(filepath.Join(cfg.Dir, "c.md"), []byte("c"), 0644)
+	restarted.Sync()
+	if len(forge.created) != 1 || restarted.Status().PullRequest != 1 {
+		t.Fatalf("pull requests opened after restart = %v", forge.created)
+	}
+}
+
+func TestPullRequestMergedResetsBranch(t *testing.T) {
+	forge := &fakeForge{}
+	syncer, remote, cfg := newPRSyncer(t, forge)
+
+	os.WriteFile(filepath.Join(cfg.Dir, "a.md"), []byte("a"), 0644)
+	syncer.Sync()
+	// Merged on the forge, as a fast-forward
+	setRemoteBranch(t, remote, "main", remoteHash(t, remote, "git3/sync"))
+	forge.states[1] = PullRequestMerged
+
+	syncer.doPull()
+	head, _ := syncer.repo.Head()
+	if head.Name().Short() != "git3/sync" || head.Hash() != remoteHash(t, remote, "main") {
+		t.Fatalf("head = %s at %s, want git3/sync reset onto main", head.Name().Short(), head.Hash())
+	}
+	if st := syncer.Status(); st.PullRequest != 0 || st.PendingTrigger {
+		t.Fatalf("status after merge = pull request %d, pending %v", st.PullRequest, st.PendingTrigger)
+	}
+	if !containsEvent(syncer.Events(), "pr", PullRequestMerged) {
+		t.Fatalf("events = %+v, want the merge", syncer.Events())
+	}
+
+	// The next change goes into a new pull request
+	os.WriteFile(filepath.Join(cfg.Dir, "b.md"), []byte("b"), 0644)
+	syncer.Sync()
+	if len(forge.created) != 2 || syncer.Status().PullRequest != 2 {
+		t.Fatalf("pull requests opened = %v, want a second", forge.created)
+	}
+}
+
+func TestPullRequestClosedKeepsVault(t *testing.T) {
+	forge := &fakeForge{}
+	syncer, remote, cfg := newPRSyncer(t, forge)
+
+	os.WriteFile(filepath.Join(cfg.Dir, "a.md"), []byte("a"), 0644)
+	syncer.Sync()
+	closed := remoteHash(t, remote, "git3/sync")
+	forge.states[1] = PullRequestClosed
+
+	syncer.doPull()
+	head, _ := syncer.repo.Head()
+	c, err := syncer.repo.CommitObject(head.Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.ParentHashes) != 1 || c.ParentHashes[0] != remoteHash(t, remote, "main") {
+		t.Fatalf("head parents = %v, want the vault committed again on main", c.ParentHashes)
+	}
+	if _, err := c.File("a.md"); err != nil {
+		t.Fatalf("vault content lost in the reset: %v", err)
+	}
+	backups := 0
+	refs, _ := syncer.repo.References()
+	refs.ForEach(func(ref *plumbing.Reference) error {
+		if strings.HasPrefix(ref.Name().Short(), "git3-backup/git3/sync-") && ref.Hash() == closed {
+			backups++
+		}
+		return nil
+	})
+	if backups != 1 {
+		t.Fatal("closed pull request's commits not kept in a backup branch")
+	}
+
+	// Its changes go out again, force-pushed, in a new pull request
+	if st := syncer.Status(); !st.PendingTrigger {
+		t.Fatal("reset didn't schedule a sync for the recommitted vault")
+	}
+	syncer.Sync()
+	if got := remoteHash(t, remote, "git3/sync"); got != head.Hash() {
+		t.Fatalf("remote git3/sync = %s, want the reset branch %s", got, head.Hash())
+	}
+	if len(forge.created) != 2 {
+		t.Fatalf("pull requests opened = %v, want a second", forge.created)
+	}
+}
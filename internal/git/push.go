@@ -0,0 +1,130 @@
+package git
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// schedulePushLocked arms the push timer when the branch has commits the
+// remote lacks. A timer already armed is left alone, so a push goes out at
+// most PushDebounce after the first commit it carries, however often the
+// branch is committed to meanwhile. Caller must hold gs.mu.
+func (gs *Syncer) schedulePushLocked() error {
+	if gs.repo == nil || gs.remote == "" || gs.pushTimer != nil {
+		return nil
+	}
+	if ahead, err := gs.aheadOfOrigin(); err != nil || !ahead {
+		return err
+	}
+	gs.debugf("push in %s", gs.pushDebounce)
+	gs.pushTimer = time.AfterFunc(gs.pushDebounce, gs.doPush)
+	gs.status.PendingPush = true
+	return nil
+}
+
+// stopPushTimerLocked disarms the push timer, for a sync about to push
+// anyway. Caller must hold gs.mu.
+func (gs *Syncer) stopPushTimerLocked() {
+	if gs.pushTimer != nil {
+		gs.pushTimer.Stop()
+		gs.pushTimer = nil
+	}
+	gs.status.PendingPush = false
+}
+
+// doPush pushes the commits the push timer was armed for.
+func (gs *Syncer) doPush() {
+	gs.syncing.Store(true)
+	defer gs.syncing.Store(false)
+	gs.mu.Lock()
+	gs.stopPushTimerLocked()
+	pushes := gs.status.Pushes
+	err := gs.pushLocked()
+	if errors.Is(err, ErrOffline) {
+		gs.debugf("%v", err)
+	} else if err != nil {
+		log.Printf("[git] %v", err)
+	}
+	result := SyncResult{Pushed: gs.status.Pushes > pushes, Err: err}
+	gs.settleLocked()
+	gs.mu.Unlock()
+	if gs.onSync != nil {
+		gs.onSync(result)
+	}
+}
+
+// commitsAheadLocked counts the branch's commits the remote lacks, going
+// by the remote-tracking refs as of the last fetch or push. Caller must
+// hold gs.mu.
+func (gs *Syncer) commitsAheadLocked() (int, error) {
+	if gs.repo == nil || gs.remote == "" {
+		return 0, nil
+	}
+	head, err := gs.repo.Reference(plumbing.NewBranchReferenceName(gs.branch), true)
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	seen, err := gs.pushedLocked()
+	if err != nil || seen[head.Hash()] {
+		return 0, err
+	}
+	local, err := gs.repo.CommitObject(head.Hash())
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	err = object.NewCommitPreorderIter(local, seen, nil).ForEach(func(*object.Commit) error {
+		n++
+		return nil
+	})
+	return n, err
+}
+
+// pushedLocked returns the commits behind any of origin's remote-tracking
+// refs. The set is kept between calls and only grows, walking just the
+// commits new to a ref that moved, so a commit stays known as pushed even
+// if the remote drops it later. Caller must hold gs.mu.
+func (gs *Syncer) pushedLocked() (map[plumbing.Hash]bool, error) {
+	if gs.pushed.seen == nil {
+		gs.pushed = pushedSet{tips: map[plumbing.ReferenceName]plumbing.Hash{}, seen: map[plumbing.Hash]bool{}}
+	}
+	refs, err := gs.repo.References()
+	if err != nil {
+		return nil, err
+	}
+	defer refs.Close()
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		name := ref.Name()
+		if ref.Type() != plumbing.HashReference || !strings.HasPrefix(name.String(), "refs/remotes/origin/") || gs.pushed.tips[name] == ref.Hash() {
+			return nil
+		}
+		tip, err := gs.repo.CommitObject(ref.Hash())
+		if err != nil {
+			return nil // not a commit, or not fetched yet
+		}
+		err = object.NewCommitPreorderIter(tip, gs.pushed.seen, nil).ForEach(func(c *object.Commit) error {
+			gs.pushed.seen[c.Hash] = true
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		gs.pushed.tips[name] = ref.Hash()
+		return nil
+	})
+	return gs.pushed.seen, err
+}
+
+// pushedSet is the commits known to be on the remote, and the tips of the
+// remote-tracking refs they were found from.
+type pushedSet struct {
+	tips map[plumbing.ReferenceName]plumbing.Hash
+	seen map[plumbing.Hash]bool
+}
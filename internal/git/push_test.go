@@ -0,0 +1,90 @@
+package git
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPushDebounceCommitsWithoutPushing(t *testing.T) {
+	remote := newRemote(t, map[string]string{"a.md": "a"})
+	cfg := Config{Dir: t.TempDir(), Repo: remote, Branch: "main", User: "Test", Email: "test@test.com", PushDebounce: time.Hour}
+	repo := mustInitRepo(t, cfg)
+	syncer := New(cfg, repo)
+	before := remoteHash(t, remote, "main")
+
+	for _, name := range []string{"b.md", "c.md"} {
+		os.WriteFile(filepath.Join(cfg.Dir, name), []byte(name), 0644)
+		syncer.doSync()
+	}
+	if got := remoteHash(t, remote, "main"); got != before {
+		t.Fatalf("remote main moved to %s before the push debounce", got)
+	}
+	st := syncer.Status()
+	if st.Commits != 2 || st.Ahead != 2 || !st.PendingPush {
+		t.Fatalf("status = commits %d, ahead %d, pending push %v; want 2, 2, true", st.Commits, st.Ahead, st.PendingPush)
+	}
+
+	syncer.doPush()
+	head, _ := repo.Head()
+	if got := remoteHash(t, remote, "main"); got != head.Hash() {
+		t.Fatalf("remote main = %s after push, want %s", got, head.Hash())
+	}
+	st = syncer.Status()
+	if st.Ahead != 0 || st.PendingPush || st.Pushes != 1 {
+		t.Fatalf("status after push = ahead %d, pending push %v, pushes %d; want 0, false, 1", st.Ahead, st.PendingPush, st.Pushes)
+	}
+}
+
+func TestPushDebounceFlushedBySyncAndClose(t *testing.T) {
+	remote := newRemote(t, map[string]string{"a.md": "a"})
+	cfg := Config{Dir: t.TempDir(), Repo: remote, Branch: "main", User: "Test", Email: "test@test.com", PushDebounce: time.Hour}
+	repo := mustInitRepo(t, cfg)
+	syncer := New(cfg, repo)
+
+	os.WriteFile(filepath.Join(cfg.Dir, "b.md"), []byte("b"), 0644)
+	syncer.doSync()
+	if err := syncer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	head, _ := repo.Head()
+	if got := remoteHash(t, remote, "main"); got != head.Hash() {
+		t.Fatalf("remote main = %s after Close, want %s", got, head.Hash())
+	}
+	if st := syncer.Status(); st.PendingPush || st.Ahead != 0 {
+		t.Fatalf("status after Close = pending push %v, ahead %d", st.PendingPush, st.Ahead)
+	}
+}
+
+func TestCommitDebounceReplacesDebounce(t *testing.T) {
+	cfg := Config{Dir: t.TempDir(), Debounce: time.Minute, CommitDebounce: time.Second}
+	if got := New(cfg, nil).debounce; got != time.Second {
+		t.Fatalf("debounce = %s, want the commit debounce", got)
+	}
+	cfg.CommitDebounce = 0
+	if got := New(cfg, nil).debounce; got != time.Minute {
+		t.Fatalf("debounce = %s, want Debounce", got)
+	}
+}
+
+func TestStatusCountsCommitsAhead(t *testing.T) {
+	remote := newRemote(t, map[string]string{"a.md": "a"})
+	cfg := Config{Dir: t.TempDir(), Repo: remote, Branch: "main", User: "Test", Email: "test@test.com"}
+	repo := mustInitRepo(t, cfg)
+	syncer := New(cfg, repo)
+
+	for _, name := range []string{"b.md", "c.md", "d.md"} {
+		os.WriteFile(filepath.Join(cfg.Dir, name), []byte(name), 0644)
+		if _, err := syncer.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if st := syncer.Status(); st.Ahead != 3 || st.PendingPush {
+		t.Fatalf("ahead = %d, pending push = %v; want 3, false", st.Ahead, st.PendingPush)
+	}
+	syncer.Sync()
+	if st := syncer.Status(); st.Ahead != 0 {
+		t.Fatalf("ahead after sync = %d, want 0", st.Ahead)
+	}
+}
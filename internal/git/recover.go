@@ -0,0 +1,179 @@
+package git
+
+import (
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/idxfile"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// recoverInterval is the least time between two automatic recoveries, so
+// a repository that keeps breaking can't make the syncer clone in a loop.
+var recoverInterval = time.Hour
+
+// recoveryDir is where recovery clones the remote and keeps the broken
+// .git, under gs.dir. It matches stateDirPattern, so neither is committed.
+const recoveryDir = ".git3"
+
+// IsCorrupt reports whether err means the local repository is damaged:
+// missing or unreadable objects, a broken pack or a broken index. Such
+// errors don't go away by retrying.
+func IsCorrupt(err error) bool {
+	var packErr *packfile.Error
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, plumbing.ErrObjectNotFound), errors.Is(err, plumbing.ErrInvalidType),
+		errors.Is(err, object.ErrUnsupportedObject):
+		return true
+	case errors.Is(err, zlib.ErrHeader), errors.Is(err, zlib.ErrChecksum):
+		return true
+	case errors.Is(err, index.ErrMalformedSignature), errors.Is(err, index.ErrInvalidChecksum),
+		errors.Is(err, idxfile.ErrMalformedIdxFile):
+		return true
+	case errors.Is(err, packfile.ErrMalformedPackFile), errors.Is(err, packfile.ErrInvalidDelta),
+		errors.Is(err, packfile.ErrReferenceDeltaNotFound), errors.As(err, &packErr):
+		return true
+	default:
+		return false
+	}
+}
+
+// checkCorruptLocked schedules a recovery if err shows the repository is
+// corrupt, unless one ran within recoverInterval. Caller must hold gs.mu.
+func (gs *Syncer) checkCorruptLocked(err error) {
+	if !IsCorrupt(err) {
+		return
+	}
+	gs.status.CorruptError = err.Error()
+	if gs.recovering {
+		return
+	}
+	if gs.remote == "" {
+		log.Printf("[git] REPOSITORY CORRUPT: %v; no remote to recover from, changes are not being committed", err)
+		return
+	}
+	if last := gs.status.LastRecoveryTime; !last.IsZero() && time.Since(last) < recoverInterval {
+		log.Printf("[git] REPOSITORY CORRUPT: %v; last recovery was %s ago, next attempt after %s",
+			err, time.Since(last).Round(time.Second), last.Add(recoverInterval).Format(time.RFC3339))
+		return
+	}
+	log.Printf("[git] REPOSITORY CORRUPT: %v; recovering from %s", err, gs.remote)
+	gs.recovering = true
+	go gs.Recover()
+}
+
+// Recover replaces a corrupt repository: it moves .git aside, clones the
+// remote afresh, keeps the current working files on top of it and commits
+// and pushes them. Local files win over the remote's version. S3 requests
+// wait until it is done.
+func (gs *Syncer) Recover() error {
+	if gs.repo == nil || gs.remote == "" {
+		return errors.New("no remote to recover from")
+	}
+
+	// The tree lock comes first, as in SwitchBranch
+	gs.tree.Lock()
+	defer gs.tree.Unlock()
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+
+	start := time.Now()
+	broken, err := gs.recoverLocked()
+	gs.recovering = false
+	gs.status.LastRecoveryTime = time.Now()
+	if err != nil {
+		log.Printf("[git] RECOVERY FAILED: %v", err)
+		gs.status.LastRecoveryError = err.Error()
+		gs.events.add(Event{Op: "recover", Result: "failed", Error: err.Error()}, start)
+		return err
+	}
+	log.Printf("[git] RECOVERED: re-cloned %s, the corrupt repository was kept in %s", gs.remote, broken)
+	gs.status.Recoveries++
+	gs.status.CorruptError = ""
+	gs.status.LastRecoveryError = ""
+	gs.events.add(Event{Op: "recover", Result: "recovered"}, start)
+	gs.measureLocked()
+
+	gs.pendingAll = true
+	if err := gs.syncLocked(); err != nil {
+		log.Printf("[git] sync after recovery failed: %v", err)
+	}
+	return nil
+}
+
+// recoverLocked does the work of Recover, returning where the corrupt .git
+// now is. Caller must hold gs.tree and gs.mu.
+func (gs *Syncer) recoverLocked() (string, error) {
+	stamp := time.Now().UTC().Format("20060102T150405Z")
+	clone := filepath.Join(gs.dir, recoveryDir, "recover-"+stamp)
+	broken := filepath.Join(gs.dir, recoveryDir, "corrupt-"+stamp)
+	defer os.RemoveAll(clone)
+
+	// initRepo clones, or starts afresh if the remote is empty
+	_, err := initRepo(Config{
+		Dir:        clone,
+		Repo:       gs.remote,
+		Branch:     gs.branch,
+		PullBranch: gs.pullBranch,
+		Token:      gs.token,
+		Subdir:     gs.subdir,
+	}.withBranches())
+	if err != nil {
+		return "", err
+	}
+
+	gitDir := filepath.Join(gs.dir, ".git")
+	if err := os.Rename(gitDir, broken); err != nil {
+		return "", fmt.Errorf("move corrupt repository aside: %w", err)
+	}
+	if err := os.Rename(filepath.Join(clone, ".git"), gitDir); err != nil {
+		// Put the old one back rather than leave no repository at all
+		os.Rename(broken, gitDir)
+		return "", fmt.Errorf("install fresh clone: %w", err)
+	}
+	// LFS content lives only in the old .git until it is pushed
+	os.Rename(filepath.Join(broken, "lfs"), filepath.Join(gitDir, "lfs"))
+	if err := excludeStateDir(gs.dir); err != nil {
+		log.Printf("[git] exclude %s failed: %v", stateDirPattern, err)
+	}
+	if err := writeExcludes(gs.dir, gs.subdir, gs.exclude); err != nil {
+		log.Printf("[git] exclude %v failed: %v", gs.exclude, err)
+	}
+
+	repo, err := gogit.PlainOpen(gs.dir)
+	if err != nil {
+		return broken, err
+	}
+	gs.repo = repo
+
+	// Point the index at the fresh HEAD without touching the working
+	// files; the next commit then records how they differ from it.
+	if _, err := repo.Head(); errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return broken, nil // empty remote, nothing to compare with
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		return broken, err
+	}
+	opts := &gogit.ResetOptions{Mode: gogit.MixedReset}
+	if gs.subdir != "" {
+		err = wt.ResetSparsely(opts, []string{gs.subdir})
+	} else {
+		err = wt.Reset(opts)
+	}
+	if err != nil {
+		return broken, fmt.Errorf("reset index: %w", err)
+	}
+	return broken, nil
+}
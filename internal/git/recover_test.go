@@ -0,0 +1,107 @@
+package git
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	gogit "github.com/go-git/go-git/v5"
+)
+
+// corruptObjects overwrites every loose object in dir's repository.
+func corruptObjects(t *testing.T, dir string) {
+	t.Helper()
+	objects := filepath.Join(dir, ".git", "objects")
+	filepath.WalkDir(objects, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() && filepath.Base(filepath.Dir(path)) != "pack" && filepath.Base(filepath.Dir(path)) != "info" {
+			os.Chmod(path, 0644)
+			os.WriteFile(path, []byte("garbage"), 0644)
+		}
+		return nil
+	})
+}
+
+// waitForRecovery waits until the syncer has attempted a recovery.
+func waitForRecovery(t *testing.T, syncer *Syncer) Status {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		if st := syncer.Status(); !st.LastRecoveryTime.IsZero() {
+			return st
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("no recovery attempted")
+	return Status{}
+}
+
+func TestRecoverCorruptRepository(t *testing.T) {
+	remote := newRemote(t, map[string]string{"a.md": "a"})
+	cfg := Config{Dir: t.TempDir(), Repo: remote, Branch: "main", User: "Test", Email: "test@test.com"}
+	mustInitRepo(t, cfg)
+	os.WriteFile(filepath.Join(cfg.Dir, "b.md"), []byte("b"), 0644)
+	New(cfg, mustOpen(t, cfg.Dir)).doSync()
+
+	corruptObjects(t, cfg.Dir)
+	os.WriteFile(filepath.Join(cfg.Dir, "b.md"), []byte("b2"), 0644)
+	os.WriteFile(filepath.Join(cfg.Dir, "c.md"), []byte("c"), 0644)
+	syncer := New(cfg, mustOpen(t, cfg.Dir))
+	if _, err := syncer.Commit(); !IsCorrupt(err) {
+		t.Fatalf("Commit on a corrupt repository = %v, want a corruption error", err)
+	}
+
+	st := waitForRecovery(t, syncer)
+	if st.Recoveries != 1 || st.CorruptError != "" || st.LastRecoveryError != "" {
+		t.Fatalf("status after recovery = %+v", st)
+	}
+	tree := remoteTree(t, remote, "main")
+	for name, want := range map[string]string{"a.md": "a", "b.md": "b2", "c.md": "c"} {
+		f, err := tree.File(name)
+		if err != nil {
+			t.Fatalf("%s not pushed after recovery: %v", name, err)
+		}
+		if got, _ := f.Contents(); got != want {
+			t.Errorf("%s = %q after recovery, want %q", name, got, want)
+		}
+	}
+
+	// The corrupt repository is kept, out of the commits
+	kept, _ := filepath.Glob(filepath.Join(cfg.Dir, recoveryDir, "corrupt-*"))
+	if len(kept) != 1 {
+		t.Fatalf("corrupt repository kept as %v", kept)
+	}
+	if _, err := tree.File(recoveryDir + "/"); err == nil {
+		t.Fatalf("%s was committed", recoveryDir)
+	}
+}
+
+func TestRecoverIsRateLimited(t *testing.T) {
+	remote := newRemote(t, map[string]string{"a.md": "a"})
+	cfg := Config{Dir: t.TempDir(), Repo: remote, Branch: "main", User: "Test", Email: "test@test.com"}
+	mustInitRepo(t, cfg)
+	os.WriteFile(filepath.Join(cfg.Dir, "b.md"), []byte("b"), 0644)
+	New(cfg, mustOpen(t, cfg.Dir)).doSync()
+
+	corruptObjects(t, cfg.Dir)
+	syncer := New(cfg, mustOpen(t, cfg.Dir))
+	last := time.Now().Add(-time.Minute)
+	syncer.status.LastRecoveryTime = last
+	os.WriteFile(filepath.Join(cfg.Dir, "c.md"), []byte("c"), 0644)
+	syncer.doSync()
+
+	st := syncer.Status()
+	if st.CorruptError == "" || st.Recoveries != 0 || !st.LastRecoveryTime.Equal(last) || syncer.recovering {
+		t.Fatalf("status = %+v, want corruption reported and no recovery within the interval", st)
+	}
+}
+
+func mustOpen(t *testing.T, dir string) *gogit.Repository {
+	t.Helper()
+	repo, err := gogit.PlainOpen(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
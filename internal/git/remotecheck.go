@@ -0,0 +1,98 @@
+package git
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// maxRemoteCheckFailures is how many times in a row remoteChangedLocked
+// may fail before pulls stop checking and always fetch, for
+// remoteCheckBackoff. Each failed retry after that doubles the wait, up to
+// maxRemoteCheckBackoff.
+const (
+	maxRemoteCheckFailures = 3
+	remoteCheckBackoff     = 5 * time.Minute
+	maxRemoteCheckBackoff  = time.Hour
+)
+
+// remoteChangedLocked reports whether a fetch could bring anything new:
+// whether the remote's push or pull branch differs from its
+// remote-tracking ref. Listing the remote's refs costs one small round
+// trip, against a fetch negotiation and a worktree update. When the check
+// itself fails it answers true, and after maxRemoteCheckFailures failures
+// in a row it is skipped, backing off, so a remote that can't be listed is
+// still pulled from. A check or fetch that works ends the backoff. Caller
+// must hold gs.mu.
+func (gs *Syncer) remoteChangedLocked() bool {
+	if gs.remoteCheckFailures >= maxRemoteCheckFailures && time.Now().Before(gs.remoteCheckRetry) {
+		return true
+	}
+	changed, err := gs.compareRemoteLocked()
+	if err != nil {
+		gs.remoteCheckFailures++
+		retries := gs.remoteCheckFailures - maxRemoteCheckFailures
+		if retries < 0 {
+			gs.debugf("listing the remote failed, fetching anyway: %v", err)
+			return true
+		}
+		backoff := min(remoteCheckBackoff<<min(retries, 8), maxRemoteCheckBackoff)
+		gs.remoteCheckRetry = time.Now().Add(backoff)
+		if retries == 0 {
+			log.Printf("[git] listing the remote failed %d times in a row (%v); pulling without checking it first for %s", maxRemoteCheckFailures, err, backoff)
+		} else {
+			gs.debugf("listing the remote failed again, checking again in %s: %v", backoff, err)
+		}
+		return true
+	}
+	gs.remoteCheckOKLocked()
+	if !changed {
+		gs.debugf("origin/%s unchanged, skipping fetch", gs.pullBranch)
+	}
+	return changed
+}
+
+// remoteCheckOKLocked ends any backoff from failed remote checks, after a
+// check or a fetch reached the remote. Caller must hold gs.mu.
+func (gs *Syncer) remoteCheckOKLocked() {
+	if gs.remoteCheckFailures >= maxRemoteCheckFailures {
+		log.Println("[git] reached the remote again; checking it before pulls")
+	}
+	gs.remoteCheckFailures, gs.remoteCheckRetry = 0, time.Time{}
+}
+
+// compareRemoteLocked lists the remote's branches and compares the push
+// and pull branch with their remote-tracking refs. Caller must hold gs.mu.
+func (gs *Syncer) compareRemoteLocked() (bool, error) {
+	remote, err := gs.repo.Remote("origin")
+	if err != nil {
+		return false, err
+	}
+	refs, err := remote.List(&gogit.ListOptions{Auth: authFor(gs.token)})
+	if err != nil && !errors.Is(err, transport.ErrEmptyRemoteRepository) {
+		return false, err
+	}
+	remoteHashes := make(map[plumbing.ReferenceName]plumbing.Hash, len(refs))
+	for _, ref := range refs {
+		remoteHashes[ref.Name()] = ref.Hash()
+	}
+
+	for _, b := range []string{gs.branch, gs.pullBranch} {
+		var tracked plumbing.Hash
+		ref, err := gs.repo.Reference(plumbing.NewRemoteReferenceName("origin", b), true)
+		switch {
+		case err == nil:
+			tracked = ref.Hash()
+		case !errors.Is(err, plumbing.ErrReferenceNotFound):
+			return false, err
+		}
+		if remoteHashes[plumbing.NewBranchReferenceName(b)] != tracked {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,91 @@
+package git
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRemoteChanged(t *testing.T) {
+	remote := newRemote(t, map[string]string{"a.md": "a"})
+	cfg := Config{Dir: t.TempDir(), Repo: remote, Branch: "main"}
+	syncer := New(cfg, mustInitRepo(t, cfg))
+
+	syncer.mu.Lock()
+	defer syncer.mu.Unlock()
+	if syncer.remoteChangedLocked() {
+		t.Fatal("remote reported changed right after the clone")
+	}
+
+	pushFiles(t, remote, filepath.Join(t.TempDir(), "other"), map[string]string{"b.md": "b"})
+	if !syncer.remoteChangedLocked() {
+		t.Fatal("remote reported unchanged after a push to it")
+	}
+	syncer.pullLocked()
+	if _, err := os.Stat(filepath.Join(cfg.Dir, "b.md")); err != nil {
+		t.Fatalf("pull after the check did not fetch: %v", err)
+	}
+	if syncer.remoteChangedLocked() {
+		t.Fatal("remote reported changed after pulling it")
+	}
+}
+
+func TestRemoteCheckFailuresFallBackToFetch(t *testing.T) {
+	remote := newRemote(t, map[string]string{"a.md": "a"})
+	cfg := Config{Dir: t.TempDir(), Repo: remote, Branch: "main"}
+	syncer := New(cfg, mustInitRepo(t, cfg))
+	syncer.mu.Lock()
+	defer syncer.mu.Unlock()
+
+	moved := remote + ".moved"
+	os.Rename(remote, moved)
+	for i := 0; i < maxRemoteCheckFailures; i++ {
+		if !syncer.remoteChangedLocked() {
+			t.Fatalf("failed check %d reported the remote unchanged", i+1)
+		}
+	}
+	if syncer.remoteCheckFailures != maxRemoteCheckFailures {
+		t.Fatalf("%d failures counted, want %d", syncer.remoteCheckFailures, maxRemoteCheckFailures)
+	}
+
+	// Once given up on, the check isn't made even when it would work,
+	// until the backoff runs out
+	if wait := time.Until(syncer.remoteCheckRetry); wait < remoteCheckBackoff-time.Minute || wait > remoteCheckBackoff {
+		t.Fatalf("checks retried in %s, want %s", wait, remoteCheckBackoff)
+	}
+	os.Rename(moved, remote)
+	if !syncer.remoteChangedLocked() || syncer.remoteCheckFailures != maxRemoteCheckFailures {
+		t.Fatal("check still made after too many failures")
+	}
+
+	// A failed retry backs off longer
+	os.Rename(remote, moved)
+	syncer.remoteCheckRetry = time.Now()
+	syncer.remoteChangedLocked()
+	if wait := time.Until(syncer.remoteCheckRetry); wait < 2*remoteCheckBackoff-time.Minute {
+		t.Fatalf("checks retried in %s after a failed retry, want %s", wait, 2*remoteCheckBackoff)
+	}
+
+	// One that works ends the backoff
+	os.Rename(moved, remote)
+	syncer.remoteCheckRetry = time.Now()
+	if syncer.remoteChangedLocked() || syncer.remoteCheckFailures != 0 {
+		t.Fatalf("retry once the remote is back: %d failures counted", syncer.remoteCheckFailures)
+	}
+}
+
+func TestRemoteCheckBackoffEndsOnFetch(t *testing.T) {
+	remote := newRemote(t, map[string]string{"a.md": "a"})
+	cfg := Config{Dir: t.TempDir(), Repo: remote, Branch: "main"}
+	syncer := New(cfg, mustInitRepo(t, cfg))
+	syncer.mu.Lock()
+	defer syncer.mu.Unlock()
+
+	syncer.remoteCheckFailures = maxRemoteCheckFailures
+	syncer.remoteCheckRetry = time.Now().Add(time.Hour)
+	syncer.pullLocked()
+	if syncer.remoteCheckFailures != 0 || !syncer.remoteCheckRetry.IsZero() {
+		t.Fatalf("after a fetch that worked: %d failures, retry at %s", syncer.remoteCheckFailures, syncer.remoteCheckRetry)
+	}
+}
@@ -0,0 +1,110 @@
+package git
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// minRemoteProbeInterval is the shortest interval StartRemoteProbe lists
+// the remote at, so a short setting doesn't hammer the git host.
+const minRemoteProbeInterval = time.Minute
+
+// remoteProbeTimeout bounds a probe's listing, well inside
+// minRemoteProbeInterval, so a host that hangs can't pile probes up.
+const remoteProbeTimeout = 20 * time.Second
+
+// RemoteHealth is what the last probe of the remote found: whether it
+// answered at all and whether it accepted the credentials.
+type RemoteHealth struct {
+	Reachable  bool      `json:"reachable"`
+	AuthOK     bool      `json:"auth_ok"`
+	CheckedAt  time.Time `json:"checked_at"`
+	Error      string    `json:"error,omitempty"`
+	ErrorClass string    `json:"error_class,omitempty"` // see ErrorClass
+}
+
+// ProbeRemote lists the remote's refs, as git ls-remote does, and records
+// whether that worked, for Status and RemoteHealth. A remote that can't
+// be reached at all is unreachable; one that answers with an error is
+// reachable, and fails auth only if the error is an authentication or
+// authorization one (see ErrorClass). An unreachable remote says nothing
+// about the credentials, so AuthOK is then what the previous probe found.
+// The sync lock is held only to look the remote up, not while it is
+// listed, so a probe doesn't hold up a sync.
+func (gs *Syncer) ProbeRemote() RemoteHealth {
+	health := RemoteHealth{Reachable: true, AuthOK: true, CheckedAt: time.Now()}
+	gs.mu.Lock()
+	remote, err := gs.repo.Remote("origin")
+	gs.mu.Unlock()
+	if err == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), remoteProbeTimeout)
+		_, err = remote.ListContext(ctx, &gogit.ListOptions{Auth: authFor(gs.token)})
+		cancel()
+	}
+	prev := gs.remoteHealth.Load()
+	if err != nil && !errors.Is(err, transport.ErrEmptyRemoteRepository) {
+		health.Error, health.ErrorClass = err.Error(), ErrorClass(err)
+		health.Reachable = !isNetworkError(err) // a timeout included
+		switch {
+		case health.ErrorClass == "auth":
+			health.AuthOK = false
+		case !health.Reachable && prev != nil:
+			health.AuthOK = prev.AuthOK
+		}
+	}
+
+	gs.remoteHealth.Store(&health)
+	switch {
+	case !health.Reachable && (prev == nil || prev.Reachable):
+		log.Printf("[git] remote probe: unreachable: %v", err)
+	case health.Reachable && health.Error != "" && (prev == nil || prev.ErrorClass != health.ErrorClass):
+		log.Printf("[git] remote probe: reachable, but listing it failed (%s): %v", health.ErrorClass, err)
+	case health.Error == "" && prev != nil && prev.Error != "":
+		log.Println("[git] remote probe: reachable and listed again")
+	default:
+		gs.debugf("remote probe: reachable=%t auth_ok=%t", health.Reachable, health.AuthOK)
+	}
+	return health
+}
+
+// RemoteHealth returns what the last ProbeRemote found, reporting false if
+// none ran yet. Unlike Status it never waits for a sync.
+func (gs *Syncer) RemoteHealth() (RemoteHealth, bool) {
+	if h := gs.remoteHealth.Load(); h != nil {
+		return *h, true
+	}
+	return RemoteHealth{}, false
+}
+
+// StartRemoteProbe launches a background goroutine that probes the remote
+// right away and then every interval, no more often than
+// minRemoteProbeInterval, so an expired token or an unreachable host
+// shows up before a sync fails on it. Does nothing if no remote is
+// configured or interval is 0.
+func (gs *Syncer) StartRemoteProbe(interval time.Duration) {
+	if gs.repo == nil || gs.remote == "" || interval <= 0 {
+		return
+	}
+	if interval < minRemoteProbeInterval {
+		log.Printf("[git] remote probe interval %s raised to %s", interval, minRemoteProbeInterval)
+		interval = minRemoteProbeInterval
+	}
+	log.Printf("[git] probing the remote every %s", interval)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			gs.ProbeRemote()
+			select {
+			case <-ticker.C:
+			case <-gs.stop:
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,53 @@
+package git
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestProbeRemote(t *testing.T) {
+	remote := newRemote(t, map[string]string{"a.md": "a"})
+	cfg := Config{Dir: t.TempDir(), Repo: remote, Branch: "main", User: "Test", Email: "test@test.com"}
+	repo := mustInitRepo(t, cfg)
+	syncer := New(cfg, repo)
+
+	if _, ok := syncer.RemoteHealth(); ok || syncer.Status().Remote != nil {
+		t.Fatal("remote health reported before any probe")
+	}
+	if h := syncer.ProbeRemote(); !h.Reachable || !h.AuthOK || h.Error != "" {
+		t.Fatalf("probe of a reachable remote = %+v", h)
+	}
+	if st := syncer.Status(); st.Remote == nil || !st.Remote.Reachable {
+		t.Fatalf("status after a healthy probe: remote = %+v", st.Remote)
+	}
+
+	// A host that answers but rejects the credentials
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+	setOrigin(t, repo, srv.URL+"/vault.git")
+	if h := syncer.ProbeRemote(); !h.Reachable || h.AuthOK || h.ErrorClass != "auth" {
+		t.Fatalf("probe of a remote rejecting the token = %+v", h)
+	}
+
+	// Nothing listens on port 1; the rejected token is still what's known
+	setOrigin(t, repo, "http://127.0.0.1:1/vault.git")
+	syncer.ProbeRemote()
+	h, ok := syncer.RemoteHealth()
+	if !ok || h.Reachable || h.AuthOK || h.ErrorClass != "network" || h.CheckedAt.IsZero() {
+		t.Fatalf("probe of an unreachable remote = %+v", h)
+	}
+	if st := syncer.Status(); st.Remote == nil || st.Remote.Reachable {
+		t.Fatalf("status after an unhealthy probe: remote = %+v", st.Remote)
+	}
+
+	// A host that takes the token but has no such repository
+	missing := httptest.NewServer(http.NotFoundHandler())
+	defer missing.Close()
+	setOrigin(t, repo, missing.URL+"/vault.git")
+	if h := syncer.ProbeRemote(); !h.Reachable || !h.AuthOK || h.ErrorClass != "not_found" {
+		t.Fatalf("probe of a missing repository = %+v", h)
+	}
+}
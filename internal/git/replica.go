@@ -0,0 +1,53 @@
+package git
+
+import (
+	"errors"
+	"log"
+	"time"
+)
+
+// ErrNotReplica is returned by Promote when the Syncer isn't a replica.
+var ErrNotReplica = errors.New("not a replica")
+
+// isReplica reports whether the Syncer is still a pull-only replica.
+func (gs *Syncer) isReplica() bool {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	return gs.replica
+}
+
+// pullEveryLocked is the puller's interval: interval, or a replica's own
+// while it is one. Caller must hold gs.mu.
+func (gs *Syncer) pullEveryLocked(interval time.Duration) time.Duration {
+	if gs.replica && gs.replicaPull > 0 {
+		return gs.replicaPull
+	}
+	return interval
+}
+
+// Promote turns a replica into a primary, without a restart: it pulls once
+// more, so writes build on the remote's latest, and from then on commits
+// and pushes changes and pulls at the puller's normal interval. Nothing is
+// flushed, since a replica has no changes of its own. Config.OnPromote is
+// called when it is done, to let writes in.
+func (gs *Syncer) Promote() error {
+	gs.mu.Lock()
+	if !gs.replica {
+		gs.mu.Unlock()
+		return ErrNotReplica
+	}
+	start := time.Now()
+	if gs.repo != nil && gs.remote != "" {
+		gs.pullLocked()
+	}
+	gs.replica = false
+	gs.status.ReplicaOf = ""
+	log.Printf("[git] promoted: no longer a replica of %s, committing and pushing to %s", gs.remote, gs.branch)
+	gs.events.add(Event{Op: "promote", Result: "primary"}, start)
+	gs.mu.Unlock()
+
+	if gs.onPromote != nil {
+		gs.onPromote()
+	}
+	return nil
+}
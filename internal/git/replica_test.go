@@ -0,0 +1,94 @@
+package git
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReplica(t *testing.T) {
+	remote := newRemote(t, map[string]string{"a.md": "a"})
+	promoted := make(chan struct{}, 1)
+	cfg := Config{Dir: t.TempDir(), Repo: remote, Branch: "main", User: "Test", Email: "test@test.com", Debounce: 10 * time.Millisecond,
+		Replica: true, ReplicaPullInterval: 5 * time.Second, OnPromote: func() { promoted <- struct{}{} }}
+	syncer := New(cfg, mustInitRepo(t, cfg))
+	defer syncer.Close()
+	head := remoteHash(t, remote, "main")
+
+	if st := syncer.Status(); st.ReplicaOf != remote {
+		t.Fatalf("ReplicaOf = %q, want %q", st.ReplicaOf, remote)
+	}
+	if d := syncer.nextPullDelay(time.Minute); d != 5*time.Second {
+		t.Fatalf("replica pulls every %s, want 5s", d)
+	}
+
+	// Nothing local is committed or pushed
+	os.WriteFile(filepath.Join(cfg.Dir, "local.md"), []byte("local"), 0644)
+	syncer.Trigger("local.md")
+	if st := syncer.Status(); st.PendingTrigger {
+		t.Fatal("replica scheduled a sync")
+	}
+	if result := syncer.Sync(); result.Commit != "" || result.Pushed || result.Err != nil {
+		t.Fatalf("replica sync = %+v", result)
+	}
+	if remoteHash(t, remote, "main") != head {
+		t.Fatal("replica pushed")
+	}
+	if _, err := syncer.Prune(time.Now()); err == nil {
+		t.Fatal("replica pruned")
+	}
+	os.Remove(filepath.Join(cfg.Dir, "local.md"))
+
+	// It follows the remote
+	pushFiles(t, remote, t.TempDir(), map[string]string{"b.md": "b"})
+	syncer.Pull()
+	if data, _ := os.ReadFile(filepath.Join(cfg.Dir, "b.md")); string(data) != "b" {
+		t.Fatalf("b.md = %q after a pull", data)
+	}
+
+	// Promoted, it commits and pushes like a primary
+	if err := syncer.Promote(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-promoted:
+	default:
+		t.Fatal("OnPromote not called")
+	}
+	if st := syncer.Status(); st.ReplicaOf != "" {
+		t.Fatalf("ReplicaOf = %q after promotion", st.ReplicaOf)
+	}
+	if d := syncer.nextPullDelay(time.Minute); d != time.Minute {
+		t.Fatalf("promoted syncer pulls every %s, want the normal interval", d)
+	}
+	os.WriteFile(filepath.Join(cfg.Dir, "c.md"), []byte("c"), 0644)
+	syncer.Trigger("c.md")
+	if result := syncer.Sync(); result.Err != nil || !result.Pushed {
+		t.Fatalf("sync after promotion = %+v", result)
+	}
+	if _, err := remoteTree(t, remote, "main").File("c.md"); err != nil {
+		t.Fatalf("c.md not pushed after promotion: %v", err)
+	}
+	if err := syncer.Promote(); !errors.Is(err, ErrNotReplica) {
+		t.Fatalf("second Promote = %v, want ErrNotReplica", err)
+	}
+}
+
+func TestReplicaFollowsForcePush(t *testing.T) {
+	remote := newRemote(t, map[string]string{"a.md": "old", "gone.md": "x"})
+	cfg := Config{Dir: t.TempDir(), Repo: remote, Branch: "main", User: "Test", Email: "test@test.com",
+		Replica: true, ResetOnForcePush: true}
+	syncer := New(cfg, mustInitRepo(t, cfg))
+	forcePush(t, remote, map[string]string{"a.md": "new"})
+
+	syncer.Pull()
+	if data, _ := os.ReadFile(filepath.Join(cfg.Dir, "a.md")); string(data) != "new" {
+		t.Fatalf("a.md = %q after the replica followed a force push, want the remote's", data)
+	}
+	head, _ := syncer.repo.Head()
+	if head.Hash() != remoteHash(t, remote, "main") {
+		t.Fatalf("replica at %s, want the remote's %s", head.Hash(), remoteHash(t, remote, "main"))
+	}
+}
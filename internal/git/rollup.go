@@ -0,0 +1,121 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// syncMessagePrefix starts the message of every commit a sync makes. Only
+// commits with it are amended or rolled up.
+const syncMessagePrefix = "sync: "
+
+// isSyncCommit reports whether c is a sync commit that can be rewritten
+// on its own: merges are left alone.
+func isSyncCommit(c *object.Commit) bool {
+	return strings.HasPrefix(c.Message, syncMessagePrefix) && len(c.ParentHashes) == 1
+}
+
+// headCommitLocked returns the commit the branch points at. Caller must
+// hold gs.mu.
+func (gs *Syncer) headCommitLocked() (*object.Commit, error) {
+	head, err := gs.repo.Reference(plumbing.NewBranchReferenceName(gs.branch), true)
+	if err != nil {
+		return nil, err
+	}
+	return gs.repo.CommitObject(head.Hash())
+}
+
+// rewritableLocked reports whether history from the remote's commits up
+// may be rewritten: there is a remote to tell what it has seen, and the
+// last push didn't fail, since a failed push may have reached the remote
+// all the same. Caller must hold gs.mu.
+func (gs *Syncer) rewritableLocked() bool {
+	return gs.remote != "" && gs.status.LastPushError == ""
+}
+
+// amendableLocked reports whether the next commit should amend the head:
+// with a rollup window, when the head is a sync commit first made within
+// it that the remote has never seen. Caller must hold gs.mu.
+func (gs *Syncer) amendableLocked() bool {
+	if gs.rollupWindow <= 0 || !gs.rewritableLocked() {
+		return false
+	}
+	head, err := gs.headCommitLocked()
+	if err != nil || !isSyncCommit(head) || time.Since(head.Author.When) > gs.rollupWindow {
+		return false
+	}
+	pushed, err := gs.pushedLocked()
+	return err == nil && !pushed[head.Hash]
+}
+
+// scheduledRollup runs a sync from the rollup schedule, which squashes
+// the unpushed sync commits into one before pushing it.
+func (gs *Syncer) scheduledRollup() SyncResult {
+	gs.mu.Lock()
+	gs.rollupDue = true
+	gs.mu.Unlock()
+	return gs.Sync()
+}
+
+// rollupLocked replaces the run of sync commits at the head of the branch
+// the remote has never seen with a single commit of the same tree. It
+// stops at the first commit that was pushed, fetched, or isn't a sync
+// commit, so nothing the remote has is rewritten. Caller must hold gs.mu.
+func (gs *Syncer) rollupLocked() error {
+	if gs.replica || !gs.rewritableLocked() {
+		return nil
+	}
+	start := time.Now()
+	head, err := gs.headCommitLocked()
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	pushed, err := gs.pushedLocked()
+	if err != nil {
+		return err
+	}
+	var run []*object.Commit
+	for c := head; !pushed[c.Hash] && isSyncCommit(c); {
+		run = append(run, c)
+		if c, err = c.Parent(0); err != nil {
+			return err
+		}
+	}
+	if len(run) < 2 {
+		gs.debugf("rollup: %d unpushed sync commits, nothing to squash", len(run))
+		return nil
+	}
+
+	first := run[len(run)-1]
+	author, committer := gs.signatures()
+	author.When = first.Author.When
+	hash, err := gs.storeCommit(&object.Commit{
+		Author:    author,
+		Committer: committer,
+		Message: fmt.Sprintf("%s%s to %s (%d commits)", syncMessagePrefix,
+			first.Author.When.Format("2006-01-02 15:04"), head.Committer.When.Format("2006-01-02 15:04"), len(run)),
+		TreeHash:     head.TreeHash,
+		ParentHashes: first.ParentHashes,
+	})
+	if err == nil {
+		err = gs.repo.Storer.SetReference(plumbing.NewHashReference(plumbing.NewBranchReferenceName(gs.branch), hash))
+	}
+	if err != nil {
+		gs.events.add(Event{Op: "commit", Result: "failed", Error: err.Error()}, start)
+		return fmt.Errorf("rollup failed: %w", err)
+	}
+	log.Printf("[git] rolled up %d unpushed commits into %s", len(run), hash)
+	gs.status.LastCommitTime = time.Now()
+	gs.status.LastCommitHash = hash.String()
+	gs.status.Rollups++
+	gs.events.add(Event{Op: "commit", Result: "rolled up", Hash: hash.String()}, start)
+	return nil
+}
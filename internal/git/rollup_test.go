@@ -0,0 +1,168 @@
+package git
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+func headCommit(t *testing.T, repo *gogit.Repository) *object.Commit {
+	t.Helper()
+	head, err := repo.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestRollupWindowAmendsUnpushedCommit(t *testing.T) {
+	remote := newRemote(t, map[string]string{"a.md": "a"})
+	cfg := Config{Dir: t.TempDir(), Repo: remote, Branch: "main", User: "Test", Email: "test@test.com",
+		PushDebounce: time.Hour, RollupWindow: time.Hour}
+	repo := mustInitRepo(t, cfg)
+	syncer := New(cfg, repo)
+	base := remoteHash(t, remote, "main")
+
+	os.WriteFile(filepath.Join(cfg.Dir, "b.md"), []byte("b"), 0644)
+	syncer.doSync()
+	first := headCommit(t, repo)
+	os.WriteFile(filepath.Join(cfg.Dir, "c.md"), []byte("c"), 0644)
+	syncer.doSync()
+
+	head := headCommit(t, repo)
+	if head.Hash == first.Hash || len(head.ParentHashes) != 1 || head.ParentHashes[0] != base {
+		t.Fatalf("head %s with parents %v, want the first sync commit amended on top of %s", head.Hash, head.ParentHashes, base)
+	}
+	if !head.Author.When.Equal(first.Author.When) {
+		t.Fatalf("amended author date = %s, want the first commit's %s", head.Author.When, first.Author.When)
+	}
+	for _, name := range []string{"b.md", "c.md"} {
+		if _, err := head.File(name); err != nil {
+			t.Fatalf("amended commit lacks %s: %v", name, err)
+		}
+	}
+	if st := syncer.Status(); st.Amends != 1 || st.Ahead != 1 {
+		t.Fatalf("amends = %d, ahead = %d; want 1, 1", st.Amends, st.Ahead)
+	}
+	if !containsEvent(syncer.Events(), "commit", "amended") {
+		t.Fatalf("events = %+v, want an amended commit", syncer.Events())
+	}
+}
+
+func TestRollupWindowNeverAmendsPushedCommit(t *testing.T) {
+	remote := newRemote(t, map[string]string{"a.md": "a"})
+	cfg := Config{Dir: t.TempDir(), Repo: remote, Branch: "main", User: "Test", Email: "test@test.com",
+		RollupWindow: time.Hour}
+	repo := mustInitRepo(t, cfg)
+	syncer := New(cfg, repo)
+
+	os.WriteFile(filepath.Join(cfg.Dir, "b.md"), []byte("b"), 0644)
+	syncer.doSync()
+	pushed := remoteHash(t, remote, "main")
+	os.WriteFile(filepath.Join(cfg.Dir, "c.md"), []byte("c"), 0644)
+	syncer.doSync()
+
+	head := headCommit(t, repo)
+	if len(head.ParentHashes) != 1 || head.ParentHashes[0] != pushed {
+		t.Fatalf("head parents = %v, want a new commit on the pushed %s", head.ParentHashes, pushed)
+	}
+	if got := remoteHash(t, remote, "main"); got != head.Hash {
+		t.Fatalf("remote main = %s, want %s pushed as a fast-forward", got, head.Hash)
+	}
+	if st := syncer.Status(); st.Amends != 0 {
+		t.Fatalf("amends = %d, want 0", st.Amends)
+	}
+}
+
+func TestRollupWindowNotAfterFailedPush(t *testing.T) {
+	remote := newRemote(t, map[string]string{"a.md": "a"})
+	cfg := Config{Dir: t.TempDir(), Repo: remote, Branch: "main", User: "Test", Email: "test@test.com",
+		PushDebounce: time.Hour, RollupWindow: time.Hour}
+	repo := mustInitRepo(t, cfg)
+	syncer := New(cfg, repo)
+
+	os.WriteFile(filepath.Join(cfg.Dir, "b.md"), []byte("b"), 0644)
+	syncer.doSync()
+	first := headCommit(t, repo)
+	// The push may have reached the remote before failing
+	syncer.status.LastPushError = "push failed: connection reset"
+	os.WriteFile(filepath.Join(cfg.Dir, "c.md"), []byte("c"), 0644)
+	syncer.doSync()
+
+	if head := headCommit(t, repo); head.ParentHashes[0] != first.Hash {
+		t.Fatalf("head parents = %v, want a new commit on %s", head.ParentHashes, first.Hash)
+	}
+}
+
+func TestRollupSquashesUnpushedCommits(t *testing.T) {
+	remote := newRemote(t, map[string]string{"a.md": "a"})
+	cfg := Config{Dir: t.TempDir(), Repo: remote, Branch: "main", User: "Test", Email: "test@test.com",
+		PushDebounce: time.Hour}
+	repo := mustInitRepo(t, cfg)
+	syncer := New(cfg, repo)
+
+	// The first commit is pushed and must survive the rollup
+	os.WriteFile(filepath.Join(cfg.Dir, "b.md"), []byte("b"), 0644)
+	syncer.Sync()
+	pushed := remoteHash(t, remote, "main")
+	for _, name := range []string{"c.md", "d.md", "e.md"} {
+		os.WriteFile(filepath.Join(cfg.Dir, name), []byte(name), 0644)
+		syncer.doSync()
+	}
+	if st := syncer.Status(); st.Ahead != 3 {
+		t.Fatalf("ahead = %d before rollup, want 3", st.Ahead)
+	}
+
+	if result := syncer.scheduledRollup(); result.Err != nil || !result.Pushed {
+		t.Fatalf("rollup = %+v, want pushed", result)
+	}
+	head := headCommit(t, repo)
+	if got := remoteHash(t, remote, "main"); got != head.Hash {
+		t.Fatalf("remote main = %s, want the rollup %s", got, head.Hash)
+	}
+	if len(head.ParentHashes) != 1 || head.ParentHashes[0] != pushed {
+		t.Fatalf("rollup parents = %v, want the pushed %s", head.ParentHashes, pushed)
+	}
+	if !strings.Contains(head.Message, "(3 commits)") {
+		t.Fatalf("rollup message = %q", head.Message)
+	}
+	for _, name := range []string{"b.md", "c.md", "d.md", "e.md"} {
+		if _, err := head.File(name); err != nil {
+			t.Fatalf("rollup lacks %s: %v", name, err)
+		}
+	}
+	if st := syncer.Status(); st.Rollups != 1 || st.Ahead != 0 || st.PendingPush {
+		t.Fatalf("status = rollups %d, ahead %d, pending push %v; want 1, 0, false", st.Rollups, st.Ahead, st.PendingPush)
+	}
+}
+
+func TestRollupLeavesSingleCommit(t *testing.T) {
+	remote := newRemote(t, map[string]string{"a.md": "a"})
+	cfg := Config{Dir: t.TempDir(), Repo: remote, Branch: "main", User: "Test", Email: "test@test.com",
+		PushDebounce: time.Hour}
+	repo := mustInitRepo(t, cfg)
+	syncer := New(cfg, repo)
+
+	os.WriteFile(filepath.Join(cfg.Dir, "b.md"), []byte("b"), 0644)
+	syncer.doSync()
+	before := headCommit(t, repo)
+
+	syncer.mu.Lock()
+	err := syncer.rollupLocked()
+	syncer.mu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head := headCommit(t, repo); head.Hash != before.Hash {
+		t.Fatalf("head = %s after rollup, want %s untouched", head.Hash, before.Hash)
+	}
+}
@@ -0,0 +1,148 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// Schedule is the times of day, as offsets from midnight in order, that
+// scheduled syncs run at every day.
+type Schedule []time.Duration
+
+// ParseSchedule parses comma-separated "HH:MM" times of day, such as
+// "03:00,15:30", in the server's local time.
+func ParseSchedule(s string) (Schedule, error) {
+	var sched Schedule
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		t, err := time.Parse("15:04", field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time of day %q, want HH:MM", field)
+		}
+		at := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+		if !slices.Contains(sched, at) {
+			sched = append(sched, at)
+		}
+	}
+	slices.Sort(sched)
+	return sched, nil
+}
+
+func (s Schedule) String() string {
+	times := make([]string, len(s))
+	for i, at := range s {
+		times[i] = fmt.Sprintf("%02d:%02d", int(at/time.Hour), int(at%time.Hour/time.Minute))
+	}
+	return strings.Join(times, ",")
+}
+
+// next returns the first scheduled time after now, in now's location. It
+// goes by the wall clock, so a sync at 03:00 stays at 03:00 across
+// daylight saving changes.
+func (s Schedule) next(now time.Time) time.Time {
+	for day := 0; ; day++ {
+		for _, at := range s {
+			t := time.Date(now.Year(), now.Month(), now.Day()+day, int(at/time.Hour), int(at%time.Hour/time.Minute), 0, 0, now.Location())
+			if t.After(now) {
+				return t
+			}
+		}
+	}
+}
+
+// StartSchedule launches background goroutines that sync at each of the
+// schedule's times every day, whether or not anything was written over
+// S3, so edits made behind git3's back are committed too, and that roll
+// up unpushed commits at each of the rollup's times. Does nothing without
+// either.
+func (gs *Syncer) StartSchedule() {
+	if gs.repo == nil {
+		return
+	}
+	if len(gs.schedule) > 0 {
+		log.Printf("[git] scheduled syncs daily at %s, heartbeat=%v", gs.schedule, gs.heartbeat)
+		go gs.runDaily(gs.schedule, gs.scheduledSync)
+	}
+	if len(gs.rollup) > 0 {
+		log.Printf("[git] rollups of unpushed commits daily at %s", gs.rollup)
+		go gs.runDaily(gs.rollup, gs.scheduledRollup)
+	}
+}
+
+// runDaily calls run at each of sched's times until Close.
+func (gs *Syncer) runDaily(sched Schedule, run func() SyncResult) {
+	for {
+		timer := time.NewTimer(time.Until(sched.next(time.Now())))
+		select {
+		case <-timer.C:
+			run()
+		case <-gs.stop:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// scheduledSync runs a sync from the schedule. It is an ordinary sync,
+// taking any pending changes with it and replacing a debounce timer that
+// is armed, except that it stages the whole tree rather than the paths
+// written; with heartbeats on, finding nothing to commit, it makes an
+// empty commit instead.
+func (gs *Syncer) scheduledSync() SyncResult {
+	gs.mu.Lock()
+	gs.addPendingLocked(nil)
+	gs.heartbeatDue = gs.heartbeat
+	gs.mu.Unlock()
+	return gs.Sync()
+}
+
+// heartbeatLocked commits the head's tree again, so the history shows
+// the server was up even when nothing changed. Caller must hold gs.mu.
+func (gs *Syncer) heartbeatLocked() error {
+	if gs.replica {
+		return nil
+	}
+	start := time.Now()
+	ref := plumbing.NewBranchReferenceName(gs.branch)
+	head, err := gs.repo.Reference(ref, true)
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return nil // nothing to beat on top of yet
+	} else if err != nil {
+		return err
+	}
+	parent, err := gs.repo.CommitObject(head.Hash())
+	if err != nil {
+		return err
+	}
+	author, committer := gs.signatures()
+	hash, err := gs.storeCommit(&object.Commit{
+		Author:       author,
+		Committer:    committer,
+		Message:      fmt.Sprintf("git3: heartbeat %s", start.Format("2006-01-02 15:04")),
+		TreeHash:     parent.TreeHash,
+		ParentHashes: []plumbing.Hash{parent.Hash},
+	})
+	if err == nil {
+		err = gs.repo.Storer.SetReference(plumbing.NewHashReference(ref, hash))
+	}
+	if err != nil {
+		gs.events.add(Event{Op: "commit", Result: "failed", Error: err.Error()}, start)
+		return fmt.Errorf("heartbeat commit failed: %w", err)
+	}
+	gs.status.LastCommitTime = time.Now()
+	gs.status.LastCommitHash = hash.String()
+	gs.status.LastCommitFiles = 0
+	gs.status.Commits++
+	gs.events.add(Event{Op: "commit", Result: "heartbeat", Hash: hash.String()}, start)
+	return nil
+}
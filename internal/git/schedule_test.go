@@ -0,0 +1,74 @@
+package git
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	s, err := ParseSchedule("15:30, 03:00,03:00")
+	if err != nil || s.String() != "03:00,15:30" {
+		t.Fatalf("ParseSchedule = %v, %v", s, err)
+	}
+	for _, bad := range []string{"3am", "24:00", "03:60"} {
+		if _, err := ParseSchedule(bad); err == nil {
+			t.Errorf("ParseSchedule(%q) accepted", bad)
+		}
+	}
+
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2026, 3, day, hour, minute, 0, 0, time.UTC)
+	}
+	for now, want := range map[time.Time]time.Time{
+		at(1, 1, 0):   at(1, 3, 0),
+		at(1, 3, 0):   at(1, 15, 30),
+		at(1, 16, 0):  at(2, 3, 0),
+		at(31, 23, 0): time.Date(2026, 4, 1, 3, 0, 0, 0, time.UTC),
+	} {
+		if got := s.next(now); !got.Equal(want) {
+			t.Errorf("next(%s) = %s, want %s", now, got, want)
+		}
+	}
+}
+
+func TestScheduledSync(t *testing.T) {
+	remote := newRemote(t, map[string]string{"a.md": "a"})
+	cfg := Config{Dir: t.TempDir(), Repo: remote, Branch: "main", User: "Test", Email: "test@test.com", Debounce: time.Hour}
+	syncer := New(cfg, mustInitRepo(t, cfg))
+	head := remoteHash(t, remote, "main")
+
+	// A clean tree without heartbeats: nothing is committed
+	if result := syncer.scheduledSync(); result.Commit != "" || result.Err != nil || remoteHash(t, remote, "main") != head {
+		t.Fatalf("scheduled sync of a clean tree = %+v", result)
+	}
+
+	// An edit made behind the syncer's back is picked up, along with a
+	// pending trigger
+	os.WriteFile(filepath.Join(cfg.Dir, "edited.md"), []byte("by hand"), 0644)
+	os.WriteFile(filepath.Join(cfg.Dir, "put.md"), []byte("over S3"), 0644)
+	syncer.Trigger("put.md")
+	result := syncer.scheduledSync()
+	if result.Files != 2 || !result.Pushed || syncer.Status().PendingTrigger {
+		t.Fatalf("scheduled sync = %+v, pending %v", result, syncer.Status().PendingTrigger)
+	}
+
+	// With heartbeats, a clean tree gets an empty commit, but only from
+	// the schedule
+	syncer.heartbeat = true
+	if result := syncer.Sync(); result.Commit != "" {
+		t.Fatalf("triggered sync made a heartbeat: %+v", result)
+	}
+	before := remoteTree(t, remote, "main").Hash
+	result = syncer.scheduledSync()
+	if result.Commit == "" || !result.Pushed || result.Err != nil {
+		t.Fatalf("heartbeat = %+v", result)
+	}
+	if got := remoteHash(t, remote, "main").String(); got != result.Commit || remoteTree(t, remote, "main").Hash != before {
+		t.Fatalf("remote at %s, want the heartbeat %s with the same tree", got, result.Commit)
+	}
+	if !containsEvent(syncer.Events(), "commit", "heartbeat") {
+		t.Fatalf("events = %+v", syncer.Events())
+	}
+}
@@ -0,0 +1,219 @@
+package git
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+)
+
+// Secret scan modes, for Config.SecretScan: what a sync does when a
+// staged change looks like it holds a credential.
+const (
+	// SecretsOff commits whatever changed. This is the default.
+	SecretsOff = "off"
+	// SecretsBlock commits nothing while any staged change holds a secret.
+	SecretsBlock = "block"
+	// SecretsSkip commits the other changes, leaving those holding a
+	// secret uncommitted.
+	SecretsSkip = "skip"
+)
+
+// SecretFinding is a line of a file that looks like it holds a secret.
+// The secret itself is never recorded.
+type SecretFinding struct {
+	Path string `json:"path"`
+	Rule string `json:"rule"`
+	Line int    `json:"line"`
+}
+
+// secretRule matches a kind of secret. With minEntropy set, the match (or
+// its first group) must also look random enough, so that placeholders
+// like "password = changeme" don't count.
+type secretRule struct {
+	name       string
+	re         *regexp.Regexp
+	minEntropy float64
+}
+
+// secretRules are the built-in rules, for the credentials most likely to
+// be pasted into a note.
+var secretRules = []secretRule{
+	{name: "aws-access-key-id", re: regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{name: "aws-secret-access-key", re: regexp.MustCompile(`(?i)aws_?secret_?access_?key\s*[:=]\s*["']?([A-Za-z0-9/+=]{40})`)},
+	{name: "private-key", re: regexp.MustCompile(`-----BEGIN (?:[A-Z0-9]+ )*PRIVATE KEY( BLOCK)?-----`)},
+	{name: "github-token", re: regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{60,})\b`)},
+	{name: "gitlab-token", re: regexp.MustCompile(`\bglpat-[A-Za-z0-9_-]{20,}\b`)},
+	{name: "slack-token", re: regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}\b`)},
+	{name: "stripe-key", re: regexp.MustCompile(`\b[rs]k_live_[A-Za-z0-9]{20,}\b`)},
+	{name: "google-api-key", re: regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}\b`)},
+	{name: "api-key", re: regexp.MustCompile(`\bsk-[A-Za-z0-9_-]{32,}\b`), minEntropy: 3.5},
+	{name: "generic-secret", re: regexp.MustCompile(`(?i)\b(?:api[_-]?key|secret|token|passw(?:or)?d)\b["']?\s*[:=]\s*["']?([A-Za-z0-9+/_.=-]{16,})`), minEntropy: 3.5},
+}
+
+// CompileSecretPatterns compiles regular expressions to scan with besides
+// the built-in rules, for Config.SecretPatterns.
+func CompileSecretPatterns(patterns []string) ([]*regexp.Regexp, error) {
+	res := make([]*regexp.Regexp, len(patterns))
+	for i, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("secret pattern %q: %w", p, err)
+		}
+		res[i] = re
+	}
+	return res, nil
+}
+
+// entropy is the Shannon entropy of s in bits per character.
+func entropy(s string) float64 {
+	counts := make(map[rune]int)
+	n := 0
+	for _, r := range s {
+		counts[r]++
+		n++
+	}
+	var h float64
+	for _, c := range counts {
+		p := float64(c) / float64(n)
+		h -= p * math.Log2(p)
+	}
+	return h
+}
+
+// scanSecrets returns the lines of text, the content of the file at name,
+// that match a rule.
+func scanSecrets(name, text string, rules []secretRule) []SecretFinding {
+	var findings []SecretFinding
+	for i, line := range strings.Split(text, "\n") {
+		for _, rule := range rules {
+			for _, m := range rule.re.FindAllStringSubmatch(line, -1) {
+				secret := m[0]
+				if len(m) > 1 && m[1] != "" {
+					secret = m[1]
+				}
+				if rule.minEntropy == 0 || entropy(secret) >= rule.minEntropy {
+					findings = append(findings, SecretFinding{Path: name, Rule: rule.name, Line: i + 1})
+					break
+				}
+			}
+		}
+	}
+	return findings
+}
+
+// holdSecretsLocked scans the staged content of paths, the changes about
+// to be committed, and unstages those holding a secret. It returns the
+// paths left to commit: none in block mode if anything was found. Held
+// paths stay pending, so every sync scans them again until their secret
+// is gone. Caller must hold gs.mu.
+func (gs *Syncer) holdSecretsLocked(paths []string) ([]string, error) {
+	idx, err := gs.repo.Storer.Index()
+	if err != nil {
+		return nil, err
+	}
+	allow := gitignore.NewMatcher(gs.secretAllow)
+	var clean, held []string
+	var findings []SecretFinding
+	for _, name := range paths {
+		rel := strings.TrimPrefix(name, gs.subdir+"/")
+		// Removals, allowed paths and binaries aren't scanned
+		var found []SecretFinding
+		if e, err := idx.Entry(name); err == nil && !allow.Match(strings.Split(rel, "/"), false) {
+			if text, ok := gs.readText(e.Hash); ok {
+				found = scanSecrets(rel, text, gs.secretRules)
+			}
+		}
+		if len(found) == 0 {
+			clean = append(clean, name)
+			continue
+		}
+		held = append(held, name)
+		findings = append(findings, found...)
+	}
+
+	for _, f := range findings {
+		if !slices.Contains(gs.status.SecretFindings, f) {
+			log.Printf("[git] SECRET: %s line %d looks like a %s; not committing it", f.Path, f.Line, f.Rule)
+		}
+	}
+	gs.status.SecretFindings = findings
+	if len(held) == 0 {
+		return clean, nil
+	}
+
+	if err := gs.unstageLocked(idx, held); err != nil {
+		return nil, err
+	}
+	requeue := held
+	result := "held"
+	if gs.secretScan == SecretsBlock {
+		requeue, clean, result = paths, nil, "blocked"
+	}
+	for _, name := range requeue {
+		gs.addPendingLocked([]string{strings.TrimPrefix(name, gs.subdir+"/")})
+	}
+	gs.events.add(Event{Op: "commit", Result: result,
+		Error: fmt.Sprintf("secrets found in %d files", len(held))}.withPaths(held), time.Now())
+	return clean, nil
+}
+
+// unstageLocked resets the index entries of names to HEAD, leaving the
+// working tree alone. Caller must hold gs.mu.
+func (gs *Syncer) unstageLocked(idx *index.Index, names []string) error {
+	var committed map[string]*index.Entry
+	if head, err := gs.repo.Head(); err == nil {
+		commit, err := gs.repo.CommitObject(head.Hash())
+		if err != nil {
+			return err
+		}
+		tree, err := commit.Tree()
+		if err != nil {
+			return err
+		}
+		committed = make(map[string]*index.Entry)
+		for _, name := range names {
+			if f, err := tree.File(name); err == nil {
+				committed[name] = &index.Entry{Hash: f.Hash, Mode: f.Mode, Size: uint32(f.Size)}
+			}
+		}
+	}
+	for _, name := range names {
+		c, ok := committed[name]
+		if !ok {
+			idx.Remove(name)
+			continue
+		}
+		if e, err := idx.Entry(name); err == nil {
+			// A zero time makes status hash the file rather than trust it
+			e.Hash, e.Mode, e.Size, e.ModifiedAt = c.Hash, c.Mode, c.Size, time.Time{}
+		}
+	}
+	return gs.repo.Storer.SetIndex(idx)
+}
+
+// secretRulesFor is the built-in rules and extra patterns, named by their
+// position, to scan with.
+func secretRulesFor(extra []*regexp.Regexp) []secretRule {
+	rules := append([]secretRule(nil), secretRules...)
+	for i, re := range extra {
+		rules = append(rules, secretRule{name: fmt.Sprintf("pattern-%d", i+1), re: re})
+	}
+	return rules
+}
+
+// parseAllowPatterns parses gitignore-style patterns naming paths, relative
+// to the served directory, the scan leaves alone.
+func parseAllowPatterns(patterns []string) []gitignore.Pattern {
+	var ps []gitignore.Pattern
+	for _, p := range patterns {
+		ps = append(ps, gitignore.ParsePattern(p, nil))
+	}
+	return ps
+}
@@ -0,0 +1,166 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// repoSizer keeps the size of the repository, .git, up to date without
+// reading all of it each time. It remembers the size of the files in each
+// directory with the directory's modification time, and reads only the
+// directories whose time moved: adding or removing a file, as writing a
+// loose object or a pack does, moves it. .git itself, whose index and
+// refs are rewritten in place, is always read.
+type repoSizer struct {
+	mu   sync.Mutex
+	dirs map[string]dirUsage // by path; nil until measured in full
+}
+
+// dirUsage is what repoSizer remembers about a directory.
+type dirUsage struct {
+	modTime time.Time
+	files   int64 // total size of the regular files directly in it
+	subdirs []string
+}
+
+// measure reads the whole repository at root, and keeps what it read for
+// update. It doesn't hold r.mu while it reads, so update needn't wait.
+func (r *repoSizer) measure(root string) (int64, error) {
+	dirs := make(map[string]dirUsage)
+	size, err := walkUsage(root, root, nil, dirs)
+	if err != nil {
+		return 0, err
+	}
+	r.mu.Lock()
+	r.dirs = dirs
+	r.mu.Unlock()
+	return size, nil
+}
+
+// update measures the repository at root again, reading only what changed
+// since the last measure or update. It reports false, reading nothing,
+// if the repository wasn't measured in full yet.
+func (r *repoSizer) update(root string) (int64, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.dirs == nil {
+		return 0, false, nil
+	}
+	dirs := make(map[string]dirUsage, len(r.dirs))
+	size, err := walkUsage(root, root, r.dirs, dirs)
+	if err != nil {
+		return 0, true, err
+	}
+	r.dirs = dirs
+	return size, true, nil
+}
+
+// walkUsage adds up the files under dir, reusing what known has for each
+// directory whose modification time hasn't moved, and records every
+// directory in dirs. A directory is stat'ed before it is read, so one that
+// changes in between is read again next time.
+func walkUsage(root, dir string, known, dirs map[string]dirUsage) (int64, error) {
+	info, err := os.Lstat(dir)
+	if errors.Is(err, fs.ErrNotExist) && dir != root {
+		return 0, nil // removed since its parent was read
+	}
+	if err != nil {
+		return 0, err
+	}
+	usage, ok := known[dir]
+	if !ok || dir == root || !usage.modTime.Equal(info.ModTime()) {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return 0, err
+		}
+		usage = dirUsage{modTime: info.ModTime()}
+		for _, e := range entries {
+			switch {
+			case e.IsDir():
+				usage.subdirs = append(usage.subdirs, filepath.Join(dir, e.Name()))
+			case e.Type().IsRegular():
+				fi, err := e.Info()
+				if errors.Is(err, fs.ErrNotExist) {
+					continue
+				}
+				if err != nil {
+					return 0, err
+				}
+				usage.files += fi.Size()
+			}
+		}
+	}
+	dirs[dir] = usage
+	size := usage.files
+	for _, sub := range usage.subdirs {
+		n, err := walkUsage(root, sub, known, dirs)
+		if err != nil {
+			return 0, err
+		}
+		size += n
+	}
+	return size, nil
+}
+
+// RepoSize returns the size of the repository, .git, as of the last commit,
+// pull or recovery, and the configured limit; the size is 0 until the
+// measure New starts in the background is in. It never waits for a sync.
+func (gs *Syncer) RepoSize() (size, limit int64) {
+	return gs.repoBytes.Load(), gs.sizeLimit
+}
+
+// measureLocked measures the repository after something may have grown
+// or shrunk it, reading only the directories that changed since it was
+// last measured. Until the full measure New starts is in, it does
+// nothing. Caller must hold gs.mu.
+func (gs *Syncer) measureLocked() {
+	if gs.repo == nil {
+		return
+	}
+	size, ok, err := gs.sizer.update(filepath.Join(gs.dir, ".git"))
+	if err != nil {
+		log.Printf("[git] measure repository: %v", err)
+		return
+	}
+	if ok {
+		gs.recordSizeLocked(size)
+	}
+}
+
+// remeasure measures the whole repository, without holding gs.mu while it
+// reads, so a large one doesn't hold up syncs.
+func (gs *Syncer) remeasure() {
+	size, err := gs.sizer.measure(filepath.Join(gs.dir, ".git"))
+	if err != nil {
+		log.Printf("[git] measure repository: %v", err)
+		return
+	}
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.recordSizeLocked(size)
+}
+
+// recordSizeLocked records the repository's size, warning when it goes
+// over the size limit and noting when it is back under. Caller must hold
+// gs.mu.
+func (gs *Syncer) recordSizeLocked(size int64) {
+	gs.repoBytes.Store(size)
+	gs.status.RepoBytes = size
+	over := gs.sizeLimit > 0 && size >= gs.sizeLimit
+	switch {
+	case over && !gs.status.OverSizeLimit:
+		msg := fmt.Sprintf("repository is %d bytes, over the %d byte limit", size, gs.sizeLimit)
+		log.Printf("[git] WARNING: %s; pushes may soon be refused by the host", msg)
+		gs.events.add(Event{Op: "size", Result: "over limit", Error: msg}, time.Now())
+	case !over && gs.status.OverSizeLimit:
+		log.Printf("[git] repository is %d bytes, back under the %d byte limit", size, gs.sizeLimit)
+		gs.events.add(Event{Op: "size", Result: "under limit"}, time.Now())
+	}
+	gs.status.OverSizeLimit = over
+}
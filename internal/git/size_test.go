@@ -0,0 +1,71 @@
+package git
+
+import (
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRepoSizeLimit(t *testing.T) {
+	cfg := Config{Dir: t.TempDir(), Branch: "main", User: "Test", Email: "test@test.com"}
+	repo := mustInitRepo(t, cfg)
+	measured := New(cfg, repo)
+	measured.remeasure() // as New does in the background
+	initial, _ := measured.RepoSize()
+	if initial <= 0 {
+		t.Fatalf("initial size = %d", initial)
+	}
+
+	cfg.SizeLimit = initial + 50_000
+	syncer := New(cfg, repo)
+	syncer.remeasure()
+	if st := syncer.Status(); st.OverSizeLimit || st.RepoBytes != initial {
+		t.Fatalf("status of a small repository = %+v", st)
+	}
+
+	// Random content doesn't compress
+	data := make([]byte, 100_000)
+	rand.Read(data)
+	os.WriteFile(filepath.Join(cfg.Dir, "photo.jpg"), data, 0644)
+	syncer.Sync()
+
+	size, limit := syncer.RepoSize()
+	st := syncer.Status()
+	if size < initial+100_000 || limit != cfg.SizeLimit || !st.OverSizeLimit || st.RepoBytes != size {
+		t.Fatalf("after a large commit: size %d, limit %d, status %+v", size, limit, st)
+	}
+	events := syncer.Events()
+	if e := events[len(events)-1]; e.Op != "size" || e.Result != "over limit" {
+		t.Fatalf("last event = %+v", e)
+	}
+}
+
+func TestRepoSizeUpdate(t *testing.T) {
+	cfg := Config{Dir: t.TempDir(), Branch: "main", User: "Test", Email: "test@test.com"}
+	syncer := New(cfg, mustInitRepo(t, cfg))
+	gitDir := filepath.Join(cfg.Dir, ".git")
+
+	syncer.remeasure()
+	initial, _ := syncer.RepoSize()
+
+	// A new loose object and a new pack, as a commit and a fetch write them
+	os.MkdirAll(filepath.Join(gitDir, "objects", "ab"), 0755)
+	os.WriteFile(filepath.Join(gitDir, "objects", "ab", "cdef"), make([]byte, 1000), 0644)
+	os.MkdirAll(filepath.Join(gitDir, "objects", "pack"), 0755)
+	os.WriteFile(filepath.Join(gitDir, "objects", "pack", "pack-1.pack"), make([]byte, 5000), 0644)
+	syncer.mu.Lock()
+	syncer.measureLocked()
+	syncer.mu.Unlock()
+	if size, _ := syncer.RepoSize(); size != initial+6000 {
+		t.Fatalf("after adding 6000 bytes: %d, want %d", size, initial+6000)
+	}
+
+	os.Remove(filepath.Join(gitDir, "objects", "pack", "pack-1.pack"))
+	syncer.mu.Lock()
+	syncer.measureLocked()
+	syncer.mu.Unlock()
+	if size, _ := syncer.RepoSize(); size != initial+1000 {
+		t.Fatalf("after removing the pack: %d, want %d", size, initial+1000)
+	}
+}
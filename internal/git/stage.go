@@ -0,0 +1,177 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// fullStageInterval is how often a sync stages the whole tree even if it
+// was only triggered for some paths, to pick up changes made outside the
+// S3 API.
+var fullStageInterval = 10 * time.Minute
+
+// stageAllLocked stages the served tree and returns the paths that differ
+// from HEAD. Caller must hold gs.mu.
+func (gs *Syncer) stageAllLocked(wt *gogit.Worktree) ([]string, error) {
+	// Only the served subdirectory is staged; anything else in the
+	// repository is left exactly as the last pull put it.
+	root := "."
+	if gs.subdir != "" {
+		root = gs.subdir
+	}
+	gs.drainWritesLocked()
+	err := wt.AddGlob(root)
+	gs.writes.release()
+	if err != nil {
+		return nil, fmt.Errorf("add failed: %w", err)
+	}
+
+	status, err := wt.Status()
+	if err != nil {
+		return nil, fmt.Errorf("status failed: %w", err)
+	}
+	var paths []string
+	for path, st := range status {
+		if st.Staging != gogit.Unmodified && st.Staging != gogit.Untracked {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// stagePathsLocked stages only the triggered paths, without the status
+// pass over the whole tree, and returns those that differ from HEAD.
+// Caller must hold gs.mu.
+func (gs *Syncer) stagePathsLocked(wt *gogit.Worktree) ([]string, error) {
+	names := make([]string, 0, len(gs.pendingPaths))
+	for p := range gs.pendingPaths {
+		names = append(names, path.Join(gs.subdir, p))
+	}
+	sort.Strings(names)
+
+	gs.drainWritesLocked()
+	err := gs.stageLocked(wt, names)
+	gs.writes.release()
+	if err != nil {
+		return nil, fmt.Errorf("add failed: %w", err)
+	}
+	return gs.stagedChangesLocked(names)
+}
+
+// stageLocked brings the index entries of names in line with the working
+// tree. Caller must hold gs.mu.
+func (gs *Syncer) stageLocked(wt *gogit.Worktree, names []string) error {
+	idx, err := gs.repo.Storer.Index()
+	if err != nil {
+		return err
+	}
+	var add []string
+	removed := false
+	for _, name := range names {
+		_, untracked := idx.Entry(name)
+		_, err := os.Lstat(filepath.Join(gs.dir, filepath.FromSlash(name)))
+		switch {
+		case os.IsNotExist(err):
+			if _, err := idx.Remove(name); err == nil {
+				removed = true
+			}
+		case err != nil:
+			return err
+		case untracked != nil && gs.ignored(name):
+			// A full status would leave it untracked too
+		default:
+			add = append(add, name)
+		}
+	}
+	if removed {
+		if err := gs.repo.Storer.SetIndex(idx); err != nil {
+			return err
+		}
+	}
+	for _, name := range add {
+		if err := wt.AddWithOptions(&gogit.AddOptions{Path: name, SkipStatus: true}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stagedChangesLocked returns those of names whose index entry differs
+// from HEAD. Caller must hold gs.mu.
+func (gs *Syncer) stagedChangesLocked(names []string) ([]string, error) {
+	idx, err := gs.repo.Storer.Index()
+	if err != nil {
+		return nil, err
+	}
+	var tree *object.Tree
+	if head, err := gs.repo.Head(); err == nil {
+		commit, err := gs.repo.CommitObject(head.Hash())
+		if err != nil {
+			return nil, err
+		}
+		if tree, err = commit.Tree(); err != nil {
+			return nil, err
+		}
+	} else if !errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return nil, err
+	}
+
+	var changed []string
+	for _, name := range names {
+		var staged, committed plumbing.Hash
+		if e, err := idx.Entry(name); err == nil {
+			staged = e.Hash
+		}
+		if tree != nil {
+			if f, err := tree.File(name); err == nil {
+				committed = f.Hash
+			}
+		}
+		if staged != committed {
+			changed = append(changed, name)
+		}
+	}
+	return changed, nil
+}
+
+// ignored reports whether an untracked name is ignored, going by
+// .git/info/exclude and the .gitignore files in its directories.
+func (gs *Syncer) ignored(name string) bool {
+	patterns := readIgnoreFile(filepath.Join(gs.dir, ".git", "info", "exclude"), nil)
+	parts := strings.Split(name, "/")
+	for i := range parts {
+		domain := parts[:i]
+		file := filepath.Join(gs.dir, filepath.FromSlash(strings.Join(domain, "/")), ".gitignore")
+		patterns = append(patterns, readIgnoreFile(file, domain)...)
+	}
+	return gitignore.NewMatcher(patterns).Match(parts, false)
+}
+
+// readIgnoreFile parses the gitignore file at path, whose patterns apply
+// below domain. A missing file has none.
+func readIgnoreFile(path string, domain []string) []gitignore.Pattern {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var patterns []gitignore.Pattern
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, "#") || strings.TrimSpace(line) == "" {
+			continue
+		}
+		patterns = append(patterns, gitignore.ParsePattern(strings.TrimRight(line, "\r"), domain))
+	}
+	return patterns
+}
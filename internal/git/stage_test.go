@@ -0,0 +1,131 @@
+package git
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+func headFiles(t *testing.T, syncer *Syncer) []string {
+	t.Helper()
+	head, err := syncer.repo.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit, err := syncer.repo.CommitObject(head.Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range tree.Entries {
+		names = append(names, e.Name)
+	}
+	return names
+}
+
+func TestTriggerStagesPaths(t *testing.T) {
+	cfg := Config{Dir: t.TempDir(), Branch: "main", User: "Test", Email: "test@test.com", Debounce: time.Hour}
+	syncer := New(cfg, mustInitRepo(t, cfg))
+	write := func(name, content string) {
+		os.WriteFile(filepath.Join(cfg.Dir, name), []byte(content), 0644)
+	}
+
+	// The first sync stages everything
+	write("a.md", "a")
+	write(".gitignore", "*.tmp\n")
+	syncer.Trigger("a.md")
+	syncer.doSync()
+	if got := headFiles(t, syncer); !slices.Equal(got, []string{".gitignore", "a.md"}) {
+		t.Fatalf("first sync committed %v", got)
+	}
+
+	// Later ones only what they were triggered for
+	write("b.md", "changed outside the API")
+	write("c.md", "c")
+	write("x.tmp", "ignored")
+	syncer.Trigger("c.md", "x.tmp")
+	syncer.doSync()
+	if got := headFiles(t, syncer); !slices.Equal(got, []string{".gitignore", "a.md", "c.md"}) {
+		t.Fatalf("sync for c.md committed %v", got)
+	}
+	if status := syncer.Status(); status.LastCommitFiles != 1 {
+		t.Fatalf("LastCommitFiles = %d, want 1", status.LastCommitFiles)
+	}
+
+	os.Remove(filepath.Join(cfg.Dir, "a.md"))
+	syncer.Trigger("a.md")
+	syncer.doSync()
+	if got := headFiles(t, syncer); !slices.Equal(got, []string{".gitignore", "c.md"}) {
+		t.Fatalf("sync for deleted a.md committed %v", got)
+	}
+
+	// Nothing changed
+	commits := syncer.Status().Commits
+	syncer.Trigger("c.md")
+	syncer.doSync()
+	if syncer.Status().Commits != commits {
+		t.Fatal("sync for an unchanged path committed")
+	}
+
+	// A trigger without paths, or the periodic full pass, catches the rest
+	syncer.Trigger()
+	syncer.doSync()
+	if got := headFiles(t, syncer); !slices.Equal(got, []string{".gitignore", "b.md", "c.md"}) {
+		t.Fatalf("full sync committed %v", got)
+	}
+}
+
+func TestTriggerStagesPathsInSubdir(t *testing.T) {
+	cfg := Config{Dir: t.TempDir(), Branch: "main", User: "Test", Email: "test@test.com", Subdir: "notes", Debounce: time.Hour}
+	syncer := New(cfg, mustInitRepo(t, cfg))
+	syncer.doSync()
+
+	os.WriteFile(filepath.Join(cfg.Dir, "notes", "a.md"), []byte("a"), 0644)
+	syncer.Trigger("a.md")
+	syncer.doSync()
+	if got := committedFile(t, syncer, plumbing.NewHash(syncer.Status().LastCommitHash), "notes/a.md"); got != "a" {
+		t.Fatalf("notes/a.md = %q", got)
+	}
+}
+
+func TestDeferredImport(t *testing.T) {
+	var results []SyncResult
+	cfg := Config{Dir: t.TempDir(), Branch: "main", User: "Test", Email: "test@test.com", Debounce: 20 * time.Millisecond,
+		OnSync: func(r SyncResult) { results = append(results, r) }}
+	syncer := New(cfg, mustInitRepo(t, cfg))
+
+	for _, name := range []string{"a.md", "b.md", "c.md"} {
+		os.WriteFile(filepath.Join(cfg.Dir, name), []byte(name), 0644)
+		syncer.Defer(name)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if len(results) != 0 || syncer.Status().PendingTrigger {
+		t.Fatalf("deferred writes scheduled a sync: %v", results)
+	}
+
+	result := syncer.Sync()
+	if len(results) != 1 || result.Files != 3 {
+		t.Fatalf("flush = %+v after %d syncs, want one commit of 3 files", result, len(results))
+	}
+
+	// A deferred change rides along with the next triggered one
+	os.WriteFile(filepath.Join(cfg.Dir, "d.md"), []byte("d"), 0644)
+	syncer.Defer("d.md")
+	os.WriteFile(filepath.Join(cfg.Dir, "e.md"), []byte("e"), 0644)
+	syncer.Trigger("e.md")
+	if err := syncer.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := headFiles(t, syncer); !slices.Equal(got, []string{"a.md", "b.md", "c.md", "d.md", "e.md"}) {
+		t.Fatalf("triggered sync committed %v", got)
+	}
+}
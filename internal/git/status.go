@@ -0,0 +1,88 @@
+package git
+
+import "time"
+
+// Status is a snapshot of what the Syncer has done since it started.
+type Status struct {
+	LastCommitTime  time.Time `json:"last_commit_time"`
+	LastCommitHash  string    `json:"last_commit_hash"`
+	LastCommitFiles int       `json:"last_commit_files"` // paths added, changed or removed
+	LastPushTime    time.Time `json:"last_push_time"`
+	LastPushError   string    `json:"last_push_error,omitempty"` // cleared by the next successful push
+	LastPullTime    time.Time `json:"last_pull_time"`
+	PendingTrigger  bool      `json:"pending_trigger"` // a debounced sync is waiting to run
+	PendingPush     bool      `json:"pending_push"`    // commits are waiting for the push debounce
+	Ahead           int       `json:"ahead"`           // commits not yet pushed, as of the last fetch or push
+	Commits         int       `json:"commits"`
+	Amends          int       `json:"amends"`  // commits that replaced an unpushed one; see Config.RollupWindow
+	Rollups         int       `json:"rollups"` // squashes of unpushed commits into one; see Config.Rollup
+	Pushes          int       `json:"pushes"`
+	PullsSkipped    int       `json:"pulls_skipped"` // scheduled pulls left to a pending or running sync
+
+	// PullRequest is the number of the pull request open from the push
+	// branch in pull-request mode, and PullRequestURL its web page
+	PullRequest    int    `json:"pull_request,omitempty"`
+	PullRequestURL string `json:"pull_request_url,omitempty"`
+
+	// ReplicaOf is the remote a pull-only replica follows, until it is
+	// promoted; see Config.Replica
+	ReplicaOf string `json:"replica_of,omitempty"`
+
+	// Offline is set while the remote can't be reached; pulls and pushes
+	// back off until it answers again
+	Offline      bool      `json:"offline"`
+	OfflineSince time.Time `json:"offline_since"`
+
+	// Remote is what the last probe of the remote found, once one ran;
+	// see StartRemoteProbe
+	Remote *RemoteHealth `json:"remote,omitempty"`
+
+	// RepoBytes is the size of .git as of the last commit or pull, and
+	// OverSizeLimit is set while that is over Config.SizeLimit
+	RepoBytes     int64 `json:"repo_bytes"`
+	OverSizeLimit bool  `json:"over_size_limit,omitempty"`
+
+	// SecretFindings are the lines of changes held back from commits
+	// because they look like they hold a secret; see Config.SecretScan
+	SecretFindings []SecretFinding `json:"secret_findings,omitempty"`
+
+	CorruptError      string    `json:"corrupt_error,omitempty"` // why the repository was last found corrupt, until recovered
+	LastRecoveryTime  time.Time `json:"last_recovery_time"`
+	LastRecoveryError string    `json:"last_recovery_error,omitempty"`
+	Recoveries        int       `json:"recoveries"`
+}
+
+// Status reports the Syncer's recent activity.
+func (gs *Syncer) Status() Status {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	return gs.statusLocked()
+}
+
+// TryStatus is Status for callers that must stay responsive during a long
+// push or pull. It waits at most timeout for the sync to let go and reports
+// false if it didn't.
+func (gs *Syncer) TryStatus(timeout time.Duration) (Status, bool) {
+	deadline := time.Now().Add(timeout)
+	for !gs.mu.TryLock() {
+		if time.Now().After(deadline) {
+			return Status{}, false
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	defer gs.mu.Unlock()
+	return gs.statusLocked(), true
+}
+
+// statusLocked returns the status with the commits ahead of the remote
+// counted. Caller must hold gs.mu.
+func (gs *Syncer) statusLocked() Status {
+	st := gs.status
+	st.Remote = gs.remoteHealth.Load()
+	if n, err := gs.commitsAheadLocked(); err != nil {
+		gs.debugf("counting commits ahead: %v", err)
+	} else {
+		st.Ahead = n
+	}
+	return st
+}
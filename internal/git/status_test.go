@@ -0,0 +1,91 @@
+package git
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStatusAfterSync(t *testing.T) {
+	remote := newRemote(t, map[string]string{"a.md": "a"})
+	cfg := Config{Dir: t.TempDir(), Repo: remote, Branch: "main", User: "Test", Email: "test@test.com", Debounce: time.Hour}
+	repo := mustInitRepo(t, cfg)
+	syncer := New(cfg, repo)
+
+	if st := syncer.Status(); st.Commits != 0 || st.Pushes != 0 || !st.LastCommitTime.IsZero() {
+		t.Fatalf("fresh syncer status = %+v", st)
+	}
+
+	os.WriteFile(filepath.Join(cfg.Dir, "b.md"), []byte("b"), 0644)
+	syncer.Trigger()
+	if !syncer.Status().PendingTrigger {
+		t.Fatal("PendingTrigger not set after Trigger")
+	}
+	syncer.doSync()
+
+	st := syncer.Status()
+	head, _ := repo.Head()
+	if st.PendingTrigger {
+		t.Fatal("PendingTrigger still set after sync")
+	}
+	if st.Commits != 1 || st.Pushes != 1 || st.LastCommitHash != head.Hash().String() || st.LastCommitFiles != 1 {
+		t.Fatalf("status = %+v, want one commit and push of %s", st, head.Hash())
+	}
+	if st.LastCommitTime.IsZero() || st.LastPushTime.IsZero() || st.LastPullTime.IsZero() || st.LastPushError != "" {
+		t.Fatalf("status = %+v, want commit, push and pull times and no error", st)
+	}
+}
+
+func TestStatusRecordsPushError(t *testing.T) {
+	remote := newRemote(t, map[string]string{"a.md": "a"})
+	cfg := Config{Dir: t.TempDir(), Repo: remote, Branch: "main", User: "Test", Email: "test@test.com"}
+	repo := mustInitRepo(t, cfg)
+	syncer := New(cfg, repo)
+
+	os.RemoveAll(remote)
+	os.WriteFile(filepath.Join(cfg.Dir, "b.md"), []byte("b"), 0644)
+	syncer.doSync()
+
+	st := syncer.Status()
+	if st.Commits != 1 || st.Pushes != 0 || st.LastPushError == "" || !st.LastPushTime.IsZero() {
+		t.Fatalf("status = %+v, want a commit and a recorded push error", st)
+	}
+}
+
+func TestTryStatusDoesNotWaitForSync(t *testing.T) {
+	syncer := New(Config{Dir: t.TempDir()}, nil)
+
+	syncer.mu.Lock()
+	start := time.Now()
+	_, ok := syncer.TryStatus(20 * time.Millisecond)
+	syncer.mu.Unlock()
+	if ok || time.Since(start) > time.Second {
+		t.Fatalf("TryStatus = %v after %s while the syncer was busy", ok, time.Since(start))
+	}
+
+	if _, ok := syncer.TryStatus(20 * time.Millisecond); !ok {
+		t.Fatal("TryStatus failed on an idle syncer")
+	}
+}
+
+func TestCloseFlushesPendingSync(t *testing.T) {
+	remote := newRemote(t, map[string]string{"a.md": "a"})
+	cfg := Config{Dir: t.TempDir(), Repo: remote, Branch: "main", User: "Test", Email: "test@test.com", Debounce: time.Hour}
+	repo := mustInitRepo(t, cfg)
+	syncer := New(cfg, repo)
+
+	os.WriteFile(filepath.Join(cfg.Dir, "b.md"), []byte("b"), 0644)
+	syncer.Trigger()
+	if err := syncer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	head, _ := repo.Head()
+	if got := remoteHash(t, remote, "main"); got != head.Hash() {
+		t.Fatalf("remote main = %s after Close, want the pending change %s pushed", got, head.Hash())
+	}
+	if syncer.Status().PendingTrigger {
+		t.Fatal("sync still pending after Close")
+	}
+}
@@ -1,55 +1,317 @@
 package git
 
 import (
+	"cmp"
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	gogit "github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
 	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
 	"github.com/go-git/go-git/v5/plumbing/transport/http"
 )
 
+// LFSUploader uploads Git LFS content ahead of the commits that point to it.
+type LFSUploader interface {
+	Push(ctx context.Context) error
+}
+
+// ChangeNotifier is told when a pull brings in commits from the remote.
+type ChangeNotifier interface {
+	Changed(oldHash, newHash string, paths []string)
+}
+
+// SyncResult describes a finished sync, as passed to Config.OnSync.
+type SyncResult struct {
+	Commit string // the commit made, or "" if nothing had changed
+	Files  int    // paths the commit added, changed or removed
+	Pushed bool   // whether commits went to the remote
+	Err    error  // why the sync failed, if it did
+}
+
 // Syncer handles debounced git commit and push operations.
 type Syncer struct {
-	dir      string
-	repo     *gogit.Repository
-	remote   string
-	branch   string
-	user     string
-	email    string
-	token    string
-	debounce time.Duration
-	mu       sync.Mutex
-	timer    *time.Timer
+	dir        string
+	repo       *gogit.Repository
+	remote     string
+	branch     string // checked out, committed to and pushed
+	pullBranch string // integrated by pulls
+	user       string
+	email      string
+	committer  string // committer name and email, which default to the author's
+	commEmail  string
+	token      string
+	subdir     string
+	lfs        LFSUploader
+	notifier   ChangeNotifier
+	onSync     func(SyncResult)
+	debug      bool
+	debounce   time.Duration
+	mu         sync.Mutex
+	timer      *time.Timer
+	pullTimer  *time.Timer
+	pushTimer  *time.Timer   // armed with a push debounce, by the first unpushed commit
+	pushed     pushedSet     // cache for commitsAheadLocked
+	settled    chan struct{} // closed, for Wait, when a sync or push finishes
+	prs        PullRequests
+	prFound    bool // the open pull request, if any, was looked up
+	status     Status
+	events     eventLog
+	instr      Instrumentation
+	stop       chan struct{} // closed by Close to end the puller
+	closeOnce  sync.Once
+	recovering bool // a Recover is scheduled or running
+
+	pushDebounce     time.Duration
+	prAutoMerge      bool
+	resetOnForcePush bool
+	pullBeforePush   bool
+	conflictPolicy   string
+	attributes       string
+	binaryPatterns   []string
+	textPatterns     []string
+	secretScan       string
+	secretRules      []secretRule
+	secretAllow      []gitignore.Pattern
+	sizeLimit        int64
+	repoBytes        atomic.Int64 // as of the last measure, for RepoSize
+	sizer            repoSizer
+	versions         versionCache // Versions' counts at the head
+
+	replica     bool // pull-only until Promote
+	replicaPull time.Duration
+	onPromote   func()
+
+	exclude []string // key prefixes never committed
+	hooks   Hooks
+
+	schedule     Schedule
+	heartbeat    bool
+	heartbeatDue bool // set by scheduledSync for the sync it runs
+	rollup       Schedule
+	rollupDue    bool // set by scheduledRollup for the sync it runs
+	rollupWindow time.Duration
+
+	remoteCheckFailures int                          // consecutive failed remoteChangedLocked checks
+	remoteCheckRetry    time.Time                    // when checks given up on are tried again
+	remoteHealth        atomic.Pointer[RemoteHealth] // the last ProbeRemote's
+
+	pullInterval time.Duration // the puller's, once started
+	offline      offlineState
+
+	// syncing is set while doSync runs or waits for gs.mu, so the puller
+	// can tell without waiting itself. pullDeferred records a pull it
+	// skipped for a sync, which the sync then runs if it didn't pull.
+	syncing      atomic.Bool
+	pullDeferred bool
+
+	// tree is read-held by every S3 request (see TreeLock) and write-held
+	// while the working tree is swapped to another branch.
+	tree sync.RWMutex
+
+	// pendingPaths are the paths triggered since the last commit, and
+	// pendingAll is set if a trigger named none. lastFullStage is when
+	// the whole tree was last staged; see fullStageInterval.
+	pendingPaths  map[string]bool
+	pendingAll    bool
+	lastFullStage time.Time
+
+	// writes is held by S3 requests putting objects in place (see
+	// WriteLock) and drained while the tree is staged.
+	writes writeGate
 }
 
 // Config holds the parameters needed to create a Syncer.
 type Config struct {
-	Dir          string
-	Repo         string
-	Branch       string
-	User         string
-	Email        string
-	Token        string
-	Debounce     time.Duration
-	PullInterval time.Duration
+	Dir             string
+	Repo            string
+	Branch          string
+	PushBranch      string // branch sync commits go to (default Branch)
+	PullBranch      string // branch pulls integrate (default Branch)
+	User            string
+	Email           string
+	Committer       string // committer name, if other than User
+	CommitterEmail  string // committer email, if other than Email
+	Token           string
+	Subdir          string
+	LFS             LFSUploader
+	Notifier        ChangeNotifier  // told about content that arrives by pull
+	Instrumentation Instrumentation // receives sync measurements (optional)
+	Debug           bool            // log routine decisions such as skipped pushes
+	// OnSync is called after every triggered sync, whether it committed,
+	// pushed, found nothing to do or failed. It runs on the sync's
+	// goroutine without any of the Syncer's locks held, so it may call
+	// back into the Syncer; the next sync waits for it to return.
+	OnSync func(SyncResult)
+	// PullRequests, when set, puts the Syncer in pull-request mode for a
+	// PullBranch that can't be pushed to directly. PushBranch is then a
+	// working branch of git3's own, force-pushed as needed, and each push
+	// opens a pull request from it into PullBranch unless one is open
+	// already. Once that is merged or closed, the working branch is reset
+	// onto PullBranch, the old commits kept in a git3-backup branch, and
+	// what the vault has beyond PullBranch is committed again on top.
+	// PullRequestAutoMerge merges the open pull request, or asks the
+	// forge to once its checks pass.
+	PullRequests         PullRequests
+	PullRequestAutoMerge bool
+	// ResetOnForcePush moves the branch onto a force-pushed remote branch
+	// instead of failing every pull; see ErrForcePushed. It has no effect
+	// when PullBranch differs from PushBranch.
+	ResetOnForcePush bool
+	// SkipPullBeforePush pushes without pulling first, saving a fetch per
+	// sync where nothing else writes to the remote. Anything else that
+	// does gets the push rejected as non-fast-forward until a scheduled
+	// pull brings its commits in.
+	SkipPullBeforePush bool
+	// ConflictPolicy is what a pull does when the branch and the remote
+	// have diverged: ConflictsFail (the default) or ConflictsManual.
+	ConflictPolicy string
+	// Attributes is AttributesOff (the default), AttributesAuto or
+	// AttributesManage: whether UpdateAttributes keeps a section in
+	// .gitattributes marking BinaryPatterns "-text -diff" and
+	// TextPatterns "text eol=lf".
+	Attributes     string
+	BinaryPatterns []string
+	TextPatterns   []string
+	// SecretScan is SecretsOff (the default), SecretsBlock or SecretsSkip:
+	// what a sync does when a staged text file matches a secret rule, the
+	// built-in ones or SecretPatterns. Paths matching SecretAllow, which
+	// are gitignore-style patterns, aren't scanned.
+	SecretScan     string
+	SecretPatterns []*regexp.Regexp
+	SecretAllow    []string
+	// SizeLimit is the size in bytes the repository should stay under,
+	// like the host's limit; going over it is logged and recorded as an
+	// event. Zero means none.
+	SizeLimit int64
+	// Replica makes the Syncer a pull-only replica of the remote: it
+	// never commits or pushes, and pulls every ReplicaPullInterval
+	// instead of the puller's interval, until Promote. OnPromote is
+	// called once it has been promoted.
+	Replica             bool
+	ReplicaPullInterval time.Duration
+	OnPromote           func()
+	// Schedule is the times of day StartSchedule syncs at, on top of the
+	// syncs writes trigger. With Heartbeat, a scheduled sync with nothing
+	// to commit makes an empty commit, so the remote's history shows the
+	// server is alive.
+	Schedule  Schedule
+	Heartbeat bool
+	// RollupWindow, when set, lets a sync amend the branch's last commit
+	// instead of adding another, if that is a sync commit made within the
+	// window that was never pushed. Rollup is times of day to squash the
+	// unpushed sync commits into one and push it. Neither ever rewrites a
+	// commit the remote has seen, so both only matter with PushDebounce,
+	// and both need a remote.
+	RollupWindow time.Duration
+	Rollup       Schedule
+	// Exclude lists key prefixes, relative to the served directory, that
+	// are never committed: writes under them don't trigger syncs, and
+	// they are listed in .git/info/exclude so untracked files under them
+	// aren't staged. Files already committed under them stay tracked.
+	Exclude []string
+	// Hooks are commands run before commits and after commits, pushes
+	// and pulls; see Hooks.
+	Hooks Hooks
+	// CloneTimeout bounds the clone InitRepo makes of a remote the
+	// directory doesn't have yet; zero means no limit.
+	CloneTimeout time.Duration
+	// CommitOnStartup makes InitRepo commit the untracked and modified
+	// files an unclean shutdown left in the working tree, so the first
+	// pull can't strand them. Deletions aren't committed; see commitDrift.
+	CommitOnStartup bool
+
+	Debounce time.Duration
+	// CommitDebounce, when set, replaces Debounce as the wait after the
+	// last write before committing. PushDebounce, when set, leaves the
+	// commits to be pushed by a timer of their own, armed by the first
+	// commit left unpushed, so commits are made promptly while pushes are
+	// batched. Without PushDebounce each sync commits and pushes.
+	CommitDebounce time.Duration
+	PushDebounce   time.Duration
+	PullInterval   time.Duration
+}
+
+// Errors returned by InitRepo, wrapped with the underlying cause.
+var (
+	ErrCreateDir = errors.New("cannot create directory")
+	ErrClone     = errors.New("clone failed")
+	ErrInit      = errors.New("init failed")
+	ErrSubdir    = errors.New("invalid subdir")
+)
+
+// CleanSubdir returns subdir in the cleaned, slash-separated form the
+// syncer uses, or ErrSubdir if it isn't a directory inside the working
+// tree: absolute, the tree itself, outside it, or in .git or .git3.
+func CleanSubdir(subdir string) (string, error) {
+	if subdir == "" {
+		return "", nil
+	}
+	clean := path.Clean(filepath.ToSlash(subdir))
+	first, _, _ := strings.Cut(clean, "/")
+	if filepath.IsAbs(subdir) || path.IsAbs(clean) || clean == "." ||
+		strings.Contains("/"+clean+"/", "/../") || first == ".git" || first == recoveryDir {
+		return "", fmt.Errorf("%w %q: must be a directory inside the repository", ErrSubdir, subdir)
+	}
+	return clean, nil
 }
 
 // InitRepo ensures the vault directory exists and initializes git if needed.
-func InitRepo(cfg Config) *gogit.Repository {
-	os.MkdirAll(cfg.Dir, 0755)
+// When cfg.Subdir is set, a fresh clone only checks out that subdirectory.
+// It logs how the working tree differs from HEAD, which after an unclean
+// shutdown may be changes not committed yet or files a pull didn't finish
+// writing, and with cfg.CommitOnStartup commits the former.
+// An empty remote, or one without cfg.Branch, is not an error: the first
+// push creates the branch. Any other clone failure is.
+func InitRepo(cfg Config) (*gogit.Repository, error) {
+	cfg = cfg.withBranches()
+	subdir, err := CleanSubdir(cfg.Subdir)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Subdir = subdir
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("%w %s: %w", ErrCreateDir, cfg.Dir, err)
+	}
 
 	repo, err := initRepo(cfg)
 	if err != nil {
-		log.Printf("[git] init failed: %v", err)
-		return nil
+		return nil, err
+	}
+	if err := excludeStateDir(cfg.Dir); err != nil {
+		log.Printf("[git] exclude %s failed: %v", stateDirPattern, err)
+	}
+	if err := writeExcludes(cfg.Dir, cfg.Subdir, cfg.Exclude); err != nil {
+		log.Printf("[git] exclude %v failed: %v", cfg.Exclude, err)
 	}
-	return repo
+	if cfg.Subdir != "" {
+		subdir := filepath.Join(cfg.Dir, cfg.Subdir)
+		if err := os.MkdirAll(subdir, 0755); err != nil {
+			return nil, fmt.Errorf("%w %s: %w", ErrCreateDir, subdir, err)
+		}
+	}
+	logDrift(repo, cfg.Subdir)
+	if cfg.CommitOnStartup && !cfg.Replica {
+		if hash, err := commitDrift(repo, cfg); err != nil {
+			log.Printf("[git] commit on startup: %v", err)
+		} else if !hash.IsZero() {
+			log.Printf("[git] committed changes left from before startup as %s", hash)
+		}
+	}
+	return repo, nil
 }
 
 func initRepo(cfg Config) (*gogit.Repository, error) {
@@ -57,35 +319,36 @@ func initRepo(cfg Config) (*gogit.Repository, error) {
 	repo, err := gogit.PlainOpen(cfg.Dir)
 	if err == nil {
 		log.Println("[git] repo already initialized")
+		if err := checkHeadBranch(repo, cfg.Branch); err != nil {
+			log.Printf("[git] check HEAD's branch failed: %v", err)
+		}
 		return repo, nil
 	}
+	if !errors.Is(err, gogit.ErrRepositoryNotExists) {
+		return nil, fmt.Errorf("%w: open %s: %w", ErrInit, cfg.Dir, err)
+	}
 
 	// Try to clone if remote is configured
 	if cfg.Repo != "" {
 		log.Printf("[git] cloning %s ...", cfg.Repo)
-		cloneOpts := &gogit.CloneOptions{
-			URL:           cfg.Repo,
-			ReferenceName: plumbing.NewBranchReferenceName(cfg.Branch),
-			SingleBranch:  true,
-		}
-		if cfg.Token != "" {
-			cloneOpts.Auth = &http.BasicAuth{
-				Username: "token",
-				Password: cfg.Token,
-			}
-		}
-		repo, err = gogit.PlainClone(cfg.Dir, false, cloneOpts)
-		if err == nil {
+		repo, err = cloneRepo(cfg)
+		switch {
+		case err == nil:
 			log.Println("[git] cloned successfully")
 			return repo, nil
+		case errors.Is(err, transport.ErrEmptyRemoteRepository):
+			log.Printf("[git] remote is empty, initializing %s locally; the first push will create it", cfg.Branch)
+		default:
+			// A fresh init would share no history with the remote and
+			// could never push to it
+			return nil, fmt.Errorf("%w: %s: %w", ErrClone, cfg.Repo, err)
 		}
-		log.Printf("[git] clone failed, initializing fresh: %v", err)
 	}
 
 	// Fall back to plain init
 	repo, err = gogit.PlainInit(cfg.Dir, false)
 	if err != nil {
-		return nil, fmt.Errorf("git init: %w", err)
+		return nil, fmt.Errorf("%w: %w", ErrInit, err)
 	}
 
 	// Set HEAD to the configured branch so the first commit lands there
@@ -110,144 +373,1199 @@ func initRepo(cfg Config) (*gogit.Repository, error) {
 	return repo, nil
 }
 
+// checkHeadBranch makes sure an existing repository has branch checked
+// out, since commits go to whatever HEAD points at while pushes send
+// branch. A HEAD on another branch with no commits yet, as left by a
+// plain git init, is moved to branch. A HEAD on another branch with
+// history is left alone, with a warning: switching would change the
+// working tree under the vault.
+func checkHeadBranch(repo *gogit.Repository, branch string) error {
+	head, err := repo.Storer.Reference(plumbing.HEAD)
+	if err != nil {
+		return err
+	}
+	want := plumbing.NewBranchReferenceName(branch)
+	if head.Type() != plumbing.SymbolicReference {
+		log.Printf("[git] WARNING: HEAD is detached at %s, not on %s; commits won't reach %s. Check %s out with git before syncing",
+			head.Hash().String()[:7], branch, branch, branch)
+		return nil
+	}
+	current := head.Target()
+	if current == want {
+		return nil
+	}
+
+	_, err = repo.Storer.Reference(current)
+	switch {
+	case errors.Is(err, plumbing.ErrReferenceNotFound):
+		// Unborn: nothing was committed to it, so nothing is lost
+		log.Printf("[git] HEAD was on %s, which has no commits; switching it to %s", current.Short(), branch)
+		return repo.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, want))
+	case err != nil:
+		return err
+	}
+	log.Printf("[git] WARNING: the repository has %s checked out, not the configured branch %s. "+
+		"Syncs would commit to %s and push %s, so the two diverge. "+
+		"Check %s out with git, or configure %s as the branch, before syncing",
+		current.Short(), branch, current.Short(), branch, branch, current.Short())
+	return nil
+}
+
+// withBranches fills in PushBranch and PullBranch, which default to Branch,
+// and makes Branch the push branch: the one checked out and committed to.
+func (cfg Config) withBranches() Config {
+	if cfg.PullBranch == "" {
+		cfg.PullBranch = cfg.Branch
+	}
+	if cfg.PushBranch == "" {
+		cfg.PushBranch = cfg.Branch
+	}
+	cfg.Branch = cfg.PushBranch
+	return cfg
+}
+
+// cloneRepo clones cfg.Branch. If the remote has history but not that
+// branch, the pull branch (or failing that, the default branch) is cloned
+// and cfg.Branch is started from it locally; the first push then creates
+// it on the remote. The remote's progress is logged as it goes, and the
+// whole clone is given up after cfg.CloneTimeout.
+func cloneRepo(cfg Config) (*gogit.Repository, error) {
+	ctx := context.Background()
+	if cfg.CloneTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.CloneTimeout)
+		defer cancel()
+	}
+	repo, err := cloneBranches(ctx, cfg)
+	if err != nil && ctx.Err() != nil {
+		return nil, fmt.Errorf("timed out after %s: %w", cfg.CloneTimeout, ctx.Err())
+	}
+	return repo, err
+}
+
+func cloneBranches(ctx context.Context, cfg Config) (*gogit.Repository, error) {
+	cloneOpts := &gogit.CloneOptions{
+		URL:           cfg.Repo,
+		Auth:          authFor(cfg.Token),
+		ReferenceName: plumbing.NewBranchReferenceName(cfg.Branch),
+		SingleBranch:  true,
+		NoCheckout:    cfg.Subdir != "",
+		Progress:      &progressLog{},
+	}
+	repo, err := gogit.PlainCloneContext(ctx, cfg.Dir, false, cloneOpts)
+	if errors.Is(err, gogit.NoMatchingRefSpecError{}) {
+		started := false
+		if cfg.PullBranch != cfg.Branch {
+			log.Printf("[git] remote has no branch %s, starting it from %s", cfg.Branch, cfg.PullBranch)
+			cloneOpts.ReferenceName = plumbing.NewBranchReferenceName(cfg.PullBranch)
+			repo, err = gogit.PlainCloneContext(ctx, cfg.Dir, false, cloneOpts)
+			started = !errors.Is(err, gogit.NoMatchingRefSpecError{})
+		}
+		if !started {
+			log.Printf("[git] remote has no branch %s, starting it from the default branch", cfg.Branch)
+			cloneOpts.ReferenceName = ""
+			repo, err = gogit.PlainCloneContext(ctx, cfg.Dir, false, cloneOpts)
+		}
+		if err == nil {
+			err = startBranch(repo, cfg)
+		}
+	}
+	if err == nil && cfg.Subdir != "" {
+		err = sparseCheckout(repo, cfg)
+	}
+	return repo, err
+}
+
+// startBranch creates cfg.Branch at the current HEAD and switches to it.
+func startBranch(repo *gogit.Repository, cfg Config) error {
+	head, err := repo.Head()
+	if err != nil {
+		return err
+	}
+	branch := plumbing.NewBranchReferenceName(cfg.Branch)
+	if err := repo.Storer.SetReference(plumbing.NewHashReference(branch, head.Hash())); err != nil {
+		return err
+	}
+	if cfg.Subdir != "" {
+		// sparseCheckout switches to the branch itself
+		return nil
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		return err
+	}
+	return wt.Checkout(&gogit.CheckoutOptions{Branch: branch})
+}
+
+// sparseCheckout populates only cfg.Subdir of a clone made with NoCheckout.
+// Entries outside it are marked skip-worktree in the index, so later pulls
+// leave them unmaterialized and they never show up as deletions.
+func sparseCheckout(repo *gogit.Repository, cfg Config) error {
+	wt, err := repo.Worktree()
+	if err != nil {
+		return err
+	}
+	err = wt.Checkout(&gogit.CheckoutOptions{
+		Branch:                    plumbing.NewBranchReferenceName(cfg.Branch),
+		SparseCheckoutDirectories: []string{filepath.ToSlash(cfg.Subdir)},
+	})
+	if err != nil {
+		return fmt.Errorf("sparse checkout of %s: %w", cfg.Subdir, err)
+	}
+	return os.MkdirAll(filepath.Join(cfg.Dir, cfg.Subdir), 0755)
+}
+
+// stateDirPattern matches the directory where the S3 handler keeps its own
+// state (temp files, deduplicated blobs), wherever the served root is.
+const stateDirPattern = ".git3/"
+
+// excludeStateDir keeps the handler's state directory out of commits by
+// listing it in .git/info/exclude.
+func excludeStateDir(dir string) error {
+	path := filepath.Join(dir, ".git", "info", "exclude")
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == stateDirPattern {
+			return nil
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		data = append(data, '\n')
+	}
+	data = append(data, stateDirPattern+"\n"...)
+	return os.WriteFile(path, data, 0644)
+}
+
 // New creates a Syncer. If repo is nil (no git configured), the syncer
 // will still accept Trigger() calls but skip actual sync operations.
 func New(cfg Config, repo *gogit.Repository) *Syncer {
-	return &Syncer{
-		dir:      cfg.Dir,
-		repo:     repo,
-		remote:   cfg.Repo,
-		branch:   cfg.Branch,
-		user:     cfg.User,
-		email:    cfg.Email,
-		token:    cfg.Token,
-		debounce: cfg.Debounce,
+	cfg = cfg.withBranches()
+	var instr Instrumentation = nopInstrumentation{}
+	if cfg.Instrumentation != nil {
+		instr = cfg.Instrumentation
+	}
+	gs := &Syncer{
+		dir:        cfg.Dir,
+		repo:       repo,
+		remote:     cfg.Repo,
+		branch:     cfg.Branch,
+		pullBranch: cfg.PullBranch,
+		user:       cfg.User,
+		email:      cfg.Email,
+		committer:  cmp.Or(cfg.Committer, cfg.User),
+		commEmail:  cmp.Or(cfg.CommitterEmail, cfg.Email),
+		token:      cfg.Token,
+		subdir:     filepath.ToSlash(cfg.Subdir),
+		lfs:        cfg.LFS,
+		notifier:   cfg.Notifier,
+		onSync:     cfg.OnSync,
+		debug:      cfg.Debug,
+		debounce:   cmp.Or(cfg.CommitDebounce, cfg.Debounce),
+
+		pushDebounce:     cfg.PushDebounce,
+		prs:              cfg.PullRequests,
+		prAutoMerge:      cfg.PullRequestAutoMerge,
+		resetOnForcePush: cfg.ResetOnForcePush,
+		pullBeforePush:   !cfg.SkipPullBeforePush,
+		conflictPolicy:   cfg.ConflictPolicy,
+		attributes:       cfg.Attributes,
+		binaryPatterns:   cfg.BinaryPatterns,
+		textPatterns:     cfg.TextPatterns,
+		secretScan:       cfg.SecretScan,
+		secretRules:      secretRulesFor(cfg.SecretPatterns),
+		secretAllow:      parseAllowPatterns(cfg.SecretAllow),
+		sizeLimit:        cfg.SizeLimit,
+		replica:          cfg.Replica,
+		replicaPull:      cfg.ReplicaPullInterval,
+		onPromote:        cfg.OnPromote,
+		schedule:         cfg.Schedule,
+		heartbeat:        cfg.Heartbeat,
+		rollup:           cfg.Rollup,
+		rollupWindow:     cfg.RollupWindow,
+		exclude:          cfg.Exclude,
+		hooks:            cfg.Hooks,
+		events:           eventLog{size: eventHistory},
+		instr:            instr,
+		stop:             make(chan struct{}),
 	}
+	if gs.replica {
+		gs.status.ReplicaOf = gs.remote
+	}
+	if gs.repo != nil {
+		go gs.remeasure()
+	}
+	return gs
 }
 
 // StartPuller launches a background goroutine that periodically pulls
-// from the remote. Does nothing if no remote is configured or interval is 0.
+// from the remote, backing off while it is unreachable. Does nothing if
+// no remote is configured or interval is 0.
 func (gs *Syncer) StartPuller(interval time.Duration) {
 	if gs.repo == nil || gs.remote == "" || interval <= 0 {
 		return
 	}
-	log.Printf("[git] starting periodic pull every %s", interval)
+	gs.mu.Lock()
+	gs.pullInterval = interval
+	first := gs.pullEveryLocked(interval)
+	gs.mu.Unlock()
+	log.Printf("[git] starting periodic pull every %s", first)
 	go func() {
-		ticker := time.NewTicker(interval)
-		defer ticker.Stop()
-		for range ticker.C {
-			gs.doPull()
+		timer := time.NewTimer(first)
+		defer timer.Stop()
+		for {
+			select {
+			case <-timer.C:
+				gs.doPull()
+				timer.Reset(gs.nextPullDelay(interval))
+			case <-gs.stop:
+				return
+			}
 		}
 	}()
 }
 
+// Close stops the periodic puller and any scheduled pull, and runs a
+// pending sync right away instead of waiting out the debounce, so no
+// change, deferred ones included, is left uncommitted.
+func (gs *Syncer) Close() error {
+	gs.closeOnce.Do(func() { close(gs.stop) })
+
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	if gs.pullTimer != nil {
+		gs.pullTimer.Stop()
+	}
+	deferred := gs.pendingAll || len(gs.pendingPaths) > 0
+	if !gs.status.PendingTrigger && !deferred && !gs.status.PendingPush {
+		return nil
+	}
+	if gs.timer != nil {
+		gs.timer.Stop()
+	}
+	gs.status.PendingTrigger = false
+	gs.instr.SetPending(false)
+	defer gs.settleLocked()
+	return gs.syncLocked()
+}
+
+// doPull runs a scheduled pull, unless a sync is pending or in progress:
+// the sync pulls right before it pushes, and a pull of its own landing
+// between the sync's commit and push could only make them diverge. The
+// skipped pull is left to the sync.
 func (gs *Syncer) doPull() {
+	if gs.syncing.Load() {
+		gs.mu.Lock()
+		gs.skipPullLocked("sync in progress")
+		gs.mu.Unlock()
+		return
+	}
 	gs.mu.Lock()
 	defer gs.mu.Unlock()
+	if gs.status.PendingTrigger {
+		gs.skipPullLocked("sync pending")
+		return
+	}
 	gs.pullLocked()
 }
 
-// pullLocked performs git pull. Caller must hold gs.mu.
+// skipPullLocked records a scheduled pull deferred to the next sync.
+// Caller must hold gs.mu.
+func (gs *Syncer) skipPullLocked(reason string) {
+	gs.debugf("deferring pull: %s", reason)
+	gs.pullDeferred = true
+	gs.status.PullsSkipped++
+	gs.events.add(Event{Op: "pull", Result: "skipped", Error: reason}, time.Now())
+}
+
+// pullLocked fetches and fast-forwards the branch to origin/<pull branch>,
+// like git pull --ff-only. It does nothing while backing off from an
+// unreachable remote. Caller must hold gs.mu.
 func (gs *Syncer) pullLocked() {
-	wt, err := gs.repo.Worktree()
+	gs.pullDeferred = false
+	if gs.backingOffLocked() {
+		gs.debugf("remote unreachable, not pulling before %s", gs.offline.nextTry.Format(time.RFC3339))
+		return
+	}
+	gs.followPullRequestLocked()
+	start := time.Now()
+	var err error
+	if gs.remoteChangedLocked() {
+		if err = gs.fetchLocked(); err == nil {
+			gs.remoteCheckOKLocked()
+		}
+	}
+	if errors.Is(err, ErrForcePushed) && gs.resetOnForcePush && gs.pullBranch == gs.branch {
+		err = gs.resetToRemoteLocked(fmt.Sprintf("origin/%s was force-pushed", gs.pullBranch))
+	}
+	if err == nil {
+		before, _ := gs.repo.Head()
+		err = gs.fastForwardLocked()
+		var merged bool
+		var conflicts, copies []string
+		if errors.Is(err, gogit.ErrNonFastForwardUpdate) && gs.conflictPolicy == ConflictsManual {
+			conflicts, copies, err = gs.mergeLocked()
+			merged = err == nil
+		}
+		switch {
+		case merged:
+			log.Printf("[git] merged new changes, %d conflicts", len(conflicts))
+			gs.pulledLocked(before, start, "merged")
+			if len(conflicts) > 0 {
+				gs.conflictedLocked(conflicts, copies)
+			}
+		case err == nil:
+			log.Println("[git] pulled new changes")
+			gs.pulledLocked(before, start, "fast-forwarded")
+		case err == gogit.NoErrAlreadyUpToDate:
+			gs.events.add(Event{Op: "pull", Result: "up to date"}, start)
+			err = nil
+		case errors.Is(err, plumbing.ErrReferenceNotFound):
+			// The remote branch doesn't exist yet; the next push creates it.
+			gs.events.add(Event{Op: "pull", Result: "no remote branch"}, start)
+			err = nil
+		}
+	}
+	gs.instr.ObservePull(time.Since(start), err)
+	if gs.networkFailedLocked("pull", err) {
+		gs.events.add(Event{Op: "pull", Result: "failed", Error: err.Error()}, start)
+		return
+	}
+	gs.reachedLocked()
 	if err != nil {
-		log.Printf("[git] pull: worktree failed: %v", err)
+		log.Printf("[git] pull failed: %v", err)
+		gs.events.add(Event{Op: "pull", Result: "failed", Error: err.Error()}, start)
+		gs.checkCorruptLocked(err)
 		return
 	}
+	gs.status.LastPullTime = time.Now()
+}
 
-	pullOpts := &gogit.PullOptions{
-		RemoteName:    "origin",
-		ReferenceName: plumbing.NewBranchReferenceName(gs.branch),
-		SingleBranch:  true,
+// fetchLocked updates origin/<branch> for the push and pull branches,
+// skipping any the remote doesn't have yet. If the pull branch was
+// force-pushed, origin/<pull branch> is left alone and ErrForcePushed is
+// returned. Caller must hold gs.mu.
+func (gs *Syncer) fetchLocked() error {
+	branches := []string{gs.branch}
+	if gs.pullBranch != gs.branch {
+		branches = append(branches, gs.pullBranch)
 	}
-	if gs.token != "" {
-		pullOpts.Auth = &http.BasicAuth{
-			Username: "token",
-			Password: gs.token,
+	specFor := func(b string) config.RefSpec {
+		if b == gs.pullBranch {
+			return pullSpec(b)
 		}
+		return fetchSpec(b)
+	}
+	var specs []config.RefSpec
+	for _, b := range branches {
+		specs = append(specs, specFor(b))
 	}
 
-	err = wt.Pull(pullOpts)
-	switch err {
-	case nil:
-		log.Println("[git] pulled new changes")
-	case gogit.NoErrAlreadyUpToDate:
-		// nothing to do
+	err := gs.repo.Fetch(&gogit.FetchOptions{
+		RemoteName: "origin",
+		RefSpecs:   specs,
+		Auth:       authFor(gs.token),
+	})
+	switch {
+	case err == nil, err == gogit.NoErrAlreadyUpToDate, errors.Is(err, transport.ErrEmptyRemoteRepository):
+		return nil
+	case errors.Is(err, gogit.NoMatchingRefSpecError{}) && len(branches) > 1:
+		// One of them is missing; fetch the others on their own
+		for _, b := range branches {
+			if _, err := gs.fetchWithSpec(b, specFor(b)); errors.Is(err, gogit.ErrForceNeeded) {
+				return fmt.Errorf("%w: origin/%s: %w", ErrForcePushed, gs.pullBranch, err)
+			} else if err != nil {
+				return err
+			}
+		}
+		return nil
+	case errors.Is(err, gogit.NoMatchingRefSpecError{}):
+		return nil
+	case errors.Is(err, gogit.ErrForceNeeded):
+		return fmt.Errorf("%w: origin/%s: %w", ErrForcePushed, gs.pullBranch, err)
 	default:
-		log.Printf("[git] pull failed: %v", err)
+		return err
 	}
 }
 
-func (gs *Syncer) Trigger() {
+// fastForwardLocked moves the branch and working tree to origin/<pull
+// branch> if that is a fast-forward. Caller must hold gs.mu.
+func (gs *Syncer) fastForwardLocked() error {
+	target, err := gs.repo.Reference(plumbing.NewRemoteReferenceName("origin", gs.pullBranch), true)
+	if err != nil {
+		return err
+	}
+	branch := plumbing.NewBranchReferenceName(gs.branch)
+	head, err := gs.repo.Reference(branch, true)
+	switch {
+	case errors.Is(err, plumbing.ErrReferenceNotFound):
+		// No local commits yet: anything is a fast-forward
+	case err != nil:
+		return err
+	case head.Hash() == target.Hash():
+		return gogit.NoErrAlreadyUpToDate
+	default:
+		local, err := gs.repo.CommitObject(head.Hash())
+		if err != nil {
+			return err
+		}
+		remote, err := gs.repo.CommitObject(target.Hash())
+		if err != nil {
+			return err
+		}
+		if ahead, err := remote.IsAncestor(local); err != nil {
+			return err
+		} else if ahead {
+			return gogit.NoErrAlreadyUpToDate
+		}
+		if ff, err := local.IsAncestor(remote); err != nil {
+			return err
+		} else if !ff {
+			return gogit.ErrNonFastForwardUpdate
+		}
+	}
+
+	if err := gs.repo.Storer.SetReference(plumbing.NewHashReference(branch, target.Hash())); err != nil {
+		return err
+	}
+	wt, err := gs.repo.Worktree()
+	if err != nil {
+		return err
+	}
+	return wt.Reset(&gogit.ResetOptions{Mode: gogit.MergeReset, Commit: target.Hash()})
+}
+
+func fetchSpec(branch string) config.RefSpec {
+	return config.RefSpec("+" + plumbing.NewBranchReferenceName(branch) + ":" + plumbing.NewRemoteReferenceName("origin", branch))
+}
+
+// pulledLocked records a pull that moved HEAD on from before (nil if the
+// branch had no commits yet) and tells the notifier which paths changed.
+// Caller must hold gs.mu.
+func (gs *Syncer) pulledLocked(before *plumbing.Reference, start time.Time, result string) {
+	after, err := gs.repo.Head()
+	if err != nil {
+		return
+	}
+	var oldHash plumbing.Hash
+	if before != nil {
+		oldHash = before.Hash()
+	}
+	gs.measureLocked()
+	event := Event{Op: "pull", Result: result, Hash: after.Hash().String()}
+	paths, err := gs.changedPaths(oldHash, after.Hash())
+	if err != nil {
+		log.Printf("[git] diff of pulled changes failed: %v", err)
+		gs.events.add(event, start)
+		gs.runHookLocked(HookPostPull, after.Hash(), nil)
+		return
+	}
+	gs.events.add(event.withPaths(paths), start)
+	if gs.notifier != nil {
+		gs.notifier.Changed(oldHash.String(), after.Hash().String(), paths)
+	}
+	gs.runHookLocked(HookPostPull, after.Hash(), paths)
+}
+
+// conflictedLocked records the conflicts a merging pull left and tells
+// the notifier about them. Caller must hold gs.mu.
+func (gs *Syncer) conflictedLocked(paths, copies []string) {
+	head, err := gs.repo.Head()
+	if err != nil {
+		return
+	}
+	gs.events.add(Event{Op: "pull", Result: "conflicts", Hash: head.Hash().String()}.withPaths(paths), time.Now())
+	if n, ok := gs.notifier.(ConflictNotifier); ok {
+		n.Conflicted(head.Hash().String(), paths, copies)
+	}
+}
+
+// changedPaths lists the files that differ between two commits. A zero
+// from hash stands for an empty tree.
+func (gs *Syncer) changedPaths(from, to plumbing.Hash) ([]string, error) {
+	treeOf := func(h plumbing.Hash) (*object.Tree, error) {
+		if h.IsZero() {
+			return &object.Tree{}, nil
+		}
+		c, err := gs.repo.CommitObject(h)
+		if err != nil {
+			return nil, err
+		}
+		return c.Tree()
+	}
+	fromTree, err := treeOf(from)
+	if err != nil {
+		return nil, err
+	}
+	toTree, err := treeOf(to)
+	if err != nil {
+		return nil, err
+	}
+	changes, err := object.DiffTree(fromTree, toTree)
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, 0, len(changes))
+	for _, c := range changes {
+		name := c.To.Name
+		if name == "" {
+			name = c.From.Name
+		}
+		paths = append(paths, name)
+	}
+	return paths, nil
+}
+
+// Trigger schedules a debounced sync. paths are the files changed,
+// relative to the served directory; a sync triggered only with paths
+// stages just those, and one triggered without any stages the whole tree.
+func (gs *Syncer) Trigger(paths ...string) {
 	gs.mu.Lock()
 	defer gs.mu.Unlock()
+	if gs.replica {
+		gs.debugf("replica, ignoring changes to %v", paths)
+		return
+	}
+	kept, ok := gs.withoutExcluded(paths)
+	if !ok {
+		gs.debugf("excluded from sync, ignoring changes to %v", paths)
+		return
+	}
 
+	gs.addPendingLocked(kept)
 	if gs.timer != nil {
 		gs.timer.Stop()
 	}
 	gs.timer = time.AfterFunc(gs.debounce, gs.doSync)
+	gs.status.PendingTrigger = true
+	gs.instr.SetPending(true)
 }
 
-func (gs *Syncer) doSync() {
+// Defer records changed paths like Trigger, but leaves the debounce timer
+// alone, so a bulk import doesn't rearm it on every write. The changes are
+// committed by the next sync, whether a later Trigger's or Sync's.
+func (gs *Syncer) Defer(paths ...string) {
 	gs.mu.Lock()
 	defer gs.mu.Unlock()
+	if gs.replica {
+		return
+	}
+	if kept, ok := gs.withoutExcluded(paths); ok {
+		gs.addPendingLocked(kept)
+	}
+}
 
-	log.Println("[git] syncing...")
+// addPendingLocked records paths for the next commit; none means anything
+// may have changed. Caller must hold gs.mu.
+func (gs *Syncer) addPendingLocked(paths []string) {
+	if len(paths) == 0 {
+		gs.pendingAll = true
+	}
+	for _, p := range paths {
+		if gs.pendingPaths == nil {
+			gs.pendingPaths = make(map[string]bool)
+		}
+		gs.pendingPaths[p] = true
+	}
+}
 
-	if gs.repo == nil {
-		log.Println("[git] no repo configured, skipping sync")
-		return
+// Sync commits and pushes pending changes now, without waiting for the
+// debounce, and returns how it went.
+func (gs *Syncer) Sync() SyncResult {
+	return gs.runSync(true)
+}
+
+// Wait blocks until no sync is pending or running: a debounced sync has
+// run, and, with a push debounce, so has the push, which Sync can be
+// called to skip waiting for. It returns at once when nothing is pending,
+// and ctx's error if ctx is done first; a sync already running is waited
+// out regardless.
+func (gs *Syncer) Wait(ctx context.Context) error {
+	for {
+		gs.mu.Lock()
+		if !gs.status.PendingTrigger && !gs.status.PendingPush {
+			gs.mu.Unlock()
+			return nil
+		}
+		if gs.settled == nil {
+			gs.settled = make(chan struct{})
+		}
+		settled := gs.settled
+		gs.mu.Unlock()
+
+		select {
+		case <-settled:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
+}
 
-	wt, err := gs.repo.Worktree()
-	if err != nil {
-		log.Printf("[git] worktree failed: %v", err)
+// settleLocked wakes the callers of Wait to check again, after a sync or
+// push. Caller must hold gs.mu.
+func (gs *Syncer) settleLocked() {
+	if gs.settled != nil {
+		close(gs.settled)
+		gs.settled = nil
+	}
+}
+
+func (gs *Syncer) runSync(push bool) SyncResult {
+	result := gs.sync(push)
+	if gs.onSync != nil {
+		gs.onSync(result)
+	}
+	return result
+}
+
+// Pull fetches the remote's changes and integrates them now, as a
+// scheduled pull does.
+func (gs *Syncer) Pull() {
+	if gs.repo == nil || gs.remote == "" {
 		return
 	}
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.pullLocked()
+}
 
-	if err := wt.AddGlob("."); err != nil {
-		log.Printf("[git] add failed: %v", err)
+// pullCoalesce is how long RequestPull waits for further requests before
+// pulling, so a burst of webhook deliveries causes a single pull.
+var pullCoalesce = 2 * time.Second
+
+// RequestPull schedules a pull outside the periodic puller, e.g. when the
+// remote reports a push. Requests arriving within pullCoalesce of each
+// other are served by one pull.
+func (gs *Syncer) RequestPull() {
+	if gs.repo == nil || gs.remote == "" {
 		return
 	}
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
 
-	status, err := wt.Status()
+	if gs.pullTimer != nil {
+		gs.pullTimer.Stop()
+	}
+	gs.pullTimer = time.AfterFunc(pullCoalesce, gs.doPull)
+}
+
+// TreeLock returns the lock S3 requests hold while they touch the working
+// tree, so a branch switch never exposes a half-checked-out tree.
+func (gs *Syncer) TreeLock() sync.Locker {
+	return gs.tree.RLocker()
+}
+
+// Branch returns the branch currently synced.
+func (gs *Syncer) Branch() string {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	return gs.branch
+}
+
+// PullBranch returns the branch pulls integrate.
+func (gs *Syncer) PullBranch() string {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	return gs.pullBranch
+}
+
+// SwitchBranch commits and pushes pending changes, then checks out branch
+// and syncs it from now on. A separately configured pull branch is kept;
+// otherwise pulls follow the switch too. A local branch is created from origin/<branch>
+// when the remote has it, or from the current HEAD otherwise; the next push
+// then creates it on the remote. S3 requests wait until the switch is done.
+func (gs *Syncer) SwitchBranch(branch string) error {
+	if gs.repo == nil {
+		return errors.New("no repo configured")
+	}
+
+	// The tree lock comes first: requests hold it while calling Trigger,
+	// which needs gs.mu.
+	gs.tree.Lock()
+	defer gs.tree.Unlock()
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+
+	if branch == gs.branch {
+		return nil
+	}
+	if gs.timer != nil {
+		gs.timer.Stop()
+	}
+	gs.status.PendingTrigger = false
+	gs.instr.SetPending(false)
+	gs.pendingAll = true
+	err := gs.syncLocked()
+	gs.settleLocked()
 	if err != nil {
-		log.Printf("[git] status failed: %v", err)
-		return
+		return fmt.Errorf("flush %s: %w", gs.branch, err)
 	}
 
-	if status.IsClean() {
-		log.Println("[git] no changes")
-		return
+	ref := plumbing.NewBranchReferenceName(branch)
+	var start plumbing.Hash
+	if gs.remote != "" {
+		remoteRef, err := gs.fetchBranch(branch)
+		if err != nil {
+			return fmt.Errorf("fetch %s: %w", branch, err)
+		}
+		if remoteRef != nil {
+			start = remoteRef.Hash()
+		}
 	}
 
-	msg := fmt.Sprintf("sync: %s", time.Now().Format("2006-01-02 15:04"))
-	_, err = wt.Commit(msg, &gogit.CommitOptions{
-		Author: &object.Signature{
-			Name:  gs.user,
-			Email: gs.email,
-			When:  time.Now(),
-		},
-	})
+	if _, err := gs.repo.Reference(ref, false); errors.Is(err, plumbing.ErrReferenceNotFound) {
+		if start.IsZero() {
+			head, err := gs.repo.Head()
+			if err != nil {
+				return fmt.Errorf("no commit to start %s from: %w", branch, err)
+			}
+			start = head.Hash()
+		} else {
+			// Track origin so plain git tooling in the directory agrees
+			err := gs.repo.CreateBranch(&config.Branch{Name: branch, Remote: "origin", Merge: ref})
+			if err != nil && !errors.Is(err, gogit.ErrBranchExists) {
+				return err
+			}
+		}
+		if err := gs.repo.Storer.SetReference(plumbing.NewHashReference(ref, start)); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	wt, err := gs.repo.Worktree()
 	if err != nil {
-		log.Printf("[git] commit failed: %v", err)
-		return
+		return err
+	}
+	opts := &gogit.CheckoutOptions{Branch: ref}
+	if gs.subdir != "" {
+		opts.SparseCheckoutDirectories = []string{gs.subdir}
+	}
+	if err := wt.Checkout(opts); err != nil {
+		return fmt.Errorf("checkout %s: %w", branch, err)
+	}
+	if gs.subdir != "" {
+		os.MkdirAll(filepath.Join(gs.dir, gs.subdir), 0755)
 	}
 
+	log.Printf("[git] switched from %s to %s", gs.branch, branch)
+	if gs.pullBranch == gs.branch {
+		gs.pullBranch = branch
+	}
+	gs.branch = branch
 	if gs.remote != "" {
+		// An existing local branch may be behind the remote
+		gs.pullLocked()
+	}
+	return nil
+}
+
+// fetchBranch updates origin/<branch> and returns it, or nil if the remote
+// has no such branch.
+func (gs *Syncer) fetchBranch(branch string) (*plumbing.Reference, error) {
+	return gs.fetchWithSpec(branch, fetchSpec(branch))
+}
+
+// fetchWithSpec is fetchBranch with the given refspec for branch.
+func (gs *Syncer) fetchWithSpec(branch string, spec config.RefSpec) (*plumbing.Reference, error) {
+	err := gs.repo.Fetch(&gogit.FetchOptions{
+		RemoteName: "origin",
+		RefSpecs:   []config.RefSpec{spec},
+		Auth:       authFor(gs.token),
+	})
+	switch {
+	case err == nil, err == gogit.NoErrAlreadyUpToDate:
+		return gs.repo.Reference(plumbing.NewRemoteReferenceName("origin", branch), true)
+	case errors.Is(err, gogit.NoMatchingRefSpecError{}), errors.Is(err, transport.ErrEmptyRemoteRepository):
+		return nil, nil
+	default:
+		return nil, err
+	}
+}
+
+// doSync runs the sync the debounce timer was armed for. With a push
+// debounce, it only commits and leaves the push to the push timer.
+func (gs *Syncer) doSync() {
+	gs.runSync(gs.pushDebounce <= 0)
+}
+
+// sync runs a triggered sync and returns how it went. Unless push is set,
+// it commits and arms the push timer rather than pushing.
+func (gs *Syncer) sync(push bool) SyncResult {
+	gs.syncing.Store(true)
+	defer gs.syncing.Store(false)
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	if gs.timer != nil {
+		gs.timer.Stop()
+	}
+	gs.status.PendingTrigger = false
+	gs.instr.SetPending(false)
+
+	commits, pushes := gs.status.Commits, gs.status.Pushes
+	var err error
+	if push {
+		err = gs.syncLocked()
+	} else if err = gs.commitPhaseLocked(); err == nil {
+		err = gs.schedulePushLocked()
+	}
+	if errors.Is(err, ErrOffline) {
+		gs.debugf("%v", err)
+	} else if err != nil {
+		log.Printf("[git] %v", err)
+	}
+	result := SyncResult{Pushed: gs.status.Pushes > pushes, Err: err}
+	if gs.status.Commits > commits {
+		result.Commit, result.Files = gs.status.LastCommitHash, gs.status.LastCommitFiles
+	}
+	// The sync pulls only when it has something to push
+	if gs.pullDeferred && gs.repo != nil && gs.remote != "" {
 		gs.pullLocked()
+	}
+	gs.settleLocked()
+	return result
+}
 
-		pushOpts := &gogit.PushOptions{}
-		if gs.token != "" {
-			pushOpts.Auth = &http.BasicAuth{
-				Username: "token",
-				Password: gs.token,
+// Commit stages and commits pending changes without pushing them. It
+// returns the new commit's hash, or plumbing.ZeroHash when there was
+// nothing to commit.
+func (gs *Syncer) Commit() (plumbing.Hash, error) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	return gs.commitPendingLocked()
+}
+
+// Push sends commits the remote doesn't have yet, pulling first so the
+// push is a fast-forward unless Config.SkipPullBeforePush is set.
+// Uncommitted changes are left alone.
+func (gs *Syncer) Push() error {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	return gs.pushLocked()
+}
+
+// syncLocked commits pending changes and pushes them, along with any
+// commits waiting for the push timer. Caller must hold gs.mu.
+func (gs *Syncer) syncLocked() error {
+	if err := gs.commitPhaseLocked(); err != nil {
+		return err
+	}
+	gs.stopPushTimerLocked()
+	return gs.pushLocked()
+}
+
+// commitPhaseLocked is the commit half of a sync: it commits pending
+// changes and rolls up unpushed ones when a rollup is due, or makes a
+// heartbeat commit when one is due and there are none. Caller must hold
+// gs.mu.
+func (gs *Syncer) commitPhaseLocked() error {
+	log.Println("[git] syncing...")
+	heartbeat, rollup := gs.heartbeatDue, gs.rollupDue
+	gs.heartbeatDue, gs.rollupDue = false, false
+
+	if gs.repo == nil {
+		log.Println("[git] no repo configured, skipping sync")
+		return nil
+	}
+	hash, err := gs.commitPendingLocked()
+	if err != nil {
+		return err
+	}
+	if rollup {
+		if err := gs.rollupLocked(); err != nil {
+			return err
+		}
+	}
+	if hash.IsZero() && heartbeat {
+		return gs.heartbeatLocked()
+	}
+	return nil
+}
+
+// commitPendingLocked stages the served tree and commits it if anything
+// changed. Caller must hold gs.mu.
+func (gs *Syncer) commitPendingLocked() (plumbing.Hash, error) {
+	if gs.repo == nil || gs.replica {
+		return plumbing.ZeroHash, nil
+	}
+	if err := gs.preCommitLocked(); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	start := time.Now()
+	hash, paths, amended, err := gs.stageAndCommitLocked()
+	if err != nil && !errors.Is(err, errIndexLocked) && gs.repairIndexLocked() {
+		hash, paths, amended, err = gs.stageAndCommitLocked()
+	}
+	switch {
+	case err != nil:
+		gs.events.add(Event{Op: "commit", Result: "failed", Error: err.Error()}, start)
+		gs.checkCorruptLocked(err)
+	case !hash.IsZero():
+		gs.status.LastCommitTime = time.Now()
+		gs.status.LastCommitHash = hash.String()
+		gs.status.LastCommitFiles = len(paths)
+		gs.status.Commits++
+		result := "committed"
+		if amended {
+			gs.status.Amends++
+			result = "amended"
+		}
+		gs.events.add(Event{Op: "commit", Result: result, Hash: hash.String()}.withPaths(paths), start)
+		gs.instr.ObserveCommit(time.Since(start))
+		gs.measureLocked()
+		gs.runHookLocked(HookPostCommit, hash, paths)
+	}
+	return hash, err
+}
+
+// stageAndCommitLocked does the work of commitPendingLocked, returning the
+// paths the commit added, changed or removed, and whether it amended the
+// last commit rather than adding one.
+func (gs *Syncer) stageAndCommitLocked() (plumbing.Hash, []string, bool, error) {
+	if err := gs.checkIndexLockLocked(); err != nil {
+		return plumbing.ZeroHash, nil, false, err
+	}
+	wt, err := gs.repo.Worktree()
+	if err != nil {
+		return plumbing.ZeroHash, nil, false, fmt.Errorf("worktree failed: %w", err)
+	}
+
+	full := gs.pendingAll || len(gs.pendingPaths) == 0 || time.Since(gs.lastFullStage) >= fullStageInterval
+	var paths []string
+	if full {
+		paths, err = gs.stageAllLocked(wt)
+	} else {
+		paths, err = gs.stagePathsLocked(wt)
+	}
+	if err != nil {
+		return plumbing.ZeroHash, nil, false, err
+	}
+	gs.pendingPaths, gs.pendingAll = nil, false
+	if full {
+		gs.lastFullStage = time.Now()
+	}
+	if gs.secretScan != "" && gs.secretScan != SecretsOff {
+		if paths, err = gs.holdSecretsLocked(paths); err != nil {
+			return plumbing.ZeroHash, nil, false, fmt.Errorf("secret scan failed: %w", err)
+		}
+	}
+	if len(paths) == 0 {
+		log.Println("[git] no changes")
+		return plumbing.ZeroHash, nil, false, nil
+	}
+
+	amend := gs.amendableLocked()
+	hash, err := gs.commitLocked(wt, amend)
+	if amend && errors.Is(err, gogit.ErrEmptyCommit) {
+		// The changes undo the commit they'd amend; commit them on top
+		amend = false
+		hash, err = gs.commitLocked(wt, false)
+	}
+	if err != nil {
+		return plumbing.ZeroHash, nil, false, err
+	}
+	return hash, paths, amend, nil
+}
+
+// pushLocked pushes the branch if it is ahead of origin. Caller must hold
+// gs.mu.
+func (gs *Syncer) pushLocked() (err error) {
+	if gs.repo == nil || gs.remote == "" {
+		return nil
+	}
+	defer func() { gs.checkCorruptLocked(err) }()
+	// The branch may have been pushed before without a pull request, as
+	// when the last one was closed and the vault committed again as it was
+	defer func() {
+		if err == nil {
+			if err := gs.openPullRequestLocked(); err != nil {
+				log.Printf("[git] %v", err)
 			}
 		}
-		if err := gs.repo.Push(pushOpts); err != nil {
-			log.Printf("[git] push failed: %v", err)
-			return
+	}()
+	// With no commits to send, skip the round trips entirely. A commit
+	// whose push failed earlier is still ahead and goes out now.
+	if ahead, err := gs.aheadOfOrigin(); err != nil {
+		return err
+	} else if !ahead {
+		gs.debugf("%s is not ahead of origin, skipping push", gs.branch)
+		return nil
+	}
+
+	if gs.backingOffLocked() {
+		return gs.skipPushLocked()
+	}
+	gs.offline.unpushed = false
+	if gs.pullBeforePush {
+		gs.pullLocked()
+		if gs.backingOffLocked() {
+			// The pull found the remote unreachable
+			return gs.skipPushLocked()
+		}
+		// The pull may have fast-forwarded us to origin's tip
+		if ahead, err := gs.aheadOfOrigin(); err != nil {
+			return err
+		} else if !ahead {
+			gs.debugf("%s is not ahead of origin after pull, skipping push", gs.branch)
+			return nil
 		}
+	}
+
+	_, err = gs.repo.Reference(plumbing.NewRemoteReferenceName("origin", gs.branch), true)
+	newBranch := errors.Is(err, plumbing.ErrReferenceNotFound)
+
+	start := time.Now()
+	hash, err := gs.sendLocked()
+	if err == gogit.NoErrAlreadyUpToDate {
+		gs.instr.ObservePush(time.Since(start), nil)
+	} else {
+		gs.instr.ObservePush(time.Since(start), err)
+	}
+	result := "pushed"
+	switch {
+	case errors.Is(err, gogit.NoErrAlreadyUpToDate):
+		// Our view of origin was stale; something else already pushed
+		// these commits, so there is nothing left to do.
+		log.Printf("[git] origin/%s already up to date", gs.branch)
+		result = "up to date"
+	case err != nil:
+		gs.status.LastPushError = err.Error()
+		gs.events.add(Event{Op: "push", Result: "failed", Error: err.Error()}, start)
+		gs.networkFailedLocked("push", err)
+		return err
+	case newBranch:
+		log.Printf("[git] pushed, creating %s on the remote", gs.branch)
+		gs.status.Pushes++
+	default:
 		log.Println("[git] pushed")
+		gs.status.Pushes++
+	}
+	gs.status.LastPushTime = time.Now()
+	gs.status.LastPushError = ""
+	gs.events.add(Event{Op: "push", Result: result, Hash: hash.String()}, start)
+	if result == "pushed" {
+		gs.runHookLocked(HookPostPush, hash, nil)
+	}
+	return nil
+}
+
+// sendLocked uploads LFS content and pushes the branch, returning the
+// pushed commit. It returns gogit.NoErrAlreadyUpToDate, with the commit,
+// when the remote already had it. Caller must hold gs.mu.
+func (gs *Syncer) sendLocked() (plumbing.Hash, error) {
+	if gs.lfs != nil {
+		if err := gs.lfs.Push(context.Background()); err != nil {
+			return plumbing.ZeroHash, fmt.Errorf("%w: %w", ErrLFSUpload, err)
+		}
+	}
+
+	// An explicit refspec creates the branch on an empty remote, or
+	// on one that only has a different default branch. In pull-request
+	// mode the branch is git3's own, and is reset once a pull request
+	// from it is merged, so it is force-pushed.
+	branch := plumbing.NewBranchReferenceName(gs.branch)
+	head, err := gs.repo.Reference(branch, true)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	spec := config.RefSpec(branch + ":" + branch)
+	if gs.prs != nil {
+		spec = "+" + spec
+	}
+	pushOpts := &gogit.PushOptions{
+		RemoteName: "origin",
+		RefSpecs:   []config.RefSpec{spec},
+		Auth:       authFor(gs.token),
+	}
+	err = gs.repo.Push(pushOpts)
+	if err != nil && err != gogit.NoErrAlreadyUpToDate {
+		return plumbing.ZeroHash, fmt.Errorf("push failed: %w", err)
+	}
+	// Push only updates tracking refs the remote's fetch refspecs cover
+	tracking := plumbing.NewHashReference(plumbing.NewRemoteReferenceName("origin", gs.branch), head.Hash())
+	gs.repo.Storer.SetReference(tracking)
+	return head.Hash(), err
+}
+
+// commitLocked commits the staged changes as a sync commit and returns
+// its hash. With amend, the commit replaces the head and keeps its author
+// date, so the rollup window runs from the first change it holds. Caller
+// must hold gs.mu.
+func (gs *Syncer) commitLocked(wt *gogit.Worktree, amend bool) (plumbing.Hash, error) {
+	msg := fmt.Sprintf("%s%s", syncMessagePrefix, time.Now().Format("2006-01-02 15:04"))
+	author, committer := gs.signatures()
+	if amend {
+		if head, err := gs.headCommitLocked(); err == nil {
+			author.When = head.Author.When
+		}
+	}
+	hash, err := wt.Commit(msg, &gogit.CommitOptions{Author: &author, Committer: &committer, Amend: amend})
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("commit failed: %w", err)
+	}
+	return hash, nil
+}
+
+// signatures returns the author and committer of a commit made now. Both
+// are set explicitly rather than leaving the committer to go-git's
+// defaults.
+func (gs *Syncer) signatures() (author, committer object.Signature) {
+	now := time.Now()
+	return object.Signature{Name: gs.user, Email: gs.email, When: now},
+		object.Signature{Name: gs.committer, Email: gs.commEmail, When: now}
+}
+
+// aheadOfOrigin reports whether the branch has commits that origin/<branch>
+// lacks, going by the remote-tracking ref as of the last fetch or push.
+func (gs *Syncer) aheadOfOrigin() (bool, error) {
+	head, err := gs.repo.Reference(plumbing.NewBranchReferenceName(gs.branch), true)
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return false, nil // no commits yet
+	} else if err != nil {
+		return false, err
+	}
+	tracking, err := gs.repo.Reference(plumbing.NewRemoteReferenceName("origin", gs.branch), true)
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return true, nil // the remote doesn't have the branch yet
+	} else if err != nil {
+		return false, err
+	}
+	if head.Hash() == tracking.Hash() {
+		return false, nil
+	}
+
+	local, err := gs.repo.CommitObject(head.Hash())
+	if err != nil {
+		return false, err
+	}
+	remote, err := gs.repo.CommitObject(tracking.Hash())
+	if err != nil {
+		return true, nil
+	}
+	behind, err := local.IsAncestor(remote)
+	return !behind, err
+}
+
+func (gs *Syncer) debugf(format string, args ...any) {
+	if gs.debug {
+		log.Printf("[git] "+format, args...)
+	}
+}
+
+// authFor returns HTTPS basic auth for a personal access token, or nil.
+func authFor(token string) transport.AuthMethod {
+	if token == "" {
+		return nil
+	}
+	return &http.BasicAuth{
+		Username: "token",
+		Password: token,
 	}
 }
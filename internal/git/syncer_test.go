@@ -1,11 +1,23 @@
 package git
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
 )
 
 func TestInitRepoFresh(t *testing.T) {
@@ -15,7 +27,7 @@ func TestInitRepoFresh(t *testing.T) {
 		Branch: "main",
 	}
 
-	repo := InitRepo(cfg)
+	repo := mustInitRepo(t, cfg)
 	if repo == nil {
 		t.Fatal("expected non-nil repo")
 	}
@@ -33,27 +45,96 @@ func TestInitRepoIdempotent(t *testing.T) {
 		Branch: "main",
 	}
 
-	repo1 := InitRepo(cfg)
-	repo2 := InitRepo(cfg)
+	repo1, err1 := InitRepo(cfg)
+	repo2, err2 := InitRepo(cfg)
 
+	if err1 != nil || err2 != nil {
+		t.Fatalf("InitRepo failed: %v, %v", err1, err2)
+	}
 	if repo1 == nil || repo2 == nil {
 		t.Fatal("expected non-nil repos")
 	}
 }
 
-func TestInitRepoWithRemoteFallback(t *testing.T) {
+func TestInitRepoMovesUnbornHead(t *testing.T) {
+	dir := t.TempDir()
+	// git init leaves HEAD on a master with no commits
+	if _, err := gogit.PlainInit(dir, false); err != nil {
+		t.Fatal(err)
+	}
+
+	repo := mustInitRepo(t, Config{Dir: dir, Branch: "main"})
+	head, err := repo.Storer.Reference(plumbing.HEAD)
+	if err != nil || head.Target() != plumbing.NewBranchReferenceName("main") {
+		t.Fatalf("HEAD = %v, %v; want refs/heads/main", head, err)
+	}
+}
+
+func TestInitRepoWarnsAboutOtherBranch(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := gogit.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(dir, "a.md"), []byte("a"), 0644)
+	wt, _ := repo.Worktree()
+	wt.Add("a.md")
+	sig := &object.Signature{Name: "Test", Email: "test@test.com", When: time.Now()}
+	if _, err := wt.Commit("on master", &gogit.CommitOptions{Author: sig}); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	repo = mustInitRepo(t, Config{Dir: dir, Branch: "main"})
+	head, _ := repo.Storer.Reference(plumbing.HEAD)
+	if head.Target() != plumbing.NewBranchReferenceName("master") {
+		t.Fatalf("HEAD moved to %s off a branch with history", head.Target())
+	}
+	if !strings.Contains(buf.String(), "WARNING: the repository has master checked out, not the configured branch main") {
+		t.Fatalf("no warning logged:\n%s", buf.String())
+	}
+}
+
+func TestInitRepoCloneFailed(t *testing.T) {
 	dir := t.TempDir()
 	cfg := Config{
 		Dir:    dir,
-		Repo:   "https://example.com/nonexistent.git",
+		Repo:   filepath.Join(t.TempDir(), "nonexistent.git"),
 		Branch: "main",
 	}
 
-	// Clone will fail (bad URL), should fall back to init + create remote
-	repo := InitRepo(cfg)
-	if repo == nil {
-		t.Fatal("expected non-nil repo")
+	// A clone that fails is reported, not papered over with a fresh init
+	repo, err := InitRepo(cfg)
+	if !errors.Is(err, ErrClone) || repo != nil {
+		t.Fatalf("InitRepo = %v, %v; want nil, ErrClone", repo, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".git")); !os.IsNotExist(err) {
+		t.Fatal("failed clone should not leave a repository behind")
 	}
+}
+
+func TestInitRepoCannotCreateDir(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "file")
+	os.WriteFile(file, nil, 0644)
+
+	_, err := InitRepo(Config{Dir: filepath.Join(file, "vault"), Branch: "main"})
+	if !errors.Is(err, ErrCreateDir) {
+		t.Fatalf("InitRepo error = %v, want ErrCreateDir", err)
+	}
+}
+
+func TestInitRepoWithRemoteFallback(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{
+		Dir:    dir,
+		Repo:   newRemote(t, nil),
+		Branch: "main",
+	}
+
+	// The remote is empty, so InitRepo falls back to init + create remote
+	repo := mustInitRepo(t, cfg)
 
 	// Check that remote was created
 	remotes, err := repo.Remotes()
@@ -71,16 +152,13 @@ func TestInitRepoWithRemoteFallback(t *testing.T) {
 func TestDoSyncCommitsChanges(t *testing.T) {
 	dir := t.TempDir()
 	cfg := Config{
-		Dir:   dir,
+		Dir:    dir,
 		Branch: "main",
-		User:  "Test",
-		Email: "test@test.com",
+		User:   "Test",
+		Email:  "test@test.com",
 	}
 
-	repo := InitRepo(cfg)
-	if repo == nil {
-		t.Fatal("expected non-nil repo")
-	}
+	repo := mustInitRepo(t, cfg)
 
 	syncer := New(cfg, repo)
 
@@ -104,19 +182,45 @@ func TestDoSyncCommitsChanges(t *testing.T) {
 	}
 }
 
+func TestDoSyncSetsCommitter(t *testing.T) {
+	for _, tt := range []struct {
+		committer, email string
+		want             object.Signature
+	}{
+		{"", "", object.Signature{Name: "Alice", Email: "alice@example.com"}},
+		{"git3 service", "git3@example.com", object.Signature{Name: "git3 service", Email: "git3@example.com"}},
+	} {
+		cfg := Config{Dir: t.TempDir(), Branch: "main", User: "Alice", Email: "alice@example.com",
+			Committer: tt.committer, CommitterEmail: tt.email}
+		repo := mustInitRepo(t, cfg)
+		syncer := New(cfg, repo)
+		os.WriteFile(filepath.Join(cfg.Dir, "a.md"), []byte("a"), 0644)
+		syncer.doSync()
+
+		head, err := repo.Head()
+		if err != nil {
+			t.Fatal(err)
+		}
+		commit, _ := repo.CommitObject(head.Hash())
+		if commit.Author.Name != "Alice" || commit.Author.Email != "alice@example.com" {
+			t.Errorf("author = %s <%s>", commit.Author.Name, commit.Author.Email)
+		}
+		if commit.Committer.Name != tt.want.Name || commit.Committer.Email != tt.want.Email {
+			t.Errorf("committer = %s <%s>, want %s <%s>", commit.Committer.Name, commit.Committer.Email, tt.want.Name, tt.want.Email)
+		}
+	}
+}
+
 func TestDoSyncNoChanges(t *testing.T) {
 	dir := t.TempDir()
 	cfg := Config{
-		Dir:   dir,
+		Dir:    dir,
 		Branch: "main",
-		User:  "Test",
-		Email: "test@test.com",
+		User:   "Test",
+		Email:  "test@test.com",
 	}
 
-	repo := InitRepo(cfg)
-	if repo == nil {
-		t.Fatal("expected non-nil repo")
-	}
+	repo := mustInitRepo(t, cfg)
 
 	syncer := New(cfg, repo)
 
@@ -135,47 +239,64 @@ func TestDoSyncNoChanges(t *testing.T) {
 	}
 }
 
+func TestOnSync(t *testing.T) {
+	remote := newRemote(t, map[string]string{"a.md": "a"})
+	var results []SyncResult
+	cfg := Config{
+		Dir:    t.TempDir(),
+		Repo:   remote,
+		Branch: "main",
+		User:   "Test",
+		Email:  "test@test.com",
+		OnSync: func(r SyncResult) { results = append(results, r) },
+	}
+	repo := mustInitRepo(t, cfg)
+	syncer := New(cfg, repo)
+
+	os.WriteFile(filepath.Join(cfg.Dir, "b.md"), []byte("b"), 0644)
+	os.WriteFile(filepath.Join(cfg.Dir, "c.md"), []byte("c"), 0644)
+	syncer.doSync()
+	syncer.doSync()
+
+	if len(results) != 2 {
+		t.Fatalf("OnSync called %d times, want 2", len(results))
+	}
+	head, _ := repo.Head()
+	if got := results[0]; got.Commit != head.Hash().String() || got.Files != 2 || !got.Pushed || got.Err != nil {
+		t.Fatalf("sync with changes = %+v", got)
+	}
+	if got := results[1]; got != (SyncResult{}) {
+		t.Fatalf("sync without changes = %+v", got)
+	}
+}
+
 func TestTriggerDebounce(t *testing.T) {
 	dir := t.TempDir()
 	cfg := Config{
-		Dir:   dir,
+		Dir:    dir,
 		Branch: "main",
-		User:  "Test",
-		Email: "test@test.com",
+		User:   "Test",
+		Email:  "test@test.com",
 	}
 
-	repo := InitRepo(cfg)
-	if repo == nil {
-		t.Fatal("expected non-nil repo")
-	}
+	repo := mustInitRepo(t, cfg)
 
-	// Track doSync calls using a wrapper
+	// Count syncs as they finish
 	var syncCount atomic.Int32
+	cfg.OnSync = func(SyncResult) { syncCount.Add(1) }
 	syncer := New(cfg, repo)
 	syncer.debounce = 50 * time.Millisecond
 
 	// Create a file so there's something to commit
 	os.WriteFile(filepath.Join(dir, "test.txt"), []byte("hello"), 0644)
 
-	// Override the timer function to count calls
-	origSync := syncer.doSync
-	countingSync := func() {
-		syncCount.Add(1)
-		origSync()
-	}
-
 	// Trigger multiple times rapidly — only the last should fire
-	syncer.mu.Lock()
 	for i := 0; i < 5; i++ {
-		if syncer.timer != nil {
-			syncer.timer.Stop()
-		}
-		syncer.timer = time.AfterFunc(syncer.debounce, countingSync)
+		syncer.Trigger("test.txt")
+	}
+	if err := syncer.Wait(context.Background()); err != nil {
+		t.Fatal(err)
 	}
-	syncer.mu.Unlock()
-
-	// Wait for debounce to fire
-	time.Sleep(200 * time.Millisecond)
 
 	count := syncCount.Load()
 	if count != 1 {
@@ -183,6 +304,46 @@ func TestTriggerDebounce(t *testing.T) {
 	}
 }
 
+func TestWait(t *testing.T) {
+	cfg := Config{Dir: t.TempDir(), Branch: "main", User: "Test", Email: "test@test.com", Debounce: 20 * time.Millisecond}
+	repo := mustInitRepo(t, cfg)
+	syncer := New(cfg, repo)
+
+	// Nothing pending: it returns at once, even with a done context
+	done, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := syncer.Wait(done); err != nil {
+		t.Fatalf("Wait with nothing pending = %v", err)
+	}
+
+	os.WriteFile(filepath.Join(cfg.Dir, "a.md"), []byte("a"), 0644)
+	syncer.Trigger("a.md")
+	if err := syncer.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := headFiles(t, syncer); !slices.Equal(got, []string{"a.md"}) {
+		t.Fatalf("committed %v after Wait, want a.md", got)
+	}
+	if st := syncer.Status(); st.PendingTrigger || st.Commits != 1 {
+		t.Fatalf("status after Wait = %+v", st)
+	}
+
+	// The context bounds the wait for a debounce
+	syncer.debounce = time.Hour
+	os.WriteFile(filepath.Join(cfg.Dir, "b.md"), []byte("b"), 0644)
+	syncer.Trigger("b.md")
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := syncer.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Wait past its deadline = %v", err)
+	}
+	// Close runs the pending sync, which ends the wait
+	syncer.Close()
+	if err := syncer.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestNewSyncerNilRepo(t *testing.T) {
 	cfg := Config{
 		Dir:      t.TempDir(),
@@ -200,3 +361,661 @@ func TestNewSyncerNilRepo(t *testing.T) {
 	// Trigger should also not panic
 	syncer.Trigger()
 }
+
+// newRemote creates a bare repository seeded with one commit on main
+// containing files, and returns its path for use as a remote URL.
+func newRemote(t *testing.T, files map[string]string) string {
+	t.Helper()
+	base := t.TempDir()
+	bare := filepath.Join(base, "remote.git")
+	remote, err := gogit.PlainInit(bare, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.NewBranchReferenceName("main")))
+	if len(files) == 0 {
+		return bare
+	}
+
+	seed := filepath.Join(base, "seed")
+	pushFiles(t, bare, seed, files)
+	return bare
+}
+
+// pushFiles writes files into a scratch clone of remote at dir (cloning or
+// initializing it on first use) and pushes them to main as a new commit.
+func pushFiles(t *testing.T, remote, dir string, files map[string]string) plumbing.Hash {
+	t.Helper()
+	repo, err := gogit.PlainOpen(dir)
+	if err != nil {
+		repo, err = gogit.PlainClone(dir, false, &gogit.CloneOptions{URL: remote})
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		repo, err = gogit.PlainInit(dir, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		repo.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.NewBranchReferenceName("main")))
+		repo.CreateRemote(&config.RemoteConfig{Name: "origin", URLs: []string{remote}})
+	}
+	for name, content := range files {
+		full := filepath.Join(dir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(full), 0755)
+		os.WriteFile(full, []byte(content), 0644)
+	}
+	wt, _ := repo.Worktree()
+	wt.AddGlob(".")
+	hash, err := wt.Commit("seed", &gogit.CommitOptions{
+		Author: &object.Signature{Name: "Seed", Email: "seed@test", When: time.Now()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repo.Push(&gogit.PushOptions{RefSpecs: []config.RefSpec{"refs/heads/main:refs/heads/main"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
+
+func TestInitRepoSparseSubdir(t *testing.T) {
+	remote := newRemote(t, map[string]string{
+		"vault/a.md": "a",
+		"other/b.md": "b",
+	})
+	dir := t.TempDir()
+	cfg := Config{Dir: dir, Repo: remote, Branch: "main", Subdir: "vault", User: "Test", Email: "test@test.com"}
+
+	repo := mustInitRepo(t, cfg)
+	if _, err := os.Stat(filepath.Join(dir, "vault", "a.md")); err != nil {
+		t.Fatalf("subdir file not checked out: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "other")); !os.IsNotExist(err) {
+		t.Fatal("files outside the subdir should not be materialized")
+	}
+
+	// A sync commits the subdir change and keeps the rest of the tree.
+	os.WriteFile(filepath.Join(dir, "vault", "c.md"), []byte("c"), 0644)
+	syncer := New(cfg, repo)
+	syncer.doSync()
+
+	head, _ := repo.Head()
+	commit, _ := repo.CommitObject(head.Hash())
+	tree, _ := commit.Tree()
+	for _, name := range []string{"vault/a.md", "vault/c.md", "other/b.md"} {
+		if _, err := tree.File(name); err != nil {
+			t.Fatalf("commit tree missing %s: %v", name, err)
+		}
+	}
+}
+
+func TestInitRepoRefusesSubdirOutsideTree(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "vault")
+	for _, subdir := range []string{"../x", "a/../../x", "/etc", ".", "a/..", ".git", ".git3/recovered"} {
+		_, err := InitRepo(Config{Dir: dir, Branch: "main", Subdir: subdir})
+		if !errors.Is(err, ErrSubdir) {
+			t.Errorf("InitRepo with subdir %q = %v, want ErrSubdir", subdir, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(dir), "x")); !os.IsNotExist(err) {
+		t.Fatal("directory created outside the repository")
+	}
+	if got, err := CleanSubdir("notes//daily/"); got != "notes/daily" || err != nil {
+		t.Fatalf("CleanSubdir = %q, %v; want notes/daily", got, err)
+	}
+}
+
+func TestSparsePullOutsideSubdir(t *testing.T) {
+	remote := newRemote(t, map[string]string{
+		"vault/a.md": "a",
+		"other/b.md": "b",
+	})
+	dir := t.TempDir()
+	cfg := Config{Dir: dir, Repo: remote, Branch: "main", Subdir: "vault"}
+	repo := mustInitRepo(t, cfg)
+
+	pushFiles(t, remote, filepath.Join(t.TempDir(), "other-clone"), map[string]string{
+		"vault/a.md":   "a",
+		"other/b.md":   "b2",
+		"other/new.md": "n",
+	})
+
+	syncer := New(cfg, repo)
+	syncer.doPull()
+
+	if _, err := os.Stat(filepath.Join(dir, "other")); !os.IsNotExist(err) {
+		t.Fatal("pull should not materialize files outside the subdir")
+	}
+	wt, _ := repo.Worktree()
+	status, err := wt.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.IsClean() {
+		t.Fatalf("worktree not clean after pull: %v", status)
+	}
+}
+
+type countingUploader struct{ calls atomic.Int32 }
+
+func (u *countingUploader) Push(ctx context.Context) error {
+	u.calls.Add(1)
+	return nil
+}
+
+func TestDoSyncUploadsLFSBeforePush(t *testing.T) {
+	remote := newRemote(t, map[string]string{"a.md": "a"})
+	uploader := &countingUploader{}
+	cfg := Config{Dir: t.TempDir(), Repo: remote, Branch: "main", User: "Test", Email: "test@test.com", LFS: uploader}
+	repo := mustInitRepo(t, cfg)
+
+	os.WriteFile(filepath.Join(cfg.Dir, "b.md"), []byte("b"), 0644)
+	New(cfg, repo).doSync()
+
+	if uploader.calls.Load() != 1 {
+		t.Fatalf("LFS Push called %d times, want 1", uploader.calls.Load())
+	}
+}
+
+func TestFirstPushToEmptyRemote(t *testing.T) {
+	remote := newRemote(t, nil)
+	cfg := Config{Dir: t.TempDir(), Repo: remote, Branch: "main", User: "Test", Email: "test@test.com"}
+	repo := mustInitRepo(t, cfg)
+
+	os.WriteFile(filepath.Join(cfg.Dir, "a.md"), []byte("a"), 0644)
+	syncer := New(cfg, repo)
+	if _, err := syncer.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := syncer.Push(); err != nil {
+		t.Fatalf("first push to an empty remote: %v", err)
+	}
+
+	local, _ := repo.Head()
+	if got := remoteHash(t, remote, "main"); got != local.Hash() {
+		t.Fatalf("remote main = %s, want local head %s", got, local.Hash())
+	}
+}
+
+func TestPushAlreadyUpToDate(t *testing.T) {
+	remote := newRemote(t, map[string]string{"a.md": "a"})
+	cfg := Config{Dir: t.TempDir(), Repo: remote, Branch: "main", User: "Test", Email: "test@test.com"}
+	repo := mustInitRepo(t, cfg)
+	syncer := New(cfg, repo)
+
+	os.WriteFile(filepath.Join(cfg.Dir, "b.md"), []byte("b"), 0644)
+	syncer.doSync()
+
+	// Pushing commits the remote already has, as after a push whose
+	// tracking ref update was lost
+	syncer.mu.Lock()
+	hash, err := syncer.sendLocked()
+	syncer.mu.Unlock()
+	head, _ := repo.Head()
+	if err != gogit.NoErrAlreadyUpToDate || hash != head.Hash() {
+		t.Fatalf("sendLocked = %s, %v; want %s, NoErrAlreadyUpToDate", hash, err, head.Hash())
+	}
+
+	tracking, err := repo.Reference(plumbing.NewRemoteReferenceName("origin", "main"), true)
+	if err != nil || tracking.Hash() != head.Hash() {
+		t.Fatalf("origin/main = %v (%v), want %s", tracking, err, head.Hash())
+	}
+}
+
+func TestMissingBranchStartsFromDefault(t *testing.T) {
+	remote := newRemote(t, map[string]string{"shared.md": "shared"})
+	cfg := Config{Dir: t.TempDir(), Repo: remote, Branch: "devices/ipad", User: "Test", Email: "test@test.com"}
+	repo := mustInitRepo(t, cfg)
+	if _, err := os.Stat(filepath.Join(cfg.Dir, "shared.md")); err != nil {
+		t.Fatalf("new branch should start from the default branch: %v", err)
+	}
+
+	mainBefore := remoteHash(t, remote, "main")
+	os.WriteFile(filepath.Join(cfg.Dir, "ipad.md"), []byte("ipad"), 0644)
+	New(cfg, repo).doSync()
+
+	local, _ := repo.Head()
+	if local.Name().Short() != "devices/ipad" {
+		t.Fatalf("HEAD on %s, want devices/ipad", local.Name().Short())
+	}
+	if got := remoteHash(t, remote, "devices/ipad"); got != local.Hash() {
+		t.Fatalf("remote devices/ipad = %s, want %s", got, local.Hash())
+	}
+	if got := remoteHash(t, remote, "main"); got != mainBefore {
+		t.Fatal("remote main should be untouched")
+	}
+}
+
+// remoteHash returns the commit a branch points to in a bare remote.
+func remoteHash(t *testing.T, remote, branch string) plumbing.Hash {
+	t.Helper()
+	r, err := gogit.PlainOpen(remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, err := r.Reference(plumbing.NewBranchReferenceName(branch), true)
+	if err != nil {
+		t.Fatalf("remote branch %s: %v", branch, err)
+	}
+	return ref.Hash()
+}
+
+// remoteTree returns the tree at the tip of a branch in a bare remote.
+func remoteTree(t *testing.T, remote, branch string) *object.Tree {
+	t.Helper()
+	r, _ := gogit.PlainOpen(remote)
+	commit, err := r.CommitObject(remoteHash(t, remote, branch))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tree
+}
+
+// mustInitRepo runs InitRepo and fails the test on error.
+func mustInitRepo(t *testing.T, cfg Config) *gogit.Repository {
+	t.Helper()
+	repo, err := InitRepo(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
+
+// pushBranch pushes files as a new commit on branch, started from main.
+func pushBranch(t *testing.T, remote, branch string, files map[string]string) {
+	t.Helper()
+	dir := t.TempDir()
+	repo, err := gogit.PlainClone(dir, false, &gogit.CloneOptions{URL: remote})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wt, _ := repo.Worktree()
+	ref := plumbing.NewBranchReferenceName(branch)
+	if err := wt.Checkout(&gogit.CheckoutOptions{Branch: ref, Create: true}); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		os.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+	}
+	wt.AddGlob(".")
+	_, err = wt.Commit(branch, &gogit.CommitOptions{
+		Author: &object.Signature{Name: "Seed", Email: "seed@test", When: time.Now()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Push(&gogit.PushOptions{RefSpecs: []config.RefSpec{config.RefSpec(ref + ":" + ref)}}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSwitchBranch(t *testing.T) {
+	remote := newRemote(t, map[string]string{"shared.md": "shared"})
+	pushBranch(t, remote, "devices/ipad", map[string]string{"ipad.md": "ipad"})
+	cfg := Config{Dir: t.TempDir(), Repo: remote, Branch: "main", User: "Test", Email: "test@test.com"}
+	repo := mustInitRepo(t, cfg)
+	syncer := New(cfg, repo)
+
+	// Pending changes are flushed to the old branch first
+	os.WriteFile(filepath.Join(cfg.Dir, "pending.md"), []byte("p"), 0644)
+	if err := syncer.SwitchBranch("devices/ipad"); err != nil {
+		t.Fatal(err)
+	}
+	mainTree := remoteTree(t, remote, "main")
+	if _, err := mainTree.File("pending.md"); err != nil {
+		t.Fatalf("pending change not pushed to main before switching: %v", err)
+	}
+
+	if syncer.Branch() != "devices/ipad" {
+		t.Fatalf("Branch() = %q, want devices/ipad", syncer.Branch())
+	}
+	if _, err := os.Stat(filepath.Join(cfg.Dir, "ipad.md")); err != nil {
+		t.Fatalf("target branch not checked out: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.Dir, "pending.md")); !os.IsNotExist(err) {
+		t.Fatal("files only on main should be gone after the switch")
+	}
+
+	// Later syncs go to the new branch
+	os.WriteFile(filepath.Join(cfg.Dir, "after.md"), []byte("a"), 0644)
+	syncer.doSync()
+	local, _ := repo.Head()
+	if got := remoteHash(t, remote, "devices/ipad"); got != local.Hash() {
+		t.Fatalf("remote devices/ipad = %s, want %s", got, local.Hash())
+	}
+}
+
+func TestSwitchToNewBranch(t *testing.T) {
+	remote := newRemote(t, map[string]string{"shared.md": "shared"})
+	cfg := Config{Dir: t.TempDir(), Repo: remote, Branch: "main", User: "Test", Email: "test@test.com"}
+	repo := mustInitRepo(t, cfg)
+	syncer := New(cfg, repo)
+
+	if err := syncer.SwitchBranch("experiment"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.Dir, "shared.md")); err != nil {
+		t.Fatalf("new branch should start from the current one: %v", err)
+	}
+
+	os.WriteFile(filepath.Join(cfg.Dir, "x.md"), []byte("x"), 0644)
+	syncer.doSync()
+	local, _ := repo.Head()
+	if got := remoteHash(t, remote, "experiment"); got != local.Hash() {
+		t.Fatalf("remote experiment = %s, want %s", got, local.Hash())
+	}
+}
+
+func TestStateDirNotCommitted(t *testing.T) {
+	cfg := Config{Dir: t.TempDir(), Branch: "main", User: "Test", Email: "test@test.com"}
+	repo := mustInitRepo(t, cfg)
+	mustInitRepo(t, cfg) // idempotent
+
+	os.MkdirAll(filepath.Join(cfg.Dir, ".git3", "blobs"), 0755)
+	os.WriteFile(filepath.Join(cfg.Dir, ".git3", "blobs", "x"), []byte("x"), 0644)
+	os.WriteFile(filepath.Join(cfg.Dir, "a.md"), []byte("a"), 0644)
+	New(cfg, repo).doSync()
+
+	head, _ := repo.Head()
+	commit, _ := repo.CommitObject(head.Hash())
+	tree, _ := commit.Tree()
+	if _, err := tree.File(".git3/blobs/x"); err == nil {
+		t.Fatal("handler state directory was committed")
+	}
+	if _, err := tree.File("a.md"); err != nil {
+		t.Fatalf("a.md not committed: %v", err)
+	}
+
+	exclude, _ := os.ReadFile(filepath.Join(cfg.Dir, ".git", "info", "exclude"))
+	if strings.Count(string(exclude), ".git3/") != 1 {
+		t.Fatalf("info/exclude = %q, want one .git3/ entry", exclude)
+	}
+}
+
+func TestSyncSkipsPushWhenNotAhead(t *testing.T) {
+	remote := newRemote(t, map[string]string{"a.md": "a"})
+	cfg := Config{Dir: t.TempDir(), Repo: remote, Branch: "main", User: "Test", Email: "test@test.com"}
+	syncer := New(cfg, mustInitRepo(t, cfg))
+
+	// Any pull or push would fail now
+	os.RemoveAll(remote)
+
+	syncer.mu.Lock()
+	err := syncer.syncLocked()
+	syncer.mu.Unlock()
+	if err != nil {
+		t.Fatalf("sync with nothing to send touched the remote: %v", err)
+	}
+}
+
+func TestSyncPushesEarlierUnpushedCommit(t *testing.T) {
+	remote := newRemote(t, map[string]string{"a.md": "a"})
+	cfg := Config{Dir: t.TempDir(), Repo: remote, Branch: "main", User: "Test", Email: "test@test.com"}
+	repo := mustInitRepo(t, cfg)
+
+	// A commit whose push never happened
+	os.WriteFile(filepath.Join(cfg.Dir, "b.md"), []byte("b"), 0644)
+	wt, _ := repo.Worktree()
+	wt.AddGlob(".")
+	local, err := wt.Commit("offline", &gogit.CommitOptions{
+		Author: &object.Signature{Name: "Test", Email: "test@test.com", When: time.Now()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	New(cfg, repo).doSync()
+	if got := remoteHash(t, remote, "main"); got != local {
+		t.Fatalf("remote main = %s, want unpushed commit %s", got, local)
+	}
+}
+
+func TestSkipPullBeforePush(t *testing.T) {
+	remote := newRemote(t, map[string]string{"a.md": "a"})
+	instr := &recordingInstrumentation{}
+	cfg := Config{Dir: t.TempDir(), Repo: remote, Branch: "main", User: "Test", Email: "test@test.com",
+		SkipPullBeforePush: true, Instrumentation: instr}
+	repo := mustInitRepo(t, cfg)
+	syncer := New(cfg, repo)
+
+	os.WriteFile(filepath.Join(cfg.Dir, "b.md"), []byte("b"), 0644)
+	syncer.doSync()
+	head, _ := repo.Head()
+	if instr.pulls != 0 || instr.pushes != 1 || remoteHash(t, remote, "main") != head.Hash() {
+		t.Fatalf("observed %d pulls and %d pushes, want the push alone", instr.pulls, instr.pushes)
+	}
+
+	// A commit from elsewhere isn't pulled in, so the next push is rejected
+	pushFiles(t, remote, filepath.Join(t.TempDir(), "other"), map[string]string{"c.md": "c"})
+	os.WriteFile(filepath.Join(cfg.Dir, "d.md"), []byte("d"), 0644)
+	if result := syncer.Sync(); result.Err == nil || result.Pushed || instr.pulls != 0 {
+		t.Fatalf("sync behind the remote = %+v after %d pulls, want a rejected push", result, instr.pulls)
+	}
+}
+
+func TestSyncUpdatesTrackingRef(t *testing.T) {
+	remote := newRemote(t, map[string]string{"shared.md": "shared"})
+	cfg := Config{Dir: t.TempDir(), Repo: remote, Branch: "devices/ipad", User: "Test", Email: "test@test.com"}
+	repo := mustInitRepo(t, cfg)
+	syncer := New(cfg, repo)
+
+	os.WriteFile(filepath.Join(cfg.Dir, "ipad.md"), []byte("ipad"), 0644)
+	syncer.doSync()
+
+	head, _ := repo.Head()
+	tracking, err := repo.Reference(plumbing.NewRemoteReferenceName("origin", "devices/ipad"), true)
+	if err != nil || tracking.Hash() != head.Hash() {
+		t.Fatalf("origin/devices/ipad = %v (%v), want %s", tracking, err, head.Hash())
+	}
+	if ahead, err := syncer.aheadOfOrigin(); err != nil || ahead {
+		t.Fatalf("aheadOfOrigin after push = %v, %v; want false", ahead, err)
+	}
+}
+
+func TestCommitWithoutPush(t *testing.T) {
+	remote := newRemote(t, map[string]string{"a.md": "a"})
+	cfg := Config{Dir: t.TempDir(), Repo: remote, Branch: "main", User: "Test", Email: "test@test.com"}
+	repo := mustInitRepo(t, cfg)
+	syncer := New(cfg, repo)
+	before := remoteHash(t, remote, "main")
+
+	os.WriteFile(filepath.Join(cfg.Dir, "b.md"), []byte("b"), 0644)
+	hash, err := syncer.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, _ := repo.Head()
+	if hash.IsZero() || head.Hash() != hash {
+		t.Fatalf("Commit returned %s, HEAD is %s", hash, head.Hash())
+	}
+	if got := remoteHash(t, remote, "main"); got != before {
+		t.Fatalf("remote main moved to %s without a push", got)
+	}
+
+	// Nothing left to commit
+	if hash, err := syncer.Commit(); err != nil || !hash.IsZero() {
+		t.Fatalf("clean Commit = %s, %v; want zero hash", hash, err)
+	}
+}
+
+func TestPushWithoutCommit(t *testing.T) {
+	remote := newRemote(t, map[string]string{"a.md": "a"})
+	cfg := Config{Dir: t.TempDir(), Repo: remote, Branch: "main", User: "Test", Email: "test@test.com"}
+	repo := mustInitRepo(t, cfg)
+	syncer := New(cfg, repo)
+
+	os.WriteFile(filepath.Join(cfg.Dir, "b.md"), []byte("b"), 0644)
+	local, err := syncer.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Uncommitted changes stay behind
+	os.WriteFile(filepath.Join(cfg.Dir, "c.md"), []byte("c"), 0644)
+
+	if err := syncer.Push(); err != nil {
+		t.Fatal(err)
+	}
+	if got := remoteHash(t, remote, "main"); got != local {
+		t.Fatalf("remote main = %s, want %s", got, local)
+	}
+	if _, err := remoteTree(t, remote, "main").File("c.md"); err == nil {
+		t.Fatal("Push committed c.md")
+	}
+}
+
+type recordingNotifier struct {
+	mu     sync.Mutex
+	events [][]string
+}
+
+func (n *recordingNotifier) Changed(oldHash, newHash string, paths []string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.events = append(n.events, append([]string{oldHash, newHash}, paths...))
+}
+
+func TestPullNotifiesChanges(t *testing.T) {
+	remote := newRemote(t, map[string]string{"a.md": "a"})
+	notifier := &recordingNotifier{}
+	cfg := Config{Dir: t.TempDir(), Repo: remote, Branch: "main", User: "Test", Email: "test@test.com", Notifier: notifier}
+	repo := mustInitRepo(t, cfg)
+	syncer := New(cfg, repo)
+
+	// The server's own commits don't notify
+	os.WriteFile(filepath.Join(cfg.Dir, "local.md"), []byte("l"), 0644)
+	syncer.doSync()
+	syncer.doPull()
+	if len(notifier.events) != 0 {
+		t.Fatalf("own commit notified: %v", notifier.events)
+	}
+	before, _ := repo.Head()
+
+	newHash := pushFiles(t, remote, filepath.Join(t.TempDir(), "other"), map[string]string{"notes/b.md": "b"})
+	syncer.doPull()
+
+	if len(notifier.events) != 1 {
+		t.Fatalf("got %d notifications, want 1", len(notifier.events))
+	}
+	e := notifier.events[0]
+	if e[0] != before.Hash().String() || e[1] != newHash.String() {
+		t.Fatalf("notified %s..%s, want %s..%s", e[0], e[1], before.Hash(), newHash)
+	}
+	if len(e) != 3 || e[2] != "notes/b.md" {
+		t.Fatalf("changed paths = %v, want [notes/b.md]", e[2:])
+	}
+}
+
+func TestPushBranchSeparateFromPullBranch(t *testing.T) {
+	remote := newRemote(t, map[string]string{"shared.md": "shared"})
+	cfg := Config{Dir: t.TempDir(), Repo: remote, Branch: "main", PushBranch: "device-laptop", User: "Test", Email: "test@test.com"}
+	repo := mustInitRepo(t, cfg)
+	syncer := New(cfg, repo)
+
+	// Pulls integrate main...
+	pushFiles(t, remote, filepath.Join(t.TempDir(), "other"), map[string]string{"from-main.md": "m"})
+	syncer.doPull()
+	if _, err := os.Stat(filepath.Join(cfg.Dir, "from-main.md")); err != nil {
+		t.Fatalf("pull branch not integrated: %v", err)
+	}
+
+	// ...while sync commits land on the push branch only
+	mainBefore := remoteHash(t, remote, "main")
+	os.WriteFile(filepath.Join(cfg.Dir, "laptop.md"), []byte("l"), 0644)
+	syncer.doSync()
+
+	local, _ := repo.Head()
+	if local.Name().Short() != "device-laptop" {
+		t.Fatalf("HEAD on %s, want device-laptop", local.Name().Short())
+	}
+	if got := remoteHash(t, remote, "device-laptop"); got != local.Hash() {
+		t.Fatalf("remote device-laptop = %s, want %s", got, local.Hash())
+	}
+	if got := remoteHash(t, remote, "main"); got != mainBefore {
+		t.Fatal("sync commit was pushed to the pull branch")
+	}
+}
+
+func TestRequestPullCoalesces(t *testing.T) {
+	remote := newRemote(t, map[string]string{"a.md": "a"})
+	notifier := &recordingNotifier{}
+	cfg := Config{Dir: t.TempDir(), Repo: remote, Branch: "main", Notifier: notifier}
+	syncer := New(cfg, mustInitRepo(t, cfg))
+
+	defer func(d time.Duration) { pullCoalesce = d }(pullCoalesce)
+	pullCoalesce = 50 * time.Millisecond
+
+	pushFiles(t, remote, filepath.Join(t.TempDir(), "other"), map[string]string{"b.md": "b"})
+	for i := 0; i < 5; i++ {
+		syncer.RequestPull()
+	}
+	time.Sleep(300 * time.Millisecond)
+
+	if _, err := os.Stat(filepath.Join(cfg.Dir, "b.md")); err != nil {
+		t.Fatalf("requested pull did not happen: %v", err)
+	}
+	notifier.mu.Lock()
+	defer notifier.mu.Unlock()
+	if len(notifier.events) != 1 {
+		t.Fatalf("%d pulls brought changes, want 1", len(notifier.events))
+	}
+}
+
+func TestPullDeferredWhileSyncPending(t *testing.T) {
+	remote := newRemote(t, map[string]string{"a.md": "a"})
+	cfg := Config{Dir: t.TempDir(), Repo: remote, Branch: "main", User: "Test", Email: "test@test.com", Debounce: time.Hour}
+	syncer := New(cfg, mustInitRepo(t, cfg))
+
+	os.WriteFile(filepath.Join(cfg.Dir, "c.md"), []byte("c"), 0644)
+	syncer.Trigger()
+	syncer.doPull()
+	if st := syncer.Status(); st.PullsSkipped != 1 || !st.LastPullTime.IsZero() {
+		t.Fatalf("status = %+v, want the pull skipped while a sync is pending", st)
+	}
+
+	// The sync pulls once, right before its push
+	syncer.doSync()
+	st := syncer.Status()
+	if st.LastPullTime.IsZero() || st.Pushes != 1 {
+		t.Fatalf("status = %+v, want the sync to pull and push", st)
+	}
+	pulls := 0
+	for _, e := range syncer.Events() {
+		if e.Op == "pull" && e.Result != "skipped" {
+			pulls++
+		}
+	}
+	if pulls != 1 {
+		t.Fatalf("%d pulls ran, want only the sync's own", pulls)
+	}
+	tree := remoteTree(t, remote, "main")
+	for _, name := range []string{"a.md", "c.md"} {
+		if _, err := tree.File(name); err != nil {
+			t.Errorf("%s missing from the remote after sync: %v", name, err)
+		}
+	}
+}
+
+func TestPullDeferredWhileSyncing(t *testing.T) {
+	remote := newRemote(t, map[string]string{"a.md": "a"})
+	cfg := Config{Dir: t.TempDir(), Repo: remote, Branch: "main", User: "Test", Email: "test@test.com", Debounce: time.Hour}
+	syncer := New(cfg, mustInitRepo(t, cfg))
+	pushFiles(t, remote, filepath.Join(t.TempDir(), "other"), map[string]string{"b.md": "b"})
+
+	syncer.syncing.Store(true)
+	syncer.doPull()
+	syncer.syncing.Store(false)
+	if st := syncer.Status(); st.PullsSkipped != 1 || !st.LastPullTime.IsZero() {
+		t.Fatalf("status = %+v, want the pull skipped during a sync", st)
+	}
+
+	// Nothing to push, so the sync runs the deferred pull itself
+	syncer.doSync()
+	if _, err := os.Stat(filepath.Join(cfg.Dir, "b.md")); err != nil {
+		t.Fatalf("deferred pull did not run after the sync: %v", err)
+	}
+}
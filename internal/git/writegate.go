@@ -0,0 +1,92 @@
+package git
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// writeDrainTimeout bounds how long a commit waits for object writes in
+// progress, so a stuck write can't hold off syncing forever.
+var writeDrainTimeout = 30 * time.Second
+
+// writeGate lets object writes run alongside each other but not alongside
+// a commit staging the tree. A commit draining the gate holds off new
+// writes and waits for the ones in progress. The zero value is ready to
+// use.
+type writeGate struct {
+	mu       sync.Mutex
+	writers  int
+	draining chan struct{} // closed when the drain ends
+	idle     chan struct{} // closed when the last writer leaves a drain
+}
+
+func (g *writeGate) Lock() {
+	g.mu.Lock()
+	for g.draining != nil {
+		draining := g.draining
+		g.mu.Unlock()
+		<-draining
+		g.mu.Lock()
+	}
+	g.writers++
+	g.mu.Unlock()
+}
+
+func (g *writeGate) Unlock() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.writers--
+	if g.writers == 0 && g.idle != nil {
+		close(g.idle)
+		g.idle = nil
+	}
+}
+
+// drain holds off new writes and waits up to timeout for the ones in
+// progress to finish. It returns how many are still running; release
+// must be called either way.
+func (g *writeGate) drain(timeout time.Duration) int {
+	g.mu.Lock()
+	g.draining = make(chan struct{})
+	if g.writers == 0 {
+		g.mu.Unlock()
+		return 0
+	}
+	idle := make(chan struct{})
+	g.idle = idle
+	g.mu.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-idle:
+	case <-timer.C:
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.writers
+}
+
+// release lets writes held off by drain go ahead.
+func (g *writeGate) release() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	close(g.draining)
+	g.draining, g.idle = nil, nil
+}
+
+// WriteLock returns the lock S3 requests hold while they put an object in
+// place in the working tree. Commits wait for them, up to
+// writeDrainTimeout, so they never stage an object half way there.
+func (gs *Syncer) WriteLock() sync.Locker {
+	return &gs.writes
+}
+
+// drainWritesLocked waits for object writes in progress before staging.
+// Caller must hold gs.mu and call gs.writes.release when done staging.
+func (gs *Syncer) drainWritesLocked() {
+	if n := gs.writes.drain(writeDrainTimeout); n > 0 {
+		log.Printf("[git] WARNING: committing with %d object writes still in progress after %s", n, writeDrainTimeout)
+	}
+}
@@ -0,0 +1,91 @@
+package git
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+func committedFile(t *testing.T, syncer *Syncer, hash plumbing.Hash, name string) string {
+	t.Helper()
+	commit, err := syncer.repo.CommitObject(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := commit.File(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := f.Contents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return content
+}
+
+func TestCommitWaitsForWrites(t *testing.T) {
+	cfg := Config{Dir: t.TempDir(), Branch: "main", User: "Test", Email: "test@test.com"}
+	syncer := New(cfg, mustInitRepo(t, cfg))
+	path := filepath.Join(cfg.Dir, "big.md")
+
+	// A slow upload is half written when the sync fires
+	writes := syncer.WriteLock()
+	writes.Lock()
+	os.WriteFile(path, []byte("first half"), 0644)
+
+	done := make(chan plumbing.Hash)
+	go func() {
+		hash, err := syncer.Commit()
+		if err != nil {
+			t.Error(err)
+		}
+		done <- hash
+	}()
+	select {
+	case <-done:
+		t.Fatal("Commit didn't wait for the write in progress")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	f.WriteString(", second half")
+	f.Close()
+	writes.Unlock()
+
+	hash := <-done
+	if got := committedFile(t, syncer, hash, "big.md"); got != "first half, second half" {
+		t.Fatalf("committed %q, want the whole object", got)
+	}
+}
+
+func TestCommitWriteTimeout(t *testing.T) {
+	defer func(d time.Duration) { writeDrainTimeout = d }(writeDrainTimeout)
+	writeDrainTimeout = 20 * time.Millisecond
+
+	cfg := Config{Dir: t.TempDir(), Branch: "main", User: "Test", Email: "test@test.com"}
+	syncer := New(cfg, mustInitRepo(t, cfg))
+	os.WriteFile(filepath.Join(cfg.Dir, "a.md"), []byte("a"), 0644)
+
+	stuck := syncer.WriteLock()
+	stuck.Lock()
+	defer stuck.Unlock()
+	if hash, err := syncer.Commit(); err != nil || hash.IsZero() {
+		t.Fatalf("Commit with a stuck write = %s, %v", hash, err)
+	}
+
+	// Writes held off by the commit go ahead once it is done
+	other := make(chan struct{})
+	go func() {
+		syncer.WriteLock().Lock()
+		syncer.WriteLock().Unlock()
+		close(other)
+	}()
+	select {
+	case <-other:
+	case <-time.After(time.Second):
+		t.Fatal("write blocked after the commit")
+	}
+}
@@ -0,0 +1,185 @@
+package lfs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const mediaType = "application/vnd.git-lfs+json"
+
+// Client talks to an LFS server's batch API.
+type Client struct {
+	Endpoint   string // e.g. https://github.com/you/vault.git/info/lfs
+	Token      string
+	HTTPClient *http.Client
+}
+
+// EndpointFor derives the LFS endpoint from a git remote URL the same way
+// git-lfs does when no lfs.url is configured.
+func EndpointFor(remote string) string {
+	remote = strings.TrimSuffix(remote, "/")
+	if !strings.HasSuffix(remote, ".git") {
+		remote += ".git"
+	}
+	return remote + "/info/lfs"
+}
+
+type batchRequest struct {
+	Operation string    `json:"operation"`
+	Transfers []string  `json:"transfers"`
+	Objects   []Pointer `json:"objects"`
+}
+
+type batchResponse struct {
+	Objects []batchObject `json:"objects"`
+}
+
+type batchObject struct {
+	Oid     string            `json:"oid"`
+	Size    int64             `json:"size"`
+	Actions map[string]action `json:"actions"`
+	Error   *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+type action struct {
+	Href   string            `json:"href"`
+	Header map[string]string `json:"header"`
+}
+
+// Upload sends objects to the server. open is called for each object the
+// server asks for; objects it already has are skipped.
+func (c *Client) Upload(ctx context.Context, objects []Pointer, open func(Pointer) (io.ReadCloser, error)) error {
+	resp, err := c.batch(ctx, "upload", objects)
+	if err != nil {
+		return err
+	}
+	for _, obj := range resp.Objects {
+		if obj.Error != nil {
+			return fmt.Errorf("lfs upload %s: %s", obj.Oid, obj.Error.Message)
+		}
+		up, ok := obj.Actions["upload"]
+		if !ok {
+			continue
+		}
+		p := Pointer{Oid: obj.Oid, Size: obj.Size}
+		if err := c.upload(ctx, p, up, open); err != nil {
+			return err
+		}
+		if verify, ok := obj.Actions["verify"]; ok {
+			body, _ := json.Marshal(p)
+			if err := c.do(ctx, "POST", verify, bytes.NewReader(body), int64(len(body)), nil); err != nil {
+				return fmt.Errorf("lfs verify %s: %w", obj.Oid, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Client) upload(ctx context.Context, p Pointer, a action, open func(Pointer) (io.ReadCloser, error)) error {
+	rc, err := open(p)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	if err := c.do(ctx, "PUT", a, rc, p.Size, nil); err != nil {
+		return fmt.Errorf("lfs upload %s: %w", p.Oid, err)
+	}
+	return nil
+}
+
+// Download streams one object's content into w.
+func (c *Client) Download(ctx context.Context, p Pointer, w io.Writer) error {
+	resp, err := c.batch(ctx, "download", []Pointer{p})
+	if err != nil {
+		return err
+	}
+	if len(resp.Objects) != 1 {
+		return fmt.Errorf("lfs download %s: server returned %d objects", p.Oid, len(resp.Objects))
+	}
+	obj := resp.Objects[0]
+	if obj.Error != nil {
+		return fmt.Errorf("lfs download %s: %s", p.Oid, obj.Error.Message)
+	}
+	dl, ok := obj.Actions["download"]
+	if !ok {
+		return fmt.Errorf("lfs download %s: no download action", p.Oid)
+	}
+	return c.do(ctx, "GET", dl, nil, 0, w)
+}
+
+func (c *Client) batch(ctx context.Context, op string, objects []Pointer) (*batchResponse, error) {
+	body, _ := json.Marshal(batchRequest{Operation: op, Transfers: []string{"basic"}, Objects: objects})
+	req, err := http.NewRequestWithContext(ctx, "POST", c.Endpoint+"/objects/batch", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", mediaType)
+	req.Header.Set("Content-Type", mediaType)
+	if c.Token != "" {
+		req.SetBasicAuth("token", c.Token)
+	}
+
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("lfs batch: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("lfs batch: %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+
+	var out batchResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("lfs batch: %w", err)
+	}
+	return &out, nil
+}
+
+// do performs a transfer action. Action headers carry their own auth, so
+// the token is only added when the server didn't supply any.
+func (c *Client) do(ctx context.Context, method string, a action, body io.Reader, size int64, w io.Writer) error {
+	req, err := http.NewRequestWithContext(ctx, method, a.Href, body)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.ContentLength = size
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+	for k, v := range a.Header {
+		req.Header.Set(k, v)
+	}
+	if req.Header.Get("Authorization") == "" && c.Token != "" {
+		req.SetBasicAuth("token", c.Token)
+	}
+
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s %s: %s", method, a.Href, resp.Status)
+	}
+	if w != nil {
+		_, err = io.Copy(w, resp.Body)
+	}
+	return err
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return &http.Client{Timeout: 10 * time.Minute}
+}
@@ -0,0 +1,249 @@
+// Package lfs stores large vault objects with Git LFS: matching files are
+// replaced in the working tree by pointer files, their content lives in the
+// repository's local LFS object store, and it is uploaded to the LFS server
+// before each push and downloaded again on demand.
+package lfs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const attrSuffix = " filter=lfs diff=lfs merge=lfs -text"
+
+// Config selects which objects go to LFS and where they are kept.
+type Config struct {
+	Root      string   // served vault directory; keys and .gitattributes are relative to it
+	GitDir    string   // the repository's .git directory, which holds lfs/objects
+	Patterns  []string // gitattributes-style patterns, e.g. "*.png" or "audio/*"
+	Threshold int64    // objects at least this large are tracked too (0 disables)
+	Client    *Client  // LFS server; nil keeps objects local only
+}
+
+// LFS manages pointer files and the local object store.
+type LFS struct {
+	cfg Config
+	mu  sync.Mutex // guards .gitattributes updates
+}
+
+// New creates an LFS store and makes sure .gitattributes tracks the
+// configured patterns.
+func New(cfg Config) (*LFS, error) {
+	l := &LFS{cfg: cfg}
+	for _, dir := range []string{l.objectsDir(), l.pendingDir()} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	for _, p := range cfg.Patterns {
+		if err := l.track(p); err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// Track reports whether an object with this key and size belongs in LFS.
+func (l *LFS) Track(key string, size int64) bool {
+	if key == ".gitattributes" {
+		return false
+	}
+	if l.cfg.Threshold > 0 && size >= l.cfg.Threshold {
+		return true
+	}
+	return l.matches(key)
+}
+
+func (l *LFS) matches(key string) bool {
+	for _, p := range l.cfg.Patterns {
+		target := key
+		if !strings.Contains(p, "/") {
+			target = path.Base(key)
+		}
+		if ok, _ := path.Match(strings.TrimPrefix(p, "/"), target); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Clean moves the file at filePath into the object store and leaves a
+// pointer in its place. Objects tracked only by size get their own
+// .gitattributes entry so other git-lfs clients smudge them too.
+func (l *LFS) Clean(key, filePath string) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	p := Pointer{Oid: hex.EncodeToString(h.Sum(nil)), Size: size}
+
+	obj := l.objectPath(p.Oid)
+	if err := os.MkdirAll(filepath.Dir(obj), 0755); err != nil {
+		return err
+	}
+	if _, err := os.Stat(obj); err == nil {
+		os.Remove(filePath)
+	} else if err := os.Rename(filePath, obj); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(l.pendingDir(), p.Oid), nil, 0644); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filePath, p.Encode(), 0644); err != nil {
+		return err
+	}
+
+	if !l.matches(key) {
+		return l.track("/" + strings.ReplaceAll(key, " ", "[[:space:]]"))
+	}
+	return nil
+}
+
+// Open returns the real content behind filePath: the file itself, or the
+// LFS object its pointer refers to, downloading it first if needed.
+func (l *LFS) Open(filePath string) (*os.File, error) {
+	p, ok, err := readPointer(filePath)
+	if err != nil || !ok {
+		return os.Open(filePath)
+	}
+
+	obj := l.objectPath(p.Oid)
+	f, err := os.Open(obj)
+	if err == nil || !os.IsNotExist(err) || l.cfg.Client == nil {
+		return f, err
+	}
+	if err := l.download(p); err != nil {
+		return nil, err
+	}
+	return os.Open(obj)
+}
+
+// Size returns the content size for a file, looking through pointers.
+func (l *LFS) Size(filePath string, size int64) int64 {
+	if size > MaxPointerSize {
+		return size
+	}
+	if p, ok, _ := readPointer(filePath); ok {
+		return p.Size
+	}
+	return size
+}
+
+// Push uploads objects added since the last successful push.
+func (l *LFS) Push(ctx context.Context) error {
+	entries, err := os.ReadDir(l.pendingDir())
+	if err != nil || len(entries) == 0 || l.cfg.Client == nil {
+		return err
+	}
+
+	var objects []Pointer
+	for _, e := range entries {
+		if len(e.Name()) != 64 {
+			continue
+		}
+		info, err := os.Stat(l.objectPath(e.Name()))
+		if err != nil {
+			log.Printf("[lfs] pending object %s missing: %v", e.Name(), err)
+			os.Remove(filepath.Join(l.pendingDir(), e.Name()))
+			continue
+		}
+		objects = append(objects, Pointer{Oid: e.Name(), Size: info.Size()})
+	}
+
+	err = l.cfg.Client.Upload(ctx, objects, func(p Pointer) (io.ReadCloser, error) {
+		return os.Open(l.objectPath(p.Oid))
+	})
+	if err != nil {
+		return err
+	}
+	for _, p := range objects {
+		os.Remove(filepath.Join(l.pendingDir(), p.Oid))
+	}
+	log.Printf("[lfs] uploaded %d object(s)", len(objects))
+	return nil
+}
+
+func (l *LFS) download(p Pointer) error {
+	obj := l.objectPath(p.Oid)
+	if err := os.MkdirAll(filepath.Dir(obj), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(obj), p.Oid+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	h := sha256.New()
+	err = l.cfg.Client.Download(context.Background(), p, io.MultiWriter(tmp, h))
+	tmp.Close()
+	if err != nil {
+		return err
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != p.Oid {
+		return fmt.Errorf("lfs download %s: content hash %s does not match", p.Oid, got)
+	}
+	log.Printf("[lfs] downloaded %s (%d bytes)", p.Oid, p.Size)
+	return os.Rename(tmp.Name(), obj)
+}
+
+// track adds a pattern to .gitattributes unless it is already there.
+func (l *LFS) track(pattern string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	attrPath := filepath.Join(l.cfg.Root, ".gitattributes")
+	data, err := os.ReadFile(attrPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, pattern+" ") && strings.Contains(line, "filter=lfs") {
+			return nil
+		}
+	}
+
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		data = append(data, '\n')
+	}
+	data = append(data, pattern+attrSuffix+"\n"...)
+	return os.WriteFile(attrPath, data, 0644)
+}
+
+func (l *LFS) objectsDir() string { return filepath.Join(l.cfg.GitDir, "lfs", "objects") }
+func (l *LFS) pendingDir() string { return filepath.Join(l.cfg.GitDir, "lfs", "pending") }
+
+// objectPath follows git-lfs's layout so a git-lfs client sharing the
+// repository finds the same objects.
+func (l *LFS) objectPath(oid string) string {
+	return filepath.Join(l.objectsDir(), oid[0:2], oid[2:4], oid)
+}
+
+func readPointer(filePath string) (Pointer, bool, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return Pointer{}, false, err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, MaxPointerSize+1))
+	if err != nil {
+		return Pointer{}, false, err
+	}
+	p, ok := DecodePointer(data)
+	return p, ok, nil
+}
@@ -0,0 +1,203 @@
+package lfs
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestPointerRoundTrip(t *testing.T) {
+	p := Pointer{Oid: strings.Repeat("ab", 32), Size: 12345}
+	got, ok := DecodePointer(p.Encode())
+	if !ok || got != p {
+		t.Fatalf("DecodePointer(Encode()) = %+v, %v; want %+v", got, ok, p)
+	}
+}
+
+func TestDecodePointerRejectsContent(t *testing.T) {
+	for _, data := range []string{
+		"hello world",
+		"version https://git-lfs.github.com/spec/v1\noid sha256:../../etc\nsize 1\n",
+		"version https://git-lfs.github.com/spec/v1\noid sha256:" + strings.Repeat("a", 64) + "\nsize -1\n",
+	} {
+		if _, ok := DecodePointer([]byte(data)); ok {
+			t.Errorf("DecodePointer(%q) accepted non-pointer", data)
+		}
+	}
+}
+
+func TestEndpointFor(t *testing.T) {
+	tests := map[string]string{
+		"https://github.com/you/vault.git": "https://github.com/you/vault.git/info/lfs",
+		"https://github.com/you/vault":     "https://github.com/you/vault.git/info/lfs",
+	}
+	for remote, want := range tests {
+		if got := EndpointFor(remote); got != want {
+			t.Errorf("EndpointFor(%q) = %q, want %q", remote, got, want)
+		}
+	}
+}
+
+func newTestLFS(t *testing.T, cfg Config) *LFS {
+	t.Helper()
+	root := t.TempDir()
+	cfg.Root = root
+	cfg.GitDir = filepath.Join(root, ".git")
+	l, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func TestTrackPatternsAndThreshold(t *testing.T) {
+	l := newTestLFS(t, Config{Patterns: []string{"*.png", "audio/*"}, Threshold: 100})
+
+	tests := []struct {
+		key  string
+		size int64
+		want bool
+	}{
+		{"img/cat.png", 10, true},
+		{"audio/memo.m4a", 10, true},
+		{"notes/a.md", 10, false},
+		{"notes/big.md", 100, true},
+		{".gitattributes", 1000, false},
+	}
+	for _, tt := range tests {
+		if got := l.Track(tt.key, tt.size); got != tt.want {
+			t.Errorf("Track(%q, %d) = %v, want %v", tt.key, tt.size, got, tt.want)
+		}
+	}
+
+	attrs, _ := os.ReadFile(filepath.Join(l.cfg.Root, ".gitattributes"))
+	if !strings.Contains(string(attrs), "*.png filter=lfs diff=lfs merge=lfs -text") {
+		t.Fatalf(".gitattributes missing pattern:\n%s", attrs)
+	}
+}
+
+func TestCleanAndOpen(t *testing.T) {
+	l := newTestLFS(t, Config{Threshold: 5})
+	path := filepath.Join(l.cfg.Root, "big file.bin")
+	os.WriteFile(path, []byte("large content"), 0644)
+
+	if err := l.Clean("big file.bin", path); err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := os.ReadFile(path)
+	p, ok := DecodePointer(data)
+	if !ok || p.Size != 13 {
+		t.Fatalf("working tree file is not a pointer: %q", data)
+	}
+	if got := l.Size(path, int64(len(data))); got != 13 {
+		t.Fatalf("Size = %d, want 13", got)
+	}
+
+	f, err := l.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := io.ReadAll(f)
+	f.Close()
+	if string(content) != "large content" {
+		t.Fatalf("Open content = %q", content)
+	}
+
+	attrs, _ := os.ReadFile(filepath.Join(l.cfg.Root, ".gitattributes"))
+	if !strings.Contains(string(attrs), "/big[[:space:]]file.bin filter=lfs") {
+		t.Fatalf("size-tracked file missing from .gitattributes:\n%s", attrs)
+	}
+}
+
+// fakeServer is a minimal in-memory LFS server speaking the batch API.
+type fakeServer struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	*httptest.Server
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	fs := &fakeServer{objects: make(map[string][]byte)}
+	fs.Server = httptest.NewServer(http.HandlerFunc(fs.serve))
+	t.Cleanup(fs.Close)
+	return fs
+}
+
+func (fs *fakeServer) serve(w http.ResponseWriter, r *http.Request) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if r.URL.Path == "/info/lfs/objects/batch" {
+		var req batchRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		var resp batchResponse
+		for _, p := range req.Objects {
+			obj := batchObject{Oid: p.Oid, Size: p.Size, Actions: map[string]action{}}
+			_, have := fs.objects[p.Oid]
+			switch {
+			case req.Operation == "upload" && !have:
+				obj.Actions["upload"] = action{Href: fs.URL + "/objects/" + p.Oid}
+			case req.Operation == "download" && have:
+				obj.Actions["download"] = action{Href: fs.URL + "/objects/" + p.Oid}
+			}
+			resp.Objects = append(resp.Objects, obj)
+		}
+		w.Header().Set("Content-Type", mediaType)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	oid := strings.TrimPrefix(r.URL.Path, "/objects/")
+	switch r.Method {
+	case "PUT":
+		fs.objects[oid], _ = io.ReadAll(r.Body)
+	case "GET":
+		w.Write(fs.objects[oid])
+	}
+}
+
+func TestPushAndDownload(t *testing.T) {
+	server := newFakeServer(t)
+	client := &Client{Endpoint: server.URL + "/info/lfs"}
+
+	// Upload from one store...
+	src := newTestLFS(t, Config{Patterns: []string{"*.bin"}, Client: client})
+	path := filepath.Join(src.cfg.Root, "a.bin")
+	os.WriteFile(path, []byte("payload"), 0644)
+	if err := src.Clean("a.bin", path); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.Push(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(server.objects) != 1 {
+		t.Fatalf("server has %d objects, want 1", len(server.objects))
+	}
+	if entries, _ := os.ReadDir(src.pendingDir()); len(entries) != 0 {
+		t.Fatal("pending uploads not cleared after push")
+	}
+
+	// ...and read it back from a clone that only has the pointer.
+	dst := newTestLFS(t, Config{Patterns: []string{"*.bin"}, Client: client})
+	pointer, _ := os.ReadFile(path)
+	dstPath := filepath.Join(dst.cfg.Root, "a.bin")
+	os.WriteFile(dstPath, pointer, 0644)
+
+	f, err := dst.Open(dstPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	content, _ := io.ReadAll(f)
+	if string(content) != "payload" {
+		t.Fatalf("downloaded content = %q, want payload", content)
+	}
+}
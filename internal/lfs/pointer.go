@@ -0,0 +1,56 @@
+package lfs
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	specVersion = "https://git-lfs.github.com/spec/v1"
+
+	// MaxPointerSize is the largest file that can be a pointer; anything
+	// bigger is always real content.
+	MaxPointerSize = 1024
+)
+
+// Pointer identifies an LFS object by its SHA-256 and size.
+type Pointer struct {
+	Oid  string `json:"oid"`
+	Size int64  `json:"size"`
+}
+
+// Encode renders the pointer file that is committed in place of the content.
+func (p Pointer) Encode() []byte {
+	return []byte(fmt.Sprintf("version %s\noid sha256:%s\nsize %d\n", specVersion, p.Oid, p.Size))
+}
+
+// DecodePointer parses a pointer file. ok is false if data is not a pointer.
+func DecodePointer(data []byte) (p Pointer, ok bool) {
+	if len(data) > MaxPointerSize || !bytes.HasPrefix(data, []byte("version ")) {
+		return Pointer{}, false
+	}
+
+	fields := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		k, v, found := strings.Cut(line, " ")
+		if !found {
+			return Pointer{}, false
+		}
+		fields[k] = v
+	}
+
+	if fields["version"] != specVersion {
+		return Pointer{}, false
+	}
+	oid, found := strings.CutPrefix(fields["oid"], "sha256:")
+	if !found || len(oid) != 64 || strings.Trim(oid, "0123456789abcdef") != "" {
+		return Pointer{}, false
+	}
+	size, err := strconv.ParseInt(fields["size"], 10, 64)
+	if err != nil || size < 0 {
+		return Pointer{}, false
+	}
+	return Pointer{Oid: oid, Size: size}, true
+}
@@ -0,0 +1,57 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"sync"
+
+	"git3/internal/s3"
+)
+
+// Auth counts requests that failed authentication, by reason. It
+// implements s3.AuthObserver.
+type Auth struct {
+	mu       sync.Mutex
+	failures map[string]uint64
+}
+
+// NewAuth creates an Auth with every known reason at zero, so rates can be
+// computed before the first failure.
+func NewAuth() *Auth {
+	m := &Auth{failures: make(map[string]uint64)}
+	for _, reason := range s3.AuthFailureReasons {
+		m.failures[reason] = 0
+	}
+	return m
+}
+
+func (m *Auth) AuthFailed(reason string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failures[reason]++
+}
+
+// WriteTo writes the metrics in the Prometheus text format.
+func (m *Auth) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p := &printer{w: w}
+	p.header("git3_auth_failures_total", "counter", "Requests that failed authentication, by reason.")
+	for _, reason := range sortedKeys(m.failures) {
+		p.sample("git3_auth_failures_total", label("reason", reason), float64(m.failures[reason]))
+	}
+	return p.n, p.err
+}
+
+// Handler serves several sets of metrics on one endpoint.
+func Handler(sets ...io.WriterTo) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		for _, set := range sets {
+			if _, err := set.WriteTo(w); err != nil {
+				return
+			}
+		}
+	})
+}
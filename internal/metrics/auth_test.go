@@ -0,0 +1,28 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"git3/internal/s3"
+)
+
+func TestAuthMetrics(t *testing.T) {
+	m := NewAuth()
+	m.AuthFailed(s3.AuthBadSignature)
+	m.AuthFailed(s3.AuthBadSignature)
+
+	w := httptest.NewRecorder()
+	Handler(NewSyncer(), m).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	for _, want := range []string{
+		"# TYPE git3_auth_failures_total counter\n",
+		`git3_auth_failures_total{reason="bad_signature"} 2` + "\n",
+		`git3_auth_failures_total{reason="skewed_date"} 0` + "\n",
+		"git3_commits_total 0\n",
+	} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Fatalf("metrics lack %q:\n%s", want, w.Body)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package metrics
+
+import (
+	"io"
+
+	"git3/internal/git"
+)
+
+// RemoteProber is the part of the syncer the remote metrics read.
+type RemoteProber interface {
+	RemoteHealth() (git.RemoteHealth, bool)
+}
+
+// Remote reports what the last probe of the git remote found. Nothing is
+// written until a probe ran, so with probing off the metrics are absent
+// rather than reporting an unreachable remote.
+type Remote struct {
+	Prober RemoteProber
+}
+
+// WriteTo writes the metrics in the Prometheus text format.
+func (m Remote) WriteTo(w io.Writer) (int64, error) {
+	p := &printer{w: w}
+	health, ok := m.Prober.RemoteHealth()
+	if !ok {
+		return 0, nil
+	}
+	p.header("git3_remote_reachable", "gauge", "1 if the git remote answered the last probe.")
+	p.sample("git3_remote_reachable", "", boolGauge(health.Reachable))
+	p.header("git3_remote_auth_ok", "gauge", "1 if the git remote accepted the credentials at the last probe.")
+	p.sample("git3_remote_auth_ok", "", boolGauge(health.AuthOK))
+	p.header("git3_remote_probe_timestamp_seconds", "gauge", "Unix time of the last probe of the git remote.")
+	p.sample("git3_remote_probe_timestamp_seconds", "", float64(health.CheckedAt.Unix()))
+	return p.n, p.err
+}
+
+func boolGauge(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
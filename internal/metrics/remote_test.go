@@ -0,0 +1,38 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"git3/internal/git"
+)
+
+type fakeProber struct {
+	health git.RemoteHealth
+	ok     bool
+}
+
+func (f fakeProber) RemoteHealth() (git.RemoteHealth, bool) { return f.health, f.ok }
+
+func TestRemoteMetrics(t *testing.T) {
+	var buf bytes.Buffer
+	Remote{Prober: fakeProber{}}.WriteTo(&buf)
+	if buf.Len() != 0 {
+		t.Fatalf("metrics before any probe:\n%s", buf.String())
+	}
+
+	at := time.Unix(1700000000, 0)
+	Remote{Prober: fakeProber{git.RemoteHealth{Reachable: true, CheckedAt: at}, true}}.WriteTo(&buf)
+	for _, want := range []string{
+		"# TYPE git3_remote_reachable gauge\n",
+		"git3_remote_reachable 1\n",
+		"git3_remote_auth_ok 0\n",
+		"git3_remote_probe_timestamp_seconds 1.7e+09\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Fatalf("metrics lack %q:\n%s", want, buf.String())
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package metrics
+
+import (
+	"io"
+
+	"git3/internal/s3"
+)
+
+// Vault is the part of the S3 handler the size metrics read.
+type Vault interface {
+	Stats() (objects int, bytes int64, err error)
+}
+
+// Size reports how big the repository and the vault's working tree are.
+type Size struct {
+	Repo  s3.RepoSizer
+	Vault Vault
+}
+
+// WriteTo writes the metrics in the Prometheus text format.
+func (m Size) WriteTo(w io.Writer) (int64, error) {
+	p := &printer{w: w}
+	size, limit := m.Repo.RepoSize()
+	p.header("git3_repo_bytes", "gauge", "Size of the git repository, as of the last commit or pull.")
+	p.sample("git3_repo_bytes", "", float64(size))
+	if limit > 0 {
+		p.header("git3_repo_size_limit_bytes", "gauge", "Size the repository should stay under.")
+		p.sample("git3_repo_size_limit_bytes", "", float64(limit))
+	}
+	if objects, bytes, err := m.Vault.Stats(); err == nil {
+		p.header("git3_vault_objects", "gauge", "Objects in the bucket.")
+		p.sample("git3_vault_objects", "", float64(objects))
+		p.header("git3_vault_bytes", "gauge", "Total content size of the objects in the bucket.")
+		p.sample("git3_vault_bytes", "", float64(bytes))
+	}
+	return p.n, p.err
+}
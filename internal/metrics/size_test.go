@@ -0,0 +1,31 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+type fakeRepo struct{}
+
+func (fakeRepo) RepoSize() (int64, int64) { return 2048, 4096 }
+
+type fakeVault struct{}
+
+func (fakeVault) Stats() (int, int64, error) { return 3, 1024, nil }
+
+func TestSizeMetrics(t *testing.T) {
+	var buf bytes.Buffer
+	Size{Repo: fakeRepo{}, Vault: fakeVault{}}.WriteTo(&buf)
+	for _, want := range []string{
+		"# TYPE git3_repo_bytes gauge\n",
+		"git3_repo_bytes 2048\n",
+		"git3_repo_size_limit_bytes 4096\n",
+		"git3_vault_objects 3\n",
+		"git3_vault_bytes 1024\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Fatalf("metrics lack %q:\n%s", want, buf.String())
+		}
+	}
+}
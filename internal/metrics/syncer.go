@@ -0,0 +1,195 @@
+// Package metrics exposes git3's internal state in the Prometheus text
+// exposition format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"git3/internal/git"
+)
+
+// durationBuckets are the histogram bounds, in seconds, for sync steps. A
+// commit takes milliseconds; a push over a slow link can take minutes.
+var durationBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120}
+
+// syncOps are the steps timed by git3_sync_duration_seconds.
+var syncOps = []string{"commit", "pull", "push"}
+
+// Syncer implements git.Instrumentation and serves what it collected.
+type Syncer struct {
+	mu           sync.Mutex
+	now          func() time.Time
+	lastPush     time.Time // last successful push, or start
+	commits      uint64
+	pushFailures map[string]uint64 // by git.ErrorClass
+	pullFailures map[string]uint64
+	durations    map[string]*histogram // by op
+	pending      bool
+}
+
+// NewSyncer creates an empty set of syncer metrics. Until the first
+// successful push, the time since the last push counts from now.
+func NewSyncer() *Syncer {
+	m := &Syncer{
+		now:          time.Now,
+		pushFailures: make(map[string]uint64),
+		pullFailures: make(map[string]uint64),
+		durations:    make(map[string]*histogram),
+	}
+	m.lastPush = m.now()
+	for _, op := range syncOps {
+		m.durations[op] = newHistogram(durationBuckets)
+	}
+	return m
+}
+
+func (m *Syncer) ObserveCommit(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.commits++
+	m.durations["commit"].observe(d.Seconds())
+}
+
+func (m *Syncer) ObservePull(d time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.durations["pull"].observe(d.Seconds())
+	if err != nil {
+		m.pullFailures[git.ErrorClass(err)]++
+	}
+}
+
+func (m *Syncer) ObservePush(d time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.durations["push"].observe(d.Seconds())
+	if err != nil {
+		m.pushFailures[git.ErrorClass(err)]++
+	} else {
+		m.lastPush = m.now()
+	}
+}
+
+func (m *Syncer) SetPending(pending bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pending = pending
+}
+
+func (m *Syncer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
+
+// WriteTo writes the metrics in the Prometheus text format.
+func (m *Syncer) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p := &printer{w: w}
+	p.header("git3_commits_total", "counter", "Commits created by the syncer.")
+	p.sample("git3_commits_total", "", float64(m.commits))
+
+	p.header("git3_push_failures_total", "counter", "Failed pushes by error class.")
+	for _, class := range sortedKeys(m.pushFailures) {
+		p.sample("git3_push_failures_total", label("class", class), float64(m.pushFailures[class]))
+	}
+	p.header("git3_pull_failures_total", "counter", "Failed pulls by error class.")
+	for _, class := range sortedKeys(m.pullFailures) {
+		p.sample("git3_pull_failures_total", label("class", class), float64(m.pullFailures[class]))
+	}
+
+	p.header("git3_sync_duration_seconds", "histogram", "Duration of commits, pulls and pushes.")
+	for _, op := range syncOps {
+		m.durations[op].write(p, "git3_sync_duration_seconds", label("op", op))
+	}
+
+	p.header("git3_seconds_since_last_push", "gauge", "Seconds since the last successful push, or since start if there was none.")
+	p.sample("git3_seconds_since_last_push", "", m.now().Sub(m.lastPush).Seconds())
+
+	p.header("git3_sync_pending", "gauge", "1 while a triggered sync is waiting to run.")
+	pending := 0.0
+	if m.pending {
+		pending = 1
+	}
+	p.sample("git3_sync_pending", "", pending)
+	return p.n, p.err
+}
+
+type histogram struct {
+	bounds []float64
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds))}
+}
+
+func (h *histogram) observe(v float64) {
+	h.sum += v
+	h.count++
+	for i, b := range h.bounds {
+		if v <= b {
+			h.counts[i]++
+			return
+		}
+	}
+}
+
+func (h *histogram) write(p *printer, name, labels string) {
+	var cumulative uint64
+	for i, b := range h.bounds {
+		cumulative += h.counts[i]
+		p.sample(name+"_bucket", labels+","+label("le", fmt.Sprint(b)), float64(cumulative))
+	}
+	p.sample(name+"_bucket", labels+","+label("le", "+Inf"), float64(h.count))
+	p.sample(name+"_sum", labels, h.sum)
+	p.sample(name+"_count", labels, float64(h.count))
+}
+
+// printer writes exposition lines, remembering the first error.
+type printer struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (p *printer) header(name, typ, help string) {
+	p.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func (p *printer) sample(name, labels string, v float64) {
+	if labels != "" {
+		name += "{" + labels + "}"
+	}
+	p.printf("%s %g\n", name, v)
+}
+
+func (p *printer) printf(format string, args ...any) {
+	if p.err != nil {
+		return
+	}
+	n, err := fmt.Fprintf(p.w, format, args...)
+	p.n += int64(n)
+	p.err = err
+}
+
+func label(name, value string) string {
+	return fmt.Sprintf("%s=%q", name, value)
+}
+
+func sortedKeys(m map[string]uint64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,61 @@
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+func TestSyncerMetrics(t *testing.T) {
+	m := NewSyncer()
+	now := time.Now()
+	m.now = func() time.Time { return now }
+	m.lastPush = now
+
+	m.ObserveCommit(20 * time.Millisecond)
+	m.ObserveCommit(3 * time.Second)
+	m.ObservePush(time.Second, fmt.Errorf("push failed: %w", transport.ErrAuthorizationFailed))
+	m.ObservePush(time.Second, errors.New("boom"))
+	m.ObservePull(time.Second, nil)
+	m.SetPending(true)
+	now = now.Add(90 * time.Second)
+
+	var out strings.Builder
+	m.WriteTo(&out)
+	for _, want := range []string{
+		"git3_commits_total 2\n",
+		`git3_push_failures_total{class="auth"} 1` + "\n",
+		`git3_push_failures_total{class="other"} 1` + "\n",
+		`git3_sync_duration_seconds_bucket{op="commit",le="0.05"} 1` + "\n",
+		`git3_sync_duration_seconds_bucket{op="commit",le="5"} 2` + "\n",
+		`git3_sync_duration_seconds_count{op="push"} 2` + "\n",
+		`git3_sync_duration_seconds_bucket{op="pull",le="+Inf"} 1` + "\n",
+		"git3_seconds_since_last_push 90\n",
+		"git3_sync_pending 1\n",
+		"# TYPE git3_sync_duration_seconds histogram\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("missing %q in:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestSecondsSinceLastPushResets(t *testing.T) {
+	m := NewSyncer()
+	now := time.Now()
+	m.now = func() time.Time { return now }
+
+	now = now.Add(time.Hour)
+	m.ObservePush(time.Second, nil)
+	now = now.Add(5 * time.Second)
+
+	var out strings.Builder
+	m.WriteTo(&out)
+	if !strings.Contains(out.String(), "git3_seconds_since_last_push 5\n") {
+		t.Fatalf("successful push did not reset the gauge:\n%s", out.String())
+	}
+}
@@ -0,0 +1,62 @@
+package s3
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"os"
+)
+
+// xsiNamespace qualifies the Grantee type attribute.
+const xsiNamespace = "http://www.w3.org/2001/XMLSchema-instance"
+
+// acl answers GET and PUT on the acl subresource of the bucket, or of an
+// object when key is set. git3 has no ACLs: everyone with the credentials
+// has full control, so GET returns that as a canned policy and PUT is
+// accepted and ignored. This is only so clients that check ACLs during
+// setup carry on.
+func (s *Handler) acl(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	if bucket != s.bucket {
+		s.xmlError(w, http.StatusNotFound, "NoSuchBucket", "Bucket not found")
+		return
+	}
+	if key != "" {
+		fullPath, ok := s.objectPath(key)
+		if !ok {
+			s.xmlError(w, http.StatusBadRequest, "InvalidArgument", "Invalid object key")
+			return
+		}
+		if _, err := os.Stat(fullPath); err != nil {
+			s.xmlError(w, http.StatusNotFound, "NoSuchKey", "Object not found")
+			return
+		}
+	}
+
+	if r.Method == "PUT" {
+		if s.readOnly.Load() {
+			s.xmlError(w, http.StatusForbidden, "AccessDenied", "Bucket is read-only")
+			return
+		}
+		io.Copy(io.Discard, r.Body)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	owner := Owner{ID: s.owner, DisplayName: s.owner}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, xml.Header)
+	xml.NewEncoder(w).Encode(AccessControlPolicy{
+		Xmlns: s3Namespace,
+		Owner: owner,
+		Grants: []Grant{{
+			Grantee: Grantee{
+				XmlnsXsi:    xsiNamespace,
+				Type:        "CanonicalUser",
+				ID:          owner.ID,
+				DisplayName: owner.DisplayName,
+			},
+			Permission: "FULL_CONTROL",
+		}},
+	})
+}
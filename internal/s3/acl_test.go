@@ -0,0 +1,43 @@
+package s3
+
+import (
+	"encoding/xml"
+	"net/http"
+	"testing"
+)
+
+func TestACL(t *testing.T) {
+	h := NewHandlerWithOptions(t.TempDir(), WithOwner("alice"))
+	serve(h, "PUT", "/vault/a.md", "hello")
+
+	for _, target := range []string{"/vault?acl", "/vault/a.md?acl"} {
+		w := serve(h, "GET", target, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %s got %d: %s", target, w.Code, w.Body)
+		}
+		var policy AccessControlPolicy
+		if err := xml.Unmarshal(w.Body.Bytes(), &policy); err != nil {
+			t.Fatalf("GET %s returned malformed XML: %v\n%s", target, err, w.Body)
+		}
+		if policy.Owner.ID != "alice" || len(policy.Grants) != 1 ||
+			policy.Grants[0].Permission != "FULL_CONTROL" || policy.Grants[0].Grantee.ID != "alice" {
+			t.Fatalf("GET %s = %+v", target, policy)
+		}
+	}
+
+	if w := serve(h, "PUT", "/vault/a.md?acl", "<AccessControlPolicy/>"); w.Code != http.StatusOK {
+		t.Fatalf("PUT ?acl got %d", w.Code)
+	}
+	if w := serve(h, "GET", "/vault/a.md", ""); w.Body.String() != "hello" {
+		t.Fatalf("PUT ?acl changed the object to %q", w.Body)
+	}
+	if w := serve(h, "PUT", "/vault?acl", ""); w.Code != http.StatusOK {
+		t.Fatalf("PUT bucket ?acl got %d", w.Code)
+	}
+	if w := serve(h, "GET", "/vault/missing.md?acl", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("GET ?acl of a missing object got %d", w.Code)
+	}
+	if w := serve(h, "GET", "/other?acl", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("GET ?acl of another bucket got %d", w.Code)
+	}
+}
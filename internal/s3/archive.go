@@ -0,0 +1,169 @@
+package s3
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// archiveQuery is the query parameter asking a bucket GET for an archive
+// of every object, optionally under prefix, as archiveTarGz, archiveTar or
+// archiveZip.
+// It is an extension to S3, for backups and snapshots, which would
+// otherwise take a listing and a GET per object.
+const archiveQuery = "archive"
+
+// Archive formats, the values archiveQuery takes.
+const (
+	archiveTarGz = "tar.gz"
+	archiveTar   = "tar"
+	archiveZip   = "zip"
+)
+
+// archiveWriter adds files to an archive being streamed.
+type archiveWriter interface {
+	add(key string, size int64, modified time.Time, content io.Reader) error
+	Close() error
+}
+
+type tarWriter struct {
+	tw *tar.Writer
+	gz *gzip.Writer // nil for an uncompressed tar
+}
+
+func newTarWriter(w io.Writer, compress bool) *tarWriter {
+	if !compress {
+		return &tarWriter{tw: tar.NewWriter(w)}
+	}
+	gz := gzip.NewWriter(w)
+	return &tarWriter{tw: tar.NewWriter(gz), gz: gz}
+}
+
+func (a *tarWriter) add(key string, size int64, modified time.Time, content io.Reader) error {
+	hdr := &tar.Header{Typeflag: tar.TypeReg, Name: key, Size: size, Mode: 0644, ModTime: modified, Format: tar.FormatPAX}
+	if err := a.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := io.CopyN(a.tw, content, size)
+	return err
+}
+
+func (a *tarWriter) Close() error {
+	if err := a.tw.Close(); err != nil || a.gz == nil {
+		return err
+	}
+	return a.gz.Close()
+}
+
+type zipWriter struct {
+	zw *zip.Writer
+}
+
+func (a zipWriter) add(key string, size int64, modified time.Time, content io.Reader) error {
+	f, err := a.zw.CreateHeader(&zip.FileHeader{Name: key, Method: zip.Deflate, Modified: modified})
+	if err != nil {
+		return err
+	}
+	_, err = io.CopyN(f, content, size)
+	return err
+}
+
+func (a zipWriter) Close() error {
+	return a.zw.Close()
+}
+
+// getArchive answers GET /{bucket}?archive=tar.gz, tar or zip with an
+// archive of the objects under the prefix parameter, built as it is sent.
+// Objects encrypted with a customer key are left out, since the request
+// can only carry one key. An error once streaming has begun can't change
+// the status, so it is logged and the archive cut short, which clients
+// notice as a truncated file.
+func (s *Handler) getArchive(w http.ResponseWriter, r *http.Request, bucket string) {
+	if bucket != s.bucket {
+		s.xmlError(w, http.StatusNotFound, "NoSuchBucket", "Bucket not found")
+		return
+	}
+	format := r.URL.Query().Get(archiveQuery)
+	var contentType string
+	switch format {
+	case archiveTarGz:
+		contentType = "application/gzip"
+	case archiveTar:
+		contentType = "application/x-tar"
+	case archiveZip:
+		contentType = "application/zip"
+	default:
+		s.xmlError(w, http.StatusBadRequest, "InvalidArgument", fmt.Sprintf("archive must be %s, %s or %s", archiveTarGz, archiveTar, archiveZip))
+		return
+	}
+	prefix := r.URL.Query().Get("prefix")
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", s.archiveName(prefix, format)))
+	w.Header().Set("Cache-Control", "no-store")
+
+	var archive archiveWriter
+	if format == archiveZip {
+		archive = zipWriter{zip.NewWriter(w)}
+	} else {
+		archive = newTarWriter(w, format == archiveTarGz)
+	}
+	var objects, skipped int
+	err := s.walkKeys(prefix, "", 0, func(key string, info fs.FileInfo) error {
+		f, err := s.openContent(s.keyFile(key), nil)
+		switch {
+		case errors.Is(err, errSSECRequired):
+			skipped++
+			return nil
+		case errors.Is(err, os.ErrNotExist):
+			return nil // deleted since the walk listed it
+		case err != nil:
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		defer f.Close()
+		// The size of what was opened, which a concurrent PUT can't change
+		size, err := f.Seek(0, io.SeekEnd)
+		if err == nil {
+			_, err = f.Seek(0, io.SeekStart)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		objects++
+		return archive.add(key, size, lastModified(info), f)
+	}, nil)
+	if err == nil {
+		err = archive.Close()
+	}
+	if err != nil {
+		s.logger.Printf("[s3] archive of %q cut short after %d objects: %v", prefix, objects, err)
+		return
+	}
+	if skipped > 0 {
+		s.logger.Printf("[s3] archive of %q left out %d objects encrypted with a customer key", prefix, skipped)
+	}
+}
+
+// archiveName is the file name an archive is offered as: the bucket and
+// the prefix, if any, with the time, as in vault-notes-20250101-120000.zip.
+func (s *Handler) archiveName(prefix, format string) string {
+	name := s.bucket
+	if p := strings.Trim(prefix, "/"); p != "" {
+		name += "-" + strings.Map(func(r rune) rune {
+			if r == '/' || r == '"' || r == '\\' || unicode.IsControl(r) {
+				return '-'
+			}
+			return r
+		}, p)
+	}
+	return fmt.Sprintf("%s-%s.%s", name, time.Now().UTC().Format("20060102-150405"), format)
+}
@@ -0,0 +1,123 @@
+package s3
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"regexp"
+	"testing"
+)
+
+func readTarGz(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := io.ReadAll(tr)
+		files[hdr.Name] = string(content)
+	}
+}
+
+func TestArchive(t *testing.T) {
+	h, _ := newTestHandler(t)
+	serve(h, "PUT", "/vault/notes/a.md", "hello")
+	serve(h, "PUT", "/vault/notes/sub/b.md", "hi")
+	serve(h, "PUT", "/vault/c.md", "top")
+
+	w := serve(h, "GET", "/vault?archive=tar.gz", "")
+	if w.Code != 200 || w.Header().Get("Content-Type") != "application/gzip" {
+		t.Fatalf("archive = %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	if cd := w.Header().Get("Content-Disposition"); !regexp.MustCompile(`^attachment; filename="vault-\d{8}-\d{6}\.tar\.gz"$`).MatchString(cd) {
+		t.Fatalf("Content-Disposition = %q", cd)
+	}
+	files := readTarGz(t, w.Body.Bytes())
+	if len(files) != 3 || files["notes/a.md"] != "hello" || files["notes/sub/b.md"] != "hi" || files["c.md"] != "top" {
+		t.Fatalf("archive holds %v", files)
+	}
+
+	w = serve(h, "GET", "/vault?archive=tar", "")
+	tr := tar.NewReader(w.Body)
+	for _, want := range []string{"c.md", "notes/a.md", "notes/sub/b.md"} {
+		if hdr, err := tr.Next(); err != nil || hdr.Name != want {
+			t.Fatalf("uncompressed tar entry = %v, %v; want %s", hdr, err, want)
+		}
+	}
+
+	if w := serve(h, "GET", "/vault?archive=rar", ""); w.Code != 400 {
+		t.Fatalf("unknown format = %d", w.Code)
+	}
+}
+
+func TestArchiveDecrypts(t *testing.T) {
+	h := NewHandlerWithOptions(t.TempDir(), WithEncryption(newTestCipher(t, 1)))
+	serve(h, "PUT", "/vault/secret.md", "plaintext")
+
+	files := readTarGz(t, serve(h, "GET", "/vault?archive=tar.gz", "").Body.Bytes())
+	if files["secret.md"] != "plaintext" {
+		t.Fatalf("archive holds %q, want the decrypted content", files["secret.md"])
+	}
+}
+
+func TestArchiveZipOfPrefix(t *testing.T) {
+	h, _ := newTestHandler(t)
+	want := map[string]string{"notes/a.md": "hello", "notes/sub/b.md": "hi there", "notes/empty.md": ""}
+	for key, content := range want {
+		serve(h, "PUT", "/vault/"+key, content)
+	}
+	serve(h, "PUT", "/vault/notesbook.md", "not under notes/")
+	serve(h, "PUT", "/vault/other/c.md", "elsewhere")
+
+	w := serve(h, "GET", "/vault?prefix=notes/&archive=zip", "")
+	if w.Code != 200 || w.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("archive = %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	if cd := w.Header().Get("Content-Disposition"); !regexp.MustCompile(`^attachment; filename="vault-notes-\d{8}-\d{6}\.zip"$`).MatchString(cd) {
+		t.Fatalf("Content-Disposition = %q", cd)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zr.File) != len(want) {
+		t.Fatalf("zip has %d entries, want %d", len(zr.File), len(want))
+	}
+	for _, f := range zr.File {
+		content, ok := want[f.Name]
+		if !ok {
+			t.Fatalf("unexpected entry %s", f.Name)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := io.ReadAll(rc)
+		rc.Close()
+		if string(got) != content || f.UncompressedSize64 != uint64(len(content)) {
+			t.Fatalf("%s = %q (%d bytes), want %q", f.Name, got, f.UncompressedSize64, content)
+		}
+	}
+}
+
+func TestArchiveHashedLayout(t *testing.T) {
+	h := NewHandlerWithOptions(t.TempDir(), WithHashedLayout())
+	serve(h, "PUT", "/vault/notes/a.md", "hello")
+
+	// Entries are named by key, without the files that record them
+	files := readTarGz(t, serve(h, "GET", "/vault?archive=tar.gz", "").Body.Bytes())
+	if len(files) != 1 || files["notes/a.md"] != "hello" {
+		t.Fatalf("archive holds %v", files)
+	}
+}
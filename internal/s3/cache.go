@@ -0,0 +1,111 @@
+package s3
+
+import (
+	"container/list"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// objectCache keeps the content of small objects in memory, so hot GETs
+// skip opening and reading the file. An entry is only used while the
+// file's modification time and size still match those it was read with,
+// so changes made behind the handler's back (a pull, say) are never
+// served stale. A nil *objectCache caches nothing.
+type objectCache struct {
+	maxEntry int64 // largest content cached
+	maxTotal int64 // content cached in all, beyond which the least recently used goes
+
+	mu      sync.Mutex
+	total   int64
+	order   *list.List // of *cacheEntry, most recently used first
+	entries map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key     string
+	modTime time.Time
+	size    int64 // the file's size, as stored
+	data    []byte
+}
+
+func newObjectCache(maxEntry, maxTotal int64) *objectCache {
+	if maxEntry <= 0 || maxTotal <= 0 {
+		return nil
+	}
+	return &objectCache{
+		maxEntry: min(maxEntry, maxTotal),
+		maxTotal: maxTotal,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+// get returns key's content if it is cached and info, the file's current
+// stat, shows it unchanged since.
+func (c *objectCache) get(key string, info os.FileInfo) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*cacheEntry)
+	if !e.modTime.Equal(info.ModTime()) || e.size != info.Size() {
+		c.removeLocked(el)
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return e.data, true
+}
+
+// read reads the content r holds for key, whose file info describes, and
+// caches it if it is small enough. It returns nil, with r back at the
+// start, if it isn't.
+func (c *objectCache) read(key string, info os.FileInfo, r io.ReadSeeker) ([]byte, error) {
+	if c == nil {
+		return nil, nil
+	}
+	data, err := io.ReadAll(io.LimitReader(r, c.maxEntry+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > c.maxEntry {
+		_, err := r.Seek(0, io.SeekStart)
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.removeLocked(el)
+	}
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, modTime: info.ModTime(), size: info.Size(), data: data})
+	c.total += int64(len(data))
+	for c.total > c.maxTotal {
+		c.removeLocked(c.order.Back())
+	}
+	return data, nil
+}
+
+// remove drops key's entry, for an object written or deleted.
+func (c *objectCache) remove(key string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.removeLocked(el)
+	}
+}
+
+func (c *objectCache) removeLocked(el *list.Element) {
+	e := c.order.Remove(el).(*cacheEntry)
+	delete(c.entries, e.key)
+	c.total -= int64(len(e.data))
+}
@@ -0,0 +1,69 @@
+package s3
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// countingFS counts the files opened through it.
+type countingFS struct {
+	osFS
+	opens int
+}
+
+func (f *countingFS) Open(name string) (*os.File, error) {
+	f.opens++
+	return f.osFS.Open(name)
+}
+
+func TestCacheServesRepeatedGets(t *testing.T) {
+	dir := t.TempDir()
+	h := NewHandlerWithOptions(dir, WithCache(16, 64))
+	files := &countingFS{}
+	h.files = files
+
+	serve(h, "PUT", "/vault/a.md", "hello")
+	for i := 0; i < 3; i++ {
+		if w := serve(h, "GET", "/vault/a.md", ""); w.Code != 200 || w.Body.String() != "hello" {
+			t.Fatalf("GET %d = %d %q", i, w.Code, w.Body.String())
+		}
+	}
+	if files.opens != 1 {
+		t.Fatalf("file opened %d times for three GETs, want 1", files.opens)
+	}
+	if w := serve(h, "HEAD", "/vault/a.md", ""); w.Header().Get("Content-Length") != "5" || files.opens != 1 {
+		t.Fatalf("HEAD: Content-Length %q after %d opens", w.Header().Get("Content-Length"), files.opens)
+	}
+
+	// A PUT drops the entry, and so does a change behind the handler's back
+	serve(h, "PUT", "/vault/a.md", "hello again")
+	if w := serve(h, "GET", "/vault/a.md", ""); w.Body.String() != "hello again" || files.opens != 2 {
+		t.Fatalf("GET after PUT = %q after %d opens", w.Body.String(), files.opens)
+	}
+	os.WriteFile(filepath.Join(dir, "a.md"), []byte("pulled"), 0644)
+	os.Chtimes(filepath.Join(dir, "a.md"), time.Now(), time.Now().Add(time.Minute))
+	if w := serve(h, "GET", "/vault/a.md", ""); w.Body.String() != "pulled" {
+		t.Fatalf("GET after the file changed = %q", w.Body.String())
+	}
+	serve(h, "DELETE", "/vault/a.md", "")
+	if _, ok := h.cache.entries["a.md"]; ok {
+		t.Fatal("deleted object still cached")
+	}
+
+	// Objects over the entry limit aren't cached, and the least recently
+	// read go once the total is reached
+	serve(h, "PUT", "/vault/big.md", "more than sixteen bytes")
+	serve(h, "GET", "/vault/big.md", "")
+	for _, key := range []string{"1", "2", "3", "4", "5"} {
+		serve(h, "PUT", "/vault/"+key, "0123456789abcdef")
+		serve(h, "GET", "/vault/"+key, "")
+	}
+	if _, ok := h.cache.entries["big.md"]; ok {
+		t.Error("object over the entry limit cached")
+	}
+	if _, ok := h.cache.entries["1"]; ok || len(h.cache.entries) != 4 || h.cache.total != 64 {
+		t.Errorf("cache holds %d entries, %d bytes; want the 4 most recent", len(h.cache.entries), h.cache.total)
+	}
+}
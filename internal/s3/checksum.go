@@ -0,0 +1,114 @@
+package s3
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"hash"
+	"hash/crc32"
+	"net/http"
+	"strings"
+)
+
+// checksumModeHeader asks GET and HEAD to return the object's checksum.
+const checksumModeHeader = "X-Amz-Checksum-Mode"
+
+// checksumAlgorithms are the additional checksums a PUT can declare, by
+// their S3 names.
+var checksumAlgorithms = map[string]func() hash.Hash{
+	"CRC32":  func() hash.Hash { return crc32.NewIEEE() },
+	"CRC32C": func() hash.Hash { return crc32.New(crc32.MakeTable(crc32.Castagnoli)) },
+	"SHA1":   sha1.New,
+	"SHA256": sha256.New,
+}
+
+// checksumHeader names the header, or trailer, carrying a checksum.
+func checksumHeader(algorithm string) string {
+	return http.CanonicalHeaderKey("x-amz-checksum-" + strings.ToLower(algorithm))
+}
+
+// checksum is the additional checksum of an upload. Its value is in the
+// request headers, in the trailers after the body, or, with only
+// x-amz-sdk-checksum-algorithm set, left for the handler to compute.
+type checksum struct {
+	algorithm string
+	expected  string // empty unless sent as a header
+	trailer   bool
+	hash      hash.Hash
+}
+
+// parseChecksum reads the checksum a PUT declares, returning nil if there
+// is none, or the S3 error code and message for a malformed one.
+func parseChecksum(h http.Header) (ck *checksum, code, message string) {
+	trailers := make(map[string]bool)
+	for _, name := range strings.Split(h.Get("X-Amz-Trailer"), ",") {
+		trailers[http.CanonicalHeaderKey(strings.TrimSpace(name))] = true
+	}
+	for algorithm, newHash := range checksumAlgorithms {
+		name := checksumHeader(algorithm)
+		value := h.Get(name)
+		if value == "" && !trailers[name] {
+			continue
+		}
+		if ck != nil {
+			return nil, "InvalidRequest", "Expecting a single x-amz-checksum- header"
+		}
+		ck = &checksum{algorithm: algorithm, expected: value, trailer: value == "", hash: newHash()}
+		if value != "" && !ck.valid(value) {
+			return nil, "InvalidRequest", "Value for " + strings.ToLower(name) + " header is invalid"
+		}
+	}
+	if ck != nil {
+		return ck, "", ""
+	}
+
+	if algorithm := strings.ToUpper(h.Get("X-Amz-Sdk-Checksum-Algorithm")); algorithm != "" {
+		newHash, ok := checksumAlgorithms[algorithm]
+		if !ok {
+			return nil, "InvalidRequest", "Checksum algorithm " + algorithm + " is not supported"
+		}
+		return &checksum{algorithm: algorithm, hash: newHash()}, "", ""
+	}
+	return nil, "", ""
+}
+
+// valid reports whether value could be a checksum of this algorithm.
+func (c *checksum) valid(value string) bool {
+	raw, err := base64.StdEncoding.DecodeString(value)
+	return err == nil && len(raw) == c.hash.Size()
+}
+
+// value returns the checksum of the bytes written to hash, as S3 encodes
+// it: the big-endian digest in base64.
+func (c *checksum) value() string {
+	return base64.StdEncoding.EncodeToString(c.hash.Sum(nil))
+}
+
+// verify compares the computed checksum with the declared one, taken from
+// trailers if it wasn't a header. It returns the S3 error code and message
+// for a mismatch.
+func (c *checksum) verify(trailers http.Header) (code, message string, ok bool) {
+	expected := c.expected
+	if c.trailer {
+		expected = trailers.Get(checksumHeader(c.algorithm))
+		if expected == "" {
+			return "InvalidRequest", "The " + strings.ToLower(checksumHeader(c.algorithm)) + " trailer is missing", false
+		}
+	}
+	if expected != "" && expected != c.value() {
+		return "BadDigest", "The " + c.algorithm + " you specified did not match the calculated checksum", false
+	}
+	return "", "", true
+}
+
+// setChecksumHeader returns the stored checksum of an object when the
+// request enables checksum mode. Range requests get none, since it covers
+// the whole object.
+func (s *Handler) setChecksumHeader(w http.ResponseWriter, r *http.Request, meta objectMeta) {
+	if !strings.EqualFold(r.Header.Get(checksumModeHeader), "ENABLED") || r.Header.Get("Range") != "" {
+		return
+	}
+	if meta.Checksum != "" {
+		w.Header().Set(checksumHeader(meta.ChecksumAlgorithm), meta.Checksum)
+	}
+}
@@ -0,0 +1,164 @@
+package s3
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func checksumOf(h hash.Hash, body string) string {
+	h.Write([]byte(body))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+func TestChecksums(t *testing.T) {
+	body := "checksummed content"
+	for algorithm, sum := range map[string]hash.Hash{
+		"CRC32":  crc32.NewIEEE(),
+		"CRC32C": crc32.New(crc32.MakeTable(crc32.Castagnoli)),
+		"SHA1":   sha1.New(),
+		"SHA256": sha256.New(),
+	} {
+		t.Run(algorithm, func(t *testing.T) {
+			h := NewHandlerWithOptions(t.TempDir())
+			header := checksumHeader(algorithm)
+			want := checksumOf(sum, body)
+
+			req := httptest.NewRequest("PUT", "/vault/a.md", strings.NewReader(body))
+			req.Header.Set(header, want)
+			put := serveRequest(h, req)
+			if put.Code != http.StatusOK || put.Header().Get(header) != want {
+				t.Fatalf("PUT got %d, %s %q: %s", put.Code, header, put.Header().Get(header), put.Body)
+			}
+
+			for _, method := range []string{"GET", "HEAD"} {
+				req := httptest.NewRequest(method, "/vault/a.md", nil)
+				req.Header.Set(checksumModeHeader, "ENABLED")
+				if got := serveRequest(h, req).Header().Get(header); got != want {
+					t.Fatalf("%s with checksum mode returned %s %q, want %q", method, header, got, want)
+				}
+			}
+			if got := serve(h, "GET", "/vault/a.md", "").Header().Get(header); got != "" {
+				t.Fatalf("GET without checksum mode returned %s %q", header, got)
+			}
+		})
+	}
+}
+
+func TestChecksumMismatch(t *testing.T) {
+	dir := t.TempDir()
+	h := NewHandlerWithOptions(dir)
+
+	req := httptest.NewRequest("PUT", "/vault/a.md", strings.NewReader("actual"))
+	req.Header.Set("X-Amz-Checksum-Sha256", checksumOf(sha256.New(), "declared"))
+	w := serveRequest(h, req)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "BadDigest") {
+		t.Fatalf("PUT with a wrong checksum got %d: %s", w.Code, w.Body)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a.md")); !os.IsNotExist(err) {
+		t.Fatal("a rejected PUT wrote the object")
+	}
+
+	req = httptest.NewRequest("PUT", "/vault/a.md", strings.NewReader("actual"))
+	req.Header.Set("X-Amz-Checksum-Crc32", "not a checksum")
+	if w := serveRequest(h, req); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "InvalidRequest") {
+		t.Fatalf("PUT with a malformed checksum got %d: %s", w.Code, w.Body)
+	}
+
+	req = httptest.NewRequest("PUT", "/vault/a.md", strings.NewReader("actual"))
+	req.Header.Set("X-Amz-Checksum-Crc32", checksumOf(crc32.NewIEEE(), "actual"))
+	req.Header.Set("X-Amz-Checksum-Sha1", checksumOf(sha1.New(), "actual"))
+	if w := serveRequest(h, req); w.Code != http.StatusBadRequest {
+		t.Fatalf("PUT with two checksums got %d, want 400", w.Code)
+	}
+}
+
+// awsChunked encodes body as aws-chunked with the given trailer.
+func awsChunked(body string, chunkSize int, trailer string) string {
+	var b strings.Builder
+	for len(body) > 0 {
+		n := min(chunkSize, len(body))
+		fmt.Fprintf(&b, "%x;chunk-signature=%064d\r\n%s\r\n", n, 0, body[:n])
+		body = body[n:]
+	}
+	b.WriteString("0\r\n" + trailer + "\r\n\r\n")
+	return b.String()
+}
+
+func TestChecksumTrailer(t *testing.T) {
+	h := NewHandlerWithOptions(t.TempDir())
+	body := "streamed with a trailing checksum"
+	want := checksumOf(crc32.New(crc32.MakeTable(crc32.Castagnoli)), body)
+
+	put := func(trailer string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", "/vault/a.md", strings.NewReader(awsChunked(body, 8, trailer)))
+		req.Header.Set("Content-Encoding", "aws-chunked")
+		req.Header.Set("X-Amz-Content-Sha256", "STREAMING-UNSIGNED-PAYLOAD-TRAILER")
+		req.Header.Set("X-Amz-Decoded-Content-Length", fmt.Sprint(len(body)))
+		req.Header.Set("X-Amz-Trailer", "x-amz-checksum-crc32c")
+		return serveRequest(h, req)
+	}
+
+	if w := put("x-amz-checksum-crc32c:" + checksumOf(sha1.New(), "other")); w.Code != http.StatusBadRequest {
+		t.Fatalf("PUT with a wrong trailer got %d, want 400", w.Code)
+	}
+	if w := put("x-amz-checksum-crc32c:" + want); w.Code != http.StatusOK {
+		t.Fatalf("PUT got %d: %s", w.Code, w.Body)
+	}
+	req := httptest.NewRequest("GET", "/vault/a.md", nil)
+	req.Header.Set(checksumModeHeader, "ENABLED")
+	w := serveRequest(h, req)
+	if w.Body.String() != body || w.Header().Get("X-Amz-Checksum-Crc32c") != want {
+		t.Fatalf("GET = %q with checksum %q", w.Body, w.Header().Get("X-Amz-Checksum-Crc32c"))
+	}
+}
+
+func TestChecksumStale(t *testing.T) {
+	dir := t.TempDir()
+	h := NewHandlerWithOptions(dir)
+	checksummed := func(body string) *http.Request {
+		req := httptest.NewRequest("PUT", "/vault/a.md", strings.NewReader(body))
+		req.Header.Set("X-Amz-Sdk-Checksum-Algorithm", "SHA256")
+		return req
+	}
+	head := func() string {
+		req := httptest.NewRequest("HEAD", "/vault/a.md", nil)
+		req.Header.Set(checksumModeHeader, "ENABLED")
+		return serveRequest(h, req).Header().Get("X-Amz-Checksum-Sha256")
+	}
+
+	// With only the algorithm named, the handler computes the checksum
+	serveRequest(h, checksummed("one"))
+	if got := head(); got != checksumOf(sha256.New(), "one") {
+		t.Fatalf("computed checksum = %q", got)
+	}
+
+	// Changed outside the handler, as by a pull
+	os.WriteFile(filepath.Join(dir, "a.md"), []byte("two"), 0644)
+	os.Chtimes(filepath.Join(dir, "a.md"), time.Now().Add(time.Minute), time.Now().Add(time.Minute))
+	if got := head(); got != "" {
+		t.Fatalf("stale checksum %q returned", got)
+	}
+
+	serveRequest(h, checksummed("three"))
+	serve(h, "PUT", "/vault/a.md", "four")
+	if got := head(); got != "" {
+		t.Fatalf("PUT without a checksum kept %q", got)
+	}
+
+	serveRequest(h, checksummed("five"))
+	serve(h, "DELETE", "/vault/a.md", "")
+	if _, err := os.Stat(h.meta.path("a.md")); !os.IsNotExist(err) {
+		t.Fatal("DELETE left the metadata behind")
+	}
+}
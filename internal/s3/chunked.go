@@ -0,0 +1,128 @@
+package s3
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// maxChunkLine bounds a chunk header or trailer line, which is only a size,
+// a signature and a checksum long.
+const maxChunkLine = 4 << 10
+
+var errBadChunk = errors.New("malformed aws-chunked body")
+
+// isAWSChunked reports whether r's body uses the aws-chunked encoding that
+// SDKs use to stream uploads and send checksums as trailers.
+func isAWSChunked(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") ||
+		strings.Contains(r.Header.Get("Content-Encoding"), "aws-chunked")
+}
+
+// chunkedReader decodes an aws-chunked body:
+//
+//	<hex size>[;chunk-signature=<sig>]\r\n<data>\r\n ... 0[;...]\r\n<trailers>\r\n
+//
+// Chunk signatures are not checked, like the payload hash of other
+// uploads. Trailers are available once Read has returned io.EOF.
+type chunkedReader struct {
+	r        *bufio.Reader
+	left     int64 // bytes left in the current chunk
+	done     bool
+	trailers http.Header
+}
+
+func newChunkedReader(r io.Reader) *chunkedReader {
+	return &chunkedReader{r: bufio.NewReader(r), trailers: make(http.Header)}
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	for c.left == 0 {
+		if c.done {
+			return 0, io.EOF
+		}
+		if err := c.nextChunk(); err != nil {
+			return 0, err
+		}
+	}
+	if int64(len(p)) > c.left {
+		p = p[:c.left]
+	}
+	n, err := c.r.Read(p)
+	c.left -= int64(n)
+	if c.left == 0 && err == nil {
+		err = c.expectCRLF()
+	}
+	if errors.Is(err, io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// nextChunk reads a chunk header, and the trailers after the last chunk.
+func (c *chunkedReader) nextChunk() error {
+	line, err := c.line()
+	if err != nil {
+		return err
+	}
+	sizeField, _, _ := strings.Cut(line, ";")
+	size, err := strconv.ParseInt(strings.TrimSpace(sizeField), 16, 64)
+	if err != nil || size < 0 {
+		return fmt.Errorf("%w: chunk size %q", errBadChunk, sizeField)
+	}
+	if size > 0 {
+		c.left = size
+		return nil
+	}
+
+	c.done = true
+	for {
+		line, err := c.line()
+		if errors.Is(err, io.ErrUnexpectedEOF) && line == "" {
+			return nil // some clients end without the blank line
+		}
+		if err != nil || line == "" {
+			return err
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return fmt.Errorf("%w: trailer %q", errBadChunk, line)
+		}
+		c.trailers.Add(textproto.TrimString(name), textproto.TrimString(value))
+	}
+}
+
+func (c *chunkedReader) line() (string, error) {
+	var b strings.Builder
+	for {
+		part, isPrefix, err := c.r.ReadLine()
+		if err == io.EOF {
+			return b.String(), io.ErrUnexpectedEOF
+		} else if err != nil {
+			return "", err
+		}
+		b.Write(part)
+		if b.Len() > maxChunkLine {
+			return "", fmt.Errorf("%w: line too long", errBadChunk)
+		}
+		if !isPrefix {
+			return b.String(), nil
+		}
+	}
+}
+
+func (c *chunkedReader) expectCRLF() error {
+	var crlf [2]byte
+	if _, err := io.ReadFull(c.r, crlf[:]); err != nil {
+		return err
+	}
+	if string(crlf[:]) != "\r\n" {
+		return fmt.Errorf("%w: missing CRLF after chunk", errBadChunk)
+	}
+	return nil
+}
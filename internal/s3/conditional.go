@@ -0,0 +1,30 @@
+package s3
+
+import (
+	"net/http"
+	"os"
+)
+
+// createOnly reports whether a write sent If-None-Match: *, asking to only
+// create its object, never to replace one. ok is false for any other
+// If-None-Match, which S3 doesn't support on writes either.
+func createOnly(h http.Header) (only, ok bool) {
+	switch h.Get("If-None-Match") {
+	case "":
+		return false, true
+	case "*":
+		return true, true
+	}
+	return false, false
+}
+
+// preconditionFailed answers a create-only write whose object exists. It
+// is checked with the key locked, once the content is in hand and just
+// before it is put in place, so two such writes can't both succeed.
+func (s *Handler) preconditionFailed(w http.ResponseWriter, fullPath string) bool {
+	if _, err := os.Lstat(fullPath); err != nil {
+		return false
+	}
+	s.xmlError(w, http.StatusPreconditionFailed, "PreconditionFailed", "At least one of the pre-conditions you specified did not hold")
+	return true
+}
@@ -0,0 +1,82 @@
+package s3
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func createOnlyPut(h http.Handler, target, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("PUT", target, strings.NewReader(body))
+	r.Header.Set("If-None-Match", "*")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestCreateOnlyPut(t *testing.T) {
+	h, _ := newTestHandler(t)
+	if w := createOnlyPut(h, "/vault/a.md", "first"); w.Code != http.StatusOK {
+		t.Fatalf("create-only PUT of a new key = %d", w.Code)
+	}
+	w := createOnlyPut(h, "/vault/a.md", "second")
+	if w.Code != http.StatusPreconditionFailed || !strings.Contains(w.Body.String(), "<Code>PreconditionFailed</Code>") {
+		t.Fatalf("create-only PUT of an existing key = %d %s", w.Code, w.Body)
+	}
+	if w := serve(h, "GET", "/vault/a.md", ""); w.Body.String() != "first" {
+		t.Fatalf("existing object replaced: %q", w.Body)
+	}
+
+	r := httptest.NewRequest("PUT", "/vault/b.md", strings.NewReader("b"))
+	r.Header.Set("If-None-Match", `"abc"`)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusNotImplemented {
+		t.Fatalf("PUT with an ETag in If-None-Match = %d", w.Code)
+	}
+}
+
+func TestCreateOnlyResumableComplete(t *testing.T) {
+	h, _ := newTestHandler(t)
+	serve(h, "PUT", "/vault/memo.md", "already here")
+	if w := serve(h, "PUT", "/vault/memo.md?offset=0", "uploaded in "); w.Code != http.StatusOK {
+		t.Fatalf("first chunk = %d", w.Code)
+	}
+
+	// The existing object is only checked for once the upload is whole
+	w := createOnlyPut(h, "/vault/memo.md?offset=12&complete", "pieces")
+	if w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("create-only completion over an existing key = %d %s", w.Code, w.Body)
+	}
+	if w := serve(h, "GET", "/vault/memo.md", ""); w.Body.String() != "already here" {
+		t.Fatalf("existing object replaced: %q", w.Body)
+	}
+	if w := serve(h, "HEAD", "/vault/memo.md?partial", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("upload kept after a failed precondition: HEAD ?partial = %d", w.Code)
+	}
+
+	serve(h, "PUT", "/vault/new.md?offset=0", "fresh")
+	if w := createOnlyPut(h, "/vault/new.md?complete", ""); w.Code != http.StatusOK {
+		t.Fatalf("create-only completion of a new key = %d %s", w.Code, w.Body)
+	}
+}
+
+func TestCopyRefused(t *testing.T) {
+	h, _ := newTestHandler(t)
+	serve(h, "PUT", "/vault/src.md", "source")
+	serve(h, "PUT", "/vault/dst.md", "destination")
+	etag := serve(h, "HEAD", "/vault/src.md", "").Header().Get("ETag")
+
+	r := httptest.NewRequest("PUT", "/vault/dst.md", nil)
+	r.Header.Set("X-Amz-Copy-Source", "/vault/src.md")
+	r.Header.Set("X-Amz-Copy-Source-If-Match", etag)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusNotImplemented {
+		t.Fatalf("copy = %d, want 501 until CopyObject exists", w.Code)
+	}
+	if w := serve(h, "GET", "/vault/dst.md", ""); w.Body.String() != "destination" {
+		t.Fatalf("destination replaced by a copy: %q", w.Body)
+	}
+}
@@ -0,0 +1,85 @@
+package s3
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultCORSMaxAge is how long browsers may cache a preflight's answer.
+const DefaultCORSMaxAge = time.Hour
+
+// cors sets the CORS headers for r. It reports false for a request from
+// an origin that isn't allowed, which gets no CORS headers.
+func (s *Handler) cors(w http.ResponseWriter, r *http.Request) bool {
+	allowOrigin := "*"
+	if !slices.Contains(s.corsOrigins, "*") {
+		origin := r.Header.Get("Origin")
+		w.Header().Add("Vary", "Origin")
+		if origin == "" || !slices.Contains(s.corsOrigins, origin) {
+			return false
+		}
+		allowOrigin = origin
+	}
+	w.Header().Set("Access-Control-Allow-Origin", allowOrigin)
+	w.Header().Set("Access-Control-Expose-Headers", "ETag, x-amz-request-id, x-amz-id-2, "+etagKeyHeader)
+	return true
+}
+
+// preflight sets the headers answering r, an OPTIONS request from an
+// allowed origin: the method and headers it asks for, echoed if the
+// resource allows them, and how long the answer may be cached. Without
+// Access-Control-Request-Method every method the resource allows is
+// listed. It reports false, setting neither, if something asked for
+// isn't allowed.
+func (s *Handler) preflight(w http.ResponseWriter, r *http.Request) bool {
+	w.Header().Add("Vary", "Access-Control-Request-Method, Access-Control-Request-Headers")
+	methods := s.corsMethods(r)
+	if method := r.Header.Get("Access-Control-Request-Method"); method != "" {
+		if !slices.Contains(methods, method) {
+			return false
+		}
+		methods = []string{method}
+	}
+	var headers []string
+	for _, h := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+		if h = strings.ToLower(strings.TrimSpace(h)); h == "" {
+			continue
+		}
+		if s.corsHeaders != nil && !slices.ContainsFunc(s.corsHeaders, func(allowed string) bool { return strings.EqualFold(allowed, h) }) {
+			return false
+		}
+		headers = append(headers, h)
+	}
+
+	w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+	if len(headers) > 0 {
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+	}
+	if s.corsMaxAge > 0 {
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(s.corsMaxAge.Seconds())))
+	}
+	return true
+}
+
+// corsMethods returns the methods browsers may use on r's resource: reads
+// everywhere, writes to objects unless the bucket is read-only, and
+// deletes by prefix and ACL changes on the bucket.
+func (s *Handler) corsMethods(r *http.Request) []string {
+	methods := []string{"GET", "HEAD"}
+	if s.readOnly.Load() {
+		return methods
+	}
+	_, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	switch {
+	case key != "":
+		methods = append(methods, "PUT", "DELETE")
+	case r.URL.Query().Has("acl"):
+		methods = append(methods, "PUT")
+	case r.URL.Query().Has("prefix"):
+		methods = append(methods, "DELETE")
+	}
+	return methods
+}
@@ -0,0 +1,84 @@
+package s3
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// blobStore keeps one copy of each distinct object content. Objects are
+// hardlinks to their blob, so a blob's link count is its reference count:
+// it is removed once the store's own link is the only one left. A nil
+// *blobStore stores nothing.
+type blobStore struct {
+	dir string
+	mu  sync.Mutex // serializes linking against releasing
+}
+
+func (b *blobStore) path(sum string) string {
+	return filepath.Join(b.dir, sum[:2], sum)
+}
+
+// adopt turns tmp, whose content hashes to sum, into a link to the stored
+// blob, storing it first if the content is new.
+func (b *blobStore) adopt(tmp, sum string) error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	blob := b.path(sum)
+	if err := os.MkdirAll(filepath.Dir(blob), 0755); err != nil {
+		return err
+	}
+	if err := os.Link(tmp, blob); !os.IsExist(err) {
+		return err
+	}
+	if err := os.Remove(tmp); err != nil {
+		return err
+	}
+	return os.Link(blob, tmp)
+}
+
+// blobOf returns the blob the file at path links to, or "" if it is not
+// linked into the store.
+func (b *blobStore) blobOf(path string) string {
+	if b == nil {
+		return ""
+	}
+	info, err := os.Stat(path)
+	if err != nil || linkCount(info) < 2 {
+		return ""
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return ""
+	}
+	blob := b.path(hex.EncodeToString(h.Sum(nil)))
+	if blobInfo, err := os.Stat(blob); err != nil || !os.SameFile(info, blobInfo) {
+		return ""
+	}
+	return blob
+}
+
+// release removes blob if no object links to it any more.
+func (b *blobStore) release(blob string) {
+	if b == nil || blob == "" {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if info, err := os.Stat(blob); err == nil && linkCount(info) == 1 {
+		os.Remove(blob)
+	}
+}
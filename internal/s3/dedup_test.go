@@ -0,0 +1,104 @@
+package s3
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func put(t *testing.T, h http.Handler, key, body string) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("PUT", "/vault/"+key, strings.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT %s got %d: %s", key, w.Code, w.Body.String())
+	}
+}
+
+// countBlobs returns the number of blobs in the dedup store.
+func countBlobs(t *testing.T, dir string) int {
+	t.Helper()
+	n := 0
+	filepath.WalkDir(filepath.Join(dir, stateDirName, "blobs"), func(path string, d os.DirEntry, err error) error {
+		if err == nil && d.Type().IsRegular() {
+			n++
+		}
+		return nil
+	})
+	return n
+}
+
+func TestDedupIdenticalContent(t *testing.T) {
+	dir := t.TempDir()
+	h := NewHandlerWithOptions(dir, WithDedup(true))
+
+	put(t, h, "a/cat.png", "same bytes")
+	put(t, h, "b/cat-copy.png", "same bytes")
+
+	if n := countBlobs(t, dir); n != 1 {
+		t.Fatalf("%d blobs stored, want 1", n)
+	}
+	a, _ := os.Stat(filepath.Join(dir, "a", "cat.png"))
+	b, _ := os.Stat(filepath.Join(dir, "b", "cat-copy.png"))
+	if !os.SameFile(a, b) {
+		t.Fatal("identical objects are not the same file on disk")
+	}
+
+	// Deleting one key keeps the other and the blob
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("DELETE", "/vault/a/cat.png", nil))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("DELETE got %d", w.Code)
+	}
+	if n := countBlobs(t, dir); n != 1 {
+		t.Fatalf("%d blobs after deleting one reference, want 1", n)
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/vault/b/cat-copy.png", nil))
+	if w.Body.String() != "same bytes" {
+		t.Fatalf("remaining key = %q", w.Body.String())
+	}
+
+	// Deleting the last reference removes the blob
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("DELETE", "/vault/b/cat-copy.png", nil))
+	if n := countBlobs(t, dir); n != 0 {
+		t.Fatalf("%d blobs after deleting every reference, want 0", n)
+	}
+}
+
+func TestDedupOverwriteReleasesBlob(t *testing.T) {
+	dir := t.TempDir()
+	h := NewHandlerWithOptions(dir, WithDedup(true))
+
+	put(t, h, "a.md", "v1")
+	put(t, h, "a.md", "v2")
+
+	if n := countBlobs(t, dir); n != 1 {
+		t.Fatalf("%d blobs after overwrite, want 1", n)
+	}
+	data, _ := os.ReadFile(filepath.Join(dir, "a.md"))
+	if string(data) != "v2" {
+		t.Fatalf("a.md = %q, want v2", data)
+	}
+}
+
+func TestStateDirHidden(t *testing.T) {
+	dir := t.TempDir()
+	h := NewHandlerWithOptions(dir, WithDedup(true))
+	put(t, h, "a.md", "a")
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/vault?list-type=2", nil))
+	if strings.Contains(w.Body.String(), stateDirName) {
+		t.Fatalf("listing exposes the state directory:\n%s", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/vault/"+stateDirName+"/tmp/x", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("GET inside state directory got %d, want 400", w.Code)
+	}
+}
@@ -0,0 +1,16 @@
+//go:build unix
+
+package s3
+
+import (
+	"os"
+	"syscall"
+)
+
+// linkCount returns the number of hardlinks to a file.
+func linkCount(info os.FileInfo) uint64 {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Nlink)
+	}
+	return 1
+}
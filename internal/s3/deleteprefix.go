@@ -0,0 +1,92 @@
+package s3
+
+import (
+	"encoding/xml"
+	"io"
+	"io/fs"
+	"net/http"
+)
+
+// confirmAllQuery is the query parameter, set to "all", that lets a
+// delete by prefix go ahead with an empty prefix, deleting every object.
+const confirmAllQuery = "x-confirm"
+
+// deletePrefix answers DELETE /{bucket}?prefix=..., an extension to S3
+// removing every object whose key starts with the prefix and committing
+// the lot with a single sync. It needs credentials to be configured, and
+// every key under the prefix to be writable; otherwise nothing is
+// deleted. The deleted keys are returned like DeleteObjects' result.
+func (s *Handler) deletePrefix(w http.ResponseWriter, r *http.Request, bucket string) {
+	if bucket != s.bucket {
+		s.xmlError(w, http.StatusNotFound, "NoSuchBucket", "Bucket not found")
+		return
+	}
+	if s.accessKey == "" {
+		s.xmlError(w, http.StatusForbidden, "AccessDenied", "Deleting by prefix requires authentication")
+		return
+	}
+	if s.readOnly.Load() {
+		s.xmlError(w, http.StatusForbidden, "AccessDenied", "Bucket is read-only")
+		return
+	}
+	prefix := r.URL.Query().Get("prefix")
+	if prefix == "" && r.URL.Query().Get(confirmAllQuery) != "all" {
+		s.xmlError(w, http.StatusBadRequest, "InvalidArgument", "An empty prefix deletes every object; add "+confirmAllQuery+"=all to confirm")
+		return
+	}
+
+	var keys []string
+	err := s.walkKeys(prefix, "", 0, func(key string, _ fs.FileInfo) error {
+		keys = append(keys, key)
+		return nil
+	}, nil)
+	if err != nil {
+		s.xmlError(w, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	for _, key := range keys {
+		if !s.writeAllowed(key) {
+			s.xmlError(w, http.StatusForbidden, "AccessDenied", "Writes to "+key+" are not allowed; nothing was deleted")
+			return
+		}
+	}
+
+	result := DeleteResult{Xmlns: s3Namespace, Deleted: []DeletedObject{}}
+	var files, deleted []string
+	for _, key := range keys {
+		fullPath, ok := s.objectPath(key)
+		if !ok {
+			continue
+		}
+		unlock := s.keys.lock(key)
+		err = s.removeObject(key, fullPath)
+		unlock()
+		if err != nil {
+			break
+		}
+		files = append(files, s.changedFiles(fullPath)...)
+		deleted = append(deleted, key)
+		result.Deleted = append(result.Deleted, DeletedObject{Key: key})
+	}
+
+	// What was deleted is synced even if not everything could be
+	if len(files) > 0 {
+		s.trigger(r, files...)
+		if s.watcher != nil {
+			s.watcher.ObjectsChanged("delete", deleted)
+		}
+		for _, key := range deleted {
+			s.notify(EventObjectRemoved, key, 0, "")
+		}
+	}
+	s.logger.Printf("[s3] deleted %d objects under %q", len(deleted), prefix)
+	if err != nil {
+		s.xmlError(w, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, xml.Header)
+	xml.NewEncoder(w).Encode(result)
+}
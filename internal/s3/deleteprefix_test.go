@@ -0,0 +1,91 @@
+package s3
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDeletePrefix(t *testing.T) {
+	dir := t.TempDir()
+	syncer := &recordingSyncer{}
+	h := NewHandlerWithOptions(dir, WithCredentials("key", "secret"), WithSyncer(syncer), WithWriteKeyAllow("notes/**", "keep.md"))
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "http://example.com"+target, strings.NewReader(body))
+		signFor(req, "key", "secret", "us-east-1", "host", "x-amz-content-sha256", "x-amz-date")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+	for _, key := range []string{"notes/old/a.md", "notes/old/sub/b.md", "notes/older.md", "notes/new.md", "keep.md"} {
+		if w := do("PUT", "/vault/"+key, "x"); w.Code != http.StatusOK {
+			t.Fatalf("PUT %s = %d: %s", key, w.Code, w.Body)
+		}
+	}
+	syncer.triggered = nil
+
+	w := do("DELETE", "/vault?prefix=notes/old", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("DELETE by prefix = %d: %s", w.Code, w.Body)
+	}
+	var result DeleteResult
+	if err := xml.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	var deleted []string
+	for _, d := range result.Deleted {
+		deleted = append(deleted, d.Key)
+	}
+	if want := []string{"notes/old/a.md", "notes/old/sub/b.md", "notes/older.md"}; !slices.Equal(deleted, want) {
+		t.Fatalf("deleted %v, want %v", deleted, want)
+	}
+	for _, key := range deleted {
+		if _, err := os.Stat(filepath.Join(dir, key)); !os.IsNotExist(err) {
+			t.Fatalf("%s still there: %v", key, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "notes", "old")); !os.IsNotExist(err) {
+		t.Fatal("empty directory left behind")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "notes", "new.md")); err != nil {
+		t.Fatal("object outside the prefix deleted")
+	}
+	// One sync, for exactly the deleted files
+	if !slices.Equal(syncer.triggered, deleted) {
+		t.Fatalf("triggered %v", syncer.triggered)
+	}
+
+	// An empty prefix needs confirming
+	if w := do("DELETE", "/vault?prefix=", ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("DELETE with an empty prefix = %d", w.Code)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "keep.md")); err != nil {
+		t.Fatal("unconfirmed empty prefix deleted objects")
+	}
+
+	// Keys outside the allowlist stop the whole delete
+	do("PUT", "/vault/notes/x.md", "x")
+	os.WriteFile(filepath.Join(dir, "other.md"), []byte("x"), 0644)
+	if w := do("DELETE", "/vault?prefix=&x-confirm=all", ""); w.Code != http.StatusForbidden {
+		t.Fatalf("DELETE of keys outside the allowlist = %d", w.Code)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "notes", "x.md")); err != nil {
+		t.Fatal("refused delete removed objects")
+	}
+	os.Remove(filepath.Join(dir, "other.md"))
+	if w := do("DELETE", "/vault?prefix=&x-confirm=all", ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "keep.md") {
+		t.Fatalf("confirmed DELETE of everything = %d: %s", w.Code, w.Body)
+	}
+
+	// Not without credentials
+	open, _ := newTestHandler(t)
+	serve(open, "PUT", "/vault/notes/a.md", "x")
+	if w := serve(open, "DELETE", "/vault?prefix=notes/", ""); w.Code != http.StatusForbidden {
+		t.Fatalf("DELETE by prefix without credentials = %d", w.Code)
+	}
+}
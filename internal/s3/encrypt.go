@@ -0,0 +1,234 @@
+package s3
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+)
+
+// encryptedMagic starts every encrypted object, followed by the nonce and
+// the AES-256-GCM sealed content.
+const encryptedMagic = "git3enc1"
+
+// ErrNotEncrypted is returned for objects stored in the clear when
+// encryption is on, such as ones written before it was turned on.
+var ErrNotEncrypted = errors.New("object is not encrypted")
+
+// Cipher encrypts objects at rest with AES-256-GCM, so the working tree,
+// and with it the git history, only holds ciphertext. Objects are sealed
+// whole, so they are read into memory to be served.
+type Cipher struct {
+	aead cipher.AEAD
+}
+
+// NewCipher creates a Cipher from a 32 byte key.
+func NewCipher(key []byte) (*Cipher, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("encryption key must be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Cipher{aead: aead}, nil
+}
+
+// overhead is how much larger an object is on disk than its content.
+func (c *Cipher) overhead() int64 {
+	return int64(len(encryptedMagic) + c.aead.NonceSize() + c.aead.Overhead())
+}
+
+func (c *Cipher) seal(plaintext []byte) ([]byte, error) {
+	out := make([]byte, len(encryptedMagic)+c.aead.NonceSize(), int64(len(plaintext))+c.overhead())
+	copy(out, encryptedMagic)
+	nonce := out[len(encryptedMagic):]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return c.aead.Seal(out, nonce, plaintext, []byte(encryptedMagic)), nil
+}
+
+func (c *Cipher) open(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, []byte(encryptedMagic)) {
+		return nil, ErrNotEncrypted
+	}
+	data = data[len(encryptedMagic):]
+	if len(data) < c.aead.NonceSize() {
+		return nil, errors.New("encrypted object is truncated")
+	}
+	nonce, sealed := data[:c.aead.NonceSize()], data[c.aead.NonceSize():]
+	plaintext, err := c.aead.Open(nil, nonce, sealed, []byte(encryptedMagic))
+	if err != nil {
+		return nil, fmt.Errorf("decrypt object: %w", err)
+	}
+	return plaintext, nil
+}
+
+// sealFile encrypts the file at path in place. It is only used on files
+// nobody else can see yet.
+func (c *Cipher) sealFile(path string) error {
+	plaintext, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	sealed, err := c.seal(plaintext)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, sealed, 0644)
+}
+
+// openFile returns the decrypted content of f, closing it. Content stored
+// in the clear, as a pull can bring in from a clone without the key, is
+// returned as it is rather than refused, so GETs and archives still get
+// it.
+func (c *Cipher) openFile(f io.ReadCloser) (io.ReadSeekCloser, error) {
+	return decryptFile(f, func(data []byte) ([]byte, error) {
+		if !bytes.HasPrefix(data, []byte(encryptedMagic)) {
+			return data, nil
+		}
+		return c.open(data)
+	})
+}
+
+// decryptFile reads all of f, closing it, and returns its content as
+// decrypted by open.
+func decryptFile(f io.ReadCloser, open func([]byte) ([]byte, error)) (io.ReadSeekCloser, error) {
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := open(data)
+	if err != nil {
+		return nil, err
+	}
+	return nopCloser{bytes.NewReader(plaintext)}, nil
+}
+
+// contentSize returns the content size of an encrypted object of size
+// bytes.
+func (c *Cipher) contentSize(size int64) int64 {
+	return max(size-c.overhead(), 0)
+}
+
+type nopCloser struct {
+	*bytes.Reader
+}
+
+func (nopCloser) Close() error { return nil }
+
+// EncryptExisting finds objects stored in the clear, which are there when
+// encryption is turned on for an existing vault. Unless migrate is set it
+// fails with ErrNotEncrypted if there are any; with migrate it encrypts
+// them in place and triggers a sync, returning how many it encrypted.
+// Their earlier versions stay readable in the git history.
+func (s *Handler) EncryptExisting(migrate bool) (int, error) {
+	if s.cipher == nil {
+		return 0, nil
+	}
+	if s.treeLock != nil {
+		s.treeLock.Lock()
+		defer s.treeLock.Unlock()
+	}
+
+	var plain []string
+	err := s.walkKeys("", "", 0, func(key string, info fs.FileInfo) error {
+		magic, err := readMagic(s.keyFile(key))
+		if err != nil {
+			return err
+		}
+		// Objects written with SSE-C are encrypted with their own key
+		if magic != encryptedMagic && magic != sseMagic {
+			plain = append(plain, key)
+		}
+		return nil
+	}, nil)
+	if err != nil || len(plain) == 0 {
+		return 0, err
+	}
+	if !migrate {
+		return 0, fmt.Errorf("%w: %d objects, including %s", ErrNotEncrypted, len(plain), plain[0])
+	}
+
+	for i, key := range plain {
+		if err := s.encryptObject(key); err != nil {
+			return i, fmt.Errorf("encrypt %s: %w", key, err)
+		}
+	}
+	var changed []string
+	for _, key := range plain {
+		changed = append(changed, s.relPath(s.keyFile(key)))
+	}
+	s.syncer.Trigger(changed...)
+	return len(plain), nil
+}
+
+// encryptObject replaces a plaintext object with its encrypted version.
+func (s *Handler) encryptObject(key string) error {
+	unlock := s.keys.lock(key)
+	defer unlock()
+
+	fullPath := s.keyFile(key)
+	tmpDir := s.stateDir("tmp")
+	if err := s.files.MkdirAll(tmpDir, 0755); err != nil {
+		return err
+	}
+	f, err := s.files.CreateTemp(tmpDir, "encrypt-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	f.Close()
+
+	plaintext, err := os.ReadFile(fullPath)
+	if err != nil {
+		return err
+	}
+	sealed, err := s.cipher.seal(plaintext)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(f.Name(), sealed, 0644); err != nil {
+		return err
+	}
+	// Keep the modification time, so listings don't show every object
+	// as just changed
+	var meta objectMeta
+	if info, err := os.Stat(fullPath); err == nil {
+		os.Chtimes(f.Name(), info.ModTime(), info.ModTime())
+		meta, _ = s.meta.get(key, info)
+	}
+	replaced := s.blobs.blobOf(fullPath)
+	if err := s.files.Rename(f.Name(), fullPath); err != nil {
+		return err
+	}
+	s.blobs.release(replaced)
+	return s.meta.put(key, fullPath, meta)
+}
+
+// readMagic returns the first bytes of the file at path, as many as an
+// encryption magic has.
+func readMagic(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	buf := make([]byte, len(encryptedMagic))
+	n, err := io.ReadFull(f, buf)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return "", err
+	}
+	return string(buf[:n]), nil
+}
@@ -0,0 +1,156 @@
+package s3
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+type triggerFunc func()
+
+func (f triggerFunc) Trigger(...string) { f() }
+
+func newTestCipher(t *testing.T, fill byte) *Cipher {
+	t.Helper()
+	c, err := NewCipher(bytes.Repeat([]byte{fill}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestEncryptedObjects(t *testing.T) {
+	dir := t.TempDir()
+	h := NewHandlerWithOptions(dir, WithEncryption(newTestCipher(t, 1)))
+	body := "# Secret plans\n\nnothing to see here"
+
+	put := serve(h, "PUT", "/vault/notes/plans.md", body)
+	if put.Code != http.StatusOK {
+		t.Fatalf("PUT got %d: %s", put.Code, put.Body)
+	}
+	sum := hashSHA256([]byte(body))
+	if put.Header().Get("ETag") != `"`+sum[:32]+`"` {
+		t.Fatalf("ETag %s is not over the plaintext", put.Header().Get("ETag"))
+	}
+
+	onDisk, err := os.ReadFile(filepath.Join(dir, "notes", "plans.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(onDisk, []byte("Secret")) || !bytes.HasPrefix(onDisk, []byte(encryptedMagic)) {
+		t.Fatalf("object stored in the clear: %q", onDisk)
+	}
+
+	if w := serve(h, "GET", "/vault/notes/plans.md", ""); w.Body.String() != body {
+		t.Fatalf("GET = %q, want %q", w.Body, body)
+	}
+	if w := serve(h, "HEAD", "/vault/notes/plans.md", ""); w.Header().Get("Content-Length") != "35" {
+		t.Fatalf("HEAD Content-Length = %s, want 35", w.Header().Get("Content-Length"))
+	}
+
+	req := httptest.NewRequest("GET", "/vault/notes/plans.md", nil)
+	req.Header.Set("Range", "bytes=2-7")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusPartialContent || w.Body.String() != "Secret" {
+		t.Fatalf("range GET got %d %q", w.Code, w.Body)
+	}
+
+	if w := serve(h, "GET", "/vault?prefix=notes/", ""); !strings.Contains(w.Body.String(), "<Size>35</Size>") {
+		t.Fatalf("listing doesn't report the content size: %s", w.Body)
+	}
+}
+
+func TestEncryptedAppend(t *testing.T) {
+	h := NewHandlerWithOptions(t.TempDir(), WithEncryption(newTestCipher(t, 1)))
+	serve(h, "PUT", "/vault/log.md", "one\n")
+
+	req := httptest.NewRequest("PUT", "/vault/log.md", strings.NewReader("two\n"))
+	req.Header.Set(writeModeHeader, "append")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	if w := serve(h, "GET", "/vault/log.md", ""); w.Body.String() != "one\ntwo\n" {
+		t.Fatalf("GET = %q after append", w.Body)
+	}
+}
+
+func TestEncryptedObjectWrongKey(t *testing.T) {
+	dir := t.TempDir()
+	serve(NewHandlerWithOptions(dir, WithEncryption(newTestCipher(t, 1))), "PUT", "/vault/a.md", "hello")
+
+	h := NewHandlerWithOptions(dir, WithEncryption(newTestCipher(t, 2)))
+	if w := serve(h, "GET", "/vault/a.md", ""); w.Code != http.StatusInternalServerError || strings.Contains(w.Body.String(), "hello") {
+		t.Fatalf("GET with the wrong key got %d %q", w.Code, w.Body)
+	}
+}
+
+func TestEncryptedVaultPulledPlaintext(t *testing.T) {
+	dir := t.TempDir()
+	h := NewHandlerWithOptions(dir, WithEncryption(newTestCipher(t, 1)))
+	serve(h, "PUT", "/vault/notes/a.md", "sealed")
+	// As a pull from a clone without the key would leave it
+	os.WriteFile(filepath.Join(dir, "notes", "b.md"), []byte("in the clear"), 0644)
+
+	if w := serve(h, "GET", "/vault/notes/b.md", ""); w.Code != http.StatusOK || w.Body.String() != "in the clear" {
+		t.Fatalf("GET of a plaintext object = %d %q", w.Code, w.Body)
+	}
+	if w := serve(h, "HEAD", "/vault/notes/b.md", ""); w.Header().Get("Content-Length") != "12" {
+		t.Fatalf("HEAD Content-Length = %s, want 12", w.Header().Get("Content-Length"))
+	}
+	// Listings agree with HEAD on both
+	sizes := map[string]int64{}
+	for _, obj := range decodeListing(t, serve(h, "GET", "/vault?list-type=2&prefix=notes/", "").Body).Contents {
+		sizes[obj.Key] = obj.Size
+	}
+	if sizes["notes/a.md"] != 6 || sizes["notes/b.md"] != 12 {
+		t.Fatalf("listed sizes = %v, want 6 and 12", sizes)
+	}
+	w := serve(h, "GET", "/vault?archive=tar", "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "sealed") || !strings.Contains(w.Body.String(), "in the clear") {
+		t.Fatalf("archive = %d, want both objects", w.Code)
+	}
+}
+
+func TestEncryptExisting(t *testing.T) {
+	dir := t.TempDir()
+	plain := NewHandlerWithOptions(dir)
+	serve(plain, "PUT", "/vault/a.md", "alpha")
+	serve(plain, "PUT", "/vault/notes/b.md", "beta")
+	info, _ := os.Stat(filepath.Join(dir, "a.md"))
+
+	var triggers atomic.Int32
+	syncer := triggerFunc(func() { triggers.Add(1) })
+	h := NewHandlerWithOptions(dir, WithEncryption(newTestCipher(t, 1)), WithSyncer(syncer))
+	if _, err := h.EncryptExisting(false); !errors.Is(err, ErrNotEncrypted) {
+		t.Fatalf("EncryptExisting without migrate = %v, want ErrNotEncrypted", err)
+	}
+	if onDisk, _ := os.ReadFile(filepath.Join(dir, "a.md")); string(onDisk) != "alpha" {
+		t.Fatal("checking changed an object")
+	}
+
+	n, err := h.EncryptExisting(true)
+	if err != nil || n != 2 {
+		t.Fatalf("EncryptExisting = %d, %v; want 2 objects", n, err)
+	}
+	if triggers.Load() == 0 {
+		t.Fatal("migration didn't trigger a sync")
+	}
+	if onDisk, _ := os.ReadFile(filepath.Join(dir, "a.md")); !bytes.HasPrefix(onDisk, []byte(encryptedMagic)) {
+		t.Fatalf("a.md not encrypted: %q", onDisk)
+	}
+	if after, _ := os.Stat(filepath.Join(dir, "a.md")); !after.ModTime().Equal(info.ModTime()) {
+		t.Fatal("migration changed the modification time")
+	}
+	if w := serve(h, "GET", "/vault/notes/b.md", ""); w.Body.String() != "beta" {
+		t.Fatalf("GET = %q after migration", w.Body)
+	}
+	if n, err := h.EncryptExisting(false); n != 0 || err != nil {
+		t.Fatalf("second EncryptExisting = %d, %v", n, err)
+	}
+}
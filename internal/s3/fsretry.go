@@ -0,0 +1,92 @@
+package s3
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"time"
+)
+
+// fileSystem is the part of the os package the handler stores and reads
+// objects through, so tests can make it fail.
+type fileSystem interface {
+	MkdirAll(path string, perm os.FileMode) error
+	CreateTemp(dir, pattern string) (*os.File, error)
+	Rename(oldpath, newpath string) error
+	Open(name string) (*os.File, error)
+}
+
+type osFS struct{}
+
+func (osFS) MkdirAll(path string, perm os.FileMode) error     { return os.MkdirAll(path, perm) }
+func (osFS) CreateTemp(dir, pattern string) (*os.File, error) { return os.CreateTemp(dir, pattern) }
+func (osFS) Rename(oldpath, newpath string) error             { return os.Rename(oldpath, newpath) }
+func (osFS) Open(name string) (*os.File, error)               { return os.Open(name) }
+
+// Retries of transient filesystem errors: fsAttempts tries in all, the
+// first retry after fsBackoff and each later one after twice as long.
+var (
+	fsAttempts = 4
+	fsBackoff  = 20 * time.Millisecond
+)
+
+// retryFS retries calls to fs that fail with a transient error, which
+// network filesystems such as NFS and SMB return now and then. Copying a
+// request body is not retried: the body can only be read once.
+type retryFS struct {
+	fs fileSystem
+}
+
+func (r retryFS) MkdirAll(path string, perm os.FileMode) error {
+	return retryTransient(func() error { return r.fs.MkdirAll(path, perm) })
+}
+
+func (r retryFS) CreateTemp(dir, pattern string) (f *os.File, err error) {
+	err = retryTransient(func() error {
+		f, err = r.fs.CreateTemp(dir, pattern)
+		return err
+	})
+	return f, err
+}
+
+func (r retryFS) Rename(oldpath, newpath string) error {
+	return retryTransient(func() error { return r.fs.Rename(oldpath, newpath) })
+}
+
+func (r retryFS) Open(name string) (f *os.File, err error) {
+	err = retryTransient(func() error {
+		f, err = r.fs.Open(name)
+		return err
+	})
+	return f, err
+}
+
+// retryTransient calls op until it succeeds, fails with an error that
+// isTransient rejects, or has been tried fsAttempts times.
+func retryTransient(op func() error) error {
+	backoff := fsBackoff
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if err == nil || attempt >= fsAttempts || !isTransient(err) {
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// isTransient reports whether err is worth retrying: an interrupted call,
+// a resource that is briefly unavailable or busy, or a full disk that
+// log rotation or a cleanup may be about to free.
+func isTransient(err error) bool {
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return false
+	}
+	switch errno {
+	case syscall.EINTR, syscall.EAGAIN, syscall.EBUSY, syscall.ENOSPC:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,105 @@
+package s3
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// flakyFS fails the first failures calls to Rename and Open with err, then
+// behaves like the os package.
+type flakyFS struct {
+	osFS
+	failures int
+	err      error
+	calls    int
+}
+
+func (f *flakyFS) fail() error {
+	f.calls++
+	if f.calls <= f.failures {
+		return &os.PathError{Op: "flaky", Err: f.err}
+	}
+	return nil
+}
+
+func (f *flakyFS) Rename(oldpath, newpath string) error {
+	if err := f.fail(); err != nil {
+		return err
+	}
+	return f.osFS.Rename(oldpath, newpath)
+}
+
+func (f *flakyFS) Open(name string) (*os.File, error) {
+	if err := f.fail(); err != nil {
+		return nil, err
+	}
+	return f.osFS.Open(name)
+}
+
+func withFastRetries(t *testing.T) {
+	t.Helper()
+	backoff := fsBackoff
+	fsBackoff = time.Millisecond
+	t.Cleanup(func() { fsBackoff = backoff })
+}
+
+func TestPutRetriesTransientErrors(t *testing.T) {
+	withFastRetries(t)
+	h, dir := newTestHandler(t)
+	flaky := &flakyFS{failures: fsAttempts - 1, err: syscall.EAGAIN}
+	h.files = retryFS{flaky}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("PUT", "/vault/a.md", strings.NewReader("a")))
+	if w.Code != http.StatusOK || flaky.calls != fsAttempts {
+		t.Fatalf("PUT got %d after %d renames, want 200 after %d", w.Code, flaky.calls, fsAttempts)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dir, "a.md")); string(got) != "a" {
+		t.Fatalf("a.md = %q", got)
+	}
+}
+
+func TestPutGivesUpAfterRetries(t *testing.T) {
+	withFastRetries(t)
+	h, _ := newTestHandler(t)
+	flaky := &flakyFS{failures: fsAttempts, err: syscall.ENOSPC}
+	h.files = retryFS{flaky}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("PUT", "/vault/a.md", strings.NewReader("a")))
+	if w.Code != http.StatusInternalServerError || flaky.calls != fsAttempts {
+		t.Fatalf("PUT got %d after %d renames, want 500 after %d", w.Code, flaky.calls, fsAttempts)
+	}
+}
+
+func TestPermanentErrorsNotRetried(t *testing.T) {
+	withFastRetries(t)
+	h, _ := newTestHandler(t)
+	flaky := &flakyFS{failures: 1, err: syscall.EACCES}
+	h.files = retryFS{flaky}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("PUT", "/vault/a.md", strings.NewReader("a")))
+	if w.Code != http.StatusInternalServerError || flaky.calls != 1 {
+		t.Fatalf("PUT got %d after %d renames, want 500 after 1", w.Code, flaky.calls)
+	}
+}
+
+func TestGetRetriesTransientErrors(t *testing.T) {
+	withFastRetries(t)
+	h, dir := newTestHandler(t)
+	os.WriteFile(filepath.Join(dir, "a.md"), []byte("a"), 0644)
+	h.files = retryFS{&flakyFS{failures: 2, err: syscall.EINTR}}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/vault/a.md", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "a" {
+		t.Fatalf("GET got %d %q", w.Code, w.Body.String())
+	}
+}
@@ -1,62 +1,209 @@
 package s3
 
 import (
+	"bytes"
+	"crypto/rand"
 	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"log"
+	"mime"
 	"net/http"
+	"net/netip"
 	"os"
+	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // Syncer is called after PUT/DELETE to trigger a background sync (e.g. git commit+push).
+// paths are the files changed, relative to the handler's root; with none,
+// anything may have changed.
 type Syncer interface {
-	Trigger()
+	Trigger(paths ...string)
+}
+
+// DeferringSyncer is a Syncer that can also record changes without
+// scheduling a sync, for writes sent with X-Git3-Defer-Sync.
+type DeferringSyncer interface {
+	Syncer
+	Defer(paths ...string)
+}
+
+// deferSyncHeader asks for a write to be committed with the next sync
+// instead of scheduling one, so a bulk import can upload everything and
+// then sync once.
+const deferSyncHeader = "X-Git3-Defer-Sync"
+
+// trigger tells the syncer about changed paths, deferring them if r asks
+// for that and the syncer can, and marks the manifest stale.
+func (s *Handler) trigger(r *http.Request, paths ...string) {
+	s.manifest.changed()
+	if d, ok := s.syncer.(DeferringSyncer); ok {
+		if deferred, _ := strconv.ParseBool(r.Header.Get(deferSyncHeader)); deferred {
+			d.Defer(paths...)
+			return
+		}
+	}
+	s.syncer.Trigger(paths...)
+}
+
+// Watcher is told about objects written ("put") or deleted ("delete")
+// through the API, after the change is on disk.
+type Watcher interface {
+	ObjectsChanged(op string, keys []string)
+}
+
+// AuthObserver is told why each request that failed authentication failed,
+// as one of the Auth* reasons.
+type AuthObserver interface {
+	AuthFailed(reason string)
+}
+
+// LFS keeps large objects out of git, leaving a pointer file at the key.
+type LFS interface {
+	// Track reports whether an object of this key and size belongs in LFS.
+	Track(key string, size int64) bool
+	// Clean moves a written object into LFS storage, leaving a pointer.
+	Clean(key, path string) error
+	// Open returns the real content behind a possibly-pointer file.
+	Open(path string) (*os.File, error)
+	// Size returns the content size of a possibly-pointer file.
+	Size(path string, size int64) int64
 }
 
 type Handler struct {
-	dir       string
-	bucket    string
-	accessKey string
-	secretKey string
-	region    string
-	syncer    Syncer
+	dir           string
+	bucket        string
+	owner         string
+	corsEnabled   bool
+	corsOrigins   []string
+	corsHeaders   []string // request headers preflights may ask for; nil for any
+	corsMaxAge    time.Duration
+	accessKey     string
+	secretKey     string
+	signedHeaders SignedHeaderPolicy
+	authObserver  AuthObserver
+	region        string
+	syncer        Syncer
+	readOnly      atomic.Bool
+	writeKeyAllow []string
+	maxObjectSize int64
+	logger        *log.Logger
+	lfs           LFS
+	treeLock      sync.Locker
+	writeLock     sync.Locker
+	blobs         *blobStore
+	meta          *metaStore
+	reconciled    atomic.Pointer[MetaReport]
+	indexDocument string
+	errorDocument string
+	keys          keyLocks
+	files         fileSystem
+	watcher       Watcher
+	notifier      *notifier
+	cipher        *Cipher
+	notifications []NotificationRule
+	cache         *objectCache
+	layout        *hashedLayout
+	stats         treeStats
+	manifest      manifestCache
+	idempotency   *idempotency
+	repoSize      RepoSizer
+	largeObject   int64
+	versions      VersionCounter
+	requireTLS    bool
+	maxKeyLength  int
+	tlsProxies    []netip.Prefix
+	plaintextOnce sync.Once
+
+	defaultContentType string
+	contentTypes       map[string]string
 }
 
 // NewHandler creates an S3-compatible HTTP handler.
 func NewHandler(dir, bucket, accessKey, secretKey, region string, syncer Syncer) *Handler {
-	return &Handler{
-		dir:       dir,
-		bucket:    bucket,
-		accessKey: accessKey,
-		secretKey: secretKey,
-		region:    region,
-		syncer:    syncer,
+	return NewHandlerWithOptions(dir,
+		WithBucket(bucket),
+		WithCredentials(accessKey, secretKey),
+		WithRegion(region),
+		WithSyncer(syncer),
+	)
+}
+
+// NewHandlerWithOptions creates an S3-compatible HTTP handler serving dir,
+// configured by opts.
+func NewHandlerWithOptions(dir string, opts ...Option) *Handler {
+	s := &Handler{
+		dir:    dir,
+		bucket: "vault",
+		owner:  "git3",
+		region: "us-east-1",
+		syncer: nopSyncer{},
+		logger: log.Default(),
+		files:  retryFS{osFS{}},
+
+		corsEnabled:   true,
+		corsOrigins:   []string{"*"},
+		corsMaxAge:    DefaultCORSMaxAge,
+		signedHeaders: DefaultSignedHeaders,
+		maxKeyLength:  DefaultMaxKeyLength,
+		contentTypes: map[string]string{
+			".md": "text/markdown; charset=utf-8",
+		},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.syncer == nil {
+		s.syncer = nopSyncer{}
 	}
+	s.meta = &metaStore{dir: s.stateDir("meta"), files: s.files}
+	if len(s.notifications) > 0 {
+		s.notifier = newNotifier(s.notifications, s.logger)
+	}
+	return s
 }
 
 func (s *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	// CORS
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, PUT, DELETE, HEAD, POST")
-	w.Header().Set("Access-Control-Allow-Headers", "*")
-	w.Header().Set("Access-Control-Expose-Headers", "ETag, x-amz-request-id, x-amz-id-2")
+	// net/http only adds Date once the response is written; set it up
+	// front so every response, wherever it is served from, has one.
+	w.Header().Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	w.Header().Set(requestIDHeader, newRequestID())
+
+	// CORS. Preflights are answered before auth, since browsers send
+	// them without credentials.
+	allowed := s.corsEnabled && s.cors(w, r)
 	if r.Method == "OPTIONS" {
-		w.WriteHeader(http.StatusOK)
+		switch {
+		case !s.corsEnabled:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		case !allowed || !s.preflight(w, r):
+			s.xmlError(w, http.StatusForbidden, "AccessForbidden", "CORSResponse: This CORS request is not allowed")
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
 		return
 	}
 
 	// Auth
-	if s.accessKey != "" {
-		if !sigV4Verify(r, s.accessKey, s.secretKey, s.region) {
-			s.xmlError(w, http.StatusForbidden, "AccessDenied", "Invalid signature")
-			return
-		}
+	if s.accessKey != "" && !s.authenticate(w, r) {
+		return
+	}
+
+	// Hold requests while the working tree is being swapped
+	if s.treeLock != nil {
+		s.treeLock.Lock()
+		defer s.treeLock.Unlock()
 	}
 
 	// Route: /{bucket} or /{bucket}/{key...}
@@ -68,13 +215,27 @@ func (s *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		key = parts[1]
 	}
 
+	if r.URL.Query().Has("acl") && (r.Method == "GET" || r.Method == "PUT") {
+		s.acl(w, r, bucket, key)
+		return
+	}
+
 	// Bucket-level operations
 	if key == "" {
-		switch r.Method {
-		case "GET":
+		switch {
+		case r.Method == "GET" && r.URL.Query().Has("etag"):
+			s.getObjectByETag(w, r, bucket)
+		case r.Method == "GET" && r.URL.Query().Has(manifestQuery):
+			s.getManifest(w, r, bucket)
+		case r.Method == "GET" && r.URL.Query().Has(archiveQuery):
+			s.getArchive(w, r, bucket)
+		case r.Method == "GET":
 			s.listObjectsV2(w, r, bucket)
-		case "HEAD":
+		case r.Method == "DELETE" && r.URL.Query().Has("prefix"):
+			s.deletePrefix(w, r, bucket)
+		case r.Method == "HEAD":
 			if bucket == s.bucket {
+				w.Header().Set(bucketRegionHeader, s.region)
 				w.WriteHeader(http.StatusOK)
 			} else {
 				s.xmlError(w, http.StatusNotFound, "NoSuchBucket", "Bucket not found")
@@ -86,20 +247,109 @@ func (s *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Object-level operations
-	switch r.Method {
-	case "PUT":
+	if bucket != s.bucket {
+		s.xmlError(w, http.StatusNotFound, "NoSuchBucket", "Bucket not found")
+		return
+	}
+	if s.readOnly.Load() && (r.Method == "PUT" || r.Method == "DELETE") {
+		s.xmlError(w, http.StatusForbidden, "AccessDenied", "Bucket is read-only")
+		return
+	}
+	if r.Method == "PUT" || r.Method == "DELETE" {
+		if code, message, ok := s.checkKey(key); !ok {
+			s.xmlError(w, http.StatusBadRequest, code, message)
+			return
+		}
+	}
+	if (r.Method == "PUT" || r.Method == "DELETE") && !s.writeAllowed(key) {
+		s.xmlError(w, http.StatusForbidden, "AccessDenied", "Writes to this key are not allowed")
+		return
+	}
+
+	if s.indexDocument != "" && strings.HasSuffix(key, "/") && (r.Method == "GET" || r.Method == "HEAD") {
+		key += s.indexDocument
+	}
+
+	switch {
+	case r.Method == "PUT" && r.Header.Get("X-Amz-Copy-Source") != "":
+		// Taken for a plain PUT, a copy would replace the destination with
+		// its empty body, whatever its x-amz-copy-source-if-* conditions
+		s.xmlError(w, http.StatusNotImplemented, "NotImplemented", "CopyObject is not implemented")
+	case r.Method == "PUT" && isResumable(r):
+		s.putPartial(w, r, key)
+	case r.Method == "PUT":
 		s.putObject(w, r, key)
-	case "GET":
+	case r.Method == "GET" && r.URL.Query().Has(objectInfoQuery):
+		s.getObjectInfo(w, r, key)
+	case r.Method == "GET":
 		s.getObject(w, r, key)
-	case "HEAD":
+	case r.Method == "HEAD" && r.URL.Query().Has("partial"):
+		s.headPartial(w, r, key)
+	case r.Method == "HEAD":
 		s.headObject(w, r, key)
-	case "DELETE":
+	case r.Method == "DELETE":
 		s.deleteObject(w, r, key)
 	default:
 		w.WriteHeader(http.StatusMethodNotAllowed)
 	}
 }
 
+// authenticate checks r's signature, answering with an error and
+// reporting the reason if it fails.
+func (s *Handler) authenticate(w http.ResponseWriter, r *http.Request) bool {
+	status, code, message := http.StatusForbidden, "AccessDenied", "Invalid signature"
+	reason := ""
+	plaintext := !s.overTLS(r)
+	if plaintext && !s.requireTLS {
+		s.warnPlaintext(r)
+	}
+	if plaintext && s.requireTLS {
+		reason, message = AuthPlaintext, "Signed requests must be made over HTTPS"
+	} else if reason = sigV4Verify(r, s.accessKey, s.secretKey, s.region); reason == "" {
+		// The policy and the date are only worth checking once the
+		// signature vouches for them
+		if h := s.signedHeaders.missing(r); h != "" {
+			reason, message = AuthUnsignedHeaders, "SignatureDoesNotMatch: SignedHeaders must include "+h
+		} else if reason = checkDate(r, time.Now()); reason != "" {
+			code, message = "RequestTimeTooSkewed", "The difference between the request time and the server's time is too large"
+		}
+	} else if reason == AuthUnsignedHeaders {
+		status, code, message = http.StatusBadRequest, "AuthorizationHeaderMalformed", "The authorization header is malformed; SignedHeaders must include host and x-amz-date"
+	} else if reason == AuthAbsentHeader {
+		message = "SignatureDoesNotMatch: a header named in SignedHeaders is missing from the request"
+	}
+	if reason == "" {
+		return true
+	}
+
+	// The signature itself is never logged
+	s.logger.Printf("[s3] auth failed (%s): %s %s from %s", reason, r.Method, r.URL.Path, r.RemoteAddr)
+	if s.authObserver != nil {
+		s.authObserver.AuthFailed(reason)
+	}
+	if reason == AuthWrongRegion {
+		s.wrongRegion(w, r)
+		return false
+	}
+	s.xmlError(w, status, code, message)
+	return false
+}
+
+// wrongRegion answers a request signed for another region as S3 does,
+// naming the bucket's region in the body as well as the header, so SDKs
+// can correct theirs and retry.
+func (s *Handler) wrongRegion(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(bucketRegionHeader, s.region)
+	writeErrorResponse(w, http.StatusBadRequest, ErrorResponse{
+		Code:    "AuthorizationHeaderMalformed",
+		Message: fmt.Sprintf("The authorization header is malformed; the region '%s' is wrong; expecting '%s'", credentialRegion(r), s.region),
+		Region:  s.region,
+	})
+}
+
+// listFlushEvery is how many listing entries are written between flushes.
+const listFlushEvery = 100
+
 func (s *Handler) listObjectsV2(w http.ResponseWriter, r *http.Request, bucket string) {
 	prefix := r.URL.Query().Get("prefix")
 	maxKeys := 1000
@@ -109,136 +359,638 @@ func (s *Handler) listObjectsV2(w http.ResponseWriter, r *http.Request, bucket s
 		}
 	}
 
-	var objects []ObjectInfo
-	root := s.dir
+	// x-sort is an extension; S3 itself always lists in key order
+	sorted := false
+	switch r.URL.Query().Get("x-sort") {
+	case "":
+	case "lastmodified-desc":
+		sorted = true
+	default:
+		s.xmlError(w, http.StatusBadRequest, "InvalidArgument", "Unsupported x-sort value")
+		return
+	}
+
+	// A "/" delimiter lists one level below the prefix; the x-max-depth
+	// extension lists several. Deeper directories become CommonPrefixes
+	// without being read.
+	delimiter := r.URL.Query().Get("delimiter")
+	depth := 0
+	switch delimiter {
+	case "":
+	case "/":
+		depth = 1
+	default:
+		s.xmlError(w, http.StatusBadRequest, "InvalidArgument", "Only / is supported as a delimiter")
+		return
+	}
+	if v := r.URL.Query().Get("x-max-depth"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || delimiter != "" {
+			s.xmlError(w, http.StatusBadRequest, "InvalidArgument", "x-max-depth must be a positive integer, without a delimiter")
+			return
+		}
+		depth = n
+	}
+	if depth > 0 && sorted {
+		s.xmlError(w, http.StatusBadRequest, "InvalidArgument", "x-sort cannot be combined with a delimiter or x-max-depth")
+		return
+	}
 
-	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+	// Listing resumes after the last key or common prefix of the page
+	// before, which the continuation token encodes
+	token := r.URL.Query().Get("continuation-token")
+	startAfter := r.URL.Query().Get("start-after")
+	after := startAfter
+	if token != "" {
+		key, err := base64.RawURLEncoding.DecodeString(token)
 		if err != nil {
-			return nil
-		}
-		if info.IsDir() {
-			if info.Name() == ".git" {
-				return filepath.SkipDir
-			}
-			return nil
+			s.xmlError(w, http.StatusBadRequest, "InvalidArgument", "The continuation token provided is incorrect")
+			return
 		}
+		after = string(key)
+	}
+	if after != "" && sorted {
+		s.xmlError(w, http.StatusBadRequest, "InvalidArgument", "x-sort cannot be combined with continuation-token or start-after")
+		return
+	}
 
-		relPath, _ := filepath.Rel(root, path)
-		relPath = filepath.ToSlash(relPath)
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
 
-		if prefix != "" && !strings.HasPrefix(relPath, prefix) {
-			return nil
-		}
+	// The listing is encoded as it is walked so memory stays bounded by
+	// max-keys rather than by the size of the vault, and flushed every
+	// listFlushEvery entries so clients can parse it as it arrives. Element
+	// order inside ListBucketResult is not significant to S3 clients,
+	// which lets the counts that are only known at the end follow the
+	// Contents entries.
+	enc := xml.NewEncoder(w)
+	flusher := http.NewResponseController(w)
+	start := xml.StartElement{
+		Name: xml.Name{Local: "ListBucketResult"},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "xmlns"}, Value: s3Namespace}},
+	}
+	enc.EncodeToken(start)
+	encodeElement(enc, "Name", bucket)
+	encodeElement(enc, "Prefix", prefix)
+	encodeElement(enc, "MaxKeys", maxKeys)
+	if delimiter != "" {
+		encodeElement(enc, "Delimiter", delimiter)
+	}
+	if token != "" {
+		encodeElement(enc, "ContinuationToken", token)
+	}
+	if startAfter != "" {
+		encodeElement(enc, "StartAfter", startAfter)
+	}
 
-		if len(objects) >= maxKeys {
-			return filepath.SkipAll
+	// Objects and common prefixes both count towards max-keys
+	keyCount := 0
+	truncated := false
+	last := ""
+	next := func(key string) bool {
+		if keyCount >= maxKeys {
+			truncated = true
+			return false
 		}
-
-		etag := fmt.Sprintf("\"%s\"", hashSHA256([]byte(relPath+info.ModTime().String())))
-		objects = append(objects, ObjectInfo{
-			Key:          relPath,
-			LastModified: info.ModTime().UTC().Format(time.RFC3339),
-			ETag:         etag,
-			Size:         info.Size(),
+		if keyCount > 0 && keyCount%listFlushEvery == 0 {
+			enc.Flush()
+			flusher.Flush()
+		}
+		keyCount++
+		last = key
+		return true
+	}
+	object := func(key string, info os.FileInfo) error {
+		if !next(key) {
+			return errStopWalk
+		}
+		return encodeElement(enc, "Contents", ObjectInfo{
+			Key:          key,
+			LastModified: lastModified(info).Format(xmlTimeFormat),
+			ETag:         objectETag(key, info),
+			Size:         s.contentSize(s.keyFile(key), info),
 			StorageClass: "STANDARD",
 		})
-		return nil
-	})
+	}
+	if sorted {
+		// One more than max-keys, to tell whether the listing is truncated
+		walkByModTime(func(fn func(string, os.FileInfo) error) error {
+			return s.walkKeys(prefix, "", 0, fn, nil)
+		}, maxKeys+1, object)
+	} else {
+		s.walkKeys(prefix, after, depth, object, func(p string) error {
+			if !next(p) {
+				return errStopWalk
+			}
+			return encodeElement(enc, "CommonPrefixes", CommonPrefix{Prefix: p})
+		})
+	}
 
-	result := ListBucketResult{
-		Xmlns:       "http://s3.amazonaws.com/doc/2006-03-01/",
-		Name:        bucket,
-		Prefix:      prefix,
-		KeyCount:    len(objects),
-		MaxKeys:     maxKeys,
-		IsTruncated: false,
-		Contents:    objects,
+	encodeElement(enc, "KeyCount", keyCount)
+	encodeElement(enc, "IsTruncated", truncated)
+	if truncated && !sorted && last != "" {
+		encodeElement(enc, "NextContinuationToken", base64.RawURLEncoding.EncodeToString([]byte(last)))
 	}
+	enc.EncodeToken(start.End())
+	enc.Flush()
+}
 
-	w.Header().Set("Content-Type", "application/xml")
-	w.WriteHeader(http.StatusOK)
-	xml.NewEncoder(w).Encode(result)
+// SetReadOnly turns the rejection of PUTs and DELETEs on or off while the
+// handler is serving, as when a replica is promoted.
+func (s *Handler) SetReadOnly(readOnly bool) {
+	s.readOnly.Store(readOnly)
+}
+
+// objectSize returns the content size of the object at fullPath, if there
+// is one.
+func (s *Handler) objectSize(fullPath string) (int64, bool) {
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return 0, false
+	}
+	return s.contentSize(fullPath, info), true
+}
+
+func encodeElement(enc *xml.Encoder, name string, v any) error {
+	return enc.EncodeElement(v, xml.StartElement{Name: xml.Name{Local: name}})
 }
 
+// writeModeHeader selects how a PUT writes its body. It is a git3
+// extension: "append" adds the body to the end of the object (creating it
+// if needed); anything else but the default "overwrite" is rejected.
+const writeModeHeader = "X-Git3-Write-Mode"
+
 func (s *Handler) putObject(w http.ResponseWriter, r *http.Request, key string) {
-	fullPath := filepath.Join(s.dir, filepath.FromSlash(key))
+	fullPath, ok := s.objectPath(key)
+	if !ok {
+		s.xmlError(w, http.StatusBadRequest, "InvalidArgument", "Invalid object key")
+		return
+	}
 
-	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
-		s.xmlError(w, http.StatusInternalServerError, "InternalError", err.Error())
+	var appending bool
+	switch r.Header.Get(writeModeHeader) {
+	case "", "overwrite":
+	case "append":
+		appending = true
+	default:
+		s.xmlError(w, http.StatusBadRequest, "InvalidArgument", "Unsupported "+writeModeHeader)
+		return
+	}
+	if code, msg, ok := checkMetadata(r.Header); !ok {
+		s.xmlError(w, http.StatusBadRequest, code, msg)
+		return
+	}
+	sse, err := parseCustomerKey(r.Header)
+	if err != nil {
+		s.xmlError(w, http.StatusBadRequest, "InvalidArgument", err.Error())
+		return
+	}
+	if sse != nil && appending {
+		s.xmlError(w, http.StatusBadRequest, "InvalidRequest", "Objects encrypted with a customer key cannot be appended to")
+		return
+	}
+	ck, code, msg := parseChecksum(r.Header)
+	if code != "" {
+		s.xmlError(w, http.StatusBadRequest, code, msg)
+		return
+	}
+	length := r.ContentLength
+	if isAWSChunked(r) {
+		length, _ = strconv.ParseInt(r.Header.Get("X-Amz-Decoded-Content-Length"), 10, 64)
+	}
+	if s.maxObjectSize > 0 && length > s.maxObjectSize {
+		s.xmlError(w, http.StatusBadRequest, "EntityTooLarge", "Your proposed upload exceeds the maximum allowed object size")
+		return
+	}
+	if msg, ok := s.checkRepoSize(length); !ok {
+		s.xmlError(w, http.StatusInsufficientStorage, "RepositoryTooLarge", msg)
+		return
+	}
+	onlyCreate, ok := createOnly(r.Header)
+	if !ok {
+		s.xmlError(w, http.StatusNotImplemented, "NotImplemented", "If-None-Match on a write only supports *")
 		return
 	}
 
-	f, err := os.Create(fullPath)
+	meta := newObjectMeta(r.Header)
+	unlock := s.keys.lock(key)
+	defer unlock()
+
+	// Write to a temp file and rename it into place, so readers and git
+	// never see a partial object.
+	tmpDir := s.stateDir("tmp")
+	if err := s.files.MkdirAll(tmpDir, 0755); err != nil {
+		s.xmlError(w, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	f, err := s.files.CreateTemp(tmpDir, "put-*")
 	if err != nil {
 		s.xmlError(w, http.StatusInternalServerError, "InternalError", err.Error())
 		return
 	}
+	defer os.Remove(f.Name())
 	defer f.Close()
 
-	if _, err := io.Copy(f, r.Body); err != nil {
+	// Size and ETag come from the bytes actually received: chunked
+	// uploads carry no Content-Length to go by.
+	h := sha256.New()
+	dst := io.MultiWriter(f, h)
+	var existing int64
+	if appending {
+		// The object keeps the Content-Type and metadata it was created with
+		if info, err := os.Stat(fullPath); err == nil {
+			if prev, ok := s.meta.get(key, info); ok {
+				meta.ContentType, meta.Metadata, meta.MetadataNames = prev.ContentType, prev.Metadata, prev.MetadataNames
+			}
+		}
+		if existing, err = s.copyContent(dst, fullPath); err != nil {
+			if !s.sseError(w, err) {
+				s.xmlError(w, http.StatusInternalServerError, "InternalError", err.Error())
+			}
+			return
+		}
+	}
+	// The checksum only covers the body, even when appending
+	if ck != nil {
+		dst = io.MultiWriter(dst, ck.hash)
+	}
+	body := r.Body
+	var chunked *chunkedReader
+	if isAWSChunked(r) {
+		chunked = newChunkedReader(r.Body)
+		body = io.NopCloser(chunked)
+	}
+	if s.maxObjectSize > 0 {
+		body = http.MaxBytesReader(w, body, max(s.maxObjectSize-existing, 0))
+	}
+	read, err := io.Copy(dst, body)
+	n := existing + read
+	// A body that ends before its declared length means the client went
+	// away; storing what arrived would silently truncate the object.
+	short := length > 0 && read < length
+	if err == nil && short {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		switch {
+		case errors.As(err, &tooLarge):
+			s.xmlError(w, http.StatusBadRequest, "EntityTooLarge", "Your proposed upload exceeds the maximum allowed object size")
+		case short:
+			s.xmlError(w, http.StatusBadRequest, "IncompleteBody", fmt.Sprintf("You did not provide the number of bytes specified by the Content-Length HTTP header (got %d of %d)", read, length))
+		case errors.Is(err, errBadChunk) || errors.Is(err, io.ErrUnexpectedEOF):
+			s.xmlError(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+		default:
+			s.xmlError(w, http.StatusInternalServerError, "InternalError", err.Error())
+		}
+		return
+	}
+	if ck != nil {
+		trailers := r.Trailer
+		if chunked != nil {
+			trailers = chunked.trailers
+		}
+		if code, msg, ok := ck.verify(trailers); !ok {
+			s.xmlError(w, http.StatusBadRequest, code, msg)
+			return
+		}
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	etag := fmt.Sprintf("\"%s\"", sum[:32])
+	if err := f.Close(); err != nil {
+		s.xmlError(w, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	// A retry of a PUT already stored is answered as that PUT was. An
+	// append is never a retry, and content under a customer key can't be
+	// compared.
+	token := r.Header.Get(clientTokenHeader)
+	var fingerprint string
+	if s.idempotency != nil && !appending && sse == nil {
+		fingerprint = putFingerprint(sum, meta)
+		if etag, ok := s.idempotency.replay(key, token, fingerprint, fullPath); ok {
+			if ck != nil {
+				w.Header().Set(checksumHeader(ck.algorithm), ck.value())
+			}
+			w.Header().Set("ETag", etag)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+	}
+	if onlyCreate && s.preconditionFailed(w, fullPath) {
+		return
+	}
+	toLFS, err := s.store(f.Name(), fullPath, key, n, sum, sse)
+	if err != nil {
 		s.xmlError(w, http.StatusInternalServerError, "InternalError", err.Error())
 		return
 	}
 
-	f.Seek(0, 0)
-	h := sha256.New()
-	io.Copy(h, f)
-	etag := fmt.Sprintf("\"%s\"", hex.EncodeToString(h.Sum(nil))[:32])
+	// An appended object's checksum would only cover the last write
+	if ck != nil && !appending {
+		meta.ChecksumAlgorithm, meta.Checksum = ck.algorithm, ck.value()
+		w.Header().Set(checksumHeader(ck.algorithm), ck.value())
+	}
+	if err := s.meta.put(key, fullPath, meta); err != nil {
+		s.logger.Printf("[s3] save metadata of %s: %v", key, err)
+	}
+	if fingerprint != "" {
+		s.idempotency.record(key, token, fingerprint, fullPath, etag)
+	}
 
+	if sse != nil {
+		sse.setHeaders(w)
+	}
 	w.Header().Set("ETag", etag)
 	w.WriteHeader(http.StatusOK)
+	s.written(r, fullPath, key, n, etag, toLFS)
+}
+
+// store puts the finished temp file tmp, holding n bytes of content with
+// the SHA-256 sum, in place as the object at fullPath: sealed with sse or
+// the handler's cipher, and deduplicated or moved to LFS. It reports
+// whether the object went to LFS.
+func (s *Handler) store(tmp, fullPath, key string, n int64, sum string, sse *customerKey) (toLFS bool, err error) {
+	if err := os.Chmod(tmp, 0644); err != nil {
+		return false, err
+	}
+	switch {
+	case sse != nil:
+		err = sse.sealFile(tmp)
+	case s.cipher != nil:
+		err = s.cipher.sealFile(tmp)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	// LFS keeps its own content-addressed store. Content sealed with a
+	// customer key isn't shared, since only its key can read it.
+	toLFS = s.lfs != nil && s.lfs.Track(key, n)
+	if !toLFS && sse == nil {
+		if err := s.blobs.adopt(tmp, sum); err != nil {
+			return false, err
+		}
+	}
+	return toLFS, s.install(tmp, fullPath, key, toLFS)
+}
 
-	s.syncer.Trigger()
+// written triggers a sync for an object just written and tells whoever
+// is watching.
+func (s *Handler) written(r *http.Request, fullPath, key string, n int64, etag string, toLFS bool) {
+	s.cache.remove(key)
+	changed := s.changedFiles(fullPath)
+	if toLFS {
+		// Tracking the key may have added a pattern
+		changed = append(changed, ".gitattributes")
+	}
+	s.trigger(r, changed...)
+	s.watch("put", key)
+	s.notify(EventObjectCreatedPut, key, n, etag)
+}
+
+// install moves the finished temp file tmp into place at fullPath, and
+// replaces it with an LFS pointer if toLFS. It holds the write lock
+// throughout, so a commit never stages the object between the two.
+func (s *Handler) install(tmp, fullPath, key string, toLFS bool) error {
+	if s.writeLock != nil {
+		s.writeLock.Lock()
+		defer s.writeLock.Unlock()
+	}
+	if err := s.files.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		return err
+	}
+	replaced := s.blobs.blobOf(fullPath)
+	if err := s.files.Rename(tmp, fullPath); err != nil {
+		return err
+	}
+	s.blobs.release(replaced)
+	if toLFS {
+		if err := s.lfs.Clean(key, fullPath); err != nil {
+			return err
+		}
+	}
+	if s.layout != nil {
+		if err := s.layout.add(fullPath, key); err != nil {
+			return err
+		}
+	}
+	after, _ := s.objectSize(fullPath)
+	s.stats.set(key, after)
+	return nil
 }
 
 func (s *Handler) getObject(w http.ResponseWriter, r *http.Request, key string) {
-	fullPath := filepath.Join(s.dir, filepath.FromSlash(key))
+	fullPath, ok := s.objectPath(key)
+	if !ok {
+		s.xmlError(w, http.StatusBadRequest, "InvalidArgument", "Invalid object key")
+		return
+	}
 
 	info, err := os.Stat(fullPath)
 	if err != nil {
-		s.xmlError(w, http.StatusNotFound, "NoSuchKey", "Object not found")
+		s.noSuchKey(w, r)
 		return
 	}
-
-	f, err := os.Open(fullPath)
+	sse, err := parseCustomerKey(r.Header)
 	if err != nil {
-		s.xmlError(w, http.StatusInternalServerError, "InternalError", err.Error())
+		s.xmlError(w, http.StatusBadRequest, "InvalidArgument", err.Error())
 		return
 	}
-	defer f.Close()
 
-	w.Header().Set("Content-Length", fmt.Sprintf("%d", info.Size()))
-	w.Header().Set("Last-Modified", info.ModTime().UTC().Format(http.TimeFormat))
-	w.WriteHeader(http.StatusOK)
-	io.Copy(w, f)
+	// Content read with a customer key is never cached
+	var content io.ReadSeeker
+	if data, ok := s.cache.get(key, info); ok && sse == nil {
+		content = bytes.NewReader(data)
+	} else {
+		f, err := s.openContent(fullPath, sse)
+		if err != nil {
+			if !s.sseError(w, err) {
+				s.xmlError(w, http.StatusInternalServerError, "InternalError", err.Error())
+			}
+			return
+		}
+		defer f.Close()
+		content = f
+		if sse != nil {
+			sse.setHeaders(w)
+		} else if data, err := s.cache.read(key, info, f); err != nil {
+			s.xmlError(w, http.StatusInternalServerError, "InternalError", err.Error())
+			return
+		} else if data != nil {
+			content = bytes.NewReader(data)
+		}
+	}
+
+	// ServeContent handles Range and conditional requests, and copies
+	// with sendfile where the platform has it.
+	meta, _ := s.meta.get(key, info)
+	w.Header().Set("ETag", objectETag(key, info))
+	s.setChecksumHeader(w, r, meta)
+	if t := s.contentType(key); t != "" {
+		w.Header().Set("Content-Type", t)
+	}
+	meta.setHeaders(w)
+	http.ServeContent(w, r, path.Base(key), lastModified(info), content)
 }
 
 func (s *Handler) headObject(w http.ResponseWriter, r *http.Request, key string) {
-	fullPath := filepath.Join(s.dir, filepath.FromSlash(key))
+	fullPath, ok := s.objectPath(key)
+	if !ok {
+		s.xmlError(w, http.StatusBadRequest, "InvalidArgument", "Invalid object key")
+		return
+	}
 
 	info, err := os.Stat(fullPath)
 	if err != nil {
 		s.xmlError(w, http.StatusNotFound, "NoSuchKey", "Object not found")
 		return
 	}
+	sse, err := parseCustomerKey(r.Header)
+	if err != nil {
+		s.xmlError(w, http.StatusBadRequest, "InvalidArgument", err.Error())
+		return
+	}
+	var size int64
+	if data, ok := s.cache.get(key, info); ok && sse == nil {
+		// Only objects readable without a customer key are cached
+		size = int64(len(data))
+	} else {
+		if err := s.checkCustomerKey(fullPath, sse); err != nil {
+			if !s.sseError(w, err) {
+				s.xmlError(w, http.StatusInternalServerError, "InternalError", err.Error())
+			}
+			return
+		}
+		size = s.contentSize(fullPath, info)
+		if sse != nil {
+			sse.setHeaders(w)
+			size = s.storedSize(fullPath, info) - sseOverhead()
+		}
+	}
 
-	etag := fmt.Sprintf("\"%s\"", hashSHA256([]byte(key+info.ModTime().String())))
-	w.Header().Set("Content-Length", fmt.Sprintf("%d", info.Size()))
-	w.Header().Set("ETag", etag)
-	w.Header().Set("Last-Modified", info.ModTime().UTC().Format(http.TimeFormat))
+	meta, _ := s.meta.get(key, info)
+	t := s.contentType(key)
+	if t == "" {
+		t = mime.TypeByExtension(path.Ext(key))
+	}
+	if t != "" {
+		w.Header().Set("Content-Type", t)
+	}
+	meta.setHeaders(w)
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", size))
+	w.Header().Set("ETag", objectETag(key, info))
+	w.Header().Set("Last-Modified", lastModified(info).Format(http.TimeFormat))
+	s.setChecksumHeader(w, r, meta)
 	w.WriteHeader(http.StatusOK)
 }
 
-func (s *Handler) deleteObject(w http.ResponseWriter, r *http.Request, key string) {
-	fullPath := filepath.Join(s.dir, filepath.FromSlash(key))
+// contentType returns the configured Content-Type for key, or "" to leave
+// it to the extension and content sniffing.
+func (s *Handler) contentType(key string) string {
+	ext := strings.ToLower(path.Ext(key))
+	if t, ok := s.contentTypes[ext]; ok {
+		return t
+	}
+	if ext == "" {
+		return s.defaultContentType
+	}
+	return ""
+}
 
-	if err := os.Remove(fullPath); err != nil && !os.IsNotExist(err) {
+// etagKeyHeader names the key of the object returned by getObjectByETag.
+const etagKeyHeader = "X-Git3-Key"
+
+// getObjectByETag serves the first object, in key order, whose ETag is the
+// etag query parameter. This is an extension to S3, for clients that cache
+// by ETag and want to check they hold the right content without trusting
+// the key. It scans the bucket, comparing objectETag for every object.
+func (s *Handler) getObjectByETag(w http.ResponseWriter, r *http.Request, bucket string) {
+	if bucket != s.bucket {
+		s.xmlError(w, http.StatusNotFound, "NoSuchBucket", "Bucket not found")
+		return
+	}
+	etag := r.URL.Query().Get("etag")
+	if !strings.HasPrefix(etag, "\"") {
+		etag = "\"" + etag + "\""
+	}
+
+	var found string
+	err := s.walkKeys("", "", 0, func(key string, info fs.FileInfo) error {
+		if objectETag(key, info) == etag {
+			found = key
+			return errStopWalk
+		}
+		return nil
+	}, nil)
+	if err != nil {
+		s.xmlError(w, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	if found == "" {
+		s.xmlError(w, http.StatusNotFound, "NoSuchKey", "No object has this ETag")
+		return
+	}
+	w.Header().Set(etagKeyHeader, found)
+	s.getObject(w, r, found)
+}
+
+// xmlTimeFormat is how S3 writes times in XML responses (ISO 8601).
+const xmlTimeFormat = "2006-01-02T15:04:05.000Z"
+
+// lastModified is an object's modification time as every response
+// reports it: in UTC, to the second, which is all Last-Modified can carry.
+func lastModified(info os.FileInfo) time.Time {
+	return info.ModTime().UTC().Truncate(time.Second)
+}
+
+// objectETag is the ETag reported for an existing object. It changes
+// whenever the file is rewritten, without hashing the content.
+func objectETag(key string, info os.FileInfo) string {
+	return fmt.Sprintf("\"%s\"", hashSHA256([]byte(key+info.ModTime().String())))
+}
+
+func (s *Handler) deleteObject(w http.ResponseWriter, r *http.Request, key string) {
+	fullPath, ok := s.objectPath(key)
+	if !ok {
+		s.xmlError(w, http.StatusBadRequest, "InvalidArgument", "Invalid object key")
+		return
+	}
+	unlock := s.keys.lock(key)
+	defer unlock()
+	if err := s.removeObject(key, fullPath); err != nil {
 		s.xmlError(w, http.StatusInternalServerError, "InternalError", err.Error())
 		return
 	}
 
-	// Clean up empty parent directories
-	dir := filepath.Dir(fullPath)
+	w.WriteHeader(http.StatusNoContent)
+	s.trigger(r, s.changedFiles(fullPath)...)
+	s.watch("delete", key)
+	s.notify(EventObjectRemoved, key, 0, "")
+}
+
+// removeObject removes key's file, at fullPath, with everything kept
+// about it, and the directories left empty. Removing a missing object
+// succeeds. Caller must hold the key's lock.
+func (s *Handler) removeObject(key, fullPath string) error {
+	blob := s.blobs.blobOf(fullPath)
+	if err := os.Remove(fullPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	s.blobs.release(blob)
+	if s.layout != nil {
+		s.layout.remove(fullPath, key)
+	}
+	s.stats.remove(key)
+	s.meta.remove(key)
+	s.cache.remove(key)
+	s.removeEmptyDirs(filepath.Dir(fullPath))
+	return nil
+}
+
+// removeEmptyDirs removes dir, an object's directory, and its parents for
+// as long as they are empty.
+func (s *Handler) removeEmptyDirs(dir string) {
 	for dir != s.dir {
 		entries, _ := os.ReadDir(dir)
 		if len(entries) > 0 {
@@ -247,13 +999,194 @@ func (s *Handler) deleteObject(w http.ResponseWriter, r *http.Request, key strin
 		os.Remove(dir)
 		dir = filepath.Dir(dir)
 	}
+}
 
-	w.WriteHeader(http.StatusNoContent)
-	s.syncer.Trigger()
+// watch tells the watcher, if any, about a changed object.
+func (s *Handler) watch(op, key string) {
+	if s.watcher != nil {
+		s.watcher.ObjectsChanged(op, []string{key})
+	}
+}
+
+// noSuchKey answers a GET for a missing object. Browsers get the error
+// document when one is configured; S3 clients always get the XML error.
+func (s *Handler) noSuchKey(w http.ResponseWriter, r *http.Request) {
+	if s.errorDocument == "" || !strings.Contains(r.Header.Get("Accept"), "text/html") {
+		s.xmlError(w, http.StatusNotFound, "NoSuchKey", "Object not found")
+		return
+	}
+
+	fullPath, ok := s.objectPath(s.errorDocument)
+	if !ok {
+		s.xmlError(w, http.StatusNotFound, "NoSuchKey", "Object not found")
+		return
+	}
+	f, err := s.openContent(fullPath, nil)
+	if err != nil {
+		s.xmlError(w, http.StatusNotFound, "NoSuchKey", "Object not found")
+		return
+	}
+	defer f.Close()
+
+	contentType := mime.TypeByExtension(path.Ext(s.errorDocument))
+	if contentType == "" {
+		contentType = "text/html; charset=utf-8"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusNotFound)
+	io.Copy(w, f)
+}
+
+// openStored opens an object as it is stored, looking through LFS
+// pointers but not decrypting it.
+func (s *Handler) openStored(fullPath string) (*os.File, error) {
+	if s.lfs != nil {
+		return s.lfs.Open(fullPath)
+	}
+	return s.files.Open(fullPath)
+}
+
+// openContent opens an object's content, looking through LFS pointers
+// and decrypting it. sse is the customer key sent with the request, which
+// objects written with SSE-C need.
+func (s *Handler) openContent(fullPath string, sse *customerKey) (io.ReadSeekCloser, error) {
+	f, err := s.openStored(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	header, err := readSSEHeader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	switch {
+	case header != nil && sse == nil:
+		f.Close()
+		return nil, errSSECRequired
+	case header != nil:
+		return sse.openFile(f)
+	case sse != nil:
+		f.Close()
+		return nil, errSSECNotApplicable
+	case s.cipher != nil:
+		return s.cipher.openFile(f)
+	}
+	return f, nil
+}
+
+// copyContent copies an object's content to w, looking through LFS
+// pointers. A missing object copies nothing.
+func (s *Handler) copyContent(w io.Writer, fullPath string) (int64, error) {
+	f, err := s.openContent(fullPath, nil)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return io.Copy(w, f)
+}
+
+// contentSize returns an object's content size, looking through LFS
+// pointers and encryption. Objects written with SSE-C are taken to be
+// encrypted like the rest, which is close but not exact; objects found
+// stored in the clear, as a pull can leave them, are served as they are.
+func (s *Handler) contentSize(fullPath string, info os.FileInfo) int64 {
+	size := s.storedSize(fullPath, info)
+	if s.cipher != nil {
+		if magic, _ := readMagic(fullPath); magic != encryptedMagic && magic != sseMagic {
+			return size
+		}
+		size = s.cipher.contentSize(size)
+	}
+	return size
+}
+
+// storedSize returns an object's size as stored, looking through LFS
+// pointers but not encryption.
+func (s *Handler) storedSize(fullPath string, info os.FileInfo) int64 {
+	if s.lfs != nil {
+		return s.lfs.Size(fullPath, info.Size())
+	}
+	return info.Size()
+}
+
+// stateDirName is the directory under the root where the handler keeps its
+// own state (temp files, deduplicated blobs). It is never listed, cannot be
+// addressed as a key, and is excluded from git.
+const stateDirName = ".git3"
+
+// stateDir returns a path inside the handler's state directory.
+func (s *Handler) stateDir(elem ...string) string {
+	return filepath.Join(append([]string{s.dir, stateDirName}, elem...)...)
+}
+
+// relPath returns the slash-separated path of fullPath, a path from
+// objectPath, relative to the root.
+func (s *Handler) relPath(fullPath string) string {
+	rel, _ := filepath.Rel(s.dir, fullPath)
+	return filepath.ToSlash(rel)
+}
+
+// reservedName reports whether a path segment belongs to git or to the
+// handler rather than to the bucket.
+func reservedName(name string) bool {
+	return name == ".git" || name == stateDirName
+}
+
+// objectPath maps a key to its file under the handler's root: the path
+// the key names, or in a hashed layout the path its hash names. Keys that
+// would resolve outside the root, or into git's or the handler's own
+// metadata, are rejected in either.
+func (s *Handler) objectPath(key string) (string, bool) {
+	fullPath := filepath.Join(s.dir, filepath.FromSlash(key))
+	rel, err := filepath.Rel(s.dir, fullPath)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	for _, part := range strings.Split(filepath.ToSlash(rel), "/") {
+		if reservedName(part) {
+			return "", false
+		}
+	}
+	if s.layout != nil {
+		return s.layout.path(key), true
+	}
+	return fullPath, true
 }
 
 func (s *Handler) xmlError(w http.ResponseWriter, status int, code, message string) {
+	if status >= http.StatusInternalServerError {
+		s.logger.Printf("[s3] %s: %s", code, message)
+	}
+	w.Header().Set(bucketRegionHeader, s.region)
+	writeXMLError(w, status, code, message)
+}
+
+// writeXMLError writes an S3 error response.
+func writeXMLError(w http.ResponseWriter, status int, code, message string) {
+	writeErrorResponse(w, status, ErrorResponse{Code: code, Message: message})
+}
+
+// writeErrorResponse writes resp, with the response's request ID.
+func writeErrorResponse(w http.ResponseWriter, status int, resp ErrorResponse) {
+	resp.RequestID = w.Header().Get(requestIDHeader)
 	w.Header().Set("Content-Type", "application/xml")
 	w.WriteHeader(status)
-	xml.NewEncoder(w).Encode(ErrorResponse{Code: code, Message: message})
+	xml.NewEncoder(w).Encode(resp)
+}
+
+// bucketRegionHeader names the bucket's region on HeadBucket and error
+// responses, as S3's do, for clients that find the region that way.
+const bucketRegionHeader = "X-Amz-Bucket-Region"
+
+// requestIDHeader identifies a response, as S3's do, so a client's report
+// of a failed request can be matched with the server's log.
+const requestIDHeader = "X-Amz-Request-Id"
+
+// newRequestID returns a random ID in the style of S3's: 16 hex digits.
+func newRequestID() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return strings.ToUpper(hex.EncodeToString(b[:]))
 }
@@ -1,20 +1,30 @@
 package s3
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/xml"
+	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
 	"testing"
+	"testing/iotest"
+	"time"
+
+	"git3/internal/lfs"
 )
 
 // noopSyncer implements Syncer but does nothing.
 type noopSyncer struct{}
 
-func (noopSyncer) Trigger() {}
+func (noopSyncer) Trigger(...string) {}
 
 func newTestHandler(t *testing.T) (*Handler, string) {
 	t.Helper()
@@ -114,6 +124,27 @@ func TestDeleteObject(t *testing.T) {
 	}
 }
 
+func TestResponseHeaders(t *testing.T) {
+	h, _ := newTestHandler(t)
+	serve(h, "PUT", "/vault/a.md", "a")
+
+	del := serve(h, "DELETE", "/vault/a.md", "")
+	if del.Code != http.StatusNoContent || del.Header().Get("Date") == "" || len(del.Header().Get("X-Amz-Request-Id")) != 16 {
+		t.Fatalf("DELETE got %d with headers %v", del.Code, del.Header())
+	}
+
+	missing := serve(h, "GET", "/vault/a.md", "")
+	id := missing.Header().Get("X-Amz-Request-Id")
+	if id == "" || id == del.Header().Get("X-Amz-Request-Id") {
+		t.Fatalf("request IDs %q and %q", del.Header().Get("X-Amz-Request-Id"), id)
+	}
+	var errResp ErrorResponse
+	xml.Unmarshal(missing.Body.Bytes(), &errResp)
+	if errResp.RequestID != id {
+		t.Fatalf("error RequestId = %q, header %q", errResp.RequestID, id)
+	}
+}
+
 func TestDeleteNonexistent(t *testing.T) {
 	h, _ := newTestHandler(t)
 
@@ -205,6 +236,99 @@ func TestCORSOptions(t *testing.T) {
 	}
 }
 
+func TestCORSDisabled(t *testing.T) {
+	h := NewHandlerWithOptions(t.TempDir(), WithCORS(false))
+	serve(h, "PUT", "/vault/test.md", "hello")
+
+	req := httptest.NewRequest("OPTIONS", "/vault/test.md", nil)
+	req.Header.Set("Origin", "https://example.com")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("OPTIONS got status %d, want %d", w.Code, http.StatusMethodNotAllowed)
+	}
+
+	req = httptest.NewRequest("GET", "/vault/test.md", nil)
+	req.Header.Set("Origin", "https://example.com")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET got status %d", w.Code)
+	}
+	for name := range w.Header() {
+		if strings.HasPrefix(name, "Access-Control-") {
+			t.Fatalf("CORS header %s sent with CORS disabled", name)
+		}
+	}
+}
+
+func TestCORSOrigins(t *testing.T) {
+	h := NewHandlerWithOptions(t.TempDir(), WithCORSOrigins("https://app.example.com"))
+
+	preflight := func(origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("OPTIONS", "/vault/test.md", nil)
+		req.Header.Set("Origin", origin)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+	if w := preflight("https://app.example.com"); w.Code != http.StatusOK || w.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" {
+		t.Fatalf("allowed origin got %d, Allow-Origin %q", w.Code, w.Header().Get("Access-Control-Allow-Origin"))
+	}
+	if w := preflight("https://evil.example.com"); w.Code != http.StatusForbidden || w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Fatalf("other origin got %d, Allow-Origin %q", w.Code, w.Header().Get("Access-Control-Allow-Origin"))
+	}
+}
+
+func TestCORSPreflight(t *testing.T) {
+	h := NewHandlerWithOptions(t.TempDir(), WithCORSHeaders("Content-Type", "X-Amz-Date", "X-Amz-Meta-Author"), WithCORSMaxAge(10*time.Minute))
+	preflight := func(target, method, headers string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("OPTIONS", target, nil)
+		req.Header.Set("Origin", "https://app.example.com")
+		req.Header.Set("Access-Control-Request-Method", method)
+		req.Header.Set("Access-Control-Request-Headers", headers)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	w := preflight("/vault/notes/a.md", "PUT", "content-type, x-amz-meta-author")
+	if w.Code != http.StatusOK {
+		t.Fatalf("preflight got %d", w.Code)
+	}
+	for name, want := range map[string]string{
+		"Access-Control-Allow-Origin":  "*",
+		"Access-Control-Allow-Methods": "PUT",
+		"Access-Control-Allow-Headers": "content-type, x-amz-meta-author",
+		"Access-Control-Max-Age":       "600",
+	} {
+		if got := w.Header().Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+
+	for _, tc := range []struct{ target, method, headers string }{
+		{"/vault/notes/a.md", "PUT", "content-type, x-secret"}, // a header not allowed
+		{"/vault/notes/a.md", "PATCH", ""},                     // a method no resource takes
+		{"/vault", "PUT", ""},                                  // a method the bucket doesn't take
+	} {
+		if w := preflight(tc.target, tc.method, tc.headers); w.Code != http.StatusForbidden || w.Header().Get("Access-Control-Allow-Methods") != "" {
+			t.Errorf("preflight for %s %s with %q got %d", tc.method, tc.target, tc.headers, w.Code)
+		}
+	}
+	if w := preflight("/vault?prefix=notes/", "DELETE", ""); w.Code != http.StatusOK {
+		t.Errorf("preflight for a delete by prefix got %d", w.Code)
+	}
+
+	h.SetReadOnly(true)
+	if w := preflight("/vault/notes/a.md", "PUT", ""); w.Code != http.StatusForbidden {
+		t.Errorf("preflight for a PUT to a read-only bucket got %d", w.Code)
+	}
+	if w := preflight("/vault/notes/a.md", "GET", ""); w.Code != http.StatusOK || w.Header().Get("Access-Control-Allow-Headers") != "" {
+		t.Errorf("preflight for a GET got %d, Allow-Headers %q", w.Code, w.Header().Get("Access-Control-Allow-Headers"))
+	}
+}
+
 func TestHeadBucket(t *testing.T) {
 	h, _ := newTestHandler(t)
 
@@ -292,6 +416,40 @@ func TestAuthRequired(t *testing.T) {
 	if errResp.Code != "AccessDenied" {
 		t.Fatalf("error code = %q, want AccessDenied", errResp.Code)
 	}
+	if got := w.Header().Get("X-Amz-Bucket-Region"); got != "us-east-1" {
+		t.Fatalf("x-amz-bucket-region = %q, want us-east-1", got)
+	}
+}
+
+func TestAuthWrongRegion(t *testing.T) {
+	dir := t.TempDir()
+	h := NewHandler(dir, "vault", "testkey", "testsecret", "us-east-1", noopSyncer{})
+
+	req := httptest.NewRequest("GET", "/vault?list-type=2", nil)
+	signFor(req, "testkey", "testsecret", "eu-west-1", "host", "x-amz-date")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("wrong region got status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	var errResp ErrorResponse
+	xml.Unmarshal(w.Body.Bytes(), &errResp)
+	if errResp.Code != "AuthorizationHeaderMalformed" || errResp.Region != "us-east-1" || !strings.Contains(errResp.Message, "'eu-west-1'") {
+		t.Fatalf("error = %+v, want AuthorizationHeaderMalformed naming both regions", errResp)
+	}
+	if got := w.Header().Get("X-Amz-Bucket-Region"); got != "us-east-1" {
+		t.Fatalf("x-amz-bucket-region = %q, want us-east-1", got)
+	}
+
+	// Signed for the right region, HeadBucket names it too
+	req = httptest.NewRequest("HEAD", "/vault", nil)
+	signFor(req, "testkey", "testsecret", "us-east-1", "host", "x-amz-date")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Header().Get("X-Amz-Bucket-Region") != "us-east-1" {
+		t.Fatalf("HeadBucket got %d with region %q", w.Code, w.Header().Get("X-Amz-Bucket-Region"))
+	}
 }
 
 func TestGetObjectNotFound(t *testing.T) {
@@ -305,3 +463,362 @@ func TestGetObjectNotFound(t *testing.T) {
 		t.Fatalf("GET missing got status %d, want %d", w.Code, http.StatusNotFound)
 	}
 }
+
+func TestGetObjectRange(t *testing.T) {
+	h, dir := newTestHandler(t)
+	os.WriteFile(filepath.Join(dir, "a.md"), []byte("0123456789"), 0644)
+
+	req := httptest.NewRequest("GET", "/vault/a.md", nil)
+	req.Header.Set("Range", "bytes=2-5")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	if w.Code != http.StatusPartialContent || w.Body.String() != "2345" {
+		t.Fatalf("range GET got %d %q, want 206 \"2345\"", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("Content-Range"); got != "bytes 2-5/10" {
+		t.Fatalf("Content-Range = %q", got)
+	}
+}
+
+func TestGetObjectNotModified(t *testing.T) {
+	h, dir := newTestHandler(t)
+	path := filepath.Join(dir, "a.md")
+	os.WriteFile(path, []byte("a"), 0644)
+	mtime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	os.Chtimes(path, mtime, mtime)
+
+	req := httptest.NewRequest("GET", "/vault/a.md", nil)
+	req.Header.Set("If-Modified-Since", mtime.Format(http.TimeFormat))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Fatalf("If-Modified-Since GET got %d with %d bytes, want 304", w.Code, w.Body.Len())
+	}
+
+	req = httptest.NewRequest("GET", "/vault/a.md", nil)
+	req.Header.Set("If-None-Match", w.Header().Get("ETag"))
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusNotModified {
+		t.Fatalf("If-None-Match GET got %d, want 304", w.Code)
+	}
+}
+
+func TestPutAppend(t *testing.T) {
+	h, dir := newTestHandler(t)
+
+	appendBody := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", "/vault/journal.md", strings.NewReader(body))
+		req.Header.Set("X-Git3-Write-Mode", "append")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("append got %d: %s", w.Code, w.Body.String())
+		}
+		return w
+	}
+
+	appendBody("monday\n")
+	w := appendBody("tuesday\n")
+	data, _ := os.ReadFile(filepath.Join(dir, "journal.md"))
+	if string(data) != "monday\ntuesday\n" {
+		t.Fatalf("journal.md = %q after two appends", data)
+	}
+	sum := sha256.Sum256(data)
+	if want := `"` + hex.EncodeToString(sum[:])[:32] + `"`; w.Header().Get("ETag") != want {
+		t.Fatalf("ETag = %s, want %s for the whole object", w.Header().Get("ETag"), want)
+	}
+
+	// A plain PUT still overwrites
+	put(t, h, "journal.md", "fresh")
+	if data, _ := os.ReadFile(filepath.Join(dir, "journal.md")); string(data) != "fresh" {
+		t.Fatalf("journal.md = %q after overwrite", data)
+	}
+
+	req := httptest.NewRequest("PUT", "/vault/journal.md", strings.NewReader("x"))
+	req.Header.Set("X-Git3-Write-Mode", "prepend")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("unknown write mode got %d, want 400", w.Code)
+	}
+}
+
+func TestConcurrentAppends(t *testing.T) {
+	h, dir := newTestHandler(t)
+
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest("PUT", "/vault/log.txt", strings.NewReader("x"))
+			req.Header.Set("X-Git3-Write-Mode", "append")
+			h.ServeHTTP(httptest.NewRecorder(), req)
+		}()
+	}
+	wg.Wait()
+
+	if data, _ := os.ReadFile(filepath.Join(dir, "log.txt")); len(data) != 20 {
+		t.Fatalf("log.txt has %d bytes after 20 concurrent appends", len(data))
+	}
+}
+
+func TestObjectInWrongBucket(t *testing.T) {
+	h, dir := newTestHandler(t)
+
+	req := httptest.NewRequest("PUT", "/wrongbucket/notes/a.md", strings.NewReader("a"))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "<Code>NoSuchBucket</Code>") {
+		t.Fatalf("PUT to wrong bucket got %d %s, want NoSuchBucket", w.Code, w.Body.String())
+	}
+	if _, err := os.Stat(filepath.Join(dir, "notes", "a.md")); !os.IsNotExist(err) {
+		t.Fatal("object was written despite the wrong bucket")
+	}
+}
+
+func TestKeyCannotEscapeRoot(t *testing.T) {
+	parent := t.TempDir()
+	root := filepath.Join(parent, "vault")
+	os.MkdirAll(root, 0755)
+	os.WriteFile(filepath.Join(parent, "secret.txt"), []byte("secret"), 0644)
+	h := NewHandler(root, "vault", "", "", "us-east-1", noopSyncer{})
+
+	for _, path := range []string{"/vault/../secret.txt", "/vault/a/../../secret.txt", "/vault/.git/config"} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.URL.Path = path
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("GET %s got status %d, want %d", path, w.Code, http.StatusBadRequest)
+		}
+	}
+
+	req := httptest.NewRequest("PUT", "/", strings.NewReader("x"))
+	req.URL.Path = "/vault/../escaped.txt"
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if _, err := os.Stat(filepath.Join(parent, "escaped.txt")); !os.IsNotExist(err) {
+		t.Fatal("PUT wrote outside the handler root")
+	}
+}
+
+func TestPutLFSObject(t *testing.T) {
+	dir := t.TempDir()
+	l, err := lfs.New(lfs.Config{Root: dir, GitDir: filepath.Join(dir, ".git"), Patterns: []string{"*.png"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandlerWithOptions(dir, WithLFS(l))
+
+	req := httptest.NewRequest("PUT", "/vault/img/cat.png", strings.NewReader("fake image bytes"))
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	onDisk, _ := os.ReadFile(filepath.Join(dir, "img", "cat.png"))
+	if _, ok := lfs.DecodePointer(onDisk); !ok {
+		t.Fatalf("expected a pointer file on disk, got %q", onDisk)
+	}
+
+	req = httptest.NewRequest("GET", "/vault/img/cat.png", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if got := w.Body.String(); got != "fake image bytes" {
+		t.Fatalf("GET body = %q, want real content", got)
+	}
+
+	req = httptest.NewRequest("HEAD", "/vault/img/cat.png", nil)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if got := w.Header().Get("Content-Length"); got != "16" {
+		t.Fatalf("HEAD Content-Length = %q, want 16", got)
+	}
+}
+
+func TestPutChunkedBody(t *testing.T) {
+	h, dir := newTestHandler(t)
+
+	body := strings.Repeat("chunked body ", 1000)
+	req := httptest.NewRequest("PUT", "/vault/chunked.md", io.NopCloser(strings.NewReader(body)))
+	req.ContentLength = -1
+	req.TransferEncoding = []string{"chunked"}
+	req.Header.Del("Content-Length")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT got status %d, want %d", w.Code, http.StatusOK)
+	}
+	stored, _ := os.ReadFile(filepath.Join(dir, "chunked.md"))
+	if string(stored) != body {
+		t.Fatalf("stored %d bytes, want %d", len(stored), len(body))
+	}
+	sum := sha256.Sum256([]byte(body))
+	if want := `"` + hex.EncodeToString(sum[:])[:32] + `"`; w.Header().Get("ETag") != want {
+		t.Fatalf("ETag = %s, want %s", w.Header().Get("ETag"), want)
+	}
+}
+
+func TestPutIncompleteBody(t *testing.T) {
+	h, dir := newTestHandler(t)
+
+	for name, body := range map[string]io.Reader{
+		"dropped": io.MultiReader(strings.NewReader(strings.Repeat("x", 40)), iotest.ErrReader(errors.New("connection reset by peer"))),
+		"short":   strings.NewReader(strings.Repeat("x", 40)),
+	} {
+		req := httptest.NewRequest("PUT", "/vault/"+name+".md", body)
+		req.ContentLength = 100
+		w := serveRequest(h, req)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "<Code>IncompleteBody</Code>") {
+			t.Fatalf("%s: PUT got %d: %s", name, w.Code, w.Body)
+		}
+		if _, err := os.Stat(filepath.Join(dir, name+".md")); !os.IsNotExist(err) {
+			t.Fatalf("%s: truncated object stored", name)
+		}
+	}
+	if tmp, _ := os.ReadDir(filepath.Join(dir, ".git3", "tmp")); len(tmp) != 0 {
+		t.Fatalf("temp files left behind: %v", tmp)
+	}
+}
+
+// recordingSyncer records which paths were triggered and which deferred.
+type recordingSyncer struct {
+	triggered, deferred []string
+}
+
+func (r *recordingSyncer) Trigger(paths ...string) { r.triggered = append(r.triggered, paths...) }
+func (r *recordingSyncer) Defer(paths ...string)   { r.deferred = append(r.deferred, paths...) }
+
+func TestDeferSync(t *testing.T) {
+	syncer := &recordingSyncer{}
+	h := NewHandlerWithOptions(t.TempDir(), WithSyncer(syncer))
+
+	for _, target := range []string{"/vault/a.md", "/vault/b.md"} {
+		req := httptest.NewRequest("PUT", target, strings.NewReader("x"))
+		req.Header.Set(deferSyncHeader, "true")
+		serveRequest(h, req)
+	}
+	req := httptest.NewRequest("DELETE", "/vault/a.md", nil)
+	req.Header.Set(deferSyncHeader, "true")
+	serveRequest(h, req)
+	serve(h, "PUT", "/vault/c.md", "x")
+
+	if want := []string{"a.md", "b.md", "a.md"}; !slices.Equal(syncer.deferred, want) {
+		t.Fatalf("deferred %v, want %v", syncer.deferred, want)
+	}
+	if want := []string{"c.md"}; !slices.Equal(syncer.triggered, want) {
+		t.Fatalf("triggered %v, want %v", syncer.triggered, want)
+	}
+}
+
+func TestContentTypes(t *testing.T) {
+	dir := t.TempDir()
+	h := NewHandlerWithOptions(dir,
+		WithDefaultContentType("text/markdown; charset=utf-8"),
+		WithContentTypes(map[string]string{".canvas": "application/json"}),
+	)
+	for _, name := range []string{"a.md", "README", "b.canvas", "c.txt"} {
+		os.WriteFile(filepath.Join(dir, name), []byte("# hi"), 0644)
+	}
+
+	for key, want := range map[string]string{
+		"a.md":     "text/markdown; charset=utf-8",
+		"README":   "text/markdown; charset=utf-8",
+		"b.canvas": "application/json",
+		"c.txt":    "text/plain; charset=utf-8",
+	} {
+		for _, method := range []string{"GET", "HEAD"} {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(method, "/vault/"+key, nil))
+			if got := w.Header().Get("Content-Type"); got != want {
+				t.Errorf("%s %s Content-Type = %q, want %q", method, key, got, want)
+			}
+		}
+	}
+}
+
+func TestGetObjectByETag(t *testing.T) {
+	h, _ := newTestHandler(t)
+	for _, key := range []string{"a.md", "notes/b.md"} {
+		req := httptest.NewRequest("PUT", "/vault/"+key, strings.NewReader("content of "+key))
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("HEAD", "/vault/notes/b.md", nil))
+	etag := w.Header().Get("ETag")
+
+	for _, query := range []string{etag, strings.Trim(etag, `"`)} {
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/vault?etag="+url.QueryEscape(query), nil))
+		if w.Code != http.StatusOK || w.Body.String() != "content of notes/b.md" {
+			t.Fatalf("GET by ETag %s got %d %q", query, w.Code, w.Body.String())
+		}
+		if got := w.Header().Get("X-Git3-Key"); got != "notes/b.md" {
+			t.Fatalf("X-Git3-Key = %q", got)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/vault?etag=nope", nil))
+	if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "<Code>NoSuchKey</Code>") {
+		t.Fatalf("GET by unknown ETag got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+type recordingWatcher struct {
+	changes []string
+}
+
+func (rw *recordingWatcher) ObjectsChanged(op string, keys []string) {
+	rw.changes = append(rw.changes, op+" "+strings.Join(keys, ","))
+}
+
+func TestWatcherSeesPutAndDelete(t *testing.T) {
+	watcher := &recordingWatcher{}
+	h := NewHandlerWithOptions(t.TempDir(), WithWatcher(watcher))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("PUT", "/vault/notes/a.md", strings.NewReader("a")))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("PUT", "/vault/../bad", strings.NewReader("a")))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("DELETE", "/vault/notes/a.md", nil))
+
+	want := []string{"put notes/a.md", "delete notes/a.md"}
+	if strings.Join(watcher.changes, "|") != strings.Join(want, "|") {
+		t.Fatalf("watcher saw %q, want %q", watcher.changes, want)
+	}
+}
+
+func TestTimestampsAgree(t *testing.T) {
+	h, dir := newTestHandler(t)
+	path := filepath.Join(dir, "a.md")
+	os.WriteFile(path, []byte("a"), 0644)
+	mtime := time.Date(2024, 5, 1, 12, 30, 15, 500_000_000, time.FixedZone("CEST", 2*3600))
+	os.Chtimes(path, mtime, mtime)
+	want := mtime.UTC().Truncate(time.Second)
+
+	for _, method := range []string{"GET", "HEAD"} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(method, "/vault/a.md", nil))
+		got, err := http.ParseTime(w.Header().Get("Last-Modified"))
+		if err != nil || !got.Equal(want) {
+			t.Errorf("%s Last-Modified = %q, want %s", method, w.Header().Get("Last-Modified"), want)
+		}
+		if _, err := http.ParseTime(w.Header().Get("Date")); err != nil {
+			t.Errorf("%s Date = %q: %v", method, w.Header().Get("Date"), err)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/vault?list-type=2", nil))
+	var result ListBucketResult
+	xml.NewDecoder(w.Body).Decode(&result)
+	if len(result.Contents) != 1 {
+		t.Fatalf("listing = %+v", result)
+	}
+	got, err := time.Parse(time.RFC3339, result.Contents[0].LastModified)
+	if err != nil || !got.Equal(want) || !strings.HasSuffix(result.Contents[0].LastModified, ".000Z") {
+		t.Fatalf("listed LastModified = %q, want %s in ISO 8601", result.Contents[0].LastModified, want)
+	}
+	if w.Header().Get("Date") == "" {
+		t.Fatal("listing has no Date header")
+	}
+}
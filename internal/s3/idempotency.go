@@ -0,0 +1,94 @@
+package s3
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// clientTokenHeader lets a client mark retries of one PUT as such; see
+// WithIdempotencyWindow.
+const clientTokenHeader = "X-Amz-Client-Token"
+
+// idempotencySweep is how many PUTs are recorded between sweeps of the
+// expired ones.
+const idempotencySweep = 1024
+
+// idempotency remembers recent PUTs, so a retry within the window is
+// answered without writing the object or triggering a sync again.
+type idempotency struct {
+	window time.Duration
+
+	mu      sync.Mutex
+	puts    map[string]recentPut // by key
+	records int                  // since the last sweep
+}
+
+// recentPut is a PUT as it left the object: what it wrote, and the file
+// it wrote, so a retry after another write isn't mistaken for a replay.
+type recentPut struct {
+	token       string
+	fingerprint string // the content's SHA-256 and the metadata
+	etag        string
+	size        int64
+	modTime     time.Time
+	expires     time.Time
+}
+
+// putFingerprint identifies what a PUT stores: its content, by SHA-256
+// sum, and the Content-Type and metadata it sets.
+func putFingerprint(sum string, meta objectMeta) string {
+	data, _ := json.Marshal(struct {
+		ContentType string            `json:"t"`
+		Metadata    map[string]string `json:"m"`
+	}{meta.ContentType, meta.Metadata})
+	return sum + string(data)
+}
+
+// replay returns the ETag of the PUT of key that this one repeats, if
+// one within the window carried the same token, content and metadata,
+// and the object is still as it left it.
+func (c *idempotency) replay(key, token, fingerprint, fullPath string) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	c.mu.Lock()
+	p, ok := c.puts[key]
+	c.mu.Unlock()
+	if !ok || time.Now().After(p.expires) || p.token != token || p.fingerprint != fingerprint {
+		return "", false
+	}
+	info, err := os.Stat(fullPath)
+	if err != nil || info.Size() != p.size || !info.ModTime().Equal(p.modTime) {
+		return "", false
+	}
+	return p.etag, true
+}
+
+// record remembers a PUT of key that has just been stored at fullPath.
+func (c *idempotency) record(key, token, fingerprint, fullPath, etag string) {
+	if c == nil {
+		return
+	}
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.puts == nil {
+		c.puts = make(map[string]recentPut)
+	}
+	c.puts[key] = recentPut{token: token, fingerprint: fingerprint, etag: etag,
+		size: info.Size(), modTime: info.ModTime(), expires: now.Add(c.window)}
+	if c.records++; c.records >= idempotencySweep {
+		c.records = 0
+		for k, p := range c.puts {
+			if now.After(p.expires) {
+				delete(c.puts, k)
+			}
+		}
+	}
+}
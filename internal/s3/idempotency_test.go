@@ -0,0 +1,64 @@
+package s3
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func tokenPut(h *Handler, target, body, token string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("PUT", target, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("X-Amz-Client-Token", token)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestIdempotentRetry(t *testing.T) {
+	syncer := &recordingSyncer{}
+	dir := t.TempDir()
+	h := NewHandlerWithOptions(dir, WithSyncer(syncer), WithIdempotencyWindow(time.Minute))
+
+	first := tokenPut(h, "/vault/a.md", "hello", "tok-1")
+	info, _ := os.Stat(filepath.Join(dir, "a.md"))
+	time.Sleep(10 * time.Millisecond) // so a rewrite would show in the mtime
+	retry := tokenPut(h, "/vault/a.md", "hello", "tok-1")
+	if first.Code != 200 || retry.Code != 200 || retry.Header().Get("ETag") != first.Header().Get("ETag") {
+		t.Fatalf("retry = %d %s, want 200 with the first ETag %s", retry.Code, retry.Header().Get("ETag"), first.Header().Get("ETag"))
+	}
+	if again, _ := os.Stat(filepath.Join(dir, "a.md")); !again.ModTime().Equal(info.ModTime()) {
+		t.Fatal("retry wrote the object again")
+	}
+	if len(syncer.triggered) != 1 {
+		t.Fatalf("triggered %v, want a single sync", syncer.triggered)
+	}
+
+	// Other content, another token, or a write in between is no retry
+	tokenPut(h, "/vault/a.md", "changed", "tok-1")
+	tokenPut(h, "/vault/a.md", "changed", "tok-2")
+	if len(syncer.triggered) != 3 {
+		t.Fatalf("triggered %v, want new content and a new token to write", syncer.triggered)
+	}
+	tokenPut(h, "/vault/a.md", "other", "")
+	tokenPut(h, "/vault/a.md", "changed", "tok-2")
+	if w := serve(h, "GET", "/vault/a.md", ""); w.Body.String() != "changed" || len(syncer.triggered) != 5 {
+		t.Fatalf("a.md = %q after %d triggers, want the retry after another write stored", w.Body, len(syncer.triggered))
+	}
+}
+
+func TestIdempotencyWindowExpires(t *testing.T) {
+	syncer := &recordingSyncer{}
+	h := NewHandlerWithOptions(t.TempDir(), WithSyncer(syncer), WithIdempotencyWindow(20*time.Millisecond))
+	tokenPut(h, "/vault/a.md", "hello", "")
+	tokenPut(h, "/vault/a.md", "hello", "")
+	time.Sleep(30 * time.Millisecond)
+	tokenPut(h, "/vault/a.md", "hello", "")
+	if len(syncer.triggered) != 2 {
+		t.Fatalf("triggered %v, want the repeat after the window written", syncer.triggered)
+	}
+}
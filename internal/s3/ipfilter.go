@@ -0,0 +1,117 @@
+package s3
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// IPFilter restricts requests by client address.
+type IPFilter struct {
+	Allow          []netip.Prefix // if non-empty, only these networks are let through
+	Deny           []netip.Prefix // always rejected, even when also allowed
+	TrustedProxies []netip.Prefix // X-Forwarded-For is honored only from these peers
+	WritesOnly     bool           // filter only PUT, POST and DELETE; reads are open
+}
+
+// ParsePrefixes parses a comma-separated list of CIDRs or bare IPs.
+func ParsePrefixes(s string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if !strings.Contains(field, "/") {
+			addr, err := netip.ParseAddr(field)
+			if err != nil {
+				return nil, fmt.Errorf("invalid address %q", field)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q", field)
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+	return prefixes, nil
+}
+
+// IPFilterMiddleware rejects requests from blocked clients with 403
+// AccessDenied before they reach next.
+func IPFilterMiddleware(f IPFilter, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if f.WritesOnly && r.Method != "PUT" && r.Method != "POST" && r.Method != "DELETE" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ip := f.clientIP(r)
+		if !f.allowed(ip) {
+			log.Printf("[http] blocked %s %s from %s", r.Method, r.URL.Path, ip)
+			writeXMLError(w, http.StatusForbidden, "AccessDenied", "Access denied from this address")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (f IPFilter) allowed(ip netip.Addr) bool {
+	if !ip.IsValid() {
+		return false
+	}
+	if containsAddr(f.Deny, ip) {
+		return false
+	}
+	return len(f.Allow) == 0 || containsAddr(f.Allow, ip)
+}
+
+// clientIP returns the address of the client. X-Forwarded-For is only
+// believed when the peer is a trusted proxy, and is read right to left so
+// that entries a client prepended itself are never used.
+func (f IPFilter) clientIP(r *http.Request) netip.Addr {
+	ip := peerAddr(r)
+	if !ip.IsValid() || !containsAddr(f.TrustedProxies, ip) {
+		return ip
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		ip = hop.Unmap()
+		if !containsAddr(f.TrustedProxies, ip) {
+			break
+		}
+	}
+	return ip
+}
+
+// peerAddr returns the address r came from, the client's or a proxy's,
+// or the zero Addr if it can't be parsed.
+func peerAddr(r *http.Request) netip.Addr {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}
+	}
+	return ip.Unmap()
+}
+
+func containsAddr(prefixes []netip.Prefix, ip netip.Addr) bool {
+	for _, p := range prefixes {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,98 @@
+package s3
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func mustPrefixes(t *testing.T, s string) []netip.Prefix {
+	t.Helper()
+	p, err := ParsePrefixes(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func filterStatus(f IPFilter, method, remoteAddr, xff string) int {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	req := httptest.NewRequest(method, "/vault/a.md", nil)
+	req.RemoteAddr = remoteAddr
+	if xff != "" {
+		req.Header.Set("X-Forwarded-For", xff)
+	}
+	w := httptest.NewRecorder()
+	IPFilterMiddleware(f, ok).ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestIPFilterAllowAndDeny(t *testing.T) {
+	f := IPFilter{
+		Allow: mustPrefixes(t, "192.168.1.0/24, 2001:db8::/32"),
+		Deny:  mustPrefixes(t, "192.168.1.13"),
+	}
+
+	tests := []struct {
+		remote string
+		want   int
+	}{
+		{"192.168.1.20:5000", http.StatusOK},
+		{"[2001:db8::1]:5000", http.StatusOK},
+		{"[::ffff:192.168.1.20]:5000", http.StatusOK},
+		{"192.168.1.13:5000", http.StatusForbidden},
+		{"203.0.113.7:5000", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		if got := filterStatus(f, "GET", tt.remote, ""); got != tt.want {
+			t.Errorf("%s got %d, want %d", tt.remote, got, tt.want)
+		}
+	}
+}
+
+func TestIPFilterXFFFromTrustedProxy(t *testing.T) {
+	f := IPFilter{
+		Allow:          mustPrefixes(t, "198.51.100.0/24"),
+		TrustedProxies: mustPrefixes(t, "10.0.0.0/8"),
+	}
+
+	// The client is the rightmost untrusted hop
+	if got := filterStatus(f, "GET", "10.0.0.2:443", "198.51.100.9, 10.0.0.5"); got != http.StatusOK {
+		t.Fatalf("allowed client behind trusted proxies got %d", got)
+	}
+	// A forged leftmost entry is not the client
+	if got := filterStatus(f, "GET", "10.0.0.2:443", "198.51.100.9, 203.0.113.7"); got != http.StatusForbidden {
+		t.Fatalf("blocked client with forged XFF got %d", got)
+	}
+}
+
+func TestIPFilterIgnoresSpoofedXFF(t *testing.T) {
+	f := IPFilter{
+		Allow:          mustPrefixes(t, "198.51.100.0/24"),
+		TrustedProxies: mustPrefixes(t, "10.0.0.0/8"),
+	}
+
+	if got := filterStatus(f, "GET", "203.0.113.7:5000", "198.51.100.9"); got != http.StatusForbidden {
+		t.Fatalf("spoofed XFF from untrusted peer got %d, want 403", got)
+	}
+}
+
+func TestIPFilterWritesOnly(t *testing.T) {
+	f := IPFilter{Allow: mustPrefixes(t, "192.168.1.0/24"), WritesOnly: true}
+
+	if got := filterStatus(f, "GET", "203.0.113.7:5000", ""); got != http.StatusOK {
+		t.Fatalf("read from outside got %d, want 200", got)
+	}
+	if got := filterStatus(f, "PUT", "203.0.113.7:5000", ""); got != http.StatusForbidden {
+		t.Fatalf("write from outside got %d, want 403", got)
+	}
+}
+
+func TestParsePrefixesInvalid(t *testing.T) {
+	for _, s := range []string{"not-an-ip", "10.0.0.0/33"} {
+		if _, err := ParsePrefixes(s); err == nil {
+			t.Errorf("ParsePrefixes(%q) succeeded", s)
+		}
+	}
+}
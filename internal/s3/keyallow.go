@@ -0,0 +1,60 @@
+package s3
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// writeAllowed reports whether key may be written or deleted: with no
+// write allowlist, any key may.
+func (s *Handler) writeAllowed(key string) bool {
+	if len(s.writeKeyAllow) == 0 {
+		return true
+	}
+	for _, p := range s.writeKeyAllow {
+		if matchKeyPattern(strings.Split(p, "/"), strings.Split(key, "/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchKeyPattern matches key segments against pattern segments. A "**"
+// segment matches any number of key segments, none included unless it
+// ends the pattern, so "notes/**" matches what is under notes/ but not
+// notes itself; the others match one segment each, as path.Match does.
+func matchKeyPattern(pattern, key []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" && len(pattern) == 1 {
+			return len(key) > 0
+		}
+		if pattern[0] == "**" {
+			for i := 0; i <= len(key); i++ {
+				if matchKeyPattern(pattern[1:], key[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(key) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], key[0]); !ok {
+			return false
+		}
+		pattern, key = pattern[1:], key[1:]
+	}
+	return len(key) == 0
+}
+
+// CheckKeyPatterns reports the first malformed pattern in patterns, for
+// validating WithWriteKeyAllow's before the handler is built.
+func CheckKeyPatterns(patterns []string) error {
+	for _, p := range patterns {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("key pattern %q: %w", p, err)
+		}
+	}
+	return nil
+}
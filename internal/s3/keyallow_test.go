@@ -0,0 +1,46 @@
+package s3
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestWriteKeyAllow(t *testing.T) {
+	h := NewHandlerWithOptions(t.TempDir(), WithWriteKeyAllow("notes/**", "*.md"))
+
+	for _, key := range []string{"notes/a.md", "notes/deep/down/b.png", "top.md"} {
+		if w := serve(h, "PUT", "/vault/"+key, "x"); w.Code != http.StatusOK {
+			t.Fatalf("PUT %s got %d", key, w.Code)
+		}
+	}
+	for _, key := range []string{"junk/a.md", "notes", "top.png", "dir/top.md"} {
+		w := serve(h, "PUT", "/vault/"+key, "x")
+		if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "<Code>AccessDenied</Code>") {
+			t.Fatalf("PUT %s got %d: %s", key, w.Code, w.Body)
+		}
+	}
+	if w := serve(h, "PUT", "/vault/junk/a.md?offset=0", "x"); w.Code != http.StatusForbidden {
+		t.Fatalf("resumable PUT outside the allowlist got %d", w.Code)
+	}
+
+	// Reads aren't restricted, deletes are
+	if w := serve(h, "GET", "/vault/junk/a.md", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("GET outside the allowlist got %d", w.Code)
+	}
+	if w := serve(h, "DELETE", "/vault/junk/a.md", ""); w.Code != http.StatusForbidden {
+		t.Fatalf("DELETE outside the allowlist got %d", w.Code)
+	}
+	if w := serve(h, "DELETE", "/vault/notes/a.md", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("DELETE inside the allowlist got %d", w.Code)
+	}
+}
+
+func TestCheckKeyPatterns(t *testing.T) {
+	if err := CheckKeyPatterns([]string{"notes/**", "*.md"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckKeyPatterns([]string{"notes/[a"}); err == nil {
+		t.Fatal("malformed pattern accepted")
+	}
+}
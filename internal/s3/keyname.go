@@ -0,0 +1,30 @@
+package s3
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// DefaultMaxKeyLength is the longest key, in bytes, that may be written,
+// as on S3.
+const DefaultMaxKeyLength = 1024
+
+// checkKey reports whether key may be written or deleted, with the error
+// code and message if it may not: it must be valid UTF-8, at most
+// maxKeyLength bytes long and free of control characters, NUL included,
+// which filesystems, git and clients handle badly if at all. objectPath
+// guards against keys escaping the root.
+func (s *Handler) checkKey(key string) (code, message string, ok bool) {
+	if len(key) > s.maxKeyLength {
+		return "KeyTooLongError", fmt.Sprintf("Your key is too long: %d bytes, over the limit of %d", len(key), s.maxKeyLength), false
+	}
+	if !utf8.ValidString(key) {
+		return "InvalidArgument", "Object keys must be valid UTF-8", false
+	}
+	if i := strings.IndexFunc(key, unicode.IsControl); i >= 0 {
+		return "InvalidArgument", fmt.Sprintf("Object keys may not contain control characters (%U at byte %d)", []rune(key[i:])[0], i), false
+	}
+	return "", "", true
+}
@@ -0,0 +1,50 @@
+package s3
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestKeyValidation(t *testing.T) {
+	h, _ := newTestHandler(t)
+	tests := []struct {
+		name, key, code string
+	}{
+		{"over-length", strings.Repeat("a", DefaultMaxKeyLength+1), "KeyTooLongError"},
+		{"NUL", "notes/a\x00.md", "InvalidArgument"},
+		{"newline", "notes/a\n.md", "InvalidArgument"},
+		{"invalid UTF-8", "notes/\xff.md", "InvalidArgument"},
+	}
+	for _, tt := range tests {
+		target := "/vault/" + url.PathEscape(tt.key)
+		for _, method := range []string{"PUT", "DELETE"} {
+			w := serve(h, method, target, "x")
+			if w.Code != 400 || !strings.Contains(w.Body.String(), "<Code>"+tt.code+"</Code>") {
+				t.Errorf("%s %s key = %d: %s", method, tt.name, w.Code, w.Body)
+			}
+		}
+	}
+
+	key := "notes/日本語 ✓ é.md"
+	target := "/vault/" + url.PathEscape(key)
+	if w := serve(h, "PUT", target, "unicode"); w.Code != 200 {
+		t.Fatalf("PUT of a unicode key = %d: %s", w.Code, w.Body)
+	}
+	if w := serve(h, "GET", target, ""); w.Code != 200 || w.Body.String() != "unicode" {
+		t.Fatalf("GET of a unicode key = %d %q", w.Code, w.Body)
+	}
+	// 1024 bytes, in directories short enough for the filesystem
+	atLimit := strings.Repeat("abcdefg/", 127) + "abcdefgh"
+	if w := serve(h, "PUT", "/vault/"+atLimit, "x"); w.Code != 200 {
+		t.Fatalf("PUT of a key at the limit = %d: %s", w.Code, w.Body)
+	}
+
+	short := NewHandlerWithOptions(t.TempDir(), WithMaxKeyLength(8))
+	if w := serve(short, "PUT", "/vault/abcd.md", "x"); w.Code != 200 {
+		t.Fatalf("PUT under a configured limit = %d", w.Code)
+	}
+	if w := serve(short, "PUT", "/vault/abcdefgh.md", "x"); w.Code != 400 {
+		t.Fatalf("PUT over a configured limit = %d", w.Code)
+	}
+}
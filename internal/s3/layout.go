@@ -0,0 +1,207 @@
+package s3
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// keyFileSuffix names the file beside a hashed object that holds its key.
+const keyFileSuffix = ".key"
+
+// hashedLayout stores each object at a path derived from the SHA-256 of
+// its key, fanned out two levels deep ("ab/cd/abcd…"), so no directory
+// grows past a few hundred entries however many objects share a prefix.
+// Beside each object a ".key" file holds its key, which makes the tree
+// enough to rebuild the key index from, here or in any clone of the
+// repository.
+//
+// The index, every key in order, is read from the key files on first use
+// and kept up to date by writes through the handler. Writers replace the
+// slice rather than change it, so a walk can go on with the one it has.
+type hashedLayout struct {
+	root string
+
+	mu     sync.Mutex
+	loaded bool
+	keys   []string
+}
+
+// path returns where the object of key is stored.
+func (l *hashedLayout) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	name := hex.EncodeToString(sum[:])
+	return filepath.Join(l.root, name[:2], name[2:4], name)
+}
+
+// add records key, just written to fullPath, writing its key file if it
+// has none yet.
+func (l *hashedLayout) add(fullPath, key string) error {
+	if _, err := os.Stat(fullPath + keyFileSuffix); errors.Is(err, fs.ErrNotExist) {
+		if err := os.WriteFile(fullPath+keyFileSuffix, []byte(key), 0644); err != nil {
+			return err
+		}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.loaded {
+		return nil
+	}
+	if i, found := slices.BinarySearch(l.keys, key); !found {
+		l.keys = slices.Concat(l.keys[:i], []string{key}, l.keys[i:])
+	}
+	return nil
+}
+
+// remove forgets key, just deleted from fullPath, and removes its key file.
+func (l *hashedLayout) remove(fullPath, key string) {
+	os.Remove(fullPath + keyFileSuffix)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.loaded {
+		return
+	}
+	if i, found := slices.BinarySearch(l.keys, key); found {
+		l.keys = slices.Concat(l.keys[:i], l.keys[i+1:])
+	}
+}
+
+// forget drops the index, for changes made to the tree other than through
+// the handler. The next walk reads the key files again.
+func (l *hashedLayout) forget() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.loaded, l.keys = false, nil
+}
+
+// index returns every key, in order, reading the key files if the index
+// isn't loaded. A key file whose key doesn't hash to its own path is left
+// out, so a stray file can't shadow another key.
+func (l *hashedLayout) index() ([]string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.loaded {
+		return l.keys, nil
+	}
+	var keys []string
+	err := filepath.WalkDir(l.root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p == l.root && errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			if p != l.root && reservedName(d.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(p, keyFileSuffix) || !d.Type().IsRegular() {
+			return nil
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		if key := string(data); l.path(key) == strings.TrimSuffix(p, keyFileSuffix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(keys)
+	l.keys, l.loaded = keys, true
+	return keys, nil
+}
+
+// walk is walkObjectsToDepth over the index: the same keys and common
+// prefixes, visited in the same order.
+func (l *hashedLayout) walk(prefix, after string, depth int, fn func(key string, info fs.FileInfo) error, dirFn func(prefix string) error) error {
+	keys, err := l.index()
+	if err != nil {
+		return err
+	}
+	i, _ := slices.BinarySearch(keys, max(prefix, after))
+	var lastDir string
+	for _, key := range keys[i:] {
+		if !strings.HasPrefix(key, prefix) {
+			break
+		}
+		if key <= after {
+			continue
+		}
+		if n := nthSlash(key[len(prefix):], depth); depth > 0 && n >= 0 {
+			dir := key[:len(prefix)+n+1]
+			if dir == lastDir || dir <= after {
+				continue
+			}
+			lastDir = dir
+			err = dirFn(dir)
+		} else {
+			info, statErr := os.Stat(l.path(key))
+			if statErr != nil || !info.Mode().IsRegular() {
+				continue
+			}
+			err = fn(key, info)
+		}
+		if errors.Is(err, errStopWalk) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nthSlash returns the index of the nth "/" in s, or -1 if it has fewer.
+func nthSlash(s string, n int) int {
+	if n <= 0 {
+		return -1
+	}
+	at := -1
+	for ; n > 0; n-- {
+		i := strings.IndexByte(s[at+1:], '/')
+		if i < 0 {
+			return -1
+		}
+		at += i + 1
+	}
+	return at
+}
+
+// walkKeys is walkObjectsToDepth over the bucket, in whichever layout it
+// is stored.
+func (s *Handler) walkKeys(prefix, after string, depth int, fn func(key string, info fs.FileInfo) error, dirFn func(prefix string) error) error {
+	if s.layout != nil {
+		return s.layout.walk(prefix, after, depth, fn, dirFn)
+	}
+	return walkObjectsToDepth(s.dir, prefix, after, depth, fn, dirFn)
+}
+
+// keyFile returns the file of key, a key that walkKeys returned.
+func (s *Handler) keyFile(key string) string {
+	if s.layout != nil {
+		return s.layout.path(key)
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(key))
+}
+
+// changedFiles returns the paths, relative to the root, that writing or
+// deleting the object at fullPath changes.
+func (s *Handler) changedFiles(fullPath string) []string {
+	rel := s.relPath(fullPath)
+	if s.layout != nil {
+		return []string{rel, rel + keyFileSuffix}
+	}
+	return []string{rel}
+}
@@ -0,0 +1,97 @@
+package s3
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestHashedLayout(t *testing.T) {
+	dir := t.TempDir()
+	syncer := &recordingSyncer{}
+	h := NewHandlerWithOptions(dir, WithHashedLayout(), WithSyncer(syncer))
+
+	keys := []string{"a.md", "b/1.md", "b/2.md", "b/c/3.md", "b.md", "c.md", "d/4.md"}
+	for _, key := range keys {
+		if w := serve(h, "PUT", "/vault/"+key, "content of "+key); w.Code != 200 {
+			t.Fatalf("PUT %s = %d: %s", key, w.Code, w.Body)
+		}
+	}
+	for _, key := range keys {
+		if w := serve(h, "GET", "/vault/"+key, ""); w.Code != 200 || w.Body.String() != "content of "+key {
+			t.Fatalf("GET %s = %d %q", key, w.Code, w.Body.String())
+		}
+	}
+
+	// Nothing is stored at the keys' own paths, and the syncer is told
+	// about the hashed files
+	if _, err := os.Stat(filepath.Join(dir, "b")); err == nil {
+		t.Fatal("object stored at its key's path")
+	}
+	path := h.layout.path("b/c/3.md")
+	rel := h.relPath(path)
+	if data, _ := os.ReadFile(path + keyFileSuffix); string(data) != "b/c/3.md" {
+		t.Fatalf("key file = %q", data)
+	}
+	if len(rel) != 2+1+2+1+64 || !slices.Contains(syncer.triggered, rel) || !slices.Contains(syncer.triggered, rel+keyFileSuffix) {
+		t.Fatalf("stored at %s, triggered %v", rel, syncer.triggered)
+	}
+
+	list := func(query string) string {
+		w := serve(h, "GET", "/vault?list-type=2&"+query, "")
+		if w.Code != 200 {
+			t.Fatalf("%s: got %d: %s", query, w.Code, w.Body)
+		}
+		result := decodeListing(t, w.Body)
+		var entries []string
+		for _, c := range result.Contents {
+			entries = append(entries, c.Key)
+		}
+		for _, p := range result.CommonPrefixes {
+			entries = append(entries, p.Prefix)
+		}
+		return fmt.Sprint(entries)
+	}
+	tests := map[string]string{
+		"":                           "[a.md b.md b/1.md b/2.md b/c/3.md c.md d/4.md]",
+		"delimiter=/":                "[a.md b.md c.md b/ d/]",
+		"prefix=b/&delimiter=/":      "[b/1.md b/2.md b/c/]",
+		"prefix=b/c":                 "[b/c/3.md]",
+		"start-after=b/1.md":         "[b/2.md b/c/3.md c.md d/4.md]",
+		"start-after=b/&delimiter=/": "[c.md d/]",
+		"x-max-depth=2":              "[a.md b.md b/1.md b/2.md c.md d/4.md b/c/]",
+		"max-keys=2":                 "[a.md b.md]",
+	}
+	for query, want := range tests {
+		if got := list(query); got != want {
+			t.Errorf("%q: listed %s, want %s", query, got, want)
+		}
+	}
+
+	// A DELETE removes the object and its key file
+	if w := serve(h, "DELETE", "/vault/b/c/3.md", ""); w.Code != 204 {
+		t.Fatalf("DELETE = %d", w.Code)
+	}
+	if _, err := os.Stat(path + keyFileSuffix); !os.IsNotExist(err) {
+		t.Fatalf("key file left behind: %v", err)
+	}
+	if got := list("prefix=b/"); got != "[b/1.md b/2.md]" {
+		t.Errorf("after DELETE listed %s", got)
+	}
+
+	// The index is rebuilt from the key files, like after a pull or on
+	// another clone
+	os.MkdirAll(filepath.Dir(h.layout.path("e.md")), 0755)
+	os.WriteFile(h.layout.path("e.md"), []byte("pulled"), 0644)
+	os.WriteFile(h.layout.path("e.md")+keyFileSuffix, []byte("e.md"), 0644)
+	h.TreeChanged()
+	if got := list(""); got != "[a.md b.md b/1.md b/2.md c.md d/4.md e.md]" {
+		t.Errorf("after TreeChanged listed %s", got)
+	}
+	other := NewHandlerWithOptions(dir, WithHashedLayout())
+	if objects, _, err := other.Stats(); err != nil || objects != 7 {
+		t.Errorf("another handler counted %d objects (%v), want 7", objects, err)
+	}
+}
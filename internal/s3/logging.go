@@ -1,6 +1,7 @@
 package s3
 
 import (
+	"fmt"
 	"log"
 	"net/http"
 	"time"
@@ -17,12 +18,27 @@ func (r *statusRecorder) WriteHeader(code int) {
 	r.ResponseWriter.WriteHeader(code)
 }
 
-// LoggingMiddleware logs each request's method, path, status code, and duration.
+// Flush passes flushes through, so streaming responses aren't held back.
+func (r *statusRecorder) Flush() {
+	http.NewResponseController(r.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// LoggingMiddleware logs each request's method, path, status code, and
+// duration, and its request ID if the response has one.
 func LoggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
 		next.ServeHTTP(rec, r)
-		log.Printf("[http] %s %s %d %dms", r.Method, r.URL.Path, rec.status, time.Since(start).Milliseconds())
+		line := fmt.Sprintf("[http] %s %s %d %dms", r.Method, r.URL.Path, rec.status, time.Since(start).Milliseconds())
+		if id := w.Header().Get(requestIDHeader); id != "" {
+			line += " " + id
+		}
+		log.Print(line)
 	})
 }
@@ -89,3 +89,17 @@ func TestLoggingMiddlewareNoAuthHeader(t *testing.T) {
 		t.Errorf("log must not contain access key, got: %s", line)
 	}
 }
+
+func TestLoggingMiddlewareFlushes(t *testing.T) {
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := http.NewResponseController(w).Flush(); err != nil {
+			t.Errorf("Flush through the middleware: %v", err)
+		}
+		w.(http.Flusher).Flush()
+	})
+	w := httptest.NewRecorder()
+	LoggingMiddleware(inner).ServeHTTP(w, httptest.NewRequest("GET", "/-/events", nil))
+	if !w.Flushed {
+		t.Fatal("flush did not reach the underlying writer")
+	}
+}
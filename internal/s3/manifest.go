@@ -0,0 +1,101 @@
+package s3
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// manifestQuery is the query parameter asking a bucket GET for a JSON
+// manifest of every object instead of a listing. It is an extension to
+// S3, for indexers and static-site builders, which would otherwise page
+// through the whole listing on every run.
+const manifestQuery = "git3-manifest"
+
+// manifest is the JSON document returned for manifestQuery.
+type manifest struct {
+	Bucket    string          `json:"bucket"`
+	Generated time.Time       `json:"generated"`
+	Objects   []manifestEntry `json:"objects"`
+}
+
+type manifestEntry struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	ETag         string    `json:"etag"`
+	LastModified time.Time `json:"lastModified"`
+}
+
+// manifestCache keeps the last manifest built, until the tree changes.
+// Every write through the handler, and every TreeChanged, counts as a
+// change; the manifest is built again on the first request after one.
+type manifestCache struct {
+	changes atomic.Uint64
+
+	mu    sync.Mutex
+	built uint64 // changes as of data
+	data  []byte // nil until first built
+	etag  string
+}
+
+// changed records that the tree has changed since the manifest was built.
+func (c *manifestCache) changed() {
+	c.changes.Add(1)
+}
+
+// getManifest answers GET /{bucket}?git3-manifest with the cached
+// manifest, building it first if the tree changed since. The response
+// carries an ETag of its own, so an indexer polling with If-None-Match
+// gets a 304 until something changes.
+func (s *Handler) getManifest(w http.ResponseWriter, r *http.Request, bucket string) {
+	if bucket != s.bucket {
+		s.xmlError(w, http.StatusNotFound, "NoSuchBucket", "Bucket not found")
+		return
+	}
+	c := &s.manifest
+	c.mu.Lock()
+	if changes := c.changes.Load(); c.data == nil || c.built != changes {
+		data, err := s.buildManifest()
+		if err != nil {
+			c.mu.Unlock()
+			s.xmlError(w, http.StatusInternalServerError, "InternalError", err.Error())
+			return
+		}
+		sum := sha256.Sum256(data)
+		c.data, c.etag, c.built = data, "\""+hex.EncodeToString(sum[:16])+"\"", changes
+	}
+	data, etag := c.data, c.etag
+	c.mu.Unlock()
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// buildManifest walks the bucket and encodes the manifest.
+func (s *Handler) buildManifest() ([]byte, error) {
+	doc := manifest{Bucket: s.bucket, Generated: time.Now().UTC(), Objects: []manifestEntry{}}
+	err := s.walkKeys("", "", 0, func(key string, info fs.FileInfo) error {
+		doc.Objects = append(doc.Objects, manifestEntry{
+			Key:          key,
+			Size:         s.contentSize(s.keyFile(key), info),
+			ETag:         objectETag(key, info),
+			LastModified: lastModified(info),
+		})
+		return nil
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(doc)
+}
@@ -0,0 +1,68 @@
+package s3
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func readManifest(t *testing.T, h *Handler) (manifest, string) {
+	t.Helper()
+	w := serve(h, "GET", "/vault?git3-manifest", "")
+	var doc manifest
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("%d: %v", w.Code, err)
+	}
+	return doc, w.Header().Get("ETag")
+}
+
+func TestManifest(t *testing.T) {
+	h, dir := newTestHandler(t)
+	serve(h, "PUT", "/vault/notes/a.md", "hello")
+	serve(h, "PUT", "/vault/b.md", "hi")
+
+	doc, etag := readManifest(t, h)
+	if doc.Bucket != "vault" || len(doc.Objects) != 2 {
+		t.Fatalf("manifest = %+v", doc)
+	}
+	a := doc.Objects[1]
+	head := serve(h, "HEAD", "/vault/notes/a.md", "")
+	if a.Key != "notes/a.md" || a.Size != 5 || a.ETag != head.Header().Get("ETag") || a.LastModified.IsZero() {
+		t.Fatalf("manifest entry = %+v, want notes/a.md as HEAD describes it", a)
+	}
+
+	// Unchanged, it is served from the cache, and a poll gets a 304
+	if again, _ := readManifest(t, h); !again.Generated.Equal(doc.Generated) {
+		t.Fatal("manifest built again without a change")
+	}
+	req := httptest.NewRequest("GET", "/vault?git3-manifest", nil)
+	req.Header.Set("If-None-Match", etag)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != 304 {
+		t.Fatalf("If-None-Match with the manifest's ETag = %d, want 304", w.Code)
+	}
+
+	// A PUT makes the next request build it again
+	serve(h, "PUT", "/vault/c.md", "new")
+	doc, newETag := readManifest(t, h)
+	if len(doc.Objects) != 3 || doc.Objects[1].Key != "c.md" || newETag == etag {
+		t.Fatalf("manifest after PUT = %+v, etag %s", doc, newETag)
+	}
+
+	// So does a change behind the handler's back, once it is told
+	os.Remove(filepath.Join(dir, "b.md"))
+	if doc, _ := readManifest(t, h); len(doc.Objects) != 3 {
+		t.Fatalf("manifest rebuilt without a change: %+v", doc)
+	}
+	h.TreeChanged()
+	if doc, _ := readManifest(t, h); len(doc.Objects) != 2 {
+		t.Fatalf("manifest after TreeChanged = %+v", doc)
+	}
+
+	if w := serve(h, "GET", "/other?git3-manifest", ""); w.Code != 404 {
+		t.Fatalf("manifest of another bucket = %d", w.Code)
+	}
+}
@@ -0,0 +1,51 @@
+package s3
+
+import (
+	"net/http"
+	"strings"
+)
+
+// metaPrefix starts the headers that carry user-defined object metadata.
+const metaPrefix = "x-amz-meta-"
+
+// maxMetadataSize is S3's limit on user-defined metadata: the bytes of all
+// keys (without metaPrefix) and values together.
+const maxMetadataSize = 2 << 10
+
+// checkMetadata validates the user-defined metadata in a PUT's headers,
+// returning the S3 error code and message for the first problem found.
+func checkMetadata(h http.Header) (code, message string, ok bool) {
+	size := 0
+	for name, values := range h {
+		key, isMeta := strings.CutPrefix(strings.ToLower(name), metaPrefix)
+		if !isMeta {
+			continue
+		}
+		if !validMetadataKey(key) {
+			return "InvalidArgument", "Invalid metadata key " + name, false
+		}
+		for _, v := range values {
+			size += len(key) + len(v)
+		}
+	}
+	if size > maxMetadataSize {
+		return "MetadataTooLarge", "Your metadata headers exceed the maximum allowed metadata size", false
+	}
+	return "", "", true
+}
+
+// validMetadataKey accepts the characters S3 clients use in metadata keys:
+// lowercase letters, digits, '-', '_' and '.'.
+func validMetadataKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, c := range key {
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,118 @@
+package s3
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func putWithHeaders(h http.Handler, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("PUT", "/vault/a.md", strings.NewReader("a"))
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+// metaHeader returns the x-amz-meta-key header of w's response, looked up
+// by its lowercase name rather than the canonical one Header.Get uses.
+func metaHeader(w *httptest.ResponseRecorder, key string) string {
+	return strings.Join(w.Header()[metaPrefix+key], ",")
+}
+
+func TestMetadataWithinLimit(t *testing.T) {
+	h, _ := newTestHandler(t)
+	w := putWithHeaders(h, map[string]string{
+		"X-Amz-Meta-Author": "me",
+		"X-Amz-Meta-Note":   strings.Repeat("x", maxMetadataSize-len("author")-len("me")-len("note")),
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT with 2KB of metadata got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestMetadataTooLarge(t *testing.T) {
+	h, _ := newTestHandler(t)
+	w := putWithHeaders(h, map[string]string{
+		"X-Amz-Meta-Note": strings.Repeat("x", maxMetadataSize),
+	})
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "<Code>MetadataTooLarge</Code>") {
+		t.Fatalf("PUT with oversized metadata got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestMetadataInvalidKey(t *testing.T) {
+	h, _ := newTestHandler(t)
+	w := putWithHeaders(h, map[string]string{"X-Amz-Meta-Bad*Key": "v"})
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "<Code>InvalidArgument</Code>") {
+		t.Fatalf("PUT with an invalid metadata key got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestMetadataStored(t *testing.T) {
+	h, dir := newTestHandler(t)
+	w := putWithHeaders(h, map[string]string{
+		"Content-Type":      "text/x-journal",
+		"X-Amz-Meta-Author": "me",
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT got %d: %s", w.Code, w.Body)
+	}
+	for _, method := range []string{"GET", "HEAD"} {
+		w := serve(h, method, "/vault/a.md", "")
+		if w.Header().Get("Content-Type") != "text/x-journal" || metaHeader(w, "author") != "me" {
+			t.Fatalf("%s returned Content-Type %q, author %q", method, w.Header().Get("Content-Type"), metaHeader(w, "author"))
+		}
+	}
+	if w := serve(h, "GET", "/vault", ""); strings.Contains(w.Body.String(), "meta") {
+		t.Fatalf("listing shows the metadata store: %s", w.Body)
+	}
+
+	// Appending keeps it
+	req := httptest.NewRequest("PUT", "/vault/a.md", strings.NewReader("b"))
+	req.Header.Set(writeModeHeader, "append")
+	serveRequest(h, req)
+	if w := serve(h, "HEAD", "/vault/a.md", ""); metaHeader(w, "author") != "me" {
+		t.Fatal("append dropped the metadata")
+	}
+
+	// An object changed outside the API loses it
+	os.WriteFile(filepath.Join(dir, "a.md"), []byte("edited by hand"), 0644)
+	if w := serve(h, "HEAD", "/vault/a.md", ""); metaHeader(w, "author") != "" || w.Header().Get("Content-Type") != "text/markdown; charset=utf-8" {
+		t.Fatalf("HEAD after an outside edit returned %v", w.Header())
+	}
+
+	// Overwriting replaces it, and DELETE removes it
+	putWithHeaders(h, map[string]string{"X-Amz-Meta-Author": "you"})
+	if w := serve(h, "HEAD", "/vault/a.md", ""); metaHeader(w, "author") != "you" {
+		t.Fatalf("author after overwrite = %q", metaHeader(w, "author"))
+	}
+	serve(h, "DELETE", "/vault/a.md", "")
+	if _, err := os.Stat(h.meta.path("a.md")); !os.IsNotExist(err) {
+		t.Fatal("DELETE left the metadata behind")
+	}
+}
+
+func TestMetadataKeyCase(t *testing.T) {
+	h, _ := newTestHandler(t)
+	if w := putWithHeaders(h, map[string]string{"X-Amz-Meta-FooBar": "Mixed Case"}); w.Code != http.StatusOK {
+		t.Fatalf("PUT got %d: %s", w.Code, w.Body)
+	}
+	for _, method := range []string{"GET", "HEAD"} {
+		w := serve(h, method, "/vault/a.md", "")
+		if got := w.Header()["x-amz-meta-foobar"]; len(got) != 1 || got[0] != "Mixed Case" {
+			t.Fatalf("%s returned x-amz-meta-foobar %q in %v", method, got, w.Header())
+		}
+	}
+
+	// The name as it reached the handler is kept for the JSON description
+	w := serve(h, "GET", "/vault/a.md?"+objectInfoQuery, "")
+	if body := w.Body.String(); !strings.Contains(body, `"metadata":{"foobar":"Mixed Case"}`) || !strings.Contains(body, `"metadataNames":{"foobar":"Foobar"}`) {
+		t.Fatalf("object info = %s", body)
+	}
+}
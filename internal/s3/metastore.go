@@ -0,0 +1,146 @@
+package s3
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// objectMeta is what the handler keeps about an object besides its
+// content. Size and ModTime are the stored file's when the entry was
+// written: an object changed behind the handler's back, by a pull or by
+// hand, no longer matches and its entry is ignored.
+type objectMeta struct {
+	Size              int64             `json:"size"`
+	ModTime           time.Time         `json:"mtime"`
+	ContentType       string            `json:"contentType,omitempty"`
+	Metadata          map[string]string `json:"metadata,omitempty"`
+	MetadataNames     map[string]string `json:"metadataNames,omitempty"` // Metadata's keys as they arrived, where not lowercase
+	ChecksumAlgorithm string            `json:"checksumAlgorithm,omitempty"`
+	Checksum          string            `json:"checksum,omitempty"`
+}
+
+// newObjectMeta takes the Content-Type and user-defined metadata of a PUT
+// from its headers. Metadata keys are lowercased, as S3 does; the case
+// they arrived in is kept aside in MetadataNames.
+func newObjectMeta(h http.Header) objectMeta {
+	meta := objectMeta{ContentType: h.Get("Content-Type")}
+	for name, values := range h {
+		if key, ok := strings.CutPrefix(strings.ToLower(name), metaPrefix); ok {
+			if meta.Metadata == nil {
+				meta.Metadata = make(map[string]string)
+			}
+			meta.Metadata[key] = strings.Join(values, ",")
+			if received := name[len(metaPrefix):]; received != key {
+				if meta.MetadataNames == nil {
+					meta.MetadataNames = make(map[string]string)
+				}
+				meta.MetadataNames[key] = received
+			}
+		}
+	}
+	return meta
+}
+
+// empty reports whether there is nothing in meta worth keeping.
+func (m objectMeta) empty() bool {
+	return m.ContentType == "" && len(m.Metadata) == 0 && m.Checksum == ""
+}
+
+// setHeaders returns the stored Content-Type and user-defined metadata
+// of an object. Metadata headers are named in lowercase, as S3 names
+// them, rather than in the canonical form Header.Set would give them.
+func (m objectMeta) setHeaders(w http.ResponseWriter) {
+	if m.ContentType != "" {
+		w.Header().Set("Content-Type", m.ContentType)
+	}
+	for key, value := range m.Metadata {
+		w.Header()[metaPrefix+key] = []string{value}
+	}
+}
+
+// errStaleMeta marks a metadata entry that no longer matches its object.
+var errStaleMeta = errors.New("metadata doesn't match the object")
+
+// metaStore keeps an objectMeta per object, as a JSON file under the state
+// directory named after the key, so it is neither listed nor committed.
+// Each entry is replaced atomically on its own, without a lock over the
+// whole store; callers hold the key's lock. Objects without an entry,
+// such as those written before the store existed, are served as before,
+// with a Content-Type going by their extension.
+type metaStore struct {
+	dir   string
+	files fileSystem
+}
+
+func (m *metaStore) path(key string) string {
+	return filepath.Join(m.dir, filepath.FromSlash(key)+".json")
+}
+
+// get returns the entry of the object stored at key, if it has one that
+// still matches info.
+func (m *metaStore) get(key string, info os.FileInfo) (objectMeta, bool) {
+	var meta objectMeta
+	data, err := os.ReadFile(m.path(key))
+	if err != nil || json.Unmarshal(data, &meta) != nil {
+		return objectMeta{}, false
+	}
+	if meta.Size != info.Size() || !meta.ModTime.Equal(info.ModTime()) {
+		return objectMeta{}, false
+	}
+	return meta, true
+}
+
+// put records meta for the object stored at fullPath, replacing any entry
+// it had. An empty meta removes the entry instead.
+func (m *metaStore) put(key, fullPath string, meta objectMeta) error {
+	if meta.empty() {
+		m.remove(key)
+		return nil
+	}
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return err
+	}
+	meta.Size, meta.ModTime = info.Size(), info.ModTime()
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+
+	path := m.path(key)
+	if err := m.files.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := m.files.CreateTemp(filepath.Dir(path), "meta-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return m.files.Rename(f.Name(), path)
+}
+
+// remove drops the entry of key, if any, along with directories it
+// leaves empty.
+func (m *metaStore) remove(key string) {
+	path := m.path(key)
+	if os.Remove(path) != nil {
+		return
+	}
+	for dir := filepath.Dir(path); dir != m.dir; dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package s3
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Errors Move fails with before moving anything.
+var (
+	ErrMoveInvalid     = errors.New("invalid move")
+	ErrMoveDenied      = errors.New("move not allowed")
+	ErrMoveNotFound    = errors.New("nothing to move")
+	ErrMoveExists      = errors.New("destination exists")
+	ErrMoveUnsupported = errors.New("move not supported")
+)
+
+// MovedObject is an object Move moved, by its old and new key.
+type MovedObject struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// Move renames the object from to the key to, or with prefix every object
+// under the prefix from to the same key under to; both prefixes then end
+// in "/", and to may not lie within from. The files are renamed in place,
+// content and all, so git sees renames and nothing is copied, and one
+// sync covers the whole move.
+//
+// Nothing moves unless every object can: the sources must exist, every
+// key involved must be writable, no destination may exist yet and no
+// object may be kept in LFS at either key. An
+// error part way through leaves what was moved moved, and synced, and
+// returns it with the error.
+func (s *Handler) Move(from, to string, prefix bool) ([]MovedObject, error) {
+	if s.treeLock != nil {
+		s.treeLock.Lock()
+		defer s.treeLock.Unlock()
+	}
+	moves, err := s.planMove(from, to, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var moved []MovedObject
+	var changed, froms, tos []string
+	for _, m := range moves {
+		var files []string
+		if files, err = s.moveObject(m.From, m.To); err != nil {
+			err = fmt.Errorf("move %s: %w", m.From, err)
+			break
+		}
+		changed = append(changed, files...)
+		moved = append(moved, m)
+		froms, tos = append(froms, m.From), append(tos, m.To)
+	}
+
+	// What was moved is synced even if not everything could be
+	if len(moved) > 0 {
+		s.manifest.changed()
+		s.syncer.Trigger(changed...)
+		if s.watcher != nil {
+			s.watcher.ObjectsChanged("delete", froms)
+			s.watcher.ObjectsChanged("put", tos)
+		}
+		for _, m := range moved {
+			size, _ := s.objectSize(s.keyFile(m.To))
+			s.notify(EventObjectRemoved, m.From, 0, "")
+			s.notify(EventObjectCreatedCopy, m.To, size, "")
+		}
+	}
+	s.logger.Printf("[s3] moved %d objects from %q to %q", len(moved), from, to)
+	return moved, err
+}
+
+// planMove checks a move and returns the objects it moves.
+func (s *Handler) planMove(from, to string, prefix bool) ([]MovedObject, error) {
+	if s.readOnly.Load() {
+		return nil, fmt.Errorf("%w: bucket is read-only", ErrMoveDenied)
+	}
+	switch {
+	case from == "" || to == "":
+		return nil, fmt.Errorf("%w: from and to are required", ErrMoveInvalid)
+	case from == to:
+		return nil, fmt.Errorf("%w: %s onto itself", ErrMoveInvalid, from)
+	case prefix && (!strings.HasSuffix(from, "/") || !strings.HasSuffix(to, "/")):
+		return nil, fmt.Errorf("%w: prefixes must end in /", ErrMoveInvalid)
+	case prefix && strings.HasPrefix(to, from):
+		return nil, fmt.Errorf("%w: %s into itself", ErrMoveInvalid, from)
+	case !prefix && (strings.HasSuffix(from, "/") || strings.HasSuffix(to, "/")):
+		return nil, fmt.Errorf("%w: a key moves to a key; set prefix to move a prefix", ErrMoveInvalid)
+	}
+
+	var moves []MovedObject
+	if prefix {
+		err := s.walkKeys(from, "", 0, func(key string, _ fs.FileInfo) error {
+			moves = append(moves, MovedObject{From: key, To: to + strings.TrimPrefix(key, from)})
+			return nil
+		}, nil)
+		if err != nil {
+			return nil, err
+		}
+	} else if fullPath, ok := s.objectPath(from); ok {
+		if info, err := os.Stat(fullPath); err == nil && info.Mode().IsRegular() {
+			moves = append(moves, MovedObject{From: from, To: to})
+		}
+	}
+	if len(moves) == 0 {
+		return nil, fmt.Errorf("%w: no object at %s", ErrMoveNotFound, from)
+	}
+
+	for _, m := range moves {
+		if _, message, ok := s.checkKey(m.To); !ok {
+			return nil, fmt.Errorf("%w: %s", ErrMoveInvalid, message)
+		}
+		if !s.writeAllowed(m.From) || !s.writeAllowed(m.To) {
+			return nil, fmt.Errorf("%w: writes to %s or %s are not allowed", ErrMoveDenied, m.From, m.To)
+		}
+		toPath, ok := s.objectPath(m.To)
+		if !ok {
+			return nil, fmt.Errorf("%w: invalid key %s", ErrMoveInvalid, m.To)
+		}
+		if _, err := os.Lstat(toPath); !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("%w: %s", ErrMoveExists, m.To)
+		}
+		if s.inLFS(m) {
+			return nil, fmt.Errorf("%w: %s is kept in LFS; download it and upload it again instead", ErrMoveUnsupported, m.From)
+		}
+	}
+	return moves, nil
+}
+
+// inLFS reports whether m moves an LFS pointer, or an object LFS tracks
+// at either key. A rename carries neither the pointer's .gitattributes
+// entry nor the clean step over, so git would commit the destination
+// wrong.
+func (s *Handler) inLFS(m MovedObject) bool {
+	if s.lfs == nil {
+		return false
+	}
+	info, err := os.Stat(s.keyFile(m.From))
+	if err != nil {
+		return false
+	}
+	size := s.lfs.Size(s.keyFile(m.From), info.Size())
+	return size != info.Size() || s.lfs.Track(m.From, size) || s.lfs.Track(m.To, size)
+}
+
+// moveObject renames the object from to the key to, with its metadata,
+// and returns the files that changed. The keys are locked in order, so
+// moves between the same keys both ways can't deadlock, and then the
+// write lock, as PUTs take them.
+func (s *Handler) moveObject(from, to string) ([]string, error) {
+	unlockFirst := s.keys.lock(min(from, to))
+	defer unlockFirst()
+	unlockSecond := s.keys.lock(max(from, to))
+	defer unlockSecond()
+	if s.writeLock != nil {
+		s.writeLock.Lock()
+		defer s.writeLock.Unlock()
+	}
+
+	fromPath, toPath := s.keyFile(from), s.keyFile(to)
+	if _, err := os.Lstat(toPath); !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrMoveExists, to) // written since the move was planned
+	}
+	info, err := os.Stat(fromPath)
+	if err != nil {
+		return nil, err
+	}
+	meta, hasMeta := s.meta.get(from, info)
+	if err := s.files.MkdirAll(filepath.Dir(toPath), 0755); err != nil {
+		return nil, err
+	}
+	if err := s.files.Rename(fromPath, toPath); err != nil {
+		return nil, err
+	}
+	if s.layout != nil {
+		s.layout.remove(fromPath, from)
+		if err := s.layout.add(toPath, to); err != nil {
+			return nil, err
+		}
+	}
+	s.meta.remove(from)
+	if hasMeta {
+		if err := s.meta.put(to, toPath, meta); err != nil {
+			return nil, err
+		}
+	}
+	size, _ := s.objectSize(toPath)
+	s.stats.remove(from)
+	s.stats.set(to, size)
+	s.cache.remove(from)
+	s.cache.remove(to)
+	s.removeEmptyDirs(filepath.Dir(fromPath))
+	return append(s.changedFiles(fromPath), s.changedFiles(toPath)...), nil
+}
@@ -0,0 +1,127 @@
+package s3
+
+import (
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"git3/internal/lfs"
+)
+
+func TestMovePrefix(t *testing.T) {
+	dir := t.TempDir()
+	syncer := &recordingSyncer{}
+	h := NewHandlerWithOptions(dir, WithSyncer(syncer))
+	req := httptest.NewRequest("PUT", "/vault/notes/a.md", strings.NewReader("hello"))
+	req.Header.Set("Content-Type", "text/x-note")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	serve(h, "PUT", "/vault/notes/sub/b.md", "hi")
+	serve(h, "PUT", "/vault/notesbook.md", "not under notes/")
+	syncer.triggered = nil
+
+	moved, err := h.Move("notes/", "archive/2025/", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []MovedObject{{"notes/a.md", "archive/2025/a.md"}, {"notes/sub/b.md", "archive/2025/sub/b.md"}}
+	if !slices.Equal(moved, want) {
+		t.Fatalf("moved %v, want %v", moved, want)
+	}
+	if w := serve(h, "GET", "/vault/archive/2025/a.md", ""); w.Body.String() != "hello" || w.Header().Get("Content-Type") != "text/x-note" {
+		t.Fatalf("moved object = %q %s, want its content and metadata", w.Body, w.Header().Get("Content-Type"))
+	}
+	if _, err := os.Stat(filepath.Join(dir, "notes")); !os.IsNotExist(err) {
+		t.Fatal("source directory left behind")
+	}
+	if w := serve(h, "GET", "/vault/notesbook.md", ""); w.Code != 200 {
+		t.Fatal("object outside the prefix moved")
+	}
+	// One sync, for both ends of every move
+	if want := []string{"notes/a.md", "archive/2025/a.md", "notes/sub/b.md", "archive/2025/sub/b.md"}; !slices.Equal(syncer.triggered, want) {
+		t.Fatalf("triggered %v, want %v", syncer.triggered, want)
+	}
+}
+
+func TestMoveRefused(t *testing.T) {
+	h, dir := newTestHandler(t)
+	for _, key := range []string{"a.md", "b.md", "notes/a.md", "notes/b.md", "other/b.md"} {
+		serve(h, "PUT", "/vault/"+key, key)
+	}
+	for _, tc := range []struct {
+		from, to string
+		prefix   bool
+		want     error
+	}{
+		{"a.md", "a.md", false, ErrMoveInvalid},
+		{"a.md", "", false, ErrMoveInvalid},
+		{"notes/", "notes/", true, ErrMoveInvalid},
+		{"notes/", "notes/old/", true, ErrMoveInvalid}, // into itself
+		{"notes", "archive", true, ErrMoveInvalid},     // prefixes end in /
+		{"notes/", "archive/", false, ErrMoveInvalid},  // a prefix needs prefix set
+		{"a.md", ".git/a.md", false, ErrMoveInvalid},
+		{"missing.md", "c.md", false, ErrMoveNotFound},
+		{"notes", "c.md", false, ErrMoveNotFound}, // a directory isn't an object
+		{"missing/", "c/", true, ErrMoveNotFound},
+		{"a.md", "b.md", false, ErrMoveExists},
+		{"notes/", "other/", true, ErrMoveExists},
+	} {
+		if _, err := h.Move(tc.from, tc.to, tc.prefix); !errors.Is(err, tc.want) {
+			t.Errorf("Move(%q, %q, %v) = %v, want %v", tc.from, tc.to, tc.prefix, err, tc.want)
+		}
+	}
+	// A refused move moves nothing, even what wouldn't collide
+	if _, err := os.Stat(filepath.Join(dir, "notes", "a.md")); err != nil {
+		t.Fatal(err)
+	}
+
+	h.SetReadOnly(true)
+	if _, err := h.Move("a.md", "c.md", false); !errors.Is(err, ErrMoveDenied) {
+		t.Fatalf("read-only move = %v", err)
+	}
+}
+
+func TestMoveLFSRefused(t *testing.T) {
+	dir := t.TempDir()
+	l, err := lfs.New(lfs.Config{Root: dir, GitDir: filepath.Join(dir, ".git"), Patterns: []string{"*.png"}, Threshold: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandlerWithOptions(dir, WithLFS(l))
+	serve(h, "PUT", "/vault/cat.png", "fake image bytes")
+	serve(h, "PUT", "/vault/big.bin", strings.Repeat("x", 100)) // tracked by size alone
+	serve(h, "PUT", "/vault/cat.txt", "a note")
+
+	for _, tc := range []struct{ from, to string }{
+		{"cat.png", "img/cat.png"},
+		{"big.bin", "big.dat"},
+		{"cat.txt", "img/cat.png"}, // would need cleaning at its new key
+	} {
+		if _, err := h.Move(tc.from, tc.to, false); !errors.Is(err, ErrMoveUnsupported) {
+			t.Errorf("Move(%q, %q) = %v, want %v", tc.from, tc.to, err, ErrMoveUnsupported)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "cat.png")); err != nil {
+		t.Fatal("refused move moved the pointer:", err)
+	}
+	if _, err := h.Move("cat.txt", "notes/cat.txt", false); err != nil {
+		t.Fatal("move of an object outside LFS:", err)
+	}
+}
+
+func TestMoveHashedLayout(t *testing.T) {
+	h := NewHandlerWithOptions(t.TempDir(), WithHashedLayout())
+	serve(h, "PUT", "/vault/a.md", "hello")
+	if _, err := h.Move("a.md", "notes/a.md", false); err != nil {
+		t.Fatal(err)
+	}
+	if w := serve(h, "GET", "/vault/notes/a.md", ""); w.Body.String() != "hello" {
+		t.Fatalf("moved object = %d %q", w.Code, w.Body)
+	}
+	if w := serve(h, "GET", "/vault?list-type=2", ""); strings.Count(w.Body.String(), "<Key>") != 1 || !strings.Contains(w.Body.String(), "<Key>notes/a.md</Key>") {
+		t.Fatalf("listing = %s", w.Body)
+	}
+}
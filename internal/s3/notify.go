@@ -0,0 +1,221 @@
+package s3
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"git3/internal/webhook"
+)
+
+// Delivery of event notifications: failed POSTs are retried as webhooks
+// are, the first retry after notifyBackoff. At most notifyQueue events
+// wait per destination; more are dropped while a receiver is down.
+var (
+	notifyBackoff = time.Second
+	notifyQueue   = 1000
+)
+
+// Event names, as they appear in event records.
+const (
+	EventObjectCreatedPut  = "ObjectCreated:Put"
+	EventObjectCreatedCopy = "ObjectCreated:Copy" // the new key of a moved object
+	EventObjectRemoved     = "ObjectRemoved:Delete"
+)
+
+// NotificationRule sends an S3 event notification to URL for every object
+// change that matches, like a bucket notification configuration.
+type NotificationRule struct {
+	ID     string   // reported as configurationId
+	URL    string   // receives each event as a JSON POST
+	Events []string // e.g. "s3:ObjectCreated:*" or "s3:ObjectRemoved:Delete"; all if empty
+	Prefix string   // only keys starting with this
+	Suffix string   // only keys ending with this
+	Secret string   // signs each body in webhook.SignatureHeader when set
+}
+
+// matches reports whether the rule covers event name on key.
+func (r NotificationRule) matches(name, key string) bool {
+	if !strings.HasPrefix(key, r.Prefix) || !strings.HasSuffix(key, r.Suffix) {
+		return false
+	}
+	if len(r.Events) == 0 {
+		return true
+	}
+	for _, pattern := range r.Events {
+		pattern = strings.TrimPrefix(pattern, "s3:")
+		if base, ok := strings.CutSuffix(pattern, "*"); ok && strings.HasPrefix(name, base) || pattern == name {
+			return true
+		}
+	}
+	return false
+}
+
+// notifier delivers event notifications in the background. Events for the
+// same key reach each destination in the order they happened; a slow key
+// doesn't hold up the others.
+type notifier struct {
+	destinations []*destination
+	sequence     atomic.Uint64
+}
+
+func newNotifier(rules []NotificationRule, logger *log.Logger) *notifier {
+	n := &notifier{}
+	for _, rule := range rules {
+		sender := webhook.New([]string{rule.URL}, rule.Secret)
+		sender.Backoff = notifyBackoff
+		n.destinations = append(n.destinations, &destination{
+			rule:    rule,
+			sender:  sender,
+			logger:  logger,
+			pending: make(map[string][][]byte),
+		})
+	}
+	return n
+}
+
+// notify queues an event record for every destination whose rule matches.
+func (n *notifier) notify(bucket, region, name, key string, size int64, etag string) {
+	now := time.Now().UTC()
+	seq := fmt.Sprintf("%016X", n.sequence.Add(1))
+	for _, d := range n.destinations {
+		if !d.rule.matches(name, key) {
+			continue
+		}
+		body, err := json.Marshal(eventRecords{Records: []eventRecord{{
+			EventVersion: "2.1",
+			EventSource:  "aws:s3",
+			AWSRegion:    region,
+			EventTime:    now.Format(xmlTimeFormat),
+			EventName:    name,
+			S3: eventS3{
+				SchemaVersion:   "1.0",
+				ConfigurationID: d.rule.ID,
+				Bucket:          eventBucket{Name: bucket, ARN: "arn:aws:s3:::" + bucket},
+				Object: eventObject{
+					Key:       eventKey(key),
+					Size:      size,
+					ETag:      strings.Trim(etag, `"`),
+					Sequencer: seq,
+				},
+			},
+		}}})
+		if err != nil {
+			continue
+		}
+		d.enqueue(key, body)
+	}
+}
+
+// eventKey URL-encodes a key the way S3 event records do: spaces become
+// "+" and slashes stay as they are.
+func eventKey(key string) string {
+	return strings.ReplaceAll(url.QueryEscape(key), "%2F", "/")
+}
+
+// destination is one rule's endpoint, with a queue of undelivered events
+// per key. A key is in pending for as long as a goroutine is draining it.
+type destination struct {
+	rule   NotificationRule
+	sender *webhook.Notifier
+	logger *log.Logger
+
+	mu      sync.Mutex
+	pending map[string][][]byte
+	queued  int
+	dropped int
+}
+
+func (d *destination) enqueue(key string, body []byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.queued >= notifyQueue {
+		if d.dropped++; d.dropped == 1 {
+			d.logger.Printf("[s3] notification queue for %s is full, dropping events", d.rule.URL)
+		}
+		return
+	}
+	d.queued++
+	q, draining := d.pending[key]
+	d.pending[key] = append(q, body)
+	if !draining {
+		go d.drain(key)
+	}
+}
+
+func (d *destination) drain(key string) {
+	for {
+		d.mu.Lock()
+		q := d.pending[key]
+		if len(q) == 0 {
+			delete(d.pending, key)
+			d.mu.Unlock()
+			return
+		}
+		d.pending[key] = q[1:]
+		d.mu.Unlock()
+
+		d.deliver(q[0])
+
+		d.mu.Lock()
+		d.queued--
+		if d.queued == 0 && d.dropped > 0 {
+			d.logger.Printf("[s3] notification queue for %s drained, %d events were dropped", d.rule.URL, d.dropped)
+			d.dropped = 0
+		}
+		d.mu.Unlock()
+	}
+}
+
+func (d *destination) deliver(body []byte) {
+	if err := d.sender.Deliver(d.rule.URL, body); err != nil {
+		d.logger.Printf("[s3] notification to %s: %v", d.rule.URL, err)
+	}
+}
+
+// notify queues event notifications, if any are configured, for a change
+// to key. Callers hold the key's lock, so events queue in the order the
+// changes happened.
+func (s *Handler) notify(name, key string, size int64, etag string) {
+	if s.notifier != nil {
+		s.notifier.notify(s.bucket, s.region, name, key, size, etag)
+	}
+}
+
+// Event records, as S3 sends them to notification targets.
+type eventRecords struct {
+	Records []eventRecord `json:"Records"`
+}
+
+type eventRecord struct {
+	EventVersion string  `json:"eventVersion"`
+	EventSource  string  `json:"eventSource"`
+	AWSRegion    string  `json:"awsRegion"`
+	EventTime    string  `json:"eventTime"`
+	EventName    string  `json:"eventName"`
+	S3           eventS3 `json:"s3"`
+}
+
+type eventS3 struct {
+	SchemaVersion   string      `json:"s3SchemaVersion"`
+	ConfigurationID string      `json:"configurationId"`
+	Bucket          eventBucket `json:"bucket"`
+	Object          eventObject `json:"object"`
+}
+
+type eventBucket struct {
+	Name string `json:"name"`
+	ARN  string `json:"arn"`
+}
+
+type eventObject struct {
+	Key       string `json:"key"`
+	Size      int64  `json:"size,omitempty"`
+	ETag      string `json:"eTag,omitempty"`
+	Sequencer string `json:"sequencer"`
+}
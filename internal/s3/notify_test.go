@@ -0,0 +1,192 @@
+package s3
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"git3/internal/webhook"
+)
+
+// eventSink collects the event records POSTed to it.
+type eventSink struct {
+	*httptest.Server
+	mu      sync.Mutex
+	records []eventRecord
+}
+
+func newEventSink(t *testing.T, handle func(w http.ResponseWriter, rec eventRecord) bool) *eventSink {
+	sink := &eventSink{}
+	sink.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var records eventRecords
+		if err := json.Unmarshal(body, &records); err != nil || len(records.Records) != 1 {
+			t.Errorf("bad event body %s", body)
+			return
+		}
+		rec := records.Records[0]
+		if handle != nil && !handle(w, rec) {
+			return
+		}
+		sink.mu.Lock()
+		sink.records = append(sink.records, rec)
+		sink.mu.Unlock()
+	}))
+	t.Cleanup(sink.Close)
+	return sink
+}
+
+func (sink *eventSink) wait(t *testing.T, n int) []eventRecord {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		sink.mu.Lock()
+		records := append([]eventRecord(nil), sink.records...)
+		sink.mu.Unlock()
+		if len(records) >= n {
+			return records
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d events, want %d", len(records), n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func serve(h http.Handler, method, target, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+	return w
+}
+
+func TestNotificationRecords(t *testing.T) {
+	sink := newEventSink(t, nil)
+	h := NewHandlerWithOptions(t.TempDir(), WithNotifications(NotificationRule{ID: "sync", URL: sink.URL}))
+
+	w := serve(h, "PUT", "/vault/notes/my%20note.md", "hello")
+	serve(h, "DELETE", "/vault/notes/my%20note.md", "")
+
+	records := sink.wait(t, 2)
+	put, del := records[0], records[1]
+	if put.EventName != "ObjectCreated:Put" || put.EventSource != "aws:s3" || put.AWSRegion != "us-east-1" {
+		t.Fatalf("unexpected put record %+v", put)
+	}
+	if put.S3.Bucket.Name != "vault" || put.S3.Bucket.ARN != "arn:aws:s3:::vault" || put.S3.ConfigurationID != "sync" {
+		t.Fatalf("unexpected bucket in %+v", put.S3)
+	}
+	if put.S3.Object.Key != "notes/my+note.md" || put.S3.Object.Size != 5 || `"`+put.S3.Object.ETag+`"` != w.Header().Get("ETag") {
+		t.Fatalf("unexpected object %+v", put.S3.Object)
+	}
+	if _, err := time.Parse(xmlTimeFormat, put.EventTime); err != nil {
+		t.Fatalf("eventTime %q: %v", put.EventTime, err)
+	}
+	if del.EventName != "ObjectRemoved:Delete" || del.S3.Object.Key != "notes/my+note.md" {
+		t.Fatalf("unexpected delete record %+v", del)
+	}
+	if del.S3.Object.Sequencer <= put.S3.Object.Sequencer {
+		t.Fatalf("sequencer %s not after %s", del.S3.Object.Sequencer, put.S3.Object.Sequencer)
+	}
+}
+
+func TestNotificationSigned(t *testing.T) {
+	signatures := make(chan bool, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		signatures <- r.Header.Get(webhook.SignatureHeader) == webhook.Sign("s3cret", body)
+	}))
+	defer srv.Close()
+	h := NewHandlerWithOptions(t.TempDir(), WithNotifications(NotificationRule{URL: srv.URL, Secret: "s3cret"}))
+
+	serve(h, "PUT", "/vault/a.md", "hello")
+	select {
+	case ok := <-signatures:
+		if !ok {
+			t.Fatal("notification not signed with the rule's secret")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("notification not delivered")
+	}
+}
+
+func TestNotificationFilters(t *testing.T) {
+	sink := newEventSink(t, nil)
+	h := NewHandlerWithOptions(t.TempDir(), WithNotifications(NotificationRule{
+		URL:    sink.URL,
+		Events: []string{"s3:ObjectCreated:*"},
+		Prefix: "notes/",
+		Suffix: ".md",
+	}))
+
+	serve(h, "PUT", "/vault/attachments/a.md", "x") // wrong prefix
+	serve(h, "PUT", "/vault/notes/a.png", "x")      // wrong suffix
+	serve(h, "PUT", "/vault/notes/a.md", "x")
+	serve(h, "DELETE", "/vault/notes/a.md", "") // not subscribed
+	serve(h, "PUT", "/vault/notes/b.md", "x")
+
+	records := sink.wait(t, 2)
+	time.Sleep(50 * time.Millisecond)
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if len(sink.records) != 2 {
+		t.Fatalf("got %d events, want 2", len(sink.records))
+	}
+	for _, rec := range records {
+		if rec.EventName != "ObjectCreated:Put" || !strings.HasPrefix(rec.S3.Object.Key, "notes/") {
+			t.Fatalf("unexpected event %+v", rec)
+		}
+	}
+}
+
+func TestNotificationRetriesInOrder(t *testing.T) {
+	defer func(backoff time.Duration) { notifyBackoff = backoff }(notifyBackoff)
+	notifyBackoff = time.Millisecond
+
+	// The first delivery fails twice; the events after it must wait
+	var failures atomic.Int32
+	sink := newEventSink(t, func(w http.ResponseWriter, rec eventRecord) bool {
+		if rec.S3.Object.Size == 1 && failures.Add(1) <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return false
+		}
+		return true
+	})
+	h := NewHandlerWithOptions(t.TempDir(), WithNotifications(NotificationRule{URL: sink.URL}))
+
+	serve(h, "PUT", "/vault/a.md", "1")
+	serve(h, "PUT", "/vault/a.md", "22")
+	serve(h, "PUT", "/vault/a.md", "333")
+
+	records := sink.wait(t, 3)
+	for i, rec := range records {
+		if rec.S3.Object.Size != int64(i+1) {
+			t.Fatalf("event %d has size %d, want %d", i, rec.S3.Object.Size, i+1)
+		}
+	}
+	if failures.Load() < 2 {
+		t.Fatalf("first event failed %d times, want 2", failures.Load())
+	}
+}
+
+func TestNotificationDoesNotBlock(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+	h := NewHandlerWithOptions(t.TempDir(), WithNotifications(NotificationRule{URL: srv.URL}))
+
+	start := time.Now()
+	if w := serve(h, "PUT", "/vault/a.md", "x"); w.Code != http.StatusOK {
+		t.Fatalf("PUT got %d", w.Code)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Fatal("PUT waited for the notification")
+	}
+}
@@ -0,0 +1,93 @@
+package s3
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"time"
+)
+
+// objectInfoQuery is the query parameter asking a GET for an object's
+// description as JSON instead of its content. It is an extension to S3,
+// for the web UI, which would otherwise need a HEAD for the headers and
+// the admin API for the history.
+const objectInfoQuery = "git3-meta"
+
+// VersionCounter counts the versions of an object kept in the history
+// behind the bucket. The path is the object's file relative to the root.
+type VersionCounter interface {
+	Versions(path string) (int, error)
+}
+
+// objectInfo is the JSON document returned for objectInfoQuery.
+type objectInfo struct {
+	Key          string            `json:"key"`
+	Size         int64             `json:"size"`
+	ETag         string            `json:"etag"`
+	LastModified time.Time         `json:"lastModified"`
+	ContentType  string            `json:"contentType,omitempty"`
+	Metadata     map[string]string `json:"metadata,omitempty"`
+	MetaNames    map[string]string `json:"metadataNames,omitempty"`
+	Versions     *int              `json:"versions,omitempty"` // committed versions; nil without a VersionCounter
+}
+
+// getObjectInfo answers a GET with objectInfoQuery: what a HEAD would
+// return, and the number of versions git has of the object. An object
+// written with SSE-C needs its key, as for a HEAD.
+func (s *Handler) getObjectInfo(w http.ResponseWriter, r *http.Request, key string) {
+	fullPath, ok := s.objectPath(key)
+	if !ok {
+		s.xmlError(w, http.StatusBadRequest, "InvalidArgument", "Invalid object key")
+		return
+	}
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		s.noSuchKey(w, r)
+		return
+	}
+	sse, err := parseCustomerKey(r.Header)
+	if err != nil {
+		s.xmlError(w, http.StatusBadRequest, "InvalidArgument", err.Error())
+		return
+	}
+	if err := s.checkCustomerKey(fullPath, sse); err != nil {
+		if !s.sseError(w, err) {
+			s.xmlError(w, http.StatusInternalServerError, "InternalError", err.Error())
+		}
+		return
+	}
+
+	meta, _ := s.meta.get(key, info)
+	doc := objectInfo{
+		Key:          key,
+		Size:         s.contentSize(fullPath, info),
+		ETag:         objectETag(key, info),
+		LastModified: lastModified(info),
+		ContentType:  meta.ContentType,
+		Metadata:     meta.Metadata,
+		MetaNames:    meta.MetadataNames,
+	}
+	if sse != nil {
+		doc.Size = s.storedSize(fullPath, info) - sseOverhead()
+	}
+	if doc.ContentType == "" {
+		doc.ContentType = s.contentType(key)
+	}
+	if doc.ContentType == "" {
+		doc.ContentType = mime.TypeByExtension(path.Ext(key))
+	}
+	if s.versions != nil {
+		n, err := s.versions.Versions(s.relPath(fullPath))
+		if err != nil {
+			s.xmlError(w, http.StatusInternalServerError, "InternalError", err.Error())
+			return
+		}
+		doc.Versions = &n
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(doc)
+}
@@ -0,0 +1,44 @@
+package s3
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// versionsFunc adapts a function to VersionCounter.
+type versionsFunc func(path string) (int, error)
+
+func (f versionsFunc) Versions(path string) (int, error) { return f(path) }
+
+func TestObjectInfo(t *testing.T) {
+	var asked string
+	h := NewHandlerWithOptions(t.TempDir(), WithHashedLayout(), WithVersionCounter(versionsFunc(func(path string) (int, error) {
+		asked = path
+		return 3, nil
+	})))
+	serve(h, "PUT", "/vault/notes/a.md", "hello")
+
+	w := serve(h, "GET", "/vault/notes/a.md?git3-meta", "")
+	var info objectInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &info); err != nil {
+		t.Fatalf("%d: %v", w.Code, err)
+	}
+	if info.Key != "notes/a.md" || info.Size != 5 || info.Versions == nil || *info.Versions != 3 || !strings.HasPrefix(info.ContentType, "text/markdown") {
+		t.Fatalf("git3-meta = %+v", info)
+	}
+	if want := h.relPath(h.layout.path("notes/a.md")); asked != want {
+		t.Fatalf("versions counted for %q, want the stored file %q", asked, want)
+	}
+
+	if w := serve(h, "GET", "/vault/missing.md?git3-meta", ""); w.Code != 404 {
+		t.Fatalf("git3-meta of a missing object = %d", w.Code)
+	}
+
+	// Without a counter, the count is left out
+	plain, _ := newTestHandler(t)
+	serve(plain, "PUT", "/vault/a.md", "hello")
+	if w := serve(plain, "GET", "/vault/a.md?git3-meta", ""); strings.Contains(w.Body.String(), "versions") {
+		t.Fatalf("git3-meta without a counter = %s", w.Body)
+	}
+}
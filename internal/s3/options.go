@@ -0,0 +1,252 @@
+package s3
+
+import (
+	"log"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Option configures a Handler created with NewHandlerWithOptions.
+type Option func(*Handler)
+
+// WithBucket sets the bucket name served by the handler (default "vault").
+func WithBucket(bucket string) Option {
+	return func(s *Handler) { s.bucket = bucket }
+}
+
+// WithCORS turns CORS on or off (default on). With it off, responses
+// carry no Access-Control-* headers and OPTIONS is not allowed.
+func WithCORS(enabled bool) Option {
+	return func(s *Handler) { s.corsEnabled = enabled }
+}
+
+// WithCORSOrigins sets the origins browsers may call the handler from
+// (default "*", any origin). Requests from other origins get no CORS
+// headers, and their preflights are rejected.
+func WithCORSOrigins(origins ...string) Option {
+	return func(s *Handler) { s.corsOrigins = origins }
+}
+
+// WithCORSHeaders limits the request headers a preflight may ask for to
+// these, matched regardless of case (default any). A preflight asking for
+// others is rejected.
+func WithCORSHeaders(headers ...string) Option {
+	return func(s *Handler) { s.corsHeaders = headers }
+}
+
+// WithCORSMaxAge sets how long browsers may cache a preflight's answer
+// (default DefaultCORSMaxAge); zero leaves it to the browser.
+func WithCORSMaxAge(maxAge time.Duration) Option {
+	return func(s *Handler) { s.corsMaxAge = maxAge }
+}
+
+// WithOwner sets the owner ID reported in ACLs (default "git3").
+func WithOwner(id string) Option {
+	return func(s *Handler) { s.owner = id }
+}
+
+// WithCredentials enables SigV4 authentication with the given key pair.
+// With an empty access key, requests are not authenticated.
+func WithCredentials(accessKey, secretKey string) Option {
+	return func(s *Handler) {
+		s.accessKey = accessKey
+		s.secretKey = secretKey
+	}
+}
+
+// WithSignedHeaders sets the headers a request's signature must cover
+// (default DefaultSignedHeaders). Requests signing fewer are rejected
+// with AccessDenied. Whatever the policy, host and x-amz-date or date
+// must be signed; without them a request is AuthorizationHeaderMalformed.
+func WithSignedHeaders(p SignedHeaderPolicy) Option {
+	return func(s *Handler) { s.signedHeaders = p }
+}
+
+// WithRequireTLS refuses signed requests that didn't arrive over TLS with
+// AccessDenied, before their signature is checked. Without it they are
+// served, and the first one is logged as a warning. A request forwarded
+// by one of proxies, such as a load balancer terminating TLS, counts as
+// arriving over TLS if it says so in X-Forwarded-Proto.
+func WithRequireTLS(require bool, proxies ...netip.Prefix) Option {
+	return func(s *Handler) { s.requireTLS, s.tlsProxies = require, proxies }
+}
+
+// WithAuthObserver tells o about every request that fails authentication.
+func WithAuthObserver(o AuthObserver) Option {
+	return func(s *Handler) { s.authObserver = o }
+}
+
+// WithRegion sets the region requests must be signed for (default "us-east-1").
+func WithRegion(region string) Option {
+	return func(s *Handler) { s.region = region }
+}
+
+// WithSyncer sets what is triggered after each PUT and DELETE.
+func WithSyncer(syncer Syncer) Option {
+	return func(s *Handler) { s.syncer = syncer }
+}
+
+// WithReadOnly rejects every PUT and DELETE with AccessDenied, until
+// Handler.SetReadOnly says otherwise.
+func WithReadOnly(readOnly bool) Option {
+	return func(s *Handler) { s.readOnly.Store(readOnly) }
+}
+
+// WithMaxKeyLength sets the longest key, in bytes, a PUT or DELETE may
+// name (default DefaultMaxKeyLength); longer ones get KeyTooLongError.
+func WithMaxKeyLength(n int) Option {
+	return func(s *Handler) { s.maxKeyLength = n }
+}
+
+// WithWriteKeyAllow only lets PUT and DELETE through for keys matching one
+// of patterns, rejecting the rest with AccessDenied; reads are not
+// affected. Patterns are path.Match globs per "/"-separated segment, where
+// a "**" segment spans any number of them: "notes/**" allows everything
+// under notes/. None allows every key.
+func WithWriteKeyAllow(patterns ...string) Option {
+	return func(s *Handler) { s.writeKeyAllow = patterns }
+}
+
+// WithMaxObjectSize rejects uploads larger than n bytes with EntityTooLarge.
+// Zero means no limit.
+func WithMaxObjectSize(n int64) Option {
+	return func(s *Handler) { s.maxObjectSize = n }
+}
+
+// WithLogger sets where the handler logs internal errors (default log.Default()).
+func WithLogger(logger *log.Logger) Option {
+	return func(s *Handler) { s.logger = logger }
+}
+
+// WithLFS stores objects that l tracks as Git LFS pointers.
+func WithLFS(l LFS) Option {
+	return func(s *Handler) { s.lfs = l }
+}
+
+// WithTreeLock makes every request hold l while it touches the tree, so
+// whoever replaces the tree wholesale can exclude them.
+func WithTreeLock(l sync.Locker) Option {
+	return func(s *Handler) { s.treeLock = l }
+}
+
+// WithWriteLock makes PUTs hold l while they put an object in place, so
+// whoever commits the tree can wait for them to finish.
+func WithWriteLock(l sync.Locker) Option {
+	return func(s *Handler) { s.writeLock = l }
+}
+
+// WithDedup stores identical content only once: objects become hardlinks
+// to a content-addressed blob, which is removed with its last object.
+// Objects sharing a blob also share its modification time.
+func WithDedup(dedup bool) Option {
+	return func(s *Handler) {
+		s.blobs = nil
+		if dedup {
+			s.blobs = &blobStore{dir: s.stateDir("blobs")}
+		}
+	}
+}
+
+// WithIndexDocument makes a GET or HEAD on a key ending in "/" return the
+// object name under that prefix, like S3 website hosting (e.g. "index.html").
+func WithIndexDocument(name string) Option {
+	return func(s *Handler) { s.indexDocument = name }
+}
+
+// WithErrorDocument serves the object key with a 404 status when a browser
+// GETs a missing object. Requests that don't accept HTML still get NoSuchKey.
+func WithErrorDocument(key string) Option {
+	return func(s *Handler) { s.errorDocument = key }
+}
+
+// WithDefaultContentType sets the Content-Type served for keys without an
+// extension, instead of sniffing their content.
+func WithDefaultContentType(contentType string) Option {
+	return func(s *Handler) { s.defaultContentType = contentType }
+}
+
+// WithContentTypes sets the Content-Type served for keys by extension
+// (e.g. ".md"), overriding the built-in types. ".md" defaults to
+// "text/markdown; charset=utf-8".
+func WithContentTypes(types map[string]string) Option {
+	return func(s *Handler) {
+		for ext, t := range types {
+			s.contentTypes[strings.ToLower(ext)] = t
+		}
+	}
+}
+
+// WithWatcher tells w about every object written or deleted.
+func WithWatcher(w Watcher) Option {
+	return func(s *Handler) { s.watcher = w }
+}
+
+// WithNotifications POSTs an S3 event record to each rule's URL after
+// objects matching it are written or deleted. Delivery is asynchronous and
+// retried; events for one key arrive in order.
+func WithNotifications(rules ...NotificationRule) Option {
+	return func(s *Handler) { s.notifications = append(s.notifications, rules...) }
+}
+
+// WithEncryption stores objects encrypted with c and decrypts them when
+// they are read. Objects already in the tree must be encrypted too; see
+// Handler.EncryptExisting.
+func WithEncryption(c *Cipher) Option {
+	return func(s *Handler) { s.cipher = c }
+}
+
+// WithIdempotencyWindow answers a PUT that repeats one made within window
+// with that PUT's ETag, without writing the object or triggering a sync
+// again, so clients retrying after a timeout don't cause a commit each. A
+// repeat has the same x-amz-client-token header, or none like the first,
+// the same content and metadata, and finds the object as the first left
+// it. Appends and PUTs with a customer key are never taken for repeats.
+// Zero, the default, turns it off.
+func WithIdempotencyWindow(window time.Duration) Option {
+	return func(s *Handler) {
+		s.idempotency = nil
+		if window > 0 {
+			s.idempotency = &idempotency{window: window}
+		}
+	}
+}
+
+// WithCache keeps the content of objects up to maxEntry bytes in memory,
+// maxTotal bytes of it at most, dropping the least recently read first.
+// GET and HEAD use a cached object while its file's modification time and
+// size are unchanged. Objects written with SSE-C are never cached. Either
+// limit being zero turns the cache off, the default.
+func WithCache(maxEntry, maxTotal int64) Option {
+	return func(s *Handler) { s.cache = newObjectCache(maxEntry, maxTotal) }
+}
+
+// WithRepoSizeGuard refuses PUTs of objects over largeObject bytes while
+// the repository r reports is over its size limit, answering them with
+// RepositoryTooLarge. Smaller objects are still accepted, so notes can be
+// edited while attachments wait for the repository to be cleaned up.
+func WithRepoSizeGuard(r RepoSizer, largeObject int64) Option {
+	return func(s *Handler) { s.repoSize, s.largeObject = r, largeObject }
+}
+
+// WithVersionCounter has v count the versions of each object reported by
+// the git3-meta extension. Without one, the count is left out.
+func WithVersionCounter(v VersionCounter) Option {
+	return func(s *Handler) { s.versions = v }
+}
+
+// WithHashedLayout stores objects under paths derived from their keys'
+// hashes rather than at the paths the keys name, spreading a bucket of
+// any shape over directories of a few hundred entries. Each object has a
+// ".key" file beside it, committed with it, that the index of keys is
+// rebuilt from. The layout is for new buckets: objects already stored at
+// their keys' paths aren't found in it.
+func WithHashedLayout() Option {
+	return func(s *Handler) { s.layout = &hashedLayout{root: s.dir} }
+}
+
+// nopSyncer is the default Syncer when none is configured.
+type nopSyncer struct{}
+
+func (nopSyncer) Trigger(...string) {}
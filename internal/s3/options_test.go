@@ -0,0 +1,96 @@
+package s3
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNewHandlerWithOptionsDefaults(t *testing.T) {
+	h := NewHandlerWithOptions(t.TempDir())
+	if h.bucket != "vault" || h.region != "us-east-1" || h.syncer == nil || h.logger == nil {
+		t.Fatalf("unexpected defaults: bucket=%q region=%q syncer=%v logger=%v", h.bucket, h.region, h.syncer, h.logger)
+	}
+
+	req := httptest.NewRequest("PUT", "/vault/a.md", strings.NewReader("a"))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT with default options got %d, want 200", w.Code)
+	}
+}
+
+func TestWithBucket(t *testing.T) {
+	h := NewHandlerWithOptions(t.TempDir(), WithBucket("notes"), WithSyncer(noopSyncer{}))
+
+	for path, want := range map[string]int{"/notes": http.StatusOK, "/vault": http.StatusNotFound} {
+		req := httptest.NewRequest("HEAD", path, nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != want {
+			t.Errorf("HEAD %s got %d, want %d", path, w.Code, want)
+		}
+	}
+}
+
+func TestWithReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a.md"), []byte("keep"), 0644)
+	h := NewHandlerWithOptions(dir, WithReadOnly(true))
+
+	for _, method := range []string{"PUT", "DELETE"} {
+		req := httptest.NewRequest(method, "/vault/a.md", strings.NewReader("changed"))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "AccessDenied") {
+			t.Errorf("%s got %d %s, want 403 AccessDenied", method, w.Code, w.Body.String())
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/vault/a.md", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Body.String() != "keep" {
+		t.Fatalf("GET got %d %q, want 200 keep", w.Code, w.Body.String())
+	}
+
+	h.SetReadOnly(false)
+	if w := serve(h, "PUT", "/vault/a.md", "changed"); w.Code != http.StatusOK {
+		t.Fatalf("PUT after SetReadOnly(false) got %d", w.Code)
+	}
+}
+
+func TestWithMaxObjectSize(t *testing.T) {
+	dir := t.TempDir()
+	h := NewHandlerWithOptions(dir, WithMaxObjectSize(4))
+
+	tests := []struct {
+		name string
+		body io.Reader
+		want int
+	}{
+		{"within limit", strings.NewReader("1234"), http.StatusOK},
+		{"declared too large", strings.NewReader("12345"), http.StatusBadRequest},
+		// No Content-Length, so the limit is only hit while copying
+		{"streamed too large", io.MultiReader(strings.NewReader("12345")), http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("PUT", "/vault/"+strings.ReplaceAll(tt.name, " ", "-"), tt.body)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != tt.want {
+			t.Errorf("%s: got %d, want %d", tt.name, w.Code, tt.want)
+		}
+		if tt.want == http.StatusBadRequest && !strings.Contains(w.Body.String(), "EntityTooLarge") {
+			t.Errorf("%s: body %s, want EntityTooLarge", tt.name, w.Body.String())
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "streamed-too-large")); !os.IsNotExist(err) {
+		t.Fatal("partial upload left behind after EntityTooLarge")
+	}
+}
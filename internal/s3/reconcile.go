@@ -0,0 +1,83 @@
+package s3
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// MetaReport summarizes a ReconcileMeta pass.
+type MetaReport struct {
+	Time     time.Time     `json:"time"`    // when the pass finished
+	Kept     int           `json:"kept"`    // entries that still match their object
+	Missing  int           `json:"missing"` // entries dropped because their object is gone
+	Stale    int           `json:"stale"`   // entries dropped because their object changed outside the API
+	Duration time.Duration `json:"duration_ns"`
+}
+
+// ReconcileMeta brings the metadata store in line with the working tree:
+// it drops the entries of objects deleted or changed outside the API, by
+// a pull or by hand, along with leftovers of interrupted writes. Objects
+// without an entry need none. The store is walked one directory at a
+// time and each entry checked under its key's lock, so the pass needs
+// little memory and can run while requests are served; one that is cut
+// short simply starts over next time.
+func (s *Handler) ReconcileMeta() (MetaReport, error) {
+	if s.treeLock != nil {
+		s.treeLock.Lock()
+		defer s.treeLock.Unlock()
+	}
+	start := time.Now()
+	var report MetaReport
+	err := walkObjects(s.meta.dir, "", func(name string, info os.FileInfo) error {
+		key, ok := strings.CutSuffix(name, ".json")
+		if !ok {
+			// A temp file of a save that never finished; one of a save
+			// in progress is at most moments old
+			if time.Since(info.ModTime()) > time.Minute {
+				os.Remove(filepath.Join(s.meta.dir, filepath.FromSlash(name)))
+			}
+			return nil
+		}
+		switch s.reconcileEntry(key) {
+		case os.ErrNotExist:
+			report.Missing++
+		case errStaleMeta:
+			report.Stale++
+		default:
+			report.Kept++
+		}
+		return nil
+	})
+	report.Time = time.Now()
+	report.Duration = time.Since(start)
+	if err == nil {
+		s.reconciled.Store(&report)
+	}
+	return report, err
+}
+
+// LastReconcile returns the report of the last ReconcileMeta pass, or nil
+// if none has finished.
+func (s *Handler) LastReconcile() *MetaReport {
+	return s.reconciled.Load()
+}
+
+// reconcileEntry checks the entry of key against its object, removing it
+// if the object is gone (os.ErrNotExist) or has changed (errStaleMeta).
+func (s *Handler) reconcileEntry(key string) error {
+	unlock := s.keys.lock(key)
+	defer unlock()
+
+	info, err := os.Stat(s.keyFile(key))
+	if err != nil || !info.Mode().IsRegular() {
+		s.meta.remove(key)
+		return os.ErrNotExist
+	}
+	if _, ok := s.meta.get(key, info); !ok {
+		s.meta.remove(key)
+		return errStaleMeta
+	}
+	return nil
+}
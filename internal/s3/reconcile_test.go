@@ -0,0 +1,50 @@
+package s3
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReconcileMeta(t *testing.T) {
+	h, dir := newTestHandler(t)
+	for _, key := range []string{"kept.md", "gone.md", "notes/edited.md"} {
+		req := httptest.NewRequest("PUT", "/vault/"+key, strings.NewReader("a"))
+		req.Header.Set("X-Amz-Meta-Author", "me")
+		serveRequest(h, req)
+	}
+	os.Remove(filepath.Join(dir, "gone.md"))
+	os.WriteFile(filepath.Join(dir, "notes", "edited.md"), []byte("pulled"), 0644)
+	leftover := filepath.Join(h.meta.dir, "meta-123")
+	os.WriteFile(leftover, nil, 0644)
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(leftover, old, old)
+
+	if h.LastReconcile() != nil {
+		t.Fatal("report before any pass")
+	}
+	report, err := h.ReconcileMeta()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Kept != 1 || report.Missing != 1 || report.Stale != 1 {
+		t.Fatalf("report = %+v", report)
+	}
+	if got := h.LastReconcile(); got == nil || *got != report {
+		t.Fatalf("LastReconcile = %+v", got)
+	}
+	for path, want := range map[string]bool{
+		h.meta.path("kept.md"):             true,
+		h.meta.path("gone.md"):             false,
+		h.meta.path("notes/edited.md"):     false,
+		filepath.Join(h.meta.dir, "notes"): false,
+		leftover:                           false,
+	} {
+		if _, err := os.Stat(path); (err == nil) != want {
+			t.Errorf("%s exists: %v, want %v", path, err == nil, want)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package s3
+
+import "fmt"
+
+// RepoSizer reports how big the repository behind the bucket is, and the
+// size it should stay under; a limit of zero means none.
+type RepoSizer interface {
+	RepoSize() (size, limit int64)
+}
+
+// checkRepoSize reports whether an object of length bytes may be written,
+// with the error message if it may not: large objects are refused while
+// the repository is over its limit.
+func (s *Handler) checkRepoSize(length int64) (string, bool) {
+	if s.repoSize == nil || length <= s.largeObject {
+		return "", true
+	}
+	size, limit := s.repoSize.RepoSize()
+	if limit <= 0 || size < limit {
+		return "", true
+	}
+	return fmt.Sprintf("The repository is %d bytes, over its limit of %d; objects over %d bytes are refused until it shrinks", size, limit, s.largeObject), false
+}
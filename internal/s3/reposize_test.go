@@ -0,0 +1,56 @@
+package s3
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type fixedRepoSize struct{ size, limit int64 }
+
+func (r *fixedRepoSize) RepoSize() (int64, int64) { return r.size, r.limit }
+
+func TestRepoSizeGuard(t *testing.T) {
+	repo := &fixedRepoSize{size: 100, limit: 1000}
+	h := NewHandlerWithOptions(t.TempDir(), WithRepoSizeGuard(repo, 10))
+
+	if w := serve(h, "PUT", "/vault/big.jpg", strings.Repeat("x", 20)); w.Code != 200 {
+		t.Fatalf("PUT under the limit = %d", w.Code)
+	}
+	repo.size = 1000
+	w := serve(h, "PUT", "/vault/big2.jpg", strings.Repeat("x", 20))
+	if w.Code != 507 || !strings.Contains(w.Body.String(), "<Code>RepositoryTooLarge</Code>") {
+		t.Fatalf("large PUT over the limit = %d %s", w.Code, w.Body)
+	}
+	if w := serve(h, "PUT", "/vault/note.md", "small"); w.Code != 200 {
+		t.Fatalf("small PUT over the limit = %d", w.Code)
+	}
+}
+
+func TestStatsKeptUpToDate(t *testing.T) {
+	h, dir := newTestHandler(t)
+	serve(h, "PUT", "/vault/a.md", "hello")
+	if objects, bytes, err := h.Stats(); err != nil || objects != 1 || bytes != 5 {
+		t.Fatalf("Stats = %d, %d, %v", objects, bytes, err)
+	}
+
+	// Writes through the handler adjust the counts without a walk, which
+	// would also count the file written behind its back
+	os.WriteFile(filepath.Join(dir, "outside.md"), []byte("outside"), 0644)
+	serve(h, "PUT", "/vault/a.md", "hello, world")
+	serve(h, "PUT", "/vault/b.md", "bb")
+	serve(h, "DELETE", "/vault/missing.md", "")
+	if objects, bytes, _ := h.Stats(); objects != 2 || bytes != 14 {
+		t.Fatalf("Stats after writes = %d, %d; want 2, 14", objects, bytes)
+	}
+	serve(h, "DELETE", "/vault/b.md", "")
+	if objects, bytes, _ := h.Stats(); objects != 1 || bytes != 12 {
+		t.Fatalf("Stats after a delete = %d, %d; want 1, 12", objects, bytes)
+	}
+
+	h.TreeChanged()
+	if objects, bytes, _ := h.Stats(); objects != 2 || bytes != 19 {
+		t.Fatalf("Stats after TreeChanged = %d, %d; want 2, 19", objects, bytes)
+	}
+}
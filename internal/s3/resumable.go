@@ -0,0 +1,254 @@
+package s3
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// partialLengthHeader reports how many bytes of a resumable upload the
+// handler has.
+const partialLengthHeader = "X-Git3-Partial-Length"
+
+// uploadSafetyMargin is how long an upload in progress is kept after its
+// last write whatever the maximum age, so a client between two chunks
+// never loses its upload.
+var uploadSafetyMargin = 10 * time.Minute
+
+// isResumable reports whether r is part of a resumable upload rather than
+// a plain PUT.
+func isResumable(r *http.Request) bool {
+	q := r.URL.Query()
+	return q.Has("offset") || q.Has("complete")
+}
+
+// partialPath is where the upload in progress for the object at fullPath
+// is kept. Partial uploads live in the state directory, so they are never
+// listed or committed.
+func (s *Handler) partialPath(fullPath string) string {
+	return s.stateDir("partial", filepath.FromSlash(s.relPath(fullPath)))
+}
+
+// putPartial handles the resumable upload extension. A PUT with
+// ?offset=N adds its body to the upload in progress for key, which must
+// be exactly N bytes long (0 starts over), and ?complete makes the upload
+// the object, as if it had been PUT whole. Both can be sent together.
+// Whatever part of a body arrives before a connection drops is kept, and
+// HEAD ?partial says how much that is.
+func (s *Handler) putPartial(w http.ResponseWriter, r *http.Request, key string) {
+	fullPath, ok := s.objectPath(key)
+	if !ok {
+		s.xmlError(w, http.StatusBadRequest, "InvalidArgument", "Invalid object key")
+		return
+	}
+	if code, msg, ok := checkMetadata(r.Header); !ok {
+		s.xmlError(w, http.StatusBadRequest, code, msg)
+		return
+	}
+	if sse, _ := parseCustomerKey(r.Header); sse != nil {
+		s.xmlError(w, http.StatusBadRequest, "InvalidRequest", "Resumable uploads cannot be encrypted with a customer key")
+		return
+	}
+	q := r.URL.Query()
+	var offset int64
+	if q.Has("offset") {
+		var err error
+		if offset, err = strconv.ParseInt(q.Get("offset"), 10, 64); err != nil || offset < 0 {
+			s.xmlError(w, http.StatusBadRequest, "InvalidArgument", "offset must be a non-negative integer")
+			return
+		}
+		if s.maxObjectSize > 0 && offset+max(r.ContentLength, 0) > s.maxObjectSize {
+			s.xmlError(w, http.StatusBadRequest, "EntityTooLarge", "Your proposed upload exceeds the maximum allowed object size")
+			return
+		}
+	}
+	if msg, ok := s.checkRepoSize(offset + max(r.ContentLength, 0)); !ok {
+		s.xmlError(w, http.StatusInsufficientStorage, "RepositoryTooLarge", msg)
+		return
+	}
+	onlyCreate, ok := createOnly(r.Header)
+	if !ok {
+		s.xmlError(w, http.StatusNotImplemented, "NotImplemented", "If-None-Match on a write only supports *")
+		return
+	}
+
+	unlock := s.keys.lock(key)
+	defer unlock()
+
+	partial := s.partialPath(fullPath)
+	if q.Has("offset") {
+		size, err := s.appendPartial(w, r, partial, offset)
+		w.Header().Set(partialLengthHeader, strconv.FormatInt(size, 10))
+		var tooLarge *http.MaxBytesError
+		switch {
+		case errors.Is(err, errOffsetMismatch):
+			s.xmlError(w, http.StatusConflict, "InvalidOffset", fmt.Sprintf("The upload in progress has %d bytes, not %d", size, offset))
+			return
+		case errors.As(err, &tooLarge):
+			s.xmlError(w, http.StatusBadRequest, "EntityTooLarge", "Your proposed upload exceeds the maximum allowed object size")
+			return
+		case err != nil:
+			// Most likely the client went away; what arrived is kept
+			s.xmlError(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+			return
+		}
+	}
+	if !q.Has("complete") {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	n, sum, err := fileSHA256(partial)
+	if errors.Is(err, os.ErrNotExist) {
+		s.xmlError(w, http.StatusNotFound, "NoSuchUpload", "No upload is in progress for this key")
+		return
+	} else if err != nil {
+		s.xmlError(w, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	// The upload can never complete, so it goes
+	if onlyCreate && s.preconditionFailed(w, fullPath) {
+		os.Remove(partial)
+		return
+	}
+	toLFS, err := s.store(partial, fullPath, key, n, sum, nil)
+	if err != nil {
+		s.xmlError(w, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	if err := s.meta.put(key, fullPath, newObjectMeta(r.Header)); err != nil {
+		s.logger.Printf("[s3] save metadata of %s: %v", key, err)
+	}
+
+	etag := fmt.Sprintf("\"%s\"", sum[:32])
+	w.Header().Set("ETag", etag)
+	w.WriteHeader(http.StatusOK)
+	s.written(r, fullPath, key, n, etag, toLFS)
+}
+
+var errOffsetMismatch = errors.New("offset doesn't match the upload in progress")
+
+// appendPartial adds r's body to the upload at partial, which must hold
+// offset bytes. It returns the upload's size afterwards, which includes
+// as much of the body as arrived if copying it failed.
+func (s *Handler) appendPartial(w http.ResponseWriter, r *http.Request, partial string, offset int64) (int64, error) {
+	var size int64
+	if info, err := os.Stat(partial); err == nil {
+		size = info.Size()
+	}
+	if offset != size {
+		return size, errOffsetMismatch
+	}
+	if err := s.files.MkdirAll(filepath.Dir(partial), 0755); err != nil {
+		return size, err
+	}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	if offset == 0 {
+		flags |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(partial, flags, 0644)
+	if err != nil {
+		return size, err
+	}
+	body := r.Body
+	if s.maxObjectSize > 0 {
+		body = http.MaxBytesReader(w, r.Body, max(s.maxObjectSize-offset, 0))
+	}
+	n, err := io.Copy(f, body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return offset + n, err
+}
+
+// headPartial answers HEAD ?partial with the size of the upload in
+// progress for key.
+func (s *Handler) headPartial(w http.ResponseWriter, r *http.Request, key string) {
+	fullPath, ok := s.objectPath(key)
+	if !ok {
+		s.xmlError(w, http.StatusBadRequest, "InvalidArgument", "Invalid object key")
+		return
+	}
+	info, err := os.Stat(s.partialPath(fullPath))
+	if err != nil {
+		s.xmlError(w, http.StatusNotFound, "NoSuchUpload", "No upload is in progress for this key")
+		return
+	}
+	w.Header().Set(partialLengthHeader, strconv.FormatInt(info.Size(), 10))
+	w.WriteHeader(http.StatusOK)
+}
+
+// fileSHA256 returns the size and hex SHA-256 of the file at path.
+func fileSHA256(path string) (int64, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return 0, "", err
+	}
+	return n, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// CleanUploads removes resumable uploads that were last written to more
+// than maxAge ago, and so were most likely abandoned by a client that
+// crashed or gave up. It returns how many it removed.
+func (s *Handler) CleanUploads(maxAge time.Duration) (int, error) {
+	cutoff := time.Now().Add(-max(maxAge, uploadSafetyMargin))
+	root := s.stateDir("partial")
+	var stale []string
+	err := walkObjects(root, "", func(key string, info os.FileInfo) error {
+		if info.ModTime().Before(cutoff) {
+			stale = append(stale, key)
+		}
+		return nil
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, key := range stale {
+		if s.removeUpload(root, key, cutoff) {
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// removeUpload removes the upload for key if it still hasn't been written
+// to since cutoff, reporting whether it did.
+func (s *Handler) removeUpload(root, key string, cutoff time.Time) bool {
+	// Holding the key keeps out a chunk arriving right now
+	unlock := s.keys.lock(key)
+	defer unlock()
+
+	partial := filepath.Join(root, filepath.FromSlash(key))
+	info, err := os.Stat(partial)
+	if err != nil || !info.ModTime().Before(cutoff) {
+		return false
+	}
+	if err := os.Remove(partial); err != nil {
+		s.logger.Printf("[s3] remove abandoned upload %s: %v", key, err)
+		return false
+	}
+	s.logger.Printf("[s3] removed abandoned upload %s (%d bytes, last written %s)", key, info.Size(), info.ModTime().Format(time.RFC3339))
+	for dir := filepath.Dir(partial); dir != root; dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return true
+}
@@ -0,0 +1,96 @@
+package s3
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestResumableUpload(t *testing.T) {
+	var triggers int
+	h := NewHandlerWithOptions(t.TempDir(), WithSyncer(triggerFunc(func() { triggers++ })))
+	body := "recorded on a train, uploaded in pieces"
+
+	// The connection drops part way through the first chunk
+	interrupted := io.MultiReader(strings.NewReader(body[:10]), iotest.ErrReader(io.ErrUnexpectedEOF))
+	serveRequest(h, httptest.NewRequest("PUT", "/vault/memo.md?offset=0", interrupted))
+
+	head := serve(h, "HEAD", "/vault/memo.md?partial", "")
+	if head.Code != http.StatusOK || head.Header().Get(partialLengthHeader) != "10" {
+		t.Fatalf("HEAD ?partial got %d, length %q", head.Code, head.Header().Get(partialLengthHeader))
+	}
+	if w := serve(h, "GET", "/vault/memo.md", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("GET of an unfinished upload got %d", w.Code)
+	}
+	if w := serve(h, "PUT", "/vault/memo.md?offset=5", body[5:]); w.Code != http.StatusConflict || w.Header().Get(partialLengthHeader) != "10" {
+		t.Fatalf("PUT at the wrong offset got %d, length %q", w.Code, w.Header().Get(partialLengthHeader))
+	}
+
+	if w := serve(h, "PUT", "/vault/memo.md?offset=10", body[10:20]); w.Code != http.StatusOK || w.Header().Get(partialLengthHeader) != "20" {
+		t.Fatalf("second chunk got %d, length %q", w.Code, w.Header().Get(partialLengthHeader))
+	}
+	if triggers != 0 {
+		t.Fatal("a partial upload triggered a sync")
+	}
+	put := serve(h, "PUT", "/vault/memo.md?offset=20&complete", body[20:])
+	if put.Code != http.StatusOK {
+		t.Fatalf("last chunk got %d: %s", put.Code, put.Body)
+	}
+	sum := hashSHA256([]byte(body))
+	if put.Header().Get("ETag") != `"`+sum[:32]+`"` {
+		t.Fatalf("ETag %s is not over the whole object", put.Header().Get("ETag"))
+	}
+	if triggers != 1 {
+		t.Fatalf("completing triggered %d syncs, want 1", triggers)
+	}
+
+	if w := serve(h, "GET", "/vault/memo.md", ""); w.Body.String() != body {
+		t.Fatalf("GET = %q, want %q", w.Body, body)
+	}
+	if w := serve(h, "HEAD", "/vault/memo.md?partial", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("HEAD ?partial after completing got %d", w.Code)
+	}
+	if w := serve(h, "PUT", "/vault/memo.md?complete", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("completing without an upload got %d", w.Code)
+	}
+	if w := serve(h, "GET", "/vault", ""); strings.Contains(w.Body.String(), "partial") {
+		t.Fatalf("listing shows the upload area: %s", w.Body)
+	}
+}
+
+func TestCleanUploads(t *testing.T) {
+	defer func(m time.Duration) { uploadSafetyMargin = m }(uploadSafetyMargin)
+	uploadSafetyMargin = time.Minute
+
+	root := t.TempDir()
+	h := NewHandlerWithOptions(root)
+	serve(h, "PUT", "/vault/old/memo.md?offset=0", "abandoned")
+	serve(h, "PUT", "/vault/new.md?offset=0", "in progress")
+	old := time.Now().Add(-2 * time.Hour)
+	os.Chtimes(filepath.Join(root, ".git3", "partial", "old", "memo.md"), old, old)
+
+	n, err := h.CleanUploads(time.Hour)
+	if err != nil || n != 1 {
+		t.Fatalf("CleanUploads = %d, %v; want 1", n, err)
+	}
+	if w := serve(h, "HEAD", "/vault/old/memo.md?partial", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("abandoned upload still there: %d", w.Code)
+	}
+	if _, err := os.Stat(filepath.Join(root, ".git3", "partial", "old")); !os.IsNotExist(err) {
+		t.Fatal("empty directory of the abandoned upload left behind")
+	}
+	if w := serve(h, "HEAD", "/vault/new.md?partial", ""); w.Code != http.StatusOK {
+		t.Fatalf("upload in progress removed: %d", w.Code)
+	}
+
+	// Nothing is ever removed within the safety margin
+	if n, _ := h.CleanUploads(0); n != 0 {
+		t.Fatalf("CleanUploads(0) removed %d fresh uploads", n)
+	}
+}
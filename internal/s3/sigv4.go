@@ -5,22 +5,58 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"net/http"
+	"slices"
 	"sort"
 	"strings"
+	"time"
 )
 
-func sigV4Verify(r *http.Request, accessKey, secretKey, region string) bool {
-	authHeader := r.Header.Get("Authorization")
-	if authHeader == "" {
-		return false
-	}
+// SignedHeaderPolicy lists the headers a SigV4 signature must cover.
+// Without it a client could sign only host, leaving the date and the
+// payload hash open to tampering.
+type SignedHeaderPolicy struct {
+	Required []string // on every request
+	Writes   []string // on PUT, POST and DELETE as well
+}
+
+// DefaultSignedHeaders requires host and x-amz-date to be signed, and
+// x-amz-content-sha256 on writes.
+var DefaultSignedHeaders = SignedHeaderPolicy{
+	Required: []string{"host", "x-amz-date"},
+	Writes:   []string{"x-amz-content-sha256"},
+}
 
-	// Parse: AWS4-HMAC-SHA256 Credential=KEY/DATE/REGION/s3/aws4_request, SignedHeaders=..., Signature=...
-	if !strings.HasPrefix(authHeader, "AWS4-HMAC-SHA256 ") {
-		return false
+// missing returns a header the policy requires that r's SignedHeaders
+// leave out, or "" if they cover them all.
+func (p SignedHeaderPolicy) missing(r *http.Request) string {
+	fields, ok := parseAuthorization(r.Header.Get("Authorization"))
+	if !ok || fields["SignedHeaders"] == "" {
+		return "" // malformed, which sigV4Verify reports
 	}
+	signed := make(map[string]bool)
+	for _, h := range strings.Split(fields["SignedHeaders"], ";") {
+		signed[strings.ToLower(h)] = true
+	}
+	required := p.Required
+	if r.Method == "PUT" || r.Method == "POST" || r.Method == "DELETE" {
+		required = append(required[:len(required):len(required)], p.Writes...)
+	}
+	for _, h := range required {
+		if !signed[strings.ToLower(h)] {
+			return strings.ToLower(h)
+		}
+	}
+	return ""
+}
 
-	parts := strings.TrimPrefix(authHeader, "AWS4-HMAC-SHA256 ")
+// parseAuthorization splits a SigV4 Authorization header
+// (AWS4-HMAC-SHA256 Credential=KEY/DATE/REGION/s3/aws4_request, SignedHeaders=..., Signature=...)
+// into its fields.
+func parseAuthorization(authHeader string) (map[string]string, bool) {
+	parts, ok := strings.CutPrefix(authHeader, "AWS4-HMAC-SHA256 ")
+	if !ok {
+		return nil, false
+	}
 	fields := make(map[string]string)
 	for _, part := range strings.Split(parts, ", ") {
 		kv := strings.SplitN(part, "=", 2)
@@ -28,30 +64,125 @@ func sigV4Verify(r *http.Request, accessKey, secretKey, region string) bool {
 			fields[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
 		}
 	}
+	return fields, true
+}
+
+// credentialRegion returns the region r's Authorization header was signed
+// for, or "" if it doesn't name one.
+func credentialRegion(r *http.Request) string {
+	fields, _ := parseAuthorization(r.Header.Get("Authorization"))
+	if parts := strings.Split(fields["Credential"], "/"); len(parts) == 5 {
+		return parts[2]
+	}
+	return ""
+}
+
+// Reasons a request fails authentication.
+const (
+	AuthMissingHeader   = "missing_header"   // no Authorization header
+	AuthBadPrefix       = "bad_prefix"       // not AWS4-HMAC-SHA256
+	AuthMalformed       = "malformed"        // fields missing or unparsable
+	AuthUnknownKey      = "unknown_key"      // access key isn't ours
+	AuthWrongRegion     = "wrong_region"     // signed for another region
+	AuthSkewedDate      = "skewed_date"      // X-Amz-Date too far from now
+	AuthUnsignedHeaders = "unsigned_headers" // SignedHeaders miss a required header
+	AuthAbsentHeader    = "absent_header"    // a signed header isn't in the request
+	AuthBadSignature    = "bad_signature"    // signature doesn't match
+)
+
+// AuthPlaintext is the reason a signed request is refused for not arriving
+// over TLS, under WithRequireTLS.
+const AuthPlaintext = "plaintext"
+
+// AuthFailureReasons lists every reason, for metrics that report zeros too.
+var AuthFailureReasons = []string{
+	AuthMissingHeader, AuthBadPrefix, AuthMalformed, AuthUnknownKey,
+	AuthWrongRegion, AuthSkewedDate, AuthUnsignedHeaders, AuthAbsentHeader,
+	AuthBadSignature, AuthPlaintext,
+}
+
+// maxClockSkew is how far X-Amz-Date may be from the server's clock, as
+// on S3.
+const maxClockSkew = 15 * time.Minute
+
+// checkDate returns AuthSkewedDate unless r's date is within
+// maxClockSkew of now.
+func checkDate(r *http.Request, now time.Time) string {
+	date, err := time.Parse("20060102T150405Z", requestDate(r))
+	if err != nil || date.Sub(now).Abs() > maxClockSkew {
+		return AuthSkewedDate
+	}
+	return ""
+}
+
+// requestDate returns r's X-Amz-Date or, without one, its Date in the
+// same form, as SigV4 signs it.
+func requestDate(r *http.Request) string {
+	if date := r.Header.Get("X-Amz-Date"); date != "" {
+		return date
+	}
+	date, err := http.ParseTime(r.Header.Get("Date"))
+	if err != nil {
+		return ""
+	}
+	return date.UTC().Format("20060102T150405Z")
+}
+
+// mandatorySigned reports whether signed, a SignedHeaders list, covers
+// what every SigV4 signature must, whatever the SignedHeaderPolicy: the
+// host, so a signature can't be replayed against another, and the date.
+func mandatorySigned(signed []string) bool {
+	return slices.Contains(signed, "host") && (slices.Contains(signed, "x-amz-date") || slices.Contains(signed, "date"))
+}
+
+// sigV4Verify checks r's signature, returning why it fails or "" if it
+// is valid.
+func sigV4Verify(r *http.Request, accessKey, secretKey, region string) string {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		return AuthMissingHeader
+	}
+	fields, ok := parseAuthorization(authHeader)
+	if !ok {
+		return AuthBadPrefix
+	}
 
 	credential := fields["Credential"]
 	signedHeadersStr := fields["SignedHeaders"]
 	signature := fields["Signature"]
 
 	if credential == "" || signedHeadersStr == "" || signature == "" {
-		return false
+		return AuthMalformed
 	}
 
 	// Parse credential: accessKey/date/region/s3/aws4_request
 	credParts := strings.Split(credential, "/")
-	if len(credParts) != 5 || credParts[0] != accessKey {
-		return false
+	if len(credParts) != 5 {
+		return AuthMalformed
+	}
+	if credParts[0] != accessKey {
+		return AuthUnknownKey
 	}
 	dateStamp := credParts[1]
 	credRegion := credParts[2]
 	service := credParts[3]
 
 	if credRegion != region {
-		return false
+		return AuthWrongRegion
 	}
 
-	// Build canonical request
 	signedHeaders := strings.Split(signedHeadersStr, ";")
+	if !mandatorySigned(signedHeaders) {
+		return AuthUnsignedHeaders
+	}
+	// A signed header the request doesn't carry would be signed as empty
+	for _, h := range signedHeaders {
+		if _, ok := r.Header[http.CanonicalHeaderKey(h)]; !ok && h != "host" {
+			return AuthAbsentHeader
+		}
+	}
+
+	// Build canonical request
 	sort.Strings(signedHeaders)
 
 	var canonicalHeaders strings.Builder
@@ -88,7 +219,7 @@ func sigV4Verify(r *http.Request, accessKey, secretKey, region string) bool {
 	}, "\n")
 
 	// String to sign
-	amzDate := r.Header.Get("X-Amz-Date")
+	amzDate := requestDate(r)
 	stringToSign := strings.Join([]string{
 		"AWS4-HMAC-SHA256",
 		amzDate,
@@ -102,7 +233,10 @@ func sigV4Verify(r *http.Request, accessKey, secretKey, region string) bool {
 	// Calculate signature
 	expectedSig := hex.EncodeToString(hmacSHA256(signingKey, []byte(stringToSign)))
 
-	return hmac.Equal([]byte(signature), []byte(expectedSig))
+	if !hmac.Equal([]byte(signature), []byte(expectedSig)) {
+		return AuthBadSignature
+	}
+	return ""
 }
 
 func sortQueryString(qs string) string {
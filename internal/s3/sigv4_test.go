@@ -4,7 +4,9 @@ import (
 	"encoding/hex"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestSortQueryString(t *testing.T) {
@@ -53,40 +55,40 @@ func TestDeriveSigningKey(t *testing.T) {
 
 func TestSigV4VerifyEmptyHeader(t *testing.T) {
 	req := httptest.NewRequest("GET", "/vault", nil)
-	if sigV4Verify(req, "key", "secret", "us-east-1") {
-		t.Fatal("expected false for empty auth header")
+	if got := sigV4Verify(req, "key", "secret", "us-east-1"); got != AuthMissingHeader {
+		t.Fatalf("empty auth header failed with %q, want %q", got, AuthMissingHeader)
 	}
 }
 
 func TestSigV4VerifyBadPrefix(t *testing.T) {
 	req := httptest.NewRequest("GET", "/vault", nil)
 	req.Header.Set("Authorization", "Bearer token123")
-	if sigV4Verify(req, "key", "secret", "us-east-1") {
-		t.Fatal("expected false for non-AWS4 auth")
+	if got := sigV4Verify(req, "key", "secret", "us-east-1"); got != AuthBadPrefix {
+		t.Fatalf("non-AWS4 auth failed with %q, want %q", got, AuthBadPrefix)
 	}
 }
 
 func TestSigV4VerifyMissingFields(t *testing.T) {
 	req := httptest.NewRequest("GET", "/vault", nil)
 	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential=key/20230101/us-east-1/s3/aws4_request")
-	if sigV4Verify(req, "key", "secret", "us-east-1") {
-		t.Fatal("expected false for missing SignedHeaders/Signature")
+	if got := sigV4Verify(req, "key", "secret", "us-east-1"); got != AuthMalformed {
+		t.Fatalf("missing SignedHeaders/Signature failed with %q, want %q", got, AuthMalformed)
 	}
 }
 
 func TestSigV4VerifyWrongAccessKey(t *testing.T) {
 	req := httptest.NewRequest("GET", "/vault", nil)
 	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential=wrongkey/20230101/us-east-1/s3/aws4_request, SignedHeaders=host, Signature=abc123")
-	if sigV4Verify(req, "key", "secret", "us-east-1") {
-		t.Fatal("expected false for wrong access key")
+	if got := sigV4Verify(req, "key", "secret", "us-east-1"); got != AuthUnknownKey {
+		t.Fatalf("wrong access key failed with %q, want %q", got, AuthUnknownKey)
 	}
 }
 
 func TestSigV4VerifyWrongRegion(t *testing.T) {
 	req := httptest.NewRequest("GET", "/vault", nil)
 	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential=key/20230101/eu-west-1/s3/aws4_request, SignedHeaders=host, Signature=abc123")
-	if sigV4Verify(req, "key", "secret", "us-east-1") {
-		t.Fatal("expected false for wrong region")
+	if got := sigV4Verify(req, "key", "secret", "us-east-1"); got != AuthWrongRegion {
+		t.Fatalf("wrong region failed with %q, want %q", got, AuthWrongRegion)
 	}
 }
 
@@ -118,8 +120,8 @@ func TestSigV4VerifyValidSignature(t *testing.T) {
 	authHeader := "AWS4-HMAC-SHA256 Credential=" + accessKey + "/" + dateStamp + "/" + region + "/s3/aws4_request, SignedHeaders=" + signedHeaders + ", Signature=" + signature
 	req.Header.Set("Authorization", authHeader)
 
-	if !sigV4Verify(req, accessKey, secretKey, region) {
-		t.Fatal("expected valid signature to verify")
+	if got := sigV4Verify(req, accessKey, secretKey, region); got != "" {
+		t.Fatalf("expected valid signature to verify, failed with %q", got)
 	}
 }
 
@@ -153,8 +155,8 @@ func TestSigV4VerifyURLEncodedPath(t *testing.T) {
 	authHeader := "AWS4-HMAC-SHA256 Credential=" + accessKey + "/" + dateStamp + "/" + region + "/s3/aws4_request, SignedHeaders=" + signedHeaders + ", Signature=" + signature
 	req.Header.Set("Authorization", authHeader)
 
-	if !sigV4Verify(req, accessKey, secretKey, region) {
-		t.Fatal("expected valid signature for URL-encoded path")
+	if got := sigV4Verify(req, accessKey, secretKey, region); got != "" {
+		t.Fatalf("expected valid signature for URL-encoded path, failed with %q", got)
 	}
 }
 
@@ -169,7 +171,114 @@ func TestSigV4VerifyTamperedSignature(t *testing.T) {
 	req.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
 	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKey+"/20230101/"+region+"/s3/aws4_request, SignedHeaders=host;x-amz-content-sha256;x-amz-date, Signature=0000000000000000000000000000000000000000000000000000000000000000")
 
-	if sigV4Verify(req, accessKey, secretKey, region) {
-		t.Fatal("expected tampered signature to fail")
+	if got := sigV4Verify(req, accessKey, secretKey, region); got != AuthBadSignature {
+		t.Fatalf("tampered signature failed with %q, want %q", got, AuthBadSignature)
+	}
+}
+
+// signFor signs r covering the given headers, the way an S3 client would.
+func signFor(r *http.Request, accessKey, secretKey, region string, headers ...string) {
+	now := time.Now().UTC()
+	dateStamp, amzDate := now.Format("20060102"), now.Format("20060102T150405Z")
+	r.Header.Set("X-Amz-Date", amzDate)
+	r.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+
+	var canonicalHeaders strings.Builder
+	for _, h := range headers {
+		val := r.Header.Get(h)
+		if h == "host" {
+			val = r.Host
+		}
+		canonicalHeaders.WriteString(h + ":" + val + "\n")
+	}
+	signedHeaders := strings.Join(headers, ";")
+	canonicalRequest := r.Method + "\n" + r.URL.EscapedPath() + "\n" + sortQueryString(r.URL.Query().Encode()) + "\n" +
+		canonicalHeaders.String() + "\n" + signedHeaders + "\nUNSIGNED-PAYLOAD"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + dateStamp + "/" + region + "/s3/aws4_request\n" + hashSHA256([]byte(canonicalRequest))
+	signature := hex.EncodeToString(hmacSHA256(deriveSigningKey(secretKey, dateStamp, region, "s3"), []byte(stringToSign)))
+	r.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKey+"/"+dateStamp+"/"+region+"/s3/aws4_request, SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func TestSignedHeaderPolicy(t *testing.T) {
+	h := NewHandlerWithOptions(t.TempDir(), WithCredentials("key", "secret"))
+	tests := []struct {
+		method  string
+		headers []string
+		want    int
+	}{
+		{"GET", []string{"host", "x-amz-date"}, http.StatusOK},
+		{"PUT", []string{"host", "x-amz-date"}, http.StatusForbidden},
+		{"PUT", []string{"host", "x-amz-content-sha256", "x-amz-date"}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, "http://example.com/vault/a.md", strings.NewReader("x"))
+		signFor(req, "key", "secret", "us-east-1", tt.headers...)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if tt.method == "GET" && tt.want == http.StatusOK {
+			tt.want = http.StatusNotFound // signature accepted, object missing
+		}
+		if w.Code != tt.want {
+			t.Fatalf("%s signing %v got %d, want %d: %s", tt.method, tt.headers, w.Code, tt.want, w.Body)
+		}
+		if w.Code == http.StatusForbidden && !strings.Contains(w.Body.String(), "SignatureDoesNotMatch") {
+			t.Fatalf("%s signing %v: rejection doesn't explain itself: %s", tt.method, tt.headers, w.Body)
+		}
+	}
+
+	// The required set is configurable, above what every signature covers
+	h = NewHandlerWithOptions(t.TempDir(), WithCredentials("key", "secret"), WithSignedHeaders(SignedHeaderPolicy{Required: []string{"host"}}))
+	req := httptest.NewRequest("PUT", "http://example.com/vault/a.md", strings.NewReader("x"))
+	signFor(req, "key", "secret", "us-east-1", "host", "x-amz-date")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT signing host under a host-only policy got %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestMandatorySignedHeaders(t *testing.T) {
+	// Even a policy requiring nothing can't drop host or the date
+	h := NewHandlerWithOptions(t.TempDir(), WithCredentials("key", "secret"), WithSignedHeaders(SignedHeaderPolicy{}))
+	for _, headers := range [][]string{{"x-amz-date"}, {"host"}, {"x-amz-content-sha256"}} {
+		req := httptest.NewRequest("GET", "http://example.com/vault/a.md", nil)
+		signFor(req, "key", "secret", "us-east-1", headers...)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "AuthorizationHeaderMalformed") {
+			t.Fatalf("signing %v got %d, want 400 AuthorizationHeaderMalformed: %s", headers, w.Code, w.Body)
+		}
+	}
+
+	// A signed header the request doesn't carry
+	req := httptest.NewRequest("GET", "http://example.com/vault/a.md", nil)
+	req.Header.Set("X-Amz-Meta-Note", "x")
+	signFor(req, "key", "secret", "us-east-1", "host", "x-amz-date", "x-amz-meta-note")
+	req.Header.Del("X-Amz-Meta-Note")
+	if got := sigV4Verify(req, "key", "secret", "us-east-1"); got != AuthAbsentHeader {
+		t.Fatalf("absent signed header failed with %q, want %q", got, AuthAbsentHeader)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "AccessDenied") {
+		t.Fatalf("absent signed header got %d, want 403 AccessDenied: %s", w.Code, w.Body)
+	}
+
+	// Date stands in for X-Amz-Date
+	for {
+		req = httptest.NewRequest("GET", "http://example.com/vault/a.md", nil)
+		date := time.Now().UTC()
+		req.Header.Set("Date", date.Format(http.TimeFormat))
+		signFor(req, "key", "secret", "us-east-1", "date", "host")
+		// Signed for the second Date names, unless the clock just ticked
+		if req.Header.Get("X-Amz-Date") == date.Format("20060102T150405Z") {
+			break
+		}
+	}
+	req.Header.Del("X-Amz-Date")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("signing date and host got %d, want the signature accepted: %s", w.Code, w.Body)
 	}
 }
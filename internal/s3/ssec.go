@@ -0,0 +1,216 @@
+package s3
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+)
+
+// SSE-C request and response headers.
+const (
+	sseCustomerAlgorithmHeader = "X-Amz-Server-Side-Encryption-Customer-Algorithm"
+	sseCustomerKeyHeader       = "X-Amz-Server-Side-Encryption-Customer-Key"
+	sseCustomerKeyMD5Header    = "X-Amz-Server-Side-Encryption-Customer-Key-Md5"
+)
+
+// sseMagic starts every object encrypted with a customer key. It is
+// followed by a salt, a fingerprint of the key, the nonce and the
+// AES-256-GCM sealed content. The fingerprint is an HMAC of the salt keyed
+// with the customer key rather than the key's MD5, so the repository
+// reveals nothing that could be checked against a guessed key offline
+// cheaper than decrypting.
+const sseMagic = "git3ssec"
+
+const (
+	sseSaltSize        = 16
+	sseFingerprintSize = sha256.Size
+	sseHeaderSize      = len(sseMagic) + sseSaltSize + sseFingerprintSize
+)
+
+var (
+	// errSSECRequired is returned for an SSE-C object read without a key.
+	errSSECRequired = errors.New("object is encrypted with a customer key")
+	// errSSECMismatch is returned for an SSE-C object read with another key.
+	errSSECMismatch = errors.New("customer key doesn't match the object's")
+	// errSSECNotApplicable is returned for a plain object read with a key.
+	errSSECNotApplicable = errors.New("object is not encrypted with a customer key")
+)
+
+// customerKey is a key supplied with SSE-C headers.
+type customerKey struct {
+	key []byte
+	md5 string // base64, as echoed in responses
+}
+
+// parseCustomerKey reads the SSE-C headers of h. It returns nil without
+// an error if there are none.
+func parseCustomerKey(h http.Header) (*customerKey, error) {
+	algorithm, key, keyMD5 := h.Get(sseCustomerAlgorithmHeader), h.Get(sseCustomerKeyHeader), h.Get(sseCustomerKeyMD5Header)
+	if algorithm == "" && key == "" && keyMD5 == "" {
+		return nil, nil
+	}
+	if algorithm != "AES256" {
+		return nil, errors.New("The customer encryption algorithm must be AES256")
+	}
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(raw) != 32 {
+		return nil, errors.New("The customer encryption key must be 256 bits, base64 encoded")
+	}
+	sum := md5.Sum(raw)
+	if keyMD5 != base64.StdEncoding.EncodeToString(sum[:]) {
+		return nil, errors.New("The calculated MD5 hash of the key did not match the hash that was provided")
+	}
+	return &customerKey{key: raw, md5: keyMD5}, nil
+}
+
+// setHeaders echoes the SSE-C response headers.
+func (k *customerKey) setHeaders(w http.ResponseWriter) {
+	w.Header().Set(sseCustomerAlgorithmHeader, "AES256")
+	w.Header().Set(sseCustomerKeyMD5Header, k.md5)
+}
+
+func (k *customerKey) aead() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(k.key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (k *customerKey) fingerprint(salt []byte) []byte {
+	mac := hmac.New(sha256.New, k.key)
+	mac.Write(salt)
+	return mac.Sum(nil)
+}
+
+// sseOverhead is how much larger an SSE-C object is on disk than its content.
+func sseOverhead() int64 {
+	return int64(sseHeaderSize + 12 + 16) // GCM nonce and tag
+}
+
+// sealFile encrypts the file at path in place. It is only used on files
+// nobody else can see yet.
+func (k *customerKey) sealFile(path string) error {
+	plaintext, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	aead, err := k.aead()
+	if err != nil {
+		return err
+	}
+	out := make([]byte, sseHeaderSize+aead.NonceSize(), int64(len(plaintext))+sseOverhead())
+	copy(out, sseMagic)
+	salt := out[len(sseMagic) : len(sseMagic)+sseSaltSize]
+	nonce := out[sseHeaderSize:]
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	copy(out[len(sseMagic)+sseSaltSize:], k.fingerprint(salt))
+	out = aead.Seal(out, nonce, plaintext, out[:sseHeaderSize])
+	return os.WriteFile(path, out, 0644)
+}
+
+// openFile returns the decrypted content of f, closing it.
+func (k *customerKey) openFile(f io.ReadCloser) (io.ReadSeekCloser, error) {
+	return decryptFile(f, k.open)
+}
+
+// check returns errSSECMismatch unless header, the start of an SSE-C
+// object, was written with this key.
+func (k *customerKey) check(header []byte) error {
+	salt := header[len(sseMagic) : len(sseMagic)+sseSaltSize]
+	if !hmac.Equal(header[len(sseMagic)+sseSaltSize:sseHeaderSize], k.fingerprint(salt)) {
+		return errSSECMismatch
+	}
+	return nil
+}
+
+// open decrypts data, a whole SSE-C object.
+func (k *customerKey) open(data []byte) ([]byte, error) {
+	if err := k.check(data); err != nil {
+		return nil, err
+	}
+	aead, err := k.aead()
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < sseHeaderSize+aead.NonceSize() {
+		return nil, errors.New("encrypted object is truncated")
+	}
+	nonce := data[sseHeaderSize : sseHeaderSize+aead.NonceSize()]
+	plaintext, err := aead.Open(nil, nonce, data[sseHeaderSize+aead.NonceSize():], data[:sseHeaderSize])
+	if err != nil {
+		return nil, fmt.Errorf("decrypt object: %w", err)
+	}
+	return plaintext, nil
+}
+
+// readSSEHeader reads the start of f and rewinds it. It returns the SSE-C
+// header if f is an SSE-C object, or nil.
+func readSSEHeader(f io.ReadSeeker) ([]byte, error) {
+	header := make([]byte, sseHeaderSize)
+	n, err := io.ReadFull(f, header)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if n < sseHeaderSize || !bytes.HasPrefix(header, []byte(sseMagic)) {
+		return nil, nil
+	}
+	return header, nil
+}
+
+// checkCustomerKey opens the object at fullPath far enough to check that
+// sse is the key it needs, if any.
+func (s *Handler) checkCustomerKey(fullPath string, sse *customerKey) error {
+	f, err := s.openStored(fullPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	header, err := readSSEHeader(f)
+	switch {
+	case err != nil:
+		return err
+	case header == nil && sse != nil:
+		return errSSECNotApplicable
+	case header == nil:
+		return nil
+	case sse == nil:
+		return errSSECRequired
+	default:
+		return sse.check(header)
+	}
+}
+
+// sseError answers a request whose customer key doesn't fit the object.
+// It reports whether err was such a mismatch.
+func (s *Handler) sseError(w http.ResponseWriter, err error) bool {
+	switch {
+	case errors.Is(err, errSSECRequired):
+		s.xmlError(w, http.StatusForbidden, "AccessDenied", "The object was stored using a customer-provided encryption key; the same key must be provided to retrieve it")
+	case errors.Is(err, errSSECMismatch):
+		s.xmlError(w, http.StatusForbidden, "AccessDenied", "The provided customer encryption key does not match the object's")
+	case errors.Is(err, errSSECNotApplicable):
+		s.xmlError(w, http.StatusBadRequest, "InvalidRequest", "The encryption parameters are not applicable to this object")
+	default:
+		return false
+	}
+	return true
+}
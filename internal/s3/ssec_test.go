@@ -0,0 +1,129 @@
+package s3
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// withCustomerKey adds SSE-C headers for a key filled with fill.
+func withCustomerKey(r *http.Request, fill byte) *http.Request {
+	key := bytes.Repeat([]byte{fill}, 32)
+	sum := md5.Sum(key)
+	r.Header.Set(sseCustomerAlgorithmHeader, "AES256")
+	r.Header.Set(sseCustomerKeyHeader, base64.StdEncoding.EncodeToString(key))
+	r.Header.Set(sseCustomerKeyMD5Header, base64.StdEncoding.EncodeToString(sum[:]))
+	return r
+}
+
+func serveRequest(h http.Handler, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestSSECustomerKey(t *testing.T) {
+	dir := t.TempDir()
+	h := NewHandlerWithOptions(dir)
+	body := "for my eyes only"
+
+	put := serveRequest(h, withCustomerKey(httptest.NewRequest("PUT", "/vault/secret.md", strings.NewReader(body)), 1))
+	if put.Code != http.StatusOK {
+		t.Fatalf("PUT got %d: %s", put.Code, put.Body)
+	}
+	if put.Header().Get(sseCustomerAlgorithmHeader) != "AES256" || put.Header().Get(sseCustomerKeyMD5Header) == "" {
+		t.Fatalf("PUT didn't echo the SSE-C headers: %v", put.Header())
+	}
+	onDisk, _ := os.ReadFile(filepath.Join(dir, "secret.md"))
+	if bytes.Contains(onDisk, []byte("eyes")) {
+		t.Fatal("object stored in the clear")
+	}
+
+	get := serveRequest(h, withCustomerKey(httptest.NewRequest("GET", "/vault/secret.md", nil), 1))
+	if get.Code != http.StatusOK || get.Body.String() != body {
+		t.Fatalf("GET with the key got %d %q", get.Code, get.Body)
+	}
+	if get.Header().Get(sseCustomerKeyMD5Header) != put.Header().Get(sseCustomerKeyMD5Header) {
+		t.Fatal("GET didn't echo the key MD5")
+	}
+	head := serveRequest(h, withCustomerKey(httptest.NewRequest("HEAD", "/vault/secret.md", nil), 1))
+	if head.Code != http.StatusOK || head.Header().Get("Content-Length") != "16" {
+		t.Fatalf("HEAD with the key got %d, Content-Length %s", head.Code, head.Header().Get("Content-Length"))
+	}
+
+	for _, method := range []string{"GET", "HEAD"} {
+		if w := serve(h, method, "/vault/secret.md", ""); w.Code != http.StatusForbidden {
+			t.Fatalf("%s without the key got %d, want 403", method, w.Code)
+		}
+		w := serveRequest(h, withCustomerKey(httptest.NewRequest(method, "/vault/secret.md", nil), 2))
+		if w.Code != http.StatusForbidden || strings.Contains(w.Body.String(), "eyes") {
+			t.Fatalf("%s with another key got %d %q, want 403", method, w.Code, w.Body)
+		}
+	}
+
+	// Listings say nothing about keys
+	list := serve(h, "GET", "/vault", "")
+	if strings.Contains(list.Body.String(), put.Header().Get(sseCustomerKeyMD5Header)) || strings.Contains(list.Body.String(), "AES256") {
+		t.Fatalf("listing leaks the key: %s", list.Body)
+	}
+}
+
+func TestSSECustomerKeyPlainObjects(t *testing.T) {
+	h := NewHandlerWithOptions(t.TempDir())
+	serve(h, "PUT", "/vault/plain.md", "hello")
+
+	if w := serve(h, "GET", "/vault/plain.md", ""); w.Body.String() != "hello" {
+		t.Fatalf("GET = %q", w.Body)
+	}
+	if w := serveRequest(h, withCustomerKey(httptest.NewRequest("GET", "/vault/plain.md", nil), 1)); w.Code != http.StatusBadRequest {
+		t.Fatalf("GET of a plain object with a key got %d, want 400", w.Code)
+	}
+}
+
+func TestSSECustomerKeyInvalid(t *testing.T) {
+	h := NewHandlerWithOptions(t.TempDir())
+
+	req := withCustomerKey(httptest.NewRequest("PUT", "/vault/a.md", strings.NewReader("x")), 1)
+	req.Header.Set(sseCustomerKeyMD5Header, base64.StdEncoding.EncodeToString(make([]byte, 16)))
+	if w := serveRequest(h, req); w.Code != http.StatusBadRequest {
+		t.Fatalf("PUT with a wrong key MD5 got %d, want 400", w.Code)
+	}
+
+	req = withCustomerKey(httptest.NewRequest("PUT", "/vault/a.md", strings.NewReader("x")), 1)
+	req.Header.Set(sseCustomerAlgorithmHeader, "AES128")
+	if w := serveRequest(h, req); w.Code != http.StatusBadRequest {
+		t.Fatalf("PUT with another algorithm got %d, want 400", w.Code)
+	}
+
+	req = withCustomerKey(httptest.NewRequest("PUT", "/vault/a.md", strings.NewReader("x")), 1)
+	req.Header.Set(writeModeHeader, "append")
+	if w := serveRequest(h, req); w.Code != http.StatusBadRequest {
+		t.Fatalf("appending with a key got %d, want 400", w.Code)
+	}
+	if _, err := os.Stat(filepath.Join(h.dir, "a.md")); !os.IsNotExist(err) {
+		t.Fatal("a rejected PUT wrote the object")
+	}
+}
+
+func TestSSECustomerKeyWithServerEncryption(t *testing.T) {
+	dir := t.TempDir()
+	h := NewHandlerWithOptions(dir, WithEncryption(newTestCipher(t, 9)))
+	serveRequest(h, withCustomerKey(httptest.NewRequest("PUT", "/vault/a.md", strings.NewReader("customer")), 1))
+	serve(h, "PUT", "/vault/b.md", "server")
+
+	if w := serveRequest(h, withCustomerKey(httptest.NewRequest("GET", "/vault/a.md", nil), 1)); w.Body.String() != "customer" {
+		t.Fatalf("GET a.md = %d %q", w.Code, w.Body)
+	}
+	if w := serve(h, "GET", "/vault/b.md", ""); w.Body.String() != "server" {
+		t.Fatalf("GET b.md = %d %q", w.Code, w.Body)
+	}
+	if n, err := h.EncryptExisting(false); n != 0 || err != nil {
+		t.Fatalf("EncryptExisting = %d, %v; SSE-C objects count as encrypted", n, err)
+	}
+}
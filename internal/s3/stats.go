@@ -0,0 +1,247 @@
+package s3
+
+import (
+	"cmp"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// statsLargest is how many of the largest objects BucketStats lists.
+const statsLargest = 10
+
+// treeStats is the content size of every object in the bucket, by key,
+// read by one walk of the tree and then kept up to date by the handler's
+// own writes and deletes and by the paths pulls report.
+type treeStats struct {
+	walk    sync.Mutex // held by the one walk at a time
+	mu      sync.Mutex
+	counted bool
+	at      time.Time // when the tree was walked
+	sizes   map[string]int64
+	bytes   int64
+
+	// Writes and deletes while the tree is walked, by key, with -1 for
+	// deleted; nil when no walk is under way
+	pending map[string]int64
+}
+
+// set records the content size of key, just written, if the bucket was
+// counted or is being counted.
+func (t *treeStats) set(key string, size int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch {
+	case t.counted:
+		t.bytes += size - t.sizes[key]
+		t.sizes[key] = size
+	case t.pending != nil:
+		t.pending[key] = size
+	}
+}
+
+// remove forgets key, just deleted, if the bucket was counted or is being
+// counted.
+func (t *treeStats) remove(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch {
+	case t.counted:
+		t.bytes -= t.sizes[key]
+		delete(t.sizes, key)
+	case t.pending != nil:
+		t.pending[key] = -1
+	}
+}
+
+// begin starts keeping the writes and deletes that happen during a walk.
+func (t *treeStats) begin() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pending = make(map[string]int64)
+}
+
+// finish takes the counts a walk read, with the writes and deletes kept
+// since begin applied over them: the walk may or may not have seen each,
+// but they are newer than anything it read. A failed walk passes nil.
+func (t *treeStats) finish(sizes map[string]int64, bytes int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	pending := t.pending
+	t.pending = nil
+	if sizes == nil {
+		return
+	}
+	for key, size := range pending {
+		bytes -= sizes[key]
+		if size < 0 {
+			delete(sizes, key)
+			continue
+		}
+		sizes[key] = size
+		bytes += size
+	}
+	t.counted, t.at, t.sizes, t.bytes = true, time.Now(), sizes, bytes
+}
+
+// forget drops the counts, so the next read walks the tree again.
+func (t *treeStats) forget() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.counted, t.sizes, t.bytes = false, nil, 0
+}
+
+// count walks the tree for the stats, unless it was already counted.
+func (s *Handler) count() error {
+	s.stats.mu.Lock()
+	counted := s.stats.counted
+	s.stats.mu.Unlock()
+	if counted {
+		return nil
+	}
+
+	// The tree lock keeps out whoever replaces the tree wholesale, but
+	// PUTs and DELETEs hold it too, so they go on during the walk and are
+	// applied over what it read once it's done.
+	if s.treeLock != nil {
+		s.treeLock.Lock()
+		defer s.treeLock.Unlock()
+	}
+	s.stats.walk.Lock()
+	defer s.stats.walk.Unlock()
+	s.stats.mu.Lock()
+	counted = s.stats.counted
+	s.stats.mu.Unlock()
+	if counted {
+		return nil // counted while waiting for another walk
+	}
+
+	s.stats.begin()
+	sizes := make(map[string]int64)
+	var bytes int64
+	err := s.walkKeys("", "", 0, func(key string, info os.FileInfo) error {
+		sizes[key] = s.contentSize(s.keyFile(key), info)
+		bytes += sizes[key]
+		return nil
+	}, nil)
+	if err != nil {
+		s.stats.finish(nil, 0)
+		return err
+	}
+	s.stats.finish(sizes, bytes)
+	return nil
+}
+
+// Stats counts the objects in the bucket and their total content size.
+// Only the first call walks the tree, or the first after TreeChanged.
+func (s *Handler) Stats() (objects int, bytes int64, err error) {
+	if err := s.count(); err != nil {
+		return 0, 0, err
+	}
+	s.stats.mu.Lock()
+	defer s.stats.mu.Unlock()
+	return len(s.stats.sizes), s.stats.bytes, nil
+}
+
+// BucketStats summarizes the objects in the bucket, with the totals Stats
+// returns.
+type BucketStats struct {
+	Objects   int           `json:"objects"`
+	Bytes     int64         `json:"bytes"`
+	Largest   []ObjectSize  `json:"largest"`    // the largest objects, largest first
+	Prefixes  []PrefixStats `json:"prefixes"`   // by top-level prefix, in order
+	CountedAt time.Time     `json:"counted_at"` // when the tree was last walked
+}
+
+// ObjectSize is an object's key and content size.
+type ObjectSize struct {
+	Key  string `json:"key"`
+	Size int64  `json:"size"`
+}
+
+// PrefixStats counts the objects under a top-level prefix such as
+// "notes/"; objects at the top level are counted under "".
+type PrefixStats struct {
+	Prefix  string `json:"prefix"`
+	Objects int    `json:"objects"`
+	Bytes   int64  `json:"bytes"`
+}
+
+// BucketStats summarizes the bucket from the counts Stats keeps, walking
+// the tree only if they aren't there yet, or with refresh, in case the
+// tree was changed behind the handler's back.
+func (s *Handler) BucketStats(refresh bool) (BucketStats, error) {
+	if refresh {
+		s.stats.forget()
+	}
+	if err := s.count(); err != nil {
+		return BucketStats{}, err
+	}
+	s.stats.mu.Lock()
+	defer s.stats.mu.Unlock()
+
+	stats := BucketStats{Objects: len(s.stats.sizes), Bytes: s.stats.bytes, CountedAt: s.stats.at,
+		Largest: []ObjectSize{}, Prefixes: []PrefixStats{}}
+	byPrefix := make(map[string]*PrefixStats)
+	for key, size := range s.stats.sizes {
+		stats.Largest = append(stats.Largest, ObjectSize{key, size})
+		prefix := ""
+		if i := strings.IndexByte(key, '/'); i >= 0 {
+			prefix = key[:i+1]
+		}
+		p := byPrefix[prefix]
+		if p == nil {
+			p = &PrefixStats{Prefix: prefix}
+			byPrefix[prefix] = p
+		}
+		p.Objects++
+		p.Bytes += size
+	}
+	slices.SortFunc(stats.Largest, func(a, b ObjectSize) int {
+		return cmp.Or(cmp.Compare(b.Size, a.Size), strings.Compare(a.Key, b.Key))
+	})
+	stats.Largest = stats.Largest[:min(len(stats.Largest), statsLargest)]
+	for _, p := range byPrefix {
+		stats.Prefixes = append(stats.Prefixes, *p)
+	}
+	slices.SortFunc(stats.Prefixes, func(a, b PrefixStats) int { return strings.Compare(a.Prefix, b.Prefix) })
+	return stats, nil
+}
+
+// TreeChanged drops what the handler keeps about the tree, the counts
+// Stats keeps, the manifest and the key index of a hashed layout, for
+// changes made other than through the handler, like a pull. They are read
+// again when next needed.
+func (s *Handler) TreeChanged() {
+	s.manifest.changed()
+	if s.layout != nil {
+		s.layout.forget()
+	}
+	s.stats.forget()
+}
+
+// PathsChanged is TreeChanged for changes to known paths, relative to the
+// handler's root, as a pull reports them: the counts Stats keeps are
+// brought up to date for just those objects. In a hashed layout, whose
+// paths don't name keys, it is TreeChanged.
+func (s *Handler) PathsChanged(paths []string) {
+	if s.layout != nil {
+		s.TreeChanged()
+		return
+	}
+	s.manifest.changed()
+	for _, path := range paths {
+		fullPath, ok := s.objectPath(path)
+		if !ok {
+			continue
+		}
+		s.cache.remove(path)
+		if info, err := os.Lstat(fullPath); err == nil && info.Mode().IsRegular() {
+			s.stats.set(path, s.contentSize(fullPath, info))
+		} else {
+			s.stats.remove(path)
+		}
+	}
+}
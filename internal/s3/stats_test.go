@@ -0,0 +1,84 @@
+package s3
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestBucketStats(t *testing.T) {
+	h, dir := newTestHandler(t)
+	serve(h, "PUT", "/vault/notes/a.md", "hello")
+	serve(h, "PUT", "/vault/notes/sub/b.md", strings.Repeat("b", 20))
+	serve(h, "PUT", "/vault/img/c.png", strings.Repeat("c", 100))
+	serve(h, "PUT", "/vault/top.md", "top")
+
+	stats, err := h.BucketStats(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Objects != 4 || stats.Bytes != 128 || stats.CountedAt.IsZero() {
+		t.Fatalf("totals = %d objects, %d bytes at %s", stats.Objects, stats.Bytes, stats.CountedAt)
+	}
+	if want := []ObjectSize{{"img/c.png", 100}, {"notes/sub/b.md", 20}, {"notes/a.md", 5}, {"top.md", 3}}; !slices.Equal(stats.Largest, want) {
+		t.Fatalf("largest = %v, want %v", stats.Largest, want)
+	}
+	if want := []PrefixStats{{"", 1, 3}, {"img/", 1, 100}, {"notes/", 2, 25}}; !slices.Equal(stats.Prefixes, want) {
+		t.Fatalf("prefixes = %v, want %v", stats.Prefixes, want)
+	}
+
+	// Moves and deletes keep the counts without a walk; refresh walks
+	os.WriteFile(filepath.Join(dir, "outside.md"), []byte("outside"), 0644)
+	h.Move("img/c.png", "notes/c.png", false)
+	serve(h, "DELETE", "/vault/top.md", "")
+	stats, _ = h.BucketStats(false)
+	if want := []PrefixStats{{"notes/", 3, 125}}; stats.Objects != 3 || !slices.Equal(stats.Prefixes, want) {
+		t.Fatalf("after a move and a delete: %d objects in %v, want %v", stats.Objects, stats.Prefixes, want)
+	}
+	if stats, _ = h.BucketStats(true); stats.Objects != 4 || stats.Bytes != 132 {
+		t.Fatalf("refreshed = %d objects, %d bytes; want the file written directly counted", stats.Objects, stats.Bytes)
+	}
+}
+
+func TestPathsChanged(t *testing.T) {
+	h, dir := newTestHandler(t)
+	serve(h, "PUT", "/vault/a.md", "hello")
+	serve(h, "PUT", "/vault/b.md", "bb")
+	h.Stats()
+
+	// As a pull would leave them: a.md changed, b.md deleted, c.md added
+	os.WriteFile(filepath.Join(dir, "a.md"), []byte("hello, world"), 0644)
+	os.Remove(filepath.Join(dir, "b.md"))
+	os.MkdirAll(filepath.Join(dir, "new"), 0755)
+	os.WriteFile(filepath.Join(dir, "new", "c.md"), []byte("c"), 0644)
+	os.WriteFile(filepath.Join(dir, "unreported.md"), []byte("unreported"), 0644)
+	h.PathsChanged([]string{"a.md", "b.md", "new/c.md", ".git3/state"})
+	if objects, bytes, _ := h.Stats(); objects != 2 || bytes != 13 {
+		t.Fatalf("Stats = %d, %d; want only the reported paths updated", objects, bytes)
+	}
+}
+
+func TestStatsWritesDuringWalk(t *testing.T) {
+	var stats treeStats
+	stats.begin()
+	// Written and deleted while the walk reads a: 1, b: 2, c: 3
+	stats.set("a", 5)
+	stats.remove("b")
+	stats.set("d", 4)
+	stats.finish(map[string]int64{"a": 1, "b": 2, "c": 3}, 6)
+	if want := map[string]int64{"a": 5, "c": 3, "d": 4}; !maps.Equal(stats.sizes, want) || stats.bytes != 12 {
+		t.Fatalf("counts = %v, %d bytes; want %v, 12 bytes", stats.sizes, stats.bytes, want)
+	}
+
+	// A failed walk counts nothing and stops keeping writes
+	stats.forget()
+	stats.begin()
+	stats.finish(nil, 0)
+	stats.set("e", 1)
+	if stats.counted || stats.pending != nil {
+		t.Fatal("failed walk left counts or pending writes")
+	}
+}
@@ -0,0 +1,29 @@
+package s3
+
+import (
+	"net/http"
+	"strings"
+)
+
+// overTLS reports whether r reached the server over TLS: directly, or
+// through a proxy trusted to say so in X-Forwarded-Proto. The header is
+// ignored from any other peer, since a client can send whatever it likes.
+func (s *Handler) overTLS(r *http.Request) bool {
+	if r.TLS != nil {
+		return true
+	}
+	if !strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https") {
+		return false
+	}
+	ip := peerAddr(r)
+	return ip.IsValid() && containsAddr(s.tlsProxies, ip)
+}
+
+// warnPlaintext logs, once, that signed requests are arriving over plain
+// HTTP, where the access key ID and each request's signature can be read
+// and the request replayed until its date is too old.
+func (s *Handler) warnPlaintext(r *http.Request) {
+	s.plaintextOnce.Do(func() {
+		s.logger.Printf("[s3] WARNING: signed request over plain HTTP from %s; the access key ID and signatures are exposed to anyone on the path. Serve git3 behind TLS and set REQUIRE_TLS to refuse such requests", r.RemoteAddr)
+	})
+}
@@ -0,0 +1,66 @@
+package s3
+
+import (
+	"crypto/tls"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestRequireTLS(t *testing.T) {
+	proxy := netip.MustParsePrefix("10.0.0.0/8")
+	h := NewHandlerWithOptions(t.TempDir(), WithCredentials("key", "secret"), WithRequireTLS(true, proxy))
+	put := func(remote, proto string, overTLS bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", "http://example.com/vault/a.md", strings.NewReader("x"))
+		req.RemoteAddr = remote + ":1234"
+		if proto != "" {
+			req.Header.Set("X-Forwarded-Proto", proto)
+		}
+		if overTLS {
+			req.TLS = &tls.ConnectionState{}
+		}
+		signFor(req, "key", "secret", "us-east-1", "host", "x-amz-content-sha256", "x-amz-date")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := put("192.0.2.1", "", false); w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "HTTPS") {
+		t.Fatalf("plaintext PUT got %d: %s", w.Code, w.Body)
+	}
+	if w := put("192.0.2.1", "", true); w.Code != http.StatusOK {
+		t.Fatalf("PUT over TLS got %d: %s", w.Code, w.Body)
+	}
+	if w := put("10.1.2.3", "https", false); w.Code != http.StatusOK {
+		t.Fatalf("PUT forwarded by a trusted proxy got %d: %s", w.Code, w.Body)
+	}
+	for _, tt := range []struct{ remote, proto string }{
+		{"192.0.2.1", "https"}, // anyone can send the header
+		{"10.1.2.3", "http"},
+	} {
+		if w := put(tt.remote, tt.proto, false); w.Code != http.StatusForbidden {
+			t.Fatalf("PUT from %s with X-Forwarded-Proto %s got %d", tt.remote, tt.proto, w.Code)
+		}
+	}
+
+	// Without RequireTLS, plaintext is served and warned about once
+	var logs strings.Builder
+	h = NewHandlerWithOptions(t.TempDir(), WithCredentials("key", "secret"), WithLogger(log.New(&logs, "", 0)))
+	for range 2 {
+		if w := put("192.0.2.1", "", false); w.Code != http.StatusOK {
+			t.Fatalf("plaintext PUT without RequireTLS got %d", w.Code)
+		}
+	}
+	if n := strings.Count(logs.String(), "plain HTTP"); n != 1 {
+		t.Fatalf("warned %d times: %s", n, logs.String())
+	}
+
+	// Unsigned requests are left alone when there are no credentials
+	open := NewHandlerWithOptions(t.TempDir(), WithRequireTLS(true))
+	if w := serve(open, "PUT", "/vault/a.md", "x"); w.Code != http.StatusOK {
+		t.Fatalf("PUT without credentials got %d", w.Code)
+	}
+}
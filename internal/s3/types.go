@@ -4,17 +4,22 @@ import "encoding/xml"
 
 // S3 XML types
 
+const s3Namespace = "http://s3.amazonaws.com/doc/2006-03-01/"
+
 type ListBucketResult struct {
-	XMLName               xml.Name     `xml:"ListBucketResult"`
-	Xmlns                 string       `xml:"xmlns,attr"`
-	Name                  string       `xml:"Name"`
-	Prefix                string       `xml:"Prefix"`
-	KeyCount              int          `xml:"KeyCount"`
-	MaxKeys               int          `xml:"MaxKeys"`
-	IsTruncated           bool         `xml:"IsTruncated"`
-	Contents              []ObjectInfo `xml:"Contents"`
-	ContinuationToken     string       `xml:"ContinuationToken,omitempty"`
-	NextContinuationToken string       `xml:"NextContinuationToken,omitempty"`
+	XMLName               xml.Name       `xml:"ListBucketResult"`
+	Xmlns                 string         `xml:"xmlns,attr"`
+	Name                  string         `xml:"Name"`
+	Prefix                string         `xml:"Prefix"`
+	KeyCount              int            `xml:"KeyCount"`
+	MaxKeys               int            `xml:"MaxKeys"`
+	IsTruncated           bool           `xml:"IsTruncated"`
+	Contents              []ObjectInfo   `xml:"Contents"`
+	CommonPrefixes        []CommonPrefix `xml:"CommonPrefixes"`
+	Delimiter             string         `xml:"Delimiter,omitempty"`
+	ContinuationToken     string         `xml:"ContinuationToken,omitempty"`
+	StartAfter            string         `xml:"StartAfter,omitempty"`
+	NextContinuationToken string         `xml:"NextContinuationToken,omitempty"`
 }
 
 type ObjectInfo struct {
@@ -25,8 +30,50 @@ type ObjectInfo struct {
 	StorageClass string `xml:"StorageClass"`
 }
 
+type CommonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+// DeleteResult is the answer to a delete by prefix, shaped like that of
+// S3's DeleteObjects.
+type DeleteResult struct {
+	XMLName xml.Name        `xml:"DeleteResult"`
+	Xmlns   string          `xml:"xmlns,attr"`
+	Deleted []DeletedObject `xml:"Deleted"`
+}
+
+type DeletedObject struct {
+	Key string `xml:"Key"`
+}
+
 type ErrorResponse struct {
-	XMLName xml.Name `xml:"Error"`
-	Code    string   `xml:"Code"`
-	Message string   `xml:"Message"`
+	XMLName   xml.Name `xml:"Error"`
+	Code      string   `xml:"Code"`
+	Message   string   `xml:"Message"`
+	RequestID string   `xml:"RequestId,omitempty"`
+	Region    string   `xml:"Region,omitempty"` // the bucket's, for AuthorizationHeaderMalformed
+}
+
+type AccessControlPolicy struct {
+	XMLName xml.Name `xml:"AccessControlPolicy"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Owner   Owner    `xml:"Owner"`
+	Grants  []Grant  `xml:"AccessControlList>Grant"`
+}
+
+type Owner struct {
+	ID          string `xml:"ID"`
+	DisplayName string `xml:"DisplayName"`
+}
+
+type Grant struct {
+	Grantee    Grantee `xml:"Grantee"`
+	Permission string  `xml:"Permission"`
+}
+
+type Grantee struct {
+	XmlnsXsi    string `xml:"xmlns:xsi,attr"`
+	Type        string `xml:"xsi:type,attr"`
+	ID          string `xml:"ID"`
+	DisplayName string `xml:"DisplayName,omitempty"`
 }
@@ -0,0 +1,172 @@
+package s3
+
+import (
+	"container/heap"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// errStopWalk is returned by a walkObjects callback to end the walk early.
+var errStopWalk = errors.New("stop walk")
+
+// walkObjects visits every object under root whose key starts with prefix,
+// in lexical key order (the order S3 lists in). Directories are read one at
+// a time and only descended into when they can contain matching keys, so the
+// cost of a walk that stops early is bounded by what it has visited rather
+// than by the size of the tree.
+func walkObjects(root, prefix string, fn func(key string, info fs.FileInfo) error) error {
+	return walkObjectsToDepth(root, prefix, "", 0, fn, nil)
+}
+
+// walkObjectsToDepth is walkObjects that, for a depth above zero, doesn't
+// descend more than depth levels below prefix: a directory at that depth
+// is passed to dirFn as a common prefix ("a/b/") instead of being read.
+// Common prefixes and objects are visited together in key order. Only
+// keys and common prefixes after the key after are visited, and
+// directories holding nothing after it aren't read.
+func walkObjectsToDepth(root, prefix, after string, depth int, fn func(key string, info fs.FileInfo) error, dirFn func(prefix string) error) error {
+	w := walker{prefix: prefix, after: after, depth: depth, fn: fn, dirFn: dirFn}
+	err := w.walkDir(root, "")
+	if errors.Is(err, errStopWalk) {
+		return nil
+	}
+	return err
+}
+
+// walkByModTime visits the limit objects walk visits that were modified
+// most recently, most recent first, and objects modified at the same time
+// in key order. Unlike walk, it has to see every object before it can
+// visit the first one, but it keeps no more than limit of them meanwhile.
+func walkByModTime(walk func(fn func(key string, info fs.FileInfo) error) error, limit int, fn func(key string, info fs.FileInfo) error) error {
+	if limit <= 0 {
+		return nil
+	}
+	var kept modTimeHeap
+	err := walk(func(key string, info fs.FileInfo) error {
+		o := modTimeObject{key, info}
+		switch {
+		case len(kept) < limit:
+			heap.Push(&kept, o)
+		case o.newer(kept[0]):
+			kept[0] = o
+			heap.Fix(&kept, 0)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(kept, func(i, j int) bool { return kept[i].newer(kept[j]) })
+	for _, o := range kept {
+		if err := fn(o.key, o.info); err != nil {
+			if errors.Is(err, errStopWalk) {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// modTimeObject is an object walkByModTime keeps.
+type modTimeObject struct {
+	key  string
+	info fs.FileInfo
+}
+
+// newer reports whether o comes before other in walkByModTime's order.
+func (o modTimeObject) newer(other modTimeObject) bool {
+	if t, u := o.info.ModTime(), other.info.ModTime(); !t.Equal(u) {
+		return t.After(u)
+	}
+	return o.key < other.key
+}
+
+// modTimeHeap is a heap of the objects walkByModTime keeps, with the one
+// it would visit last on top, to be replaced by anything newer.
+type modTimeHeap []modTimeObject
+
+func (h modTimeHeap) Len() int           { return len(h) }
+func (h modTimeHeap) Less(i, j int) bool { return h[j].newer(h[i]) }
+func (h modTimeHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *modTimeHeap) Push(x any)        { *h = append(*h, x.(modTimeObject)) }
+func (h *modTimeHeap) Pop() any {
+	old := *h
+	o := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return o
+}
+
+type walker struct {
+	prefix string
+	after  string
+	depth  int
+	fn     func(key string, info fs.FileInfo) error
+	dirFn  func(prefix string) error
+}
+
+func (w *walker) walkDir(dir, keyPrefix string) error {
+	prefix := w.prefix
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	// os.ReadDir sorts by name, but a directory "a" must sort as "a/" so
+	// that "a.txt" comes before "a/b.txt" like it does in key order.
+	sort.Slice(entries, func(i, j int) bool {
+		return entryKey(entries[i]) < entryKey(entries[j])
+	})
+
+	for _, e := range entries {
+		key := keyPrefix + entryKey(e)
+		if e.IsDir() {
+			if reservedName(e.Name()) {
+				continue
+			}
+			if !strings.HasPrefix(key, prefix) && !strings.HasPrefix(prefix, key) {
+				continue
+			}
+			if key <= w.after && !strings.HasPrefix(w.after, key) {
+				continue // every key in it sorts before after
+			}
+			if w.depth > 0 && strings.HasPrefix(key, prefix) && strings.Count(key[len(prefix):], "/") >= w.depth {
+				if key <= w.after {
+					continue
+				}
+				if err := w.dirFn(key); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := w.walkDir(filepath.Join(dir, e.Name()), key); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if !e.Type().IsRegular() || !strings.HasPrefix(key, prefix) || key <= w.after {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		if err := w.fn(key, info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func entryKey(e fs.DirEntry) string {
+	if e.IsDir() {
+		return e.Name() + "/"
+	}
+	return e.Name()
+}
@@ -0,0 +1,345 @@
+package s3
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWalkObjectsKeyOrder(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "a"), 0755)
+	os.MkdirAll(filepath.Join(dir, ".git"), 0755)
+	os.WriteFile(filepath.Join(dir, "a", "b.txt"), []byte("x"), 0644)
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("x"), 0644)
+	os.WriteFile(filepath.Join(dir, "b.txt"), []byte("x"), 0644)
+	os.WriteFile(filepath.Join(dir, ".git", "HEAD"), []byte("x"), 0644)
+
+	var keys []string
+	walkObjects(dir, "", func(key string, info os.FileInfo) error {
+		keys = append(keys, key)
+		return nil
+	})
+
+	want := []string{"a.txt", "a/b.txt", "b.txt"}
+	if fmt.Sprint(keys) != fmt.Sprint(want) {
+		t.Fatalf("keys = %v, want %v", keys, want)
+	}
+}
+
+func TestWalkObjectsPrefixPrunes(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "notes", "daily"), 0755)
+	os.MkdirAll(filepath.Join(dir, "other"), 0755)
+	os.WriteFile(filepath.Join(dir, "notes", "daily", "1.md"), []byte("x"), 0644)
+	os.WriteFile(filepath.Join(dir, "notes", "x.md"), []byte("x"), 0644)
+	os.WriteFile(filepath.Join(dir, "other", "y.md"), []byte("x"), 0644)
+
+	var keys []string
+	walkObjects(dir, "notes/d", func(key string, info os.FileInfo) error {
+		keys = append(keys, key)
+		return nil
+	})
+
+	if len(keys) != 1 || keys[0] != "notes/daily/1.md" {
+		t.Fatalf("keys = %v, want [notes/daily/1.md]", keys)
+	}
+}
+
+func TestListObjectsV2Truncated(t *testing.T) {
+	h, dir := newTestHandler(t)
+
+	os.WriteFile(filepath.Join(dir, "c.txt"), []byte("c"), 0644)
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0644)
+	os.WriteFile(filepath.Join(dir, "b.txt"), []byte("b"), 0644)
+
+	req := httptest.NewRequest("GET", "/vault?list-type=2&max-keys=2", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	result := decodeListing(t, w.Body)
+	if !result.IsTruncated {
+		t.Fatal("expected IsTruncated with more keys than max-keys")
+	}
+	if result.KeyCount != 2 || result.Contents[0].Key != "a.txt" || result.Contents[1].Key != "b.txt" {
+		t.Fatalf("got %d keys %v, want the first two in key order", result.KeyCount, result.Contents)
+	}
+}
+
+func TestWalkByModTimeLimit(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	for i := range 20 {
+		path := filepath.Join(dir, fmt.Sprintf("%02d.md", i))
+		os.WriteFile(path, nil, 0644)
+		// Ages that don't follow key order, with pairs of equal ones
+		age := time.Duration((i*7)%10) * time.Minute
+		os.Chtimes(path, now.Add(-age), now.Add(-age))
+	}
+
+	var keys []string
+	walkByModTime(func(fn func(string, os.FileInfo) error) error {
+		return walkObjects(dir, "", fn)
+	}, 5, func(key string, _ os.FileInfo) error {
+		keys = append(keys, key)
+		return nil
+	})
+	if want := "00.md,10.md,03.md,13.md,06.md"; strings.Join(keys, ",") != want {
+		t.Fatalf("visited %v, want %s", keys, want)
+	}
+}
+
+func TestListObjectsV2SortByModTime(t *testing.T) {
+	h, dir := newTestHandler(t)
+
+	now := time.Now()
+	for name, age := range map[string]time.Duration{
+		"old.md":         3 * time.Hour,
+		"newest.md":      0,
+		"middle.md":      time.Hour,
+		"also-middle.md": time.Hour,
+	} {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, []byte(name), 0644)
+		os.Chtimes(path, now.Add(-age), now.Add(-age))
+	}
+
+	req := httptest.NewRequest("GET", "/vault?list-type=2&x-sort=lastmodified-desc&max-keys=3", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	result := decodeListing(t, w.Body)
+	var keys []string
+	for _, c := range result.Contents {
+		keys = append(keys, c.Key)
+	}
+	// Equal times fall back to key order
+	if strings.Join(keys, ",") != "newest.md,also-middle.md,middle.md" || !result.IsTruncated {
+		t.Fatalf("got %v (truncated %v), want the three newest, newest first", keys, result.IsTruncated)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/vault?list-type=2&x-sort=size", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("unknown x-sort got %d, want 400", w.Code)
+	}
+}
+
+// TestListObjectsV2BoundedAllocs checks that a small max-keys listing of a
+// large tree stops walking early instead of paying for the whole vault.
+func TestListObjectsV2BoundedAllocs(t *testing.T) {
+	dir := makeTree(t, t.TempDir(), 50, 100)
+	h := NewHandler(dir, "vault", "", "", "us-east-1", noopSyncer{})
+
+	allocs := func(maxKeys int) float64 {
+		return testing.AllocsPerRun(3, func() {
+			req := httptest.NewRequest("GET", fmt.Sprintf("/vault?list-type=2&max-keys=%d", maxKeys), nil)
+			h.ServeHTTP(httptest.NewRecorder(), req)
+		})
+	}
+
+	page, full := allocs(10), allocs(10000)
+	if page*10 > full {
+		t.Fatalf("max-keys=10 listing made %v allocations, full listing %v; want a small fraction", page, full)
+	}
+}
+
+func BenchmarkListObjectsV2(b *testing.B) {
+	dir := makeTree(b, b.TempDir(), 100, 100)
+	h := NewHandler(dir, "vault", "", "", "us-east-1", noopSyncer{})
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		req := httptest.NewRequest("GET", "/vault?list-type=2&max-keys=100", nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		io.Copy(io.Discard, w.Body)
+	}
+}
+
+func TestListObjectsV2MaxDepth(t *testing.T) {
+	h, dir := newTestHandler(t)
+	for _, key := range []string{"top.md", "a/1.md", "a/b/2.md", "a/b/c/3.md", "d/4.md"} {
+		path := filepath.Join(dir, filepath.FromSlash(key))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		os.WriteFile(path, []byte("x"), 0644)
+	}
+
+	list := func(query string) (keys, prefixes []string) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/vault?list-type=2&"+query, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: got %d: %s", query, w.Code, w.Body.String())
+		}
+		result := decodeListing(t, w.Body)
+		for _, c := range result.Contents {
+			keys = append(keys, c.Key)
+		}
+		for _, p := range result.CommonPrefixes {
+			prefixes = append(prefixes, p.Prefix)
+		}
+		return keys, prefixes
+	}
+
+	for _, tc := range []struct {
+		query          string
+		keys, prefixes string
+	}{
+		{"x-max-depth=1", "[top.md]", "[a/ d/]"},
+		{"delimiter=/", "[top.md]", "[a/ d/]"},
+		{"x-max-depth=2", "[a/1.md d/4.md top.md]", "[a/b/]"},
+		{"prefix=a/&x-max-depth=1", "[a/1.md]", "[a/b/]"},
+		{"prefix=a/b&delimiter=/", "[]", "[a/b/]"},
+		{"prefix=a/&x-max-depth=1&max-keys=1", "[a/1.md]", "[]"},
+	} {
+		keys, prefixes := list(tc.query)
+		if fmt.Sprint(keys) != tc.keys {
+			t.Errorf("%s: keys = %v, want %s", tc.query, keys, tc.keys)
+		}
+		if fmt.Sprint(prefixes) != tc.prefixes {
+			t.Errorf("%s: prefixes = %v, want %s", tc.query, prefixes, tc.prefixes)
+		}
+	}
+
+	for _, query := range []string{"x-max-depth=0", "x-max-depth=x", "delimiter=-", "delimiter=/&x-max-depth=2", "delimiter=/&x-sort=lastmodified-desc"} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/vault?list-type=2&"+query, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: got %d, want 400", query, w.Code)
+		}
+	}
+}
+
+func TestListObjectsV2Pagination(t *testing.T) {
+	h, dir := newTestHandler(t)
+	for _, key := range []string{"a.md", "b/1.md", "b/2.md", "b/c/3.md", "b.md", "c.md", "d/4.md"} {
+		path := filepath.Join(dir, filepath.FromSlash(key))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		os.WriteFile(path, []byte("x"), 0644)
+	}
+
+	// pages follows continuation tokens from query to the end, returning
+	// every key and common prefix, sorted
+	pages := func(query string) (entries []string) {
+		token := ""
+		for page := 0; page < 20; page++ {
+			target := "/vault?list-type=2&max-keys=2&" + query
+			if token != "" {
+				target += "&continuation-token=" + url.QueryEscape(token)
+			}
+			w := serve(h, "GET", target, "")
+			if w.Code != http.StatusOK {
+				t.Fatalf("%s: got %d: %s", target, w.Code, w.Body)
+			}
+			result := decodeListing(t, w.Body)
+			if result.ContinuationToken != token {
+				t.Fatalf("ContinuationToken = %q, want %q", result.ContinuationToken, token)
+			}
+			for _, c := range result.Contents {
+				entries = append(entries, c.Key)
+			}
+			for _, p := range result.CommonPrefixes {
+				entries = append(entries, p.Prefix)
+			}
+			if !result.IsTruncated {
+				sort.Strings(entries)
+				return entries
+			}
+			if result.NextContinuationToken == "" {
+				t.Fatalf("%s: truncated without a NextContinuationToken", target)
+			}
+			token = result.NextContinuationToken
+		}
+		t.Fatalf("%s: listing never ended", query)
+		return nil
+	}
+
+	for query, want := range map[string]string{
+		"":                           "[a.md b.md b/1.md b/2.md b/c/3.md c.md d/4.md]",
+		"delimiter=/":                "[a.md b.md b/ c.md d/]",
+		"prefix=b/&delimiter=/":      "[b/1.md b/2.md b/c/]",
+		"start-after=b/1.md":         "[b/2.md b/c/3.md c.md d/4.md]",
+		"start-after=b/&delimiter=/": "[c.md d/]",
+	} {
+		if got := pages(query); fmt.Sprint(got) != want {
+			t.Errorf("%q: listed %v, want %s", query, got, want)
+		}
+	}
+
+	for _, query := range []string{"continuation-token=%25%25", "x-sort=lastmodified-desc&start-after=a.md"} {
+		if w := serve(h, "GET", "/vault?list-type=2&"+query, ""); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: got %d, want 400", query, w.Code)
+		}
+	}
+}
+
+// TestListObjectsV2Streams checks that a large listing goes out with
+// chunked transfer encoding, as it is generated, and is still one valid
+// document.
+func TestListObjectsV2Streams(t *testing.T) {
+	dir := makeTree(t, t.TempDir(), 10, 50)
+	srv := httptest.NewServer(NewHandler(dir, "vault", "", "", "us-east-1", noopSyncer{}))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/vault?list-type=2&max-keys=400")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.ContentLength != -1 || fmt.Sprint(resp.TransferEncoding) != "[chunked]" {
+		t.Fatalf("Content-Length %d, Transfer-Encoding %v; want chunked", resp.ContentLength, resp.TransferEncoding)
+	}
+	result := decodeListing(t, resp.Body)
+	if result.KeyCount != 400 || len(result.Contents) != 400 || !result.IsTruncated || result.NextContinuationToken == "" {
+		t.Fatalf("got %d keys, truncated %v, next %q", result.KeyCount, result.IsTruncated, result.NextContinuationToken)
+	}
+}
+
+func BenchmarkListObjectsV2MaxDepth(b *testing.B) {
+	dir := makeTree(b, b.TempDir(), 100, 100)
+	h := NewHandler(dir, "vault", "", "", "us-east-1", noopSyncer{})
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		req := httptest.NewRequest("GET", "/vault?list-type=2&x-max-depth=1", nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		io.Copy(io.Discard, w.Body)
+	}
+}
+
+func makeTree(tb testing.TB, dir string, dirs, files int) string {
+	tb.Helper()
+	for d := 0; d < dirs; d++ {
+		sub := filepath.Join(dir, fmt.Sprintf("dir%03d", d))
+		if err := os.MkdirAll(sub, 0755); err != nil {
+			tb.Fatal(err)
+		}
+		for f := 0; f < files; f++ {
+			if err := os.WriteFile(filepath.Join(sub, fmt.Sprintf("note%03d.md", f)), []byte("x"), 0644); err != nil {
+				tb.Fatal(err)
+			}
+		}
+	}
+	return dir
+}
+
+func decodeListing(t *testing.T, r io.Reader) ListBucketResult {
+	t.Helper()
+	var result ListBucketResult
+	if err := xml.NewDecoder(r).Decode(&result); err != nil {
+		t.Fatalf("failed to parse XML: %v", err)
+	}
+	return result
+}
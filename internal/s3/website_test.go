@@ -0,0 +1,60 @@
+package s3
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIndexDocument(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "notes"), 0755)
+	os.WriteFile(filepath.Join(dir, "notes", "index.md"), []byte("# Notes"), 0644)
+	h := NewHandlerWithOptions(dir, WithIndexDocument("index.md"))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/vault/notes/", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "# Notes" {
+		t.Fatalf("GET notes/ = %d %q, want the index document", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/vault/empty/", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("GET on a prefix without an index got %d, want 404", w.Code)
+	}
+
+	// Listing the bucket is unaffected
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/vault/?list-type=2", nil))
+	if res := decodeListing(t, w.Body); len(res.Contents) != 1 {
+		t.Fatalf("listing returned %d objects, want 1", len(res.Contents))
+	}
+}
+
+func TestErrorDocument(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "404.html"), []byte("<h1>Not here</h1>"), 0644)
+	h := NewHandlerWithOptions(dir, WithErrorDocument("404.html"))
+
+	req := httptest.NewRequest("GET", "/vault/missing.md", nil)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml,*/*;q=0.8")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound || w.Body.String() != "<h1>Not here</h1>" {
+		t.Fatalf("browser GET = %d %q, want 404 with the error document", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Fatalf("Content-Type = %q, want text/html", ct)
+	}
+
+	// SDKs don't ask for HTML and get the S3 error
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/vault/missing.md", nil))
+	if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "<Code>NoSuchKey</Code>") {
+		t.Fatalf("XML client GET = %d %q, want NoSuchKey", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,127 @@
+// Package webhook notifies other services when vault content changes.
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// SignatureHeader carries the HMAC-SHA256 of the request body, keyed with
+// the shared secret, as "sha256=<hex>".
+const SignatureHeader = "X-Git3-Signature-256"
+
+// Event describes content that arrived through a pull. An event for
+// conflicts the pull left has no OldHash: Paths are the conflicting files
+// and Conflicts, in the same order, where their remote versions went.
+type Event struct {
+	OldHash   string    `json:"old_hash"`
+	NewHash   string    `json:"new_hash"`
+	Paths     []string  `json:"paths"`
+	Conflicts []string  `json:"conflicts,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Notifier POSTs events to a set of URLs.
+type Notifier struct {
+	URLs    []string
+	Secret  string        // signs each body when set
+	Retries int           // extra attempts after a failed delivery
+	Backoff time.Duration // delay before the first retry, doubled after each
+	Client  *http.Client  // its Timeout bounds each attempt
+}
+
+// New creates a Notifier with a 10 second timeout per attempt and three
+// retries starting at one second apart.
+func New(urls []string, secret string) *Notifier {
+	return &Notifier{
+		URLs:    urls,
+		Secret:  secret,
+		Retries: 3,
+		Backoff: time.Second,
+		Client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Changed sends an event for a pull that moved the branch from oldHash to
+// newHash. Delivery happens in the background, so a slow or dead receiver
+// never holds up the caller.
+func (n *Notifier) Changed(oldHash, newHash string, paths []string) {
+	n.send(Event{OldHash: oldHash, NewHash: newHash, Paths: paths})
+}
+
+// Conflicted sends an event for the conflicts a merging pull left at
+// hash, like Changed.
+func (n *Notifier) Conflicted(hash string, paths, copies []string) {
+	n.send(Event{NewHash: hash, Paths: paths, Conflicts: copies})
+}
+
+func (n *Notifier) send(e Event) {
+	e.Timestamp = time.Now().UTC()
+	body, err := json.Marshal(e)
+	if err != nil {
+		log.Printf("[webhook] encode event: %v", err)
+		return
+	}
+	for _, url := range n.URLs {
+		go n.deliver(url, body)
+	}
+}
+
+func (n *Notifier) deliver(url string, body []byte) {
+	if err := n.Deliver(url, body); err != nil {
+		log.Printf("[webhook] %s: %v", url, err)
+	}
+}
+
+// Deliver POSTs body to url as JSON, signed when a secret is set, and
+// retries with backoff until it is accepted or the retries run out. It
+// blocks until then, returning the last error if it gave up.
+func (n *Notifier) Deliver(url string, body []byte) error {
+	backoff := n.Backoff
+	for attempt := 0; ; attempt++ {
+		err := n.post(url, body)
+		if err == nil {
+			return nil
+		}
+		if attempt >= n.Retries {
+			return fmt.Errorf("giving up after %d attempts: %w", attempt+1, err)
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (n *Notifier) post(url string, body []byte) error {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if n.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(n.Secret, body))
+	}
+
+	resp, err := n.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+	return nil
+}
+
+// Sign returns the signature header value for body.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
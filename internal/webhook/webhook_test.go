@@ -0,0 +1,78 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestChangedDeliversSignedEvent(t *testing.T) {
+	got := make(chan Event, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get(SignatureHeader) != Sign("s3cret", body) {
+			t.Errorf("bad signature %q", r.Header.Get(SignatureHeader))
+		}
+		var e Event
+		json.Unmarshal(body, &e)
+		got <- e
+	}))
+	defer srv.Close()
+
+	New([]string{srv.URL}, "s3cret").Changed("aaa", "bbb", []string{"notes/a.md"})
+
+	select {
+	case e := <-got:
+		if e.OldHash != "aaa" || e.NewHash != "bbb" || len(e.Paths) != 1 || e.Paths[0] != "notes/a.md" || e.Timestamp.IsZero() {
+			t.Fatalf("unexpected event %+v", e)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("webhook not delivered")
+	}
+}
+
+func TestChangedRetriesFailedDelivery(t *testing.T) {
+	var attempts atomic.Int32
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		close(done)
+	}))
+	defer srv.Close()
+
+	n := New([]string{srv.URL}, "")
+	n.Backoff = time.Millisecond
+	n.Changed("a", "b", nil)
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("delivered after %d attempts, want success on the third", attempts.Load())
+	}
+}
+
+func TestChangedDoesNotBlock(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	n := New([]string{srv.URL}, "")
+	n.Client.Timeout = 50 * time.Millisecond
+	n.Retries = 0
+
+	start := time.Now()
+	n.Changed("a", "b", nil)
+	if time.Since(start) > 20*time.Millisecond {
+		t.Fatal("Changed blocked on a slow receiver")
+	}
+}
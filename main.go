@@ -1,77 +1,151 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
-	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
-	"git3/internal/git"
-	"git3/internal/s3"
+	"git3/server"
 )
 
-type Config struct {
-	Dir       string
-	Bucket    string
-	Addr      string
-	AccessKey string
-	SecretKey string
-	Region    string
-	GitRepo   string
-	GitBranch string
-	GitUser   string
-	GitEmail  string
-	GitToken  string
-	Debounce  time.Duration
-}
-
 func main() {
-	var cfg Config
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		os.Exit(runCommand(os.Args[1], os.Args[2:]))
+	}
+
+	var cfg server.Config
 
 	flag.StringVar(&cfg.Dir, "dir", envOr("VAULT_DIR", "/vault"), "vault directory")
 	flag.StringVar(&cfg.Bucket, "bucket", envOr("BUCKET", "vault"), "S3 bucket name")
+	flag.StringVar(&cfg.Owner, "owner", envOr("OWNER", "git3"), "owner ID reported in bucket and object ACLs")
 	flag.StringVar(&cfg.Addr, "addr", envOr("ADDR", ":80"), "listen address")
 	flag.StringVar(&cfg.AccessKey, "access-key", envOr("ACCESS_KEY", ""), "S3 access key")
 	flag.StringVar(&cfg.SecretKey, "secret-key", envOr("SECRET_KEY", ""), "S3 secret key")
 	flag.StringVar(&cfg.Region, "region", envOr("REGION", "us-east-1"), "S3 region")
 	flag.StringVar(&cfg.GitRepo, "git-repo", envOr("GIT_REPO", ""), "git remote URL")
 	flag.StringVar(&cfg.GitBranch, "git-branch", envOr("GIT_BRANCH", "main"), "git branch")
+	flag.StringVar(&cfg.PushBranch, "push-branch", envOr("PUSH_BRANCH", ""), "branch sync commits are pushed to (default: git-branch)")
+	flag.StringVar(&cfg.PullBranch, "pull-branch", envOr("PULL_BRANCH", ""), "branch pulled into the vault (default: git-branch)")
 	flag.StringVar(&cfg.GitUser, "git-user", envOr("GIT_USER", "git3"), "git commit user")
 	flag.StringVar(&cfg.GitEmail, "git-email", envOr("GIT_EMAIL", "git3@sync"), "git commit email")
+	flag.StringVar(&cfg.GitCommitter, "git-committer", envOr("GIT_COMMITTER", ""), "git committer name (default the commit user)")
+	flag.StringVar(&cfg.GitCommitterEmail, "git-committer-email", envOr("GIT_COMMITTER_EMAIL", ""), "git committer email (default the commit email)")
 	flag.StringVar(&cfg.GitToken, "git-token", envOr("GIT_TOKEN", ""), "git PAT for HTTPS auth")
+	flag.StringVar(&cfg.Attributes, "gitattributes", envOr("GITATTRIBUTES", "auto"), "keep a git3 section in .gitattributes: auto (unless the file has rules of its own), manage (always) or off")
+	flag.StringVar(&cfg.AttributesBinary, "gitattributes-binary", envOr("GITATTRIBUTES_BINARY", server.DefaultAttributesBinary), "comma-separated patterns .gitattributes marks -text -diff")
+	flag.StringVar(&cfg.AttributesText, "gitattributes-text", envOr("GITATTRIBUTES_TEXT", server.DefaultAttributesText), "comma-separated patterns .gitattributes marks text eol=lf")
+	flag.StringVar(&cfg.ConflictPolicy, "conflict-policy", envOr("CONFLICT_POLICY", "fail"), "what a pull does when the branch and the remote diverged: fail, or manual to merge and keep conflicting remote versions under .conflicts/")
+	flag.StringVar(&cfg.SecretScan, "secret-scan", envOr("SECRET_SCAN", "off"), "what a sync does with changes that look like they hold a secret: off, block (commit nothing) or skip (commit the rest)")
+	flag.StringVar(&cfg.SecretPatterns, "secret-patterns", envOr("SECRET_PATTERNS", ""), "whitespace-separated regular expressions the secret scan also looks for")
+	flag.StringVar(&cfg.SecretAllow, "secret-allow", envOr("SECRET_ALLOW", ""), "comma-separated gitignore-style path patterns the secret scan skips")
+	flag.StringVar(&cfg.PRForge, "pr-forge", envOr("PR_FORGE", ""), "github or gitea to open pull requests from push-branch into pull-branch instead of pushing to it")
+	flag.StringVar(&cfg.PRAPIURL, "pr-api-url", envOr("PR_API_URL", ""), "forge API base URL (derived from git-repo if empty)")
+	flag.StringVar(&cfg.PRToken, "pr-token", envOr("PR_TOKEN", ""), "API token for opening and merging pull requests")
+	flag.BoolVar(&cfg.PRAutoMerge, "pr-auto-merge", envOrBool("PR_AUTO_MERGE", false), "merge the open pull request once its checks pass")
+	flag.BoolVar(&cfg.ResetOnForcePush, "reset-on-force-push", envOrBool("RESET_ON_FORCE_PUSH", false), "follow a force-pushed remote branch, keeping the old history in a backup branch")
+	pullBeforePush := flag.Bool("pull-before-push", envOrBool("PULL_BEFORE_PUSH", true), "pull before every push; turn off only when nothing else writes to the remote branch")
+	flag.StringVar(&cfg.Subdir, "subdir", envOr("SUBDIR", ""), "serve and sync only this subdirectory of the repo")
+	flag.StringVar(&cfg.Layout, "layout", envOr("LAYOUT", "flat"), "how objects are stored on disk: flat, at their keys' paths, or hashed, spread over hash-named directories")
+	flag.BoolVar(&cfg.Dedup, "dedup", envOrBool("DEDUP", false), "store identical objects once, as hardlinks to a shared blob")
+	flag.StringVar(&cfg.IndexDoc, "index-document", envOr("INDEX_DOCUMENT", ""), "object returned for GETs on a key ending in / (e.g. index.html)")
+	flag.StringVar(&cfg.ErrorDoc, "error-document", envOr("ERROR_DOCUMENT", ""), "object served to browsers for missing keys (e.g. 404.html)")
+	flag.StringVar(&cfg.DefaultContentType, "default-content-type", envOr("DEFAULT_CONTENT_TYPE", ""), "Content-Type for keys without an extension (default: sniffed)")
+	flag.StringVar(&cfg.ContentTypes, "content-types", envOr("CONTENT_TYPES", ""), "comma-separated ext=type Content-Type overrides (e.g. .txt=text/plain)")
+	flag.BoolVar(&cfg.DisableCORS, "disable-cors", envOrBool("DISABLE_CORS", false), "send no CORS headers and reject OPTIONS, for deployments without browser clients")
+	flag.StringVar(&cfg.CORSOrigins, "cors-origins", envOr("CORS_ORIGINS", ""), "comma-separated origins browsers may use the API from (default any)")
+	flag.StringVar(&cfg.CORSHeaders, "cors-headers", envOr("CORS_HEADERS", ""), "comma-separated request headers CORS preflights may ask for (default any)")
+	corsMaxAge := flag.Int("cors-max-age", envOrInt("CORS_MAX_AGE", 3600), "seconds browsers may cache the answer to a CORS preflight")
+	flag.StringVar(&cfg.WriteKeyAllow, "write-key-allow", envOr("WRITE_KEY_ALLOW", ""), "comma-separated key patterns PUT and DELETE are limited to (e.g. notes/**; default any)")
+	flag.IntVar(&cfg.MaxKeyLength, "max-key-length", envOrInt("MAX_KEY_LENGTH", 1024), "longest key in bytes a PUT or DELETE may name")
+	flag.StringVar(&cfg.LFSPatterns, "lfs-patterns", envOr("LFS_PATTERNS", ""), "comma-separated patterns stored with Git LFS (e.g. *.png,*.mp3)")
+	flag.Int64Var(&cfg.LFSThreshold, "lfs-threshold", int64(envOrInt("LFS_THRESHOLD", 0)), "store objects of at least this many bytes with Git LFS (0 to disable)")
+	flag.Int64Var(&cfg.RepoSizeLimit, "repo-size-limit", int64(envOrInt("REPO_SIZE_LIMIT", 0)), "bytes the git repository should stay under; going over is logged and recorded as an event (0 to disable)")
+	flag.Int64Var(&cfg.RepoSizeRefuseOver, "repo-size-refuse-over", int64(envOrInt("REPO_SIZE_REFUSE_OVER", 0)), "while over the repository size limit, refuse PUTs of objects larger than this many bytes (0 to accept all)")
+	flag.Int64Var(&cfg.CacheSize, "cache-size", int64(envOrInt("CACHE_SIZE", 0)), "bytes of small objects' content to keep in memory for repeated GETs (0 to disable)")
+	flag.Int64Var(&cfg.CacheMaxObject, "cache-max-object", int64(envOrInt("CACHE_MAX_OBJECT", 65536)), "largest object, in bytes, the cache keeps")
+	idempotencyWindow := flag.Int("idempotency-window", envOrInt("IDEMPOTENCY_WINDOW", 0), "seconds within which a repeated identical PUT is answered without writing or syncing again (0 to disable)")
+	flag.StringVar(&cfg.LFSURL, "lfs-url", envOr("LFS_URL", ""), "Git LFS endpoint (derived from the git remote if empty)")
+	flag.StringVar(&cfg.SignedHeaders, "signed-headers", envOr("SIGNED_HEADERS", ""), "comma-separated headers every signature must cover (default host,x-amz-date)")
+	flag.StringVar(&cfg.SignedHeadersWrites, "signed-headers-writes", envOr("SIGNED_HEADERS_WRITES", ""), "comma-separated headers PUT and DELETE signatures must also cover (default x-amz-content-sha256)")
+	flag.StringVar(&cfg.AdminToken, "admin-token", envOr("ADMIN_TOKEN", ""), "bearer token for the admin API under /-/ (disabled if empty)")
+	flag.StringVar(&cfg.StatusToken, "status-token", envOr("STATUS_TOKEN", ""), "bearer token for /-/status (open if empty)")
+	flag.StringVar(&cfg.AllowCIDRs, "allow-cidrs", envOr("ALLOW_CIDRS", ""), "comma-separated networks allowed to connect (all if empty)")
+	flag.StringVar(&cfg.DenyCIDRs, "deny-cidrs", envOr("DENY_CIDRS", ""), "comma-separated networks always rejected")
+	flag.StringVar(&cfg.TrustedProxies, "trusted-proxies", envOr("TRUSTED_PROXIES", ""), "comma-separated proxy networks whose X-Forwarded-For and X-Forwarded-Proto are honored")
+	flag.BoolVar(&cfg.RequireTLS, "require-tls", envOrBool("REQUIRE_TLS", false), "refuse signed requests that didn't arrive over HTTPS")
+	flag.BoolVar(&cfg.IPFilterWritesOnly, "ip-filter-writes-only", envOrBool("IP_FILTER_WRITES_ONLY", false), "apply the allow/deny lists to writes only")
+	flag.BoolVar(&cfg.Debug, "debug", envOrBool("DEBUG", false), "log routine sync decisions")
+	flag.StringVar(&cfg.HookSecret, "hook-secret", envOr("HOOK_SECRET", ""), "secret for push webhooks from the git host at /-/hooks/push (disabled if empty)")
+	flag.StringVar(&cfg.WebhookURLs, "webhook-urls", envOr("WEBHOOK_URLS", ""), "comma-separated URLs notified when a pull brings new changes")
+	flag.StringVar(&cfg.WebhookSecret, "webhook-secret", envOr("WEBHOOK_SECRET", ""), "HMAC key for the webhook signature header")
+	flag.StringVar(&cfg.NotifyURLs, "notify-urls", envOr("NOTIFY_URLS", ""), "comma-separated URLs sent S3 event notifications for objects written or deleted")
+	flag.StringVar(&cfg.NotifyEvents, "notify-events", envOr("NOTIFY_EVENTS", ""), "comma-separated events to notify (e.g. s3:ObjectCreated:*; default all)")
+	flag.StringVar(&cfg.NotifyPrefix, "notify-prefix", envOr("NOTIFY_PREFIX", ""), "notify only for keys with this prefix")
+	flag.StringVar(&cfg.NotifySuffix, "notify-suffix", envOr("NOTIFY_SUFFIX", ""), "notify only for keys with this suffix")
+	flag.StringVar(&cfg.NotifySecret, "notify-secret", envOr("NOTIFY_SECRET", ""), "HMAC key for the notification signature header")
+	flag.StringVar(&cfg.EncryptionKey, "encryption-key", envOr("ENCRYPTION_KEY", ""), "32 byte key, hex or base64, to encrypt objects at rest with (disabled if empty)")
+	flag.StringVar(&cfg.EncryptionKeyFile, "encryption-key-file", envOr("ENCRYPTION_KEY_FILE", ""), "file holding the encryption key")
+	flag.BoolVar(&cfg.EncryptionMigrate, "encryption-migrate", envOrBool("ENCRYPTION_MIGRATE", false), "encrypt existing plaintext objects on startup instead of refusing to start")
+	uploadMaxAge := flag.Int("upload-max-age", envOrInt("UPLOAD_MAX_AGE", 24), "hours a resumable upload may go without a chunk before it is removed")
 	debounce := flag.Int("debounce", envOrInt("DEBOUNCE", 10), "git sync debounce in seconds")
+	commitDebounce := flag.Int("commit-debounce", envOrInt("COMMIT_DEBOUNCE", 0), "seconds after the last write to commit, instead of the debounce (0 to use the debounce)")
+	pushDebounce := flag.Int("push-debounce", envOrInt("PUSH_DEBOUNCE", 0), "seconds after the first unpushed commit to push (0 to push every commit right away)")
+	flag.StringVar(&cfg.SyncSchedule, "sync-schedule", envOr("SYNC_SCHEDULE", ""), "comma-separated HH:MM times of day to also sync at, such as 03:00")
+	flag.BoolVar(&cfg.SyncHeartbeat, "sync-heartbeat", envOrBool("SYNC_HEARTBEAT", false), "make an empty commit when a scheduled sync has nothing to commit")
+	rollupWindow := flag.Int("rollup-window", envOrInt("ROLLUP_WINDOW", 0), "seconds within which a sync amends the last unpushed sync commit instead of adding one (0 to disable)")
+	flag.StringVar(&cfg.RollupSchedule, "rollup-schedule", envOr("ROLLUP_SCHEDULE", ""), "comma-separated HH:MM times of day to squash unpushed sync commits into one and push it")
+	flag.StringVar(&cfg.SyncExclude, "sync-exclude", envOr("SYNC_EXCLUDE", ""), "comma-separated key prefixes served but never committed, such as cache/")
+	flag.StringVar(&cfg.PreCommitHook, "pre-commit-hook", envOr("PRE_COMMIT_HOOK", ""), "shell command run in the vault before each commit, e.g. to regenerate an index")
+	flag.StringVar(&cfg.PostCommitHook, "post-commit-hook", envOr("POST_COMMIT_HOOK", ""), "shell command run after each commit")
+	flag.StringVar(&cfg.PostPushHook, "post-push-hook", envOr("POST_PUSH_HOOK", ""), "shell command run after each push")
+	flag.StringVar(&cfg.PostPullHook, "post-pull-hook", envOr("POST_PULL_HOOK", ""), "shell command run after a pull brings changes")
+	flag.StringVar(&cfg.PreCommitFailure, "pre-commit-failure", envOr("PRE_COMMIT_FAILURE", "abort"), "what a sync does when the pre-commit hook fails: abort, or continue to commit anyway")
+	hookTimeout := flag.Int("hook-timeout", envOrInt("HOOK_TIMEOUT", 60), "seconds a hook may run before it is killed")
 	pullInterval := flag.Int("pull-interval", envOrInt("PULL_INTERVAL", 60), "git pull interval in seconds (0 to disable)")
+	remoteProbeInterval := flag.Int("remote-probe-interval", envOrInt("REMOTE_PROBE_INTERVAL", 0), "seconds between probes of the git remote's reachability and credentials (0 to disable, at least 60)")
+	flag.BoolVar(&cfg.Replica, "replica", envOrBool("REPLICA", false), "serve read-only as a pull-only replica of GIT_REPO until promoted through the admin API")
+	replicaPullInterval := flag.Int("replica-pull-interval", envOrInt("REPLICA_PULL_INTERVAL", 10), "seconds between a replica's pulls")
+	flag.BoolVar(&cfg.CommitOnStartup, "commit-on-startup", envOrBool("COMMIT_ON_STARTUP", false), "commit changes left in the working tree since the last run before pulling")
+	cloneTimeout := flag.Int("clone-timeout", envOrInt("CLONE_TIMEOUT", 1800), "seconds the first clone, and the pull before serving, may take")
 	flag.Parse()
 
 	cfg.Debounce = time.Duration(*debounce) * time.Second
+	cfg.CommitDebounce = time.Duration(*commitDebounce) * time.Second
+	cfg.PushDebounce = time.Duration(*pushDebounce) * time.Second
+	cfg.RollupWindow = time.Duration(*rollupWindow) * time.Second
+	cfg.HookTimeout = time.Duration(*hookTimeout) * time.Second
+	cfg.IdempotencyWindow = time.Duration(*idempotencyWindow) * time.Second
+	cfg.CORSMaxAge = time.Duration(*corsMaxAge) * time.Second
+	cfg.SkipPullBeforePush = !*pullBeforePush
+	cfg.PullInterval = time.Duration(*pullInterval) * time.Second
+	cfg.RemoteProbeInterval = time.Duration(*remoteProbeInterval) * time.Second
+	cfg.ReplicaPullInterval = time.Duration(*replicaPullInterval) * time.Second
+	cfg.UploadMaxAge = time.Duration(*uploadMaxAge) * time.Hour
+	cfg.CloneTimeout = time.Duration(*cloneTimeout) * time.Second
 
-	gitCfg := git.Config{
-		Dir:      cfg.Dir,
-		Repo:     cfg.GitRepo,
-		Branch:   cfg.GitBranch,
-		User:     cfg.GitUser,
-		Email:    cfg.GitEmail,
-		Token:    cfg.GitToken,
-		Debounce: cfg.Debounce,
+	srv, err := server.New(cfg)
+	if err != nil {
+		log.Fatalf("[git3] %v", err)
 	}
-
-	pullDuration := time.Duration(*pullInterval) * time.Second
-
-	repo := git.InitRepo(gitCfg)
-	syncer := git.New(gitCfg, repo)
-	syncer.StartPuller(pullDuration)
-	handler := s3.NewHandler(cfg.Dir, cfg.Bucket, cfg.AccessKey, cfg.SecretKey, cfg.Region, syncer)
-
-	log.Printf("[git3] listening on %s", cfg.Addr)
-	log.Printf("[git3] bucket=%s dir=%s region=%s", cfg.Bucket, cfg.Dir, cfg.Region)
-	if cfg.GitRepo != "" {
-		log.Printf("[git3] git=%s branch=%s debounce=%s pull=%s", cfg.GitRepo, cfg.GitBranch, cfg.Debounce, pullDuration)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := srv.Start(ctx); err != nil {
+		log.Fatal(err)
 	}
+	<-ctx.Done()
 
-	if err := http.ListenAndServe(cfg.Addr, s3.LoggingMiddleware(handler)); err != nil {
-		log.Fatal(err)
+	// Commit and push what the last requests changed before exiting
+	log.Println("[git3] shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("[git3] shutdown: %v", err)
 	}
 }
 
@@ -90,3 +164,12 @@ func envOrInt(key string, fallback int) int {
 	}
 	return fallback
 }
+
+func envOrBool(key string, fallback bool) bool {
+	if v := os.Getenv(key); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			return b
+		}
+	}
+	return fallback
+}
@@ -0,0 +1,890 @@
+// Package server wires the git syncer, the S3 handler and the admin API
+// into one embeddable server. The git3 command is a thin wrapper around it.
+package server
+
+import (
+	"cmp"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"git3/internal/admin"
+	"git3/internal/feed"
+	"git3/internal/forge"
+	"git3/internal/git"
+	"git3/internal/lfs"
+	"git3/internal/metrics"
+	"git3/internal/s3"
+	"git3/internal/webhook"
+)
+
+// Config holds everything needed to run a server. The zero value of each
+// field disables the feature it controls, except where noted.
+type Config struct {
+	Dir        string
+	Bucket     string // default "vault"
+	Owner      string // default "git3"
+	Addr       string // default ":80"
+	AccessKey  string
+	SecretKey  string
+	Region     string // default "us-east-1"
+	GitRepo    string
+	GitBranch  string // default "main"
+	PushBranch string
+	PullBranch string
+	GitUser    string // default "git3"
+	GitEmail   string // default "git3@sync"
+	GitToken   string
+	Subdir     string
+	Dedup      bool
+	IndexDoc   string
+	ErrorDoc   string
+	Debug      bool
+
+	// GitCommitter and GitCommitterEmail sign commits as committer; they
+	// default to GitUser and GitEmail.
+	GitCommitter      string
+	GitCommitterEmail string
+
+	// DisableCORS drops the Access-Control-* headers and rejects OPTIONS.
+	// CORSOrigins is comma-separated; empty means any origin. CORSHeaders
+	// lists, comma-separated, the request headers preflights may ask for;
+	// empty means any. CORSMaxAge is how long browsers may cache a
+	// preflight's answer; zero means s3.DefaultCORSMaxAge.
+	DisableCORS bool
+	CORSOrigins string
+	CORSHeaders string
+	CORSMaxAge  time.Duration
+
+	// WriteKeyAllow is a comma-separated list of key patterns PUT and
+	// DELETE are limited to, such as "notes/**"; empty allows any key.
+	WriteKeyAllow string
+	// MaxKeyLength is the longest key in bytes PUT and DELETE accept;
+	// zero means s3.DefaultMaxKeyLength.
+	MaxKeyLength int
+
+	DefaultContentType string
+	ContentTypes       string // comma-separated ext=type pairs, e.g. ".txt=text/plain"
+
+	Debounce time.Duration
+	// CommitDebounce and PushDebounce split the debounce in two: commits
+	// are made CommitDebounce after the last write, and pushed within
+	// PushDebounce of the first unpushed one. Zero keeps Debounce for
+	// commits and pushes each commit right away; see git.Config.
+	CommitDebounce time.Duration
+	PushDebounce   time.Duration
+	PullInterval   time.Duration
+	// RemoteProbeInterval is how often the remote is listed to check it
+	// is reachable and accepts the token, for /-/status and the metrics;
+	// zero turns it off. See git.Syncer.StartRemoteProbe.
+	RemoteProbeInterval time.Duration
+	// Replica serves the vault read-only as a pull-only replica of
+	// GitRepo, pulling every ReplicaPullInterval (default 10s), until it
+	// is promoted through POST /-/promote; see git.Config.Replica.
+	Replica             bool
+	ReplicaPullInterval time.Duration
+	// SyncSchedule is comma-separated "HH:MM" times of day to sync at as
+	// well; see git.ParseSchedule. SyncHeartbeat makes those syncs commit
+	// even when nothing changed; see git.Config.Heartbeat.
+	SyncSchedule  string
+	SyncHeartbeat bool
+	// RollupWindow and RollupSchedule keep unpushed sync commits down to
+	// one: a sync within the window amends the last one, and at each
+	// "HH:MM" time of the schedule they are squashed and pushed. Neither
+	// rewrites what was pushed; see git.Config.RollupWindow.
+	RollupWindow   time.Duration
+	RollupSchedule string
+	// SyncExclude is a comma-separated list of key prefixes that are
+	// served but never committed; see git.Config.Exclude.
+	SyncExclude string
+	// Hook commands run at points of a sync, each for up to HookTimeout
+	// (default one minute). PreCommitFailure is git.HookAbort (the
+	// default) or git.HookContinue; see git.Hooks.
+	PreCommitHook    string
+	PostCommitHook   string
+	PostPushHook     string
+	PostPullHook     string
+	HookTimeout      time.Duration
+	PreCommitFailure string
+	// CommitOnStartup commits the untracked and modified files an unclean
+	// shutdown left in the working tree, before the pull Start makes, and
+	// pushes them with the next sync; see git.Config.CommitOnStartup.
+	CommitOnStartup bool
+	// CloneTimeout bounds the clone of the remote on first start, and how
+	// long Start waits for the pull it makes before serving (default 30m)
+	CloneTimeout time.Duration
+	// UploadMaxAge is how long a resumable upload may go without a chunk
+	// before it is removed as abandoned (default 24h).
+	UploadMaxAge time.Duration
+	// PRForge, "github" or "gitea", turns on pull-request mode: syncs
+	// are pushed to PushBranch (default "git3/sync") and go into the pull
+	// branch through a pull request opened with PRToken, merged by git3
+	// with PRAutoMerge. PRAPIURL is derived from GitRepo when empty; see
+	// git.Config.PullRequests.
+	PRForge     string
+	PRAPIURL    string
+	PRToken     string
+	PRAutoMerge bool
+	// ResetOnForcePush follows a force-pushed remote branch; see
+	// git.Config.ResetOnForcePush.
+	ResetOnForcePush bool
+	// SkipPullBeforePush pushes without pulling first, for a vault nothing
+	// else writes to; see git.Config.SkipPullBeforePush.
+	SkipPullBeforePush bool
+	// ConflictPolicy is git.ConflictsFail (the default) or
+	// git.ConflictsManual; see git.Config.ConflictPolicy.
+	ConflictPolicy string
+	// Attributes is git.AttributesAuto (the default), git.AttributesManage
+	// or git.AttributesOff; see git.Config.Attributes. AttributesBinary
+	// and AttributesText are comma-separated patterns for it.
+	Attributes       string
+	AttributesBinary string
+	AttributesText   string
+	// SecretScan is git.SecretsOff (the default), git.SecretsBlock or
+	// git.SecretsSkip; see git.Config.SecretScan. SecretPatterns are
+	// extra regular expressions, separated by whitespace, and SecretAllow
+	// comma-separated path patterns that aren't scanned.
+	SecretScan     string
+	SecretPatterns string
+	SecretAllow    string
+	// OnSync is called after every sync; see git.Config.OnSync. It has
+	// no flag, being for programs embedding the server.
+	OnSync func(git.SyncResult)
+
+	LFSPatterns  string
+	LFSThreshold int64
+	LFSURL       string
+
+	// RepoSizeLimit is the size in bytes the repository should stay under;
+	// see git.Config.SizeLimit. While it is over, PUTs of objects larger
+	// than RepoSizeRefuseOver bytes are refused, unless that is zero.
+	RepoSizeLimit      int64
+	RepoSizeRefuseOver int64
+
+	// CacheSize is how many bytes of object content GETs may keep in
+	// memory, for objects of at most CacheMaxObject bytes; zero turns the
+	// cache off. See s3.WithCache.
+	CacheSize      int64
+	CacheMaxObject int64
+
+	// IdempotencyWindow is how long a PUT's retries are answered without
+	// writing again; zero turns it off. See s3.WithIdempotencyWindow.
+	IdempotencyWindow time.Duration
+
+	// Layout is how objects are stored on disk: "flat", at the paths their
+	// keys name, or "hashed"; see s3.WithHashedLayout. Default "flat".
+	Layout string
+
+	// Headers a request's signature must cover, comma-separated; empty
+	// means s3.DefaultSignedHeaders.
+	SignedHeaders       string
+	SignedHeadersWrites string
+
+	// RequireTLS refuses signed requests that didn't arrive over TLS, as
+	// told by a TrustedProxies peer's X-Forwarded-Proto where there is a
+	// proxy in front. Without it they are only warned about.
+	RequireTLS bool
+
+	AdminToken  string
+	StatusToken string
+	HookSecret  string
+
+	WebhookURLs   string
+	WebhookSecret string
+
+	// S3 event notifications for changes made through the API; the
+	// filters apply to every URL.
+	NotifyURLs   string
+	NotifyEvents string // comma-separated, e.g. "s3:ObjectCreated:*"
+	NotifyPrefix string
+	NotifySuffix string
+	NotifySecret string // signs each body like WebhookSecret does
+
+	// EncryptionKey (or the contents of EncryptionKeyFile) is a 32 byte
+	// key, hex or base64 encoded, that objects are encrypted with at rest.
+	// EncryptionMigrate encrypts objects stored in the clear on startup
+	// instead of refusing to start.
+	EncryptionKey     string
+	EncryptionKeyFile string
+	EncryptionMigrate bool
+
+	AllowCIDRs         string
+	DenyCIDRs          string
+	TrustedProxies     string
+	IPFilterWritesOnly bool
+}
+
+// Default patterns for the .gitattributes section: the attachments vaults
+// usually hold are binary, and notes are text.
+const (
+	DefaultAttributesBinary = "*.png,*.jpg,*.jpeg,*.gif,*.webp,*.pdf,*.mp3,*.m4a,*.wav,*.ogg,*.mp4,*.mov,*.webm,*.zip"
+	DefaultAttributesText   = "*.md"
+)
+
+func (cfg Config) withDefaults() Config {
+	setDefault(&cfg.Bucket, "vault")
+	setDefault(&cfg.Owner, "git3")
+	setDefault(&cfg.Addr, ":80")
+	setDefault(&cfg.Region, "us-east-1")
+	setDefault(&cfg.GitBranch, "main")
+	setDefault(&cfg.GitUser, "git3")
+	setDefault(&cfg.GitEmail, "git3@sync")
+	setDefault(&cfg.ConflictPolicy, git.ConflictsFail)
+	setDefault(&cfg.SecretScan, git.SecretsOff)
+	setDefault(&cfg.PreCommitFailure, git.HookAbort)
+	setDefault(&cfg.Layout, "flat")
+	setDefault(&cfg.Attributes, git.AttributesAuto)
+	setDefault(&cfg.AttributesBinary, DefaultAttributesBinary)
+	setDefault(&cfg.AttributesText, DefaultAttributesText)
+	if cfg.UploadMaxAge <= 0 {
+		cfg.UploadMaxAge = 24 * time.Hour
+	}
+	if cfg.CloneTimeout <= 0 {
+		cfg.CloneTimeout = 30 * time.Minute
+	}
+	if cfg.ReplicaPullInterval <= 0 {
+		cfg.ReplicaPullInterval = 10 * time.Second
+	}
+	return cfg
+}
+
+func setDefault(field *string, value string) {
+	if *field == "" {
+		*field = value
+	}
+}
+
+// Server is a configured git3 instance.
+type Server struct {
+	cfg     Config
+	syncer  *git.Syncer
+	vault   *s3.Handler
+	handler http.Handler
+	changes *feed.Broker
+	http    *http.Server
+	ln      net.Listener
+
+	shutdownOnce sync.Once
+	stopped      chan struct{} // closed when shutdown has finished
+	shutdownErr  error
+}
+
+// New prepares the vault directory and repository and builds the handlers,
+// but doesn't listen or sync yet.
+func New(cfg Config) (*Server, error) {
+	cfg = cfg.withDefaults()
+	if cfg.ConflictPolicy != git.ConflictsFail && cfg.ConflictPolicy != git.ConflictsManual {
+		return nil, fmt.Errorf("conflict policy %q: must be %q or %q", cfg.ConflictPolicy, git.ConflictsFail, git.ConflictsManual)
+	}
+	switch cfg.Attributes {
+	case git.AttributesAuto, git.AttributesManage, git.AttributesOff:
+	default:
+		return nil, fmt.Errorf("gitattributes mode %q: must be %q, %q or %q", cfg.Attributes, git.AttributesAuto, git.AttributesManage, git.AttributesOff)
+	}
+	switch cfg.SecretScan {
+	case git.SecretsOff, git.SecretsBlock, git.SecretsSkip:
+	default:
+		return nil, fmt.Errorf("secret scan %q: must be %q, %q or %q", cfg.SecretScan, git.SecretsOff, git.SecretsBlock, git.SecretsSkip)
+	}
+	if cfg.PreCommitFailure != git.HookAbort && cfg.PreCommitFailure != git.HookContinue {
+		return nil, fmt.Errorf("pre-commit failure policy %q: must be %q or %q", cfg.PreCommitFailure, git.HookAbort, git.HookContinue)
+	}
+	switch {
+	case cfg.Layout != "flat" && cfg.Layout != "hashed":
+		return nil, fmt.Errorf("layout %q: must be %q or %q", cfg.Layout, "flat", "hashed")
+	case cfg.Layout == "hashed" && (cfg.LFSPatterns != "" || cfg.LFSThreshold > 0):
+		// LFS patterns match keys, which the paths in git no longer are
+		return nil, errors.New("hashed layout cannot be combined with LFS")
+	case cfg.Layout == "hashed" && cfg.SyncExclude != "":
+		return nil, errors.New("hashed layout cannot be combined with sync exclusions")
+	}
+	subdir, err := git.CleanSubdir(cfg.Subdir)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Subdir = subdir
+	syncExclude := splitList(cfg.SyncExclude)
+	if err := git.CheckExcludes(syncExclude); err != nil {
+		return nil, err
+	}
+	if cfg.Replica && cfg.GitRepo == "" {
+		return nil, errors.New("replica: set GIT_REPO to the remote to follow")
+	}
+	secretPatterns, err := git.CompileSecretPatterns(strings.Fields(cfg.SecretPatterns))
+	if err != nil {
+		return nil, err
+	}
+	schedule, err := git.ParseSchedule(cfg.SyncSchedule)
+	if err != nil {
+		return nil, fmt.Errorf("sync schedule: %w", err)
+	}
+	rollup, err := git.ParseSchedule(cfg.RollupSchedule)
+	if err != nil {
+		return nil, fmt.Errorf("rollup schedule: %w", err)
+	}
+	var prs git.PullRequests
+	if cfg.PRForge != "" {
+		if prs, err = newPullRequests(&cfg); err != nil {
+			return nil, fmt.Errorf("pull-request mode: %w", err)
+		}
+	}
+	gitCfg := git.Config{
+		Dir:        cfg.Dir,
+		Repo:       cfg.GitRepo,
+		Branch:     cfg.GitBranch,
+		PushBranch: cfg.PushBranch,
+		PullBranch: cfg.PullBranch,
+		User:       cfg.GitUser,
+		Email:      cfg.GitEmail,
+		Token:      cfg.GitToken,
+		Subdir:     cfg.Subdir,
+		Debug:      cfg.Debug,
+		Debounce:   cfg.Debounce,
+
+		CommitDebounce:   cfg.CommitDebounce,
+		PushDebounce:     cfg.PushDebounce,
+		ResetOnForcePush: cfg.ResetOnForcePush,
+		ConflictPolicy:   cfg.ConflictPolicy,
+
+		SkipPullBeforePush: cfg.SkipPullBeforePush,
+
+		PullRequests:         prs,
+		PullRequestAutoMerge: cfg.PRAutoMerge,
+
+		Attributes:     cfg.Attributes,
+		BinaryPatterns: splitList(cfg.AttributesBinary),
+		TextPatterns:   splitList(cfg.AttributesText),
+		OnSync:         cfg.OnSync,
+		CloneTimeout:   cfg.CloneTimeout,
+		Committer:      cfg.GitCommitter,
+		CommitterEmail: cfg.GitCommitterEmail,
+		SecretScan:     cfg.SecretScan,
+		SecretPatterns: secretPatterns,
+		SecretAllow:    splitList(cfg.SecretAllow),
+		SizeLimit:      cfg.RepoSizeLimit,
+
+		Replica:             cfg.Replica,
+		ReplicaPullInterval: cfg.ReplicaPullInterval,
+
+		CommitOnStartup: cfg.CommitOnStartup,
+
+		Schedule:     schedule,
+		Heartbeat:    cfg.SyncHeartbeat,
+		RollupWindow: cfg.RollupWindow,
+		Rollup:       rollup,
+		Exclude:      syncExclude,
+		Hooks: git.Hooks{
+			PreCommit:        cfg.PreCommitHook,
+			PostCommit:       cfg.PostCommitHook,
+			PostPush:         cfg.PostPushHook,
+			PostPull:         cfg.PostPullHook,
+			Timeout:          cfg.HookTimeout,
+			PreCommitFailure: cfg.PreCommitFailure,
+		},
+	}
+
+	root := filepath.Join(cfg.Dir, cfg.Subdir)
+	repo, err := git.InitRepo(gitCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	var largeFiles *lfs.LFS
+	if cfg.LFSPatterns != "" || cfg.LFSThreshold > 0 {
+		if largeFiles, err = newLFS(cfg, root); err != nil {
+			return nil, fmt.Errorf("lfs: %w", err)
+		}
+		gitCfg.LFS = largeFiles
+	}
+
+	changes := feed.New()
+	// The handler is created after the syncer, which must know whom to tell
+	vault := &vaultStats{subdir: cfg.Subdir}
+	notifiers := changeNotifiers{feed.Pulls{Broker: changes, Subdir: cfg.Subdir}, vault}
+	if urls := splitList(cfg.WebhookURLs); len(urls) > 0 {
+		notifiers = append(notifiers, webhook.New(urls, cfg.WebhookSecret))
+		log.Printf("[git3] webhooks=%v", urls)
+	}
+	gitCfg.Notifier = notifiers
+
+	syncMetrics := metrics.NewSyncer()
+	gitCfg.Instrumentation = syncMetrics
+	authMetrics := metrics.NewAuth()
+
+	contentTypes, err := parseContentTypes(cfg.ContentTypes)
+	if err != nil {
+		return nil, err
+	}
+	cipher, err := newCipher(cfg)
+	if err != nil {
+		return nil, err
+	}
+	writeKeyAllow := splitList(cfg.WriteKeyAllow)
+	if err := s3.CheckKeyPatterns(writeKeyAllow); err != nil {
+		return nil, fmt.Errorf("write key allowlist: %w", err)
+	}
+
+	// Writes are let in once a replica is promoted
+	var handler *s3.Handler
+	gitCfg.OnPromote = func() { handler.SetReadOnly(false) }
+	syncer := git.New(gitCfg, repo)
+	opts := []s3.Option{
+		s3.WithBucket(cfg.Bucket),
+		s3.WithOwner(cfg.Owner),
+		s3.WithCredentials(cfg.AccessKey, cfg.SecretKey),
+		s3.WithRegion(cfg.Region),
+		s3.WithSyncer(syncer),
+		s3.WithTreeLock(syncer.TreeLock()),
+		s3.WithWriteLock(syncer.WriteLock()),
+		s3.WithDedup(cfg.Dedup),
+		s3.WithIndexDocument(cfg.IndexDoc),
+		s3.WithErrorDocument(cfg.ErrorDoc),
+		s3.WithDefaultContentType(cfg.DefaultContentType),
+		s3.WithContentTypes(contentTypes),
+		s3.WithCORS(!cfg.DisableCORS),
+		s3.WithWatcher(changes),
+		s3.WithAuthObserver(authMetrics),
+		s3.WithReadOnly(cfg.Replica),
+		s3.WithVersionCounter(syncer),
+	}
+	if cfg.MaxKeyLength > 0 {
+		opts = append(opts, s3.WithMaxKeyLength(cfg.MaxKeyLength))
+	}
+	if origins := splitList(cfg.CORSOrigins); len(origins) > 0 {
+		opts = append(opts, s3.WithCORSOrigins(origins...))
+	}
+	if headers := splitList(cfg.CORSHeaders); len(headers) > 0 {
+		opts = append(opts, s3.WithCORSHeaders(headers...))
+	}
+	if cfg.CORSMaxAge > 0 {
+		opts = append(opts, s3.WithCORSMaxAge(cfg.CORSMaxAge))
+	}
+	if len(writeKeyAllow) > 0 {
+		opts = append(opts, s3.WithWriteKeyAllow(writeKeyAllow...))
+		log.Printf("[git3] writes limited to keys matching %v", writeKeyAllow)
+	}
+	if largeFiles != nil {
+		opts = append(opts, s3.WithLFS(largeFiles))
+	}
+	if cipher != nil {
+		opts = append(opts, s3.WithEncryption(cipher))
+	}
+	if cfg.Layout == "hashed" {
+		opts = append(opts, s3.WithHashedLayout())
+		log.Printf("[git3] layout=hashed")
+	}
+	if cfg.RepoSizeLimit > 0 && cfg.RepoSizeRefuseOver > 0 {
+		opts = append(opts, s3.WithRepoSizeGuard(syncer, cfg.RepoSizeRefuseOver))
+	}
+	if cfg.CacheSize > 0 && cfg.CacheMaxObject > 0 {
+		opts = append(opts, s3.WithCache(cfg.CacheMaxObject, cfg.CacheSize))
+		log.Printf("[git3] cache=%d bytes, objects up to %d", cfg.CacheSize, cfg.CacheMaxObject)
+	}
+	if cfg.IdempotencyWindow > 0 {
+		opts = append(opts, s3.WithIdempotencyWindow(cfg.IdempotencyWindow))
+	}
+	if cfg.SignedHeaders != "" || cfg.SignedHeadersWrites != "" {
+		policy := s3.DefaultSignedHeaders
+		if cfg.SignedHeaders != "" {
+			policy.Required = splitList(cfg.SignedHeaders)
+		}
+		if cfg.SignedHeadersWrites != "" {
+			policy.Writes = splitList(cfg.SignedHeadersWrites)
+		}
+		opts = append(opts, s3.WithSignedHeaders(policy))
+	}
+	tlsProxies, err := s3.ParsePrefixes(cfg.TrustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("trusted-proxies: %w", err)
+	}
+	opts = append(opts, s3.WithRequireTLS(cfg.RequireTLS, tlsProxies...))
+	if urls := splitList(cfg.NotifyURLs); len(urls) > 0 {
+		rules := make([]s3.NotificationRule, len(urls))
+		for i, url := range urls {
+			rules[i] = s3.NotificationRule{
+				URL:    url,
+				Events: splitList(cfg.NotifyEvents),
+				Prefix: cfg.NotifyPrefix,
+				Suffix: cfg.NotifySuffix,
+				Secret: cfg.NotifySecret,
+			}
+		}
+		opts = append(opts, s3.WithNotifications(rules...))
+		log.Printf("[git3] notifications=%v", urls)
+	}
+	handler = s3.NewHandlerWithOptions(root, opts...)
+	vault.handler = handler
+	// A replica only checks: the primary encrypts
+	if n, err := handler.EncryptExisting(cfg.EncryptionMigrate && !cfg.Replica); err != nil {
+		return nil, fmt.Errorf("encryption: %w (set ENCRYPTION_MIGRATE=true to encrypt them)", err)
+	} else if n > 0 {
+		log.Printf("[git3] encrypted %d existing objects; their plaintext remains in the git history", n)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(admin.Prefix, admin.NewHandler(admin.Config{
+		Token:       cfg.AdminToken,
+		StatusToken: cfg.StatusToken,
+		HookSecret:  cfg.HookSecret,
+		S3Auth:      cfg.AccessKey != "",
+		Syncer:      syncer,
+		Vault:       handler,
+		Metrics:     metrics.Handler(syncMetrics, authMetrics, metrics.Size{Repo: syncer, Vault: handler}, metrics.Remote{Prober: syncer}),
+		Events:      changes,
+	}))
+	mux.Handle("/", handler)
+
+	var h http.Handler = mux
+	if cfg.AllowCIDRs != "" || cfg.DenyCIDRs != "" {
+		f, err := newIPFilter(cfg)
+		if err != nil {
+			return nil, err
+		}
+		h = s3.IPFilterMiddleware(f, mux)
+	}
+
+	return &Server{
+		cfg:     cfg,
+		syncer:  syncer,
+		vault:   handler,
+		handler: s3.LoggingMiddleware(h),
+		changes: changes,
+		stopped: make(chan struct{}),
+	}, nil
+}
+
+// Handler returns the server's HTTP handler, for embedders that run their
+// own listener instead of calling Start.
+func (s *Server) Handler() http.Handler {
+	return s.handler
+}
+
+// Syncer returns the server's git syncer.
+func (s *Server) Syncer() *git.Syncer {
+	return s.syncer
+}
+
+// Addr returns the address the server listens on once started.
+func (s *Server) Addr() net.Addr {
+	if s.ln == nil {
+		return nil
+	}
+	return s.ln.Addr()
+}
+
+// Start listens on the configured address, pulls so the vault is current
+// before the first request, and starts serving and pulling in the
+// background. Cancelling ctx shuts the server down as Shutdown does.
+func (s *Server) Start(ctx context.Context) error {
+	ln, err := net.Listen("tcp", s.cfg.Addr)
+	if err != nil {
+		return err
+	}
+	s.ln = ln
+	s.http = &http.Server{Handler: s.handler}
+	// Event streams never go idle; end them so Shutdown needn't wait
+	s.http.RegisterOnShutdown(s.changes.Close)
+
+	if s.cfg.CommitOnStartup && s.syncer.Status().Ahead > 0 {
+		// Push what InitRepo committed from before startup
+		s.syncer.Trigger()
+	}
+	s.initialPull()
+	if err := s.syncer.UpdateAttributes(); err != nil {
+		log.Printf("[git3] update .gitattributes: %v", err)
+	}
+	log.Printf("[git3] listening on %s", ln.Addr())
+	log.Printf("[git3] bucket=%s dir=%s region=%s", s.cfg.Bucket, s.cfg.Dir, s.cfg.Region)
+	if s.cfg.Subdir != "" {
+		log.Printf("[git3] subdir=%s", s.cfg.Subdir)
+	}
+	if s.cfg.GitRepo != "" {
+		log.Printf("[git3] git=%s branch=%s debounce=%s pull=%s", s.cfg.GitRepo, s.cfg.GitBranch, cmp.Or(s.cfg.CommitDebounce, s.cfg.Debounce), s.cfg.PullInterval)
+		if s.cfg.PushDebounce > 0 {
+			log.Printf("[git3] pushing within %s of the first unpushed commit", s.cfg.PushDebounce)
+		}
+	}
+	pullInterval := s.cfg.PullInterval
+	if s.cfg.Replica {
+		log.Printf("[git3] replica of %s: read-only, pulling every %s until promoted", s.cfg.GitRepo, s.cfg.ReplicaPullInterval)
+		// A replica pulls even when periodic pulls are off
+		pullInterval = cmp.Or(pullInterval, s.cfg.ReplicaPullInterval)
+	}
+
+	s.syncer.StartPuller(pullInterval)
+	s.syncer.StartSchedule()
+	s.syncer.StartRemoteProbe(s.cfg.RemoteProbeInterval)
+	go s.cleanUploads()
+	go s.reconcileMeta()
+	go func() {
+		if err := s.http.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("[git3] serve: %v", err)
+		}
+	}()
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.Shutdown(context.Background())
+		case <-s.stopped:
+		}
+	}()
+	return nil
+}
+
+// Shutdown stops accepting requests, waits for those in flight, stops
+// pulling, and commits and pushes any pending changes. If ctx is done
+// first it returns ctx.Err(), and the shutdown carries on in the
+// background. Later calls wait for the same shutdown.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.shutdownOnce.Do(func() { go s.shutdown() })
+	select {
+	case <-s.stopped:
+		return s.shutdownErr
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Server) shutdown() {
+	defer close(s.stopped)
+	if s.http != nil {
+		if err := s.http.Shutdown(context.Background()); err != nil {
+			log.Printf("[git3] shutdown: %v", err)
+		}
+	}
+	s.shutdownErr = s.syncer.Close()
+}
+
+// initialPull pulls before the server accepts requests, so clients don't
+// see a vault that is behind the remote. It waits at most CloneTimeout;
+// a pull still running then finishes while the server serves.
+func (s *Server) initialPull() {
+	if s.cfg.GitRepo == "" {
+		return
+	}
+	log.Println("[git3] pulling before serving")
+	done := make(chan struct{})
+	go func() {
+		s.syncer.Pull()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(s.cfg.CloneTimeout):
+		log.Printf("[git3] initial pull still running after %s; serving anyway", s.cfg.CloneTimeout)
+	}
+}
+
+// reconcileMeta drops metadata the working tree has outgrown while the
+// server was down, and logs what it did.
+func (s *Server) reconcileMeta() {
+	report, err := s.vault.ReconcileMeta()
+	if err != nil {
+		log.Printf("[git3] reconcile metadata: %v", err)
+		return
+	}
+	log.Printf("[git3] metadata reconciled in %s: %d entries kept, %d for missing objects and %d stale dropped",
+		report.Duration.Round(time.Millisecond), report.Kept, report.Missing, report.Stale)
+}
+
+// uploadJanitorInterval is how often abandoned uploads are looked for.
+var uploadJanitorInterval = time.Hour
+
+// cleanUploads removes abandoned resumable uploads until the server shuts
+// down.
+func (s *Server) cleanUploads() {
+	ticker := time.NewTicker(uploadJanitorInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			n, err := s.vault.CleanUploads(s.cfg.UploadMaxAge)
+			if err != nil {
+				log.Printf("[git3] clean uploads: %v", err)
+			} else if n > 0 {
+				log.Printf("[git3] removed %d abandoned uploads", n)
+			}
+		case <-s.stopped:
+			return
+		}
+	}
+}
+
+func newLFS(cfg Config, root string) (*lfs.LFS, error) {
+	lfsCfg := lfs.Config{
+		Root:      root,
+		GitDir:    filepath.Join(cfg.Dir, ".git"),
+		Threshold: cfg.LFSThreshold,
+	}
+	lfsCfg.Patterns = splitList(cfg.LFSPatterns)
+	endpoint := cfg.LFSURL
+	if endpoint == "" && cfg.GitRepo != "" {
+		endpoint = lfs.EndpointFor(cfg.GitRepo)
+	}
+	if endpoint != "" {
+		lfsCfg.Client = &lfs.Client{Endpoint: endpoint, Token: cfg.GitToken}
+	}
+
+	l, err := lfs.New(lfsCfg)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[git3] lfs patterns=%v threshold=%d endpoint=%s", lfsCfg.Patterns, cfg.LFSThreshold, endpoint)
+	return l, nil
+}
+
+// newPullRequests checks the pull-request mode settings, defaulting the
+// push branch to git3/sync, and returns the forge client.
+func newPullRequests(cfg *Config) (git.PullRequests, error) {
+	setDefault(&cfg.PushBranch, "git3/sync")
+	target := cmp.Or(cfg.PullBranch, cfg.GitBranch)
+	switch {
+	case cfg.GitRepo == "":
+		return nil, errors.New("set GIT_REPO to the repository to open pull requests on")
+	case cfg.PRToken == "":
+		return nil, errors.New("set PR_TOKEN to a token that may open pull requests")
+	case cfg.PushBranch == target:
+		return nil, fmt.Errorf("PUSH_BRANCH must differ from the branch pull requests go into, %s", target)
+	}
+	c, err := forge.New(cfg.PRForge, cfg.PRAPIURL, cfg.GitRepo, cfg.PRToken)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[git3] pull-request mode: %s into %s through %s, auto-merge=%v", cfg.PushBranch, target, c.API, cfg.PRAutoMerge)
+	return c, nil
+}
+
+func newIPFilter(cfg Config) (s3.IPFilter, error) {
+	f := s3.IPFilter{WritesOnly: cfg.IPFilterWritesOnly}
+	var err error
+	if f.Allow, err = s3.ParsePrefixes(cfg.AllowCIDRs); err != nil {
+		return f, fmt.Errorf("allow-cidrs: %w", err)
+	}
+	if f.Deny, err = s3.ParsePrefixes(cfg.DenyCIDRs); err != nil {
+		return f, fmt.Errorf("deny-cidrs: %w", err)
+	}
+	if f.TrustedProxies, err = s3.ParsePrefixes(cfg.TrustedProxies); err != nil {
+		return f, fmt.Errorf("trusted-proxies: %w", err)
+	}
+	log.Printf("[git3] ip filter allow=%v deny=%v trusted-proxies=%v writes-only=%v", f.Allow, f.Deny, f.TrustedProxies, f.WritesOnly)
+	return f, nil
+}
+
+// newCipher loads the encryption key, if one is configured.
+func newCipher(cfg Config) (*s3.Cipher, error) {
+	encoded := cfg.EncryptionKey
+	if cfg.EncryptionKeyFile != "" {
+		if encoded != "" {
+			return nil, errors.New("encryption: set either a key or a key file, not both")
+		}
+		data, err := os.ReadFile(cfg.EncryptionKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("encryption: %w", err)
+		}
+		encoded = string(data)
+	}
+	if encoded = strings.TrimSpace(encoded); encoded == "" {
+		return nil, nil
+	}
+	// LFS content and deduplicated blobs are kept by content, which
+	// encrypted objects don't share
+	if cfg.LFSPatterns != "" || cfg.LFSThreshold > 0 || cfg.Dedup {
+		return nil, errors.New("encryption cannot be combined with LFS or dedup")
+	}
+
+	decode := base64.StdEncoding.DecodeString
+	if len(encoded) == 64 {
+		decode = hex.DecodeString
+	}
+	key, err := decode(encoded)
+	if err != nil {
+		return nil, errors.New("encryption: key must be 32 bytes, hex or base64 encoded")
+	}
+	c, err := s3.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("encryption: %w", err)
+	}
+	log.Printf("[git3] encryption=aes-256-gcm")
+	return c, nil
+}
+
+// parseContentTypes parses comma-separated ext=type pairs. The leading dot
+// of an extension is optional.
+func parseContentTypes(s string) (map[string]string, error) {
+	types := make(map[string]string)
+	for _, item := range splitList(s) {
+		ext, t, ok := strings.Cut(item, "=")
+		ext, t = strings.TrimSpace(ext), strings.TrimSpace(t)
+		if !ok || ext == "" || t == "" {
+			return nil, fmt.Errorf("content-types: %q is not ext=type", item)
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		types[ext] = t
+	}
+	return types, nil
+}
+
+// vaultStats tells the handler which of its objects a pull changed, so
+// it updates its counts, and in a hashed layout reads its key index again.
+// Paths outside subdir are left out and the rest made relative to it.
+type vaultStats struct {
+	handler *s3.Handler
+	subdir  string
+}
+
+func (v *vaultStats) Changed(oldHash, newHash string, paths []string) {
+	if v.handler == nil {
+		return
+	}
+	prefix := strings.Trim(v.subdir, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	rel := make([]string, 0, len(paths))
+	for _, path := range paths {
+		if p, ok := strings.CutPrefix(path, prefix); ok {
+			rel = append(rel, p)
+		}
+	}
+	v.handler.PathsChanged(rel)
+}
+
+// changeNotifiers tells several notifiers about each pull.
+type changeNotifiers []git.ChangeNotifier
+
+func (ns changeNotifiers) Changed(oldHash, newHash string, paths []string) {
+	for _, n := range ns {
+		n.Changed(oldHash, newHash, paths)
+	}
+}
+
+func (ns changeNotifiers) Conflicted(hash string, paths, copies []string) {
+	for _, n := range ns {
+		if c, ok := n.(git.ConflictNotifier); ok {
+			c.Conflicted(hash, paths, copies)
+		}
+	}
+}
+
+// splitList splits a comma-separated setting, dropping empty entries.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
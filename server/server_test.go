@@ -0,0 +1,424 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"git3/internal/git"
+	"git3/internal/s3"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// sign adds an AWS SigV4 Authorization header covering host and the
+// x-amz-* headers, as S3 clients send it.
+func sign(r *http.Request, accessKey, secretKey, region string) {
+	signAt(r, time.Now(), accessKey, secretKey, region)
+}
+
+// signAt is sign with the client's clock at now.
+func signAt(r *http.Request, now time.Time, accessKey, secretKey, region string) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	dateStamp := now.Format("20060102")
+	r.Header.Set("X-Amz-Date", amzDate)
+	r.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonical := strings.Join([]string{
+		r.Method,
+		r.URL.EscapedPath(),
+		"",
+		"host:" + r.Host + "\nx-amz-content-sha256:UNSIGNED-PAYLOAD\nx-amz-date:" + amzDate + "\n",
+		signedHeaders,
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	scope := dateStamp + "/" + region + "/s3/aws4_request"
+	sum := sha256.Sum256([]byte(canonical))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+
+	key := []byte("AWS4" + secretKey)
+	for _, part := range []string{dateStamp, region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	r.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func TestServerPutAndShutdownFlushes(t *testing.T) {
+	dir := t.TempDir()
+	srv, err := New(Config{
+		Dir:       dir,
+		Addr:      "127.0.0.1:0",
+		AccessKey: "AKID",
+		SecretKey: "secret",
+		Debounce:  time.Hour, // only Shutdown can commit in time
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	url := "http://" + srv.Addr().String() + "/vault/notes/a.md"
+	req, _ := http.NewRequest("PUT", url, strings.NewReader("hello"))
+	sign(req, "AKID", "secret", "us-east-1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("signed PUT got %s", resp.Status)
+	}
+
+	unsigned, _ := http.NewRequest("PUT", url, strings.NewReader("x"))
+	if resp, err := http.DefaultClient.Do(unsigned); err == nil {
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Fatalf("unsigned PUT got %s, want 403", resp.Status)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	repo, err := gogit.PlainOpen(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, err := repo.Head()
+	if err != nil {
+		t.Fatalf("no commit after shutdown: %v", err)
+	}
+	commit, _ := repo.CommitObject(head.Hash())
+	if _, err := commit.File("notes/a.md"); err != nil {
+		t.Fatalf("pending PUT not committed on shutdown: %v", err)
+	}
+
+	if _, err := http.Get("http://" + srv.Addr().String() + "/vault"); err == nil {
+		t.Fatal("server still accepting connections after Shutdown")
+	}
+}
+
+func TestSubdirOutsideRepoRefused(t *testing.T) {
+	root := t.TempDir()
+	cfg := Config{Dir: filepath.Join(root, "vault"), Subdir: "../x"}
+
+	if _, err := New(cfg); !errors.Is(err, git.ErrSubdir) {
+		t.Fatalf("New = %v, want ErrSubdir", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "x")); !os.IsNotExist(err) {
+		t.Fatal("subdir created outside the repository")
+	}
+}
+
+func TestEncryptionRefusesPlaintextVault(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.md"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := Config{Dir: dir, EncryptionKey: strings.Repeat("ab", 32)}
+
+	if _, err := New(cfg); !errors.Is(err, s3.ErrNotEncrypted) {
+		t.Fatalf("New = %v, want ErrNotEncrypted", err)
+	}
+	cfg.EncryptionMigrate = true
+	if _, err := New(cfg); err != nil {
+		t.Fatalf("New with migration: %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "a.md")); strings.Contains(string(data), "hello") {
+		t.Fatal("object not encrypted by the migration")
+	}
+
+	cfg.Dedup = true
+	if _, err := New(cfg); err == nil {
+		t.Fatal("encryption combined with dedup")
+	}
+}
+
+func TestAuthFailureMetrics(t *testing.T) {
+	srv, err := New(Config{Dir: t.TempDir(), AccessKey: "AKID", SecretKey: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := srv.Handler()
+
+	tests := []struct {
+		reason string
+		auth   func(r *http.Request)
+	}{
+		{s3.AuthMissingHeader, func(r *http.Request) {}},
+		{s3.AuthBadPrefix, func(r *http.Request) { r.Header.Set("Authorization", "Bearer token") }},
+		{s3.AuthMalformed, func(r *http.Request) {
+			r.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential=AKID/20250101/us-east-1/s3/aws4_request")
+		}},
+		{s3.AuthUnknownKey, func(r *http.Request) { sign(r, "OTHER", "secret", "us-east-1") }},
+		{s3.AuthWrongRegion, func(r *http.Request) { sign(r, "AKID", "secret", "eu-west-1") }},
+		{s3.AuthSkewedDate, func(r *http.Request) { signAt(r, time.Now().Add(-time.Hour), "AKID", "secret", "us-east-1") }},
+		{s3.AuthUnsignedHeaders, func(r *http.Request) {
+			sign(r, "AKID", "secret", "us-east-1")
+			r.Header.Set("Authorization", strings.Replace(r.Header.Get("Authorization"),
+				"SignedHeaders=host;x-amz-content-sha256;x-amz-date", "SignedHeaders=host", 1))
+		}},
+		{s3.AuthAbsentHeader, func(r *http.Request) {
+			sign(r, "AKID", "secret", "us-east-1")
+			r.Header.Del("X-Amz-Content-Sha256")
+		}},
+		{s3.AuthBadSignature, func(r *http.Request) { sign(r, "AKID", "wrong", "us-east-1") }},
+	}
+	for i, tt := range tests {
+		req := httptest.NewRequest("GET", "/vault", nil)
+		tt.auth(req)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		status := http.StatusForbidden
+		switch tt.reason {
+		case s3.AuthWrongRegion, s3.AuthUnsignedHeaders:
+			// AuthorizationHeaderMalformed, as S3 answers
+			status = http.StatusBadRequest
+		}
+		if w.Code != status {
+			t.Fatalf("%s: got %d, want %d", tt.reason, w.Code, status)
+		}
+
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/-/metrics", nil))
+		for j, other := range tests {
+			want := 0
+			if j <= i {
+				want = 1
+			}
+			line := fmt.Sprintf("git3_auth_failures_total{reason=%q} %d\n", other.reason, want)
+			if !strings.Contains(w.Body.String(), line) {
+				t.Fatalf("after %s, metrics lack %q:\n%s", tt.reason, line, w.Body)
+			}
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/vault", nil)
+	sign(req, "AKID", "secret", "us-east-1")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("signed request got %d: %s", w.Code, w.Body)
+	}
+}
+
+// pushFile commits name to the repository at work and pushes it to main.
+func pushFile(t *testing.T, work *gogit.Repository, name, content string) {
+	t.Helper()
+	wt, _ := work.Worktree()
+	os.WriteFile(filepath.Join(wt.Filesystem.Root(), name), []byte(content), 0644)
+	wt.Add(name)
+	sig := &object.Signature{Name: "Test", Email: "test@test.com", When: time.Now()}
+	if _, err := wt.Commit("add "+name, &gogit.CommitOptions{Author: sig}); err != nil {
+		t.Fatal(err)
+	}
+	err := work.Push(&gogit.PushOptions{RefSpecs: []config.RefSpec{"refs/heads/main:refs/heads/main"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStartPullsBeforeServing(t *testing.T) {
+	remote := filepath.Join(t.TempDir(), "remote.git")
+	if _, err := gogit.PlainInit(remote, true); err != nil {
+		t.Fatal(err)
+	}
+	work, err := gogit.PlainInit(t.TempDir(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	work.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.NewBranchReferenceName("main")))
+	work.CreateRemote(&config.RemoteConfig{Name: "origin", URLs: []string{remote}})
+	pushFile(t, work, "a.md", "a")
+
+	dir := t.TempDir()
+	srv, err := New(Config{Dir: dir, Addr: "127.0.0.1:0", GitRepo: remote})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Pushed while the server was down, or before it came up
+	pushFile(t, work, "b.md", "b")
+	if err := srv.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Shutdown(context.Background())
+
+	if data, err := os.ReadFile(filepath.Join(dir, "b.md")); err != nil || string(data) != "b" {
+		t.Fatalf("b.md = %q, %v when serving began", data, err)
+	}
+}
+
+func TestReplicaPromotion(t *testing.T) {
+	remote := filepath.Join(t.TempDir(), "remote.git")
+	if _, err := gogit.PlainInit(remote, true); err != nil {
+		t.Fatal(err)
+	}
+	work, err := gogit.PlainInit(t.TempDir(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	work.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.NewBranchReferenceName("main")))
+	work.CreateRemote(&config.RemoteConfig{Name: "origin", URLs: []string{remote}})
+	pushFile(t, work, "a.md", "a")
+
+	srv, err := New(Config{Dir: t.TempDir(), Addr: "127.0.0.1:0", GitRepo: remote, AdminToken: "admin", Replica: true, Debounce: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Shutdown(context.Background())
+	base := "http://" + srv.Addr().String()
+
+	do := func(method, path, token string) int {
+		t.Helper()
+		req, _ := http.NewRequest(method, base+path, strings.NewReader("x"))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := do("GET", "/vault/a.md", ""); code != http.StatusOK {
+		t.Fatalf("replica GET got %d", code)
+	}
+	if code := do("PUT", "/vault/b.md", ""); code != http.StatusForbidden {
+		t.Fatalf("replica PUT got %d, want 403", code)
+	}
+	if st := srv.syncer.Status(); st.ReplicaOf != remote {
+		t.Fatalf("ReplicaOf = %q", st.ReplicaOf)
+	}
+
+	if code := do("POST", "/-/promote", "admin"); code != http.StatusOK {
+		t.Fatalf("promote got %d", code)
+	}
+	if code := do("PUT", "/vault/b.md", ""); code != http.StatusOK {
+		t.Fatalf("PUT after promotion got %d", code)
+	}
+	if result := srv.syncer.Sync(); result.Err != nil || !result.Pushed {
+		t.Fatalf("sync after promotion = %+v", result)
+	}
+}
+
+func TestObjectInfo(t *testing.T) {
+	srv, err := New(Config{Dir: t.TempDir(), Addr: "127.0.0.1:0", Debounce: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Shutdown(context.Background())
+	url := "http://" + srv.Addr().String() + "/vault/notes/a.md"
+
+	for _, content := range []string{"first", "second"} {
+		req, _ := http.NewRequest("PUT", url, strings.NewReader(content))
+		req.Header.Set("Content-Type", "text/markdown")
+		req.Header.Set("X-Amz-Meta-Author", "ann")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("PUT got %s", resp.Status)
+		}
+		if result := srv.syncer.Sync(); result.Err != nil {
+			t.Fatal(result.Err)
+		}
+	}
+
+	resp, err := http.Get(url + "?git3-meta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var info struct {
+		Size        int64
+		ETag        string
+		ContentType string
+		Metadata    map[string]string
+		Versions    int
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		t.Fatalf("%s: %v", resp.Status, err)
+	}
+	if resp.Header.Get("Content-Type") != "application/json" || info.ContentType != "text/markdown" || info.Versions != 2 ||
+		info.Size != int64(len("second")) || info.ETag == "" || info.Metadata["author"] != "ann" {
+		t.Fatalf("git3-meta = %s %+v", resp.Header.Get("Content-Type"), info)
+	}
+}
+
+func TestCommitOnStartup(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := gogit.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.NewBranchReferenceName("main")))
+	os.WriteFile(filepath.Join(dir, "a.md"), []byte("a"), 0644)
+	wt, _ := repo.Worktree()
+	wt.Add("a.md")
+	sig := &object.Signature{Name: "Test", Email: "test@test.com", When: time.Now()}
+	if _, err := wt.Commit("first", &gogit.CommitOptions{Author: sig}); err != nil {
+		t.Fatal(err)
+	}
+	// Written before an unclean shutdown, never committed
+	os.MkdirAll(filepath.Join(dir, "notes"), 0755)
+	os.WriteFile(filepath.Join(dir, "notes", "b.md"), []byte("b"), 0644)
+
+	srv, err := New(Config{Dir: dir, Addr: "127.0.0.1:0", CommitOnStartup: true, Debounce: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Shutdown(context.Background())
+
+	head, err := repo.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.File("notes/b.md"); err != nil || len(c.ParentHashes) != 1 {
+		t.Fatalf("head %q with parents %v lacks notes/b.md: %v", c.Message, c.ParentHashes, err)
+	}
+}